@@ -106,3 +106,28 @@ type ContractManager interface {
 
 	DisconnectBlock(block *asiutil.Block)
 }
+
+// ISigningKeyManager is implemented by contract managers which read the block
+// signing keys the validators delegate the signing of their blocks to.
+type ISigningKeyManager interface {
+	// Get the signing keys of the validators, the zero address for the
+	// validators which sign with their own key.
+	GetSigningKeys(
+		block *asiutil.Block,
+		stateDB vm.StateDB,
+		chainConfig *params.ChainConfig,
+		validators []common.Address) ([]common.Address, error)
+}
+
+// IEvidenceManager is implemented by contract managers which read the
+// misbehaviors reported against the validators.
+type IEvidenceManager interface {
+	// Get the height of the block which recorded the last misbehavior
+	// reported against each validator, zero for the validators which never
+	// misbehaved.
+	GetMisbehaviorHeights(
+		block *asiutil.Block,
+		stateDB vm.StateDB,
+		chainConfig *params.ChainConfig,
+		validators []common.Address) ([]int32, error)
+}
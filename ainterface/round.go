@@ -44,6 +44,20 @@ type IRoundManager interface {
     GetValidators(blockHash common.Hash, round uint32, fn GetValidatorsCallBack) ([]*common.Address, map[common.Address]uint16, error)
 }
 
+// IRoundContract is implemented by round managers whose consensus contract
+// depends on the round, e.g. when the consensus engine changes at a scheduled
+// round.
+type IRoundContract interface {
+    GetContractByRound(round uint32) common.Address
+}
+
+// IVrfRoundManager is implemented by round managers which select the
+// validators of a round with the seed derived from the vrf outputs of the
+// blocks of the previous round.
+type IVrfRoundManager interface {
+    GetValidatorsBySeed(blockHash common.Hash, seed common.Hash, round uint32, fn GetValidatorsCallBack) ([]*common.Address, map[common.Address]uint16, error)
+}
+
 type Round struct {
     Round         uint32
     RoundStartUnix int64
@@ -6,6 +6,8 @@ package main
 
 import (
 	"fmt"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/blockchain/indexers"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/database/dbdriver"
@@ -126,6 +128,29 @@ func flowdMain(serverChan chan<- *servers.NodeServer) error {
 		db.Close()
 	}()
 
+	// Return now if an interrupt signal was triggered.
+	if interruptRequested(interrupt) {
+		return nil
+	}
+
+	// Drop the balance index and exit if requested.
+	if cfg.DropBalanceIndex {
+		if err := indexers.DropBalanceIndex(db, interrupt); err != nil {
+			mainLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+	if cfg.DropAssetIndex {
+		if err := indexers.DropAssetIndex(db, interrupt); err != nil {
+			mainLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+
 	// Load StateDB
 	stateDB, err := ethdb.NewLDBDatabase(cfg.StateDir, 768, 1024)
 	if err != nil {
@@ -143,6 +168,48 @@ func flowdMain(serverChan chan<- *servers.NodeServer) error {
 		return nil
 	}
 
+	// Prune the state database and exit if requested.
+	if cfg.PruneState {
+		var checkpoints []chaincfg.Checkpoint
+		if !cfg.DisableCheckpoints {
+			checkpoints = servers.MergeCheckpoints(
+				chaincfg.ActiveNetParams.Checkpoints, cfg.AddCheckpoints)
+		}
+		err := blockchain.PruneState(db, stateDB, checkpoints, cfg.StateRetain,
+			interrupt)
+		if err != nil {
+			mainLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+
+	// Export or import a snapshot and exit if requested.
+	if cfg.ExportSnapshot != "" {
+		if err := exportSnapshot(db, stateDB, cfg.ExportSnapshot); err != nil {
+			mainLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+	if cfg.ImportSnapshot != "" {
+		var checkpoints []chaincfg.Checkpoint
+		if !cfg.DisableCheckpoints {
+			checkpoints = servers.MergeCheckpoints(
+				chaincfg.ActiveNetParams.Checkpoints, cfg.AddCheckpoints)
+		}
+		err := importSnapshot(db, stateDB, cfg.ImportSnapshot, checkpoints,
+			cfg.StateRetain, interrupt)
+		if err != nil {
+			mainLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+
 	// Create server and start it.
 	server, err := servers.NewServer(db, stateDB, cfg.AgentBlacklist,
 		cfg.AgentWhitelist, chaincfg.ActiveNetParams.Params, interrupt, shutdownRequestChannel)
@@ -4,6 +4,8 @@
 package bloom
 
 import (
+	"errors"
+
 	"github.com/AsimovNetwork/asimov/asiutil"
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/common"
@@ -79,6 +81,26 @@ func (m *merkleBlock) traverseAndBuild(height, pos uint32) {
 // NewMerkleBlock returns a new *protos.MsgMerkleBlock and an array of the matched
 // transaction index numbers based on the passed block and filter.
 func NewMerkleBlock(block *asiutil.Block, filter *Filter) (*protos.MsgMerkleBlock, []uint32) {
+	return newMerkleBlock(block, filter.MatchTxAndUpdate)
+}
+
+// NewMerkleBlockWithTxs returns a new *protos.MsgMerkleBlock which proves the
+// inclusion of the transactions with the passed hashes in the block and an
+// array of their index numbers.
+func NewMerkleBlockWithTxs(block *asiutil.Block, txHashes []*common.Hash) (*protos.MsgMerkleBlock, []uint32) {
+	wanted := make(map[common.Hash]struct{}, len(txHashes))
+	for _, hash := range txHashes {
+		wanted[*hash] = struct{}{}
+	}
+	return newMerkleBlock(block, func(tx *asiutil.Tx) bool {
+		_, ok := wanted[*tx.Hash()]
+		return ok
+	})
+}
+
+// newMerkleBlock builds the merkle block of the transactions of the block
+// which satisfy the passed match function.
+func newMerkleBlock(block *asiutil.Block, match func(tx *asiutil.Tx) bool) (*protos.MsgMerkleBlock, []uint32) {
 	numTx := uint32(len(block.Transactions()))
 	mBlock := merkleBlock{
 		numTx:       numTx,
@@ -89,7 +111,7 @@ func NewMerkleBlock(block *asiutil.Block, filter *Filter) (*protos.MsgMerkleBloc
 	// Find and keep track of any transactions that match the filter.
 	var matchedIndices []uint32
 	for txIndex, tx := range block.Transactions() {
-		if filter.MatchTxAndUpdate(tx) {
+		if match(tx) {
 			mBlock.matchedBits = append(mBlock.matchedBits, 0x01)
 			matchedIndices = append(matchedIndices, uint32(txIndex))
 		} else {
@@ -122,3 +144,100 @@ func NewMerkleBlock(block *asiutil.Block, filter *Filter) (*protos.MsgMerkleBloc
 	}
 	return &msgMerkleBlock, matchedIndices
 }
+
+// partialTree is used to walk the partial merkle tree of a
+// protos.MsgMerkleBlock.
+type partialTree struct {
+	numTx      uint32
+	hashes     []*common.Hash
+	flags      []byte
+	hashesUsed int
+	bitsUsed   int
+	matches    []*common.Hash
+	indices    []uint32
+}
+
+// calcTreeWidth calculates and returns the the number of nodes (width) or a
+// merkle tree at the given depth-first height.
+func (p *partialTree) calcTreeWidth(height uint32) uint32 {
+	return (p.numTx + (1 << height) - 1) >> height
+}
+
+// traverseAndExtract rebuilds the hash of the sub-tree at the passed
+// depth-first height and position while collecting the matched leaves.
+func (p *partialTree) traverseAndExtract(height, pos uint32) (*common.Hash, error) {
+	if p.bitsUsed >= len(p.flags)*8 {
+		return nil, errors.New("merkle block flags overflowed")
+	}
+	isParent := (p.flags[p.bitsUsed/8] >> uint(p.bitsUsed%8)) & 0x01
+	p.bitsUsed++
+
+	if height == 0 || isParent == 0x00 {
+		if p.hashesUsed >= len(p.hashes) {
+			return nil, errors.New("merkle block hashes overflowed")
+		}
+		hash := p.hashes[p.hashesUsed]
+		p.hashesUsed++
+		if height == 0 && isParent == 0x01 {
+			p.matches = append(p.matches, hash)
+			p.indices = append(p.indices, pos)
+		}
+		return hash, nil
+	}
+
+	left, err := p.traverseAndExtract(height-1, pos*2)
+	if err != nil {
+		return nil, err
+	}
+	right := left
+	if pos*2+1 < p.calcTreeWidth(height-1) {
+		right, err = p.traverseAndExtract(height-1, pos*2+1)
+		if err != nil {
+			return nil, err
+		}
+		// Identical siblings allow to forge the tree, see CVE-2012-2459.
+		if *right == *left {
+			return nil, errors.New("merkle block contains identical siblings")
+		}
+	}
+	return blockchain.HashMerkleBranches(left, right), nil
+}
+
+// ExtractMerkleBlockMatches walks the partial merkle tree of the passed merkle
+// block.  It returns the merkle root the tree commits to along with the hashes
+// and the index numbers of the transactions it proves.  The caller must
+// compare the returned root with the merkle root of a trusted header.
+func ExtractMerkleBlockMatches(msg *protos.MsgMerkleBlock) (*common.Hash, []*common.Hash, []uint32, error) {
+	if msg.Transactions == 0 {
+		return nil, nil, nil, errors.New("merkle block has no transactions")
+	}
+	if uint32(len(msg.Hashes)) > msg.Transactions {
+		return nil, nil, nil, errors.New("merkle block has more hashes than transactions")
+	}
+	if len(msg.Flags)*8 < len(msg.Hashes) {
+		return nil, nil, nil, errors.New("merkle block has fewer flags than hashes")
+	}
+
+	tree := partialTree{
+		numTx:  msg.Transactions,
+		hashes: msg.Hashes,
+		flags:  msg.Flags,
+	}
+	height := uint32(0)
+	for tree.calcTreeWidth(height) > 1 {
+		height++
+	}
+	root, err := tree.traverseAndExtract(height, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Every hash and every flag byte must have been consumed.
+	if tree.hashesUsed != len(msg.Hashes) {
+		return nil, nil, nil, errors.New("merkle block has unused hashes")
+	}
+	if (tree.bitsUsed+7)/8 != len(msg.Flags) {
+		return nil, nil, nil, errors.New("merkle block has unused flags")
+	}
+	return root, tree.matches, tree.indices, nil
+}
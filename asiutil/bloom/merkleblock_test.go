@@ -0,0 +1,66 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package bloom_test
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/asiutil/bloom"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// TestMerkleBlockWithTxs ensures the partial merkle tree built for a set of
+// transactions commits to the merkle root of the block and proves exactly
+// those transactions.
+func TestMerkleBlockWithTxs(t *testing.T) {
+	msgBlock := protos.MsgBlock{}
+	for i := 0; i < 5; i++ {
+		tx := protos.NewMsgTx(protos.TxVersion)
+		tx.AddTxIn(&protos.TxIn{PreviousOutPoint: protos.OutPoint{Index: uint32(i)}})
+		msgBlock.AddTransaction(tx)
+	}
+	block := asiutil.NewBlock(&msgBlock)
+	txs := block.Transactions()
+	merkles := blockchain.BuildMerkleTreeStore(txs)
+	wantRoot := merkles[len(merkles)-1]
+
+	merkleBlock, indices := bloom.NewMerkleBlockWithTxs(block,
+		[]*common.Hash{txs[1].Hash(), txs[4].Hash()})
+	if len(indices) != 2 || indices[0] != 1 || indices[1] != 4 {
+		t.Fatalf("unexpected matched indices %v", indices)
+	}
+
+	root, matches, matchIndices, err := bloom.ExtractMerkleBlockMatches(merkleBlock)
+	if err != nil {
+		t.Fatalf("ExtractMerkleBlockMatches: unexpected error: %v", err)
+	}
+	if *root != *wantRoot {
+		t.Errorf("unexpected merkle root - got %v, want %v", root, wantRoot)
+	}
+	if len(matches) != 2 || *matches[0] != *txs[1].Hash() ||
+		*matches[1] != *txs[4].Hash() {
+		t.Errorf("unexpected matches %v", matches)
+	}
+	if len(matchIndices) != 2 || matchIndices[0] != 1 || matchIndices[1] != 4 {
+		t.Errorf("unexpected match indices %v", matchIndices)
+	}
+
+	// Tampering with a hash must change the root.
+	tampered := *merkleBlock
+	tampered.Hashes = append([]*common.Hash{}, merkleBlock.Hashes...)
+	tampered.Hashes[0] = &common.Hash{0x01}
+	root, _, _, err = bloom.ExtractMerkleBlockMatches(&tampered)
+	if err == nil && *root == *wantRoot {
+		t.Errorf("tampered merkle block still commits to the merkle root")
+	}
+
+	// Extra hashes must be rejected.
+	tampered.Hashes = append(merkleBlock.Hashes, &common.Hash{})
+	if _, _, _, err = bloom.ExtractMerkleBlockMatches(&tampered); err == nil {
+		t.Errorf("merkle block with unused hashes was accepted")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package clock provides the time the chain rules and the consensus services
+// run on.  It is the system clock, except in tests which replace it with a
+// simulated clock to drive the slots of the validators without waiting for
+// them.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Timer is a single event timer, like a time.Timer.
+type Timer interface {
+	// C returns the channel the time is sent on when the timer expires.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing.  It returns false when the
+	// timer already expired or was stopped.
+	Stop() bool
+
+	// Reset changes the timer to expire after the duration.  It returns
+	// true when the timer was active.
+	Reset(d time.Duration) bool
+}
+
+// Clock tells the time and creates timers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTimer creates a timer which sends the current time on its
+	// channel after the duration.
+	NewTimer(d time.Duration) Timer
+}
+
+// systemTimer implements the Timer interface with a time.Timer.
+type systemTimer struct {
+	*time.Timer
+}
+
+// C returns the channel of the timer.
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// systemClock implements the Clock interface with the system clock.
+type systemClock struct{}
+
+// Now returns the current local time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer creates a time.Timer.
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+// System is the clock of the operating system.
+var System Clock = systemClock{}
+
+var (
+	clockLock sync.RWMutex
+	current   = System
+)
+
+// Set replaces the clock of the process.  A nil clock restores the system
+// clock.  It is meant to be called by tests before the services using the
+// clock are started.
+func Set(c Clock) {
+	if c == nil {
+		c = System
+	}
+	clockLock.Lock()
+	current = c
+	clockLock.Unlock()
+}
+
+// Get returns the clock of the process.
+func Get() Clock {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return current
+}
+
+// Now returns the current time of the clock of the process.
+func Now() time.Time {
+	return Get().Now()
+}
+
+// NewTimer creates a timer of the clock of the process.
+func NewTimer(d time.Duration) Timer {
+	return Get().NewTimer(d)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// received returns the time sent on the channel of the timer, and false when
+// the timer did not fire.
+func received(t Timer) (time.Time, bool) {
+	select {
+	case now := <-t.C():
+		return now, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// TestSimulated ensures the timers of a simulated clock fire in the order of
+// their deadlines when the clock is advanced, and only then.
+func TestSimulated(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	c := NewSimulated(start)
+
+	late := c.NewTimer(3 * time.Second)
+	early := c.NewTimer(time.Second)
+	stopped := c.NewTimer(2 * time.Second)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Errorf("Stop: an active timer is not reported active once")
+	}
+	if next, ok := c.Next(); !ok || !next.Equal(start.Add(time.Second)) {
+		t.Errorf("Next: got %v, want %v", next, start.Add(time.Second))
+	}
+
+	c.Advance(500 * time.Millisecond)
+	if _, ok := received(early); ok {
+		t.Fatalf("Advance: a timer fired before its deadline")
+	}
+	c.Advance(2 * time.Second)
+	if now, ok := received(early); !ok || !now.Equal(start.Add(time.Second)) {
+		t.Errorf("Advance: got %v, %v, want the deadline of the timer", now, ok)
+	}
+	if _, ok := received(stopped); ok {
+		t.Errorf("Advance: a stopped timer fired")
+	}
+	if _, ok := received(late); ok {
+		t.Errorf("Advance: a timer fired before its deadline")
+	}
+	if !c.Now().Equal(start.Add(2500 * time.Millisecond)) {
+		t.Errorf("Now: got %v", c.Now())
+	}
+
+	// Reset moves the deadline and a negative duration fires at once.
+	if !late.Reset(time.Second) {
+		t.Errorf("Reset: an active timer is reported inactive")
+	}
+	c.Advance(900 * time.Millisecond)
+	if _, ok := received(late); ok {
+		t.Errorf("Reset: the timer fired at its former deadline")
+	}
+	early.Reset(-time.Second)
+	if _, ok := received(early); !ok {
+		t.Errorf("Reset: an expired timer did not fire at once")
+	}
+	c.Advance(100 * time.Millisecond)
+	if _, ok := received(late); !ok {
+		t.Errorf("Reset: the timer did not fire at its new deadline")
+	}
+	if c.Pending() != 0 {
+		t.Errorf("Pending: got %d timers, want none", c.Pending())
+	}
+}
+
+// TestSet ensures the clock of the process can be replaced and restored.
+func TestSet(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	Set(NewSimulated(start))
+	defer Set(nil)
+	if !Now().Equal(start) {
+		t.Errorf("Now: got %v, want the simulated time", Now())
+	}
+	Set(nil)
+	if Get() != System {
+		t.Errorf("Set: the system clock is not restored")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Simulated implements the Clock interface with a clock which only moves
+// when it is advanced.  Its timers fire in the order of their deadlines
+// while it is advanced, so the services using it run the same way whatever
+// the speed of the machine.
+type Simulated struct {
+	mtx    sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers []*simTimer
+}
+
+// simTimer is a timer of a simulated clock.
+type simTimer struct {
+	clock    *Simulated
+	c        chan time.Time
+	deadline time.Time
+	seq      uint64
+	active   bool
+}
+
+// Ensure the Simulated type implements the Clock interface.
+var _ Clock = (*Simulated)(nil)
+
+// NewSimulated returns a simulated clock starting at the given time.
+func NewSimulated(start time.Time) *Simulated {
+	return &Simulated{now: start}
+}
+
+// Now returns the current time of the clock.
+func (s *Simulated) Now() time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.now
+}
+
+// NewTimer creates a timer firing once the clock is advanced by the
+// duration.
+func (s *Simulated) NewTimer(d time.Duration) Timer {
+	t := &simTimer{
+		clock: s,
+		c:     make(chan time.Time, 1),
+	}
+	s.mtx.Lock()
+	s.schedule(t, d)
+	s.mtx.Unlock()
+	return t
+}
+
+// schedule arms the timer to fire after the duration, or fires it at once
+// when the duration is not positive.  It must be called with the lock held.
+func (s *Simulated) schedule(t *simTimer, d time.Duration) {
+	if d <= 0 {
+		t.fire(s.now)
+		return
+	}
+	s.seq++
+	t.deadline = s.now.Add(d)
+	t.seq = s.seq
+	t.active = true
+	s.timers = append(s.timers, t)
+	sort.Slice(s.timers, func(i, j int) bool {
+		if s.timers[i].deadline.Equal(s.timers[j].deadline) {
+			return s.timers[i].seq < s.timers[j].seq
+		}
+		return s.timers[i].deadline.Before(s.timers[j].deadline)
+	})
+}
+
+// unschedule disarms the timer.  It must be called with the lock held.
+func (s *Simulated) unschedule(t *simTimer) bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, timer := range s.timers {
+		if timer == t {
+			s.timers = append(s.timers[:i], s.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Next returns the deadline of the next timer to fire, and false when no
+// timer is active.
+func (s *Simulated) Next() (time.Time, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.timers) == 0 {
+		return time.Time{}, false
+	}
+	return s.timers[0].deadline, true
+}
+
+// Pending returns the number of active timers.
+func (s *Simulated) Pending() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.timers)
+}
+
+// Advance moves the clock forward by the duration, firing the timers which
+// expire meanwhile in the order of their deadlines.
+func (s *Simulated) Advance(d time.Duration) {
+	s.AdvanceTo(s.Now().Add(d))
+}
+
+// AdvanceTo moves the clock forward to the given time, firing the timers
+// which expire meanwhile in the order of their deadlines.  The clock never
+// moves backward.
+func (s *Simulated) AdvanceTo(end time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for len(s.timers) > 0 && !s.timers[0].deadline.After(end) {
+		t := s.timers[0]
+		s.timers = s.timers[1:]
+		t.active = false
+		s.now = t.deadline
+		t.fire(s.now)
+	}
+	if end.After(s.now) {
+		s.now = end
+	}
+}
+
+// fire sends the time on the channel of the timer, unless a previous time
+// was not received yet.
+func (t *simTimer) fire(now time.Time) {
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+// C returns the channel of the timer.
+func (t *simTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop prevents the timer from firing.
+func (t *simTimer) Stop() bool {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	return t.clock.unschedule(t)
+}
+
+// Reset changes the timer to expire once the clock is advanced by the
+// duration.
+func (t *simTimer) Reset(d time.Duration) bool {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	active := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return active
+}
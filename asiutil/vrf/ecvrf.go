@@ -0,0 +1,219 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package vrf
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+)
+
+// This file implements the elliptic curve verifiable random function
+// ECVRF-SECP256K1-SHA256-TAI, following draft-irtf-cfrg-vrf-10 with the
+// secp256k1 curve of the validator keys: the hash to curve uses the try and
+// increment method and the nonce is generated as in RFC 6979.
+
+const (
+	// ProofSize is the size of a serialized proof: the compressed point
+	// Gamma, the 16 bytes challenge c and the 32 bytes scalar s.
+	ProofSize = 33 + 16 + 32
+
+	// suiteString identifies the ECVRF-SECP256K1-SHA256-TAI cipher suite in
+	// the hashes.
+	suiteString = 0xfe
+)
+
+var (
+	// ErrInvalidProof describes a proof which is malformed or was not
+	// computed with the private key of the public key and the input.
+	ErrInvalidProof = errors.New("invalid vrf proof")
+
+	// ErrHashToCurve describes an input which can not be hashed to a point,
+	// which happens with a negligible probability.
+	ErrHashToCurve = errors.New("vrf input can not be hashed to the curve")
+)
+
+// Prove returns the proof and the output of the verifiable random function
+// for the private key and the input alpha.
+func Prove(priv *crypto.PrivateKey, alpha []byte) ([]byte, common.Hash, error) {
+	curve := crypto.S256()
+	x := priv.D
+	pub := priv.PubKey()
+
+	hx, hy, err := hashToCurve(pub, alpha)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	gx, gy := curve.ScalarMult(hx, hy, x.Bytes())
+	hString := pointToString(hx, hy)
+	k := nonce(x, hString)
+	ux, uy := curve.ScalarBaseMult(k.Bytes())
+	vx, vy := curve.ScalarMult(hx, hy, k.Bytes())
+	c := hashPoints(hString, pointToString(gx, gy), pointToString(ux, uy),
+		pointToString(vx, vy))
+
+	s := new(big.Int).Mul(c, x)
+	s.Add(s, k)
+	s.Mod(s, curve.N)
+
+	proof := make([]byte, 0, ProofSize)
+	proof = append(proof, pointToString(gx, gy)...)
+	proof = append(proof, intToString(c, 16)...)
+	proof = append(proof, intToString(s, 32)...)
+	return proof, proofToHash(gx, gy), nil
+}
+
+// Verify checks the proof of the verifiable random function for the public
+// key and the input alpha, and returns the output it proves.
+func Verify(pub *crypto.PublicKey, alpha []byte, proof []byte) (common.Hash, error) {
+	curve := crypto.S256()
+	gx, gy, c, s, err := decodeProof(proof)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	hx, hy, err := hashToCurve(pub, alpha)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	// U = s*B - c*Y, V = s*H - c*Gamma
+	negC := new(big.Int).Sub(curve.N, c).Bytes()
+	ux, uy := curve.ScalarBaseMult(s.Bytes())
+	cyx, cyy := curve.ScalarMult(pub.X, pub.Y, negC)
+	ux, uy = curve.Add(ux, uy, cyx, cyy)
+	vx, vy := curve.ScalarMult(hx, hy, s.Bytes())
+	cgx, cgy := curve.ScalarMult(gx, gy, negC)
+	vx, vy = curve.Add(vx, vy, cgx, cgy)
+
+	expected := hashPoints(pointToString(hx, hy), pointToString(gx, gy),
+		pointToString(ux, uy), pointToString(vx, vy))
+	if expected.Cmp(c) != 0 {
+		return common.Hash{}, ErrInvalidProof
+	}
+	return proofToHash(gx, gy), nil
+}
+
+// ProofToHash returns the output of a proof without verifying it.
+func ProofToHash(proof []byte) (common.Hash, error) {
+	gx, gy, _, _, err := decodeProof(proof)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return proofToHash(gx, gy), nil
+}
+
+// decodeProof splits a proof into the point Gamma, the challenge c and the
+// scalar s.
+func decodeProof(proof []byte) (*big.Int, *big.Int, *big.Int, *big.Int, error) {
+	if len(proof) != ProofSize {
+		return nil, nil, nil, nil, ErrInvalidProof
+	}
+	gamma, err := crypto.ParsePubKey(proof[:33], crypto.S256())
+	if err != nil {
+		return nil, nil, nil, nil, ErrInvalidProof
+	}
+	c := new(big.Int).SetBytes(proof[33:49])
+	s := new(big.Int).SetBytes(proof[49:])
+	if s.Cmp(crypto.S256().N) >= 0 {
+		return nil, nil, nil, nil, ErrInvalidProof
+	}
+	return gamma.X, gamma.Y, c, s, nil
+}
+
+// hashToCurve hashes the public key and the input to a point of the curve
+// with the try and increment method.
+func hashToCurve(pub *crypto.PublicKey, alpha []byte) (*big.Int, *big.Int, error) {
+	pk := pub.SerializeCompressed()
+	for ctr := 0; ctr < 256; ctr++ {
+		h := sha256.New()
+		h.Write([]byte{suiteString, 0x01})
+		h.Write(pk)
+		h.Write(alpha)
+		h.Write([]byte{byte(ctr), 0x00})
+		candidate := append([]byte{0x02}, h.Sum(nil)...)
+		point, err := crypto.ParsePubKey(candidate, crypto.S256())
+		if err == nil {
+			return point.X, point.Y, nil
+		}
+	}
+	return nil, nil, ErrHashToCurve
+}
+
+// hashPoints returns the challenge of the points: the first 16 bytes of their
+// hash.
+func hashPoints(points ...[]byte) *big.Int {
+	h := sha256.New()
+	h.Write([]byte{suiteString, 0x02})
+	for _, point := range points {
+		h.Write(point)
+	}
+	h.Write([]byte{0x00})
+	return new(big.Int).SetBytes(h.Sum(nil)[:16])
+}
+
+// proofToHash returns the output of the proof with the point Gamma.
+func proofToHash(gx, gy *big.Int) common.Hash {
+	h := sha256.New()
+	h.Write([]byte{suiteString, 0x03})
+	h.Write(pointToString(gx, gy))
+	h.Write([]byte{0x00})
+	var output common.Hash
+	copy(output[:], h.Sum(nil))
+	return output
+}
+
+// nonce generates the nonce of a proof deterministically from the private
+// key and the point H, as described in RFC 6979 with SHA-256.
+func nonce(x *big.Int, hString []byte) *big.Int {
+	n := crypto.S256().N
+	h1 := sha256.Sum256(hString)
+	z := new(big.Int).SetBytes(h1[:])
+	z.Mod(z, n)
+	bx := append(intToString(x, 32), intToString(z, 32)...)
+
+	v := bytes.Repeat([]byte{0x01}, sha256.Size)
+	k := make([]byte, sha256.Size)
+	k = hmacSHA256(k, v, []byte{0x00}, bx)
+	v = hmacSHA256(k, v)
+	k = hmacSHA256(k, v, []byte{0x01}, bx)
+	v = hmacSHA256(k, v)
+	for {
+		v = hmacSHA256(k, v)
+		t := new(big.Int).SetBytes(v)
+		if t.Sign() > 0 && t.Cmp(n) < 0 {
+			return t
+		}
+		k = hmacSHA256(k, v, []byte{0x00})
+		v = hmacSHA256(k, v)
+	}
+}
+
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// pointToString returns the compressed encoding of a point.
+func pointToString(x, y *big.Int) []byte {
+	buf := make([]byte, 33)
+	buf[0] = 0x02 | byte(y.Bit(0))
+	copy(buf[1:], intToString(x, 32))
+	return buf
+}
+
+// intToString returns the big endian encoding of an integer on n bytes.
+func intToString(i *big.Int, n int) []byte {
+	buf := make([]byte, n)
+	b := i.Bytes()
+	copy(buf[n-len(b):], b)
+	return buf
+}
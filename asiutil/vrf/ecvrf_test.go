@@ -0,0 +1,58 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package vrf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/crypto"
+)
+
+func TestProveVerify(t *testing.T) {
+	priv, _ := crypto.PrivKeyFromBytes(crypto.S256(), bytes.Repeat([]byte{0x11}, 32))
+	other, _ := crypto.PrivKeyFromBytes(crypto.S256(), bytes.Repeat([]byte{0x22}, 32))
+	alpha := []byte("sample")
+
+	proof, output, err := Prove(priv, alpha)
+	if err != nil {
+		t.Fatalf("Prove error %v", err)
+	}
+	if len(proof) != ProofSize {
+		t.Fatalf("Prove: got a proof of %d bytes", len(proof))
+	}
+	again, _, _ := Prove(priv, alpha)
+	if !bytes.Equal(proof, again) {
+		t.Errorf("Prove: proofs of the same input differ")
+	}
+	got, err := Verify(priv.PubKey(), alpha, proof)
+	if err != nil || got != output {
+		t.Fatalf("Verify: got %x, %v want %x", got, err, output)
+	}
+	if hash, _ := ProofToHash(proof); hash != output {
+		t.Errorf("ProofToHash: got %x want %x", hash, output)
+	}
+
+	if _, err := Verify(other.PubKey(), alpha, proof); err != ErrInvalidProof {
+		t.Errorf("Verify: a proof is accepted for another key")
+	}
+	if _, err := Verify(priv.PubKey(), []byte("other"), proof); err != ErrInvalidProof {
+		t.Errorf("Verify: a proof is accepted for another input")
+	}
+	for _, i := range []int{0, 20, 40, 70} {
+		tampered := append([]byte(nil), proof...)
+		tampered[i] ^= 1
+		if _, err := Verify(priv.PubKey(), alpha, tampered); err != ErrInvalidProof {
+			t.Errorf("Verify: a proof tampered at byte %d is accepted", i)
+		}
+	}
+	if _, err := Verify(priv.PubKey(), alpha, proof[1:]); err != ErrInvalidProof {
+		t.Errorf("Verify: a truncated proof is accepted")
+	}
+
+	_, otherOutput, _ := Prove(other, alpha)
+	if otherOutput == output {
+		t.Errorf("Prove: distinct keys have the same output")
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package vrf
+
+import (
+	"encoding/binary"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// BlockAlpha returns the input of the proof published by the producer of the
+// block at the round and the slot.  It chains the output of the parent
+// block, so the producer can only publish or withhold its block but can not
+// choose its output.
+func BlockAlpha(parentOutput common.Hash, round uint32, slot uint16) []byte {
+	alpha := make([]byte, common.HashLength+6)
+	copy(alpha, parentOutput[:])
+	binary.BigEndian.PutUint32(alpha[common.HashLength:], round)
+	binary.BigEndian.PutUint16(alpha[common.HashLength+4:], slot)
+	return alpha
+}
+
+// RoundSeed returns the seed selecting the validators of the round from the
+// verified outputs of the blocks of the previous round, in chain order.
+func RoundSeed(round uint32, outputs []common.Hash) common.Hash {
+	buf := make([]byte, 4, 4+len(outputs)*common.HashLength)
+	binary.BigEndian.PutUint32(buf, round)
+	for _, output := range outputs {
+		buf = append(buf, output[:]...)
+	}
+	return common.DoubleHashH(buf)
+}
@@ -7,6 +7,7 @@ package blockchain
 import (
 	"fmt"
 	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/asiutil/vrf"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
@@ -37,6 +38,12 @@ func (b *BlockChain) maybeAcceptBlock(block *asiutil.Block, vblock *asiutil.VBlo
 		return false, ruleError(ErrInvalidAncestorBlock, str)
 	}
 
+	// Blocks which fork the main chain before the finalized block can
+	// never become part of the main chain, even when added fast.
+	if err := b.checkFinalizedFork(prevNode); err != nil {
+		return false, err
+	}
+
 	fastAdd := flags&common.BFFastAdd == common.BFFastAdd
 
 	var err error
@@ -89,6 +96,15 @@ func (b *BlockChain) maybeAcceptBlock(block *asiutil.Block, vblock *asiutil.VBlo
 	if newNode.round.Round != prevNode.round.Round {
 		newNode.status |= statusFirstInRound
 	}
+	if proof := block.MsgBlock().VrfProof; len(proof) > 0 {
+		// The proof was verified with the block context, unless the block
+		// is added fast.
+		newNode.vrfOutput, err = vrf.ProofToHash(proof)
+		if err != nil {
+			return false, ruleError(ErrBadVrfProof, err.Error())
+		}
+		newNode.status |= statusVrfOutput
+	}
 
 	b.index.AddNode(newNode)
 	err = b.index.flushToDB()
@@ -109,6 +125,7 @@ func (b *BlockChain) maybeAcceptBlock(block *asiutil.Block, vblock *asiutil.VBlo
 	// inventory to other peers.
 	b.chainLock.Unlock()
 	b.sendNotification(NTBlockAccepted, block)
+	b.detectEvidence(block)
 	b.chainLock.Lock()
 
 	return isMainChain, nil
@@ -35,6 +35,10 @@ const (
 	// round.
 	statusFirstInRound
 
+	// statusVrfOutput indicates that the block carries a vrf proof, whose
+	// output is stored with the node.
+	statusVrfOutput
+
 	// statusNone indicates that the block has no validation state flags set.
 	//
 	// NOTE: This must be defined last in order to avoid influencing iota.
@@ -107,6 +111,10 @@ type blockNode struct {
 
 	weight  uint64
 	poaHash common.Hash
+
+	// vrfOutput is the output of the vrf proof of the block, or the zero
+	// hash when the block carries no proof.
+	vrfOutput common.Hash
 }
 
 // initBlockNode initializes a block node from the given header and parent node,
@@ -276,6 +284,12 @@ func (node *blockNode) GasLimit() uint64 {
 	return node.gasLimit
 }
 
+// VrfOutput returns the output of the vrf proof of the block, or the zero
+// hash when the block carries no proof.
+func (node *blockNode) VrfOutput() common.Hash {
+	return node.vrfOutput
+}
+
 // blockIndex provides facilities for keeping track of an in-memory index of the
 // block chain.  Although the name block chain suggests a single chain of
 // blocks, it is actually a tree-shaped structure where any node can have
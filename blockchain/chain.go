@@ -5,6 +5,7 @@
 package blockchain
 
 import (
+	"bytes"
 	"container/list"
 	"encoding/binary"
 	"errors"
@@ -225,6 +226,35 @@ type BlockChain struct {
 	vmConfig vm.Config
 
 	feesChan chan interface{}
+
+	// stateRetain is the number of recent states kept when pruning, zero
+	// when every state is kept.  statePruned is the height up to which the
+	// states are pruned, -1 when none is.
+	stateRetain int32
+	statePruned int32
+
+	// snapshotBase is the block the chain was bootstrapped from with a
+	// snapshot, nil when it was synced from genesis.
+	snapshotBase *blockNode
+
+	// evidence keeps the recent headers and signatures of the validators
+	// to detect misbehavior.
+	evidence *evidencePool
+
+	// finalized is the last finalized block, the main chain never
+	// reorganizes below it.  blockSigners records the proposer and the
+	// signers of the recent main chain blocks to find the ones becoming
+	// final.
+	finalized    *blockNode
+	blockSigners map[common.Hash]map[common.Address]struct{}
+
+	// signingKeys caches the keys the validators of the recent rounds
+	// delegated the signing of their blocks to.
+	signingKeys signingKeyCache
+
+	// limitedAssets caches whether the assets checked by the mempool are
+	// restricted at the tip of the main chain.
+	limitedAssets limitedAssetCache
 }
 
 // HaveBlock returns whether or not the chain instance has the block represented
@@ -615,6 +645,25 @@ func (b *BlockChain) connectBlock(node *blockNode, block *asiutil.Block,
 		return err
 	}
 
+	// Find the block the signers the block carries finalize, if any.  They
+	// are only recorded once the block is committed.
+	signers := b.newBlockSigners(node, block)
+	finalized, err := b.finalizedBySigners(signers)
+	if err != nil {
+		return err
+	}
+
+	// Account the produced block, the missed slots and the signatures to
+	// the statistics of the validators, and drop the journals of the blocks
+	// which can no longer be disconnected.
+	statsEntries := b.validatorStatsEntries(node, block)
+	newFinalized := b.finalized
+	if finalized != nil {
+		newFinalized = finalized
+	}
+	pruneFrom := validatorStatsPruneHeight(node.parent, b.finalized)
+	pruneTo := validatorStatsPruneHeight(node, newFinalized)
+
 	// Generate a new best state snapshot that will be used to update the
 	// database and later memory if all database updates are successful.
 	b.stateLock.RLock()
@@ -683,6 +732,24 @@ func (b *BlockChain) connectBlock(node *blockNode, block *asiutil.Block,
 			return err
 		}
 
+		if finalized != nil {
+			err = dbPutFinalizedBlock(dbTx, finalized)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = dbPutValidatorStats(dbTx, block.Hash(), statsEntries)
+		if err != nil {
+			return err
+		}
+		if pruneTo > pruneFrom {
+			err = dbPruneValidatorStats(dbTx, node.Ancestor(pruneTo), pruneFrom)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Allow the index manager to call each of the currently active
 		// optional indexes with the block being connected so they can
 		// update themselves accordingly.
@@ -709,6 +776,12 @@ func (b *BlockChain) connectBlock(node *blockNode, block *asiutil.Block,
 	// This node is now the end of the best chain.
 	b.bestChain.SetTip(node)
 	b.BestSnapshot()
+	b.addBlockSigners(node, signers)
+	if finalized != nil {
+		b.finalized = finalized
+		log.Debugf("Block %v (height %d) is finalized", finalized.hash,
+			finalized.height)
+	}
 
 	// Update the state for the best block.  Notice how this replaces the
 	// entire struct instead of updating the existing one.  This effectively
@@ -719,6 +792,9 @@ func (b *BlockChain) connectBlock(node *blockNode, block *asiutil.Block,
 	b.stateSnapshot = state
 	b.stateLock.Unlock()
 
+	// Prune the contract states which fell out of the retained window.
+	b.pruneState(node.height)
+
 	// Notify the caller that the block was connected to the main chain.
 	// The caller would typically want to react with actions such as
 	// updating wallets.
@@ -825,6 +901,11 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *asiutil.Block, view
 			return err
 		}
 
+		err = dbRemoveValidatorStats(dbTx, block.Hash())
+		if err != nil {
+			return err
+		}
+
 		// Allow the index manager to call each of the currently active
 		// optional indexes with the block being disconnected so they
 		// can update themselves accordingly.
@@ -849,6 +930,7 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *asiutil.Block, view
 
 	// This node's parent is now the end of the best chain.
 	b.bestChain.SetTip(node.parent)
+	b.removeBlockSigners(node, block)
 
 	// Update the state for the best block.  Notice how this replaces the
 	// entire struct instead of updating the existing one.  This effectively
@@ -952,6 +1034,14 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		}
 	}
 
+	// Refuse to detach the finalized block or any block below it.
+	if detachNodes.Len() != 0 {
+		lastDetachNode := detachNodes.Back().Value.(*blockNode)
+		if err := b.checkFinalizedFork(lastDetachNode.parent); err != nil {
+			return err
+		}
+	}
+
 	// Track the old and new best chains heads.
 	oldBest := tip
 	newBest := tip
@@ -1184,6 +1274,31 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 	log.Infof("REORGANIZE: New best chain head is %v (height %v)",
 		newBest.hash, newBest.height)
 
+	// Notify the caller about the reorganization as a whole, the individual
+	// blocks were already announced while being connected and disconnected.
+	if detachNodes.Len() > 0 {
+		data := &ReorganizeData{
+			OldTip:    oldBest.hash,
+			OldHeight: oldBest.height,
+			NewTip:    newBest.hash,
+			NewHeight: newBest.height,
+			Detached:  make([]common.Hash, 0, detachNodes.Len()),
+			Attached:  make([]common.Hash, 0, attachNodes.Len()),
+		}
+		forkPoint := detachNodes.Back().Value.(*blockNode).parent
+		data.ForkHash = forkPoint.hash
+		data.ForkHeight = forkPoint.height
+		for e := detachNodes.Front(); e != nil; e = e.Next() {
+			data.Detached = append(data.Detached, e.Value.(*blockNode).hash)
+		}
+		for e := attachNodes.Front(); e != nil; e = e.Next() {
+			data.Attached = append(data.Attached, e.Value.(*blockNode).hash)
+		}
+		b.chainLock.Unlock()
+		b.sendNotification(NTChainReorganized, data)
+		b.chainLock.Lock()
+	}
+
 	return nil
 }
 
@@ -1376,6 +1491,14 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *asiutil.Block, vbl
 func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *txo.UtxoViewpoint, tx *asiutil.Tx,
 	stxos *[]txo.SpentTxOut, stateDB *state.StateDB, fee int64) (
 	receipt *types.Receipt, err error, gasUsed uint64, vtx *protos.MsgTx, feeLockItems map[protos.Asset]*txo.LockItem) {
+	return b.connectTransaction(block, txidx, view, tx, stxos, stateDB, fee, &b.vmConfig)
+}
+
+// connectTransaction is the implementation of ConnectTransaction, the
+// contracts are executed with the passed vm configuration.
+func (b *BlockChain) connectTransaction(block *asiutil.Block, txidx int, view *txo.UtxoViewpoint, tx *asiutil.Tx,
+	stxos *[]txo.SpentTxOut, stateDB *state.StateDB, fee int64, vmConfig *vm.Config) (
+	receipt *types.Receipt, err error, gasUsed uint64, vtx *protos.MsgTx, feeLockItems map[protos.Asset]*txo.LockItem) {
 
 	scriptClass := txscript.NonStandardTy
 	txbaseGas := uint64(tx.MsgTx().SerializeSize() * common.GasPerByte)
@@ -1423,6 +1546,9 @@ func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *t
 			receipt.Logs = stateDB.GetLogs(*tx.Hash())
 			receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
 			receipt.GasUsed = gasUsed
+			if vmtx != nil && !executeVMFailed {
+				receipt.CreatedAssets = createdAssets(vmtx)
+			}
 		}
 		if executeVMFailed {
 			vtx, err = b.connectContractRollback(view, block.Height(), stxos, txidx, tx)
@@ -1432,7 +1558,7 @@ func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *t
 		}
 	}()
 	if coinbase {
-		vmtx, err, snapshot = b.connectCoinbaseTX(block, view, tx, stxos, stateDB, fee)
+		vmtx, err, snapshot = b.connectCoinbaseTX(block, view, tx, stxos, stateDB, fee, vmConfig)
 		leftOverGas = 0
 		feeLockItems = view.AddTxOuts(tx.Hash(), tx.MsgTx(), true, block.Height())
 		return
@@ -1516,7 +1642,7 @@ func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *t
 		contractAddr = addrs[0].StandardAddress()
 	}
 
-	vmtx, err, leftOverGas, contractAddr, snapshot = b.connectContract(block, view, stateDB, callerAddr, contractAddr, txOut, stxos, tx, scriptClass, leftOverGas, nil, fee)
+	vmtx, err, leftOverGas, contractAddr, snapshot = b.connectContract(block, view, stateDB, callerAddr, contractAddr, txOut, stxos, tx, scriptClass, leftOverGas, nil, fee, vmConfig)
 
 	if err != nil {
 		log.Info("handle vm excute error", err)
@@ -1542,6 +1668,21 @@ func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *t
 	return
 }
 
+// createdAssets returns the ids of the assets created by a virtual
+// transaction, in ascending order.
+func createdAssets(vmtx *virtualtx.VirtualTransaction) [][]byte {
+	var assets [][]byte
+	for asset, item := range vmtx.GetAllTransfers() {
+		if item.Create {
+			assets = append(assets, asset.Bytes())
+		}
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		return bytes.Compare(assets[i], assets[j]) < 0
+	})
+	return assets
+}
+
 // generate, check, and connect to block for the virtual tx.
 func (b *BlockChain) handleVTX(vmtx *virtualtx.VirtualTransaction,
 	block *asiutil.Block,
@@ -1595,7 +1736,8 @@ func (b *BlockChain) connectCoinbaseTX(block *asiutil.Block,
 	view *txo.UtxoViewpoint,
 	tx *asiutil.Tx, stxos *[]txo.SpentTxOut,
 	db *state.StateDB,
-	fee int64) (vtx *virtualtx.VirtualTransaction, err error, snapshot int) {
+	fee int64,
+	vmConfig *vm.Config) (vtx *virtualtx.VirtualTransaction, err error, snapshot int) {
 
 	gas := uint64(math.MaxUint64)
 	poaAddr := chaincfg.OfficialAddress
@@ -1613,7 +1755,7 @@ func (b *BlockChain) connectCoinbaseTX(block *asiutil.Block,
 		}
 		if scriptClass == txscript.CallTy && len(txOut.Data) > 0 {
 			vtx, err, _, _, tempsnapshot = b.connectContract(block, view, db, poaAddr, addrs[0].StandardAddress(), txOut,
-				stxos, tx, scriptClass, gas, vtx, fee)
+				stxos, tx, scriptClass, gas, vtx, fee, vmConfig)
 			// coinbase tx is not allowed be failed
 			if err != nil {
 				str := fmt.Sprintf("coinbase tx call contract failed %v", err)
@@ -1696,7 +1838,8 @@ func (b *BlockChain) connectContract(
 	contractCode txscript.ScriptClass,
 	gas uint64,
 	vtx *virtualtx.VirtualTransaction,
-	fee int64) (
+	fee int64,
+	vmConfig *vm.Config) (
 	vtxr *virtualtx.VirtualTransaction, err error, leftOverGas uint64, newContractAddr common.Address, snapshot int) {
 
 	log.Debug("connectContract enter", contractCode)
@@ -1720,7 +1863,7 @@ func (b *BlockChain) connectContract(
 	gasPrice := new(big.Int).Mul(big.NewInt(fee), big.NewInt(10000))
 	gasPrice = new(big.Int).Div(gasPrice, big.NewInt(int64(tx.MsgTx().TxContract.GasLimit)))
 	context := fvm.NewFVMContext(caller, gasPrice, block, b, view, voteValue)
-	vmenv := vm.NewFVMWithVtx(context, stateDB, chaincfg.ActiveNetParams.FvmParam, *b.GetVmConfig(), vtx)
+	vmenv := vm.NewFVMWithVtx(context, stateDB, chaincfg.ActiveNetParams.FvmParam, *vmConfig, vtx)
 	var ret []byte
 	switch contractCode {
 	case txscript.VoteTy:
@@ -1867,6 +2010,70 @@ func (b *BlockChain) commitTemplateContract(
 	return
 }
 
+// limitedAssetCache keeps whether the assets are restricted in the state of
+// the tip of the main chain.  It is emptied when the tip changes.  Its zero
+// value is ready to use.
+type limitedAssetCache struct {
+	sync.Mutex
+	tip     common.Hash
+	limited map[protos.Asset]bool
+}
+
+// lookup returns whether the asset is restricted at the tip, and whether it
+// is in the cache at all.
+func (cache *limitedAssetCache) lookup(tip common.Hash, asset *protos.Asset) (bool, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.tip != tip {
+		return false, false
+	}
+	limited, ok := cache.limited[*asset]
+	return limited, ok
+}
+
+// add records whether the asset is restricted at the tip.
+func (cache *limitedAssetCache) add(tip common.Hash, asset *protos.Asset, limited bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.tip != tip || cache.limited == nil {
+		cache.tip = tip
+		cache.limited = make(map[protos.Asset]bool)
+	}
+	cache.limited[*asset] = limited
+}
+
+// IsLimitedAsset returns whether the asset is restricted by its issuer as of
+// the end of the main chain.  The answers are cached until the tip changes,
+// so the mempool checking the same assets over and over does not run the
+// registry each time.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsLimitedAsset(asset *protos.Asset) (bool, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	tip := b.bestChain.Tip()
+	if limited, ok := b.limitedAssets.lookup(tip.hash, asset); ok {
+		return limited, nil
+	}
+	block := asiutil.NewBlock(&protos.MsgBlock{
+		Header: protos.BlockHeader{
+			Timestamp: tip.timestamp,
+			Height:    tip.height,
+			StateRoot: tip.stateRoot,
+		},
+	})
+	stateDB, err := state.New(tip.stateRoot, b.stateCache)
+	if err != nil {
+		return false, err
+	}
+	limited := b.contractManager.IsLimit(block, stateDB, asset) > 0
+	b.limitedAssets.add(tip.hash, asset, limited)
+	return limited, nil
+}
+
 //check if the asset in the tx is forbidden.
 func (b *BlockChain) checkAssetForbidden(
 	block *asiutil.Block,
@@ -2831,6 +3038,11 @@ type Config struct {
 	ContractManager ainterface.ContractManager
 
 	FeesChan chan interface{}
+
+	// StateRetain is the number of recent blocks whose contract state is
+	// kept, older states are pruned except at the checkpoints.  Zero keeps
+	// every state.
+	StateRetain int32
 }
 
 // New returns a BlockChain instance using the provided configuration details.
@@ -2848,6 +3060,10 @@ func New(config *Config, fconfig *chaincfg.FConfig) (*BlockChain, error) {
 	if config.StateDB == nil {
 		return nil, common.AssertError("blockchain.New state database is nil")
 	}
+	if config.StateRetain != 0 && config.StateRetain < MinStateRetain {
+		return nil, fmt.Errorf("the number of retained states may not be "+
+			"less than %d", MinStateRetain)
+	}
 
 	// Generate a checkpoint by height map from the provided checkpoints
 	// and assert the provided checkpoints are sorted by height as required.
@@ -2895,6 +3111,12 @@ func New(config *Config, fconfig *chaincfg.FConfig) (*BlockChain, error) {
 		contractManager:     config.ContractManager,
 		vmConfig:            *vmConfig,
 		feesChan:            config.FeesChan,
+		stateRetain:         config.StateRetain,
+		statePruned:         -1,
+		evidence:            newEvidencePool(),
+	}
+	if b.stateRetain > 0 {
+		state.EnableRefcount(b.stateCache)
 	}
 
 	if err := b.contractManager.Init(&b, params.GenesisBlock.Transactions[0].TxOut[0].Data); err != nil {
@@ -2908,6 +3130,15 @@ func New(config *Config, fconfig *chaincfg.FConfig) (*BlockChain, error) {
 	if err := b.initChainState(int64(config.ChainParams.ChainStartTime)); err != nil {
 		return nil, err
 	}
+	if err := b.initStatePruning(); err != nil {
+		return nil, err
+	}
+	if err := b.initSnapshotBase(); err != nil {
+		return nil, err
+	}
+	if err := b.initUtxoSetHash(); err != nil {
+		return nil, err
+	}
 
 	// Initialize and catch up all of the currently active optional indexes
 	// as needed.
@@ -2926,6 +3157,11 @@ func New(config *Config, fconfig *chaincfg.FConfig) (*BlockChain, error) {
 		return nil, err
 	}
 
+	// The validators of the recent rounds are needed to rebuild finality.
+	if err := b.initFinality(); err != nil {
+		return nil, err
+	}
+
 	log.Infof("Chain state (height %d, hash %v, totaltx %d)",
 		bestNode.height, bestNode.hash, b.stateSnapshot.TotalTxns)
 
@@ -5,19 +5,23 @@
 package blockchain
 
 import (
+	"github.com/AsimovNetwork/asimov/ainterface"
 	"github.com/AsimovNetwork/asimov/asiutil"
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
 	"reflect"
 	"testing"
 )
 
 // TestHaveBlock tests the HaveBlock API to ensure proper functionality.
 func TestHaveBlock(t *testing.T) {
-	// Load up blocks such that there is a side chain.
+	// Load up blocks such that there is an attempted side chain.  The only
+	// validator holds the whole weight so every block is final once
+	// connected and the side chain block is rejected.
 	// (genesis block) -> 1 -> 2 -> 3 -> 4 -> 5
 	//                          \-> 3a
 
@@ -92,11 +96,10 @@ func TestHaveBlock(t *testing.T) {
 		}
 		log.Infof("isOrphan = %v", isOrphan)
 		if i == int(forkBlkHeightIdx - 1) {
-			_, forkIsOrphan, err := chain.ProcessBlock(frokBlock, nil, nil, nil, common.BFNone)
-			if err != nil {
-				t.Errorf("ProcessBlock err %v", err)
+			_, _, err := chain.ProcessBlock(frokBlock, nil, nil, nil, common.BFNone)
+			if !isRuleError(err, ErrForkBelowFinalized) {
+				t.Errorf("ProcessBlock fork block unexpected err %v", err)
 			}
-			log.Infof("frokBlock isOrphan = %v", forkIsOrphan)
 		}
 
 		if !isOrphan {
@@ -120,10 +123,10 @@ func TestHaveBlock(t *testing.T) {
 			bestChainHashList[mainChainBlkNums-2],
 			true,
 		},
-		//block hash in fork chain: test1
+		//block hash forking below the finalized block: test1
 		{
 			forkHash,
-			true,
+			false,
 		},
 		//orphan block hash: test2
 		{
@@ -843,6 +846,60 @@ func TestReorganizeChain(t *testing.T) {
 		}
 	}
 }
+
+// limitManagerTmp is a contract manager counting the restriction checks.
+type limitManagerTmp struct {
+	ainterface.ContractManager
+	calls int
+}
+
+func (m *limitManagerTmp) IsLimit(block *asiutil.Block,
+	stateDB vm.StateDB, asset *protos.Asset) int {
+	m.calls++
+	return 1
+}
+
+// TestIsLimitedAsset ensures the restriction of an asset is checked once per
+// tip of the main chain.
+func TestIsLimitedAsset(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	manager := &limitManagerTmp{ContractManager: chain.contractManager}
+	chain.contractManager = manager
+	asset := protos.NewAsset(protos.DivisibleAsset, 1, 1)
+	for i := 0; i < 2; i++ {
+		if limited, err := chain.IsLimitedAsset(asset); err != nil || !limited {
+			t.Fatalf("IsLimitedAsset: got %v, %v want true", limited, err)
+		}
+	}
+	if manager.calls != 1 {
+		t.Errorf("IsLimitedAsset: got %d checks at the same tip, want 1", manager.calls)
+	}
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+		chain, 1, 0, chain.bestChain.height(), protos.Asset{}, 0,
+		validators[0], nil, 0, chain.bestChain.tip())
+	if err != nil {
+		t.Fatalf("create block error %v", err)
+	}
+	if _, _, err := chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+		t.Fatalf("ProcessBlock error %v", err)
+	}
+	if _, err := chain.IsLimitedAsset(asset); err != nil {
+		t.Fatalf("IsLimitedAsset error %v", err)
+	}
+	if manager.calls != 2 {
+		t.Errorf("IsLimitedAsset: got %d checks after the tip changed, want 2", manager.calls)
+	}
+}
 //
 //func TestConnectTransactions(t *testing.T) {
 //	parivateKeyList := []string{
@@ -13,12 +13,14 @@ import (
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/protos"
 	"github.com/AsimovNetwork/asimov/txscript"
 	"github.com/AsimovNetwork/asimov/vm/fvm"
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+	"io"
 	"math/big"
 	"sync"
 )
@@ -609,8 +611,15 @@ func dbFetchUtxoEntry(dbTx database.Tx, outpoint protos.OutPoint) (*txo.UtxoEntr
 // in the database based on the provided utxo view contents and state.  In
 // particular, only the entries that have been marked as modified are written
 // to the database.
+//
+// The utxo set hash, when it is maintained, is updated with the entries
+// removed from and added to the database.
 func dbPutUtxoView(dbTx database.Tx, view *txo.UtxoViewpoint) error {
 	utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+	setHash, err := dbFetchUtxoSetHash(dbTx)
+	if err != nil {
+		return err
+	}
 	for outpoint, entry := range view.Entries() {
 		// No need to update the database if the entry was not modified.
 		if entry == nil || !entry.IsModified() {
@@ -620,6 +629,11 @@ func dbPutUtxoView(dbTx database.Tx, view *txo.UtxoViewpoint) error {
 		// Remove the utxo entry if it is spent.
 		if entry.IsSpent() {
 			key := outpointKey(outpoint)
+			if setHash != nil {
+				if old := utxoBucket.Get(*key); old != nil {
+					setHash.Remove(utxoSetHashElement(*key, old))
+				}
+			}
 			err := utxoBucket.Delete(*key)
 			recycleOutpointKey(key)
 			if err != nil {
@@ -635,6 +649,12 @@ func dbPutUtxoView(dbTx database.Tx, view *txo.UtxoViewpoint) error {
 			return err
 		}
 		key := outpointKey(outpoint)
+		if setHash != nil {
+			if old := utxoBucket.Get(*key); old != nil {
+				setHash.Remove(utxoSetHashElement(*key, old))
+			}
+			setHash.Add(utxoSetHashElement(*key, serialized))
+		}
 		err = utxoBucket.Put(*key, serialized)
 		// NOTE: The key is intentionally not recycled here since the
 		// database interface contract prohibits modifications.  It will
@@ -645,7 +665,10 @@ func dbPutUtxoView(dbTx database.Tx, view *txo.UtxoViewpoint) error {
 		}
 	}
 
-	return nil
+	if setHash == nil {
+		return nil
+	}
+	return dbPutUtxoSetHash(dbTx, setHash)
 }
 
 // dbFetchLockItem uses an existing database transaction to fetch the specified
@@ -1144,6 +1167,14 @@ func (b *BlockChain) createChainState(chainStartTime int64) error {
 	if err != nil {
 		log.Info("Commit error", err)
 	}
+	if b.stateRetain > 0 {
+		if err := b.referenceState(0, stateRoot); err != nil {
+			return err
+		}
+		if err := dbPutStatePruned(b.ethDB, -1); err != nil {
+			return err
+		}
+	}
 
 	// Initialize the state related to the best block.  Since it is the
 	// genesis block, use its timestamp for the median time.
@@ -1230,6 +1261,12 @@ func (b *BlockChain) createChainState(chainStartTime int64) error {
 			return err
 		}
 
+		// Start maintaining the utxo set hash from the empty set.
+		err = dbPutUtxoSetHash(dbTx, muhash.New())
+		if err != nil {
+			return err
+		}
+
 		err = dbPutBalance(dbTx, view)
 		if err != nil {
 			return err
@@ -1303,7 +1340,7 @@ func (b *BlockChain) initChainState(chainStartTime int64) error {
 		var lastRound *ainterface.Round
 		cursor = blockIndexBucket.Cursor()
 		for ok := cursor.First(); ok; ok = cursor.Next() {
-			header, round, status, err := deserializeBlockRow(cursor.Value())
+			header, round, status, vrfOutput, err := deserializeBlockRowVrf(cursor.Value())
 			if err != nil {
 				return err
 			}
@@ -1342,6 +1379,7 @@ func (b *BlockChain) initChainState(chainStartTime int64) error {
 				initBlockNode(node, lastRound, header, parent)
 			}
 			node.status = status
+			node.vrfOutput = vrfOutput
 			b.index.addNode(node)
 
 			lastNode = node
@@ -1407,30 +1445,44 @@ func (b *BlockChain) initChainState(chainStartTime int64) error {
 // deserializeBlockRow parses a value in the block index bucket into a block
 // header and block status bitfield.
 func deserializeBlockRow(blockRow []byte) (*protos.BlockHeader, *ainterface.Round, blockStatus, error) {
+	header, round, status, _, err := deserializeBlockRowVrf(blockRow)
+	return header, round, status, err
+}
+
+// deserializeBlockRowVrf parses a value in the block index bucket like
+// deserializeBlockRow, and also returns the vrf output of the block.
+func deserializeBlockRowVrf(blockRow []byte) (*protos.BlockHeader, *ainterface.Round, blockStatus, common.Hash, error) {
 	buffer := bytes.NewReader(blockRow)
 
 	var header protos.BlockHeader
 	err := header.Deserialize(buffer)
 	if err != nil {
-		return nil, nil, statusNone, err
+		return nil, nil, statusNone, common.Hash{}, err
 	}
 
 	statusByte, err := buffer.ReadByte()
 	if err != nil {
-		return nil, nil, statusNone, err
+		return nil, nil, statusNone, common.Hash{}, err
 	}
 
+	var round *ainterface.Round
 	if blockStatus(statusByte)&statusFirstInRound == statusFirstInRound {
-		var round ainterface.Round
+		round = new(ainterface.Round)
 		err = round.Deserialize(buffer)
 		if err != nil {
-			return nil, nil, statusNone, err
+			return nil, nil, statusNone, common.Hash{}, err
 		}
 		round.Round = header.Round
+	}
 
-		return &header, &round, blockStatus(statusByte), nil
+	var vrfOutput common.Hash
+	if blockStatus(statusByte)&statusVrfOutput == statusVrfOutput {
+		_, err = io.ReadFull(buffer, vrfOutput[:])
+		if err != nil {
+			return nil, nil, statusNone, common.Hash{}, err
+		}
 	}
-	return &header, nil, blockStatus(statusByte), nil
+	return &header, round, blockStatus(statusByte), vrfOutput, nil
 }
 
 // dbFetchHeaderByHash uses an existing database transaction to retrieve the
@@ -1477,6 +1529,9 @@ func dbStoreBlockNode(dbTx database.Tx, node *blockNode) error {
 	if node.status&statusFirstInRound == statusFirstInRound {
 		size += roundHdrDeltaSize
 	}
+	if node.status&statusVrfOutput == statusVrfOutput {
+		size += common.HashLength
+	}
 	w := bytes.NewBuffer(make([]byte, 0, size))
 	header := node.Header()
 	err := header.Serialize(w)
@@ -1494,6 +1549,12 @@ func dbStoreBlockNode(dbTx database.Tx, node *blockNode) error {
 			return err
 		}
 	}
+	if node.status&statusVrfOutput == statusVrfOutput {
+		_, err = w.Write(node.vrfOutput[:])
+		if err != nil {
+			return err
+		}
+	}
 	value := w.Bytes()
 
 	// Write block header data to block index bucket.
@@ -1725,12 +1725,6 @@ func createAndSignBlock(paramstmp chaincfg.Params,
 		block.MsgBlock().Header.GasUsed = gasUsed
 		block.MsgBlock().Header.StateRoot = *stateRoot
 	}
-	block.MsgBlock().Header.PoaHash = block.MsgBlock().CalculatePoaHash()
-	if timeAddCnt > 0 {
-		multTime := int64(timeAddCnt)*5134567889 / int64(time.Second)
-		block.MsgBlock().Header.Timestamp = block.MsgBlock().Header.Timestamp + multTime
-	}
-	hash1 := block.MsgBlock().Header.BlockHash()
 	index := 0
 	for k:=0; k<len(paramstmp.GenesisCandidates); k++ {
 		if paramstmp.GenesisCandidates[k] == block.MsgBlock().Header.CoinBase {
@@ -1738,6 +1732,20 @@ func createAndSignBlock(paramstmp chaincfg.Params,
 			break
 		}
 	}
+	var vrfOutput common.Hash
+	if preNode != nil && chain.chainParams.VrfActiveAt(epoch) {
+		alpha := vrf.BlockAlpha(preNode.vrfOutput, epoch, slot)
+		block.MsgBlock().VrfProof, vrfOutput, err = vrf.Prove(&accList[index].PrivateKey, alpha)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	block.MsgBlock().Header.PoaHash = block.MsgBlock().CalculatePoaHash()
+	if timeAddCnt > 0 {
+		multTime := int64(timeAddCnt)*5134567889 / int64(time.Second)
+		block.MsgBlock().Header.Timestamp = block.MsgBlock().Header.Timestamp + multTime
+	}
+	hash1 := block.MsgBlock().Header.BlockHash()
 	signature, signErr := crypto.Sign(hash1[:], (*ecdsa.PrivateKey)(&accList[index].PrivateKey))
 	if signErr != nil {
 		return nil, nil, signErr
@@ -1754,6 +1762,7 @@ func createAndSignBlock(paramstmp chaincfg.Params,
 			}
 		}
 		newBestNode = newBlockNode(round, &block.MsgBlock().Header,preNode)
+		newBestNode.vrfOutput = vrfOutput
 	}
 
 	return block, newBestNode, nil
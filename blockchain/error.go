@@ -284,6 +284,18 @@ const (
 
 	// ErrFailedSerializedBlock indicates failed to get serialized bytes for block
 	ErrFailedSerializedBlock
+
+	// ErrInvalidEvidence indicates an evidence does not prove a validator
+	// misbehaved.
+	ErrInvalidEvidence
+
+	// ErrForkBelowFinalized indicates a block or a reorganization attempts
+	// to fork the block chain before the finalized block.
+	ErrForkBelowFinalized
+
+	// ErrBadVrfProof indicates a block misses the vrf proof of its producer,
+	// carries one while the vrf is not active, or carries an invalid one.
+	ErrBadVrfProof
 )
 
 // Map of ErrorCode values back to their constant names for pretty printing.
@@ -360,6 +372,9 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrStxoMismatch:         "ErrStxoMismatch",
 	ErrNotInMainChain:       "ErrNotInMainChain",
 	ErrFailedSerializedBlock: "ErrFailedSerializedBlock",
+	ErrInvalidEvidence:      "ErrInvalidEvidence",
+	ErrForkBelowFinalized:   "ErrForkBelowFinalized",
+	ErrBadVrfProof:          "ErrBadVrfProof",
 }
 
 // String returns the ErrorCode as a human-readable name.
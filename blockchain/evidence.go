@@ -0,0 +1,386 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/AsimovNetwork/asimov/ainterface"
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+)
+
+const (
+	// evidenceWindow is the number of blocks below the best height for
+	// which the headers and signatures are kept to detect conflicts.
+	evidenceWindow = 2000
+
+	// evidencePruneInterval is the number of blocks between two prunings
+	// of the evidence pool.
+	evidencePruneInterval = evidenceWindow / 4
+)
+
+// evidenceBucketName is the name of the db bucket used to house the evidences
+// of validator misbehavior, keyed by evidence hash.
+var evidenceBucketName = []byte("evidence")
+
+// slotKey identifies the slot a block is proposed for.
+type slotKey struct {
+	round uint32
+	slot  uint16
+}
+
+// signKey identifies the height a validator signs a block at.
+type signKey struct {
+	signer common.Address
+	height int32
+}
+
+// evidencePool keeps the recent block headers and block signatures of the
+// validators to detect the ones which conflict.
+type evidencePool struct {
+	sync.Mutex
+	proposals   map[slotKey]*protos.BlockHeader
+	signs       map[signKey]*protos.MsgBlockSign
+	pruneHeight int32
+}
+
+// newEvidencePool returns a new empty evidence pool.
+func newEvidencePool() *evidencePool {
+	return &evidencePool{
+		proposals: make(map[slotKey]*protos.BlockHeader),
+		signs:     make(map[signKey]*protos.MsgBlockSign),
+	}
+}
+
+// addProposal records the header and returns a header of a different block
+// proposed for the same round and slot, if any.
+func (p *evidencePool) addProposal(header *protos.BlockHeader) *protos.BlockHeader {
+	p.Lock()
+	defer p.Unlock()
+
+	key := slotKey{round: header.Round, slot: header.SlotIndex}
+	if prev, ok := p.proposals[key]; ok {
+		if prev.BlockHash() != header.BlockHash() {
+			return prev
+		}
+		return nil
+	}
+	stored := *header
+	p.proposals[key] = &stored
+	return nil
+}
+
+// addSign records the signature and returns a signature of a different block
+// at the same height by the same signer, if any.
+func (p *evidencePool) addSign(sign *protos.MsgBlockSign) *protos.MsgBlockSign {
+	p.Lock()
+	defer p.Unlock()
+
+	key := signKey{signer: sign.Signer, height: sign.BlockHeight}
+	if prev, ok := p.signs[key]; ok {
+		if prev.BlockHash != sign.BlockHash {
+			return prev
+		}
+		return nil
+	}
+	stored := *sign
+	p.signs[key] = &stored
+	return nil
+}
+
+// prune removes the headers and signatures too deep below the passed best
+// height to matter anymore.
+func (p *evidencePool) prune(bestHeight int32) {
+	p.Lock()
+	defer p.Unlock()
+
+	if bestHeight < p.pruneHeight {
+		return
+	}
+	p.pruneHeight = bestHeight + evidencePruneInterval
+	minHeight := bestHeight - evidenceWindow
+	for key, header := range p.proposals {
+		if header.Height < minHeight {
+			delete(p.proposals, key)
+		}
+	}
+	for key := range p.signs {
+		if key.height < minHeight {
+			delete(p.signs, key)
+		}
+	}
+}
+
+// dbPutEvidence stores the evidence.  It returns false when the evidence was
+// already stored.
+func dbPutEvidence(dbTx database.Tx, evidence *protos.MsgEvidence) (bool, error) {
+	bucket, err := dbTx.Metadata().CreateBucketIfNotExists(evidenceBucketName)
+	if err != nil {
+		return false, err
+	}
+	hash := evidence.Hash()
+	if bucket.Get(hash[:]) != nil {
+		return false, nil
+	}
+	var buf bytes.Buffer
+	if err := evidence.Serialize(&buf); err != nil {
+		return false, err
+	}
+	return true, bucket.Put(hash[:], buf.Bytes())
+}
+
+// deserializeEvidence decodes a stored evidence.
+func deserializeEvidence(serialized []byte) (*protos.MsgEvidence, error) {
+	evidence := &protos.MsgEvidence{}
+	if err := evidence.Deserialize(bytes.NewReader(serialized)); err != nil {
+		return nil, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt evidence: %v", err),
+		}
+	}
+	return evidence, nil
+}
+
+// checkEvidenceValidator ensures the offender was a validator of the round,
+// and the validator of the slot when one is passed.
+func (b *BlockChain) checkEvidenceValidator(offender common.Address, round uint32, slot int) error {
+	if round > b.bestChain.Tip().round.Round+1 {
+		str := fmt.Sprintf("evidence round %d is too far ahead", round)
+		return ruleError(ErrInvalidEvidence, str)
+	}
+	validators, weightMap, err := b.GetValidators(round)
+	if err != nil {
+		return err
+	}
+	if slot >= 0 {
+		if slot >= len(validators) || *validators[slot] != offender {
+			str := fmt.Sprintf("%v is not the validator of round %d slot %d",
+				offender, round, slot)
+			return ruleError(ErrInvalidEvidence, str)
+		}
+		return nil
+	}
+	if _, ok := weightMap[offender]; !ok {
+		str := fmt.Sprintf("%v is not a validator of round %d", offender, round)
+		return ruleError(ErrInvalidEvidence, str)
+	}
+	return nil
+}
+
+// VerifyEvidence ensures the evidence proves the misbehavior of a validator.
+// Both conflicting items must be signed by the validator and the validator
+// must have been allowed to sign them.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) VerifyEvidence(evidence *protos.MsgEvidence) error {
+	hashes := evidence.BlockHashes()
+	if bytes.Compare(hashes[0][:], hashes[1][:]) >= 0 {
+		return ruleError(ErrInvalidEvidence, "evidence blocks are not distinct and ordered")
+	}
+
+	switch evidence.Type {
+	case protos.EvidenceDoubleProposal:
+		a, c := &evidence.Headers[0], &evidence.Headers[1]
+		if a.CoinBase != c.CoinBase || a.Round != c.Round || a.SlotIndex != c.SlotIndex {
+			return ruleError(ErrInvalidEvidence, "evidence headers do not conflict")
+		}
+		for i := range evidence.Headers {
+			header := &evidence.Headers[i]
+			err := b.verifyValidatorSignature(hashes[i][:], &header.CoinBase, header.Round,
+				b.bestChain.Tip(), header.SigData[:])
+			if err != nil {
+				return err
+			}
+		}
+		return b.checkEvidenceValidator(a.CoinBase, a.Round, int(a.SlotIndex))
+
+	case protos.EvidenceDoubleSign:
+		a, c := &evidence.Signs[0], &evidence.Signs[1]
+		if a.Signer != c.Signer || a.BlockHeight != c.BlockHeight {
+			return ruleError(ErrInvalidEvidence, "evidence signatures do not conflict")
+		}
+		node := b.bestChain.NodeByHeight(a.BlockHeight)
+		if node == nil {
+			str := fmt.Sprintf("evidence height %d is beyond the best chain", a.BlockHeight)
+			return ruleError(ErrInvalidEvidence, str)
+		}
+		for i := range evidence.Signs {
+			err := b.verifyBlockSign(&evidence.Signs[i], node.round.Round, node)
+			if err != nil {
+				return err
+			}
+		}
+		return b.checkEvidenceValidator(a.Signer, node.round.Round, -1)
+	}
+
+	str := fmt.Sprintf("unknown evidence type %v", evidence.Type)
+	return ruleError(ErrInvalidEvidence, str)
+}
+
+// ProcessEvidence verifies and stores the evidence, and notifies the caller
+// with NTEvidence when it was not known yet.  It returns whether the evidence
+// is new.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ProcessEvidence(evidence *protos.MsgEvidence) (bool, error) {
+	if err := b.VerifyEvidence(evidence); err != nil {
+		return false, err
+	}
+
+	var isNew bool
+	err := b.db.Update(func(dbTx database.Tx) error {
+		var err error
+		isNew, err = dbPutEvidence(dbTx, evidence)
+		return err
+	})
+	if err != nil || !isNew {
+		return false, err
+	}
+
+	log.Warnf("Validator %v misbehaved: %v at height %d, evidence %v",
+		evidence.Offender(), evidence.Type, evidence.Height(), evidence.Hash())
+	b.sendNotification(NTEvidence, evidence)
+	return true, nil
+}
+
+// penalizeMisbehaviors clears the blocks produced during the round by the
+// validators whose misbehavior was recorded by the evidence registry during
+// the round, in the state of the last node of the round.  The blocks are
+// handed to the validator committee at the start of the next round, so an
+// offender loses the credit of the round there: its efficiency drops, and
+// with it its chance to join the committee.
+func (b *BlockChain) penalizeMisbehaviors(round uint32, roundLastNode *blockNode,
+	validators []common.Address, actualBlocks []uint16) error {
+
+	em, ok := b.contractManager.(ainterface.IEvidenceManager)
+	if !ok || len(validators) == 0 {
+		return nil
+	}
+
+	block := asiutil.NewBlock(&protos.MsgBlock{
+		Header: protos.BlockHeader{
+			Timestamp: roundLastNode.timestamp,
+			Height:    roundLastNode.height,
+			StateRoot: roundLastNode.stateRoot,
+		},
+	})
+	stateDB, err := state.New(roundLastNode.stateRoot, b.stateCache)
+	if err != nil {
+		return err
+	}
+	heights, err := em.GetMisbehaviorHeights(block, stateDB,
+		chaincfg.ActiveNetParams.FvmParam, validators)
+	if err != nil {
+		return err
+	}
+
+	// The misbehaviors recorded before the round were penalized already.
+	var roundStart int32
+	if node := findPreroundLastNode(round, roundLastNode); node != nil {
+		roundStart = node.height + 1
+	}
+	for i, height := range heights {
+		if height == 0 || height < roundStart || actualBlocks[i] == 0 {
+			continue
+		}
+		log.Infof("Validator %v misbehaved in round %d, its %d blocks are "+
+			"not credited", validators[i], round, actualBlocks[i])
+		actualBlocks[i] = 0
+	}
+	return nil
+}
+
+// DetectDoubleSign records the block signature and processes an evidence when
+// the signer already signed a different block at the same height.  The
+// signature is expected to be verified.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DetectDoubleSign(sign *protos.MsgBlockSign) error {
+	b.evidence.prune(b.bestChain.Height())
+	prev := b.evidence.addSign(sign)
+	if prev == nil {
+		return nil
+	}
+	_, err := b.ProcessEvidence(protos.NewMsgDoubleSign(prev, sign))
+	return err
+}
+
+// detectEvidence looks for conflicts between the accepted block and the
+// recent blocks and signatures.  Errors are only logged since the block
+// itself is valid.
+//
+// This function MUST NOT be called with the chain state lock held since it
+// may send notifications.
+func (b *BlockChain) detectEvidence(block *asiutil.Block) {
+	b.evidence.prune(b.bestChain.Height())
+	header := &block.MsgBlock().Header
+	if prev := b.evidence.addProposal(header); prev != nil {
+		_, err := b.ProcessEvidence(protos.NewMsgDoubleProposal(prev, header))
+		if err != nil {
+			log.Warnf("Failed to process double proposal of block %v: %v",
+				block.Hash(), err)
+		}
+	}
+	for _, sign := range block.MsgBlock().PreBlockSigs {
+		if err := b.DetectDoubleSign(sign); err != nil {
+			log.Warnf("Failed to process double sign in block %v: %v",
+				block.Hash(), err)
+		}
+	}
+}
+
+// FetchEvidence returns the stored evidence with the passed hash, nil when it
+// is unknown.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchEvidence(hash *common.Hash) (*protos.MsgEvidence, error) {
+	var evidence *protos.MsgEvidence
+	err := b.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(evidenceBucketName)
+		if bucket == nil {
+			return nil
+		}
+		serialized := bucket.Get(hash[:])
+		if serialized == nil {
+			return nil
+		}
+		var err error
+		evidence, err = deserializeEvidence(serialized)
+		return err
+	})
+	return evidence, err
+}
+
+// FetchEvidences returns the stored evidences, only the ones against the
+// passed offender when it is not nil.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchEvidences(offender *common.Address) ([]*protos.MsgEvidence, error) {
+	var evidences []*protos.MsgEvidence
+	err := b.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(evidenceBucketName)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			evidence, err := deserializeEvidence(v)
+			if err != nil {
+				return err
+			}
+			if offender == nil || evidence.Offender() == *offender {
+				evidences = append(evidences, evidence)
+			}
+			return nil
+		})
+	})
+	return evidences, err
+}
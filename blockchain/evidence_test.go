@@ -0,0 +1,367 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/ainterface"
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/params"
+)
+
+// TestEvidence ensures conflicting block signatures and block proposals of a
+// validator are detected, verified and stored once.
+func TestEvidence(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	var headers []protos.BlockHeader
+	for slot := uint16(0); slot < 2; slot++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+			chain, 1, slot, chain.bestChain.height(), protos.Asset{}, 0,
+			validators[slot], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err := chain.ProcessBlock(block, nil, nil, nil, 1); err != nil {
+			t.Fatalf("ProcessBlock error %v", err)
+		}
+		headers = append(headers, block.MsgBlock().Header)
+	}
+
+	var notified []*protos.MsgEvidence
+	chain.Subscribe(func(n *Notification) {
+		if n.Type == NTEvidence {
+			notified = append(notified, n.Data.(*protos.MsgEvidence))
+		}
+	})
+
+	sign := func(height int32, hash common.Hash, acc *crypto.Account) *protos.MsgBlockSign {
+		signature, err := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&acc.PrivateKey))
+		if err != nil {
+			t.Fatalf("Sign error %v", err)
+		}
+		msg := &protos.MsgBlockSign{BlockHeight: height, BlockHash: hash, Signer: *acc.Address}
+		copy(msg.Signature[:], signature)
+		return msg
+	}
+
+	// Signing two blocks at the same height is a double sign, signing the
+	// same block twice is not.
+	signA := sign(1, common.Hash{0x01}, &accList[0])
+	signB := sign(1, common.Hash{0x02}, &accList[0])
+	for _, msg := range []*protos.MsgBlockSign{signA, signA, signB, signB} {
+		if err := chain.DetectDoubleSign(msg); err != nil {
+			t.Fatalf("DetectDoubleSign: unexpected error: %v", err)
+		}
+	}
+	if len(notified) != 1 || notified[0].Type != protos.EvidenceDoubleSign ||
+		notified[0].Offender() != signA.Signer {
+		t.Fatalf("unexpected double sign notifications %v", notified)
+	}
+
+	// A tampered signature or a signer which is not a validator does not
+	// prove anything.
+	tampered := protos.NewMsgDoubleSign(signA, signB)
+	tampered.Signs[1].Signature[10] ^= 0xff
+	if err := chain.VerifyEvidence(tampered); err == nil {
+		t.Fatalf("VerifyEvidence: tampered evidence was accepted")
+	}
+	other, err := crypto.NewAccount("0x224828e95689e30a8e668418968260edbfadb0c2d5da1a7ab5cae4d0e1bc73b5")
+	if err != nil {
+		t.Fatalf("NewAccount error %v", err)
+	}
+	stranger := protos.NewMsgDoubleSign(sign(1, common.Hash{0x01}, other),
+		sign(1, common.Hash{0x02}, other))
+	if err := chain.VerifyEvidence(stranger); err == nil {
+		t.Fatalf("VerifyEvidence: evidence against a non validator was accepted")
+	}
+
+	// A second block signed for the slot of an accepted block is a double
+	// proposal.
+	conflict := headers[0]
+	conflict.Timestamp++
+	hash := conflict.BlockHash()
+	signature, err := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&accList[0].PrivateKey))
+	if err != nil {
+		t.Fatalf("Sign error %v", err)
+	}
+	copy(conflict.SigData[:], signature)
+	proposal := protos.NewMsgDoubleProposal(&headers[0], &conflict)
+	isNew, err := chain.ProcessEvidence(proposal)
+	if err != nil || !isNew {
+		t.Fatalf("ProcessEvidence: unexpected result %v, %v", isNew, err)
+	}
+	if isNew, err = chain.ProcessEvidence(proposal); err != nil || isNew {
+		t.Fatalf("ProcessEvidence: known evidence is new %v, %v", isNew, err)
+	}
+	mismatch := protos.NewMsgDoubleProposal(&headers[0], &headers[1])
+	if err := chain.VerifyEvidence(mismatch); err == nil {
+		t.Fatalf("VerifyEvidence: blocks of different slots were accepted")
+	}
+
+	evidences, err := chain.FetchEvidences(&signA.Signer)
+	if err != nil || len(evidences) != 2 {
+		t.Fatalf("FetchEvidences: unexpected result %d, %v", len(evidences), err)
+	}
+	proposalHash := proposal.Hash()
+	fetched, err := chain.FetchEvidence(&proposalHash)
+	if err != nil || fetched == nil || fetched.Hash() != proposalHash {
+		t.Fatalf("FetchEvidence: unexpected result %v, %v", fetched, err)
+	}
+	if len(notified) != 2 {
+		t.Fatalf("unexpected number of notifications %d", len(notified))
+	}
+}
+
+// TestEvidenceRegistry ensures the evidence registry native contract counts the
+// misbehaviors proven by the evidences once, refuses the evidences which do
+// not prove anything, and can not be called before it is active.
+func TestEvidenceRegistry(t *testing.T) {
+	offender, err := crypto.NewAccount("0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e")
+	if err != nil {
+		t.Fatalf("NewAccount error %v", err)
+	}
+	other, err := crypto.NewAccount("0x224828e95689e30a8e668418968260edbfadb0c2d5da1a7ab5cae4d0e1bc73b5")
+	if err != nil {
+		t.Fatalf("NewAccount error %v", err)
+	}
+	stateDB, err := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	if err != nil {
+		t.Fatalf("state.New error %v", err)
+	}
+	reporter := *other.Address
+
+	call := func(config *params.ChainConfig, method string, args ...interface{}) ([]byte, error) {
+		input, err := fvm.PackFunctionArgs(vm.EvidenceRegistryABI, method, args...)
+		if err != nil {
+			t.Fatalf("PackFunctionArgs error %v", err)
+		}
+		vmenv := vm.NewFVM(vm.Context{BlockNumber: big.NewInt(10)}, stateDB, config, vm.Config{})
+		ret, _, _, err := vmenv.Call(vm.AccountRef(reporter), common.EvidenceRegistry,
+			input, 100000, common.Big0, nil, false)
+		return ret, err
+	}
+	report := func(config *params.ChainConfig, evidence *protos.MsgEvidence) error {
+		var buf bytes.Buffer
+		if err := evidence.Serialize(&buf); err != nil {
+			t.Fatalf("Serialize error %v", err)
+		}
+		_, err := call(config, "reportMisbehavior", buf.Bytes())
+		return err
+	}
+	misbehaviors := func() int64 {
+		ret, err := call(params.DevelopnetChainConfig, "getMisbehaviors", *offender.Address)
+		if err != nil {
+			t.Fatalf("getMisbehaviors error %v", err)
+		}
+		count, err := fvm.UnPackReadOnlyResult(vm.EvidenceRegistryABI, "getMisbehaviors", ret)
+		if err != nil {
+			t.Fatalf("UnPackReadOnlyResult error %v", err)
+		}
+		return count.(*big.Int).Int64()
+	}
+	sign := func(height int32, hash common.Hash, acc *crypto.Account) *protos.MsgBlockSign {
+		signature, err := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&acc.PrivateKey))
+		if err != nil {
+			t.Fatalf("Sign error %v", err)
+		}
+		msg := &protos.MsgBlockSign{BlockHeight: height, BlockHash: hash, Signer: *offender.Address}
+		copy(msg.Signature[:], signature)
+		return msg
+	}
+	propose := func(timestamp int64, acc *crypto.Account) *protos.BlockHeader {
+		header := &protos.BlockHeader{Height: 5, Round: 1, SlotIndex: 2,
+			CoinBase: *offender.Address, Timestamp: timestamp}
+		hash := header.BlockHash()
+		signature, err := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&acc.PrivateKey))
+		if err != nil {
+			t.Fatalf("Sign error %v", err)
+		}
+		copy(header.SigData[:], signature)
+		return header
+	}
+
+	doubleSign := protos.NewMsgDoubleSign(sign(5, common.Hash{0x01}, offender),
+		sign(5, common.Hash{0x02}, offender))
+
+	// The registry is not there before it is active.
+	if err := report(params.TestnetChainConfig, doubleSign); err != nil {
+		t.Fatalf("inactive registry: unexpected error %v", err)
+	}
+	if count := misbehaviors(); count != 0 {
+		t.Fatalf("inactive registry: got %d misbehaviors, want 0", count)
+	}
+
+	tampered := protos.NewMsgDoubleSign(sign(5, common.Hash{0x01}, offender),
+		sign(5, common.Hash{0x02}, offender))
+	tampered.Signs[1].Signature[10] ^= 0xff
+	tests := []struct {
+		name     string
+		evidence *protos.MsgEvidence
+		valid    bool
+	}{
+		{"double sign", doubleSign, true},
+		{"double sign reported again", doubleSign, false},
+		{"tampered signature", tampered, false},
+		{"signed by another key", protos.NewMsgDoubleSign(sign(5, common.Hash{0x01}, other),
+			sign(5, common.Hash{0x02}, other)), false},
+		{"signs at different heights", protos.NewMsgDoubleSign(sign(5, common.Hash{0x01}, offender),
+			sign(6, common.Hash{0x02}, offender)), false},
+		{"same block signed twice", &protos.MsgEvidence{Type: protos.EvidenceDoubleSign,
+			Signs: [2]protos.MsgBlockSign{*sign(5, common.Hash{0x01}, offender),
+				*sign(5, common.Hash{0x01}, offender)}}, false},
+		{"double proposal", protos.NewMsgDoubleProposal(propose(1, offender),
+			propose(2, offender)), true},
+		{"proposals signed by another key", protos.NewMsgDoubleProposal(propose(1, offender),
+			propose(3, other)), false},
+	}
+	var want int64
+	for _, test := range tests {
+		err := report(params.DevelopnetChainConfig, test.evidence)
+		if test.valid {
+			want++
+		}
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, want valid %v", test.name, err, test.valid)
+		}
+		if count := misbehaviors(); count != want {
+			t.Errorf("%s: got %d misbehaviors, want %d", test.name, count, want)
+		}
+	}
+
+	logs := stateDB.Logs()
+	if len(logs) != 2 {
+		t.Fatalf("got %d logs, want 2", len(logs))
+	}
+	for _, log := range logs {
+		if log.Address != common.EvidenceRegistry || log.BlockNumber != 10 {
+			t.Errorf("unexpected log %+v", log)
+		}
+	}
+
+	// The height of the last misbehavior is recorded for the penalty.
+	ret, err := call(params.DevelopnetChainConfig, "getMisbehaviorHeights",
+		[]common.Address{*offender.Address, reporter})
+	if err != nil {
+		t.Fatalf("getMisbehaviorHeights error %v", err)
+	}
+	heights, err := fvm.UnPackReadOnlyResult(vm.EvidenceRegistryABI, "getMisbehaviorHeights", ret)
+	if err != nil {
+		t.Fatalf("UnPackReadOnlyResult error %v", err)
+	}
+	if got := heights.([]*big.Int); len(got) != 2 || got[0].Int64() != 10 || got[1].Sign() != 0 {
+		t.Errorf("getMisbehaviorHeights: got %v, want [10 0]", got)
+	}
+
+	// Trailing bytes are refused.
+	var buf bytes.Buffer
+	doubleSign.Serialize(&buf)
+	if _, err := call(params.DevelopnetChainConfig, "reportMisbehavior",
+		append(buf.Bytes(), 0)); err == nil {
+		t.Errorf("evidence with trailing bytes was accepted")
+	}
+
+	// The signatures of the signing key the offender registered are its
+	// own.
+	input, err := fvm.PackFunctionArgs(vm.SigningKeyRegistryABI, "setSigningKey", reporter)
+	if err != nil {
+		t.Fatalf("PackFunctionArgs error %v", err)
+	}
+	vmenv := vm.NewFVM(vm.Context{BlockNumber: big.NewInt(10)}, stateDB,
+		params.DevelopnetChainConfig, vm.Config{})
+	if _, _, _, err := vmenv.Call(vm.AccountRef(*offender.Address), common.SigningKeyRegistry,
+		input, 100000, common.Big0, nil, false); err != nil {
+		t.Fatalf("setSigningKey error %v", err)
+	}
+	delegated := protos.NewMsgDoubleSign(sign(5, common.Hash{0x03}, other),
+		sign(5, common.Hash{0x04}, other))
+	if err := report(params.DevelopnetChainConfig, delegated); err != nil {
+		t.Errorf("double sign by the signing key: unexpected error %v", err)
+	}
+	if count := misbehaviors(); count != want+1 {
+		t.Errorf("double sign by the signing key: got %d misbehaviors, want %d",
+			count, want+1)
+	}
+}
+
+// evidenceManagerTmp is a contract manager reporting fixed misbehavior
+// heights.
+type evidenceManagerTmp struct {
+	ainterface.ContractManager
+	heights map[common.Address]int32
+}
+
+func (m *evidenceManagerTmp) GetMisbehaviorHeights(block *asiutil.Block,
+	stateDB vm.StateDB, chainConfig *params.ChainConfig,
+	validators []common.Address) ([]int32, error) {
+	heights := make([]int32, len(validators))
+	for i, validator := range validators {
+		heights[i] = m.heights[validator]
+	}
+	return heights, nil
+}
+
+// TestPenalizeMisbehaviors ensures the blocks of the validators which
+// misbehaved during a round are not credited to them.
+func TestPenalizeMisbehaviors(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	_, _, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 3)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	// Three blocks in round 1, then one in round 2.
+	node := chain.bestChain.tip()
+	for _, round := range []uint32{1, 1, 1, 2} {
+		node = &blockNode{
+			parent:    node,
+			round:     &ainterface.Round{Round: round},
+			height:    node.height + 1,
+			stateRoot: node.stateRoot,
+		}
+	}
+
+	validators := []common.Address{{0x01}, {0x02}, {0x03}, {0x04}}
+	chain.contractManager = &evidenceManagerTmp{
+		ContractManager: chain.contractManager,
+		heights: map[common.Address]int32{
+			validators[0]: 4,
+			validators[1]: 3,
+			validators[3]: 4,
+		},
+	}
+	actualBlocks := []uint16{2, 1, 3, 0}
+	if err := chain.penalizeMisbehaviors(2, node, validators, actualBlocks); err != nil {
+		t.Fatalf("penalizeMisbehaviors error %v", err)
+	}
+	want := []uint16{0, 1, 3, 0}
+	for i := range want {
+		if actualBlocks[i] != want[i] {
+			t.Errorf("validator %d: got %d blocks, want %d", i, actualBlocks[i], want[i])
+		}
+	}
+}
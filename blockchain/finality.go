@@ -0,0 +1,243 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+)
+
+// finalityDepth is the number of recent main chain blocks whose signers are
+// tracked.  The signatures of deeper blocks can no longer be included in a
+// new block.
+const finalityDepth = common.BlockSignDepth + 2
+
+// finalizedBlockKeyName is the name of the db key used to store the hash and
+// the height of the last finalized block.
+var finalizedBlockKeyName = []byte("finalizedblock")
+
+// dbPutFinalizedBlock stores the last finalized block.
+func dbPutFinalizedBlock(dbTx database.Tx, node *blockNode) error {
+	serialized := make([]byte, common.HashLength+4)
+	copy(serialized, node.hash[:])
+	byteOrder.PutUint32(serialized[common.HashLength:], uint32(node.height))
+	return dbTx.Metadata().Put(finalizedBlockKeyName, serialized)
+}
+
+// dbFetchFinalizedBlock returns the hash of the last finalized block, nil
+// when no block was finalized yet.
+func dbFetchFinalizedBlock(dbTx database.Tx) (*common.Hash, int32, error) {
+	serialized := dbTx.Metadata().Get(finalizedBlockKeyName)
+	if serialized == nil {
+		return nil, 0, nil
+	}
+	if len(serialized) != common.HashLength+4 {
+		return nil, 0, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: "corrupt finalized block",
+		}
+	}
+	var hash common.Hash
+	copy(hash[:], serialized)
+	return &hash, int32(byteOrder.Uint32(serialized[common.HashLength:])), nil
+}
+
+// isFinal returns whether the signers of the block, its proposer included,
+// hold more than two thirds of the weight of the validators of the round of
+// the block.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) isFinal(node *blockNode, signers map[common.Address]struct{}) (bool, error) {
+	_, weightMap, err := b.GetValidators(node.round.Round)
+	if err != nil {
+		return false, err
+	}
+	var weight, total uint32
+	for validator, w := range weightMap {
+		total += uint32(w)
+		if _, ok := signers[validator]; ok {
+			weight += uint32(w)
+		}
+	}
+	return total > 0 && weight*3 > total*2, nil
+}
+
+// newBlockSigners returns the signers the block, which extends the main chain,
+// adds to the recorded ones: its proposer for the block itself, and the
+// signers of the recent blocks it includes.  The signatures of the blocks whose
+// signers are no longer tracked are left out.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) newBlockSigners(node *blockNode, block *asiutil.Block) map[*blockNode]map[common.Address]struct{} {
+	added := map[*blockNode]map[common.Address]struct{}{
+		node: {node.coinbase: {}},
+	}
+	for _, sign := range block.MsgBlock().PreBlockSigs {
+		target := node.Ancestor(sign.BlockHeight)
+		if target == nil || target.hash != sign.BlockHash {
+			continue
+		}
+		if _, ok := b.blockSigners[target.hash]; !ok && target != node {
+			continue
+		}
+		if added[target] == nil {
+			added[target] = make(map[common.Address]struct{})
+		}
+		added[target][sign.Signer] = struct{}{}
+	}
+	return added
+}
+
+// finalizedBySigners returns the highest block which becomes final once the
+// new signers are recorded, nil when none does.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) finalizedBySigners(added map[*blockNode]map[common.Address]struct{}) (*blockNode, error) {
+	var finalized *blockNode
+	for candidate, newSigners := range added {
+		if candidate.height <= b.finalized.height ||
+			(finalized != nil && candidate.height <= finalized.height) {
+			continue
+		}
+		signers := make(map[common.Address]struct{})
+		for signer := range b.blockSigners[candidate.hash] {
+			signers[signer] = struct{}{}
+		}
+		for signer := range newSigners {
+			signers[signer] = struct{}{}
+		}
+		final, err := b.isFinal(candidate, signers)
+		if err != nil {
+			return nil, err
+		}
+		if final {
+			finalized = candidate
+		}
+	}
+	return finalized, nil
+}
+
+// addBlockSigners records the new signers of the block, which is now the tip
+// of the main chain, and forgets the signers of the block falling out of the
+// tracked depth.  It is called once the block is committed, so a failed
+// connection leaves the recorded signers untouched.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) addBlockSigners(node *blockNode, added map[*blockNode]map[common.Address]struct{}) {
+	for target, newSigners := range added {
+		signers, ok := b.blockSigners[target.hash]
+		if !ok {
+			signers = make(map[common.Address]struct{})
+			b.blockSigners[target.hash] = signers
+		}
+		for signer := range newSigners {
+			signers[signer] = struct{}{}
+		}
+	}
+	if old := node.Ancestor(node.height - finalityDepth); old != nil {
+		delete(b.blockSigners, old.hash)
+	}
+}
+
+// removeBlockSigners forgets the proposer of the block, which is detached
+// from the main chain, and the signers of the blocks it includes.  Blocks
+// which are final stay final.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) removeBlockSigners(node *blockNode, block *asiutil.Block) {
+	delete(b.blockSigners, node.hash)
+	for _, sign := range block.MsgBlock().PreBlockSigs {
+		target := node.Ancestor(sign.BlockHeight)
+		if target == nil || target.hash != sign.BlockHash {
+			continue
+		}
+		if signers, ok := b.blockSigners[target.hash]; ok {
+			delete(signers, sign.Signer)
+		}
+	}
+}
+
+// checkFinalizedFork ensures a chain ending at the passed node contains the
+// finalized block, so the main chain never reorganizes below it.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) checkFinalizedFork(node *blockNode) error {
+	if b.finalized == nil || node.Ancestor(b.finalized.height) == b.finalized {
+		return nil
+	}
+	str := fmt.Sprintf("block %v at height %d does not descend from the "+
+		"finalized block %v at height %d", node.hash, node.height,
+		b.finalized.hash, b.finalized.height)
+	return ruleError(ErrForkBelowFinalized, str)
+}
+
+// initFinality loads the last finalized block and rebuilds the signers of the
+// recent main chain blocks.  The genesis block, or the snapshot block the
+// chain was bootstrapped from, is final.
+func (b *BlockChain) initFinality() error {
+	b.blockSigners = make(map[common.Hash]map[common.Address]struct{})
+	b.finalized = b.bestChain.Genesis()
+	if b.snapshotBase != nil {
+		b.finalized = b.snapshotBase
+	}
+
+	tip := b.bestChain.Tip()
+	start := tip.height - finalityDepth + 1
+	if start <= b.finalized.height {
+		start = b.finalized.height + 1
+	}
+	return b.db.View(func(dbTx database.Tx) error {
+		hash, _, err := dbFetchFinalizedBlock(dbTx)
+		if err != nil {
+			return err
+		}
+		if hash != nil {
+			node := b.index.LookupNode(hash)
+			if node == nil || !b.bestChain.Contains(node) {
+				return common.AssertError(fmt.Sprintf("initFinality: "+
+					"finalized block %s is not in the main chain", hash))
+			}
+			if node.height > b.finalized.height {
+				b.finalized = node
+			}
+		}
+
+		for height := start; height <= tip.height; height++ {
+			node := b.bestChain.NodeByHeight(height)
+			block, err := dbFetchBlockByNode(dbTx, node)
+			if err != nil {
+				return err
+			}
+			added := b.newBlockSigners(node, block)
+			finalized, err := b.finalizedBySigners(added)
+			if err != nil {
+				return err
+			}
+			b.addBlockSigners(node, added)
+			if finalized != nil {
+				b.finalized = finalized
+			}
+		}
+		log.Infof("Finalized block %v (height %d)", b.finalized.hash,
+			b.finalized.height)
+		return nil
+	})
+}
+
+// GetFinalizedBlock returns the hash and the height of the last finalized
+// block.  A block is final once the validators holding more than two thirds
+// of the weight of its round signed it on chain, and the main chain never
+// reorganizes below it.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) GetFinalizedBlock() (*common.Hash, int32) {
+	b.chainLock.RLock()
+	finalized := b.finalized
+	b.chainLock.RUnlock()
+	return &finalized.hash, finalized.height
+}
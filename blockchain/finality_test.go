@@ -0,0 +1,104 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// TestFinality ensures blocks signed by more than two thirds of the weight of
+// their round become final, the finalized block is persisted and no block
+// forking the main chain before it is accepted.
+func TestFinality(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	if hash, height := chain.GetFinalizedBlock(); height != 0 ||
+		*hash != chain.bestChain.Genesis().hash {
+		t.Fatalf("GetFinalizedBlock: genesis is not final, got %v at %d", hash, height)
+	}
+
+	// The single validator holds the whole weight, so every block it
+	// proposes is final once connected.
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	for slot := uint16(0); slot < 2; slot++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+			chain, 1, slot, chain.bestChain.height(), protos.Asset{}, 0,
+			validators[slot], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err := chain.ProcessBlock(block, nil, nil, nil, 1); err != nil {
+			t.Fatalf("ProcessBlock error %v", err)
+		}
+	}
+	tip := chain.bestChain.tip()
+	if signers := chain.blockSigners[tip.hash]; len(signers) != 1 {
+		t.Fatalf("got signers %v of the tip, want its proposer", signers)
+	}
+
+	// Finding the block new signers finalize records nothing.
+	child := &blockNode{parent: tip, hash: common.Hash{0x01}, height: tip.height + 1,
+		round: tip.round, coinbase: tip.coinbase}
+	finalized, err := chain.finalizedBySigners(
+		chain.newBlockSigners(child, asiutil.NewBlock(&protos.MsgBlock{})))
+	if err != nil || finalized != child {
+		t.Fatalf("finalizedBySigners: got %v, %v want the child", finalized, err)
+	}
+	if _, ok := chain.blockSigners[child.hash]; ok {
+		t.Fatalf("finalizedBySigners: the signers of the child are recorded")
+	}
+
+	hash, height := chain.GetFinalizedBlock()
+	if height != tip.height || *hash != tip.hash {
+		t.Fatalf("GetFinalizedBlock: got %v at %d, want the tip %v at %d",
+			hash, height, tip.hash, tip.height)
+	}
+	err = chain.db.View(func(dbTx database.Tx) error {
+		stored, storedHeight, err := dbFetchFinalizedBlock(dbTx)
+		if err == nil && (stored == nil || *stored != tip.hash || storedHeight != tip.height) {
+			t.Errorf("dbFetchFinalizedBlock: got %v at %d", stored, storedHeight)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("dbFetchFinalizedBlock error %v", err)
+	}
+
+	// Neither a block nor a reorganization may fork the main chain before
+	// the finalized block.
+	if err := chain.checkFinalizedFork(tip.parent); !isRuleError(err, ErrForkBelowFinalized) {
+		t.Fatalf("checkFinalizedFork: unexpected error %v", err)
+	}
+	if err := chain.checkFinalizedFork(tip); err != nil {
+		t.Fatalf("checkFinalizedFork: unexpected error %v", err)
+	}
+	fork, _, err := createAndSignBlock(netParam, accList, validators, filters,
+		chain, 1, 1, tip.parent.height, protos.Asset{}, 0,
+		validators[1], nil, 1, tip.parent)
+	if err != nil {
+		t.Fatalf("create block error %v", err)
+	}
+	if _, _, err := chain.ProcessBlock(fork, nil, nil, nil, 1); !isRuleError(err, ErrForkBelowFinalized) {
+		t.Fatalf("ProcessBlock: unexpected error %v", err)
+	}
+}
+
+// isRuleError returns whether the error is a rule error with the passed code.
+func isRuleError(err error, code ErrorCode) bool {
+	rerr, ok := err.(RuleError)
+	return ok && rerr.ErrorCode == code
+}
@@ -0,0 +1,700 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package indexers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rawdb"
+)
+
+const (
+	// assetIndexName is the human-readable name for the index.
+	assetIndexName = "asset registry index"
+
+	// assetKeyInfo is the key kind of the entries which describe an asset.
+	assetKeyInfo = byte(0x00)
+
+	// assetKeyOrg is the key kind of the entries which map an organization
+	// to the assets it created.
+	assetKeyOrg = byte(0x01)
+
+	// assetKeySeq is the key kind of the entries which order the assets by
+	// creation.
+	assetKeySeq = byte(0x02)
+
+	// assetKeyMint is the key kind of the entries which record the mints of
+	// an asset.
+	assetKeyMint = byte(0x03)
+
+	// assetInfoSize is the size of a serialized asset info entry.
+	assetInfoSize = 4 + common.HashLength + 4 + 4
+
+	// assetMintSize is the size of a serialized mint entry.
+	assetMintSize = common.HashLength + 8
+)
+
+var (
+	// assetIndexKey is the key of the asset registry index and the db bucket
+	// used to house it.
+	assetIndexKey = []byte("assetregidx")
+
+	// assetCountKey is the key of the entry holding the number of indexed
+	// assets.
+	assetCountKey = []byte{0xff}
+)
+
+// -----------------------------------------------------------------------------
+// The asset registry index records every asset created on the main chain along
+// with its mints.  Creations are read from the stored receipts of the block, so
+// that the assets created without any initial issuance are recorded too.
+// Mints are found in the virtual transactions spending the transfer creation
+// outpoint, the minted amount of an asset being the difference between the
+// outputs and the inputs of the virtual transaction for that asset.  The
+// initial issuance of an asset is its first mint.
+//
+// All entries live in a single bucket and are distinguished by their first
+// byte.
+//
+//   Kind  Key                                              Value
+//   0x00  <asset>                                          info
+//   0x01  <organization id><asset>                         (empty)
+//   0x02  <sequence>                                       asset
+//   0x03  <asset><block height><vtx index><mint index>     mint
+//   0xff                                                   number of assets
+//
+// The serialized format of an info entry is:
+//
+//   <creation height><creation tx hash><sequence><number of mints>
+//
+// The serialized format of a mint entry is:
+//
+//   <tx hash><amount>
+//
+// The tx hash is the hash of the transaction whose execution created or minted
+// the asset.  The amount is the voucher id for indivisible assets.  All numbers
+// in keys are big endian so that the entries are ordered.
+// -----------------------------------------------------------------------------
+
+// AssetInfo describes an asset recorded by the asset registry index.
+type AssetInfo struct {
+	Asset          protos.Asset
+	CreationHeight int32
+	CreationTx     common.Hash
+	Sequence       uint32
+	Mints          uint32
+}
+
+// OrganizationId returns the id of the organization which created the asset.
+func (info *AssetInfo) OrganizationId() uint32 {
+	_, orgId, _ := info.Asset.AssetFields()
+	return orgId
+}
+
+// AssetMint describes a creation or a mint of an asset.
+type AssetMint struct {
+	Height int32
+	TxHash common.Hash
+	Amount int64
+}
+
+// assetCreation is a creation found in a block.
+type assetCreation struct {
+	asset  protos.Asset
+	txHash common.Hash
+}
+
+// assetMint is a mint found in a block.
+type assetMint struct {
+	asset  protos.Asset
+	vtxIdx uint32
+	txHash common.Hash
+	amount int64
+}
+
+// assetInfoKey returns the key of the info entry of the passed asset.
+func assetInfoKey(asset []byte) []byte {
+	key := make([]byte, 1+common.AssetLength)
+	key[0] = assetKeyInfo
+	copy(key[1:], asset)
+	return key
+}
+
+// assetOrgKey returns the key of the entry mapping the organization of the
+// passed asset to it.
+func assetOrgKey(asset *protos.Asset) []byte {
+	_, orgId, _ := asset.AssetFields()
+	key := make([]byte, 1+4+common.AssetLength)
+	key[0] = assetKeyOrg
+	binary.BigEndian.PutUint32(key[1:], orgId)
+	copy(key[5:], asset.Bytes())
+	return key
+}
+
+// assetSeqKey returns the key of the entry of the asset with the passed
+// creation sequence.
+func assetSeqKey(seq uint32) []byte {
+	key := make([]byte, 1+4)
+	key[0] = assetKeySeq
+	binary.BigEndian.PutUint32(key[1:], seq)
+	return key
+}
+
+// assetMintKey returns the key of the entry of a mint of the passed asset.
+func assetMintKey(asset []byte, height int32, vtxIdx uint32, mintIdx uint32) []byte {
+	key := make([]byte, 1+common.AssetLength+12)
+	key[0] = assetKeyMint
+	copy(key[1:], asset)
+	binary.BigEndian.PutUint32(key[1+common.AssetLength:], uint32(height))
+	binary.BigEndian.PutUint32(key[5+common.AssetLength:], vtxIdx)
+	binary.BigEndian.PutUint32(key[9+common.AssetLength:], mintIdx)
+	return key
+}
+
+// serializeAssetInfo returns the passed asset info serialized for storage.
+func serializeAssetInfo(info *AssetInfo) []byte {
+	serialized := make([]byte, assetInfoSize)
+	byteOrder.PutUint32(serialized, uint32(info.CreationHeight))
+	copy(serialized[4:], info.CreationTx[:])
+	byteOrder.PutUint32(serialized[4+common.HashLength:], info.Sequence)
+	byteOrder.PutUint32(serialized[8+common.HashLength:], info.Mints)
+	return serialized
+}
+
+// deserializeAssetInfo returns the asset info of the passed serialized entry
+// or nil when the entry is missing.
+func deserializeAssetInfo(asset []byte, serialized []byte) *AssetInfo {
+	if len(serialized) < assetInfoSize {
+		return nil
+	}
+	info := &AssetInfo{
+		Asset:          *protos.AssetFromBytes(asset),
+		CreationHeight: int32(byteOrder.Uint32(serialized)),
+		Sequence:       byteOrder.Uint32(serialized[4+common.HashLength:]),
+		Mints:          byteOrder.Uint32(serialized[8+common.HashLength:]),
+	}
+	copy(info.CreationTx[:], serialized[4:])
+	return info
+}
+
+// vtxSpentTxOuts returns the outputs spent by each virtual transaction of the
+// passed block.  The spent outputs are ordered the same way the addrindex
+// walks them: the inputs of each transaction of the block are followed by the
+// inputs of the virtual transactions it caused, then the auto merge virtual
+// transactions follow.
+func vtxSpentTxOuts(block *asiutil.Block, stxos []txo.SpentTxOut,
+	vblock *asiutil.VBlock) [][]txo.SpentTxOut {
+
+	vtxs := vblock.MsgVBlock().VTransactions
+	spent := make([][]txo.SpentTxOut, len(vtxs))
+	txLen := len(block.Transactions())
+	coinbaseIdx := txLen - 1
+	stxoIndex := 0
+
+	// consume returns the outputs spent by the passed virtual transaction
+	// and advances the stxo counter.
+	consume := func(vtx *protos.MsgTx) []txo.SpentTxOut {
+		start := stxoIndex
+		for _, txIn := range vtx.TxIn {
+			if asiutil.IsMintOrCreateInput(txIn) {
+				continue
+			}
+			stxoIndex++
+		}
+		if stxoIndex > len(stxos) {
+			stxoIndex = len(stxos)
+		}
+		return stxos[start:stxoIndex]
+	}
+
+	vtxIdx := 0
+	for txIdx, tx := range block.Transactions() {
+		if txIdx < coinbaseIdx {
+			stxoIndex += len(tx.MsgTx().TxIn)
+		}
+		for ; vtxIdx < len(vtxs); vtxIdx++ {
+			vtx := vtxs[vtxIdx]
+			if vtx.Version < uint32(txIdx) {
+				continue
+			}
+			if vtx.Version > uint32(txIdx) {
+				break
+			}
+			spent[vtxIdx] = consume(vtx)
+		}
+	}
+	for ; vtxIdx < len(vtxs); vtxIdx++ {
+		vtx := vtxs[vtxIdx]
+		if vtx.Version < uint32(txLen) {
+			continue
+		}
+		spent[vtxIdx] = consume(vtx)
+	}
+	return spent
+}
+
+// blockMints returns the creations and mints of assets made by the passed
+// block, ordered by virtual transaction and asset.
+func blockMints(block *asiutil.Block, stxos []txo.SpentTxOut,
+	vblock *asiutil.VBlock) []assetMint {
+
+	if vblock == nil {
+		return nil
+	}
+
+	var mints []assetMint
+	txs := block.Transactions()
+	spent := vtxSpentTxOuts(block, stxos, vblock)
+	for vtxIdx, vtx := range vblock.MsgVBlock().VTransactions {
+		isMint := false
+		for _, txIn := range vtx.TxIn {
+			if asiutil.IsMintOrCreateInput(txIn) {
+				isMint = true
+				break
+			}
+		}
+		if !isMint {
+			continue
+		}
+
+		var txHash common.Hash
+		if int(vtx.Version) < len(txs) {
+			txHash = *txs[vtx.Version].Hash()
+		}
+
+		// Collect the divisible amounts and the vouchers which flow in
+		// and out of the virtual transaction.
+		amounts := make(map[protos.Asset]int64)
+		vouchersIn := make(map[protos.Asset]map[int64]struct{})
+		for _, stxo := range spent[vtxIdx] {
+			if stxo.Asset == nil {
+				continue
+			}
+			if stxo.Asset.IsIndivisible() {
+				if vouchersIn[*stxo.Asset] == nil {
+					vouchersIn[*stxo.Asset] = make(map[int64]struct{})
+				}
+				vouchersIn[*stxo.Asset][stxo.Amount] = struct{}{}
+				continue
+			}
+			amounts[*stxo.Asset] -= stxo.Amount
+		}
+
+		var found []assetMint
+		for _, txOut := range vtx.TxOut {
+			if txOut.Asset.IsIndivisible() {
+				if _, ok := vouchersIn[txOut.Asset][txOut.Value]; !ok && txOut.Value > 0 {
+					found = append(found, assetMint{
+						asset:  txOut.Asset,
+						vtxIdx: uint32(vtxIdx),
+						txHash: txHash,
+						amount: txOut.Value,
+					})
+				}
+				continue
+			}
+			amounts[txOut.Asset] += txOut.Value
+		}
+		for asset, amount := range amounts {
+			if amount <= 0 {
+				continue
+			}
+			found = append(found, assetMint{
+				asset:  asset,
+				vtxIdx: uint32(vtxIdx),
+				txHash: txHash,
+				amount: amount,
+			})
+		}
+
+		// Order the mints of the virtual transaction deterministically.
+		sort.SliceStable(found, func(i, j int) bool {
+			a, b := found[i].asset.Bytes(), found[j].asset.Bytes()
+			if c := bytes.Compare(a, b); c != 0 {
+				return c < 0
+			}
+			return found[i].amount < found[j].amount
+		})
+		mints = append(mints, found...)
+	}
+	return mints
+}
+
+// blockCreations returns the assets created by the passed block, as recorded
+// in its stored receipts, ordered by transaction and asset.
+func (idx *AssetIndex) blockCreations(block *asiutil.Block) []assetCreation {
+	if idx.ethDB == nil {
+		return nil
+	}
+	var creations []assetCreation
+	receipts := rawdb.ReadReceipts(idx.ethDB, *block.Hash(), uint64(block.Height()))
+	for _, receipt := range receipts {
+		for _, asset := range receipt.CreatedAssets {
+			if len(asset) != common.AssetLength {
+				continue
+			}
+			creations = append(creations, assetCreation{
+				asset:  *protos.AssetFromBytes(asset),
+				txHash: receipt.TxHash,
+			})
+		}
+	}
+	return creations
+}
+
+// AssetIndex implements an asset registry index.  It records every asset
+// created on the main chain with its organization, divisibility and mints, so
+// the assets can be discovered without knowing their ids up front.
+type AssetIndex struct {
+	db    database.Transactor
+	ethDB rawdb.DatabaseReader
+}
+
+// Ensure the AssetIndex type implements the Indexer interface.
+var _ blockchain.Indexer = (*AssetIndex)(nil)
+
+// Ensure the AssetIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*AssetIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *AssetIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *AssetIndex) Init() error {
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *AssetIndex) Key() []byte {
+	return assetIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *AssetIndex) Name() string {
+	return assetIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the asset
+// registry index.
+//
+// This is part of the Indexer interface.
+func (idx *AssetIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(assetIndexKey)
+	return err
+}
+
+// Checking if there is a new bucket added to this indexer
+// This method is invoked each time when node started.
+func (idx *AssetIndex) Check(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucketIfNotExists(assetIndexKey)
+	return err
+}
+
+// dbFetchAssetCount returns the number of assets in the index.
+func dbFetchAssetCount(bucket database.Bucket) uint32 {
+	serialized := bucket.Get(assetCountKey)
+	if len(serialized) < 4 {
+		return 0
+	}
+	return byteOrder.Uint32(serialized)
+}
+
+// dbPutAssetCount stores the number of assets in the index.
+func dbPutAssetCount(bucket database.Bucket, count uint32) error {
+	var serialized [4]byte
+	byteOrder.PutUint32(serialized[:], count)
+	return bucket.Put(assetCountKey, serialized[:])
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer records the assets created by the
+// block and the mints it made.
+//
+// This is part of the Indexer interface.
+func (idx *AssetIndex) ConnectBlock(dbTx database.Tx, block *asiutil.Block,
+	stxos []txo.SpentTxOut, vblock *asiutil.VBlock) error {
+
+	creations := idx.blockCreations(block)
+	mints := blockMints(block, stxos, vblock)
+	if len(creations) == 0 && len(mints) == 0 {
+		return nil
+	}
+
+	bucket := dbTx.Metadata().Bucket(assetIndexKey)
+	count := dbFetchAssetCount(bucket)
+	height := block.Height()
+	putCreation := func(asset *protos.Asset, txHash *common.Hash) (*AssetInfo, error) {
+		info := deserializeAssetInfo(asset.Bytes(), bucket.Get(assetInfoKey(asset.Bytes())))
+		if info != nil {
+			return info, nil
+		}
+		info = &AssetInfo{
+			Asset:          *asset,
+			CreationHeight: height,
+			CreationTx:     *txHash,
+			Sequence:       count,
+		}
+		count++
+		if err := bucket.Put(assetOrgKey(asset), []byte{}); err != nil {
+			return nil, err
+		}
+		if err := bucket.Put(assetSeqKey(info.Sequence), asset.Bytes()); err != nil {
+			return nil, err
+		}
+		err := bucket.Put(assetInfoKey(asset.Bytes()), serializeAssetInfo(info))
+		return info, err
+	}
+
+	for _, creation := range creations {
+		if _, err := putCreation(&creation.asset, &creation.txHash); err != nil {
+			return err
+		}
+	}
+
+	mintIdx := make(map[protos.Asset]uint32)
+	for _, mint := range mints {
+		// The creation of the asset is missing from the receipts stored
+		// before they recorded the created assets.
+		info, err := putCreation(&mint.asset, &mint.txHash)
+		if err != nil {
+			return err
+		}
+		asset := mint.asset.Bytes()
+		info.Mints++
+		if err := bucket.Put(assetInfoKey(asset), serializeAssetInfo(info)); err != nil {
+			return err
+		}
+
+		serialized := make([]byte, assetMintSize)
+		copy(serialized, mint.txHash[:])
+		byteOrder.PutUint64(serialized[common.HashLength:], uint64(mint.amount))
+		key := assetMintKey(asset, height, mint.vtxIdx, mintIdx[mint.asset])
+		mintIdx[mint.asset]++
+		if err := bucket.Put(key, serialized); err != nil {
+			return err
+		}
+	}
+	return dbPutAssetCount(bucket, count)
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the mints made by
+// the block and the assets it created.
+//
+// This is part of the Indexer interface.
+func (idx *AssetIndex) DisconnectBlock(dbTx database.Tx, block *asiutil.Block,
+	stxos []txo.SpentTxOut, vblock *asiutil.VBlock) error {
+
+	creations := idx.blockCreations(block)
+	mints := blockMints(block, stxos, vblock)
+	if len(creations) == 0 && len(mints) == 0 {
+		return nil
+	}
+
+	bucket := dbTx.Metadata().Bucket(assetIndexKey)
+	height := block.Height()
+	mintIdx := make(map[protos.Asset]uint32)
+	for _, mint := range mints {
+		asset := mint.asset.Bytes()
+		key := assetMintKey(asset, height, mint.vtxIdx, mintIdx[mint.asset])
+		mintIdx[mint.asset]++
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+
+		info := deserializeAssetInfo(asset, bucket.Get(assetInfoKey(asset)))
+		if info == nil || info.Mints == 0 {
+			continue
+		}
+		info.Mints--
+		if err := bucket.Put(assetInfoKey(asset), serializeAssetInfo(info)); err != nil {
+			return err
+		}
+	}
+
+	// Remove the assets created by this block.
+	count := dbFetchAssetCount(bucket)
+	assets := make([]protos.Asset, 0, len(creations)+len(mints))
+	for _, creation := range creations {
+		assets = append(assets, creation.asset)
+	}
+	for _, mint := range mints {
+		assets = append(assets, mint.asset)
+	}
+	for i := range assets {
+		asset := assets[i].Bytes()
+		info := deserializeAssetInfo(asset, bucket.Get(assetInfoKey(asset)))
+		if info == nil || info.CreationHeight != height {
+			continue
+		}
+		if err := bucket.Delete(assetInfoKey(asset)); err != nil {
+			return err
+		}
+		if err := bucket.Delete(assetOrgKey(&assets[i])); err != nil {
+			return err
+		}
+		if err := bucket.Delete(assetSeqKey(info.Sequence)); err != nil {
+			return err
+		}
+		count--
+	}
+	return dbPutAssetCount(bucket, count)
+}
+
+// FetchBlockRegion is only provided to satisfy the Indexer interface, the
+// asset registry index does not map keys to block regions.
+//
+// This is part of the Indexer interface.
+func (idx *AssetIndex) FetchBlockRegion([]byte) (*database.BlockRegion, error) {
+	return nil, nil
+}
+
+// Asset returns the information recorded for the passed asset, or nil when
+// the asset was never minted on the main chain.
+//
+// This function is safe for concurrent access.
+func (idx *AssetIndex) Asset(asset *protos.Asset) (*AssetInfo, error) {
+	var info *AssetInfo
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(assetIndexKey)
+		assetBytes := asset.Bytes()
+		info = deserializeAssetInfo(assetBytes, bucket.Get(assetInfoKey(assetBytes)))
+		return nil
+	})
+	return info, err
+}
+
+// Assets returns at most count assets in the order they were created,
+// skipping the first offset ones, along with the total number of assets.
+//
+// This function is safe for concurrent access.
+func (idx *AssetIndex) Assets(offset, count uint32) ([]*AssetInfo, uint32, error) {
+	assets := make([]*AssetInfo, 0)
+	var total uint32
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(assetIndexKey)
+		total = dbFetchAssetCount(bucket)
+
+		cursor := bucket.Cursor()
+		for ok := cursor.Seek(assetSeqKey(offset)); ok && uint32(len(assets)) < count; ok = cursor.Next() {
+			key := cursor.Key()
+			if len(key) != 5 || key[0] != assetKeySeq {
+				break
+			}
+			asset := cursor.Value()
+			info := deserializeAssetInfo(asset, bucket.Get(assetInfoKey(asset)))
+			if info != nil {
+				assets = append(assets, info)
+			}
+		}
+		return nil
+	})
+	return assets, total, err
+}
+
+// OrganizationAssets returns the assets created by the organization with the
+// passed id.
+//
+// This function is safe for concurrent access.
+func (idx *AssetIndex) OrganizationAssets(orgId uint32) ([]*AssetInfo, error) {
+	assets := make([]*AssetInfo, 0)
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(assetIndexKey)
+		prefix := make([]byte, 5)
+		prefix[0] = assetKeyOrg
+		binary.BigEndian.PutUint32(prefix[1:], orgId)
+
+		cursor := bucket.Cursor()
+		for ok := cursor.Seek(prefix); ok; ok = cursor.Next() {
+			key := cursor.Key()
+			if len(key) != len(prefix)+common.AssetLength || !bytes.HasPrefix(key, prefix) {
+				break
+			}
+			asset := key[len(prefix):]
+			info := deserializeAssetInfo(asset, bucket.Get(assetInfoKey(asset)))
+			if info != nil {
+				assets = append(assets, info)
+			}
+		}
+		return nil
+	})
+	return assets, err
+}
+
+// AssetMints returns at most count creations and mints of the passed asset in
+// chain order, skipping the first offset ones.
+//
+// This function is safe for concurrent access.
+func (idx *AssetIndex) AssetMints(asset *protos.Asset, offset, count uint32) ([]AssetMint, error) {
+	mints := make([]AssetMint, 0)
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(assetIndexKey)
+		prefix := make([]byte, 1+common.AssetLength)
+		prefix[0] = assetKeyMint
+		copy(prefix[1:], asset.Bytes())
+
+		skipped := uint32(0)
+		cursor := bucket.Cursor()
+		for ok := cursor.Seek(prefix); ok && uint32(len(mints)) < count; ok = cursor.Next() {
+			key := cursor.Key()
+			if len(key) != len(prefix)+12 || !bytes.HasPrefix(key, prefix) {
+				break
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			value := cursor.Value()
+			if len(value) < assetMintSize {
+				continue
+			}
+			mint := AssetMint{
+				Height: int32(binary.BigEndian.Uint32(key[len(prefix):])),
+				Amount: int64(byteOrder.Uint64(value[common.HashLength:])),
+			}
+			copy(mint.TxHash[:], value)
+			mints = append(mints, mint)
+		}
+		return nil
+	})
+	return mints, err
+}
+
+// NewAssetIndex returns a new instance of an indexer that is used to record
+// the assets created on the chain together with their mints.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewAssetIndex(db database.Transactor, ethDB rawdb.DatabaseReader) *AssetIndex {
+	log.Info("Asset registry index is enabled")
+	return &AssetIndex{db: db, ethDB: ethDB}
+}
+
+// DropAssetIndex drops the asset registry index from the provided database if
+// it exists.
+func DropAssetIndex(db database.Transactor, interrupt <-chan struct{}) error {
+	return dropIndex(db, assetIndexKey, assetIndexName, interrupt)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package indexers
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/mock"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rawdb"
+	"github.com/AsimovNetwork/asimov/txscript"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+)
+
+// TestAssetIndexConnectBlock ensures the asset registry index finds the
+// creations and mints of a block.
+func TestAssetIndexConnectBlock(t *testing.T) {
+	t.Parallel()
+
+	user, _ := common.NewAddressWithId(common.PubKeyHashAddrID, []byte{01, 01, 01, 01})
+	org, _ := common.NewAddressWithId(common.ContractHashAddrID, []byte{11, 11, 11, 11})
+	userScript, _ := txscript.PayToAddrScript(user)
+	orgScript, _ := txscript.PayToAddrScript(org)
+	coin := protos.NewAsset(0, 7, 1)
+	voucher := protos.NewAsset(protos.InDivisibleAsset, 7, 2)
+	share := protos.NewAsset(0, 7, 3)
+
+	// The user calls the organization which mints 100 coins and keeps 60
+	// of them, it also mints voucher 5 and transfers voucher 3 the user
+	// owned to itself.  It also creates shares without any issuance.
+	tx := protos.NewMsgTx(0)
+	tx.AddTxIn(&protos.TxIn{PreviousOutPoint: protos.OutPoint{Index: 0}})
+	tx.AddTxOut(protos.NewTxOut(10, userScript, asiutil.AsimovAsset))
+	coinbase := protos.NewMsgTx(0)
+	coinbase.AddTxIn(&protos.TxIn{})
+	pblock := protos.MsgBlock{}
+	pblock.Header.Height = 20
+	pblock.AddTransaction(tx)
+	pblock.AddTransaction(coinbase)
+	block := asiutil.NewBlock(&pblock)
+
+	vtx := protos.NewMsgTx(0)
+	vtx.AddTxIn(protos.NewTxIn(protos.NewOutPoint(&common.Hash{}, asiutil.TransferCreationIdx), nil))
+	vtx.AddTxIn(&protos.TxIn{PreviousOutPoint: protos.OutPoint{Index: 1}})
+	vtx.AddTxOut(protos.NewTxOut(60, orgScript, *coin))
+	vtx.AddTxOut(protos.NewTxOut(40, userScript, *coin))
+	vtx.AddTxOut(protos.NewTxOut(5, orgScript, *voucher))
+	vtx.AddTxOut(protos.NewTxOut(3, orgScript, *voucher))
+	pvblock := protos.MsgVBlock{}
+	pvblock.AddTransaction(vtx)
+	vblock := asiutil.NewVBlock(&pvblock, block.Hash())
+
+	stxos := []txo.SpentTxOut{
+		{Amount: 20, PkScript: userScript, Asset: &asiutil.AsimovAsset},
+		{Amount: 3, PkScript: userScript, Asset: voucher},
+	}
+
+	mints := blockMints(block, stxos, vblock)
+	want := []assetMint{
+		{asset: *coin, txHash: tx.TxHash(), amount: 100},
+		{asset: *voucher, txHash: tx.TxHash(), amount: 5},
+	}
+	if len(mints) != len(want) {
+		t.Fatalf("unexpected number of mints - got %d, want %d",
+			len(mints), len(want))
+	}
+	for i := range want {
+		if mints[i] != want[i] {
+			t.Errorf("unexpected mint #%d - got %+v, want %+v", i,
+				mints[i], want[i])
+		}
+	}
+
+	ethDB := ethdb.NewMemDatabase()
+	rawdb.WriteReceipts(ethDB, *block.Hash(), uint64(block.Height()), types.Receipts{
+		{TxHash: tx.TxHash(), CreatedAssets: [][]byte{
+			coin.Bytes(), share.Bytes(), voucher.Bytes(),
+		}},
+		{TxHash: coinbase.TxHash()},
+	})
+
+	dbTx := mock.NewMockTx()
+	bucket, _ := dbTx.Metadata().CreateBucket(assetIndexKey)
+	idx := NewAssetIndex(nil, ethDB)
+	if err := idx.ConnectBlock(dbTx, block, stxos, vblock); err != nil {
+		t.Fatalf("ConnectBlock: unexpected error: %v", err)
+	}
+
+	if count := dbFetchAssetCount(bucket); count != 3 {
+		t.Errorf("unexpected number of assets - got %d, want 3", count)
+	}
+	info := deserializeAssetInfo(coin.Bytes(), bucket.Get(assetInfoKey(coin.Bytes())))
+	if info == nil || info.CreationHeight != 20 || info.Mints != 1 ||
+		info.OrganizationId() != 7 || info.Sequence != 0 {
+		t.Errorf("unexpected asset info %+v", info)
+	}
+	if bucket.Get(assetOrgKey(voucher)) == nil {
+		t.Errorf("missing organization entry of the voucher")
+	}
+	if asset := bucket.Get(assetSeqKey(2)); string(asset) != string(voucher.Bytes()) {
+		t.Errorf("unexpected asset at sequence 2 - got %x, want %x",
+			asset, voucher.Bytes())
+	}
+
+	// The shares are indexed although they were never minted.
+	info = deserializeAssetInfo(share.Bytes(), bucket.Get(assetInfoKey(share.Bytes())))
+	if info == nil || info.CreationHeight != 20 || info.Mints != 0 ||
+		info.CreationTx != tx.TxHash() || info.Sequence != 1 {
+		t.Errorf("unexpected share info %+v", info)
+	}
+
+	// Disconnecting the block removes every asset it created.
+	if err := idx.DisconnectBlock(dbTx, block, stxos, vblock); err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	if count := dbFetchAssetCount(bucket); count != 0 {
+		t.Errorf("unexpected number of assets - got %d, want 0", count)
+	}
+	for _, asset := range []*protos.Asset{coin, share, voucher} {
+		if bucket.Get(assetInfoKey(asset.Bytes())) != nil {
+			t.Errorf("asset %v is still indexed", asset)
+		}
+		if bucket.Get(assetOrgKey(asset)) != nil {
+			t.Errorf("organization entry of asset %v is still indexed", asset)
+		}
+	}
+}
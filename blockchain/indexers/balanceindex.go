@@ -0,0 +1,592 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package indexers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+const (
+	// balanceIndexName is the human-readable name for the index.
+	balanceIndexName = "asset balance index"
+
+	// balanceKeyHistory is the key kind of the entries which record the
+	// balance of an address after each block changing it.
+	balanceKeyHistory = byte(0x00)
+
+	// balanceKeyCurrent is the key kind of the entries which hold the
+	// current balance of an address.
+	balanceKeyCurrent = byte(0x01)
+
+	// balanceKeyRank is the key kind of the entries which order the holders
+	// of an asset by descending balance.
+	balanceKeyRank = byte(0x02)
+
+	// balanceKeySupplyHistory is the key kind of the entries which record
+	// the supply of an asset after each block changing it.
+	balanceKeySupplyHistory = byte(0x03)
+
+	// balanceKeySupply is the key kind of the entries which hold the current
+	// supply of an asset.
+	balanceKeySupply = byte(0x04)
+
+	// supplyValueSize is the size of a serialized supply entry.
+	supplyValueSize = 8 + 4
+)
+
+var (
+	// balanceIndexKey is the key of the balance index and the db bucket used
+	// to house it.
+	balanceIndexKey = []byte("balbyaddridx")
+)
+
+// -----------------------------------------------------------------------------
+// The balance index records the balance every address holds of every asset
+// after each block which changed it, along with the total supply held by
+// addresses.  The balance of an indivisible asset is the number of vouchers
+// held.
+//
+// All entries live in a single bucket and are distinguished by their first
+// byte.  Heights are serialized big endian so that the entries of an item are
+// ordered by height.
+//
+//   Kind  Key                                        Value
+//   0x00  <address><asset><block height>             balance (int64)
+//   0x01  <asset><address>                           balance (int64)
+//   0x02  <asset><inverted balance><address>         (empty)
+//   0x03  <asset><block height>                      supply (int64), holders (uint32)
+//   0x04  <asset>                                    supply (int64), holders (uint32)
+//
+// Addresses are common.AddressLength bytes and assets common.AssetLength bytes.
+// The inverted balance is the bitwise complement of the balance as a big
+// endian uint64, which orders the holders of an asset by descending balance.
+// -----------------------------------------------------------------------------
+
+// balanceKey identifies the balance of an address for an asset.
+type balanceKey struct {
+	address common.Address
+	asset   [common.AssetLength]byte
+}
+
+// AssetHolder describes the balance an address holds of an asset.
+type AssetHolder struct {
+	Address common.Address
+	Balance int64
+}
+
+// AssetSupply describes the supply of an asset held by addresses after the
+// block at Height.
+type AssetSupply struct {
+	Height  int32
+	Supply  int64
+	Holders uint32
+}
+
+// balanceHistoryKey returns the key of the balance history entry of the passed
+// address and asset at the passed block height.
+func balanceHistoryKey(address *common.Address, asset []byte, height int32) []byte {
+	key := make([]byte, 1+common.AddressLength+common.AssetLength+4)
+	key[0] = balanceKeyHistory
+	copy(key[1:], address[:])
+	copy(key[1+common.AddressLength:], asset)
+	binary.BigEndian.PutUint32(key[1+common.AddressLength+common.AssetLength:], uint32(height))
+	return key
+}
+
+// balanceCurrentKey returns the key of the current balance entry of the passed
+// address and asset.
+func balanceCurrentKey(address *common.Address, asset []byte) []byte {
+	key := make([]byte, 1+common.AssetLength+common.AddressLength)
+	key[0] = balanceKeyCurrent
+	copy(key[1:], asset)
+	copy(key[1+common.AssetLength:], address[:])
+	return key
+}
+
+// balanceRankKey returns the key of the entry ranking the passed address
+// among the holders of the asset.
+func balanceRankKey(address *common.Address, asset []byte, balance int64) []byte {
+	key := make([]byte, 1+common.AssetLength+8+common.AddressLength)
+	key[0] = balanceKeyRank
+	copy(key[1:], asset)
+	binary.BigEndian.PutUint64(key[1+common.AssetLength:], ^uint64(balance))
+	copy(key[1+common.AssetLength+8:], address[:])
+	return key
+}
+
+// supplyHistoryKey returns the key of the supply history entry of the passed
+// asset at the passed block height.
+func supplyHistoryKey(asset []byte, height int32) []byte {
+	key := make([]byte, 1+common.AssetLength+4)
+	key[0] = balanceKeySupplyHistory
+	copy(key[1:], asset)
+	binary.BigEndian.PutUint32(key[1+common.AssetLength:], uint32(height))
+	return key
+}
+
+// supplyKey returns the key of the current supply entry of the passed asset.
+func supplyKey(asset []byte) []byte {
+	key := make([]byte, 1+common.AssetLength)
+	key[0] = balanceKeySupply
+	copy(key[1:], asset)
+	return key
+}
+
+// serializeBalance returns the passed balance serialized for storage.
+func serializeBalance(balance int64) []byte {
+	serialized := make([]byte, 8)
+	byteOrder.PutUint64(serialized, uint64(balance))
+	return serialized
+}
+
+// deserializeBalance returns the balance of the passed serialized entry.  A
+// missing entry stands for a zero balance.
+func deserializeBalance(serialized []byte) int64 {
+	if len(serialized) < 8 {
+		return 0
+	}
+	return int64(byteOrder.Uint64(serialized))
+}
+
+// serializeSupply returns the passed supply and number of holders serialized
+// for storage.
+func serializeSupply(supply int64, holders uint32) []byte {
+	serialized := make([]byte, supplyValueSize)
+	byteOrder.PutUint64(serialized, uint64(supply))
+	byteOrder.PutUint32(serialized[8:], holders)
+	return serialized
+}
+
+// deserializeSupply returns the supply and number of holders of the passed
+// serialized entry.  A missing entry stands for an unknown asset.
+func deserializeSupply(serialized []byte) (int64, uint32) {
+	if len(serialized) < supplyValueSize {
+		return 0, 0
+	}
+	return int64(byteOrder.Uint64(serialized)), byteOrder.Uint32(serialized[8:])
+}
+
+// BalanceIndex implements a per asset balance history index.  It allows
+// querying the balance of an address at any height of the main chain, the
+// holders of an asset ordered by balance and the supply history of an asset.
+type BalanceIndex struct {
+	db database.Transactor
+}
+
+// Ensure the BalanceIndex type implements the Indexer interface.
+var _ blockchain.Indexer = (*BalanceIndex)(nil)
+
+// Ensure the BalanceIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*BalanceIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *BalanceIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) Init() error {
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) Key() []byte {
+	return balanceIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) Name() string {
+	return balanceIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the balance
+// index.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(balanceIndexKey)
+	return err
+}
+
+// Checking if there is a new bucket added to this indexer
+// This method is invoked each time when node started.
+func (idx *BalanceIndex) Check(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucketIfNotExists(balanceIndexKey)
+	return err
+}
+
+// balanceDelta returns the change of balance an output of the passed amount
+// and asset causes.
+func balanceDelta(asset *protos.Asset, amount int64) int64 {
+	if asset.IsIndivisible() {
+		return 1
+	}
+	return amount
+}
+
+// addDelta adds the balance change of an output with the passed script, asset
+// and amount to the passed map.  The sign is -1 for spent outputs.
+func addDelta(deltas map[balanceKey]int64, pkScript []byte, asset *protos.Asset,
+	amount int64, sign int64) {
+
+	if asset == nil || amount <= 0 || txscript.IsUnspendable(pkScript) {
+		return
+	}
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript)
+	if err != nil || len(addrs) == 0 {
+		return
+	}
+
+	key := balanceKey{
+		address: common.Address(addrs[0].StandardAddress()),
+		asset:   asset.FixedBytes(),
+	}
+	deltas[key] += sign * balanceDelta(asset, amount)
+}
+
+// blockDeltas returns the balance changes caused by the transactions of the
+// passed block and virtual block.  Changes which cancel out are omitted.
+func blockDeltas(block *asiutil.Block, stxos []txo.SpentTxOut,
+	vblock *asiutil.VBlock) map[balanceKey]int64 {
+
+	deltas := make(map[balanceKey]int64)
+	for i := range stxos {
+		stxo := &stxos[i]
+		addDelta(deltas, stxo.PkScript, stxo.Asset, stxo.Amount, -1)
+	}
+	for _, tx := range block.Transactions() {
+		for _, txOut := range tx.MsgTx().TxOut {
+			addDelta(deltas, txOut.PkScript, &txOut.Asset, txOut.Value, 1)
+		}
+	}
+	if vblock != nil {
+		for _, vtx := range vblock.MsgVBlock().VTransactions {
+			for _, txOut := range vtx.TxOut {
+				addDelta(deltas, txOut.PkScript, &txOut.Asset, txOut.Value, 1)
+			}
+		}
+	}
+
+	for key, delta := range deltas {
+		if delta == 0 {
+			delete(deltas, key)
+		}
+	}
+	return deltas
+}
+
+// applyDeltas updates the current balances, ranks and supplies of the passed
+// bucket by the passed deltas and returns the resulting supply of each asset
+// along with its number of holders.
+func applyDeltas(bucket database.Bucket, deltas map[balanceKey]int64,
+	sign int64) (map[[common.AssetLength]byte][]byte, map[balanceKey]int64, error) {
+
+	supplies := make(map[[common.AssetLength]byte][]byte)
+	balances := make(map[balanceKey]int64, len(deltas))
+	for key, delta := range deltas {
+		address, asset := key.address, key.asset[:]
+
+		supply, ok := supplies[key.asset]
+		if !ok {
+			supply = bucket.Get(supplyKey(asset))
+		}
+		total, holders := deserializeSupply(supply)
+
+		currentKey := balanceCurrentKey(&address, asset)
+		oldBalance := deserializeBalance(bucket.Get(currentKey))
+		newBalance := oldBalance + sign*delta
+		balances[key] = newBalance
+
+		if oldBalance > 0 {
+			if err := bucket.Delete(balanceRankKey(&address, asset, oldBalance)); err != nil {
+				return nil, nil, err
+			}
+			holders--
+		}
+		if newBalance == 0 {
+			if err := bucket.Delete(currentKey); err != nil {
+				return nil, nil, err
+			}
+		} else if err := bucket.Put(currentKey, serializeBalance(newBalance)); err != nil {
+			return nil, nil, err
+		}
+		if newBalance > 0 {
+			if err := bucket.Put(balanceRankKey(&address, asset, newBalance), []byte{}); err != nil {
+				return nil, nil, err
+			}
+			holders++
+		}
+
+		supplies[key.asset] = serializeSupply(total+sign*delta, holders)
+	}
+
+	for asset, supply := range supplies {
+		total, holders := deserializeSupply(supply)
+		var err error
+		if total == 0 && holders == 0 {
+			err = bucket.Delete(supplyKey(asset[:]))
+		} else {
+			err = bucket.Put(supplyKey(asset[:]), supply)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return supplies, balances, nil
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer records the new balance of every
+// address and the new supply of every asset the block changed.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) ConnectBlock(dbTx database.Tx, block *asiutil.Block,
+	stxos []txo.SpentTxOut, vblock *asiutil.VBlock) error {
+
+	deltas := blockDeltas(block, stxos, vblock)
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	bucket := dbTx.Metadata().Bucket(balanceIndexKey)
+	supplies, balances, err := applyDeltas(bucket, deltas, 1)
+	if err != nil {
+		return err
+	}
+
+	height := block.Height()
+	for key, balance := range balances {
+		historyKey := balanceHistoryKey(&key.address, key.asset[:], height)
+		if err := bucket.Put(historyKey, serializeBalance(balance)); err != nil {
+			return err
+		}
+	}
+	for asset, supply := range supplies {
+		if err := bucket.Put(supplyHistoryKey(asset[:], height), supply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer reverts the balances and
+// supplies the block changed and removes their history entries.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) DisconnectBlock(dbTx database.Tx, block *asiutil.Block,
+	stxos []txo.SpentTxOut, vblock *asiutil.VBlock) error {
+
+	deltas := blockDeltas(block, stxos, vblock)
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	bucket := dbTx.Metadata().Bucket(balanceIndexKey)
+	supplies, _, err := applyDeltas(bucket, deltas, -1)
+	if err != nil {
+		return err
+	}
+
+	height := block.Height()
+	for key := range deltas {
+		historyKey := balanceHistoryKey(&key.address, key.asset[:], height)
+		if err := bucket.Delete(historyKey); err != nil {
+			return err
+		}
+	}
+	for asset := range supplies {
+		if err := bucket.Delete(supplyHistoryKey(asset[:], height)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchBlockRegion is only provided to satisfy the Indexer interface, the
+// balance index does not map keys to block regions.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) FetchBlockRegion([]byte) (*database.BlockRegion, error) {
+	return nil, nil
+}
+
+// lastEntryAtHeight positions the cursor on the entry with the passed prefix
+// whose height suffix is the greatest one not above height.  It returns the
+// value of the entry or nil when there is none.
+func lastEntryAtHeight(bucket database.Bucket, prefix []byte, height int32) []byte {
+	seek := make([]byte, len(prefix)+4)
+	copy(seek, prefix)
+	binary.BigEndian.PutUint32(seek[len(prefix):], uint32(height)+1)
+
+	cursor := bucket.Cursor()
+	var ok bool
+	if cursor.Seek(seek) {
+		ok = cursor.Prev()
+	} else {
+		ok = cursor.Last()
+	}
+	if !ok {
+		return nil
+	}
+	key := cursor.Key()
+	if len(key) != len(seek) || !bytes.HasPrefix(key, prefix) {
+		return nil
+	}
+	return cursor.Value()
+}
+
+// BalanceAtHeight returns the balance the passed address held of the passed
+// asset after the main chain block at the passed height was connected.
+//
+// This function is safe for concurrent access.
+func (idx *BalanceIndex) BalanceAtHeight(address *common.Address,
+	asset *protos.Asset, height int32) (int64, error) {
+
+	if height < 0 {
+		return 0, fmt.Errorf("invalid height %d", height)
+	}
+
+	var balance int64
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(balanceIndexKey)
+		prefix := balanceHistoryKey(address, asset.Bytes(), 0)
+		prefix = prefix[:len(prefix)-4]
+		balance = deserializeBalance(lastEntryAtHeight(bucket, prefix, height))
+		return nil
+	})
+	return balance, err
+}
+
+// AssetHolders returns at most count holders of the passed asset ordered by
+// descending balance, skipping the first offset ones, along with the total
+// number of holders.
+//
+// This function is safe for concurrent access.
+func (idx *BalanceIndex) AssetHolders(asset *protos.Asset, offset,
+	count int) ([]AssetHolder, uint32, error) {
+
+	holders := make([]AssetHolder, 0)
+	var total uint32
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(balanceIndexKey)
+		assetBytes := asset.Bytes()
+		_, total = deserializeSupply(bucket.Get(supplyKey(assetBytes)))
+
+		prefix := make([]byte, 1+common.AssetLength)
+		prefix[0] = balanceKeyRank
+		copy(prefix[1:], assetBytes)
+
+		skipped := 0
+		cursor := bucket.Cursor()
+		for ok := cursor.Seek(prefix); ok && len(holders) < count; ok = cursor.Next() {
+			key := cursor.Key()
+			if !bytes.HasPrefix(key, prefix) {
+				break
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			balance := ^binary.BigEndian.Uint64(key[len(prefix):])
+			holders = append(holders, AssetHolder{
+				Address: common.BytesToAddress(key[len(prefix)+8:]),
+				Balance: int64(balance),
+			})
+		}
+		return nil
+	})
+	return holders, total, err
+}
+
+// AssetSupplyHistory returns the supply changes of the passed asset made by
+// the main chain blocks in the range [from, to] in ascending order of height.
+// The first element describes the supply at the start of the range when the
+// asset existed then.
+//
+// This function is safe for concurrent access.
+func (idx *BalanceIndex) AssetSupplyHistory(asset *protos.Asset, from,
+	to int32) ([]AssetSupply, error) {
+
+	history := make([]AssetSupply, 0)
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(balanceIndexKey)
+		prefix := supplyHistoryKey(asset.Bytes(), 0)
+		prefix = prefix[:len(prefix)-4]
+
+		// Report the supply in effect at the start of the range.
+		if from > 0 {
+			serialized := lastEntryAtHeight(bucket, prefix, from-1)
+			if serialized != nil {
+				supply, holders := deserializeSupply(serialized)
+				history = append(history, AssetSupply{
+					Height:  from,
+					Supply:  supply,
+					Holders: holders,
+				})
+			}
+		}
+
+		cursor := bucket.Cursor()
+		for ok := cursor.Seek(supplyHistoryKey(asset.Bytes(), from)); ok; ok = cursor.Next() {
+			key := cursor.Key()
+			if len(key) != len(prefix)+4 || !bytes.HasPrefix(key, prefix) {
+				break
+			}
+			height := int32(binary.BigEndian.Uint32(key[len(prefix):]))
+			if height > to {
+				break
+			}
+			supply, holders := deserializeSupply(cursor.Value())
+			if len(history) > 0 && history[len(history)-1].Height == height {
+				history = history[:len(history)-1]
+			}
+			history = append(history, AssetSupply{
+				Height:  height,
+				Supply:  supply,
+				Holders: holders,
+			})
+		}
+		return nil
+	})
+	return history, err
+}
+
+// NewBalanceIndex returns a new instance of an indexer that is used to record
+// the balance history of every address and asset.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewBalanceIndex(db database.Transactor) *BalanceIndex {
+	log.Info("Asset balance index is enabled")
+	return &BalanceIndex{db: db}
+}
+
+// DropBalanceIndex drops the balance index from the provided database if it
+// exists.
+func DropBalanceIndex(db database.Transactor, interrupt <-chan struct{}) error {
+	return dropIndex(db, balanceIndexKey, balanceIndexName, interrupt)
+}
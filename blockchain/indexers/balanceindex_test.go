@@ -0,0 +1,101 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package indexers
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/mock"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// TestBalanceIndexConnectBlock ensures the balance index records the balance
+// changes of a block.
+func TestBalanceIndexConnectBlock(t *testing.T) {
+	t.Parallel()
+
+	addr1, _ := common.NewAddressWithId(common.PubKeyHashAddrID, []byte{01, 01, 01, 01})
+	addr2, _ := common.NewAddressWithId(common.PubKeyHashAddrID, []byte{02, 02, 02, 02})
+	pkScript1, _ := txscript.PayToAddrScript(addr1)
+	pkScript2, _ := txscript.PayToAddrScript(addr2)
+	voucher := protos.NewAsset(protos.InDivisibleAsset, 1, 1)
+
+	// addr1 spends 500 and a voucher, it pays 300 to addr2 and 150 back to
+	// itself, the remaining 50 are paid to addr2 by the coinbase.
+	stxos := []txo.SpentTxOut{
+		{Amount: 500, PkScript: pkScript1, Asset: &asiutil.AsimovAsset},
+		{Amount: 7, PkScript: pkScript1, Asset: voucher},
+	}
+	tx := protos.NewMsgTx(0)
+	tx.AddTxIn(&protos.TxIn{PreviousOutPoint: protos.OutPoint{Index: 0}})
+	tx.AddTxIn(&protos.TxIn{PreviousOutPoint: protos.OutPoint{Index: 1}})
+	tx.AddTxOut(protos.NewTxOut(300, pkScript2, asiutil.AsimovAsset))
+	tx.AddTxOut(protos.NewTxOut(150, pkScript1, asiutil.AsimovAsset))
+	tx.AddTxOut(protos.NewTxOut(7, pkScript2, *voucher))
+	coinbase := protos.NewMsgTx(0)
+	coinbase.AddTxIn(&protos.TxIn{})
+	coinbase.AddTxOut(protos.NewTxOut(50, pkScript2, asiutil.AsimovAsset))
+
+	pblock := protos.MsgBlock{}
+	pblock.Header.Height = 10
+	pblock.AddTransaction(tx)
+	pblock.AddTransaction(coinbase)
+	block := asiutil.NewBlock(&pblock)
+	vblock := asiutil.NewVBlock(&protos.MsgVBlock{}, block.Hash())
+
+	asimov := asiutil.AsimovAsset.Bytes()
+	deltas := blockDeltas(block, stxos, vblock)
+	tests := []struct {
+		address *common.Address
+		asset   []byte
+		delta   int64
+	}{
+		{addr1, asimov, -350},
+		{addr2, asimov, 350},
+		{addr1, voucher.Bytes(), -1},
+		{addr2, voucher.Bytes(), 1},
+	}
+	if len(deltas) != len(tests) {
+		t.Fatalf("unexpected number of deltas - got %d, want %d",
+			len(deltas), len(tests))
+	}
+	for _, test := range tests {
+		key := balanceKey{address: *test.address}
+		copy(key.asset[:], test.asset)
+		if deltas[key] != test.delta {
+			t.Errorf("unexpected delta for %v - got %d, want %d",
+				test.address, deltas[key], test.delta)
+		}
+	}
+
+	// Seed the balance of addr1 and connect the block.
+	dbTx := mock.NewMockTx()
+	bucket, _ := dbTx.Metadata().CreateBucket(balanceIndexKey)
+	bucket.Put(balanceCurrentKey(addr1, asimov), serializeBalance(1000))
+	bucket.Put(supplyKey(asimov), serializeSupply(1000, 1))
+
+	idx := NewBalanceIndex(nil)
+	if err := idx.ConnectBlock(dbTx, block, stxos, vblock); err != nil {
+		t.Fatalf("ConnectBlock: unexpected error: %v", err)
+	}
+
+	if got := deserializeBalance(bucket.Get(balanceCurrentKey(addr1, asimov))); got != 650 {
+		t.Errorf("unexpected balance of addr1 - got %d, want 650", got)
+	}
+	if got := deserializeBalance(bucket.Get(balanceHistoryKey(addr2, asimov, 10))); got != 350 {
+		t.Errorf("unexpected history of addr2 - got %d, want 350", got)
+	}
+	if bucket.Get(balanceRankKey(addr2, asimov, 350)) == nil {
+		t.Errorf("missing rank entry of addr2")
+	}
+	supply, holders := deserializeSupply(bucket.Get(supplyHistoryKey(asimov, 10)))
+	if supply != 1000 || holders != 2 {
+		t.Errorf("unexpected supply - got (%d, %d), want (1000, 2)",
+			supply, holders)
+	}
+}
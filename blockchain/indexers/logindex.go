@@ -0,0 +1,352 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package indexers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/rpcs/rawdb"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+)
+
+const (
+	// logIndexName is the human-readable name for the index.
+	logIndexName = "contract log index"
+
+	// maxLogTopics is the maximum number of topics a log may carry, it
+	// matches the LOG0 to LOG4 instructions of the virtual machine.
+	maxLogTopics = 4
+
+	// logKeyAny is the key kind of the entries which mark the blocks that
+	// contain at least one log.
+	logKeyAny = byte(0x00)
+
+	// logKeyAddress is the key kind of the entries which map a contract
+	// address to the blocks containing its logs.
+	logKeyAddress = byte(0x01)
+
+	// logKeyTopic is the key kind of the entries which map a topic to the
+	// blocks containing it.  The position of the topic in the log is added
+	// to the kind.
+	logKeyTopic = byte(0x10)
+)
+
+var (
+	// logIndexKey is the key of the log index and the db bucket used to
+	// house it.
+	logIndexKey = []byte("logbyitemidx")
+)
+
+// -----------------------------------------------------------------------------
+// The log index maps every contract address and every log topic to the heights
+// of the main chain blocks whose receipts contain a matching log.  The logs
+// themselves are not duplicated, they are loaded from the receipts which the
+// chain stores for every connected block and filtered precisely.
+//
+// The serialized format for keys in the log index bucket is:
+//
+//   <kind><item><block height>
+//
+//   Field           Type              Size
+//   kind            byte              1 byte
+//   item            []byte            0, 21 (address) or 32 (topic) bytes
+//   block height    uint32            4 bytes (big endian)
+//
+// The kind is 0x00 for the entry marking a block with logs, 0x01 for contract
+// addresses and 0x10 plus the position for topics.  The value of each entry is
+// the number of matching logs in the block as a uint32.
+// -----------------------------------------------------------------------------
+
+// logIndexEntryKey returns the key of the log index entry for the passed kind,
+// item and block height.
+func logIndexEntryKey(kind byte, item []byte, height int32) []byte {
+	key := make([]byte, 1+len(item)+4)
+	key[0] = kind
+	copy(key[1:], item)
+	binary.BigEndian.PutUint32(key[1+len(item):], uint32(height))
+	return key
+}
+
+// logIndexEntries returns all of the log index keys of the passed logs
+// together with the number of logs matching each key.
+func logIndexEntries(logs []*types.Log, height int32) map[string]uint32 {
+	entries := make(map[string]uint32)
+	for _, log := range logs {
+		entries[string(logIndexEntryKey(logKeyAny, nil, height))]++
+		entries[string(logIndexEntryKey(logKeyAddress, log.Address[:], height))]++
+		for i, topic := range log.Topics {
+			if i >= maxLogTopics {
+				break
+			}
+			entries[string(logIndexEntryKey(logKeyTopic+byte(i), topic[:], height))]++
+		}
+	}
+	return entries
+}
+
+// LogIndex implements a contract log index.  It allows querying the logs of
+// the main chain by block range, contract address and topics without
+// replaying the receipts of every block.
+type LogIndex struct {
+	db    database.Transactor
+	ethDB rawdb.DatabaseReader
+}
+
+// Ensure the LogIndex type implements the Indexer interface.
+var _ blockchain.Indexer = (*LogIndex)(nil)
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *LogIndex) Init() error {
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *LogIndex) Key() []byte {
+	return logIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *LogIndex) Name() string {
+	return logIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the log index.
+//
+// This is part of the Indexer interface.
+func (idx *LogIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(logIndexKey)
+	return err
+}
+
+// Checking if there is a new bucket added to this indexer
+// This method is invoked each time when node started.
+func (idx *LogIndex) Check(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucketIfNotExists(logIndexKey)
+	return err
+}
+
+// blockLogs loads the logs of the passed block from its stored receipts.
+func (idx *LogIndex) blockLogs(block *asiutil.Block) []*types.Log {
+	receipts := rawdb.ReadReceipts(idx.ethDB, *block.Hash(), uint64(block.Height()))
+	var logs []*types.Log
+	for _, receipt := range receipts {
+		logs = append(logs, receipt.Logs...)
+	}
+	return logs
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer adds an entry for the contract
+// address and each topic of every log emitted in the block.
+//
+// This is part of the Indexer interface.
+func (idx *LogIndex) ConnectBlock(dbTx database.Tx, block *asiutil.Block,
+	stxos []txo.SpentTxOut, vblock *asiutil.VBlock) error {
+
+	logs := idx.blockLogs(block)
+	if len(logs) == 0 {
+		return nil
+	}
+
+	bucket := dbTx.Metadata().Bucket(logIndexKey)
+	for key, count := range logIndexEntries(logs, block.Height()) {
+		var serialized [4]byte
+		byteOrder.PutUint32(serialized[:], count)
+		if err := bucket.Put([]byte(key), serialized[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the entries of every
+// log emitted in the block.
+//
+// This is part of the Indexer interface.
+func (idx *LogIndex) DisconnectBlock(dbTx database.Tx, block *asiutil.Block,
+	stxos []txo.SpentTxOut, vblock *asiutil.VBlock) error {
+
+	logs := idx.blockLogs(block)
+	if len(logs) == 0 {
+		return nil
+	}
+
+	bucket := dbTx.Metadata().Bucket(logIndexKey)
+	for key := range logIndexEntries(logs, block.Height()) {
+		if err := bucket.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchBlockRegion is only provided to satisfy the Indexer interface, the log
+// index does not map keys to block regions.
+//
+// This is part of the Indexer interface.
+func (idx *LogIndex) FetchBlockRegion([]byte) (*database.BlockRegion, error) {
+	return nil, nil
+}
+
+// dbFetchLogHeights returns the heights in the range [from, to] of the blocks
+// indexed under the passed kind and item.
+func dbFetchLogHeights(dbTx database.Tx, kind byte, item []byte, from, to int32,
+	heights map[int32]struct{}) {
+
+	prefix := make([]byte, 1+len(item))
+	prefix[0] = kind
+	copy(prefix[1:], item)
+
+	cursor := dbTx.Metadata().Bucket(logIndexKey).Cursor()
+	for ok := cursor.Seek(logIndexEntryKey(kind, item, from)); ok; ok = cursor.Next() {
+		key := cursor.Key()
+		if len(key) != len(prefix)+4 || !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		height := int32(binary.BigEndian.Uint32(key[len(prefix):]))
+		if height > to {
+			break
+		}
+		heights[height] = struct{}{}
+	}
+}
+
+// intersectHeights returns the heights contained in both sets.  A nil set
+// stands for all heights.
+func intersectHeights(a, b map[int32]struct{}) map[int32]struct{} {
+	if a == nil {
+		return b
+	}
+	result := make(map[int32]struct{})
+	for height := range a {
+		if _, ok := b[height]; ok {
+			result[height] = struct{}{}
+		}
+	}
+	return result
+}
+
+// FilterBlocks returns the heights in the range [from, to] of the main chain
+// blocks which may contain logs matching the passed criteria, in ascending
+// order.  A log matches when it was emitted by one of the addresses (any
+// address when empty) and, for every position of topics, carries one of the
+// listed topics at that position (any topic when the list is empty).
+//
+// The returned blocks are guaranteed to contain logs which match each of the
+// criteria, callers still need to apply FilterLogs to the logs of the blocks
+// since the criteria may be satisfied by different logs.
+//
+// This function is safe for concurrent access.
+func (idx *LogIndex) FilterBlocks(from, to int32, addresses []common.Address,
+	topics [][]common.Hash) ([]int32, error) {
+
+	var candidates map[int32]struct{}
+	err := idx.db.View(func(dbTx database.Tx) error {
+		if len(addresses) > 0 {
+			heights := make(map[int32]struct{})
+			for _, addr := range addresses {
+				dbFetchLogHeights(dbTx, logKeyAddress, addr[:], from, to, heights)
+			}
+			candidates = intersectHeights(candidates, heights)
+		}
+		for i, sub := range topics {
+			if len(sub) == 0 || i >= maxLogTopics {
+				continue
+			}
+			heights := make(map[int32]struct{})
+			for _, topic := range sub {
+				dbFetchLogHeights(dbTx, logKeyTopic+byte(i), topic[:], from, to, heights)
+			}
+			candidates = intersectHeights(candidates, heights)
+		}
+		if candidates == nil {
+			candidates = make(map[int32]struct{})
+			dbFetchLogHeights(dbTx, logKeyAny, nil, from, to, candidates)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]int32, 0, len(candidates))
+	for height := range candidates {
+		result = append(result, height)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+// FilterLogs returns the logs which were emitted by one of the addresses and
+// carry the listed topics.  See FilterBlocks for the matching rules.
+func FilterLogs(logs []*types.Log, addresses []common.Address, topics [][]common.Hash) []*types.Log {
+	var result []*types.Log
+next:
+	for _, log := range logs {
+		if len(addresses) > 0 {
+			found := false
+			for _, addr := range addresses {
+				if log.Address == addr {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		if len(topics) > len(log.Topics) {
+			for _, sub := range topics[len(log.Topics):] {
+				if len(sub) > 0 {
+					continue next
+				}
+			}
+		}
+		for i, sub := range topics {
+			if len(sub) == 0 || i >= len(log.Topics) {
+				continue
+			}
+			found := false
+			for _, topic := range sub {
+				if log.Topics[i] == topic {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue next
+			}
+		}
+		result = append(result, log)
+	}
+	return result
+}
+
+// NewLogIndex returns a new instance of an indexer that is used to create a
+// mapping of contract addresses and log topics to the blocks which contain
+// them.  The logs are read from the receipts stored in the passed database.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewLogIndex(db database.Transactor, ethDB rawdb.DatabaseReader) *LogIndex {
+	log.Info("Contract log index is enabled")
+	return &LogIndex{db: db, ethDB: ethDB}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package indexers
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+)
+
+// TestFilterLogs ensures the log matching rules of the log index behave as
+// expected.
+func TestFilterLogs(t *testing.T) {
+	addr1 := common.Address{0x63, 0x01}
+	addr2 := common.Address{0x63, 0x02}
+	topicA := common.Hash{0xa}
+	topicB := common.Hash{0xb}
+	topicC := common.Hash{0xc}
+
+	logs := []*types.Log{
+		{Address: addr1, Topics: []common.Hash{topicA}},
+		{Address: addr1, Topics: []common.Hash{topicA, topicB}},
+		{Address: addr2, Topics: []common.Hash{topicB, topicC}},
+		{Address: addr2},
+	}
+
+	tests := []struct {
+		name      string
+		addresses []common.Address
+		topics    [][]common.Hash
+		want      []int
+	}{
+		{
+			name: "no criteria",
+			want: []int{0, 1, 2, 3},
+		},
+		{
+			name:      "single address",
+			addresses: []common.Address{addr2},
+			want:      []int{2, 3},
+		},
+		{
+			name:      "any of the addresses",
+			addresses: []common.Address{addr1, addr2},
+			want:      []int{0, 1, 2, 3},
+		},
+		{
+			name:   "first topic",
+			topics: [][]common.Hash{{topicA}},
+			want:   []int{0, 1},
+		},
+		{
+			name:   "wildcard position",
+			topics: [][]common.Hash{{}, {topicB}},
+			want:   []int{1},
+		},
+		{
+			name:   "any of the topics",
+			topics: [][]common.Hash{{topicA, topicB}},
+			want:   []int{0, 1, 2},
+		},
+		{
+			name:      "address and topic",
+			addresses: []common.Address{addr2},
+			topics:    [][]common.Hash{{topicB}, {topicC}},
+			want:      []int{2},
+		},
+		{
+			name:   "more topics than the log",
+			topics: [][]common.Hash{{topicA}, {}, {topicC}},
+			want:   []int{},
+		},
+	}
+
+	for _, test := range tests {
+		got := FilterLogs(logs, test.addresses, test.topics)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: unexpected number of logs - got %d, "+
+				"want %d", test.name, len(got), len(test.want))
+			continue
+		}
+		for i, idx := range test.want {
+			if got[i] != logs[idx] {
+				t.Errorf("%s: unexpected log #%d - got %v, "+
+					"want %v", test.name, i, got[i], logs[idx])
+			}
+		}
+	}
+}
+
+// TestLogIndexEntries ensures the keys of the log index are derived from the
+// logs of a block as expected.
+func TestLogIndexEntries(t *testing.T) {
+	addr := common.Address{0x63, 0x01}
+	topic := common.Hash{0xa}
+	logs := []*types.Log{
+		{Address: addr, Topics: []common.Hash{topic}},
+		{Address: addr, Topics: []common.Hash{topic, topic}},
+	}
+
+	entries := logIndexEntries(logs, 7)
+	want := map[string]uint32{
+		string(logIndexEntryKey(logKeyAny, nil, 7)):                2,
+		string(logIndexEntryKey(logKeyAddress, addr[:], 7)):        2,
+		string(logIndexEntryKey(logKeyTopic, topic[:], 7)):         2,
+		string(logIndexEntryKey(logKeyTopic+byte(1), topic[:], 7)): 1,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("unexpected number of entries - got %d, want %d",
+			len(entries), len(want))
+	}
+	for key, count := range want {
+		if entries[key] != count {
+			t.Errorf("unexpected count for key %x - got %d, want %d",
+				key, entries[key], count)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"sort"
 	"sync"
 	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
 )
 
 const (
@@ -95,7 +97,7 @@ func (m *medianTime) AdjustedTime() int64 {
 	defer m.mtx.Unlock()
 
 	// Limit the adjusted time to 1 second precision.
-	now := time.Now().Unix()
+	now := clock.Now().Unix()
 	return now + m.offsetSecs
 }
 
@@ -118,7 +120,7 @@ func (m *medianTime) AddTimeSample(sourceID string, timeVal int64) {
 	// of offsets while respecting the maximum number of allowed entries by
 	// replacing the oldest entry with the new entry once the maximum number
 	// of entries is reached.
-	now := time.Now().Unix()
+	now := clock.Now().Unix()
 	offsetSecs := timeVal - now
 	numOffsets := len(m.offsets)
 	if numOffsets == maxMedianTimeEntries && maxMedianTimeEntries > 0 {
@@ -128,6 +128,7 @@ func (mb *MockBucket) Get(key []byte) []byte {
 }
 
 func (mb *MockBucket) Delete(key []byte) error {
+	delete(mb.cache, string(key))
 	return nil
 }
 
@@ -6,6 +6,8 @@ package blockchain
 
 import (
 	"fmt"
+
+	"github.com/AsimovNetwork/asimov/common"
 )
 
 // NotificationType represents the type of a notification message.
@@ -29,6 +31,16 @@ const (
 	// NTBlockDisconnected indicates the associated block was disconnected
 	// from the main chain.
 	NTBlockDisconnected
+
+	// NTChainReorganized indicates the main chain switched to another
+	// branch.  It is sent once after all of the blocks of the old branch
+	// were disconnected and all of the blocks of the new branch were
+	// connected.
+	NTChainReorganized
+
+	// NTEvidence indicates a new evidence of validator misbehavior was
+	// detected or received and stored.
+	NTEvidence
 )
 
 // notificationTypeStrings is a map of notification types back to their constant
@@ -37,6 +49,8 @@ var notificationTypeStrings = map[NotificationType]string{
 	NTBlockAccepted:     "NTBlockAccepted",
 	NTBlockConnected:    "NTBlockConnected",
 	NTBlockDisconnected: "NTBlockDisconnected",
+	NTChainReorganized:  "NTChainReorganized",
+	NTEvidence:          "NTEvidence",
 }
 
 // String returns the NotificationType in human-readable form.
@@ -53,11 +67,27 @@ func (n NotificationType) String() string {
 // 	- NTBlockAccepted:     *asiutil.Block
 // 	- NTBlockConnected:    *asiutil.Block
 // 	- NTBlockDisconnected: [*asiutil.Block, *asiutil.VBlock]
+// 	- NTChainReorganized:  *ReorganizeData
+// 	- NTEvidence:          *protos.MsgEvidence
 type Notification struct {
 	Type NotificationType
 	Data interface{}
 }
 
+// ReorganizeData describes a reorganization of the main chain.  Detached
+// blocks are ordered from the old tip back to the fork point, attached blocks
+// are ordered from the fork point forward to the new tip.
+type ReorganizeData struct {
+	ForkHash   common.Hash
+	ForkHeight int32
+	OldTip     common.Hash
+	OldHeight  int32
+	NewTip     common.Hash
+	NewHeight  int32
+	Detached   []common.Hash
+	Attached   []common.Hash
+}
+
 // Subscribe to block chain notifications. Registers a callback to be executed
 // when various events take place. See the documentation on Notification and
 // NotificationType for details on the types and contents of notifications.
@@ -0,0 +1,218 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"sync"
+
+	"github.com/AsimovNetwork/asimov/ainterface"
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+)
+
+// signingKeyCacheSize is the number of rounds whose signing keys are cached.
+const signingKeyCacheSize = 8
+
+// roundSigningKeys are the block signing keys of the validators of a round,
+// read in the state of the pre-round last node.
+type roundSigningKeys struct {
+	round uint32
+	hash  common.Hash
+	keys  map[common.Address]common.Address
+}
+
+// signingKeyCache keeps the signing keys of the recent rounds.  Its zero
+// value is ready to use.
+type signingKeyCache struct {
+	sync.Mutex
+	entries []*roundSigningKeys
+}
+
+// findPreroundLastNode returns the last node before the round on the branch of
+// the node.
+func findPreroundLastNode(round uint32, node *blockNode) *blockNode {
+	for ; node != nil; node = node.parent {
+		if node.round.Round < round {
+			break
+		}
+	}
+	return node
+}
+
+// signingKeysByNode returns the block signing keys the validators of the
+// round delegated the signing of their blocks to, in the state of the
+// pre-round last node.  A key registered in the signing key registry during a
+// round is thus in force from the next round on.  The validators absent from
+// the map sign with their own key.
+func (b *BlockChain) signingKeysByNode(round uint32, preroundLastNode *blockNode) (
+	map[common.Address]common.Address, error) {
+
+	if preroundLastNode == nil {
+		return nil, nil
+	}
+	skm, ok := b.contractManager.(ainterface.ISigningKeyManager)
+	if !ok {
+		return nil, nil
+	}
+
+	cache := &b.signingKeys
+	cache.Lock()
+	defer cache.Unlock()
+	for _, entry := range cache.entries {
+		if entry.round == round && entry.hash == preroundLastNode.hash {
+			return entry.keys, nil
+		}
+	}
+
+	_, weightMap, err := b.GetValidatorsByNode(round, preroundLastNode)
+	if err != nil {
+		return nil, err
+	}
+	validators := make([]common.Address, 0, len(weightMap))
+	for validator := range weightMap {
+		validators = append(validators, validator)
+	}
+
+	header := protos.BlockHeader{
+		Timestamp: preroundLastNode.timestamp,
+		Height:    preroundLastNode.height,
+		StateRoot: preroundLastNode.stateRoot,
+	}
+	block := asiutil.NewBlock(&protos.MsgBlock{
+		Header: header,
+	})
+	stateDB, err := state.New(header.StateRoot, b.stateCache)
+	if err != nil {
+		return nil, err
+	}
+	signingKeys, err := skm.GetSigningKeys(block, stateDB,
+		chaincfg.ActiveNetParams.FvmParam, validators)
+	if err != nil {
+		return nil, err
+	}
+
+	// A key which is the address of another validator of the round is
+	// ignored, so each address maps back to a single validator.
+	keys := make(map[common.Address]common.Address)
+	for i, key := range signingKeys {
+		if _, ok := weightMap[key]; !ok && key != (common.Address{}) {
+			keys[validators[i]] = key
+		}
+	}
+	entry := &roundSigningKeys{round: round, hash: preroundLastNode.hash, keys: keys}
+	cache.entries = append([]*roundSigningKeys{entry}, cache.entries...)
+	if len(cache.entries) > signingKeyCacheSize {
+		cache.entries = cache.entries[:signingKeyCacheSize]
+	}
+	return keys, nil
+}
+
+// signingKeyOf returns the address of the key signing the blocks of the
+// validator in the round, on the branch of the node.
+func (b *BlockChain) signingKeyOf(validator common.Address, round uint32, node *blockNode) (common.Address, error) {
+	keys, err := b.signingKeysByNode(round, findPreroundLastNode(round, node))
+	if err != nil {
+		return common.Address{}, err
+	}
+	if key, ok := keys[validator]; ok {
+		return key, nil
+	}
+	return validator, nil
+}
+
+// validatorOf returns the validator whose blocks are signed by the address in
+// the round, on the branch of the node.  It is the address itself unless it is
+// the signing key of a validator of the round.
+func (b *BlockChain) validatorOf(addr common.Address, round uint32, node *blockNode) (common.Address, error) {
+	keys, err := b.signingKeysByNode(round, findPreroundLastNode(round, node))
+	if err != nil {
+		return common.Address{}, err
+	}
+	for validator, key := range keys {
+		if key == addr {
+			return validator, nil
+		}
+	}
+	return addr, nil
+}
+
+// verifyValidatorSignature ensures the signature of the hash was made by the
+// key signing the blocks of the validator in the round, on the branch of the
+// node.
+func (b *BlockChain) verifyValidatorSignature(hash []byte, validator *common.Address,
+	round uint32, node *blockNode, sig []byte) error {
+
+	key, err := b.signingKeyOf(*validator, round, node)
+	if err != nil {
+		return err
+	}
+	return AddressVerifySignature(hash, &key, sig)
+}
+
+// signedRound returns the round and the branch of the block signed by the
+// signature, the given round and branch when the block is unknown.
+func (b *BlockChain) signedRound(sign *protos.MsgBlockSign, round uint32, node *blockNode) (uint32, *blockNode) {
+	if signed := b.index.LookupNode(&sign.BlockHash); signed != nil {
+		return signed.round.Round, signed
+	}
+	return round, node
+}
+
+// verifyBlockSign ensures the signature of a block was made by the signing
+// key of its signer in the round of the block, or of the given round and
+// branch when the block is unknown.
+func (b *BlockChain) verifyBlockSign(sign *protos.MsgBlockSign, round uint32, node *blockNode) error {
+	round, node = b.signedRound(sign, round, node)
+	return b.verifyValidatorSignature(sign.BlockHash[:], &sign.Signer, round, node,
+		sign.Signature[:])
+}
+
+// VerifyBlockSign ensures the signature of a block was made by the key
+// signing the blocks of its signer.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) VerifyBlockSign(sign *protos.MsgBlockSign) error {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+	tip := b.bestChain.Tip()
+	return b.verifyBlockSign(sign, tip.round.Round, tip)
+}
+
+// SignerValidator returns the validator of the signer of a block signature.
+// The signer is not covered by the signature, so it may name the signing key
+// of the validator in place of the validator.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) SignerValidator(sign *protos.MsgBlockSign) (common.Address, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+	tip := b.bestChain.Tip()
+	round, node := b.signedRound(sign, tip.round.Round, tip)
+	return b.validatorOf(sign.Signer, round, node)
+}
+
+// SigningKeyOf returns the address of the key signing the blocks of the
+// validator in the round, on the main chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) SigningKeyOf(validator common.Address, round uint32) (common.Address, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+	return b.signingKeyOf(validator, round, b.bestChain.Tip())
+}
+
+// ValidatorOf returns the validator whose blocks are signed by the address in
+// the round, on the main chain.  It is the address itself unless it is the
+// signing key of a validator of the round.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ValidatorOf(addr common.Address, round uint32) (common.Address, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+	return b.validatorOf(addr, round, b.bestChain.Tip())
+}
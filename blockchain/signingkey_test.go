@@ -0,0 +1,222 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/ainterface"
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/params"
+)
+
+// signingKeyManagerTmp is a contract manager reporting fixed signing keys.
+type signingKeyManagerTmp struct {
+	ainterface.ContractManager
+	keys map[common.Address]common.Address
+}
+
+func (m *signingKeyManagerTmp) GetSigningKeys(block *asiutil.Block,
+	stateDB vm.StateDB, chainConfig *params.ChainConfig,
+	validators []common.Address) ([]common.Address, error) {
+	keys := make([]common.Address, len(validators))
+	for i, validator := range validators {
+		keys[i] = m.keys[validator]
+	}
+	return keys, nil
+}
+
+// TestSigningKey ensures the blocks and the block signatures of a validator
+// which delegated the signing to another key are signed by that key.
+func TestSigningKey(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	hotKey, err := crypto.NewAccount("0x224828e95689e30a8e668418968260edbfd7fe6d1e3e6d7ee2a6e0f5c8a8f00c")
+	if err != nil {
+		t.Fatalf("NewAccount error %v", err)
+	}
+	validator := *accList[0].Address
+	chain.contractManager = &signingKeyManagerTmp{
+		ContractManager: chain.contractManager,
+		keys:            map[common.Address]common.Address{validator: *hotKey.Address},
+	}
+	if key, err := chain.SigningKeyOf(validator, 1); err != nil || key != *hotKey.Address {
+		t.Fatalf("SigningKeyOf: got %v, %v want %v", key, err, hotKey.Address)
+	}
+	for _, addr := range []common.Address{*hotKey.Address, validator} {
+		if got, err := chain.ValidatorOf(addr, 1); err != nil || got != validator {
+			t.Errorf("ValidatorOf(%v): got %v, %v want %v", addr, got, err, validator)
+		}
+	}
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+		chain, 1, 0, chain.bestChain.height(), protos.Asset{}, 0,
+		validators[0], nil, 0, chain.bestChain.tip())
+	if err != nil {
+		t.Fatalf("create block error %v", err)
+	}
+	if err := chain.checkSignatures(block); !isRuleError(err, ErrSigAndKeyMismatch) {
+		t.Errorf("checkSignatures: a block signed by the validator key is accepted, got %v", err)
+	}
+
+	hash := block.MsgBlock().BlockHash()
+	signature, err := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&hotKey.PrivateKey))
+	if err != nil {
+		t.Fatalf("Sign error %v", err)
+	}
+	copy(block.MsgBlock().Header.SigData[:], signature)
+	if err := chain.checkSignatures(block); err != nil {
+		t.Fatalf("checkSignatures error %v", err)
+	}
+	if _, _, err := chain.ProcessBlock(block, nil, nil, nil, 1); err != nil {
+		t.Fatalf("ProcessBlock error %v", err)
+	}
+
+	sign := &protos.MsgBlockSign{
+		BlockHeight: block.Height(),
+		BlockHash:   hash,
+		Signer:      validator,
+	}
+	copy(sign.Signature[:], signature)
+	if err := chain.VerifyBlockSign(sign); err != nil {
+		t.Errorf("VerifyBlockSign error %v", err)
+	}
+	validatorSig, _ := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&accList[0].PrivateKey))
+	copy(sign.Signature[:], validatorSig)
+	if err := chain.VerifyBlockSign(sign); !isRuleError(err, ErrSigAndKeyMismatch) {
+		t.Errorf("VerifyBlockSign: a signature by the validator key is accepted, got %v", err)
+	}
+
+	// A signature naming the signing key is attributed to its validator, but
+	// blocks must carry it with the validator as signer.
+	copy(sign.Signature[:], signature)
+	sign.Signer = *hotKey.Address
+	if got, err := chain.SignerValidator(sign); err != nil || got != validator {
+		t.Errorf("SignerValidator: got %v, %v want %v", got, err, validator)
+	}
+	next, _, err := createAndSignBlock(netParam, accList, validators, filters,
+		chain, 1, 1, chain.bestChain.height(), protos.Asset{}, 0,
+		validators[1], nil, 0, chain.bestChain.tip())
+	if err != nil {
+		t.Fatalf("create block error %v", err)
+	}
+	next.MsgBlock().PreBlockSigs = append(next.MsgBlock().PreBlockSigs, sign)
+	if err := chain.checkSignatures(next); !isRuleError(err, ErrValidatorMismatch) {
+		t.Errorf("checkSignatures: a signature naming the signing key is accepted, got %v", err)
+	}
+	sign.Signer = validator
+	if err := chain.checkSignatures(next); !isRuleError(err, ErrSigAndKeyMismatch) {
+		t.Errorf("checkSignatures: got %v, want the block signature refused", err)
+	}
+}
+
+// TestSigningKeyRegistry ensures the validators register, rotate and clear
+// their signing keys in the registry, and cannot take the key of another
+// validator.
+func TestSigningKeyRegistry(t *testing.T) {
+	stateDB, err := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	if err != nil {
+		t.Fatalf("state.New error %v", err)
+	}
+	validatorA := common.BytesToAddress([]byte{common.PubKeyHashAddrID, 0xa})
+	validatorB := common.BytesToAddress([]byte{common.PubKeyHashAddrID, 0xb})
+	key1 := common.BytesToAddress([]byte{common.PubKeyHashAddrID, 0x1})
+	key2 := common.BytesToAddress([]byte{common.PubKeyHashAddrID, 0x2})
+
+	call := func(caller common.Address, method string, args ...interface{}) (interface{}, error) {
+		input, err := fvm.PackFunctionArgs(vm.SigningKeyRegistryABI, method, args...)
+		if err != nil {
+			t.Fatalf("PackFunctionArgs error %v", err)
+		}
+		vmenv := vm.NewFVM(vm.Context{BlockNumber: big.NewInt(10)}, stateDB,
+			params.DevelopnetChainConfig, vm.Config{})
+		ret, _, _, err := vmenv.Call(vm.AccountRef(caller), common.SigningKeyRegistry,
+			input, 100000, common.Big0, nil, false)
+		if err != nil || method == "setSigningKey" {
+			return nil, err
+		}
+		result, err := fvm.UnPackReadOnlyResult(vm.SigningKeyRegistryABI, method, ret)
+		if err != nil {
+			t.Fatalf("UnPackReadOnlyResult error %v", err)
+		}
+		return result, nil
+	}
+	checkKeys := func(name string, wantA, wantB common.Address) {
+		result, err := call(validatorA, "getSigningKeys", []common.Address{validatorA, validatorB})
+		keys, ok := result.([]common.Address)
+		if err != nil || !ok || len(keys) != 2 || keys[0] != wantA || keys[1] != wantB {
+			t.Errorf("%s: got keys %v, error %v, want %v and %v", name, result, err,
+				wantA, wantB)
+		}
+	}
+	checkOwner := func(name string, key, want common.Address) {
+		owner, err := call(validatorA, "getSigningKeyOwner", key)
+		if err != nil || owner != want {
+			t.Errorf("%s: got owner %v of %v, error %v, want %v", name, owner, key, err, want)
+		}
+	}
+
+	checkKeys("no key", common.Address{}, common.Address{})
+	if _, err := call(validatorA, "setSigningKey", key1); err != nil {
+		t.Fatalf("setSigningKey error %v", err)
+	}
+	checkKeys("registered", key1, common.Address{})
+	checkOwner("registered", key1, validatorA)
+
+	// The key of a validator is not given to another one, even once it is
+	// rotated out.
+	if _, err := call(validatorB, "setSigningKey", key1); err == nil {
+		t.Errorf("setSigningKey: the key of another validator was taken")
+	}
+	if _, err := call(validatorA, "setSigningKey", key2); err != nil {
+		t.Fatalf("setSigningKey error %v", err)
+	}
+	checkKeys("rotated", key2, common.Address{})
+	if _, err := call(validatorB, "setSigningKey", key1); err == nil {
+		t.Errorf("setSigningKey: a rotated out key was taken")
+	}
+	checkOwner("rotated", key1, validatorA)
+
+	// The validator signs with its own key again once it registers its own
+	// address or the zero address.
+	if _, err := call(validatorA, "setSigningKey", validatorA); err != nil {
+		t.Fatalf("setSigningKey error %v", err)
+	}
+	checkKeys("cleared", common.Address{}, common.Address{})
+	if _, err := call(validatorA, "setSigningKey", key1); err != nil {
+		t.Fatalf("setSigningKey error %v", err)
+	}
+	if _, err := call(validatorA, "setSigningKey", common.Address{}); err != nil {
+		t.Fatalf("setSigningKey error %v", err)
+	}
+	checkKeys("cleared", common.Address{}, common.Address{})
+	checkOwner("unknown key", validatorB, common.Address{})
+
+	// Values are refused.
+	input, _ := fvm.PackFunctionArgs(vm.SigningKeyRegistryABI, "setSigningKey", key2)
+	vmenv := vm.NewFVM(vm.Context{BlockNumber: big.NewInt(10)}, stateDB,
+		params.DevelopnetChainConfig, vm.Config{})
+	if _, _, _, err := vmenv.Call(vm.AccountRef(validatorA), common.SigningKeyRegistry,
+		input, 100000, common.Big1, nil, false); err == nil {
+		t.Errorf("setSigningKey: a call with value was accepted")
+	}
+}
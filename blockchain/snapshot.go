@@ -0,0 +1,831 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/txscript"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/trie"
+)
+
+// -----------------------------------------------------------------------------
+// A snapshot holds what a node needs to continue the chain from a block
+// without the blocks before it.  It is serialized as follows:
+//
+//   <magic><version><net><block hash><height><total txns><records><trailer>
+//
+//   Field          Type             Size
+//   magic          uint32           4
+//   version        uint32           4
+//   net            uint32           4
+//   block hash     common.Hash      32
+//   height         uint32           4
+//   total txns     uint64           8
+//
+// The records follow, each one is a type byte and its fields, every field
+// being serialized as variable length bytes:
+//
+//   bucket:  bucket name, key, value of a metadata bucket entry.  The block
+//            index rows of the main chain come first in height order.
+//   block:   block key, serialized block of the snapshot block and genesis.
+//   state:   hash, encoding of a trie node or contract code.
+//   end:     no field, the trailer follows.
+//
+// The trailer is the number of state roots as a variable length integer, and
+// the height (uint32) and the root of each one.  The state roots are the one
+// of the snapshot block and the ones of the last blocks of the two previous
+// rounds which are needed to compute the validators.
+//
+// A snapshot is trusted because its block is a checkpoint which pins the hash
+// of the utxo set and the snapshot hash at the block.  The snapshot hash is
+// the sha256 of the fields of every bucket record, in order, so none of the
+// metadata can be altered.  The state is checked against the state root of
+// the block header.
+//
+// Indexes are not part of a snapshot, the optional indexes of a node
+// bootstrapped from a snapshot start at the snapshot block.  Neither are the
+// buckets of the bitcoin miners kept by the consensus service, it fetches
+// them again from its bitcoin node.
+// -----------------------------------------------------------------------------
+
+const (
+	// snapshotMagic identifies a snapshot file.
+	snapshotMagic uint32 = 0x534e5341 // "ASNS"
+
+	// snapshotVersion is the version of the snapshot serialization.
+	snapshotVersion uint32 = 2
+
+	// maxSnapshotField is the maximum size of a field of a snapshot record.
+	maxSnapshotField = 1 << 25
+
+	// snapshotFlushSize is the amount of pending metadata written by an
+	// import before it is flushed to the database.
+	snapshotFlushSize = 32 * 1024 * 1024
+
+	// snapshotRoundDepth is the number of previous rounds whose last state
+	// is included in a snapshot.
+	snapshotRoundDepth = 2
+)
+
+// Snapshot record types.
+const (
+	snapshotRecordEnd byte = iota
+	snapshotRecordBucket
+	snapshotRecordBlock
+	snapshotRecordState
+)
+
+var (
+	// snapshotBaseKeyName is the name of the db key used to store the
+	// block a node was bootstrapped from with a snapshot.
+	snapshotBaseKeyName = []byte("snapshotbase")
+
+	// snapshotBuckets are the metadata buckets copied by a snapshot besides
+	// the block index.
+	snapshotBuckets = [][]byte{
+		roundIndexBucketName,
+		utxoSetBucketName,
+		lockSetBucketName,
+		assetsSetBucketName,
+		signatureSetBucketName,
+	}
+)
+
+// SnapshotState is a state root included in a snapshot.
+type SnapshotState struct {
+	Height int32
+	Root   common.Hash
+}
+
+// SnapshotInfo describes a snapshot which was exported or imported.
+type SnapshotInfo struct {
+	Hash   common.Hash
+	Height int32
+	States []SnapshotState
+
+	// UtxoSetHash is the hash of the utxo set at the snapshot block and
+	// SnapshotHash the hash of its bucket records, the ones reported by
+	// gettxoutsetinfo and pinned by the checkpoints.
+	UtxoSetHash  common.Hash
+	SnapshotHash common.Hash
+}
+
+// writeSnapshotRecord writes a record of the passed type with its fields.
+func writeSnapshotRecord(w io.Writer, kind byte, fields ...[]byte) error {
+	if _, err := w.Write([]byte{kind}); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if err := serialization.WriteVarBytes(w, 0, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSnapshotFields reads n fields of a record.
+func readSnapshotFields(r io.Reader, n int) ([][]byte, error) {
+	fields := make([][]byte, n)
+	for i := range fields {
+		field, err := serialization.ReadVarBytes(r, 0, maxSnapshotField,
+			"snapshot record")
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = field
+	}
+	return fields, nil
+}
+
+// commitSnapshotRecord adds a bucket record to the snapshot hash.  The
+// validation flags of the block index rows are left out, they depend on how
+// the node came by the blocks.
+func commitSnapshotRecord(h hash.Hash, name, key, value []byte) {
+	if bytes.Equal(name, blockIndexBucketName) && len(value) > blockHdrSize {
+		value = append([]byte(nil), value...)
+		value[blockHdrSize] &= byte(statusFirstInRound | statusVrfOutput)
+	}
+	serialization.WriteVarBytes(h, 0, name)
+	serialization.WriteVarBytes(h, 0, key)
+	serialization.WriteVarBytes(h, 0, value)
+}
+
+// forEachSnapshotRecord calls fn with the bucket name, the key and the value
+// of each bucket record of a snapshot of the block at the passed height, in
+// snapshot order: the block index rows of the main chain in height order,
+// then the entries of the snapshot buckets.
+func forEachSnapshotRecord(meta database.Bucket, height uint32,
+	fn func(name, key, value []byte) error) error {
+
+	// The hash index only holds the blocks of the main chain.
+	hashIndex := meta.Bucket(hashIndexBucketName)
+	cursor := meta.Bucket(blockIndexBucketName).Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		key := cursor.Key()
+		serializedHeight := hashIndex.Get(key[4:])
+		if len(serializedHeight) != 4 ||
+			byteOrder.Uint32(serializedHeight) != binary.BigEndian.Uint32(key) {
+			continue
+		}
+		if binary.BigEndian.Uint32(key) > height {
+			continue
+		}
+		if err := fn(blockIndexBucketName, key, cursor.Value()); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range snapshotBuckets {
+		bucket := meta.Bucket(name)
+		if bucket == nil {
+			continue
+		}
+		err := bucket.ForEach(func(k, v []byte) error {
+			return fn(name, k, v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbFetchSnapshotBase returns the block the database was bootstrapped from
+// with a snapshot, nil when it was synced from genesis.
+func dbFetchSnapshotBase(dbTx database.Tx) (*common.Hash, int32, error) {
+	serialized := dbTx.Metadata().Get(snapshotBaseKeyName)
+	if serialized == nil {
+		return nil, 0, nil
+	}
+	if len(serialized) != common.HashLength+4 {
+		return nil, 0, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: "corrupt snapshot base",
+		}
+	}
+	var hash common.Hash
+	copy(hash[:], serialized)
+	return &hash, int32(byteOrder.Uint32(serialized[common.HashLength:])), nil
+}
+
+// initSnapshotBase loads the block the chain was bootstrapped from with a
+// snapshot, if any.
+func (b *BlockChain) initSnapshotBase() error {
+	return b.db.View(func(dbTx database.Tx) error {
+		hash, _, err := dbFetchSnapshotBase(dbTx)
+		if err != nil || hash == nil {
+			return err
+		}
+		b.snapshotBase = b.index.LookupNode(hash)
+		if b.snapshotBase == nil {
+			return common.AssertError(fmt.Sprintf("initSnapshotBase: cannot "+
+				"find snapshot block %s in block index", hash))
+		}
+		log.Infof("Chain bootstrapped from the snapshot of block %v "+
+			"(height %d)", hash, b.snapshotBase.height)
+		return nil
+	})
+}
+
+// SnapshotBase returns the hash and the height of the block the chain was
+// bootstrapped from with a snapshot.  The blocks before it are not
+// available.  The hash is nil when the chain was synced from genesis.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) SnapshotBase() (*common.Hash, int32) {
+	if b.snapshotBase == nil {
+		return nil, 0
+	}
+	return &b.snapshotBase.hash, b.snapshotBase.height
+}
+
+// ExportSnapshot writes a snapshot of the best block of the block database
+// and of its state to w.  The databases must not be in use.
+func ExportSnapshot(db database.Transactor, stateDB database.Database, w io.Writer,
+	params *chaincfg.Params) (*SnapshotInfo, error) {
+
+	bw := bufio.NewWriter(w)
+	info := &SnapshotInfo{}
+	commitment := sha256.New()
+	setHash := muhash.New()
+	err := db.View(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		serialized := meta.Get(chainStateKeyName)
+		if serialized == nil {
+			return errors.New("the block database is not initialized")
+		}
+		best, err := deserializeBestChainState(serialized)
+		if err != nil {
+			return err
+		}
+		tip, err := dbFetchHeaderByHash(dbTx, &best.hash)
+		if err != nil {
+			return err
+		}
+		info.Hash = best.hash
+		info.Height = int32(best.height)
+
+		if err := serialization.WriteUint32(bw, snapshotMagic); err != nil {
+			return err
+		}
+		if err := serialization.WriteUint32(bw, snapshotVersion); err != nil {
+			return err
+		}
+		if err := serialization.WriteUint32(bw, uint32(params.Net)); err != nil {
+			return err
+		}
+		if _, err := bw.Write(best.hash[:]); err != nil {
+			return err
+		}
+		if err := serialization.WriteUint32(bw, best.height); err != nil {
+			return err
+		}
+		if err := serialization.WriteUint64(bw, best.totalTxns); err != nil {
+			return err
+		}
+
+		// Write the bucket records.  The last blocks of the previous
+		// rounds are tracked on the way.
+		roundStates := make(map[uint32]SnapshotState)
+		err = forEachSnapshotRecord(meta, best.height, func(name, k, v []byte) error {
+			if bytes.Equal(name, blockIndexBucketName) {
+				header, _, _, err := deserializeBlockRow(v)
+				if err != nil {
+					return err
+				}
+				if header.Round+snapshotRoundDepth >= tip.Round && header.Round < tip.Round {
+					roundStates[header.Round] = SnapshotState{
+						Height: header.Height,
+						Root:   header.StateRoot,
+					}
+				}
+			}
+			if bytes.Equal(name, utxoSetBucketName) {
+				setHash.Add(utxoSetHashElement(k, v))
+			}
+			commitSnapshotRecord(commitment, name, k, v)
+			return writeSnapshotRecord(bw, snapshotRecordBucket, name, k, v)
+		})
+		if err != nil {
+			return err
+		}
+
+		// Write the snapshot block and the genesis block, along with their
+		// virtual blocks when they have one.
+		genesisHash := params.GenesisBlock.Header.BlockHash()
+		for _, hash := range []*common.Hash{&genesisHash, &best.hash} {
+			for _, key := range []*database.BlockKey{
+				database.NewNormalBlockKey(hash),
+				database.NewVirtualBlockKey(hash),
+			} {
+				has, err := dbTx.HasBlock(key)
+				if err != nil {
+					return err
+				}
+				if !has {
+					continue
+				}
+				block, err := dbTx.FetchBlock(key)
+				if err != nil {
+					return err
+				}
+				err = writeSnapshotRecord(bw, snapshotRecordBlock, key[:], block)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		info.States = append(info.States, SnapshotState{
+			Height: info.Height,
+			Root:   tip.StateRoot,
+		})
+		for round := tip.Round - 1; round+snapshotRoundDepth >= tip.Round; round-- {
+			if s, ok := roundStates[round]; ok {
+				info.States = append(info.States, s)
+			}
+			if round == 0 {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make([]common.Hash, 0, len(info.States))
+	for _, s := range info.States {
+		roots = append(roots, s.Root)
+	}
+	err = state.Walk(stateDB, roots, func(hash common.Hash, enc []byte) error {
+		return writeSnapshotRecord(bw, snapshotRecordState, hash[:], enc)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeSnapshotRecord(bw, snapshotRecordEnd); err != nil {
+		return nil, err
+	}
+	if err := serialization.WriteVarInt(bw, 0, uint64(len(info.States))); err != nil {
+		return nil, err
+	}
+	for _, s := range info.States {
+		if err := serialization.WriteUint32(bw, uint32(s.Height)); err != nil {
+			return nil, err
+		}
+		if _, err := bw.Write(s.Root[:]); err != nil {
+			return nil, err
+		}
+	}
+	copy(info.SnapshotHash[:], commitment.Sum(nil))
+	info.UtxoSetHash = setHash.Finalize()
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// snapshotPut is a metadata entry read from a snapshot, sub is the nested
+// bucket of the entry when it has one.
+type snapshotPut struct {
+	bucket []byte
+	sub    []byte
+	key    []byte
+	value  []byte
+}
+
+// ImportSnapshot bootstraps empty block and state databases from the snapshot
+// read from r.  The snapshot block must be one of the checkpoints and the
+// checkpoint must pin the hash of the utxo set.  The block index is checked
+// to link the genesis block of the network to the snapshot block through the
+// checkpoints, the states are checked against the state roots of the headers,
+// the utxo set and the bucket records against the hashes of the checkpoint and
+// the lock set to only lock unspent outputs.  The chain state is only written once every check
+// passed, the databases must be discarded when it fails.
+//
+// When retain is not zero, the state database is set up for pruning as
+// --prunestate would do.  The databases must not be in use.
+func ImportSnapshot(db database.Transactor, stateDB trie.IterableDatabase, r io.Reader,
+	params *chaincfg.Params, checkpoints []chaincfg.Checkpoint, retain int32,
+	interrupt <-chan struct{}) (*SnapshotInfo, error) {
+
+	if retain != 0 && retain < MinStateRetain {
+		return nil, fmt.Errorf("the number of retained states may not be "+
+			"less than %d", MinStateRetain)
+	}
+
+	// Refuse to import into databases which are in use already.
+	err := db.View(func(dbTx database.Tx) error {
+		if dbTx.Metadata().Get(chainStateKeyName) != nil {
+			return errors.New("the block database is not empty")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	it := stateDB.NewIterator()
+	empty := !it.Next()
+	it.Release()
+	if !empty {
+		return nil, errors.New("the state database is not empty")
+	}
+
+	br := bufio.NewReader(r)
+	var magic, version, net, height uint32
+	var totalTxns uint64
+	info := &SnapshotInfo{}
+	if err := serialization.ReadUint32(br, &magic); err != nil {
+		return nil, err
+	}
+	if magic != snapshotMagic {
+		return nil, errors.New("not a snapshot file")
+	}
+	if err := serialization.ReadUint32(br, &version); err != nil {
+		return nil, err
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	if err := serialization.ReadUint32(br, &net); err != nil {
+		return nil, err
+	}
+	if net != uint32(params.Net) {
+		return nil, fmt.Errorf("the snapshot is for network %v, not %v",
+			common.AsimovNet(net), params.Net)
+	}
+	if _, err := io.ReadFull(br, info.Hash[:]); err != nil {
+		return nil, err
+	}
+	if err := serialization.ReadUint32(br, &height); err != nil {
+		return nil, err
+	}
+	if err := serialization.ReadUint64(br, &totalTxns); err != nil {
+		return nil, err
+	}
+	info.Height = int32(height)
+
+	genesisHash := params.GenesisBlock.Header.BlockHash()
+	checkpointsByHeight := make(map[int32]*chaincfg.Checkpoint)
+	for i := range checkpoints {
+		checkpointsByHeight[checkpoints[i].Height] = &checkpoints[i]
+	}
+
+	// Only the snapshot of a checkpoint whose utxo set and bucket records are
+	// known is trusted.
+	trusted, ok := checkpointsByHeight[info.Height]
+	if !ok || !trusted.Hash.IsEqual(&info.Hash) {
+		return nil, fmt.Errorf("the snapshot block %v (height %d) is not a "+
+			"checkpoint", info.Hash, info.Height)
+	}
+	if trusted.UtxoSetHash == nil {
+		return nil, fmt.Errorf("the checkpoint at height %d has no utxo "+
+			"set hash", info.Height)
+	}
+	if trusted.SnapshotHash == nil {
+		return nil, fmt.Errorf("the checkpoint at height %d has no "+
+			"snapshot hash", info.Height)
+	}
+
+	err = db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		for _, name := range [][]byte{blockIndexBucketName, roundIndexBucketName,
+			hashIndexBucketName, spendJournalBucketName, utxoSetBucketName,
+			assetsSetBucketName, lockSetBucketName, balanceBucketName} {
+			if _, err := meta.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The metadata entries are written in chunks, the hash index entries
+	// of the main chain and the balance entries are derived from the block
+	// index and from the utxo set respectively.
+	var pending []snapshotPut
+	pendingSize := 0
+	flush := func() error {
+		err := db.Update(func(dbTx database.Tx) error {
+			meta := dbTx.Metadata()
+			for _, put := range pending {
+				bucket, err := meta.CreateBucketIfNotExists(put.bucket)
+				if err != nil {
+					return err
+				}
+				if put.sub != nil {
+					bucket, err = bucket.CreateBucketIfNotExists(put.sub)
+					if err != nil {
+						return err
+					}
+				}
+				if err := bucket.Put(put.key, put.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		pending = pending[:0]
+		pendingSize = 0
+		return err
+	}
+	put := func(p snapshotPut) error {
+		pending = append(pending, p)
+		pendingSize += len(p.key) + len(p.value)
+		if pendingSize >= snapshotFlushSize {
+			return flush()
+		}
+		return nil
+	}
+	allowed := make(map[string]struct{})
+	for _, name := range snapshotBuckets {
+		allowed[string(name)] = struct{}{}
+	}
+
+	var stateRoots []common.Hash
+	var prevHash common.Hash
+	blocks := make(map[database.BlockKey][]byte)
+	commitment := sha256.New()
+	setHash := muhash.New()
+	batch := stateDB.NewBatch()
+	for records := 1; ; records++ {
+		if records%100000 == 0 {
+			select {
+			case <-interrupt:
+				return nil, errors.New("snapshot import interrupted")
+			default:
+			}
+		}
+		kind, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if kind == snapshotRecordEnd {
+			break
+		}
+
+		switch kind {
+		case snapshotRecordBucket:
+			fields, err := readSnapshotFields(br, 3)
+			if err != nil {
+				return nil, err
+			}
+			name, key, value := fields[0], fields[1], fields[2]
+
+			if bytes.Equal(name, blockIndexBucketName) {
+				header, _, _, err := deserializeBlockRow(value)
+				if err != nil {
+					return nil, err
+				}
+				hash := header.BlockHash()
+				height := int32(len(stateRoots))
+				if !bytes.Equal(key, blockIndexKey(&hash, uint32(height))) ||
+					header.Height != height {
+					return nil, fmt.Errorf("unexpected block index entry %v "+
+						"at height %d", hash, height)
+				}
+				if height == 0 && !hash.IsEqual(&genesisHash) {
+					return nil, fmt.Errorf("the snapshot starts at block %v, "+
+						"not at the genesis block", hash)
+				}
+				if height > 0 && header.PrevBlock != prevHash {
+					return nil, fmt.Errorf("block %v at height %d does not "+
+						"connect to the previous block", hash, height)
+				}
+				if checkpoint, ok := checkpointsByHeight[height]; ok &&
+					!hash.IsEqual(checkpoint.Hash) {
+					return nil, fmt.Errorf("block %v at height %d does not "+
+						"match the checkpoint %v", hash, height, checkpoint.Hash)
+				}
+				stateRoots = append(stateRoots, header.StateRoot)
+				prevHash = hash
+
+				var serializedHeight [4]byte
+				byteOrder.PutUint32(serializedHeight[:], uint32(height))
+				err = put(snapshotPut{bucket: hashIndexBucketName,
+					key: hash[:], value: serializedHeight[:]})
+				if err != nil {
+					return nil, err
+				}
+			} else if _, ok := allowed[string(name)]; !ok {
+				return nil, fmt.Errorf("unexpected bucket %q in the snapshot",
+					name)
+			}
+
+			commitSnapshotRecord(commitment, name, key, value)
+			if bytes.Equal(name, utxoSetBucketName) {
+				setHash.Add(utxoSetHashElement(key, value))
+				entry, err := DeserializeUtxoEntry(value)
+				if err != nil {
+					return nil, err
+				}
+				_, addrs, _, err := txscript.ExtractPkScriptAddrs(entry.PkScript())
+				if err == nil && len(addrs) > 0 {
+					err = put(snapshotPut{bucket: balanceBucketName,
+						sub: addrs[0].ScriptAddress(), key: key, value: value})
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err := put(snapshotPut{bucket: name, key: key, value: value}); err != nil {
+				return nil, err
+			}
+
+		case snapshotRecordBlock:
+			fields, err := readSnapshotFields(br, 2)
+			if err != nil {
+				return nil, err
+			}
+			var key database.BlockKey
+			if len(fields[0]) != len(key) {
+				return nil, errors.New("malformed snapshot block key")
+			}
+			copy(key[:], fields[0])
+			blocks[key] = fields[1]
+
+		case snapshotRecordState:
+			fields, err := readSnapshotFields(br, 2)
+			if err != nil {
+				return nil, err
+			}
+			hash, enc := fields[0], fields[1]
+			if !bytes.Equal(hash, crypto.Keccak256(enc)) {
+				return nil, fmt.Errorf("state entry %x does not match its "+
+					"hash", hash)
+			}
+			if err := batch.Put(hash, enc); err != nil {
+				return nil, err
+			}
+			if batch.ValueSize() >= database.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					return nil, err
+				}
+				batch.Reset()
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown snapshot record type %d", kind)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	batch.Reset()
+
+	// Read the trailer and check the snapshot against it.
+	count, err := serialization.ReadVarInt(br, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 || count > snapshotRoundDepth+1 {
+		return nil, fmt.Errorf("unexpected number of snapshot states %d", count)
+	}
+	roots := make([]common.Hash, count)
+	for i := range roots {
+		var h uint32
+		if err := serialization.ReadUint32(br, &h); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(br, roots[i][:]); err != nil {
+			return nil, err
+		}
+		info.States = append(info.States, SnapshotState{Height: int32(h), Root: roots[i]})
+	}
+
+	if len(stateRoots) != int(info.Height)+1 || prevHash != info.Hash {
+		return nil, fmt.Errorf("the block index does not end at the "+
+			"snapshot block %v", info.Hash)
+	}
+	if info.States[0].Height != info.Height {
+		return nil, errors.New("the snapshot misses the state of its block")
+	}
+	for _, s := range info.States {
+		if s.Height < 0 || s.Height > info.Height || stateRoots[s.Height] != s.Root {
+			return nil, fmt.Errorf("state %v does not match the state root "+
+				"of the block at height %d", s.Root, s.Height)
+		}
+	}
+	if err := state.Walk(stateDB, roots, func(common.Hash, []byte) error { return nil }); err != nil {
+		return nil, fmt.Errorf("incomplete snapshot state: %v", err)
+	}
+	info.UtxoSetHash = setHash.Finalize()
+	if info.UtxoSetHash != *trusted.UtxoSetHash {
+		return nil, fmt.Errorf("utxo set hash mismatch - got %v, want %v "+
+			"from the checkpoint", info.UtxoSetHash, *trusted.UtxoSetHash)
+	}
+	copy(info.SnapshotHash[:], commitment.Sum(nil))
+	if info.SnapshotHash != *trusted.SnapshotHash {
+		return nil, fmt.Errorf("snapshot hash mismatch - got %v, want %v "+
+			"from the checkpoint", info.SnapshotHash, *trusted.SnapshotHash)
+	}
+	err = db.View(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+		return dbTx.Metadata().Bucket(lockSetBucketName).ForEach(func(k, v []byte) error {
+			if utxoBucket.Get(k) == nil {
+				return fmt.Errorf("lock set entry %x of an output which "+
+					"is not in the utxo set", k)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := blocks[*database.NewNormalBlockKey(&info.Hash)]; !ok {
+		return nil, errors.New("the snapshot misses its block")
+	}
+	for key, serialized := range blocks {
+		hash := common.Hash{}
+		copy(hash[:], key[:common.HashLength])
+		if !hash.IsEqual(&info.Hash) && !hash.IsEqual(&genesisHash) {
+			return nil, fmt.Errorf("unexpected block %v in the snapshot", hash)
+		}
+		if key.IsVirtual() {
+			continue
+		}
+		block, err := asiutil.NewBlockFromBytes(serialized)
+		if err != nil {
+			return nil, err
+		}
+		if !block.Hash().IsEqual(&hash) {
+			return nil, fmt.Errorf("snapshot block %v does not match its "+
+				"hash", hash)
+		}
+	}
+
+	// Set up the pruning of the state database, the imported states are
+	// journaled at their heights and the heights before them are pruned.
+	if retain != 0 {
+		if _, _, err := state.Prune(stateDB, roots, interrupt); err != nil {
+			return nil, err
+		}
+		pruned := info.Height
+		for _, s := range info.States {
+			if err := dbAppendStateRoot(stateDB, s.Height, s.Root); err != nil {
+				return nil, err
+			}
+			if s.Height-1 < pruned {
+				pruned = s.Height - 1
+			}
+		}
+		if err := dbPutStatePruned(stateDB, pruned); err != nil {
+			return nil, err
+		}
+	}
+
+	// Store the blocks and finally the chain state, which makes the
+	// database usable.
+	err = db.Update(func(dbTx database.Tx) error {
+		for key, serialized := range blocks {
+			key := key
+			if err := dbTx.StoreBlock(&key, serialized); err != nil {
+				return err
+			}
+		}
+		serializedBase := make([]byte, common.HashLength+4)
+		copy(serializedBase, info.Hash[:])
+		byteOrder.PutUint32(serializedBase[common.HashLength:], uint32(info.Height))
+		if err := dbTx.Metadata().Put(snapshotBaseKeyName, serializedBase); err != nil {
+			return err
+		}
+		if err := dbPutUtxoSetHash(dbTx, setHash); err != nil {
+			return err
+		}
+		return dbTx.Metadata().Put(chainStateKeyName, serializeBestChainState(
+			bestChainState{
+				hash:      info.Hash,
+				height:    uint32(info.Height),
+				totalTxns: totalTxns,
+			}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
@@ -0,0 +1,318 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/database/dbdriver"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// rewriteSnapshot returns the snapshot with its records changed by edit and
+// its trailer kept, as a forger would do.
+func rewriteSnapshot(snapshot []byte, edit func(records [][][]byte) ([][][]byte, error)) ([]byte, error) {
+	const headerSize = 56
+	r := bufio.NewReader(bytes.NewReader(snapshot[headerSize:]))
+	var out bytes.Buffer
+	out.Write(snapshot[:headerSize])
+
+	var records [][][]byte
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if kind == snapshotRecordEnd {
+			break
+		}
+		n := 2
+		if kind == snapshotRecordBucket {
+			n = 3
+		}
+		fields, err := readSnapshotFields(r, n)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, append([][]byte{{kind}}, fields...))
+	}
+	records, err := edit(records)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		if err := writeSnapshotRecord(&out, record[0][0], record[1:]...); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeSnapshotRecord(&out, snapshotRecordEnd); err != nil {
+		return nil, err
+	}
+	trailer, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	out.Write(trailer)
+	return out.Bytes(), nil
+}
+
+// swapSnapshotUtxos swaps the values of the first two utxo set records.
+func swapSnapshotUtxos(records [][][]byte) ([][][]byte, error) {
+	var utxos []int
+	for i, record := range records {
+		if record[0][0] == snapshotRecordBucket && bytes.Equal(record[1], utxoSetBucketName) {
+			utxos = append(utxos, i)
+		}
+	}
+	if len(utxos) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	first, second := records[utxos[0]], records[utxos[1]]
+	first[3], second[3] = second[3], first[3]
+	return records, nil
+}
+
+// addSnapshotAsset adds a record of an asset which was never created to the
+// assets set.
+func addSnapshotAsset(records [][][]byte) ([][][]byte, error) {
+	asset := protos.NewAsset(protos.DivisibleAsset, 1, 1).FixedBytes()
+	return append(records, [][]byte{{snapshotRecordBucket},
+		assetsSetBucketName, asset[:], {1}}), nil
+}
+
+// TestSnapshot ensures a snapshot exported from a chain imports into empty
+// databases at a checkpoint pinning its utxo set, and that snapshots of other
+// blocks or with a tampered utxo set are rejected.
+func TestSnapshot(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	for slot := uint16(0); slot < 3; slot++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+			chain, 1, slot, chain.bestChain.height(), protos.Asset{}, 0,
+			validators[slot], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err := chain.ProcessBlock(block, nil, nil, nil, 1); err != nil {
+			t.Fatalf("ProcessBlock error %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	exported, err := ExportSnapshot(chain.db, chain.ethDB, &buf, &netParam)
+	if err != nil {
+		t.Fatalf("ExportSnapshot: unexpected error: %v", err)
+	}
+	tip := chain.bestChain.Tip()
+	if exported.Hash != tip.hash || exported.Height != tip.height ||
+		exported.States[0].Root != tip.stateRoot {
+		t.Fatalf("unexpected snapshot %+v of tip %v (height %d)",
+			exported, tip.hash, tip.height)
+	}
+
+	setInfo, err := chain.FetchUtxoSetInfo()
+	if err != nil {
+		t.Fatalf("FetchUtxoSetInfo: unexpected error: %v", err)
+	}
+	if exported.UtxoSetHash != setInfo.SetHash {
+		t.Fatalf("unexpected utxo set hash - got %v, want %v",
+			exported.UtxoSetHash, setInfo.SetHash)
+	}
+	if exported.SnapshotHash != setInfo.SnapshotHash {
+		t.Fatalf("unexpected snapshot hash - got %v, want %v",
+			exported.SnapshotHash, setInfo.SnapshotHash)
+	}
+	checkpoints := []chaincfg.Checkpoint{
+		{Height: 1, Hash: &chain.bestChain.NodeByHeight(1).hash},
+		{Height: tip.height, Hash: &tip.hash, UtxoSetHash: &exported.UtxoSetHash,
+			SnapshotHash: &exported.SnapshotHash},
+	}
+
+	importSnapshot := func(snapshot []byte, checkpoints []chaincfg.Checkpoint) (*SnapshotInfo, error) {
+		dir, err := ioutil.TempDir("", "snapshot")
+		if err != nil {
+			t.Fatalf("TempDir: unexpected error: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		db, err := dbdriver.Create(database.FFLDB, filepath.Join(dir, "blocks"),
+			netParam.Net)
+		if err != nil {
+			t.Fatalf("Create: unexpected error: %v", err)
+		}
+		defer db.Close()
+		stateDB, err := ethdb.NewLDBDatabase(filepath.Join(dir, "state"), 16, 16)
+		if err != nil {
+			t.Fatalf("NewLDBDatabase: unexpected error: %v", err)
+		}
+		defer stateDB.Close()
+
+		info, err := ImportSnapshot(db, stateDB, bytes.NewReader(snapshot),
+			&netParam, checkpoints, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Check the imported data matches the source before closing.
+		err = db.View(func(dbTx database.Tx) error {
+			best, err := deserializeBestChainState(dbTx.Metadata().Get(chainStateKeyName))
+			if err != nil {
+				return err
+			}
+			if best.hash != tip.hash || int32(best.height) != tip.height {
+				t.Errorf("unexpected imported chain state %v (height %d)",
+					best.hash, best.height)
+			}
+			base, height, err := dbFetchSnapshotBase(dbTx)
+			if err != nil || base == nil || *base != tip.hash || height != tip.height {
+				t.Errorf("unexpected snapshot base %v (height %d, %v)", base, height, err)
+			}
+			var count int
+			err = dbTx.Metadata().Bucket(utxoSetBucketName).ForEach(func(k, v []byte) error {
+				count++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			var want int
+			chain.db.View(func(srcTx database.Tx) error {
+				return srcTx.Metadata().Bucket(utxoSetBucketName).ForEach(func(k, v []byte) error {
+					want++
+					return nil
+				})
+			})
+			if count != want {
+				t.Errorf("unexpected number of imported utxos - got %d, want %d",
+					count, want)
+			}
+			setHash, err := dbFetchUtxoSetHash(dbTx)
+			if err != nil || setHash == nil || setHash.Finalize() != exported.UtxoSetHash {
+				t.Errorf("unexpected imported utxo set hash %v (%v)", setHash, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The imported databases must load as a chain at the snapshot block.
+		imported, err := New(&Config{
+			DB:          db,
+			ChainParams: &netParam,
+			TimeSource:  NewMedianTime(),
+			StateDB:     stateDB,
+			BtcClient: NewFakeBtcClient(netParam.CollectHeight,
+				netParam.CollectInterval+int32(netParam.BtcBlocksPerRound)*24),
+			RoundManager:    NewRoundManager(),
+			ContractManager: NewContractManagerTmp(),
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if imported.bestChain.Tip().hash != tip.hash {
+			t.Errorf("unexpected imported tip %v", imported.bestChain.Tip().hash)
+		}
+		if base, height := imported.SnapshotBase(); base == nil ||
+			*base != tip.hash || height != tip.height {
+			t.Errorf("unexpected snapshot base %v (height %d)", base, height)
+		}
+		return info, nil
+	}
+
+	info, err := importSnapshot(buf.Bytes(), checkpoints)
+	if err != nil {
+		t.Fatalf("ImportSnapshot: unexpected error: %v", err)
+	}
+	if info.SnapshotHash != exported.SnapshotHash ||
+		info.UtxoSetHash != exported.UtxoSetHash {
+		t.Fatalf("unexpected imported snapshot %+v, want %+v", info, exported)
+	}
+
+	// The snapshot block must be a checkpoint pinning the utxo set.
+	otherHash := common.Hash{1}
+	wrongBlock := []chaincfg.Checkpoint{
+		{Height: tip.height, Hash: &otherHash, UtxoSetHash: &exported.UtxoSetHash,
+			SnapshotHash: &exported.SnapshotHash},
+	}
+	unpinned := []chaincfg.Checkpoint{{Height: tip.height, Hash: &tip.hash}}
+	noSnapshotHash := []chaincfg.Checkpoint{
+		{Height: tip.height, Hash: &tip.hash, UtxoSetHash: &exported.UtxoSetHash},
+	}
+	otherSet := []chaincfg.Checkpoint{
+		{Height: tip.height, Hash: &tip.hash, UtxoSetHash: &otherHash,
+			SnapshotHash: &exported.SnapshotHash},
+	}
+	otherSnapshot := []chaincfg.Checkpoint{
+		{Height: tip.height, Hash: &tip.hash, UtxoSetHash: &exported.UtxoSetHash,
+			SnapshotHash: &otherHash},
+	}
+	tests := []struct {
+		name        string
+		checkpoints []chaincfg.Checkpoint
+		err         string
+	}{
+		{"no checkpoint", nil, "is not a checkpoint"},
+		{"earlier checkpoint only", checkpoints[:1], "is not a checkpoint"},
+		{"wrong block", wrongBlock, "is not a checkpoint"},
+		{"no utxo set hash", unpinned, "has no utxo set hash"},
+		{"no snapshot hash", noSnapshotHash, "has no snapshot hash"},
+		{"other utxo set", otherSet, "utxo set hash mismatch"},
+		{"other snapshot", otherSnapshot, "snapshot hash mismatch"},
+	}
+	for _, test := range tests {
+		_, err := importSnapshot(buf.Bytes(), test.checkpoints)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	// Corrupt the last byte of the trailer.
+	tampered := append([]byte(nil), buf.Bytes()...)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := importSnapshot(tampered, checkpoints); err == nil {
+		t.Fatalf("ImportSnapshot: tampered snapshot was accepted")
+	}
+
+	// Forged utxos do not match the utxo set hash of the checkpoint, and
+	// forged records of the other buckets its snapshot hash.
+	forgeries := []struct {
+		name string
+		edit func([][][]byte) ([][][]byte, error)
+		err  string
+	}{
+		{"swapped utxos", swapSnapshotUtxos, "utxo set hash mismatch"},
+		{"added asset", addSnapshotAsset, "snapshot hash mismatch"},
+	}
+	for _, test := range forgeries {
+		forged, err := rewriteSnapshot(buf.Bytes(), test.edit)
+		if err != nil {
+			t.Fatalf("%s: rewriteSnapshot: unexpected error: %v", test.name, err)
+		}
+		_, err = importSnapshot(forged, checkpoints)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
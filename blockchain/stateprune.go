@@ -0,0 +1,344 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/trie"
+)
+
+// MinStateRetain is the minimum number of recent states a node pruning the
+// contract state keeps.  Reorganizations deeper than the retained window
+// can not be processed since the state of the fork point is gone.
+const MinStateRetain = 2880
+
+var (
+	// stateRootsPrefix is the state database key prefix of the journal of
+	// the state roots committed while pruning, each entry holds the roots
+	// referenced at a height, in the main chain or not.
+	stateRootsPrefix = []byte("state-roots-") // stateRootsPrefix + height (uint32) -> roots
+
+	// statePrunedKey is the state database key of the height up to which
+	// the states have been pruned.  Its presence marks a pruned database.
+	statePrunedKey = []byte("state-pruned-height")
+)
+
+// StatePrunedError identifies an attempt to access the contract state of a
+// block which was pruned from the state database.
+type StatePrunedError struct {
+	Height       int32 // Height of the block whose state is requested
+	PrunedHeight int32 // Height up to which the states are pruned
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e StatePrunedError) Error() string {
+	return fmt.Sprintf("state of block %d is not available, the states of "+
+		"the blocks up to height %d are pruned", e.Height, e.PrunedHeight)
+}
+
+// stateRootsKey returns the key of the state roots journal entry of a height.
+func stateRootsKey(height int32) []byte {
+	key := make([]byte, len(stateRootsPrefix)+4)
+	copy(key, stateRootsPrefix)
+	byteOrder.PutUint32(key[len(stateRootsPrefix):], uint32(height))
+	return key
+}
+
+// dbFetchStatePruned returns the height up to which the states of the state
+// database are pruned and whether the database is pruned at all.
+func dbFetchStatePruned(stateDB database.Database) (int32, bool, error) {
+	has, err := stateDB.Has(statePrunedKey)
+	if err != nil || !has {
+		return -1, false, err
+	}
+	serialized, err := stateDB.Get(statePrunedKey)
+	if err != nil {
+		return -1, false, err
+	}
+	if len(serialized) != 4 {
+		return -1, false, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: "corrupt state pruned height",
+		}
+	}
+	return int32(byteOrder.Uint32(serialized)), true, nil
+}
+
+// dbPutStatePruned stores the height up to which the states are pruned.
+func dbPutStatePruned(putter database.Putter, height int32) error {
+	var serialized [4]byte
+	byteOrder.PutUint32(serialized[:], uint32(height))
+	return putter.Put(statePrunedKey, serialized[:])
+}
+
+// dbFetchStateRoots returns the state roots referenced at a height.
+func dbFetchStateRoots(stateDB database.Database, height int32) ([]common.Hash, error) {
+	key := stateRootsKey(height)
+	has, err := stateDB.Has(key)
+	if err != nil || !has {
+		return nil, err
+	}
+	serialized, err := stateDB.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(serialized)%common.HashLength != 0 {
+		return nil, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt state roots at height %d", height),
+		}
+	}
+	roots := make([]common.Hash, len(serialized)/common.HashLength)
+	for i := range roots {
+		copy(roots[i][:], serialized[i*common.HashLength:])
+	}
+	return roots, nil
+}
+
+// dbAppendStateRoot adds a root to the state roots referenced at a height.
+func dbAppendStateRoot(stateDB database.Database, height int32, root common.Hash) error {
+	roots, err := dbFetchStateRoots(stateDB, height)
+	if err != nil {
+		return err
+	}
+	serialized := make([]byte, 0, (len(roots)+1)*common.HashLength)
+	for _, r := range roots {
+		serialized = append(serialized, r[:]...)
+	}
+	serialized = append(serialized, root[:]...)
+	return stateDB.Put(stateRootsKey(height), serialized)
+}
+
+// initStatePruning loads the pruning state of the state database and prunes
+// the states which fell out of the retained window since the last run.
+func (b *BlockChain) initStatePruning() error {
+	pruned, ok, err := dbFetchStatePruned(b.ethDB)
+	if err != nil {
+		return err
+	}
+	if b.stateRetain == 0 {
+		if ok {
+			return errors.New("the state database is pruned, --stateretain " +
+				"must be specified to use it")
+		}
+		return nil
+	}
+	if !ok {
+		return errors.New("the state database keeps every state, prune it " +
+			"with --prunestate before enabling --stateretain")
+	}
+
+	b.statePruned = pruned
+	b.pruneState(b.bestChain.Tip().height)
+	log.Infof("State pruning enabled, keeping the states of the last %d "+
+		"blocks (pruned up to height %d)", b.stateRetain, b.statePruned)
+	return nil
+}
+
+// referenceState keeps the committed state with the passed root, created at
+// the passed height, until the height falls out of the retained window.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) referenceState(height int32, root common.Hash) error {
+	if b.stateRetain == 0 {
+		return nil
+	}
+	// Add the reference before journaling it, a reference which is not in
+	// the journal is leaked on failure while a journaled one which is not
+	// referenced would delete nodes still in use.
+	if err := state.ReferenceState(b.stateCache, root); err != nil {
+		return err
+	}
+	return dbAppendStateRoot(b.ethDB, height, root)
+}
+
+// pruneState dereferences the states of the heights which are no longer in
+// the retained window once the passed height is connected.  The state of
+// the main chain block at a checkpoint is kept.  Failures are logged since
+// the block is connected already, the pruning resumes with the next block.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) pruneState(height int32) {
+	if b.stateRetain == 0 {
+		return
+	}
+	for h := b.statePruned + 1; h <= height-b.stateRetain; h++ {
+		roots, err := dbFetchStateRoots(b.ethDB, h)
+		if err != nil {
+			log.Errorf("Failed to load the state roots at height %d: %v", h, err)
+			return
+		}
+
+		// Drop the journal entry before dereferencing, a state which is
+		// dereferenced twice would lose nodes still in use.
+		batch := b.ethDB.NewBatch()
+		if err := batch.Delete(stateRootsKey(h)); err != nil {
+			log.Errorf("Failed to prune the state at height %d: %v", h, err)
+			return
+		}
+		if err := dbPutStatePruned(batch, h); err != nil {
+			log.Errorf("Failed to prune the state at height %d: %v", h, err)
+			return
+		}
+		if err := batch.Write(); err != nil {
+			log.Errorf("Failed to prune the state at height %d: %v", h, err)
+			return
+		}
+		b.statePruned = h
+
+		var keep *common.Hash
+		if _, ok := b.checkpointsByHeight[h]; ok {
+			if node := b.bestChain.NodeByHeight(h); node != nil {
+				keep = &node.stateRoot
+			}
+		}
+		deleted := 0
+		for _, root := range roots {
+			if keep != nil && root == *keep {
+				keep = nil
+				continue
+			}
+			n, err := state.DereferenceState(b.stateCache, root)
+			if err != nil {
+				log.Errorf("Failed to dereference state %v at height %d: %v",
+					root, h, err)
+				continue
+			}
+			deleted += n
+		}
+		log.Debugf("Pruned the states at height %d, %d roots, %d entries "+
+			"deleted", h, len(roots), deleted)
+	}
+}
+
+// stateRetained returns a StatePrunedError when the state of the block at
+// the passed height was pruned.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) stateRetained(height int32) error {
+	if height > b.statePruned {
+		return nil
+	}
+	if _, ok := b.checkpointsByHeight[height]; ok {
+		return nil
+	}
+	return StatePrunedError{Height: height, PrunedHeight: b.statePruned}
+}
+
+// CheckStateRetained returns a StatePrunedError when the contract state of
+// the block at the passed height is no longer available because it was
+// pruned, nil otherwise.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CheckStateRetained(height int32) error {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	return b.stateRetained(height)
+}
+
+// PruneState deletes from the state database every contract state but the
+// ones of the last retain blocks of the main chain and of the checkpoints.
+// The reference counts and the journal which let the node prune its state
+// while running with --stateretain are rebuilt.  It is meant to run once on
+// a database which kept every state, before pruning is enabled, and may be
+// run again to reclaim the space leaked by interrupted prunings.
+//
+// The block database and the state database must not be in use.
+func PruneState(db database.Transactor, stateDB trie.IterableDatabase,
+	checkpoints []chaincfg.Checkpoint, retain int32, interrupt <-chan struct{}) error {
+
+	if retain < MinStateRetain {
+		return fmt.Errorf("the number of retained states may not be less "+
+			"than %d", MinStateRetain)
+	}
+
+	// Collect the state roots of the retained blocks walking back from the
+	// tip, followed by the ones of the checkpoints out of the window.
+	var roots, retained []common.Hash
+	var tipHeight int32
+	err := db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Get(chainStateKeyName)
+		if serialized == nil {
+			return errors.New("the block database is not initialized")
+		}
+		best, err := deserializeBestChainState(serialized)
+		if err != nil {
+			return err
+		}
+		tipHeight = int32(best.height)
+
+		hash := best.hash
+		for i := int32(0); i < retain && i <= tipHeight; i++ {
+			header, err := dbFetchHeaderByHash(dbTx, &hash)
+			if err != nil {
+				return err
+			}
+			retained = append(retained, header.StateRoot)
+			hash = header.PrevBlock
+		}
+		for _, checkpoint := range checkpoints {
+			if checkpoint.Height > tipHeight-retain {
+				continue
+			}
+			header, err := dbFetchHeaderByHash(dbTx, checkpoint.Hash)
+			if err != nil {
+				return err
+			}
+			roots = append(roots, header.StateRoot)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	roots = append(roots, retained...)
+
+	log.Infof("Pruning the state database, keeping %d states...", len(roots))
+	kept, deleted, err := state.Prune(stateDB, roots, interrupt)
+	if err != nil {
+		return err
+	}
+
+	// Rebuild the journal, the roots of the retained blocks were collected
+	// from the tip down.
+	batch := stateDB.NewBatch()
+	it := stateDB.NewIteratorWithPrefix(stateRootsPrefix)
+	for it.Next() {
+		if err := batch.Delete(common.CopyBytes(it.Key())); err != nil {
+			it.Release()
+			return err
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+	for i, root := range retained {
+		if err := batch.Put(stateRootsKey(tipHeight-int32(i)), root.Bytes()); err != nil {
+			return err
+		}
+	}
+	pruned := tipHeight - retain
+	if pruned < -1 {
+		pruned = -1
+	}
+	if err := dbPutStatePruned(batch, pruned); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	log.Infof("Pruned the state database, %d entries kept, %d deleted",
+		kept, deleted)
+	return nil
+}
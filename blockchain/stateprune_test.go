@@ -0,0 +1,100 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+)
+
+// TestPruneState ensures the states falling out of the retained window are
+// deleted while the retained ones and the checkpoints stay readable.
+func TestPruneState(t *testing.T) {
+	const retain = 3
+	const checkpoint = 2
+
+	stateDB := ethdb.NewMemDatabase()
+	b := &BlockChain{
+		ethDB:       stateDB,
+		stateCache:  state.NewDatabase(stateDB),
+		bestChain:   newChainView(nil),
+		stateRetain: retain,
+		statePruned: -1,
+		checkpointsByHeight: map[int32]*chaincfg.Checkpoint{
+			checkpoint: {Height: checkpoint},
+		},
+	}
+	state.EnableRefcount(b.stateCache)
+
+	addr := common.HexToAddress("0x66a7c4d4b4bd4a2e1a3bd8bdb1a1b1d2e76d1b1c2f")
+	var parent *blockNode
+	var root common.Hash
+	var nodes []*blockNode
+	for height := int32(0); height < 10; height++ {
+		statedb, err := state.New(root, b.stateCache)
+		if err != nil {
+			t.Fatalf("state.New: unexpected error: %v", err)
+		}
+		statedb.AddBalance(addr, big.NewInt(int64(height+1)))
+		statedb.SetState(addr, common.BigToHash(big.NewInt(int64(height))),
+			common.BigToHash(big.NewInt(int64(height+1))))
+		root, err = statedb.Commit(true)
+		if err != nil {
+			t.Fatalf("Commit: unexpected error: %v", err)
+		}
+		if err := b.stateCache.TrieDB().Commit(root, false); err != nil {
+			t.Fatalf("TrieDB.Commit: unexpected error: %v", err)
+		}
+		if err := b.referenceState(height, root); err != nil {
+			t.Fatalf("referenceState: unexpected error: %v", err)
+		}
+
+		node := &blockNode{height: height, stateRoot: root, parent: parent}
+		b.bestChain.SetTip(node)
+		b.pruneState(height)
+		nodes = append(nodes, node)
+		parent = node
+	}
+
+	if b.statePruned != 9-retain {
+		t.Fatalf("unexpected pruned height - got %d, want %d",
+			b.statePruned, 9-retain)
+	}
+	pruned, ok, err := dbFetchStatePruned(stateDB)
+	if err != nil || !ok || pruned != b.statePruned {
+		t.Fatalf("unexpected stored pruned height %d (%v, %v)", pruned, ok, err)
+	}
+
+	for _, node := range nodes {
+		retained := node.height > b.statePruned || node.height == checkpoint
+		err := b.stateRetained(node.height)
+		if retained != (err == nil) {
+			t.Errorf("stateRetained(%d): unexpected result %v", node.height, err)
+			continue
+		}
+		if _, ok := err.(StatePrunedError); err != nil && !ok {
+			t.Errorf("stateRetained(%d): unexpected error type %T",
+				node.height, err)
+		}
+
+		// Read through a new cache, the recent tries are cached in memory.
+		statedb, err := state.New(node.stateRoot, state.NewDatabase(stateDB))
+		if err == nil {
+			statedb.GetState(addr, common.BigToHash(big.NewInt(int64(node.height))))
+			err = statedb.Error()
+		}
+		if retained && err != nil {
+			t.Errorf("state at height %d: unexpected error: %v", node.height, err)
+		}
+		if !retained && err == nil {
+			t.Errorf("state at height %d was not pruned", node.height)
+		}
+	}
+}
@@ -78,3 +78,95 @@ func (m *Manager) GetSignedUpValidators(
 
 	return validators, round32, nil
 }
+
+// GetSigningKeys returns the block signing keys registered by the validators
+// by calling the native signing key registry, the zero address for validators
+// which sign with their own key.  No key is registered before the native
+// system contracts are active.
+func (m *Manager) GetSigningKeys(
+	block *asiutil.Block,
+	stateDB vm.StateDB,
+	chainConfig *params.ChainConfig,
+	validators []common.Address) ([]common.Address, error) {
+
+	if !chainConfig.IsNativeContracts(big.NewInt(int64(block.Height()))) {
+		return make([]common.Address, len(validators)), nil
+	}
+
+	gas := uint64(common.SystemContractReadOnlyGas)
+	officialAddr := chaincfg.OfficialAddress
+	abi := vm.SigningKeyRegistryABI
+	funcName := "getSigningKeys"
+	runCode, err := fvm.PackFunctionArgs(abi, funcName, validators)
+	if err != nil {
+		return nil, err
+	}
+
+	result, _, err := fvm.CallReadOnlyFunction(officialAddr, block, m.chain, stateDB, chainConfig,
+		gas, common.SigningKeyRegistry, runCode)
+	if err != nil {
+		log.Errorf("Get signing keys failed, error: %s", err)
+		return nil, err
+	}
+
+	keys := make([]common.Address, 0)
+	err = fvm.UnPackFunctionResult(abi, &keys, funcName, result)
+	if err != nil {
+		log.Errorf("Get signing keys failed, error: %s", err)
+		return nil, err
+	}
+	if len(keys) != len(validators) {
+		errStr := "get signing keys failed, length of keys does not match length of validators"
+		log.Errorf("%s", errStr)
+		return nil, common.AssertError(errStr)
+	}
+	return keys, nil
+}
+
+// GetMisbehaviorHeights returns the height of the block which recorded the
+// last misbehavior reported against each validator by calling the native
+// evidence registry, zero for validators which never misbehaved.  No
+// misbehavior is recorded before the native system contracts are active.
+func (m *Manager) GetMisbehaviorHeights(
+	block *asiutil.Block,
+	stateDB vm.StateDB,
+	chainConfig *params.ChainConfig,
+	validators []common.Address) ([]int32, error) {
+
+	heights := make([]int32, len(validators))
+	if !chainConfig.IsNativeContracts(big.NewInt(int64(block.Height()))) {
+		return heights, nil
+	}
+
+	gas := uint64(common.SystemContractReadOnlyGas)
+	officialAddr := chaincfg.OfficialAddress
+	abi := vm.EvidenceRegistryABI
+	funcName := "getMisbehaviorHeights"
+	runCode, err := fvm.PackFunctionArgs(abi, funcName, validators)
+	if err != nil {
+		return nil, err
+	}
+
+	result, _, err := fvm.CallReadOnlyFunction(officialAddr, block, m.chain, stateDB, chainConfig,
+		gas, common.EvidenceRegistry, runCode)
+	if err != nil {
+		log.Errorf("Get misbehavior heights failed, error: %s", err)
+		return nil, err
+	}
+
+	bigHeights := make([]*big.Int, 0)
+	err = fvm.UnPackFunctionResult(abi, &bigHeights, funcName, result)
+	if err != nil {
+		log.Errorf("Get misbehavior heights failed, error: %s", err)
+		return nil, err
+	}
+	if len(bigHeights) != len(validators) {
+		errStr := "get misbehavior heights failed, length of heights does not match length of validators"
+		log.Errorf("%s", errStr)
+		return nil, common.AssertError(errStr)
+	}
+	for i, height := range bigHeights {
+		heights[i] = int32(height.Int64())
+	}
+	return heights, nil
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+)
+
+// TxTrace is the outcome of re-executing a transaction of a block.
+type TxTrace struct {
+	Tx      *asiutil.Tx
+	Index   int
+	Receipt *types.Receipt
+	GasUsed uint64
+
+	// Vtx is the virtual transaction generated by the contract execution,
+	// nil when no asset was transferred.
+	Vtx *protos.MsgTx
+}
+
+// traceSetup loads the main chain block with the passed hash along with the
+// utxos it spent and the state root of its parent.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) traceSetup(hash *common.Hash) (*asiutil.Block,
+	*txo.UtxoViewpoint, int32, common.Hash, error) {
+
+	node := b.index.LookupNode(hash)
+	if node == nil || !b.bestChain.Contains(node) {
+		str := fmt.Sprintf("block %s is not in the main chain", hash)
+		return nil, nil, 0, common.Hash{}, errNotInMainChain(str)
+	}
+	if node.parent == nil {
+		return nil, nil, 0, common.Hash{}, fmt.Errorf("genesis block %s "+
+			"can not be traced", hash)
+	}
+	if err := b.stateRetained(node.parent.height); err != nil {
+		return nil, nil, 0, common.Hash{}, err
+	}
+
+	block, vblock, err := asiutil.GetBlockPair(b.db, hash)
+	if err != nil {
+		return nil, nil, 0, common.Hash{}, err
+	}
+
+	// Rebuild the utxos the block spent by unspending them with the spend
+	// journal, the same way a block is detached during a reorganize.
+	view := txo.NewUtxoViewpoint()
+	view.SetBestHash(hash)
+	err = fetchInputUtxos(view, b.db, block)
+	if err != nil {
+		return nil, nil, 0, common.Hash{}, err
+	}
+	var stxos []txo.SpentTxOut
+	err = b.db.View(func(dbTx database.Tx) error {
+		stxos, err = dbFetchSpendJournalEntry(dbTx, block, vblock)
+		return err
+	})
+	if err != nil {
+		return nil, nil, 0, common.Hash{}, err
+	}
+	err = disconnectTransactions(view, b.db, block, stxos, vblock)
+	if err != nil {
+		return nil, nil, 0, common.Hash{}, err
+	}
+
+	return block, view, node.height, node.parent.stateRoot, nil
+}
+
+// TraceBlock re-executes the transactions of the main chain block with the
+// passed hash on top of the utxo set and the contract state of its parent.
+// newTracer is invoked for every traced transaction to obtain the tracer
+// the contracts are executed with.  When txIndex is not negative only the
+// transaction at that index is traced and the execution stops after it,
+// otherwise all transactions, including the coinbase, are traced.
+//
+// Nothing is written to the database.  The chain state lock is only held
+// while the block and the utxos it spent are loaded, the transactions are
+// replayed without it.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) TraceBlock(hash *common.Hash, txIndex int,
+	newTracer func(tx *asiutil.Tx) vm.Tracer) ([]*TxTrace, error) {
+
+	b.chainLock.RLock()
+	block, view, height, parentRoot, err := b.traceSetup(hash)
+	b.chainLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	txs := block.Transactions()
+	if txIndex >= len(txs) {
+		return nil, fmt.Errorf("transaction index %d out of range, block %s "+
+			"has %d transactions", txIndex, hash, len(txs))
+	}
+
+	statedb, err := state.New(parentRoot, b.stateCache)
+	if err != nil {
+		return nil, err
+	}
+
+	traces := make([]*TxTrace, 0, len(txs))
+	for i, tx := range txs {
+		fee, _, err := CheckTransactionInputs(tx, height, view, b)
+		if err != nil {
+			return nil, err
+		}
+
+		vmConfig := &b.vmConfig
+		traced := txIndex < 0 || i == txIndex
+		if traced {
+			vmConfig = &vm.Config{
+				Debug:            true,
+				Tracer:           newTracer(tx),
+				EWASMInterpreter: b.vmConfig.EWASMInterpreter,
+				FVMInterpreter:   b.vmConfig.FVMInterpreter,
+			}
+		}
+
+		statedb.Prepare(*tx.Hash(), *block.Hash(), i)
+		receipt, err, gasUsed, vtx, _ := b.connectTransaction(block, i, view, tx,
+			nil, statedb, fee, vmConfig)
+		if err != nil {
+			return nil, err
+		}
+		if !traced {
+			continue
+		}
+
+		traces = append(traces, &TxTrace{
+			Tx:      tx,
+			Index:   i,
+			Receipt: receipt,
+			GasUsed: gasUsed,
+			Vtx:     vtx,
+		})
+		if i == txIndex {
+			break
+		}
+	}
+
+	return traces, nil
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"sort"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// utxoSetHashKeyName is the name of the db key used to store the state of
+// the rolling hash of the utxo set.  The hash is only maintained once the
+// key exists.
+var utxoSetHashKeyName = []byte("utxosethash")
+
+// UtxoAssetInfo summarizes the unspent outputs of an asset.
+type UtxoAssetInfo struct {
+	Asset   protos.Asset
+	Outputs int64
+
+	// Amount is the sum of the output amounts, it is not computed for the
+	// indivisible assets whose amounts identify the tokens.
+	Amount int64
+}
+
+// UtxoSetInfo summarizes the utxo set at a block of the main chain.
+// SnapshotHash is the hash of the bucket records of a snapshot of the block,
+// see ExportSnapshot.
+type UtxoSetInfo struct {
+	Hash         common.Hash
+	Height       int32
+	SetHash      common.Hash
+	SnapshotHash common.Hash
+	Outputs      int64
+	Assets       []UtxoAssetInfo
+}
+
+// utxoSetHashElement returns the element of the utxo set hash of an entry of
+// the utxo set bucket, the concatenation of its key and its serialized value.
+func utxoSetHashElement(key, serialized []byte) []byte {
+	element := make([]byte, 0, len(key)+len(serialized))
+	element = append(element, key...)
+	return append(element, serialized...)
+}
+
+// dbFetchUtxoSetHash returns the rolling hash of the utxo set, nil when it is
+// not maintained.
+func dbFetchUtxoSetHash(dbTx database.Tx) (*muhash.MuHash, error) {
+	serialized := dbTx.Metadata().Get(utxoSetHashKeyName)
+	if serialized == nil {
+		return nil, nil
+	}
+	setHash, err := muhash.Deserialize(serialized)
+	if err != nil {
+		return nil, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: "corrupt utxo set hash",
+		}
+	}
+	return setHash, nil
+}
+
+// dbPutUtxoSetHash stores the rolling hash of the utxo set.
+func dbPutUtxoSetHash(dbTx database.Tx, setHash *muhash.MuHash) error {
+	return dbTx.Metadata().Put(utxoSetHashKeyName, setHash.Serialize())
+}
+
+// initUtxoSetHash computes the hash of the utxo set when it is not maintained
+// yet, e.g. for a database created before it was introduced or imported from
+// a snapshot.
+func (b *BlockChain) initUtxoSetHash() error {
+	return b.db.Update(func(dbTx database.Tx) error {
+		if dbTx.Metadata().Get(utxoSetHashKeyName) != nil {
+			return nil
+		}
+
+		log.Infof("Computing the UTXO set hash...")
+		setHash := muhash.New()
+		count := 0
+		err := dbTx.Metadata().Bucket(utxoSetBucketName).ForEach(func(k, v []byte) error {
+			setHash.Add(utxoSetHashElement(k, v))
+			count++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		log.Infof("Computed the UTXO set hash of %d outputs", count)
+		return dbPutUtxoSetHash(dbTx, setHash)
+	})
+}
+
+// FetchUtxoSetInfo returns the number of unspent outputs and the total amount
+// of each asset in the utxo set at the best block, along with the hash of the
+// set and the hash of a snapshot of the block.  Two nodes with the same utxo
+// set report the same hashes.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchUtxoSetInfo() (*UtxoSetInfo, error) {
+	info := &UtxoSetInfo{}
+	err := b.db.View(func(dbTx database.Tx) error {
+		// The best state and the utxo set are read in the same database
+		// transaction, so they match even if a block is connected meanwhile.
+		meta := dbTx.Metadata()
+		best, err := deserializeBestChainState(meta.Get(chainStateKeyName))
+		if err != nil {
+			return err
+		}
+		info.Hash = best.hash
+		info.Height = int32(best.height)
+
+		setHash, err := dbFetchUtxoSetHash(dbTx)
+		if err != nil {
+			return err
+		}
+		if setHash == nil {
+			return errors.New("the utxo set hash is not maintained")
+		}
+		info.SetHash = setHash.Finalize()
+
+		assets := make(map[protos.Asset]*UtxoAssetInfo)
+		commitment := sha256.New()
+		err = forEachSnapshotRecord(meta, best.height, func(name, k, v []byte) error {
+			commitSnapshotRecord(commitment, name, k, v)
+			if !bytes.Equal(name, utxoSetBucketName) {
+				return nil
+			}
+			entry, err := DeserializeUtxoEntry(v)
+			if err != nil {
+				return err
+			}
+			asset := *entry.Asset()
+			assetInfo, ok := assets[asset]
+			if !ok {
+				assetInfo = &UtxoAssetInfo{Asset: asset}
+				assets[asset] = assetInfo
+			}
+			assetInfo.Outputs++
+			if !asset.IsIndivisible() {
+				assetInfo.Amount += entry.Amount()
+			}
+			info.Outputs++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		copy(info.SnapshotHash[:], commitment.Sum(nil))
+		for _, assetInfo := range assets {
+			info.Assets = append(info.Assets, *assetInfo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(info.Assets, func(i, j int) bool {
+		a, b := info.Assets[i].Asset.FixedBytes(), info.Assets[j].Asset.FixedBytes()
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	return info, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// TestUtxoSetHash ensures the utxo set hash maintained while connecting
+// blocks matches the hash of the utxo set computed from scratch.
+func TestUtxoSetHash(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	for slot := uint16(0); slot < 3; slot++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+			chain, 1, slot, chain.bestChain.height(), protos.Asset{}, 0,
+			validators[slot], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err := chain.ProcessBlock(block, nil, nil, nil, 1); err != nil {
+			t.Fatalf("ProcessBlock error %v", err)
+		}
+	}
+
+	info, err := chain.FetchUtxoSetInfo()
+	if err != nil {
+		t.Fatalf("FetchUtxoSetInfo: unexpected error: %v", err)
+	}
+	tip := chain.bestChain.Tip()
+	if info.Hash != tip.hash || info.Height != tip.height {
+		t.Fatalf("unexpected utxo set info tip %v (height %d)", info.Hash, info.Height)
+	}
+
+	setHash := muhash.New()
+	var outputs int64
+	amounts := make(map[protos.Asset]int64)
+	err = chain.db.View(func(dbTx database.Tx) error {
+		return dbTx.Metadata().Bucket(utxoSetBucketName).ForEach(func(k, v []byte) error {
+			setHash.Add(utxoSetHashElement(k, v))
+			entry, err := DeserializeUtxoEntry(v)
+			if err != nil {
+				return err
+			}
+			amounts[*entry.Asset()] += entry.Amount()
+			outputs++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("View: unexpected error: %v", err)
+	}
+	if outputs == 0 || info.Outputs != outputs {
+		t.Fatalf("unexpected number of outputs - got %d, want %d", info.Outputs, outputs)
+	}
+	if want := setHash.Finalize(); info.SetHash != want {
+		t.Fatalf("unexpected utxo set hash - got %v, want %v", info.SetHash, want)
+	}
+	if len(info.Assets) != len(amounts) {
+		t.Fatalf("unexpected number of assets - got %d, want %d",
+			len(info.Assets), len(amounts))
+	}
+	for _, asset := range info.Assets {
+		if !asset.Asset.IsIndivisible() && asset.Amount != amounts[asset.Asset] {
+			t.Errorf("unexpected amount of asset %v - got %d, want %d",
+				asset.Asset, asset.Amount, amounts[asset.Asset])
+		}
+	}
+}
@@ -10,10 +10,13 @@ import (
 	"fmt"
 	"github.com/AsimovNetwork/asimov/ainterface"
 	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
+	"github.com/AsimovNetwork/asimov/asiutil/vrf"
 	"github.com/AsimovNetwork/asimov/blockchain/syscontract"
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/protos"
 	"github.com/AsimovNetwork/asimov/txscript"
@@ -25,7 +28,6 @@ import (
 	"math"
 	"math/big"
 	"sort"
-	"time"
 )
 
 const (
@@ -391,7 +393,7 @@ func CountP2SHSigOps(tx *asiutil.Tx, isCoinBaseTx bool, utxoView *txo.UtxoViewpo
 // are needed to pass along to checkProofOfWork.
 func checkBlockHeaderSanity(header *protos.BlockHeader, parent *blockNode) error {
 	// Ensure the block time is not too far in the future.
-	if header.Timestamp-time.Now().Unix() > int64(chaincfg.Cfg.MaxTimeOffset) {
+	if header.Timestamp-clock.Now().Unix() > int64(chaincfg.Cfg.MaxTimeOffset) {
 		str := fmt.Sprintf("block timestamp of %v is too far in the "+
 			"future, max validtime offset is %d", header.Timestamp, chaincfg.Cfg.MaxTimeOffset)
 		return ruleError(ErrTimeTooNew, str)
@@ -406,17 +408,17 @@ func checkBlockHeaderSanity(header *protos.BlockHeader, parent *blockNode) error
 		return ruleError(ErrBadSlotOrRound, str)
 	}
 	// compare two neighbor nodes time stamp.
-	delta := time.Now().Unix() - parent.timestamp
+	delta := clock.Now().Unix() - parent.timestamp
 	if parent.round.Round == 0 {
-		delta = time.Now().Unix() - chaincfg.ActiveNetParams.ChainStartTime
+		delta = clock.Now().Unix() - chaincfg.ActiveNetParams.ChainStartTime
 	}
 	maxslot := (delta + int64(chaincfg.Cfg.MaxTimeOffset)*2) / common.MinBlockInterval
-	slotcount := int64(header.Round-parent.round.Round)*int64(chaincfg.ActiveNetParams.RoundSize) +
+	slotcount := chaincfg.ActiveNetParams.SlotsBetween(parent.round.Round, header.Round) +
 		int64(header.SlotIndex)
 	if parent.round.Round > 0 {
 		slotcount -= int64(parent.slot)
 	} else {
-		slotcount -= int64(chaincfg.ActiveNetParams.RoundSize - 1)
+		slotcount -= int64(chaincfg.ActiveNetParams.RoundSizeAt(0) - 1)
 	}
 	if maxslot < slotcount {
 		str := fmt.Sprintf("block has too new slot/round: slot:%d, round:%d, delta %d",
@@ -633,7 +635,7 @@ func (b *BlockChain) checkBlockHeaderContext(header *protos.BlockHeader, prevNod
 	// range of allowed timestamp of the last several blocks.
 	interval := header.Timestamp - round.RoundStartUnix
 	expected := round.Duration * int64(header.SlotIndex)
-	expected = expected / int64(chaincfg.ActiveNetParams.RoundSize)
+	expected = expected / int64(chaincfg.ActiveNetParams.RoundSizeAt(round.Round))
 	if interval < expected-int64(chaincfg.Cfg.MaxTimeOffset) ||
 		interval > expected+int64(chaincfg.Cfg.MaxTimeOffset) {
 		str := "block timestamp %d - round start %d = %d is out of range [%d, %d]"
@@ -666,7 +668,7 @@ func (b *BlockChain) checkBlockHeaderContext(header *protos.BlockHeader, prevNod
 		return ruleError(ErrForkTooOld, str)
 	}
 
-	if header.SlotIndex >= chaincfg.ActiveNetParams.RoundSize {
+	if header.SlotIndex >= chaincfg.ActiveNetParams.RoundSizeAt(header.Round) {
 		str := fmt.Sprintf("slot is out of range: height=%d, round=%d, slot=%d",
 			header.Height, header.Round, header.SlotIndex)
 		return ruleError(ErrInvalidSlotIndex, str)
@@ -752,8 +754,18 @@ func (b *BlockChain) checkBlockContext(block *asiutil.Block, prevNode *blockNode
 			return err
 		}
 
-		// check if the coinbase in global table.
-		if !b.roundManager.HasValidator(header.CoinBase) {
+		// check if the coinbase in global table.  The coinbase is the
+		// validator itself, never the key signing its blocks.
+		validator, err := b.validatorOf(header.CoinBase, header.Round, prevNode)
+		if err != nil {
+			return err
+		}
+		if validator != header.CoinBase {
+			errStr := fmt.Sprintf("the miner %v is the signing key of validator %v",
+				header.CoinBase, validator)
+			return ruleError(ErrValidatorMismatch, errStr)
+		}
+		if !b.roundManager.HasValidator(validator) {
 			errStr := fmt.Sprintf("the miner is unknown %d", header.CoinBase)
 			return ruleError(ErrValidatorMismatch, errStr)
 		}
@@ -762,14 +774,60 @@ func (b *BlockChain) checkBlockContext(block *asiutil.Block, prevNode *blockNode
 		if err != nil {
 			return err
 		}
+
+		err = b.checkVrfProof(block, prevNode)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkVrfProof ensures the block carries the vrf proof of its producer when
+// the vrf is active in its round, and none otherwise.  The proof is checked
+// against the key which signed the block, for the input chaining the output
+// of the parent block.
+func (b *BlockChain) checkVrfProof(block *asiutil.Block, prevNode *blockNode) error {
+	header := &block.MsgBlock().Header
+	proof := block.MsgBlock().VrfProof
+	if !b.chainParams.VrfActiveAt(header.Round) {
+		if len(proof) > 0 {
+			str := fmt.Sprintf("block of round %d carries a vrf proof "+
+				"before the vrf is active", header.Round)
+			return ruleError(ErrBadVrfProof, str)
+		}
+		return nil
+	}
+	if len(proof) == 0 {
+		str := fmt.Sprintf("block of round %d misses the vrf proof of "+
+			"its producer", header.Round)
+		return ruleError(ErrBadVrfProof, str)
 	}
 
+	pubkey, err := crypto.SigToPub(block.Hash()[:], header.SigData[:])
+	if err != nil {
+		str := fmt.Sprintf("invalid signature data: %v", err)
+		return ruleError(ErrInvalidSigData, str)
+	}
+	alpha := vrf.BlockAlpha(prevNode.vrfOutput, header.Round, header.SlotIndex)
+	if _, err = vrf.Verify((*crypto.PublicKey)(pubkey), alpha, proof); err != nil {
+		str := fmt.Sprintf("block %v carries an invalid vrf proof: %v",
+			block.Hash(), err)
+		return ruleError(ErrBadVrfProof, str)
+	}
 	return nil
 }
 
-// Check signature only.
+// Check signature only.  The signatures are checked against the keys the
+// validators delegated the signing of their blocks to on the branch of the
+// block.
 func (b *BlockChain) checkSignatures(block *asiutil.Block) error {
 	header := &block.MsgBlock().Header
+	prevNode := b.index.LookupNode(&header.PrevBlock)
+	if prevNode == nil {
+		prevNode = b.bestChain.Tip()
+	}
 	bestHeight := header.Height - 1
 	for i, preSig := range block.MsgBlock().PreBlockSigs {
 		// The max height depth is 10
@@ -778,20 +836,33 @@ func (b *BlockChain) checkSignatures(block *asiutil.Block) error {
 			return ruleError(ErrBadPreSigHeight, errStr)
 		}
 
-		if !b.roundManager.HasValidator(preSig.Signer) {
+		// The signer is the validator itself, never the key signing its
+		// blocks, so the signatures are weighted by validator.
+		round, node := b.signedRound(preSig, prevNode.round.Round, prevNode)
+		validator, err := b.validatorOf(preSig.Signer, round, node)
+		if err != nil {
+			return err
+		}
+		if validator != preSig.Signer {
+			errStr := fmt.Sprintf("the Signer of preSig %v is the signing key of validator %v",
+				preSig.Signer, validator)
+			return ruleError(ErrValidatorMismatch, errStr)
+		}
+		if !b.roundManager.HasValidator(validator) {
 			errStr := fmt.Sprintf("the Signer of preSig is unknown %d", preSig.Signer)
 			return ruleError(ErrValidatorMismatch, errStr)
 		}
 
 		// signature verify
-		err := AddressVerifySignature(preSig.BlockHash[:], &preSig.Signer, preSig.Signature[:])
+		err = b.verifyBlockSign(preSig, prevNode.round.Round, prevNode)
 		if err != nil {
 			log.Warnf("Verify pre signature failed: height = %d, PreIndex = %d", header.Height, i)
 			return err
 		}
 	}
 
-	err := AddressVerifySignature(block.Hash()[:], &header.CoinBase, header.SigData[:])
+	err := b.verifyValidatorSignature(block.Hash()[:], &header.CoinBase, header.Round,
+		prevNode, header.SigData[:])
 	if err != nil {
 		log.Errorf("Verify signature failed: height=%d, round=%d, slot=%d, hash=%v",
 			header.Height, header.Round, header.SlotIndex, block.Hash())
@@ -1206,6 +1277,15 @@ func (b *BlockChain) GetValidators(round uint32) ([]*common.Address, map[common.
 	return b.GetValidatorsByNode(round, node)
 }
 
+// consensusContract returns the system contract of the consensus engine which
+// selects the validators of the round.
+func (b *BlockChain) consensusContract(round uint32) common.Address {
+	if rc, ok := b.roundManager.(ainterface.IRoundContract); ok {
+		return rc.GetContractByRound(round)
+	}
+	return b.roundManager.GetContract()
+}
+
 // GetValidatorsByNode depends on current round miners and pre-round last node.
 func (b *BlockChain) GetValidatorsByNode(round uint32, preroundLastNode *blockNode) ([]*common.Address, map[common.Address]uint16, error) {
 	if preroundLastNode == nil {
@@ -1228,7 +1308,7 @@ func (b *BlockChain) GetValidatorsByNode(round uint32, preroundLastNode *blockNo
 			return nil, nil, common.AssertError("stateDB is nil")
 		}
 
-		signupValidators, rounds, err := b.contractManager.GetSignedUpValidators(b.roundManager.GetContract(), block,
+		signupValidators, rounds, err := b.contractManager.GetSignedUpValidators(b.consensusContract(round), block,
 			stateDB, chaincfg.ActiveNetParams.FvmParam, mineraddrs)
 		if err != nil {
 			return nil, nil, err
@@ -1246,9 +1326,34 @@ func (b *BlockChain) GetValidatorsByNode(round uint32, preroundLastNode *blockNo
 		}
 		return signupValidators, filters, err
 	}
+	if seed, ok := roundSeed(round, preroundLastNode); ok {
+		if vrm, ok := b.roundManager.(ainterface.IVrfRoundManager); ok {
+			return vrm.GetValidatorsBySeed(preroundLastNode.hash, seed, round, fn)
+		}
+	}
 	return b.roundManager.GetValidators(preroundLastNode.hash, round, fn)
 }
 
+// roundSeed returns the seed selecting the validators of the round from the
+// vrf outputs of the blocks of the round of the pre-round last node, and
+// false when none of them carries a vrf proof.
+func roundSeed(round uint32, preroundLastNode *blockNode) (common.Hash, bool) {
+	var outputs []common.Hash
+	for node := preroundLastNode; node != nil &&
+		node.round.Round == preroundLastNode.round.Round; node = node.parent {
+		if node.vrfOutput != (common.Hash{}) {
+			outputs = append(outputs, node.vrfOutput)
+		}
+	}
+	if len(outputs) == 0 {
+		return common.Hash{}, false
+	}
+	for i, j := 0, len(outputs)-1; i < j; i, j = i+1, j-1 {
+		outputs[i], outputs[j] = outputs[j], outputs[i]
+	}
+	return vrf.RoundSeed(round, outputs), true
+}
+
 // checkConnectBlock performs several checks to confirm connecting the passed
 // block to the chain represented by the passed view does not violate any rules.
 // In addition, the passed view is updated to spend all of the referenced
@@ -1509,6 +1614,9 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *asiutil.Block, vi
 	if !bytes.Equal(node.stateRoot[:], stateRoot[:]) {
 		return nil, nil, ruleError(ErrStateRootNotMatch, "state root of the block is not matched.")
 	}
+	if err := b.referenceState(node.height, stateRoot); err != nil {
+		return nil, nil, err
+	}
 
 	updateFeeLockItems(block, view, totalFeeLockItems)
 
@@ -1522,6 +1630,10 @@ func (b *BlockChain) createCoinbaseContractOut(preround uint32, preroundLastNode
 	if err != nil {
 		return nil, err
 	}
+	err = b.penalizeMisbehaviors(preround, preroundLastNode, validators, actualBlocks)
+	if err != nil {
+		return nil, err
+	}
 	bigIntExpected := make([]*big.Int, len(expectedBlocks))
 	for i, v := range expectedBlocks {
 		bigIntExpected[i] = big.NewInt(int64(v))
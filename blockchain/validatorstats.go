@@ -0,0 +1,432 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+)
+
+const (
+	// validatorStatsKeySize is the size of the key of the statistics of a
+	// validator in a round: the big endian round, so the keys of a round
+	// are contiguous, followed by the address of the validator.
+	validatorStatsKeySize = 4 + common.AddressLength
+
+	// validatorStatsSize is the size of serialized statistics.
+	validatorStatsSize = 4 + 4 + 4 + 8
+)
+
+var (
+	// validatorStatsBucketName is the name of the db bucket used to house
+	// the statistics of the validators per round.
+	validatorStatsBucketName = []byte("validatorstats")
+
+	// validatorStatsJournalBucketName is the name of the db bucket used to
+	// house the statistics each main chain block added, keyed by block
+	// hash, so they are removed when the block is disconnected.
+	validatorStatsJournalBucketName = []byte("validatorstatsjournal")
+)
+
+// ValidatorStats are the statistics of a validator in a round, for the
+// blocks of the main chain.
+type ValidatorStats struct {
+	// Produced is the number of slots of the validator with a block.
+	Produced uint32
+
+	// Missed is the number of slots of the validator without a block.  The
+	// slots after the tip are counted once the next block is connected.
+	Missed uint32
+
+	// Signed is the number of blocks of the round the validator signed,
+	// whose signatures were carried by a later block.
+	Signed uint32
+
+	// Latency is the total number of seconds between the start of the
+	// slots of the produced blocks and their timestamps.
+	Latency int64
+}
+
+// AverageLatency returns the average number of seconds between the start of
+// the slots of the produced blocks and their timestamps.
+func (s *ValidatorStats) AverageLatency() float64 {
+	if s.Produced == 0 {
+		return 0
+	}
+	return float64(s.Latency) / float64(s.Produced)
+}
+
+// add adds or, when sign is negative, subtracts the statistics.
+func (s *ValidatorStats) add(other *ValidatorStats, sign int) {
+	if sign < 0 {
+		s.Produced -= other.Produced
+		s.Missed -= other.Missed
+		s.Signed -= other.Signed
+		s.Latency -= other.Latency
+		return
+	}
+	s.Produced += other.Produced
+	s.Missed += other.Missed
+	s.Signed += other.Signed
+	s.Latency += other.Latency
+}
+
+// RoundValidatorStats are the statistics of a validator in a round.
+type RoundValidatorStats struct {
+	Round uint32
+	ValidatorStats
+}
+
+// RoundSlot is a slot of a round, with its validator and the block of the
+// main chain produced in it, if any.  A slot before the tip without a block
+// is missed.
+type RoundSlot struct {
+	Slot      uint16
+	Validator common.Address
+	Block     *common.Hash
+	Height    int32
+	Missed    bool
+}
+
+// validatorStatsEntry is the change a block makes to the statistics of a
+// validator in a round.
+type validatorStatsEntry struct {
+	round     uint32
+	validator common.Address
+	stats     ValidatorStats
+}
+
+// validatorStatsKey returns the key of the statistics of the validator in
+// the round.
+func validatorStatsKey(round uint32, validator *common.Address) []byte {
+	key := make([]byte, validatorStatsKeySize)
+	binary.BigEndian.PutUint32(key, round)
+	copy(key[4:], validator[:])
+	return key
+}
+
+func serializeValidatorStats(stats *ValidatorStats) []byte {
+	serialized := make([]byte, validatorStatsSize)
+	byteOrder.PutUint32(serialized, stats.Produced)
+	byteOrder.PutUint32(serialized[4:], stats.Missed)
+	byteOrder.PutUint32(serialized[8:], stats.Signed)
+	byteOrder.PutUint64(serialized[12:], uint64(stats.Latency))
+	return serialized
+}
+
+func deserializeValidatorStats(serialized []byte) (*ValidatorStats, error) {
+	if len(serialized) != validatorStatsSize {
+		return nil, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: "corrupt validator stats",
+		}
+	}
+	return &ValidatorStats{
+		Produced: byteOrder.Uint32(serialized),
+		Missed:   byteOrder.Uint32(serialized[4:]),
+		Signed:   byteOrder.Uint32(serialized[8:]),
+		Latency:  int64(byteOrder.Uint64(serialized[12:])),
+	}, nil
+}
+
+// dbApplyValidatorStats adds or, when sign is negative, subtracts the
+// entries to the statistics stored.
+func dbApplyValidatorStats(bucket database.Bucket, entries []validatorStatsEntry, sign int) error {
+	for i := range entries {
+		entry := &entries[i]
+		key := validatorStatsKey(entry.round, &entry.validator)
+		stats := &ValidatorStats{}
+		if serialized := bucket.Get(key); serialized != nil {
+			var err error
+			stats, err = deserializeValidatorStats(serialized)
+			if err != nil {
+				return err
+			}
+		}
+		stats.add(&entry.stats, sign)
+		var err error
+		if *stats == (ValidatorStats{}) {
+			err = bucket.Delete(key)
+		} else {
+			err = bucket.Put(key, serializeValidatorStats(stats))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbPutValidatorStats adds the entries of the block to the statistics and
+// journals them.
+func dbPutValidatorStats(dbTx database.Tx, hash *common.Hash, entries []validatorStatsEntry) error {
+	meta := dbTx.Metadata()
+	bucket, err := meta.CreateBucketIfNotExists(validatorStatsBucketName)
+	if err != nil {
+		return err
+	}
+	journal, err := meta.CreateBucketIfNotExists(validatorStatsJournalBucketName)
+	if err != nil {
+		return err
+	}
+	if err := dbApplyValidatorStats(bucket, entries, 1); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for i := range entries {
+		entry := &entries[i]
+		buf.Write(validatorStatsKey(entry.round, &entry.validator))
+		buf.Write(serializeValidatorStats(&entry.stats))
+	}
+	return journal.Put(hash[:], buf.Bytes())
+}
+
+// dbRemoveValidatorStats subtracts the entries journaled for the block from
+// the statistics.  The blocks connected before the statistics were kept
+// have no journal.
+func dbRemoveValidatorStats(dbTx database.Tx, hash *common.Hash) error {
+	meta := dbTx.Metadata()
+	bucket := meta.Bucket(validatorStatsBucketName)
+	journal := meta.Bucket(validatorStatsJournalBucketName)
+	if bucket == nil || journal == nil {
+		return nil
+	}
+	serialized := journal.Get(hash[:])
+	if serialized == nil {
+		return nil
+	}
+
+	const entrySize = validatorStatsKeySize + validatorStatsSize
+	if len(serialized)%entrySize != 0 {
+		return database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt validator stats journal of block %v", hash),
+		}
+	}
+	entries := make([]validatorStatsEntry, 0, len(serialized)/entrySize)
+	for offset := 0; offset < len(serialized); offset += entrySize {
+		entry := validatorStatsEntry{
+			round: binary.BigEndian.Uint32(serialized[offset:]),
+		}
+		copy(entry.validator[:], serialized[offset+4:offset+validatorStatsKeySize])
+		stats, err := deserializeValidatorStats(
+			serialized[offset+validatorStatsKeySize : offset+entrySize])
+		if err != nil {
+			return err
+		}
+		entry.stats = *stats
+		entries = append(entries, entry)
+	}
+	if err := dbApplyValidatorStats(bucket, entries, -1); err != nil {
+		return err
+	}
+	return journal.Delete(hash[:])
+}
+
+// validatorStatsPruneHeight returns the height of the highest main chain block
+// whose journal is no longer needed while the node is the tip and the passed
+// block is final.  A block is pruned once it is final, so it is never
+// disconnected, and deeper than the blocks the signatures of a new block may
+// refer to.
+func validatorStatsPruneHeight(tip, finalized *blockNode) int32 {
+	height := tip.height - finalityDepth
+	if finalized.height < height {
+		height = finalized.height
+	}
+	return height
+}
+
+// dbPruneValidatorStats removes the journals of the main chain blocks from the
+// node down to the passed height, excluded.
+func dbPruneValidatorStats(dbTx database.Tx, node *blockNode, height int32) error {
+	journal := dbTx.Metadata().Bucket(validatorStatsJournalBucketName)
+	if journal == nil {
+		return nil
+	}
+	for ; node != nil && node.height > height; node = node.parent {
+		if err := journal.Delete(node.hash[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// slotStartTime returns the time the slot of the node starts at.
+func slotStartTime(node *blockNode) int64 {
+	round := node.round
+	roundSize := int64(chaincfg.ActiveNetParams.RoundSizeAt(round.Round))
+	return round.RoundStartUnix + round.Duration*int64(node.slot)/roundSize
+}
+
+// validatorStatsEntries returns the changes the block of the node, which
+// extends the main chain, makes to the statistics of the validators: the
+// block its validator produced, the slots since the parent whose validators
+// missed them and the signatures of earlier blocks it carries.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) validatorStatsEntries(node *blockNode, block *asiutil.Block) []validatorStatsEntry {
+	type statsKey struct {
+		round     uint32
+		validator common.Address
+	}
+	changes := make(map[statsKey]*ValidatorStats)
+	change := func(round uint32, validator common.Address) *ValidatorStats {
+		key := statsKey{round: round, validator: validator}
+		stats, ok := changes[key]
+		if !ok {
+			stats = &ValidatorStats{}
+			changes[key] = stats
+		}
+		return stats
+	}
+
+	produced := change(node.round.Round, node.coinbase)
+	produced.Produced++
+	produced.Latency += node.timestamp - slotStartTime(node)
+
+	// The round 0 only holds the genesis block, it has no validators.
+	if parent := node.parent; parent != nil {
+		for round := parent.round.Round; round <= node.round.Round; round++ {
+			if round == 0 {
+				continue
+			}
+			from, to := uint16(0), chaincfg.ActiveNetParams.RoundSizeAt(round)
+			if round == parent.round.Round {
+				from = parent.slot + 1
+			}
+			if round == node.round.Round {
+				to = node.slot
+			}
+			if from >= to {
+				continue
+			}
+			validators, _, err := b.GetValidatorsByNode(round, findPreroundLastNode(round, parent))
+			if err != nil {
+				log.Warnf("Failed to get the validators of round %d missing "+
+					"slots: %v", round, err)
+				continue
+			}
+			for slot := from; slot < to && int(slot) < len(validators); slot++ {
+				log.Debugf("Validator %v missed slot %d of round %d",
+					validators[slot], slot, round)
+				change(round, *validators[slot]).Missed++
+			}
+		}
+	}
+
+	for _, sign := range block.Signs() {
+		signed := b.bestChain.NodeByHeight(sign.MsgSign.BlockHeight)
+		if signed == nil || signed.hash != sign.MsgSign.BlockHash {
+			continue
+		}
+		change(signed.round.Round, sign.MsgSign.Signer).Signed++
+	}
+
+	entries := make([]validatorStatsEntry, 0, len(changes))
+	for key, stats := range changes {
+		entries = append(entries, validatorStatsEntry{
+			round:     key.round,
+			validator: key.validator,
+			stats:     *stats,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].round != entries[j].round {
+			return entries[i].round < entries[j].round
+		}
+		return bytes.Compare(entries[i].validator[:], entries[j].validator[:]) < 0
+	})
+	return entries
+}
+
+// GetValidatorStats returns the statistics of the validator in the rounds
+// from fromRound to toRound included, for the rounds it has any.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) GetValidatorStats(validator common.Address, fromRound, toRound uint32) (
+	[]RoundValidatorStats, error) {
+
+	var result []RoundValidatorStats
+	err := b.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(validatorStatsBucketName)
+		if bucket == nil {
+			return nil
+		}
+		for round := fromRound; round <= toRound; round++ {
+			serialized := bucket.Get(validatorStatsKey(round, &validator))
+			if serialized != nil {
+				stats, err := deserializeValidatorStats(serialized)
+				if err != nil {
+					return err
+				}
+				result = append(result, RoundValidatorStats{
+					Round:          round,
+					ValidatorStats: *stats,
+				})
+			}
+			if round == toRound {
+				break
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetRoundSchedule returns the slots of the round, up to the round after the
+// one of the tip, with their validators and the blocks of the main chain
+// produced in them.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) GetRoundSchedule(round uint32) ([]RoundSlot, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	tip := b.bestChain.Tip()
+	if round > tip.round.Round+1 {
+		return nil, fmt.Errorf("round %d is after the next round %d",
+			round, tip.round.Round+1)
+	}
+	validators, _, err := b.GetValidatorsByNode(round, findPreroundLastNode(round, tip))
+	if err != nil {
+		return nil, err
+	}
+	roundSize := chaincfg.ActiveNetParams.RoundSizeAt(round)
+	slots := make([]RoundSlot, 0, roundSize)
+	for slot := uint16(0); slot < roundSize && int(slot) < len(validators); slot++ {
+		slots = append(slots, RoundSlot{
+			Slot:      slot,
+			Validator: *validators[slot],
+		})
+	}
+
+	// The rounds of the main chain never decrease, so the first block of
+	// the round is found with a binary search.
+	height := sort.Search(int(tip.height)+1, func(height int) bool {
+		return b.bestChain.NodeByHeight(int32(height)).round.Round >= round
+	})
+	for node := b.bestChain.NodeByHeight(int32(height)); node != nil &&
+		node.round.Round == round; node = b.bestChain.Next(node) {
+		if int(node.slot) < len(slots) {
+			hash := node.hash
+			slots[node.slot].Block = &hash
+			slots[node.slot].Height = node.height
+		}
+	}
+	for i := range slots {
+		slot := &slots[i]
+		slot.Missed = slot.Block == nil && (round < tip.round.Round ||
+			round == tip.round.Round && slot.Slot < tip.slot)
+	}
+	return slots, nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// TestValidatorStats ensures the blocks produced and the slots missed by the
+// validators are accounted per round, and reverted with their blocks.
+func TestValidatorStats(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	// The validator produces the slots 0 and 3 of round 1 and misses the
+	// slots 1 and 2.
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	validator := *validators[0]
+	for _, slot := range []uint16{0, 3} {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+			chain, 1, slot, chain.bestChain.height(), protos.Asset{}, 0,
+			validators[slot], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err := chain.ProcessBlock(block, nil, nil, nil, 1); err != nil {
+			t.Fatalf("ProcessBlock error %v", err)
+		}
+	}
+
+	stats, err := chain.GetValidatorStats(validator, 0, 5)
+	if err != nil {
+		t.Fatalf("GetValidatorStats error %v", err)
+	}
+	if len(stats) != 1 || stats[0].Round != 1 || stats[0].Produced != 2 ||
+		stats[0].Missed != 2 {
+		t.Fatalf("GetValidatorStats: got %+v, want 2 blocks and 2 missed slots in round 1", stats)
+	}
+
+	slots, err := chain.GetRoundSchedule(1)
+	if err != nil {
+		t.Fatalf("GetRoundSchedule error %v", err)
+	}
+	if len(slots) != int(netParam.RoundSize) {
+		t.Fatalf("GetRoundSchedule: got %d slots, want %d", len(slots), netParam.RoundSize)
+	}
+	tip := chain.bestChain.tip()
+	for _, slot := range slots {
+		produced := slot.Slot == 0 || slot.Slot == 3
+		missed := slot.Slot == 1 || slot.Slot == 2
+		if slot.Validator != validator || (slot.Block != nil) != produced ||
+			slot.Missed != missed {
+			t.Errorf("GetRoundSchedule: unexpected slot %+v", slot)
+		}
+	}
+	if *slots[3].Block != tip.hash || slots[3].Height != tip.height {
+		t.Errorf("GetRoundSchedule: slot 3 has block %v at %d, want the tip",
+			slots[3].Block, slots[3].Height)
+	}
+
+	// Disconnecting the tip removes its block and the slots it reported
+	// missed.
+	err = chain.db.Update(func(dbTx database.Tx) error {
+		return dbRemoveValidatorStats(dbTx, &tip.hash)
+	})
+	if err != nil {
+		t.Fatalf("dbRemoveValidatorStats error %v", err)
+	}
+	stats, err = chain.GetValidatorStats(validator, 1, 1)
+	if err != nil {
+		t.Fatalf("GetValidatorStats error %v", err)
+	}
+	if len(stats) != 1 || stats[0].Produced != 1 || stats[0].Missed != 0 {
+		t.Errorf("GetValidatorStats: got %+v after the removal, want 1 block", stats)
+	}
+}
+
+// TestValidatorStatsPrune ensures the journals of the blocks which are final
+// and deeper than the tracked signatures are pruned as blocks are connected.
+func TestValidatorStatsPrune(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 20)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	for slot := uint16(0); slot < finalityDepth+2; slot++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+			chain, 1, slot, chain.bestChain.height(), protos.Asset{}, 0,
+			validators[slot], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err := chain.ProcessBlock(block, nil, nil, nil, 1); err != nil {
+			t.Fatalf("ProcessBlock error %v", err)
+		}
+	}
+
+	// Every block is final, only the ones deeper than finalityDepth are
+	// pruned.
+	err = chain.db.View(func(dbTx database.Tx) error {
+		journal := dbTx.Metadata().Bucket(validatorStatsJournalBucketName)
+		for height := int32(1); height <= chain.bestChain.height(); height++ {
+			node := chain.bestChain.NodeByHeight(height)
+			pruned := height <= chain.bestChain.height()-finalityDepth
+			if (journal.Get(node.hash[:]) == nil) != pruned {
+				t.Errorf("journal of block at height %d: want pruned %v", height, pruned)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View error %v", err)
+	}
+}
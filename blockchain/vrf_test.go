@@ -0,0 +1,93 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// TestVrfProof ensures the blocks carry the vrf proofs of their producers once
+// the vrf is active, their outputs are stored with the block index and seed
+// the selection of the validators of the next round.
+func TestVrfProof(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	if err != nil || chain == nil {
+		t.Fatalf("createFakeChainByPrivateKeys error %v", err)
+	}
+	defer teardownFunc()
+
+	schedule := chain.chainParams.ConsensusSchedule
+	chain.chainParams.ConsensusSchedule = []chaincfg.ConsensusEpoch{{StartRound: 1, Vrf: true}}
+	defer func() {
+		chain.chainParams.ConsensusSchedule = schedule
+	}()
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	prevNode := chain.bestChain.tip()
+	block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+		chain, 1, 0, chain.bestChain.height(), protos.Asset{}, 0,
+		validators[0], nil, 0, prevNode)
+	if err != nil {
+		t.Fatalf("create block error %v", err)
+	}
+	if err := chain.checkVrfProof(block, prevNode); err != nil {
+		t.Fatalf("checkVrfProof error %v", err)
+	}
+
+	missing := *block.MsgBlock()
+	missing.VrfProof = nil
+	if err := chain.checkVrfProof(asiutil.NewBlock(&missing), prevNode); !isRuleError(err, ErrBadVrfProof) {
+		t.Errorf("checkVrfProof: a block without proof is accepted, got %v", err)
+	}
+	tampered := *block.MsgBlock()
+	tampered.VrfProof = append([]byte(nil), block.MsgBlock().VrfProof...)
+	tampered.VrfProof[40] ^= 1
+	if err := chain.checkVrfProof(asiutil.NewBlock(&tampered), prevNode); !isRuleError(err, ErrBadVrfProof) {
+		t.Errorf("checkVrfProof: a tampered proof is accepted, got %v", err)
+	}
+	otherParent := *prevNode
+	otherParent.vrfOutput = common.HexToHash("0x01")
+	if err := chain.checkVrfProof(block, &otherParent); !isRuleError(err, ErrBadVrfProof) {
+		t.Errorf("checkVrfProof: a proof for another parent is accepted, got %v", err)
+	}
+
+	if _, _, err := chain.ProcessBlock(block, nil, nil, nil, 1); err != nil {
+		t.Fatalf("ProcessBlock error %v", err)
+	}
+	tip := chain.bestChain.tip()
+	if tip.vrfOutput == (common.Hash{}) {
+		t.Fatalf("ProcessBlock: the vrf output of the block is not stored")
+	}
+	seed, ok := roundSeed(2, tip)
+	if !ok || seed == *chain.chainParams.GenesisHash {
+		t.Errorf("roundSeed: got %v, %v", seed, ok)
+	}
+	err = chain.db.View(func(dbTx database.Tx) error {
+		row := dbTx.Metadata().Bucket(blockIndexBucketName).Get(
+			blockIndexKey(&tip.hash, uint32(tip.height)))
+		_, _, status, output, err := deserializeBlockRowVrf(row)
+		if err == nil && (status&statusVrfOutput == 0 || output != tip.vrfOutput) {
+			t.Errorf("deserializeBlockRowVrf: got %x with status %x", output, status)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("deserializeBlockRowVrf error %v", err)
+	}
+
+	chain.chainParams.ConsensusSchedule = schedule
+	if err := chain.checkVrfProof(block, prevNode); !isRuleError(err, ErrBadVrfProof) {
+		t.Errorf("checkVrfProof: a proof is accepted before the vrf is active, got %v", err)
+	}
+}
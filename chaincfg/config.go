@@ -46,6 +46,8 @@ const (
 	DefaultMinTxPrice            = 0.01
 	DefaultMaxOrphanTransactions = 100
 	DefaultMaxOrphanTxSize       = 100000
+	DefaultMaxMempool            = 300
+	DefaultMempoolExpiry         = time.Hour * 336
 	DefaultAutoSignUpGasLimit    = 300000
 	DefaultMergeLimit            = 10
 
@@ -148,7 +150,7 @@ type FConfig struct {
 	RejectReplacement    bool          `long:"rejectreplacement" description:"Reject transactions that attempt to replace existing transactions within the mempool through the Replace-By-Price (RBP) signaling policy."`
 	DevelopNet           bool          `long:"devnet" description:"Use the develop network"`
 	ChainId              uint64        `long:"chainid" description:"Use distinguish different chain, the main chain occupy zero, each subchain take a positive integer"`
-	AddCheckpointsArr    []string      `long:"addcheckpoint" description:"Add a custom checkpoint.  Format: '<height>:<hash>'"`
+	AddCheckpointsArr    []string      `long:"addcheckpoint" description:"Add a custom checkpoint.  Format: '<height>:<hash>[:<utxo set hash>[:<snapshot hash>]]'"`
 	DisableCheckpoints   bool          `long:"nocheckpoints" description:"Disable built-in checkpoints.  Don't do this unless you know what you're doing."`
 	Profile              string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	CPUProfile           string        `long:"cpuprofile" description:"Write CPU profile to the specified file"`
@@ -162,16 +164,30 @@ type FConfig struct {
 	DisableBlockEarly    bool          `long:"disableblockearly" description:"Generate blocks early when the last block is received and consensus is satoshi"`
 	MaxOrphanTxs         int           `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
 	MaxOrphanTxSize      int           `long:"maxorphantxsize" description:"Max size of an orphan transaction to allow in memory"`
+	MaxMempool           int64         `long:"maxmempool" description:"Max size in megabytes of the transactions to keep in memory, the ones paying the lowest gas price are evicted beyond it -- 0 means no limit"`
+	MempoolExpiry        time.Duration `long:"mempoolexpiry" description:"How long a transaction stays in memory before it expires.  Valid time units are {s, m, h} -- 0 means no expiry"`
+	MempoolRules         string        `long:"mempoolrules" description:"Path of a JSON file of admission rules checked on every transaction entering memory"`
 	Consensustype        string        `long:"consensustype" description:"Consensus type which the server uses"`
 	Privatekey           string        `long:"privatekey" description:"Add the private key which is used to assign block header for generated blocks"`
+	Validator            string        `long:"validator" description:"Address of the validator the private key signs blocks for, when it is a signing key the validator registered in the signing key registry; by default it is read from the registry"`
+	RemoteSigner         string        `long:"remotesigner" description:"Path of the socket of the signer daemon which signs the blocks in place of the private key"`
 	UserAgentComments    []string      `long:"uacomment" description:"Comment to add to the user agent -- See BIP 14 for more information."`
 	NoPeerBloomFilters   bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
 	NoCFilters           bool          `long:"nocfilters" description:"Disable committed filtering (CF) support"`
+	NoLogIndex           bool          `long:"nologindex" description:"Disable the contract log index which backs the getLogs and filter RPCs"`
 	DropCfIndex          bool          `long:"dropcfindex" description:"Deletes the index used for committed filtering (CF) support from the database on start up and then exits."`
 	BlocksOnly           bool          `long:"blocksonly" description:"Do not accept transactions from remote peers."`
 	EmptyRound           bool          `long:"emptyround" description:"Allow round contains no blocks."`
 	DropTxIndex          bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	DropAddrIndex        bool          `long:"dropaddrindex" description:"Deletes the address-based transaction index from the database on start up and then exits."`
+	BalanceIndex         bool          `long:"balanceindex" description:"Maintain a per asset balance history index which enables the getbalanceatheight, getassetholders and getassetsupplyhistory RPCs"`
+	DropBalanceIndex     bool          `long:"dropbalanceindex" description:"Deletes the per asset balance history index from the database on start up and then exits."`
+	AssetIndex           bool          `long:"assetindex" description:"Maintain an asset registry index which enables the listassets, getassetsbyorganization and getassetminthistory RPCs"`
+	DropAssetIndex       bool          `long:"dropassetindex" description:"Deletes the asset registry index from the database on start up and then exits."`
+	StateRetain          int32         `long:"stateretain" description:"Prune the contract state, keeping the states of the last N blocks and of the checkpoints (0 keeps every state, minimum 2880)"`
+	PruneState           bool          `long:"prunestate" description:"Deletes from the state database the contract states which are not kept by --stateretain on start up and then exits."`
+	ExportSnapshot       string        `long:"exportsnapshot" description:"Writes a snapshot of the best block, its UTXO set and its contract state to the given file on start up and then exits."`
+	ImportSnapshot       string        `long:"importsnapshot" description:"Bootstraps an empty data directory from the given snapshot file on start up and then exits.  The snapshot block must be a checkpoint with a utxo set hash and a snapshot hash."`
 	MaxTimeOffset        int           `long:"maxtimeoffset" description:"The maximum number of seconds a block time is allowed to be ahead of the current time, it is allowd to take [5-30]."`
 	MergeLimit           int           `long:"mergeLimit" description:"It is a miner strategy that miner can merge its utxo and push into block."`
 	AddCheckpoints       []Checkpoint
@@ -191,6 +207,7 @@ type FConfig struct {
 
 	AddBtc    []string `long:"addbtc" description:"Add a param to call btc server.  Format: '<ip>:<port>:<rpcuser>:<rpcpassword>'"`
 	BtcParams []*BitcoinParams
+	BtcSpv    bool `long:"btcspv" description:"Verify the bitcoin headers and coinbases fetched from the btc servers instead of trusting them"`
 
 	GenesisPath    string `long:"genesispath" description:"Path of genesis files"`
 	GenesisBlockFile string
@@ -265,13 +282,14 @@ func ParseAndSetDebugLevels(debugLevel string) error {
 	return nil
 }
 
-// newCheckpointFromStr parses checkpoints in the '<height>:<hash>' format.
+// newCheckpointFromStr parses checkpoints in the '<height>:<hash>' format,
+// optionally followed by ':<utxo set hash>' and ':<snapshot hash>'.
 func newCheckpointFromStr(checkpoint string) (Checkpoint, error) {
 	parts := strings.Split(checkpoint, ":")
-	if len(parts) != 2 {
+	if len(parts) < 2 || len(parts) > 4 {
 		return Checkpoint{}, fmt.Errorf("unable to parse "+
-			"checkpoint %q -- use the syntax <height>:<hash>",
-			checkpoint)
+			"checkpoint %q -- use the syntax <height>:<hash>"+
+			"[:<utxo set hash>[:<snapshot hash>]]", checkpoint)
 	}
 
 	height, err := strconv.ParseInt(parts[0], 10, 32)
@@ -294,14 +312,39 @@ func newCheckpointFromStr(checkpoint string) (Checkpoint, error) {
 
 	hash := common.BytesToHash(bytes)
 
+	var utxoSetHash *common.Hash
+	if len(parts) >= 3 {
+		bytes := common.FromHex(parts[2])
+		if len(bytes) != common.HashLength {
+			return Checkpoint{}, fmt.Errorf("invalid utxo set hash length "+
+				"of %v, want %v", len(parts[2]), common.HashLength)
+		}
+		setHash := common.BytesToHash(bytes)
+		utxoSetHash = &setHash
+	}
+
+	var snapshotHash *common.Hash
+	if len(parts) == 4 {
+		bytes := common.FromHex(parts[3])
+		if len(bytes) != common.HashLength {
+			return Checkpoint{}, fmt.Errorf("invalid snapshot hash length "+
+				"of %v, want %v", len(parts[3]), common.HashLength)
+		}
+		h := common.BytesToHash(bytes)
+		snapshotHash = &h
+	}
+
 	return Checkpoint{
-		Height: int32(height),
-		Hash:   &hash,
+		Height:       int32(height),
+		Hash:         &hash,
+		UtxoSetHash:  utxoSetHash,
+		SnapshotHash: snapshotHash,
 	}, nil
 }
 
 // parseCheckpoints checks the checkpoint strings for valid syntax
-// ('<height>:<hash>') and parses them to chaincfg.Checkpoint instances.
+// ('<height>:<hash>[:<utxo set hash>[:<snapshot hash>]]') and parses them to
+// chaincfg.Checkpoint instances.
 func parseCheckpoints(checkpointStrings []string) ([]Checkpoint, error) {
 	if len(checkpointStrings) == 0 {
 		return nil, nil
@@ -372,6 +415,8 @@ func LoadConfig() (*FConfig, []string, error) {
 		BlockSyncTime:        DefaultSyncBlockTime,
 		MaxOrphanTxs:         DefaultMaxOrphanTransactions,
 		MaxOrphanTxSize:      DefaultMaxOrphanTxSize,
+		MaxMempool:           DefaultMaxMempool,
+		MempoolExpiry:        DefaultMempoolExpiry,
 		EmptyRound:           false,
 		MaxTimeOffset:        DefaultMaxTimeOffsetSeconds,
 		MergeLimit:           DefaultMergeLimit,
@@ -527,6 +572,10 @@ func LoadConfig() (*FConfig, []string, error) {
 	cfg.StateDir = cleanAndExpandPath(cfg.StateDir)
 	cfg.StateDir = filepath.Join(cfg.StateDir, ActiveNetParams.Name())
 
+	if cfg.MempoolRules != "" {
+		cfg.MempoolRules = cleanAndExpandPath(cfg.MempoolRules)
+	}
+
 	// Special show command to list supported subsystems and exit.
 	if cfg.DebugLevel == "show" {
 		fmt.Println("Supported subsystems", logger.SupportedSubsystems())
@@ -702,6 +751,47 @@ func LoadConfig() (*FConfig, []string, error) {
 		return nil, nil, err
 	}
 
+	if cfg.MaxMempool < 0 {
+		str := "%s: The maxmempool option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxMempool)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	if cfg.MempoolExpiry < 0 {
+		str := "%s: The mempoolexpiry option may not be less than 0 " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.MempoolExpiry)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	if cfg.StateRetain < 0 {
+		str := "%s: The stateretain option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.StateRetain)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.PruneState && cfg.StateRetain == 0 {
+		err := fmt.Errorf("%s: the --prunestate option requires "+
+			"--stateretain", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.ExportSnapshot != "" && cfg.ImportSnapshot != "" {
+		err := fmt.Errorf("%s: the --exportsnapshot and --importsnapshot "+
+			"options can not be used together", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	if numNets == 0 && cfg.MaxTimeOffset > DefaultMaxTimeOffsetSeconds {
 		str := "%s: The maxtimeoffset option may not be greater than %d " +
 			"-- parsed [%d]"
@@ -852,5 +942,16 @@ func LoadGenesis(path string) error {
 		return errors.New("ChainStartTime must be greater than 0")
 	}
 	ActiveNetParams.ChainStartTime = params.ChainStartTime
+
+	if params.ConsensusParams != nil {
+		ActiveNetParams.ConsensusParams = params.ConsensusParams
+	}
+
+	if params.ConsensusSchedule != nil {
+		if err := params.CheckConsensusSchedule(); err != nil {
+			return err
+		}
+		ActiveNetParams.ConsensusSchedule = params.ConsensusSchedule
+	}
 	return nil
 }
@@ -5,6 +5,7 @@
 package chaincfg
 
 import (
+	"encoding/json"
 	"errors"
 	"math"
 
@@ -23,6 +24,16 @@ import (
 type Checkpoint struct {
 	Height int32
 	Hash   *common.Hash
+
+	// UtxoSetHash is the hash of the utxo set at the checkpoint, as
+	// reported by gettxoutsetinfo.  It is optional, a snapshot can only be
+	// imported at a checkpoint which has one.
+	UtxoSetHash *common.Hash
+
+	// SnapshotHash is the hash of the chain metadata a snapshot holds at
+	// the checkpoint, as reported by gettxoutsetinfo.  It is optional, a
+	// snapshot can only be imported at a checkpoint which has one.
+	SnapshotHash *common.Hash
 }
 
 // DNSSeed identifies a DNS seed.
@@ -153,6 +164,15 @@ type Params struct {
 	FvmParam *params.ChainConfig
 
 	Bitcoin []*BitcoinParams
+
+	// ConsensusSchedule lists the consensus rules activated at scheduled
+	// rounds, ordered by start round.  See ConsensusEpoch.
+	ConsensusSchedule []ConsensusEpoch
+
+	// ConsensusParams holds the parameters specific to a consensus engine,
+	// keyed by the name of the engine.  Each engine decodes its own
+	// parameters.
+	ConsensusParams map[string]json.RawMessage
 }
 
 // Name defines a human-readable identifier for the network.
@@ -160,6 +180,12 @@ func (p *Params) Name() string {
 	return p.Net.String()
 }
 
+// EngineParams returns the parameters of the named consensus engine, nil when
+// the network does not define any.
+func (p *Params) EngineParams(name string) json.RawMessage {
+	return p.ConsensusParams[name]
+}
+
 // MainNetParams defines the network parameters for the main Bitcoin network.
 var MainNetParams = Params{
 	Net:         common.MainNet,
@@ -252,7 +278,7 @@ var DevelopNetParams = Params{
 		},
 	},
 
-	FvmParam: params.TestnetChainConfig,
+	FvmParam: params.DevelopnetChainConfig,
 
 	Bitcoin: []*BitcoinParams{
 		{
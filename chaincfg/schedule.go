@@ -0,0 +1,174 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chaincfg
+
+import (
+	"fmt"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// ConsensusEpoch defines the consensus rules in force from a round on.  It is
+// the scheduled counterpart of a ConsensusDeployment: instead of being voted
+// in by the validators, it activates at a fixed round known in advance by
+// every node of the network.
+//
+// The zero value of a field keeps the value of the previous epoch, so an
+// epoch may change the round size only, the consensus engine only, and so on.
+type ConsensusEpoch struct {
+	// StartRound is the first round the epoch applies to.
+	StartRound uint32
+
+	// Consensus is the name of the consensus engine producing and
+	// validating the blocks of the epoch.  It is empty when the engine
+	// configured with the consensustype option is used.
+	Consensus string
+
+	// RoundSize is the number of slots of each round of the epoch.
+	RoundSize uint16
+
+	// BlockInterval is the expected number of seconds between two slots.
+	BlockInterval int64
+
+	// Vrf makes the producers of the blocks publish a vrf proof in their
+	// blocks from the epoch on, and the round managers which support it
+	// select the validators with the seed derived from the proofs.  Once
+	// activated, the vrf stays active in the later epochs.
+	Vrf bool
+}
+
+// Duration returns the expected number of seconds of each round of the
+// epoch.
+func (e *ConsensusEpoch) Duration() int64 {
+	return int64(e.RoundSize) * e.BlockInterval
+}
+
+// ConsensusEpochAt returns the consensus rules in force at the given round.
+// The rules before the first scheduled epoch are the ones of the network
+// parameters: the round size of the network, the default block interval
+// and the configured consensus engine.
+func (p *Params) ConsensusEpochAt(round uint32) ConsensusEpoch {
+	epoch := ConsensusEpoch{
+		RoundSize:     p.RoundSize,
+		BlockInterval: common.DefaultBlockInterval,
+	}
+	for _, e := range p.ConsensusSchedule {
+		if e.StartRound > round {
+			break
+		}
+		epoch.StartRound = e.StartRound
+		if e.Consensus != "" {
+			epoch.Consensus = e.Consensus
+		}
+		if e.RoundSize != 0 {
+			epoch.RoundSize = e.RoundSize
+		}
+		if e.BlockInterval != 0 {
+			epoch.BlockInterval = e.BlockInterval
+		}
+		if e.Vrf {
+			epoch.Vrf = true
+		}
+	}
+	return epoch
+}
+
+// VrfActiveAt returns whether the blocks of the given round carry vrf proofs.
+func (p *Params) VrfActiveAt(round uint32) bool {
+	epoch := p.ConsensusEpochAt(round)
+	return epoch.Vrf
+}
+
+// RoundSizeAt returns the number of slots of the given round.
+func (p *Params) RoundSizeAt(round uint32) uint16 {
+	epoch := p.ConsensusEpochAt(round)
+	return epoch.RoundSize
+}
+
+// RoundDurationAt returns the expected number of seconds of the given round.
+func (p *Params) RoundDurationAt(round uint32) int64 {
+	epoch := p.ConsensusEpochAt(round)
+	return epoch.Duration()
+}
+
+// nextEpochRound returns the start round of the first epoch scheduled after
+// the given round, and false when there is none.
+func (p *Params) nextEpochRound(round uint32) (uint32, bool) {
+	for _, e := range p.ConsensusSchedule {
+		if e.StartRound > round {
+			return e.StartRound, true
+		}
+	}
+	return 0, false
+}
+
+// SlotsBetween returns the number of slots of the rounds from the round from
+// included to the round to excluded.
+func (p *Params) SlotsBetween(from, to uint32) int64 {
+	var slots int64
+	for from < to {
+		epoch := p.ConsensusEpochAt(from)
+		end := to
+		if next, ok := p.nextEpochRound(from); ok && next < end {
+			end = next
+		}
+		slots += int64(end-from) * int64(epoch.RoundSize)
+		from = end
+	}
+	return slots
+}
+
+// RoundAt returns the round, the slot and the start time of the round at the
+// given time, for engines whose rounds last the duration scheduled for them.
+// The genesis block takes the last slot of round 0 at ChainStartTime and
+// round 1 starts one block interval later.
+func (p *Params) RoundAt(unix int64) (uint32, uint16, int64) {
+	epoch := p.ConsensusEpochAt(0)
+	start := p.ChainStartTime + epoch.BlockInterval
+	if unix < start {
+		return 0, epoch.RoundSize - 1,
+			p.ChainStartTime - int64(epoch.RoundSize-1)*epoch.BlockInterval
+	}
+
+	round := uint32(1)
+	for {
+		epoch = p.ConsensusEpochAt(round)
+		rounds := (unix - start) / epoch.Duration()
+		next, ok := p.nextEpochRound(round)
+		if ok && rounds >= int64(next-round) {
+			start += int64(next-round) * epoch.Duration()
+			round = next
+			continue
+		}
+		round += uint32(rounds)
+		start += rounds * epoch.Duration()
+		return round, uint16((unix - start) / epoch.BlockInterval), start
+	}
+}
+
+// CheckConsensusSchedule ensures the scheduled epochs are ordered by start
+// round, name registered consensus engines and keep the round size and the
+// block interval in range.
+func (p *Params) CheckConsensusSchedule() error {
+	var prevRound uint32
+	for i, e := range p.ConsensusSchedule {
+		if e.StartRound <= prevRound {
+			return fmt.Errorf("consensus epoch %d starts at round %d, "+
+				"after round %d is expected", i, e.StartRound, prevRound)
+		}
+		prevRound = e.StartRound
+		if e.Consensus != "" && common.GetConsensus(e.Consensus) < 0 {
+			return fmt.Errorf("consensus epoch %d uses the unknown "+
+				"consensus %s", i, e.Consensus)
+		}
+		if e.BlockInterval != 0 && (e.BlockInterval < common.MinBlockInterval ||
+			e.BlockInterval > common.MaxBlockInterval) {
+			return fmt.Errorf("consensus epoch %d block interval %d is "+
+				"out of range [%d, %d]", i, e.BlockInterval,
+				common.MinBlockInterval, common.MaxBlockInterval)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package chaincfg
+
+import (
+	"testing"
+)
+
+// scheduleParams returns network parameters with rounds of 10 slots of 5
+// seconds, shrunk to 4 slots from round 3, slowed down to 10 seconds a slot
+// from round 5 with vrf proofs and handed over to the poa engine from round 7.
+func scheduleParams() *Params {
+	return &Params{
+		RoundSize:      10,
+		ChainStartTime: 1000,
+		ConsensusSchedule: []ConsensusEpoch{
+			{StartRound: 3, RoundSize: 4},
+			{StartRound: 5, BlockInterval: 10, Vrf: true},
+			{StartRound: 7, Consensus: "poa"},
+		},
+	}
+}
+
+// TestConsensusEpochAt ensures the epochs inherit the fields they leave unset.
+func TestConsensusEpochAt(t *testing.T) {
+	p := scheduleParams()
+	tests := []struct {
+		round uint32
+		want  ConsensusEpoch
+	}{
+		{0, ConsensusEpoch{0, "", 10, 5, false}},
+		{2, ConsensusEpoch{0, "", 10, 5, false}},
+		{3, ConsensusEpoch{3, "", 4, 5, false}},
+		{6, ConsensusEpoch{5, "", 4, 10, true}},
+		{100, ConsensusEpoch{7, "poa", 4, 10, true}},
+	}
+	for _, test := range tests {
+		if got := p.ConsensusEpochAt(test.round); got != test.want {
+			t.Errorf("ConsensusEpochAt(%d): got %+v want %+v", test.round, got, test.want)
+		}
+	}
+}
+
+// TestSlotsBetween ensures the slots of rounds spanning epochs are counted with
+// the round size of each.
+func TestSlotsBetween(t *testing.T) {
+	p := scheduleParams()
+	tests := []struct {
+		from, to uint32
+		want     int64
+	}{
+		{4, 4, 0},
+		{1, 2, 10},
+		{2, 4, 14},
+		{1, 7, 36},
+		{6, 9, 12},
+	}
+	for _, test := range tests {
+		if got := p.SlotsBetween(test.from, test.to); got != test.want {
+			t.Errorf("SlotsBetween(%d, %d): got %d want %d", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+// TestRoundAt ensures the round and slot at a time follow the durations of the
+// rounds of each epoch.
+func TestRoundAt(t *testing.T) {
+	p := scheduleParams()
+	tests := []struct {
+		unix  int64
+		round uint32
+		slot  uint16
+		start int64
+	}{
+		{1000, 0, 9, 955},
+		{1004, 0, 9, 955},
+		{1005, 1, 0, 1005},
+		{1060, 2, 1, 1055},
+		{1126, 4, 0, 1125},
+		{1164, 5, 1, 1145},
+		{1230, 7, 0, 1225},
+		{1306, 9, 0, 1305},
+	}
+	for _, test := range tests {
+		round, slot, start := p.RoundAt(test.unix)
+		if round != test.round || slot != test.slot || start != test.start {
+			t.Errorf("RoundAt(%d): got (%d, %d, %d) want (%d, %d, %d)", test.unix,
+				round, slot, start, test.round, test.slot, test.start)
+		}
+	}
+}
+
+// TestCheckConsensusSchedule ensures malformed schedules are rejected.
+func TestCheckConsensusSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule []ConsensusEpoch
+		valid    bool
+	}{
+		{"empty", nil, true},
+		{"valid", scheduleParams().ConsensusSchedule, true},
+		{"round zero", []ConsensusEpoch{{StartRound: 0, RoundSize: 4}}, false},
+		{"unordered", []ConsensusEpoch{{StartRound: 5}, {StartRound: 5}}, false},
+		{"unknown consensus", []ConsensusEpoch{{StartRound: 1, Consensus: "pow"}}, false},
+		{"block interval", []ConsensusEpoch{{StartRound: 1, BlockInterval: 20}}, false},
+	}
+	for _, test := range tests {
+		p := Params{ConsensusSchedule: test.schedule}
+		if err := p.CheckConsensusSchedule(); (err == nil) != test.valid {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/jessevdk/go-flags"
+)
+
+const (
+	defaultIPCFilename        = "signer.ipc"
+	defaultProtectionFilename = "protection.json"
+)
+
+var defaultDataDir = asiutil.AppDataDir("asimovsigner", false)
+
+// config defines the configuration options for asimovsigner.
+//
+// See loadConfig for details on the configuration load process.
+type config struct {
+	KeyFile string `short:"k" long:"keyfile" description:"File holding the hex encoded private key signing the blocks"`
+	DataDir string `short:"b" long:"datadir" description:"Directory of the slashing protection state"`
+	IPCPath string `long:"ipcpath" description:"Path of the socket the node connects to with its remotesigner option"`
+}
+
+// loadConfig initializes and parses the config using command line options.
+func loadConfig() (*config, []string, error) {
+	// Default config.
+	cfg := config{
+		DataDir: defaultDataDir,
+	}
+
+	// Parse command line options.
+	parser := flags.NewParser(&cfg, flags.Default)
+	remainingArgs, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			parser.WriteHelp(os.Stderr)
+		}
+		return nil, nil, err
+	}
+
+	if cfg.KeyFile == "" {
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, errors.New("the keyfile option is required")
+	}
+	if cfg.IPCPath == "" {
+		cfg.IPCPath = filepath.Join(cfg.DataDir, defaultIPCFilename)
+	}
+
+	return &cfg, remainingArgs, nil
+}
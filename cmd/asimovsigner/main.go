@@ -0,0 +1,100 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// asimovsigner is the reference signer daemon of the validators.  It holds
+// the private key signing the blocks and serves the signer api on a local
+// socket to the node started with the remotesigner option, so the key does
+// not live on the internet facing host.  It keeps the highest blocks it
+// signed in its data directory and refuses to sign blocks which could
+// conflict with them.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/consensus/signer"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/logger"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+)
+
+// maxConcurrent is the number of requests handled at once.  The signatures
+// are made one at a time anyway, in the order of the protection state.
+const maxConcurrent = 1
+
+var log = logger.GetLog()
+
+// loadPrivateKey reads the hex encoded private key of the file.
+func loadPrivateKey(path string) (*crypto.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := hexutil.Decode(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in %s: %v", path, err)
+	}
+	privateKey, _ := crypto.PrivKeyFromBytes(crypto.S256(), keyBytes)
+	return privateKey, nil
+}
+
+func run(cfg *config) error {
+	privateKey, err := loadPrivateKey(cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		return err
+	}
+	protection, err := signer.NewProtection(filepath.Join(cfg.DataDir, defaultProtectionFilename))
+	if err != nil {
+		return err
+	}
+	if last := protection.LastProposal(); last != nil {
+		log.Infof("Last proposal at height %d round %d slot %d",
+			last.Height, last.Round, last.Slot)
+	}
+	if last := protection.LastBlock(); last != nil {
+		log.Infof("Last block signed at height %d", last.Height)
+	}
+
+	service := signer.NewService(signer.NewLocalSigner(privateKey, protection))
+	listener, server, err := rpc.StartIPCEndpoint(cfg.IPCPath, service.APIs(), maxConcurrent)
+	if err != nil {
+		return err
+	}
+	defer server.Stop()
+	defer listener.Close()
+
+	address, err := common.NewAddressWithId(common.PubKeyHashAddrID,
+		common.Hash160(privateKey.PubKey().SerializeCompressed()))
+	if err != nil {
+		return err
+	}
+	log.Infof("Signing with key %v on %s", address, cfg.IPCPath)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	sig := <-interrupt
+	log.Infof("Received signal (%s).  Shutting down...", sig)
+	return nil
+}
+
+func main() {
+	cfg, _, err := loadConfig()
+	if err != nil {
+		os.Exit(1)
+	}
+	if err := run(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
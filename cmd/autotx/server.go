@@ -222,7 +222,7 @@ func (s *Server) mergeUtxo(signUpPreOutList []protos.OutPoint, signUpBalance int
         go s.autoMergeUtxo(mergeList, utxoCnt, s.account)
     }
 
-    epochSize := chaincfg.ActiveNetParams.RoundSize
+    epochSize := chaincfg.ActiveNetParams.RoundSizeAt(s.currentRound())
     nextWatch := time.Duration(epochSize*5) * time.Second
     s.timer.Reset(nextWatch)
     mainLog.Infof("WatchInterval = %v", nextWatch)
@@ -230,6 +230,18 @@ func (s *Server) mergeUtxo(signUpPreOutList []protos.OutPoint, signUpBalance int
     return nil
 }
 
+// currentRound returns the round of the best block of the node, the first
+// round when the node can not tell.
+func (s *Server) currentRound() uint32 {
+    var result rpcjson.GetBlockChainInfoResult
+    err := s.wsConn.Call(&result, "asimov_getBlockChainInfo")
+    if err != nil || result.Round < 0 {
+        mainLog.Errorf("currentRound: failed to get chain info: %v", err)
+        return 0
+    }
+    return uint32(result.Round)
+}
+
 func (s *Server) broadcastTx(txStr string) error {
     var result string
     err := s.wsConn.Call(&result, "asimov_sendRawTransaction", txStr)
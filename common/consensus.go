@@ -22,6 +22,19 @@ var consensusArray = [ConsensusCount]string{
 	SATOSHIPLUS:"satoshiplus",
 }
 
+// RegisterConsensus assigns a consensus type to the given name and returns
+// it.  The type already assigned is returned when the name is known.  It is
+// not safe for concurrent access and is expected to be called from init
+// functions only, when consensus engines are registered.
+func RegisterConsensus(name string) int32 {
+	if c, ok := consensusByName[name]; ok {
+		return c
+	}
+	c := int32(len(consensusByName))
+	consensusByName[name] = c
+	return c
+}
+
 // GetConsensus returns consensus type according to the given name
 func GetConsensus(name string) int32 {
 	if c, ok := consensusByName[name]; ok {
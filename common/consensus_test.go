@@ -0,0 +1,29 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package common
+
+import (
+	"testing"
+)
+
+// TestRegisterConsensus ensures registered consensus names get a type of their
+// own and the built in ones keep theirs.
+func TestRegisterConsensus(t *testing.T) {
+	if c := RegisterConsensus("satoshiplus"); c != SATOSHIPLUS {
+		t.Errorf("RegisterConsensus: built in type changed to %d", c)
+	}
+	if c := GetConsensus("roundrobin"); c != -1 {
+		t.Errorf("GetConsensus: unregistered name has type %d", c)
+	}
+	c := RegisterConsensus("roundrobin")
+	if c < ConsensusCount {
+		t.Errorf("RegisterConsensus: got type %d of a built in consensus", c)
+	}
+	if got := GetConsensus("roundrobin"); got != c {
+		t.Errorf("GetConsensus: got %d want %d", got, c)
+	}
+	if again := RegisterConsensus("roundrobin"); again != c {
+		t.Errorf("RegisterConsensus: registering twice got %d want %d", again, c)
+	}
+}
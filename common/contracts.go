@@ -9,3 +9,10 @@ var (
 	TemplateWarehouse = HexToAddress("0x630000000000000000000000000000000000000067")
 	ValidatorCommittee = HexToAddress("0x63000000000000000000000000000000000000006b")
 )
+
+// The native system contracts, implemented by the virtual machine rather than
+// deployed in the genesis block.
+var (
+	EvidenceRegistry   = HexToAddress("0x63000000000000000000000000000000000000006d")
+	SigningKeyRegistry = HexToAddress("0x63000000000000000000000000000000000000006e")
+)
@@ -74,13 +74,18 @@ const (
 	// SFNodeCF is a flag used to indicate a peer supports committed
 	// filters (CFs).
 	SFNodeCF
+
+	// SFNodeEvidence is a flag used to indicate a peer supports the
+	// evidence message.
+	SFNodeEvidence
 )
 
 // Map of service flags back to their constant names for pretty printing.
 var sfStrings = map[ServiceFlag]string{
-	SFNodeNetwork: "SFNodeNetwork",
-	SFNodeBloom:   "SFNodeBloom",
-	SFNodeCF:      "SFNodeCF",
+	SFNodeNetwork:  "SFNodeNetwork",
+	SFNodeBloom:    "SFNodeBloom",
+	SFNodeCF:       "SFNodeCF",
+	SFNodeEvidence: "SFNodeEvidence",
 }
 
 // orderedSFStrings is an ordered list of service flags from highest to
@@ -89,6 +94,7 @@ var orderedSFStrings = []ServiceFlag{
 	SFNodeNetwork,
 	SFNodeBloom,
 	SFNodeCF,
+	SFNodeEvidence,
 }
 
 // String returns the ServiceFlag in human-readable form.
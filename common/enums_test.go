@@ -18,7 +18,8 @@ func TestServiceFlagStringer(t *testing.T) {
 		{SFNodeNetwork, "SFNodeNetwork"},
 		{SFNodeBloom, "SFNodeBloom"},
 		{SFNodeCF, "SFNodeCF"},
-		{0xffffffff, "SFNodeNetwork|SFNodeBloom|SFNodeCF|0xfffffff8"},
+		{SFNodeEvidence, "SFNodeEvidence"},
+		{0xffffffff, "SFNodeNetwork|SFNodeBloom|SFNodeCF|SFNodeEvidence|0xfffffff0"},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -5,7 +5,11 @@
 package consensus
 
 import (
+	"encoding/json"
 	"errors"
+	"sort"
+	"sync"
+
 	"github.com/AsimovNetwork/asimov/ainterface"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/consensus/params"
@@ -15,30 +19,129 @@ import (
 	"github.com/AsimovNetwork/asimov/crypto"
 )
 
+// ServiceFactory creates the consensus service of an engine.  The engine
+// specific parameters of the network are passed in cfg.EngineParams.
+type ServiceFactory func(cfg *params.Config) (ainterface.Consensus, error)
+
+// RoundManagerFactory creates the round manager of an engine.  The account
+// is nil when the node is not configured with a private key and engineParams
+// is nil when the network does not define parameters for the engine.
+type RoundManagerFactory func(acc *crypto.Account, engineParams json.RawMessage) (ainterface.IRoundManager, error)
+
+// engine houses the factories of a registered consensus engine.
+type engine struct {
+	newService      ServiceFactory
+	newRoundManager RoundManagerFactory
+}
+
+var (
+	enginesLock sync.RWMutex
+	engines     = make(map[string]*engine)
+)
+
+// Register makes a consensus engine available by name, so it can be selected
+// with the consensus type of the configuration.  It is typically called from
+// the init function of the package implementing the engine.  Registering a
+// name twice is an error.
+func Register(name string, newService ServiceFactory, newRoundManager RoundManagerFactory) error {
+	if name == "" || newService == nil || newRoundManager == nil {
+		return errors.New("consensus engine requires a name and both factories")
+	}
+
+	enginesLock.Lock()
+	defer enginesLock.Unlock()
+	if _, ok := engines[name]; ok {
+		return errors.New("consensus engine " + name + " is already registered")
+	}
+	engines[name] = &engine{
+		newService:      newService,
+		newRoundManager: newRoundManager,
+	}
+	common.RegisterConsensus(name)
+	return nil
+}
+
+// Engines returns the sorted names of the registered consensus engines.
+func Engines() []string {
+	enginesLock.RLock()
+	defer enginesLock.RUnlock()
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupEngine returns the registered engine with the given name.
+func lookupEngine(name string) (*engine, error) {
+	enginesLock.RLock()
+	defer enginesLock.RUnlock()
+	e, ok := engines[name]
+	if !ok {
+		return nil, errors.New("unknown consensus " + name)
+	}
+	return e, nil
+}
+
 // Create a new consensus service via pass config match consensus name
 func NewConsensusService(consensusName string, cfg *params.Config) (ainterface.Consensus, error) {
-	consensusType := common.GetConsensus(consensusName)
-	switch consensusType {
-	case common.SOLO:
-		return solo.NewSoloService(cfg)
-	case common.SATOSHIPLUS:
-		return satoshiplus.NewSatoshiPlusService(cfg)
-	case common.POA:
-		return poa.NewService(cfg)
-	}
-	return nil, errors.New("unknown consensus " + consensusName)
+	e, err := lookupEngine(consensusName)
+	if err != nil {
+		return nil, err
+	}
+	return e.newService(cfg)
 }
 
 // Create a new round manager under consensus name
-func NewRoundManager(consensusName string, acc *crypto.Account) ainterface.IRoundManager {
-	consensusType := common.GetConsensus(consensusName)
-	switch consensusType {
-	case common.SOLO:
-		return solo.NewRoundManager([]*common.Address{acc.Address})
-	case common.SATOSHIPLUS:
-		return satoshiplus.NewRoundManager()
-	case common.POA:
-		return poa.NewRoundManager()
+func NewRoundManager(consensusName string, acc *crypto.Account, engineParams json.RawMessage) (ainterface.IRoundManager, error) {
+	e, err := lookupEngine(consensusName)
+	if err != nil {
+		return nil, err
+	}
+	return e.newRoundManager(acc, engineParams)
+}
+
+func init() {
+	builtins := []struct {
+		name            string
+		newService      ServiceFactory
+		newRoundManager RoundManagerFactory
+	}{
+		{
+			name: "solo",
+			newService: func(cfg *params.Config) (ainterface.Consensus, error) {
+				return solo.NewSoloService(cfg)
+			},
+			newRoundManager: func(acc *crypto.Account, _ json.RawMessage) (ainterface.IRoundManager, error) {
+				if acc == nil {
+					return nil, errors.New("solo consensus requires a private key")
+				}
+				return solo.NewRoundManager([]*common.Address{acc.Address}), nil
+			},
+		},
+		{
+			name: "poa",
+			newService: func(cfg *params.Config) (ainterface.Consensus, error) {
+				return poa.NewService(cfg)
+			},
+			newRoundManager: func(*crypto.Account, json.RawMessage) (ainterface.IRoundManager, error) {
+				return poa.NewRoundManager(), nil
+			},
+		},
+		{
+			name: "satoshiplus",
+			newService: func(cfg *params.Config) (ainterface.Consensus, error) {
+				return satoshiplus.NewSatoshiPlusService(cfg)
+			},
+			newRoundManager: func(*crypto.Account, json.RawMessage) (ainterface.IRoundManager, error) {
+				return satoshiplus.NewRoundManager(), nil
+			},
+		},
+	}
+	for _, builtin := range builtins {
+		if err := Register(builtin.name, builtin.newService, builtin.newRoundManager); err != nil {
+			panic(err)
+		}
 	}
-	return nil
 }
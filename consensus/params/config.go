@@ -4,10 +4,13 @@
 package params
 
 import (
+	"encoding/json"
+
 	"github.com/AsimovNetwork/asimov/ainterface"
 	"github.com/AsimovNetwork/asimov/asiutil"
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus/signer"
 	"github.com/AsimovNetwork/asimov/crypto"
 	"github.com/AsimovNetwork/asimov/mining"
 )
@@ -41,4 +44,34 @@ type Config struct {
 
 	// Account provide a private key to sign a new produced block.
 	Account *crypto.Account
+
+	// Signer signs the blocks of the account in place of its private key,
+	// nil when the blocks are signed with the private key.
+	Signer signer.Signer
+
+	// EngineParams holds the parameters of the consensus engine defined by
+	// the network, nil when there are none.
+	EngineParams json.RawMessage
+}
+
+// BlockSigner returns the signer of the blocks of the account.
+func (c *Config) BlockSigner() signer.Signer {
+	if c.Signer != nil {
+		return c.Signer
+	}
+	return signer.NewLocalSigner(&c.Account.PrivateKey, nil)
+}
+
+// Validator returns the validator the account produces and signs blocks for
+// in the round: the validator which registered the key of the account as its
+// signing key, the account itself otherwise.
+func (c *Config) Validator(round uint32) *common.Address {
+	if c.Chain == nil {
+		return c.Account.Address
+	}
+	validator, err := c.Chain.ValidatorOf(*c.Account.Address, round)
+	if err != nil {
+		return c.Account.Address
+	}
+	return &validator
 }
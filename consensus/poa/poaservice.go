@@ -8,6 +8,7 @@ package poa
 import (
 	"errors"
 	"fmt"
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
@@ -25,7 +26,7 @@ type Service struct {
 	wg      sync.WaitGroup
 	existCh chan interface{}
 	context params.Context
-	timer   *time.Timer
+	timer   clock.Timer
 
 	started bool
 	config  *params.Config
@@ -63,7 +64,7 @@ func (s *Service) Start() error {
 	log.Info("Region consensus start")
 
 	// current block maybe do not at the best block height:
-	s.timer = time.NewTimer(common.DefaultBlockInterval * 1000000 * time.Second)
+	s.timer = clock.NewTimer(common.DefaultBlockInterval * 1000000 * time.Second)
 	if err := s.initializeConsensus(); err != nil {
 		log.Errorf("Start poa service failed:", err)
 		s.timer.Stop()
@@ -79,7 +80,7 @@ func (s *Service) Start() error {
 	mainloop:
 		for {
 			select {
-			case <-s.timer.C:
+			case <-s.timer.C():
 				s.genBlock()
 			case <-existCh:
 				break mainloop
@@ -89,7 +90,7 @@ func (s *Service) Start() error {
 	cleanup:
 		for {
 			select {
-			case <-s.timer.C:
+			case <-s.timer.C():
 			default:
 				break cleanup
 			}
@@ -126,7 +127,7 @@ func (s *Service) getValidators(round uint32, verbose bool) ([]*common.Address,
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get validators %v", err.Error())
 	}
-	if uint16(len(validators)) != chaincfg.ActiveNetParams.RoundSize {
+	if uint16(len(validators)) != chaincfg.ActiveNetParams.RoundSizeAt(round) {
 		return nil, nil, fmt.Errorf("getValidators error: can not get validators %d for round = %d",
 			len(validators), round)
 	}
@@ -143,20 +144,12 @@ func (s *Service) getValidators(round uint32, verbose bool) ([]*common.Address,
  * Initialize Consensus
  */
 func (s *Service) initializeConsensus() error {
-	s.context.RoundInterval = common.DefaultBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize)
-	chainStartTime := int64(chaincfg.ActiveNetParams.ChainStartTime)
-
-	d := time.Now().Unix() - chainStartTime
-	roundSizei64 := int64(chaincfg.ActiveNetParams.RoundSize)
-	if d < common.DefaultBlockInterval {
-		s.context.Round = 0
-		s.context.Slot = roundSizei64 - 1
-	} else {
-		slotCount := d * roundSizei64 / s.context.RoundInterval
-		s.context.Round = 1 + slotCount/roundSizei64
-		s.context.Slot = slotCount % roundSizei64
-	}
-	s.context.RoundStartTime = chainStartTime + common.DefaultBlockInterval + s.context.RoundInterval*int64(s.context.Round-1)
+	round, slot, roundStartTime := chaincfg.ActiveNetParams.RoundAt(clock.Now().Unix())
+	s.context.Round = int64(round)
+	s.context.Slot = int64(slot)
+	s.context.RoundStartTime = roundStartTime
+	s.context.RoundInterval = chaincfg.ActiveNetParams.RoundDurationAt(round)
+	s.context.RoundSize = int64(chaincfg.ActiveNetParams.RoundSizeAt(round))
 
 	s.resetTimer(false)
 
@@ -175,9 +168,10 @@ func (s *Service) slotControl() (int64, int64, bool) {
 
 	slot := s.context.Slot + 1
 	round := s.context.Round
-	roundSizei64 := int64(chaincfg.ActiveNetParams.RoundSize)
-	if slot == roundSizei64 {
+	if slot == s.context.RoundSize {
 		s.context.RoundStartTime = s.context.RoundStartTime + s.context.RoundInterval
+		s.context.RoundInterval = chaincfg.ActiveNetParams.RoundDurationAt(uint32(round + 1))
+		s.context.RoundSize = int64(chaincfg.ActiveNetParams.RoundSizeAt(uint32(round + 1)))
 		slot = 0
 		round = round + 1
 	}
@@ -189,10 +183,10 @@ func (s *Service) slotControl() (int64, int64, bool) {
 		return 0, 0, false
 	}
 
-	isTurn := *validators[slot] == *s.config.Account.Address
+	isTurn := *validators[slot] == *s.config.Validator(uint32(round))
 	log.Infof("[slotControl] slot change slot=%d, round=%d, height=%d, isTurn=%v, interval=%v",
 		slot, round, best.Height+1, isTurn,
-		float64(s.context.RoundInterval)/float64(chaincfg.ActiveNetParams.RoundSize))
+		float64(s.context.RoundInterval)/float64(s.context.RoundSize))
 	s.context.Slot = slot
 	s.context.Round = round
 	return round, slot, isTurn
@@ -205,12 +199,12 @@ func (s *Service) genBlock() {
 	if !isTurn {
 		return
 	}
-	blockInterval := float64(s.GetRoundInterval()) / float64(chaincfg.ActiveNetParams.RoundSize) * 1000
+	blockInterval := float64(s.GetRoundInterval()) / float64(s.context.RoundSize) * 1000
 	log.Infof("try to gen block at round=%d, slot=%d", round, slot)
 
 	template, err := s.config.BlockTemplateGenerator.ProduceNewBlock(
-		s.config.Account, s.config.GasFloor, s.config.GasCeil,
-		time.Now().Unix(), uint32(round), uint16(slot), blockInterval)
+		s.config.Validator(uint32(round)), s.config.BlockSigner(), s.config.GasFloor, s.config.GasCeil,
+		clock.Now().Unix(), uint32(round), uint16(slot), blockInterval)
 	if err != nil {
 		log.Errorf("Consensus POA Failed to gen a block: %v", err)
 		return
@@ -242,7 +236,7 @@ func (s *Service) resetTimer(isErr bool) {
 		s.timer.Reset(time.Second)
 		return
 	}
-	d := time.Duration(int64(s.context.Slot+1)*s.context.RoundInterval) * time.Second / time.Duration(chaincfg.ActiveNetParams.RoundSize)
-	offset := time.Unix(s.context.RoundStartTime, 0).Add(d + time.Millisecond).Sub(time.Now())
+	d := time.Duration(int64(s.context.Slot+1)*s.context.RoundInterval) * time.Second / time.Duration(s.context.RoundSize)
+	offset := time.Unix(s.context.RoundStartTime, 0).Add(d + time.Millisecond).Sub(clock.Now())
 	s.timer.Reset(offset)
 }
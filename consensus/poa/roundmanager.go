@@ -28,13 +28,14 @@ func (m *RoundManager) GetValidators(blockHash common.Hash, round uint32, fn ain
 	if err != nil {
 		return nil, nil, err
 	}
-	validators := make([]*common.Address, chaincfg.ActiveNetParams.RoundSize)
+	roundSize := chaincfg.ActiveNetParams.RoundSizeAt(round)
+	validators := make([]*common.Address, roundSize)
 	weightmap := make(map[common.Address]uint16)
 	for _, v := range signupValidators {
 		weightmap[v] = 1
 	}
 	l := len(signupValidators)
-	for i := 0; i < int(chaincfg.ActiveNetParams.RoundSize); i++ {
+	for i := 0; i < int(roundSize); i++ {
 		validators[i] = &signupValidators[i%l]
 	}
 	return validators, weightmap, nil
@@ -48,14 +49,14 @@ func (m *RoundManager) GetNextRound(round *ainterface.Round) (*ainterface.Round,
 	newRound := &ainterface.Round{
 		Round:          round.Round + 1,
 		RoundStartUnix: round.RoundStartUnix + round.Duration,
-		Duration:       common.DefaultBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize),
+		Duration:       chaincfg.ActiveNetParams.RoundDurationAt(round.Round + 1),
 	}
 
 	return newRound, nil
 }
 
 func (m *RoundManager) GetRoundInterval(round int64) int64 {
-	return common.DefaultBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize)
+	return chaincfg.ActiveNetParams.RoundDurationAt(uint32(round))
 }
 
 func (m *RoundManager) Init(round uint32, db database.Transactor, c ainterface.IBtcClient) error {
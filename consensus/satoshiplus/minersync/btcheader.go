@@ -0,0 +1,694 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package minersync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/bitcoinaddress"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+)
+
+const (
+	// BtcBlockHeaderLen is the number of bytes of a serialized bitcoin block
+	// header.
+	BtcBlockHeaderLen = 80
+
+	// btcMedianTimeBlocks is the number of previous blocks whose median
+	// timestamp a block timestamp must exceed.
+	btcMedianTimeBlocks = 11
+
+	// btcMaxTimeOffset is the maximum number of seconds a block timestamp
+	// is allowed to be ahead of the current time.
+	btcMaxTimeOffset = 2 * 60 * 60
+
+	// maxBtcTxSize is the maximum size of a bitcoin transaction accepted in
+	// a block proof.
+	maxBtcTxSize = 4000000
+)
+
+var (
+	// bigOne is 1 represented as a big.Int.
+	bigOne = big.NewInt(1)
+
+	// oneLsh256 is 1 shifted left 256 bits.
+	oneLsh256 = new(big.Int).Lsh(bigOne, 256)
+)
+
+// BtcChainParams defines the proof of work rules of a bitcoin network, which
+// the spv client verifies the headers of the network with.
+type BtcChainParams struct {
+	// Name defines a human-readable identifier for the network.
+	Name string
+
+	// PowLimit defines the highest allowed proof of work value for a block
+	// and PowLimitBits is the same value in compact form.
+	PowLimit     *big.Int
+	PowLimitBits uint32
+
+	// TargetTimespan is the desired amount of time between two difficulty
+	// retargets and TargetTimePerBlock the desired amount of time to
+	// generate each block.
+	TargetTimespan     time.Duration
+	TargetTimePerBlock time.Duration
+
+	// RetargetAdjustmentFactor is the adjustment factor used to limit the
+	// minimum and maximum amount of adjustment of a difficulty retarget.
+	RetargetAdjustmentFactor int64
+
+	// ReduceMinDifficulty defines whether the network reduces the
+	// difficulty to the minimum after MinDiffReductionTime without blocks.
+	ReduceMinDifficulty  bool
+	MinDiffReductionTime time.Duration
+
+	// PoWNoRetargeting defines whether the network retargets the
+	// difficulty at all.
+	PoWNoRetargeting bool
+
+	// AddressParams defines the encoding of the addresses of the network.
+	AddressParams *bitcoinaddress.Params
+}
+
+// blocksPerRetarget returns the number of blocks between two difficulty
+// retargets.
+func (p *BtcChainParams) blocksPerRetarget() int32 {
+	return int32(p.TargetTimespan / p.TargetTimePerBlock)
+}
+
+// BtcMainNetParams defines the proof of work rules of the main bitcoin
+// network.
+var BtcMainNetParams = BtcChainParams{
+	Name:                     "main",
+	PowLimit:                 new(big.Int).Sub(new(big.Int).Lsh(bigOne, 224), bigOne),
+	PowLimitBits:             0x1d00ffff,
+	TargetTimespan:           time.Hour * 24 * 14,
+	TargetTimePerBlock:       time.Minute * 10,
+	RetargetAdjustmentFactor: 4,
+	AddressParams:            &bitcoinaddress.MainNetParams,
+}
+
+// BtcTestNet3Params defines the proof of work rules of the version 3 of the
+// bitcoin test network.
+var BtcTestNet3Params = BtcChainParams{
+	Name:                     "test",
+	PowLimit:                 new(big.Int).Sub(new(big.Int).Lsh(bigOne, 224), bigOne),
+	PowLimitBits:             0x1d00ffff,
+	TargetTimespan:           time.Hour * 24 * 14,
+	TargetTimePerBlock:       time.Minute * 10,
+	RetargetAdjustmentFactor: 4,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     time.Minute * 20,
+	AddressParams:            &bitcoinaddress.TestNet3Params,
+}
+
+// BtcRegTestParams defines the proof of work rules of the bitcoin regression
+// test network.
+var BtcRegTestParams = BtcChainParams{
+	Name:                     "regtest",
+	PowLimit:                 new(big.Int).Sub(new(big.Int).Lsh(bigOne, 255), bigOne),
+	PowLimitBits:             0x207fffff,
+	TargetTimespan:           time.Hour * 24 * 14,
+	TargetTimePerBlock:       time.Minute * 10,
+	RetargetAdjustmentFactor: 4,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     time.Minute * 20,
+	PoWNoRetargeting:         true,
+	AddressParams:            &bitcoinaddress.RegressionNetParams,
+}
+
+// BtcBlockHeader defines a bitcoin block header.
+type BtcBlockHeader struct {
+	Version    int32
+	PrevBlock  common.Hash
+	MerkleRoot common.Hash
+	Timestamp  uint32
+	Bits       uint32
+	Nonce      uint32
+}
+
+// Serialize encodes the header in the bitcoin wire format.
+func (h *BtcBlockHeader) Serialize() []byte {
+	buf := make([]byte, BtcBlockHeaderLen)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(h.Version))
+	copy(buf[4:36], h.PrevBlock[:])
+	copy(buf[36:68], h.MerkleRoot[:])
+	binary.LittleEndian.PutUint32(buf[68:72], h.Timestamp)
+	binary.LittleEndian.PutUint32(buf[72:76], h.Bits)
+	binary.LittleEndian.PutUint32(buf[76:80], h.Nonce)
+	return buf
+}
+
+// Deserialize decodes the header from the bitcoin wire format.
+func (h *BtcBlockHeader) Deserialize(buf []byte) error {
+	if len(buf) != BtcBlockHeaderLen {
+		return fmt.Errorf("bitcoin block header is %d bytes, %d expected",
+			len(buf), BtcBlockHeaderLen)
+	}
+	h.Version = int32(binary.LittleEndian.Uint32(buf[0:4]))
+	copy(h.PrevBlock[:], buf[4:36])
+	copy(h.MerkleRoot[:], buf[36:68])
+	h.Timestamp = binary.LittleEndian.Uint32(buf[68:72])
+	h.Bits = binary.LittleEndian.Uint32(buf[72:76])
+	h.Nonce = binary.LittleEndian.Uint32(buf[76:80])
+	return nil
+}
+
+// BlockHash returns the hash of the header, in the internal byte order of
+// bitcoin.
+func (h *BtcBlockHeader) BlockHash() common.Hash {
+	return common.DoubleHashH(h.Serialize())
+}
+
+// btcHashString returns the hash in the reversed byte order bitcoin displays
+// and accepts in its RPC interface.
+func btcHashString(hash *common.Hash) string {
+	var reversed [common.HashLength]byte
+	for i, b := range hash {
+		reversed[common.HashLength-1-i] = b
+	}
+	return hex.EncodeToString(reversed[:])
+}
+
+// btcHashFromString decodes a hash displayed by bitcoin.
+func btcHashFromString(s string) (common.Hash, error) {
+	var hash common.Hash
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return hash, err
+	}
+	if len(buf) != common.HashLength {
+		return hash, fmt.Errorf("bitcoin hash %s is not %d bytes", s, common.HashLength)
+	}
+	for i, b := range buf {
+		hash[common.HashLength-1-i] = b
+	}
+	return hash, nil
+}
+
+// hashToBig converts a hash in the internal byte order of bitcoin into a
+// big.Int that can be used to perform math comparisons.
+func hashToBig(hash *common.Hash) *big.Int {
+	buf := *hash
+	for i := 0; i < common.HashLength/2; i++ {
+		buf[i], buf[common.HashLength-1-i] = buf[common.HashLength-1-i], buf[i]
+	}
+	return new(big.Int).SetBytes(buf[:])
+}
+
+// compactToBig converts a compact representation of a whole number N to an
+// unsigned 32-bit number.  The representation is similar to IEEE754 floating
+// point numbers: the most significant 8 bits are the base 256 exponent, bit 23
+// is the sign and the remaining 23 bits the mantissa.
+func compactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+	if isNegative {
+		bn = bn.Neg(bn)
+	}
+	return bn
+}
+
+// bigToCompact converts a whole number N to a compact representation using
+// an unsigned 32-bit number.  It is the inverse of compactToBig.
+func bigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+
+	// When the mantissa already has the sign bit set, the number is too
+	// large to fit into the available 23-bits, so divide the number by 256
+	// and increment the exponent accordingly.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// calcWork calculates a work value from difficulty bits: the number of hashes
+// expected to find a block of the difficulty, 2^256 / (target+1).
+func calcWork(bits uint32) *big.Int {
+	difficultyNum := compactToBig(bits)
+	if difficultyNum.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	denominator := new(big.Int).Add(difficultyNum, bigOne)
+	return new(big.Int).Div(oneLsh256, denominator)
+}
+
+// checkProofOfWork ensures the header hash is below the target of its bits
+// and the target is in range.
+func checkProofOfWork(header *BtcBlockHeader, powLimit *big.Int) error {
+	target := compactToBig(header.Bits)
+	if target.Sign() <= 0 {
+		return fmt.Errorf("bitcoin block target difficulty of %064x is too low", target)
+	}
+	if target.Cmp(powLimit) > 0 {
+		return fmt.Errorf("bitcoin block target difficulty of %064x is higher "+
+			"than max of %064x", target, powLimit)
+	}
+	hash := header.BlockHash()
+	if hashToBig(&hash).Cmp(target) > 0 {
+		return fmt.Errorf("bitcoin block hash of %064x is higher than "+
+			"expected max of %064x", hashToBig(&hash), target)
+	}
+	return nil
+}
+
+// btcHeaderLookup returns the verified header at a height, or nil when the
+// height is out of the headers known.
+type btcHeaderLookup func(height int32) *BtcBlockHeader
+
+// calcNextRequiredDifficulty returns the difficulty bits required for the
+// block at the height, which follows prev, with the given timestamp.
+func calcNextRequiredDifficulty(params *BtcChainParams, prev *BtcBlockHeader,
+	height int32, timestamp uint32, lookup btcHeaderLookup) (uint32, error) {
+
+	if params.PoWNoRetargeting {
+		return prev.Bits, nil
+	}
+
+	interval := params.blocksPerRetarget()
+	if height%interval != 0 {
+		if !params.ReduceMinDifficulty {
+			return prev.Bits, nil
+		}
+
+		// A block more than the reduction time after the previous one may
+		// use the minimum difficulty; otherwise it uses the difficulty of
+		// the last block which did not.
+		reductionTime := uint32(params.MinDiffReductionTime / time.Second)
+		if timestamp > prev.Timestamp+reductionTime {
+			return params.PowLimitBits, nil
+		}
+		h, header := height-1, prev
+		for h%interval != 0 && header.Bits == params.PowLimitBits {
+			h--
+			if header = lookup(h); header == nil {
+				return 0, fmt.Errorf("bitcoin block header %d is unknown", h)
+			}
+		}
+		return header.Bits, nil
+	}
+
+	first := lookup(height - interval)
+	if first == nil {
+		return 0, fmt.Errorf("bitcoin block header %d is unknown", height-interval)
+	}
+
+	// Limit the amount of adjustment that can occur to the previous
+	// difficulty.
+	targetTimespan := int64(params.TargetTimespan / time.Second)
+	minTimespan := targetTimespan / params.RetargetAdjustmentFactor
+	maxTimespan := targetTimespan * params.RetargetAdjustmentFactor
+	actualTimespan := int64(prev.Timestamp) - int64(first.Timestamp)
+	if actualTimespan < minTimespan {
+		actualTimespan = minTimespan
+	} else if actualTimespan > maxTimespan {
+		actualTimespan = maxTimespan
+	}
+
+	newTarget := new(big.Int).Mul(compactToBig(prev.Bits), big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+	if newTarget.Cmp(params.PowLimit) > 0 {
+		newTarget.Set(params.PowLimit)
+	}
+	return bigToCompact(newTarget), nil
+}
+
+// checkBtcHeaderContext ensures the header at the height follows prev: it
+// links to it, carries the required difficulty and a valid proof of work, and
+// its timestamp is after the median of the previous blocks known and not too
+// far in the future.
+func checkBtcHeaderContext(params *BtcChainParams, header, prev *BtcBlockHeader,
+	height int32, lookup btcHeaderLookup, now int64) error {
+
+	if header.PrevBlock != prev.BlockHash() {
+		return fmt.Errorf("bitcoin block header %d does not connect to its "+
+			"previous block", height)
+	}
+	bits, err := calcNextRequiredDifficulty(params, prev, height, header.Timestamp, lookup)
+	if err != nil {
+		return err
+	}
+	if header.Bits != bits {
+		return fmt.Errorf("bitcoin block header %d has difficulty bits %08x, "+
+			"%08x expected", height, header.Bits, bits)
+	}
+	if err := checkProofOfWork(header, params.PowLimit); err != nil {
+		return err
+	}
+
+	timestamps := make([]uint32, 0, btcMedianTimeBlocks)
+	for h, ancestor := height-1, prev; ancestor != nil && len(timestamps) < btcMedianTimeBlocks; h-- {
+		timestamps = append(timestamps, ancestor.Timestamp)
+		ancestor = lookup(h - 1)
+	}
+	for i := 1; i < len(timestamps); i++ {
+		for j := i; j > 0 && timestamps[j] < timestamps[j-1]; j-- {
+			timestamps[j], timestamps[j-1] = timestamps[j-1], timestamps[j]
+		}
+	}
+	if median := timestamps[len(timestamps)/2]; header.Timestamp <= median {
+		return fmt.Errorf("bitcoin block header %d timestamp %d is not after "+
+			"the median time %d", height, header.Timestamp, median)
+	}
+	if int64(header.Timestamp) > now+btcMaxTimeOffset {
+		return fmt.Errorf("bitcoin block header %d timestamp %d is too far "+
+			"in the future", height, header.Timestamp)
+	}
+	return nil
+}
+
+// calcMerkleBranch returns the hashes needed to prove the leaf at the index
+// is part of the merkle tree of the leaves.  The last hash of a level with an
+// odd number of hashes is paired with itself, as bitcoin does.
+func calcMerkleBranch(leaves []common.Hash, index int) []common.Hash {
+	var branch []common.Hash
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		branch = append(branch, level[sibling])
+
+		next := make([]common.Hash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			right := i + 1
+			if right >= len(level) {
+				right = i
+			}
+			next = append(next, hashMerkleBranches(&level[i], &level[right]))
+		}
+		level = next
+		index >>= 1
+	}
+	return branch
+}
+
+// calcMerkleRoot returns the root of the merkle tree the branch proves the
+// leaf at the index is part of.
+func calcMerkleRoot(leaf common.Hash, index uint32, branch []common.Hash) common.Hash {
+	root := leaf
+	for i := range branch {
+		if index&1 == 1 {
+			root = hashMerkleBranches(&branch[i], &root)
+		} else {
+			root = hashMerkleBranches(&root, &branch[i])
+		}
+		index >>= 1
+	}
+	return root
+}
+
+// hashMerkleBranches returns the hash of the concatenation of the two nodes
+// of a merkle tree.
+func hashMerkleBranches(left, right *common.Hash) common.Hash {
+	var buf [common.HashLength * 2]byte
+	copy(buf[:common.HashLength], left[:])
+	copy(buf[common.HashLength:], right[:])
+	return common.DoubleHashH(buf[:])
+}
+
+// btcTxIn is an input of a bitcoin transaction.
+type btcTxIn struct {
+	prevHash  common.Hash
+	prevIndex uint32
+	scriptSig []byte
+	witness   [][]byte
+}
+
+// btcTxOut is an output of a bitcoin transaction.
+type btcTxOut struct {
+	value    int64
+	pkScript []byte
+}
+
+// btcTx is a bitcoin transaction, decoded from the wire format with or
+// without its witness.
+type btcTx struct {
+	txid  common.Hash
+	txIn  []*btcTxIn
+	txOut []*btcTxOut
+}
+
+// parseBtcTx decodes a serialized bitcoin transaction and computes its id,
+// which does not commit to the witness.
+func parseBtcTx(raw []byte) (*btcTx, error) {
+	if len(raw) > maxBtcTxSize {
+		return nil, errors.New("bitcoin transaction is too large")
+	}
+	r := bytes.NewReader(raw)
+	readBytes := func() ([]byte, error) {
+		return serialization.ReadVarBytes(r, 0, maxBtcTxSize, "bitcoin script")
+	}
+	offset := func() int {
+		return len(raw) - r.Len()
+	}
+
+	var version uint32
+	if err := serialization.ReadUint32(r, &version); err != nil {
+		return nil, err
+	}
+	count, err := serialization.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// A zero input count is the marker of the witness serialization.
+	bodyStart, hasWitness := offset(), false
+	if count == 0 {
+		var flag uint8
+		if err = serialization.ReadUint8(r, &flag); err != nil {
+			return nil, err
+		}
+		if flag != 1 {
+			return nil, fmt.Errorf("bitcoin transaction witness flag %d is unknown", flag)
+		}
+		hasWitness = true
+		bodyStart = offset()
+		if count, err = serialization.ReadVarInt(r, 0); err != nil {
+			return nil, err
+		}
+	}
+	if count > uint64(r.Len()) {
+		return nil, errors.New("bitcoin transaction input count is too large")
+	}
+
+	tx := &btcTx{}
+	for i := uint64(0); i < count; i++ {
+		txIn := &btcTxIn{}
+		if err = serialization.ReadNBytes(r, txIn.prevHash[:], common.HashLength); err != nil {
+			return nil, err
+		}
+		if err = serialization.ReadUint32(r, &txIn.prevIndex); err != nil {
+			return nil, err
+		}
+		if txIn.scriptSig, err = readBytes(); err != nil {
+			return nil, err
+		}
+		var sequence uint32
+		if err = serialization.ReadUint32(r, &sequence); err != nil {
+			return nil, err
+		}
+		tx.txIn = append(tx.txIn, txIn)
+	}
+
+	if count, err = serialization.ReadVarInt(r, 0); err != nil {
+		return nil, err
+	}
+	if count > uint64(r.Len()) {
+		return nil, errors.New("bitcoin transaction output count is too large")
+	}
+	for i := uint64(0); i < count; i++ {
+		txOut := &btcTxOut{}
+		var value uint64
+		if err = serialization.ReadUint64(r, &value); err != nil {
+			return nil, err
+		}
+		txOut.value = int64(value)
+		if txOut.pkScript, err = readBytes(); err != nil {
+			return nil, err
+		}
+		tx.txOut = append(tx.txOut, txOut)
+	}
+	bodyEnd := offset()
+
+	if hasWitness {
+		for _, txIn := range tx.txIn {
+			if count, err = serialization.ReadVarInt(r, 0); err != nil {
+				return nil, err
+			}
+			if count > uint64(r.Len()) {
+				return nil, errors.New("bitcoin transaction witness is too large")
+			}
+			for i := uint64(0); i < count; i++ {
+				item, err := readBytes()
+				if err != nil {
+					return nil, err
+				}
+				txIn.witness = append(txIn.witness, item)
+			}
+		}
+	}
+
+	var lockTime uint32
+	if err = serialization.ReadUint32(r, &lockTime); err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("bitcoin transaction has trailing bytes")
+	}
+
+	stripped := make([]byte, 0, 8+bodyEnd-bodyStart)
+	stripped = append(stripped, raw[:4]...)
+	stripped = append(stripped, raw[bodyStart:bodyEnd]...)
+	stripped = append(stripped, raw[len(raw)-4:]...)
+	if !hasWitness {
+		stripped = raw
+	}
+	tx.txid = common.DoubleHashH(stripped)
+	return tx, nil
+}
+
+// isCoinbase returns whether the transaction is a coinbase, whose single
+// input spends no previous output.
+func (tx *btcTx) isCoinbase() bool {
+	return len(tx.txIn) == 1 && tx.txIn[0].prevIndex == 0xffffffff &&
+		tx.txIn[0].prevHash == (common.Hash{})
+}
+
+// scriptPushes returns the data pushed by a script made of push operations
+// only, or nil when the script has other operations.
+func scriptPushes(script []byte) [][]byte {
+	var pushes [][]byte
+	for i := 0; i < len(script); {
+		op := int(script[i])
+		i++
+		var n int
+		switch {
+		case op >= 0x01 && op <= 0x4b:
+			n = op
+		case op == 0x4c && i+1 <= len(script):
+			n = int(script[i])
+			i++
+		case op == 0x4d && i+2 <= len(script):
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			return nil
+		}
+		if i+n > len(script) {
+			return nil
+		}
+		pushes = append(pushes, script[i:i+n])
+		i += n
+	}
+	return pushes
+}
+
+// isBtcPubKey returns whether the data has the size of a serialized public
+// key.
+func isBtcPubKey(data []byte) bool {
+	return (len(data) == 33 && (data[0] == 0x02 || data[0] == 0x03)) ||
+		(len(data) == 65 && data[0] == 0x04)
+}
+
+// encodeBtcPkScriptAddress returns the address paid by a pay to public key
+// hash or a pay to witness public key hash script, and an empty string for
+// the other scripts.
+func encodeBtcPkScriptAddress(pkScript []byte, params *bitcoinaddress.Params) string {
+	switch {
+	case len(pkScript) == 25 && pkScript[0] == 0x76 && pkScript[1] == 0xa9 &&
+		pkScript[2] == 0x14 && pkScript[23] == 0x88 && pkScript[24] == 0xac:
+		addr, err := bitcoinaddress.NewAddressPubKeyHash(pkScript[3:23], params)
+		if err == nil {
+			return addr.EncodeAddress()
+		}
+	case len(pkScript) == 22 && pkScript[0] == 0x00 && pkScript[1] == 0x14:
+		addr, err := bitcoinaddress.NewAddressWitnessPubKeyHash(pkScript[2:], params)
+		if err == nil {
+			return addr.EncodeAddress()
+		}
+	}
+	return ""
+}
+
+// encodeBtcTxInAddress returns the address whose key signed the input, for
+// inputs spending pay to public key hash or pay to witness public key hash
+// outputs, and an empty string for the other inputs.
+func encodeBtcTxInAddress(txIn *btcTxIn, params *bitcoinaddress.Params) string {
+	if len(txIn.scriptSig) == 0 {
+		if len(txIn.witness) != 2 || !isBtcPubKey(txIn.witness[1]) {
+			return ""
+		}
+		addr, err := bitcoinaddress.NewAddressWitnessPubKeyHash(
+			common.Hash160(txIn.witness[1]), params)
+		if err != nil {
+			return ""
+		}
+		return addr.EncodeAddress()
+	}
+	pushes := scriptPushes(txIn.scriptSig)
+	if len(pushes) != 2 || !isBtcPubKey(pushes[1]) {
+		return ""
+	}
+	addr, err := bitcoinaddress.NewAddressPubKeyHash(common.Hash160(pushes[1]), params)
+	if err != nil {
+		return ""
+	}
+	return addr.EncodeAddress()
+}
+
+// mappingOutAddress returns the asimov address a validator mapping
+// transaction maps its inputs to: the 20 bytes pushed by its first null data
+// output.  It returns false for the transactions without such output.
+func mappingOutAddress(tx *btcTx) ([]byte, bool) {
+	for _, txOut := range tx.txOut {
+		script := txOut.pkScript
+		if len(script) == 0 || script[0] != 0x6a {
+			continue
+		}
+		pushes := scriptPushes(script[1:])
+		if len(pushes) == 1 && len(pushes[0]) == common.AddressLength-1 {
+			return pushes[0], true
+		}
+		return nil, false
+	}
+	return nil, false
+}
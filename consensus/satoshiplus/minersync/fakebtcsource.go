@@ -0,0 +1,199 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package minersync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+)
+
+// FakeMapping is a validator mapping transaction generated by the
+// FakeBtcSource: it spends an output of the public key and maps the key to
+// the asimov address.
+type FakeMapping struct {
+	PubKey     []byte
+	OutAddress [20]byte
+}
+
+// fakeBlock is a block generated by the FakeBtcSource.
+type fakeBlock struct {
+	header *BtcBlockHeader
+	txs    [][]byte
+	txids  []common.Hash
+}
+
+// FakeBtcSource implements the BtcSource interface with a bitcoin chain it
+// mines itself, for offline testing of the spv client.  Its blocks pay the
+// miners passed to Mine and carry the requested mapping transactions.  It
+// only mines at the minimum difficulty of networks which do not retarget,
+// such as the regression test network.
+type FakeBtcSource struct {
+	sync.Mutex
+	params      *BtcChainParams
+	startHeight int32
+	blocks      []*fakeBlock
+}
+
+// NewFakeBtcSource returns a new FakeBtcSource whose chain starts with a
+// block at the height and the timestamp.
+func NewFakeBtcSource(params *BtcChainParams, height int32, timestamp uint32) *FakeBtcSource {
+	s := &FakeBtcSource{
+		params:      params,
+		startHeight: height,
+	}
+	header := &BtcBlockHeader{
+		Version:   1,
+		Timestamp: timestamp,
+		Bits:      params.PowLimitBits,
+	}
+	s.blocks = append(s.blocks, s.mine(header, make([]byte, 20), nil))
+	return s
+}
+
+// fakeCoinbase returns a coinbase paying the miner public key hash.  The
+// height makes the coinbases of distinct blocks distinct.
+func fakeCoinbase(height int32, miner []byte) []byte {
+	var heightBytes [4]byte
+	binary.LittleEndian.PutUint32(heightBytes[:], uint32(height))
+	var buf bytes.Buffer
+	serialization.WriteUint32(&buf, 1)
+	serialization.WriteVarInt(&buf, 0, 1)
+	serialization.WriteNBytes(&buf, make([]byte, common.HashLength))
+	serialization.WriteUint32(&buf, 0xffffffff)
+	serialization.WriteVarBytes(&buf, 0, append([]byte{0x04}, heightBytes[:]...))
+	serialization.WriteUint32(&buf, 0xffffffff)
+	serialization.WriteVarInt(&buf, 0, 1)
+	serialization.WriteUint64(&buf, 5000000000)
+	pkScript := append([]byte{0x76, 0xa9, 0x14}, miner...)
+	serialization.WriteVarBytes(&buf, 0, append(pkScript, 0x88, 0xac))
+	serialization.WriteUint32(&buf, 0)
+	return buf.Bytes()
+}
+
+// fakeMappingTx returns a transaction spending an output of the public key
+// with a null data output pushing the asimov address.
+func fakeMappingTx(height int32, index int, mapping *FakeMapping) []byte {
+	var prevHash common.Hash
+	binary.LittleEndian.PutUint32(prevHash[:], uint32(height))
+	binary.LittleEndian.PutUint32(prevHash[4:], uint32(index))
+	scriptSig := []byte{0x47}
+	scriptSig = append(scriptSig, make([]byte, 0x47)...)
+	scriptSig = append(scriptSig, byte(len(mapping.PubKey)))
+	scriptSig = append(scriptSig, mapping.PubKey...)
+
+	var buf bytes.Buffer
+	serialization.WriteUint32(&buf, 1)
+	serialization.WriteVarInt(&buf, 0, 1)
+	serialization.WriteNBytes(&buf, prevHash[:])
+	serialization.WriteUint32(&buf, 0)
+	serialization.WriteVarBytes(&buf, 0, scriptSig)
+	serialization.WriteUint32(&buf, 0xffffffff)
+	serialization.WriteVarInt(&buf, 0, 1)
+	serialization.WriteUint64(&buf, 0)
+	pkScript := append([]byte{0x6a, 0x14}, mapping.OutAddress[:]...)
+	serialization.WriteVarBytes(&buf, 0, pkScript)
+	serialization.WriteUint32(&buf, 0)
+	return buf.Bytes()
+}
+
+// mine completes the header with the merkle root of the transactions of a
+// block paying the miner and solves its proof of work.
+func (s *FakeBtcSource) mine(header *BtcBlockHeader, miner []byte, mappings []*FakeMapping) *fakeBlock {
+	height := s.startHeight + int32(len(s.blocks))
+	block := &fakeBlock{header: header}
+	block.txs = append(block.txs, fakeCoinbase(height, miner))
+	for i, mapping := range mappings {
+		block.txs = append(block.txs, fakeMappingTx(height, i, mapping))
+	}
+	for _, tx := range block.txs {
+		block.txids = append(block.txids, common.DoubleHashH(tx))
+	}
+	root := calcMerkleRoot(block.txids[0], 0, calcMerkleBranch(block.txids, 0))
+	header.MerkleRoot = root
+	for checkProofOfWork(header, s.params.PowLimit) != nil {
+		header.Nonce++
+	}
+	return block
+}
+
+// Mine appends a block paying the miner public key hash, with the mapping
+// transactions, to the chain and returns its header.
+func (s *FakeBtcSource) Mine(miner []byte, mappings ...*FakeMapping) *BtcBlockHeader {
+	s.Lock()
+	defer s.Unlock()
+	prev := s.blocks[len(s.blocks)-1].header
+	header := &BtcBlockHeader{
+		Version:   1,
+		PrevBlock: prev.BlockHash(),
+		Timestamp: prev.Timestamp + uint32(s.params.TargetTimePerBlock.Seconds()),
+		Bits:      s.params.PowLimitBits,
+	}
+	block := s.mine(header, miner, mappings)
+	s.blocks = append(s.blocks, block)
+	return block.header
+}
+
+// Rewind drops the blocks above the height, so the next blocks mined fork
+// the chain.
+func (s *FakeBtcSource) Rewind(height int32) {
+	s.Lock()
+	defer s.Unlock()
+	if n := int(height - s.startHeight + 1); n > 0 && n < len(s.blocks) {
+		s.blocks = s.blocks[:n]
+	}
+}
+
+// GetBestHeight returns the height of the last block mined.
+func (s *FakeBtcSource) GetBestHeight() (int32, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.startHeight + int32(len(s.blocks)) - 1, nil
+}
+
+// GetHeaders returns the headers of the chain from the height.
+func (s *FakeBtcSource) GetHeaders(height, count int32) ([]*BtcBlockHeader, error) {
+	s.Lock()
+	defer s.Unlock()
+	var headers []*BtcBlockHeader
+	for h := height; h < height+count; h++ {
+		i := int(h - s.startHeight)
+		if i < 0 || i >= len(s.blocks) {
+			break
+		}
+		header := *s.blocks[i].header
+		headers = append(headers, &header)
+	}
+	return headers, nil
+}
+
+// GetBlockProof returns the proofs of the coinbase and of every mapping
+// transaction of the block.
+func (s *FakeBtcSource) GetBlockProof(height int32, hash common.Hash) (*BtcBlockProof, error) {
+	s.Lock()
+	defer s.Unlock()
+	i := int(height - s.startHeight)
+	if i < 0 || i >= len(s.blocks) || s.blocks[i].header.BlockHash() != hash {
+		return nil, fmt.Errorf("bitcoin block %s is unknown", btcHashString(&hash))
+	}
+	block := s.blocks[i]
+	proof := &BtcBlockProof{}
+	for index, tx := range block.txs {
+		txProof := &BtcTxProof{
+			Tx:     tx,
+			Index:  uint32(index),
+			Branch: calcMerkleBranch(block.txids, index),
+		}
+		if index == 0 {
+			proof.Coinbase = txProof
+		} else {
+			proof.ValidatorTxs = append(proof.ValidatorTxs, txProof)
+		}
+	}
+	return proof, nil
+}
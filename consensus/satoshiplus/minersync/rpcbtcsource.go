@@ -0,0 +1,166 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package minersync
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+)
+
+// RpcBtcSource implements the BtcSource interface over the RPC interface of a
+// bitcoin server.  Headers and transactions come from the standard bitcoind
+// methods; the validator mapping transactions of a block are located with
+// listblockminerinfo when the server supports it.
+type RpcBtcSource struct {
+	client *rpc.Client
+}
+
+// NewRpcBtcSource returns a new RpcBtcSource connected to the bitcoin server.
+func NewRpcBtcSource(param *chaincfg.BitcoinParams) (*RpcBtcSource, error) {
+	client, err := rpc.DialHTTPWithClientAuthorization("http://"+param.Host,
+		new(http.Client), param.RpcUser, param.RpcPassword)
+	if err != nil {
+		return nil, err
+	}
+	return &RpcBtcSource{client: client}, nil
+}
+
+// GetBestHeight calls getblockcount to the bitcoin server.
+func (s *RpcBtcSource) GetBestHeight() (int32, error) {
+	var height int32
+	err := s.client.Call(&height, "getblockcount")
+	return height, err
+}
+
+// GetHeaders calls getblockhash and getblockheader to the bitcoin server, in
+// two batches.
+func (s *RpcBtcSource) GetHeaders(height, count int32) ([]*BtcBlockHeader, error) {
+	hashes := make([]string, count)
+	batch := make([]rpc.BatchElem, count)
+	for i := range batch {
+		batch[i] = rpc.BatchElem{
+			Method: "getblockhash",
+			Args:   []interface{}{height + int32(i)},
+			Result: &hashes[i],
+		}
+	}
+	if err := s.client.BatchCall(batch); err != nil {
+		return nil, err
+	}
+
+	// The best chain of the server may end before the last height.
+	for i := range batch {
+		if batch[i].Error != nil {
+			batch, hashes = batch[:i], hashes[:i]
+			break
+		}
+	}
+	serialized := make([]string, len(hashes))
+	for i := range batch {
+		batch[i] = rpc.BatchElem{
+			Method: "getblockheader",
+			Args:   []interface{}{hashes[i], false},
+			Result: &serialized[i],
+		}
+	}
+	if err := s.client.BatchCall(batch); err != nil {
+		return nil, err
+	}
+
+	headers := make([]*BtcBlockHeader, 0, len(batch))
+	for i := range batch {
+		if batch[i].Error != nil {
+			return nil, batch[i].Error
+		}
+		buf, err := hex.DecodeString(serialized[i])
+		if err != nil {
+			return nil, err
+		}
+		var header BtcBlockHeader
+		if err = header.Deserialize(buf); err != nil {
+			return nil, err
+		}
+		headers = append(headers, &header)
+	}
+	return headers, nil
+}
+
+// getTxProof fetches the transaction at the index of the block and computes
+// its merkle branch from the ids of the transactions of the block.
+func (s *RpcBtcSource) getTxProof(blockHash string, txids []common.Hash,
+	index int) (*BtcTxProof, error) {
+
+	var serialized string
+	err := s.client.Call(&serialized, "getrawtransaction",
+		btcHashString(&txids[index]), false, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := hex.DecodeString(serialized)
+	if err != nil {
+		return nil, err
+	}
+	return &BtcTxProof{
+		Tx:     tx,
+		Index:  uint32(index),
+		Branch: calcMerkleBranch(txids, index),
+	}, nil
+}
+
+// GetBlockProof calls getblock for the ids of the transactions of the block
+// and getrawtransaction for its coinbase and validator mapping transactions.
+func (s *RpcBtcSource) GetBlockProof(height int32, hash common.Hash) (*BtcBlockProof, error) {
+	blockHash := btcHashString(&hash)
+	var block struct {
+		Tx []string `json:"tx"`
+	}
+	if err := s.client.Call(&block, "getblock", blockHash, 1); err != nil {
+		return nil, err
+	}
+	if len(block.Tx) == 0 {
+		return nil, fmt.Errorf("bitcoin block %s has no transaction", blockHash)
+	}
+	txids := make([]common.Hash, len(block.Tx))
+	indexes := make(map[string]int, len(block.Tx))
+	for i, txid := range block.Tx {
+		var err error
+		if txids[i], err = btcHashFromString(txid); err != nil {
+			return nil, err
+		}
+		indexes[txid] = i
+	}
+
+	coinbase, err := s.getTxProof(blockHash, txids, 0)
+	if err != nil {
+		return nil, err
+	}
+	proof := &BtcBlockProof{Coinbase: coinbase}
+
+	// The mapping transactions reported by the server are only hints: the
+	// client verifies and decodes them.
+	var infos []GetBitcoinBlockMinerInfoResult
+	if err := s.client.Call(&infos, "listblockminerinfo", height, 1); err != nil {
+		log.Debugf("Bitcoin server does not report mapping transactions: %v", err)
+		return proof, nil
+	}
+	for _, info := range infos {
+		for _, vtx := range info.ValidatorTxs {
+			index, ok := indexes[vtx.Txid]
+			if !ok {
+				continue
+			}
+			txProof, err := s.getTxProof(blockHash, txids, index)
+			if err != nil {
+				return nil, err
+			}
+			proof.ValidatorTxs = append(proof.ValidatorTxs, txProof)
+		}
+	}
+	return proof, nil
+}
@@ -0,0 +1,553 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package minersync
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/ainterface"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+)
+
+const (
+	// maxSpvReorgDepth is the maximum number of bitcoin blocks the spv
+	// client replaces when a source offers a chain with more work.
+	maxSpvReorgDepth = 100
+
+	// maxSpvSyncHeaders is the maximum number of headers fetched from a
+	// source at each sync.
+	maxSpvSyncHeaders = 20000
+
+	// maxHeadersPerCall is the maximum number of headers requested from a
+	// source at once.
+	maxHeadersPerCall = 2000
+)
+
+var (
+	btcHeaderBucketName = []byte("btcHeaderIdx")
+
+	// btcHeaderTipKey is the key of the height of the best verified header
+	// in the header bucket.  It can not collide with the 4 bytes height
+	// keys of the headers.
+	btcHeaderTipKey = []byte("tip")
+)
+
+// BtcTxProof proves a bitcoin transaction is part of a block: the merkle
+// branch links the id of the transaction at the index to the merkle root of
+// the block header.
+type BtcTxProof struct {
+	Tx     []byte
+	Index  uint32
+	Branch []common.Hash
+}
+
+// BtcBlockProof holds the transactions of a bitcoin block the satoshiplus
+// consensus needs: the coinbase, which pays the miner, and the validator
+// mapping transactions.
+type BtcBlockProof struct {
+	Coinbase     *BtcTxProof
+	ValidatorTxs []*BtcTxProof
+}
+
+// BtcSource is a source of bitcoin headers and block proofs.  The spv client
+// verifies everything a source returns, so sources need not be trusted.
+type BtcSource interface {
+	// GetBestHeight returns the height of the best chain of the source.
+	GetBestHeight() (int32, error)
+
+	// GetHeaders returns the headers of the best chain of the source from
+	// the height, at most count of them.
+	GetHeaders(height, count int32) ([]*BtcBlockHeader, error)
+
+	// GetBlockProof returns the proofs of the coinbase and the validator
+	// mapping transactions of the block with the hash at the height.
+	GetBlockProof(height int32, hash common.Hash) (*BtcBlockProof, error)
+}
+
+// SpvClient implements the IBtcClient interface by following the bitcoin
+// chain with the most work offered by several sources.  It verifies the proof
+// of work and the difficulty of every header from an anchor header on, and
+// the merkle proofs of the transactions of the blocks, so none of the sources
+// is trusted.  The verified headers are persisted in the database.
+type SpvClient struct {
+	sync.Mutex
+	db           database.Transactor
+	params       *BtcChainParams
+	sources      []BtcSource
+	anchorHeight int32
+	tipHeight    int32
+	tipHash      common.Hash
+}
+
+// Ensure the SpvClient type implements the IBtcClient interface.
+var _ ainterface.IBtcClient = (*SpvClient)(nil)
+
+// NewSpvClientWithParams returns a new SpvClient following the bitcoin
+// network of the asimov network through the configured btc servers.  The
+// anchor is the last difficulty retarget before the initial collect height.
+func NewSpvClientWithParams(db database.Transactor, params *chaincfg.Params) (*SpvClient, error) {
+	btcParams := &BtcRegTestParams
+	switch params.Net {
+	case common.MainNet:
+		btcParams = &BtcMainNetParams
+	case common.TestNet:
+		btcParams = &BtcTestNet3Params
+	}
+
+	// Configuration takes precedence over default parameters.
+	bitcoinParams := make([]*chaincfg.BitcoinParams, 0, len(params.Bitcoin))
+	bitcoinParams = append(bitcoinParams, chaincfg.Cfg.BtcParams...)
+	bitcoinParams = append(bitcoinParams, params.Bitcoin...)
+	sources := make([]BtcSource, 0, len(bitcoinParams))
+	for _, param := range bitcoinParams {
+		source, err := NewRpcBtcSource(param)
+		if err != nil {
+			log.Warnf("Failed to connect bitcoin server %s: %v", param.Host, err)
+			continue
+		}
+		sources = append(sources, source)
+	}
+
+	interval := btcParams.blocksPerRetarget()
+	anchorHeight := params.CollectHeight / interval * interval
+	return NewSpvClient(db, btcParams, anchorHeight, sources...)
+}
+
+// NewSpvClient returns a new SpvClient verifying the headers of the bitcoin
+// network from the anchor height on.  The anchor height must be a difficulty
+// retarget height.  The anchor header is loaded from the database, or taken
+// from the sources when all of them agree on it.
+func NewSpvClient(db database.Transactor, params *BtcChainParams,
+	anchorHeight int32, sources ...BtcSource) (*SpvClient, error) {
+
+	if len(sources) == 0 {
+		return nil, errors.New("spv client requires a bitcoin source")
+	}
+	if !params.PoWNoRetargeting && anchorHeight%params.blocksPerRetarget() != 0 {
+		return nil, fmt.Errorf("spv anchor height %d is not a difficulty "+
+			"retarget height", anchorHeight)
+	}
+	c := &SpvClient{
+		db:           db,
+		params:       params,
+		sources:      sources,
+		anchorHeight: anchorHeight,
+	}
+
+	var tip *BtcBlockHeader
+	err := db.Update(func(dbTx database.Tx) error {
+		bucket, err := dbTx.Metadata().CreateBucketIfNotExists(btcHeaderBucketName)
+		if err != nil {
+			return err
+		}
+		serializedTip := bucket.Get(btcHeaderTipKey)
+		if len(serializedTip) != 4 {
+			return nil
+		}
+		c.tipHeight = int32(binary.BigEndian.Uint32(serializedTip))
+		tip, err = dbFetchBtcHeader(dbTx, c.tipHeight)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tip != nil && c.tipHeight >= anchorHeight {
+		c.tipHash = tip.BlockHash()
+		return c, nil
+	}
+
+	anchor, err := c.fetchAnchor()
+	if err != nil {
+		return nil, err
+	}
+	c.tipHeight = anchorHeight
+	c.tipHash = anchor.BlockHash()
+	err = db.Update(func(dbTx database.Tx) error {
+		return dbPutBtcHeaders(dbTx, anchorHeight, []*BtcBlockHeader{anchor}, anchorHeight)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Spv client anchored at bitcoin block %s (height %d)",
+		btcHashString(&c.tipHash), anchorHeight)
+	return c, nil
+}
+
+// fetchAnchor returns the anchor header the reachable sources agree on.
+func (c *SpvClient) fetchAnchor() (*BtcBlockHeader, error) {
+	var anchor *BtcBlockHeader
+	for i, source := range c.sources {
+		headers, err := source.GetHeaders(c.anchorHeight, 1)
+		if err != nil || len(headers) == 0 {
+			log.Warnf("Failed to fetch the anchor header from bitcoin source %d: %v", i, err)
+			continue
+		}
+		if anchor != nil && headers[0].BlockHash() != anchor.BlockHash() {
+			return nil, fmt.Errorf("bitcoin sources disagree on the anchor "+
+				"header at height %d", c.anchorHeight)
+		}
+		anchor = headers[0]
+	}
+	if anchor == nil {
+		return nil, errors.New("no bitcoin source returned the anchor header")
+	}
+	if err := checkProofOfWork(anchor, c.params.PowLimit); err != nil {
+		return nil, err
+	}
+	return anchor, nil
+}
+
+// dbFetchBtcHeader returns the verified header at the height, nil when there
+// is none.
+func dbFetchBtcHeader(dbTx database.Tx, height int32) (*BtcBlockHeader, error) {
+	serialized := dbTx.Metadata().Bucket(btcHeaderBucketName).Get(encodeMinerInfoDbKey(height))
+	if serialized == nil {
+		return nil, nil
+	}
+	var header BtcBlockHeader
+	if err := header.Deserialize(serialized); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// dbPutBtcHeaders stores the headers from the height on as the best chain,
+// removing the headers of the previous best chain above them up to its tip.
+func dbPutBtcHeaders(dbTx database.Tx, height int32, headers []*BtcBlockHeader, oldTip int32) error {
+	bucket := dbTx.Metadata().Bucket(btcHeaderBucketName)
+	for i, header := range headers {
+		if err := bucket.Put(encodeMinerInfoDbKey(height+int32(i)), header.Serialize()); err != nil {
+			return err
+		}
+	}
+	tip := height + int32(len(headers)) - 1
+	for h := tip + 1; h <= oldTip; h++ {
+		if err := bucket.Delete(encodeMinerInfoDbKey(h)); err != nil {
+			return err
+		}
+	}
+	return bucket.Put(btcHeaderTipKey, encodeMinerInfoDbKey(tip))
+}
+
+// fetchHeader returns the verified header at the height.
+func (c *SpvClient) fetchHeader(height int32) (*BtcBlockHeader, error) {
+	var header *BtcBlockHeader
+	err := c.db.View(func(dbTx database.Tx) error {
+		var err error
+		header, err = dbFetchBtcHeader(dbTx, height)
+		return err
+	})
+	if err == nil && header == nil {
+		err = fmt.Errorf("bitcoin block header %d is unknown", height)
+	}
+	return header, err
+}
+
+// headerBranch is a chain of headers verified to extend the header of the
+// best chain at the fork height.
+type headerBranch struct {
+	source     int
+	forkHeight int32
+	headers    []*BtcBlockHeader
+	work       *big.Int
+}
+
+// fetchBranch fetches the best chain of the source from a few blocks below
+// the tip of the client and verifies it.  It returns nil when the source has
+// no block above them.
+func (c *SpvClient) fetchBranch(index int, source BtcSource) (*headerBranch, error) {
+	bestHeight, err := source.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	forkHeight := c.tipHeight - maxSpvReorgDepth
+	if forkHeight < c.anchorHeight {
+		forkHeight = c.anchorHeight
+	}
+	if bestHeight <= forkHeight {
+		return nil, nil
+	}
+	if bestHeight > c.tipHeight+maxSpvSyncHeaders {
+		bestHeight = c.tipHeight + maxSpvSyncHeaders
+	}
+
+	branch := &headerBranch{
+		source:     index,
+		forkHeight: forkHeight,
+		work:       new(big.Int),
+	}
+	for height := forkHeight + 1; height <= bestHeight; {
+		count := bestHeight - height + 1
+		if count > maxHeadersPerCall {
+			count = maxHeadersPerCall
+		}
+		headers, err := source.GetHeaders(height, count)
+		if err != nil {
+			return nil, err
+		}
+		if len(headers) == 0 {
+			break
+		}
+		branch.headers = append(branch.headers, headers...)
+		height += int32(len(headers))
+	}
+	if len(branch.headers) == 0 {
+		return nil, nil
+	}
+
+	var stored []*BtcBlockHeader
+	err = c.db.View(func(dbTx database.Tx) error {
+		for h := forkHeight; h <= c.tipHeight; h++ {
+			header, err := dbFetchBtcHeader(dbTx, h)
+			if err != nil {
+				return err
+			}
+			if header == nil {
+				return fmt.Errorf("bitcoin block header %d is unknown", h)
+			}
+			stored = append(stored, header)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	lookup := func(height int32) *BtcBlockHeader {
+		switch {
+		case height > forkHeight && int(height-forkHeight) <= len(branch.headers):
+			return branch.headers[height-forkHeight-1]
+		case height >= forkHeight && int(height-forkHeight) < len(stored):
+			return stored[height-forkHeight]
+		case height < forkHeight && height >= c.anchorHeight:
+			header, _ := c.fetchHeader(height)
+			return header
+		}
+		return nil
+	}
+
+	now := time.Now().Unix()
+	prev := stored[0]
+	for i, header := range branch.headers {
+		height := forkHeight + int32(i) + 1
+		err := checkBtcHeaderContext(c.params, header, prev, height, lookup, now)
+		if err != nil {
+			return nil, err
+		}
+		branch.work.Add(branch.work, calcWork(header.Bits))
+		prev = header
+	}
+	return branch, nil
+}
+
+// storedWork returns the work of the best chain above the height.
+func (c *SpvClient) storedWork(height int32) (*big.Int, error) {
+	work := new(big.Int)
+	err := c.db.View(func(dbTx database.Tx) error {
+		for h := height + 1; h <= c.tipHeight; h++ {
+			header, err := dbFetchBtcHeader(dbTx, h)
+			if err != nil {
+				return err
+			}
+			if header == nil {
+				return fmt.Errorf("bitcoin block header %d is unknown", h)
+			}
+			work.Add(work, calcWork(header.Bits))
+		}
+		return nil
+	})
+	return work, err
+}
+
+// Sync fetches the best chain of every source and switches to the verified
+// one with the most work, when it has more work than the best chain of the
+// client.  It fails only when no source could be synced with.
+func (c *SpvClient) Sync() error {
+	c.Lock()
+	defer c.Unlock()
+
+	var best *headerBranch
+	var bestWork *big.Int
+	failures := 0
+	for i, source := range c.sources {
+		branch, err := c.fetchBranch(i, source)
+		if err != nil {
+			log.Warnf("Failed to sync with bitcoin source %d: %v", i, err)
+			failures++
+			continue
+		}
+		if branch == nil {
+			continue
+		}
+		work, err := c.storedWork(branch.forkHeight)
+		if err != nil {
+			return err
+		}
+		work.Sub(branch.work, work)
+		if work.Sign() > 0 && (bestWork == nil || work.Cmp(bestWork) > 0) {
+			best, bestWork = branch, work
+		}
+	}
+	if failures == len(c.sources) {
+		return errors.New("failed to sync with all bitcoin sources")
+	}
+	if best == nil {
+		return nil
+	}
+
+	err := c.db.Update(func(dbTx database.Tx) error {
+		return dbPutBtcHeaders(dbTx, best.forkHeight+1, best.headers, c.tipHeight)
+	})
+	if err != nil {
+		return err
+	}
+	c.tipHeight = best.forkHeight + int32(len(best.headers))
+	c.tipHash = best.headers[len(best.headers)-1].BlockHash()
+	log.Infof("Spv client synced bitcoin headers to %s (height %d) from source %d",
+		btcHashString(&c.tipHash), c.tipHeight, best.source)
+	return nil
+}
+
+// verifyTxProof ensures the transaction of the proof is part of the block of
+// the header and decodes it.
+func verifyTxProof(header *BtcBlockHeader, proof *BtcTxProof) (*btcTx, error) {
+	if proof == nil {
+		return nil, errors.New("missing bitcoin transaction proof")
+	}
+	tx, err := parseBtcTx(proof.Tx)
+	if err != nil {
+		return nil, err
+	}
+	if calcMerkleRoot(tx.txid, proof.Index, proof.Branch) != header.MerkleRoot {
+		hash := header.BlockHash()
+		return nil, fmt.Errorf("bitcoin transaction %s is not part of block %s",
+			btcHashString(&tx.txid), btcHashString(&hash))
+	}
+	return tx, nil
+}
+
+// fetchMinerInfo returns the miner information of the block at the height,
+// from the transactions of the block which any source proves.  The validator
+// mapping transactions are the union of the ones of all sources, so a source
+// can not hide a mapping as long as another one reports it.
+func (c *SpvClient) fetchMinerInfo(height int32, header *BtcBlockHeader) (*GetBitcoinBlockMinerInfoResult, error) {
+	hash := header.BlockHash()
+	var info *GetBitcoinBlockMinerInfoResult
+	mapped := make(map[common.Hash]struct{})
+	for i, source := range c.sources {
+		proof, err := source.GetBlockProof(height, hash)
+		if err != nil {
+			log.Warnf("Failed to fetch bitcoin block %d from source %d: %v", height, i, err)
+			continue
+		}
+		coinbase, err := verifyTxProof(header, proof.Coinbase)
+		if err == nil && (proof.Coinbase.Index != 0 || !coinbase.isCoinbase()) {
+			err = errors.New("first transaction is not a coinbase")
+		}
+		if err != nil {
+			log.Warnf("Invalid coinbase of bitcoin block %d from source %d: %v", height, i, err)
+			continue
+		}
+
+		if info == nil {
+			info = &GetBitcoinBlockMinerInfoResult{
+				Height: height,
+				Hash:   btcHashString(&hash),
+				Time:   header.Timestamp,
+			}
+			for _, txOut := range coinbase.txOut {
+				info.Address = encodeBtcPkScriptAddress(txOut.pkScript, c.params.AddressParams)
+				if info.Address != "" {
+					break
+				}
+			}
+		}
+
+		for _, txProof := range proof.ValidatorTxs {
+			tx, err := verifyTxProof(header, txProof)
+			if err != nil {
+				log.Warnf("Invalid validator tx of bitcoin block %d from source %d: %v", height, i, err)
+				continue
+			}
+			outAddress, ok := mappingOutAddress(tx)
+			if _, exist := mapped[tx.txid]; exist || !ok {
+				continue
+			}
+			mapped[tx.txid] = struct{}{}
+			vtx := BtcValidatorTx{
+				Txid:        btcHashString(&tx.txid),
+				OutAddress:  hex.EncodeToString(outAddress),
+				AddressType: 1,
+			}
+			for _, txIn := range tx.txIn {
+				if addr := encodeBtcTxInAddress(txIn, c.params.AddressParams); addr != "" {
+					vtx.Vin = append(vtx.Vin, addr)
+				}
+			}
+			info.ValidatorTxs = append(info.ValidatorTxs, vtx)
+		}
+	}
+	if info == nil {
+		return nil, fmt.Errorf("no bitcoin source proved the coinbase of block %d", height)
+	}
+	return info, nil
+}
+
+// GetBitcoinMinerInfo returns the miner information of count blocks of the
+// best verified chain from the height.
+func (c *SpvClient) GetBitcoinMinerInfo(result interface{}, height, count int32) error {
+	miners, ok := result.(*[]GetBitcoinBlockMinerInfoResult)
+	if !ok {
+		return errors.New("spv client requires a miner info result")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if height < c.anchorHeight || height+count-1 > c.tipHeight {
+		return fmt.Errorf("bitcoin blocks from %d to %d are not verified",
+			height, height+count-1)
+	}
+	infos := make([]GetBitcoinBlockMinerInfoResult, 0, count)
+	for h := height; h < height+count; h++ {
+		header, err := c.fetchHeader(h)
+		if err != nil {
+			return err
+		}
+		info, err := c.fetchMinerInfo(h, header)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, *info)
+	}
+	*miners = infos
+	return nil
+}
+
+// GetBitcoinBlockChainInfo syncs with the sources and returns the tip of the
+// best verified chain.
+func (c *SpvClient) GetBitcoinBlockChainInfo(result interface{}) error {
+	chainInfo, ok := result.(*GetBlockChainInfoResult)
+	if !ok {
+		return errors.New("spv client requires a chain info result")
+	}
+	if err := c.Sync(); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	*chainInfo = GetBlockChainInfoResult{
+		Chain:         c.params.Name,
+		Blocks:        c.tipHeight,
+		BestBlockHash: btcHashString(&c.tipHash),
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package minersync
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/bitcoinaddress"
+)
+
+// tamperedBtcSource is a bitcoin source lying about the difficulty of its
+// headers and the coinbases of its blocks.
+type tamperedBtcSource struct {
+	*FakeBtcSource
+}
+
+func (s *tamperedBtcSource) GetHeaders(height, count int32) ([]*BtcBlockHeader, error) {
+	headers, err := s.FakeBtcSource.GetHeaders(height, count)
+	for i, header := range headers {
+		if height+int32(i) > s.startHeight {
+			header.Bits = BtcMainNetParams.PowLimitBits
+		}
+	}
+	return headers, err
+}
+
+func (s *tamperedBtcSource) GetBlockProof(height int32, hash common.Hash) (*BtcBlockProof, error) {
+	proof, err := s.FakeBtcSource.GetBlockProof(height, hash)
+	if err == nil {
+		proof.Coinbase.Tx = append([]byte(nil), proof.Coinbase.Tx...)
+		proof.Coinbase.Tx[len(proof.Coinbase.Tx)-5]++
+	}
+	return proof, err
+}
+
+func TestMerkleBranch(t *testing.T) {
+	for n := 1; n <= 7; n++ {
+		leaves := make([]common.Hash, n)
+		for i := range leaves {
+			leaves[i] = common.DoubleHashH([]byte{byte(i)})
+		}
+		root := calcMerkleRoot(leaves[0], 0, calcMerkleBranch(leaves, 0))
+		for i := range leaves {
+			got := calcMerkleRoot(leaves[i], uint32(i), calcMerkleBranch(leaves, i))
+			if got != root {
+				t.Errorf("calcMerkleRoot of leaf %d of %d: got %x want %x", i, n, got, root)
+			}
+		}
+	}
+}
+
+func TestCompactBig(t *testing.T) {
+	for _, bits := range []uint32{0x1d00ffff, 0x207fffff, 0x1b0404cb, 0x170e1f2a} {
+		if got := bigToCompact(compactToBig(bits)); got != bits {
+			t.Errorf("bigToCompact(compactToBig(%08x)): got %08x", bits, got)
+		}
+	}
+	if work := calcWork(0x1d00ffff); work.Int64() != 0x100010001 {
+		t.Errorf("calcWork: got %x want 100010001", work)
+	}
+}
+
+// TestSpvClient ensures the spv client follows the verified chain with the
+// most work and reports the miners and the mapping transactions its blocks
+// prove.
+func TestSpvClient(t *testing.T) {
+	dbPath := chaincfg.DefaultDataDir + "/spvUnitTest"
+	db, err := loadBlockDB(dbPath)
+	if err != nil {
+		t.Fatalf("loadBlockDB error %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	defer db.Close()
+
+	params := &BtcRegTestParams
+	minerA, minerB := bytes.Repeat([]byte{0xaa}, 20), bytes.Repeat([]byte{0xbb}, 20)
+	pubKey := append([]byte{0x02}, bytes.Repeat([]byte{0x11}, 32)...)
+	mapping := &FakeMapping{PubKey: pubKey}
+	copy(mapping.OutAddress[:], bytes.Repeat([]byte{0x66}, 20))
+
+	honest := NewFakeBtcSource(params, 0, 1600000000)
+	for i := 0; i < 12; i++ {
+		miner := minerA
+		if i%2 == 1 {
+			miner = minerB
+		}
+		if i == 4 {
+			honest.Mine(miner, mapping)
+		} else {
+			honest.Mine(miner)
+		}
+	}
+	weaker := NewFakeBtcSource(params, 0, 1600000000)
+	for i := 0; i < 5; i++ {
+		weaker.Mine(minerB)
+	}
+	tampered := &tamperedBtcSource{NewFakeBtcSource(params, 0, 1600000000)}
+	for i := 0; i < 30; i++ {
+		tampered.Mine(minerA)
+	}
+
+	liar := &tamperedBtcSource{honest}
+
+	client, err := NewSpvClient(db, params, 0, tampered, liar, weaker, honest)
+	if err != nil {
+		t.Fatalf("NewSpvClient error %v", err)
+	}
+	if _, err := client.fetchBranch(0, tampered); err == nil {
+		t.Errorf("fetchBranch: headers with a wrong difficulty are accepted")
+	}
+
+	var chainInfo GetBlockChainInfoResult
+	if err := client.GetBitcoinBlockChainInfo(&chainInfo); err != nil {
+		t.Fatalf("GetBitcoinBlockChainInfo error %v", err)
+	}
+	tip, _ := honest.GetHeaders(12, 1)
+	tipHash := tip[0].BlockHash()
+	if chainInfo.Blocks != 12 || chainInfo.BestBlockHash != btcHashString(&tipHash) {
+		t.Fatalf("GetBitcoinBlockChainInfo: got %d %s, want the honest tip",
+			chainInfo.Blocks, chainInfo.BestBlockHash)
+	}
+
+	var infos []GetBitcoinBlockMinerInfoResult
+	if err := client.GetBitcoinMinerInfo(&infos, 1, 12); err != nil {
+		t.Fatalf("GetBitcoinMinerInfo error %v", err)
+	}
+	addrA, _ := bitcoinaddress.NewAddressPubKeyHash(minerA, params.AddressParams)
+	addrB, _ := bitcoinaddress.NewAddressPubKeyHash(minerB, params.AddressParams)
+	vin, _ := bitcoinaddress.NewAddressPubKeyHash(common.Hash160(pubKey), params.AddressParams)
+	for i, info := range infos {
+		want := addrA.EncodeAddress()
+		if i%2 == 1 {
+			want = addrB.EncodeAddress()
+		}
+		if info.Height != int32(i+1) || info.Address != want {
+			t.Errorf("GetBitcoinMinerInfo: block %d mined by %s at %d, want %s at %d",
+				i, info.Address, info.Height, want, i+1)
+		}
+		if (i == 4) != (len(info.ValidatorTxs) == 1) {
+			t.Fatalf("GetBitcoinMinerInfo: block %d has %d mapping transactions",
+				i, len(info.ValidatorTxs))
+		}
+	}
+	vtx := infos[4].ValidatorTxs[0]
+	if len(vtx.Vin) != 1 || vtx.Vin[0] != vin.EncodeAddress() ||
+		vtx.OutAddress != hex.EncodeToString(mapping.OutAddress[:]) {
+		t.Errorf("GetBitcoinMinerInfo: unexpected mapping %+v", vtx)
+	}
+	if err := client.GetBitcoinMinerInfo(&infos, 12, 2); err == nil {
+		t.Errorf("GetBitcoinMinerInfo: unverified blocks are reported")
+	}
+
+	// A chain with more work replaces the best one.
+	for i := 0; i < 10; i++ {
+		weaker.Mine(minerB)
+	}
+	if err := client.GetBitcoinBlockChainInfo(&chainInfo); err != nil {
+		t.Fatalf("GetBitcoinBlockChainInfo error %v", err)
+	}
+	if chainInfo.Blocks != 15 {
+		t.Fatalf("GetBitcoinBlockChainInfo: got height %d want 15", chainInfo.Blocks)
+	}
+	if err := client.GetBitcoinMinerInfo(&infos, 1, 1); err != nil ||
+		infos[0].Address != addrB.EncodeAddress() {
+		t.Errorf("GetBitcoinMinerInfo: got %v, %v after the reorganization", infos, err)
+	}
+
+	// The headers are persisted.
+	reopened, err := NewSpvClient(db, params, 0, honest)
+	if err != nil || reopened.tipHeight != 15 || reopened.tipHash != client.tipHash {
+		t.Errorf("NewSpvClient: got tip %d, %v", reopened.tipHeight, err)
+	}
+}
@@ -32,6 +32,7 @@ var (
 type RoundValidators struct {
 	round      uint32
 	blockHash  common.Hash
+	seed       common.Hash
 	validators []*common.Address
 	weightmap  map[common.Address]uint16
 }
@@ -47,9 +48,6 @@ type RoundManager struct {
 	minerCollector *minersync.BtcPowerCollector
 	db             database.Transactor
 
-	// Expected count of seconds per round.
-	roundInterval int64
-
 	// round cache
 	vLock                sync.RWMutex
     roundValidatorsCache []*RoundValidators
@@ -90,6 +88,13 @@ func (m* RoundManager) HasValidator(validator common.Address) bool {
 // Get validators for special round.
 func (m *RoundManager) GetValidators(blockHash common.Hash, round uint32, fn ainterface.GetValidatorsCallBack) (
 	[]*common.Address, map[common.Address]uint16, error) {
+	return m.GetValidatorsBySeed(blockHash, *chaincfg.ActiveNetParams.GenesisHash, round, fn)
+}
+
+// GetValidatorsBySeed returns the validators for special round, selected
+// with the given seed derived from the vrf outputs of the previous round.
+func (m *RoundManager) GetValidatorsBySeed(blockHash common.Hash, seed common.Hash, round uint32,
+	fn ainterface.GetValidatorsCallBack) ([]*common.Address, map[common.Address]uint16, error) {
 
 	m.vLock.Lock()
 	defer m.vLock.Unlock()
@@ -99,7 +104,7 @@ func (m *RoundManager) GetValidators(blockHash common.Hash, round uint32, fn ain
 		if cache == nil {
 			break
 		}
-		if cache.round == round && cache.blockHash == blockHash {
+		if cache.round == round && cache.blockHash == blockHash && cache.seed == seed {
 			return cache.validators, cache.weightmap, nil
 		}
 	}
@@ -143,14 +148,15 @@ func (m *RoundManager) GetValidators(blockHash common.Hash, round uint32, fn ain
 		}
 	}
 
-	validators := vrf.SelectValidators(candidates, *chaincfg.ActiveNetParams.GenesisHash, round, chaincfg.ActiveNetParams.RoundSize)
+	validators := vrf.SelectValidators(candidates, seed, round,
+		chaincfg.ActiveNetParams.RoundSizeAt(round))
 
-	weightmap := m.setValidators(round, blockHash, validators)
+	weightmap := m.setValidators(round, blockHash, seed, validators)
 	return validators, weightmap, nil
 }
 
 // set validators into the cache
-func (m *RoundManager) setValidators(round uint32,blockHash common.Hash, validators []*common.Address) map[common.Address]uint16 {
+func (m *RoundManager) setValidators(round uint32,blockHash common.Hash, seed common.Hash, validators []*common.Address) map[common.Address]uint16 {
 	weightmap := make(map[common.Address]uint16)
 	for _, validator := range validators {
 		weightmap[*validator]++
@@ -158,6 +164,7 @@ func (m *RoundManager) setValidators(round uint32,blockHash common.Hash, validat
 	newRb := &RoundValidators{
 		round:      round,
 		blockHash:  blockHash,
+		seed:       seed,
 		validators: validators,
 		weightmap:  weightmap,
 	}
@@ -263,7 +270,7 @@ func (m *RoundManager) GetHsMappingByRound(round uint32) (map[string]*ainterface
 // get special round interval
 func (m *RoundManager) GetRoundInterval(round int64) int64 {
 	if round <= 1 {
-		return m.roundInterval
+		return chaincfg.ActiveNetParams.RoundDurationAt(uint32(round))
 	}
 
 	roundMiner1, err := m.getRoundMiner(uint32(round) - 1)
@@ -275,12 +282,17 @@ func (m *RoundManager) GetRoundInterval(round int64) int64 {
 	if err != nil {
 		return 0
 	}
-	interval := int64(roundMiner2.lastTime-roundMiner1.lastTime)
-	if interval < common.MinBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize) {
-		interval = common.MinBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize)
+	// The bitcoin blocks pace the rounds at the default block interval, a
+	// scheduled block interval stretches the rounds accordingly.
+	epoch := chaincfg.ActiveNetParams.ConsensusEpochAt(uint32(round))
+	interval := int64(roundMiner2.lastTime-roundMiner1.lastTime) *
+		epoch.BlockInterval / common.DefaultBlockInterval
+	roundSize := int64(epoch.RoundSize)
+	if interval < common.MinBlockInterval * roundSize {
+		interval = common.MinBlockInterval * roundSize
 	}
-	if interval > common.MaxBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize) {
-		interval = common.MaxBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize)
+	if interval > common.MaxBlockInterval * roundSize {
+		interval = common.MaxBlockInterval * roundSize
 	}
 	return interval
 }
@@ -345,7 +357,5 @@ func (m *RoundManager) GetContract() common.Address {
 
 // create a new round maganger
 func NewRoundManager() *RoundManager {
-	return &RoundManager{
-		roundInterval: int64(chaincfg.ActiveNetParams.RoundSize) * common.DefaultBlockInterval,
-	}
+	return &RoundManager{}
 }
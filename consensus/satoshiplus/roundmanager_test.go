@@ -182,7 +182,7 @@ func TestValidators(t *testing.T) {
 	}
 
 	for i, test := range tests {
-		w := rm.setValidators(uint32(i) + 1,test.hash, test.validators)
+		w := rm.setValidators(uint32(i) + 1,test.hash, *chaincfg.ActiveNetParams.GenesisHash, test.validators)
 		for k, v := range w {
 			if v != test.wantWeights[k] {
 				t.Errorf("TestValidators SetRoundMiner test #%v error", i)
@@ -199,3 +199,35 @@ func TestValidators(t *testing.T) {
 		}
 	}
 }
+
+// TestGetRoundIntervalSchedule ensures the round interval follows the block
+// interval scheduled for the round.
+func TestGetRoundIntervalSchedule(t *testing.T) {
+	origParams := chaincfg.ActiveNetParams.Params
+	defer func() { chaincfg.ActiveNetParams.Params = origParams }()
+	params := chaincfg.DevelopNetParams
+	params.ConsensusSchedule = []chaincfg.ConsensusEpoch{{StartRound: 3, BlockInterval: 10}}
+	chaincfg.ActiveNetParams.Params = &params
+
+	rm := NewRoundManager()
+	rm.rmCache = make([]*RoundMinerInfo, CacheSize)
+	for round := uint32(1); round <= 3; round++ {
+		rm.setRoundMiner(&RoundMinerInfo{round: round, lastTime: round * 800})
+	}
+
+	roundSize := int64(params.RoundSize)
+	tests := []struct {
+		round        int64
+		wantInterval int64
+	}{
+		{1, common.DefaultBlockInterval * roundSize},
+		{2, 800},
+		{3, 1600},
+	}
+	for _, test := range tests {
+		if interval := rm.GetRoundInterval(test.round); interval != test.wantInterval {
+			t.Errorf("GetRoundInterval(%d): got %d, want %d", test.round,
+				interval, test.wantInterval)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/AsimovNetwork/asimov/ainterface"
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
@@ -24,7 +25,7 @@ type SPService struct {
 	wg         sync.WaitGroup
 	existCh    chan interface{}
 	context    params.Context
-	blockTimer *time.Timer
+	blockTimer clock.Timer
 
 	config *params.Config
 
@@ -41,15 +42,16 @@ func NewSatoshiPlusService(config *params.Config) (*SPService, error) {
 		return nil, errors.New("config.chain can't be nil")
 	}
 	chainStartTime := chaincfg.ActiveNetParams.ChainStartTime
-	roundSizei64 := int64(chaincfg.ActiveNetParams.RoundSize)
+	epoch := chaincfg.ActiveNetParams.ConsensusEpochAt(0)
+	roundSizei64 := int64(epoch.RoundSize)
 	service := &SPService{
 		config:       config,
 		chainTipChan: make(chan ainterface.BlockNode),
 		context: params.Context{
 			Round:          0,
 			Slot:           roundSizei64 - 1,
-			RoundStartTime: chainStartTime - (roundSizei64-1)*common.DefaultBlockInterval,
-			RoundInterval:  roundSizei64 * common.DefaultBlockInterval,
+			RoundStartTime: chainStartTime - (roundSizei64-1)*epoch.BlockInterval,
+			RoundInterval:  epoch.Duration(),
 			RoundSize:      roundSizei64,
 		},
 	}
@@ -74,7 +76,7 @@ func (s *SPService) Start() error {
 	log.Info("satoshiplus consensus start")
 
 	// current block maybe do not at the best block height:
-	s.blockTimer = time.NewTimer(time.Hour)
+	s.blockTimer = clock.NewTimer(time.Hour)
 	if err := s.initializeConsensus(); err != nil {
 		log.Errorf("Start satoshi service failed:", err)
 		s.blockTimer.Stop()
@@ -89,7 +91,7 @@ func (s *SPService) Start() error {
 	mainloop:
 		for {
 			select {
-			case <-s.blockTimer.C:
+			case <-s.blockTimer.C():
 				s.handleBlockTimeout()
 			case chainTip := <-s.chainTipChan:
 				s.handleNewBlock(chainTip)
@@ -101,7 +103,7 @@ func (s *SPService) Start() error {
 	cleanup:
 		for {
 			select {
-			case <-s.blockTimer.C:
+			case <-s.blockTimer.C():
 			default:
 				break cleanup
 			}
@@ -148,7 +150,7 @@ func (s *SPService) getValidators(round uint32, verbose bool) ([]*common.Address
 // Initialize Consensus, it only called when service start
 func (s *SPService) initializeConsensus() error {
 	chainStartTime := chaincfg.ActiveNetParams.ChainStartTime
-	now := time.Now().Unix()
+	now := clock.Now().Unix()
 	d := now - chainStartTime
 	if d >= common.DefaultBlockInterval {
 		round, slot, roundStartTime, err := s.getRoundInfo(now)
@@ -188,13 +190,14 @@ func (s *SPService) checkTurn(slot, round int64, verbose bool) bool {
 		return false
 	}
 
-	isTurn := *validators[slot] == *s.config.Account.Address
+	isTurn := *validators[slot] == *s.config.Validator(uint32(round))
 	log.Infof("[checkTurn] slot change slot=%d, round=%d, height=%d, isTurn=%v, interval=%v",
 		slot, round, best.Height+1, isTurn, s.context.RoundInterval)
 	return isTurn
 }
 
-// reset round interval of context, round interval need be reset when round change.
+// reset round interval and round size of context, they need be reset when
+// round change.
 func (s *SPService) resetRoundInterval() bool {
 	roundInterval := s.config.RoundManager.GetRoundInterval(s.context.Round)
 	if roundInterval == 0 {
@@ -203,6 +206,7 @@ func (s *SPService) resetRoundInterval() bool {
 	}
 	log.Infof("Reset round interval, round %d, interval %f", s.context.Round, roundInterval)
 	s.context.RoundInterval = roundInterval
+	s.context.RoundSize = int64(chaincfg.ActiveNetParams.RoundSizeAt(uint32(s.context.Round)))
 	return true
 }
 
@@ -230,7 +234,7 @@ func (s *SPService) handleBlockTimeout() {
 	if isTurn {
 		// milliseconds
 		blockInterval := float64(offset / time.Millisecond)
-		s.processBlock(time.Now().Unix(), round, slot, blockInterval)
+		s.processBlock(clock.Now().Unix(), round, slot, blockInterval)
 		return
 	}
 }
@@ -247,7 +251,7 @@ func (s *SPService) handleNewBlock(chainTip ainterface.BlockNode) {
 func (s *SPService) processBlock(blockTime int64, round, slot int64, interval float64) {
 	log.Infof("satoshiplus gen block start at round=%d, slot=%d", round, slot)
 	template, err := s.config.BlockTemplateGenerator.ProduceNewBlock(
-		s.config.Account, s.config.GasFloor, s.config.GasCeil,
+		s.config.Validator(uint32(round)), s.config.BlockSigner(), s.config.GasFloor, s.config.GasCeil,
 		blockTime, uint32(round), uint16(slot), interval)
 	if err != nil {
 		log.Errorf("satoshiplus gen block failed to make a block: %v", err)
@@ -300,14 +304,14 @@ func (s *SPService) getRoundInfo(targetTime int64) (int64, int64, int64, error)
 	if curTime+roundInterval == targetTime {
 		return round + 1, 0, targetTime, nil
 	}
-	slot := (targetTime - curTime) * s.context.RoundSize / roundInterval
+	slot := (targetTime - curTime) * int64(chaincfg.ActiveNetParams.RoundSizeAt(uint32(round))) / roundInterval
 	return round, slot, curTime, nil
 }
 
 // reset blockTimer.
 func (s *SPService) resetTimer(slot int64) time.Duration {
 	d := slot * s.context.RoundInterval * int64(time.Second) / s.context.RoundSize
-	offset := time.Unix(s.context.RoundStartTime, 0).Add(time.Duration(d) + time.Millisecond).Sub(time.Now())
+	offset := time.Unix(s.context.RoundStartTime, 0).Add(time.Duration(d) + time.Millisecond).Sub(clock.Now())
 	s.blockTimer.Reset(offset)
 	return offset
 }
@@ -4,6 +4,7 @@
 package satoshiplus
 
 import (
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/crypto"
@@ -142,7 +143,7 @@ func TestGenblock(t *testing.T) {
 	if err != nil {
 		t.Errorf("tests NewSatoshiPlusService error %v", err)
 	}
-	ps.blockTimer = time.NewTimer(time.Hour)
+	ps.blockTimer = clock.NewTimer(time.Hour)
 	ps.context.Round = 0
 	ps.context.Slot = int64(chaincfg.ActiveNetParams.RoundSize) - 1
 	acc := ps.config.Account
@@ -0,0 +1,306 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package consensus
+
+import (
+	"sync"
+
+	"github.com/AsimovNetwork/asimov/ainterface"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus/params"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database"
+)
+
+// scheduledEngines returns the names of the consensus engines used by the
+// schedule of the network, the configured one first.
+func scheduledEngines(consensusName string, chainParams *chaincfg.Params) []string {
+	names := []string{consensusName}
+	for _, epoch := range chainParams.ConsensusSchedule {
+		known := epoch.Consensus == ""
+		for _, name := range names {
+			known = known || name == epoch.Consensus
+		}
+		if !known {
+			names = append(names, epoch.Consensus)
+		}
+	}
+	return names
+}
+
+// engineAt returns the name of the consensus engine scheduled for the round.
+func engineAt(consensusName string, chainParams *chaincfg.Params, round uint32) string {
+	if epoch := chainParams.ConsensusEpochAt(round); epoch.Consensus != "" {
+		return epoch.Consensus
+	}
+	return consensusName
+}
+
+// scheduledRoundManager implements the IRoundManager interface by delegating
+// to the round manager of the consensus engine scheduled for each round.
+type scheduledRoundManager struct {
+	consensusName string
+	chainParams   *chaincfg.Params
+	managers      map[string]ainterface.IRoundManager
+
+	// round is the highest round the manager was asked for, the one of
+	// the engine which currently produces blocks.
+	roundLock sync.RWMutex
+	round     uint32
+}
+
+// Ensure the scheduledRoundManager type implements the IRoundManager and the
+// IRoundContract interfaces.
+var _ ainterface.IRoundManager = (*scheduledRoundManager)(nil)
+var _ ainterface.IRoundContract = (*scheduledRoundManager)(nil)
+
+// NewScheduledRoundManager creates the round manager of the network which
+// honors its consensus schedule: each round is managed by the engine scheduled
+// for it, the configured one when none is.  It is the plain round manager of
+// the configured engine when the schedule names no other engine.
+func NewScheduledRoundManager(consensusName string, acc *crypto.Account,
+	chainParams *chaincfg.Params) (ainterface.IRoundManager, error) {
+
+	names := scheduledEngines(consensusName, chainParams)
+	if len(names) == 1 {
+		return NewRoundManager(consensusName, acc, chainParams.EngineParams(consensusName))
+	}
+
+	m := &scheduledRoundManager{
+		consensusName: consensusName,
+		chainParams:   chainParams,
+		managers:      make(map[string]ainterface.IRoundManager),
+	}
+	for _, name := range names {
+		manager, err := NewRoundManager(name, acc, chainParams.EngineParams(name))
+		if err != nil {
+			return nil, err
+		}
+		m.managers[name] = manager
+	}
+	return m, nil
+}
+
+// managerAt returns the round manager of the engine scheduled for the round.
+func (m *scheduledRoundManager) managerAt(round uint32) ainterface.IRoundManager {
+	return m.managers[engineAt(m.consensusName, m.chainParams, round)]
+}
+
+// observeRound records the round as the current one when it is the highest
+// seen.
+func (m *scheduledRoundManager) observeRound(round uint32) {
+	m.roundLock.Lock()
+	if round > m.round {
+		m.round = round
+	}
+	m.roundLock.Unlock()
+}
+
+func (m *scheduledRoundManager) Init(round uint32, db database.Transactor, c ainterface.IBtcClient) error {
+	m.observeRound(round)
+	for _, manager := range m.managers {
+		if err := manager.Init(round, db, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *scheduledRoundManager) Start() {
+	for _, manager := range m.managers {
+		manager.Start()
+	}
+}
+
+func (m *scheduledRoundManager) Halt() {
+	for _, manager := range m.managers {
+		manager.Halt()
+	}
+}
+
+// GetContract returns the consensus contract of the engine of the current
+// round.
+func (m *scheduledRoundManager) GetContract() common.Address {
+	m.roundLock.RLock()
+	round := m.round
+	m.roundLock.RUnlock()
+	return m.GetContractByRound(round)
+}
+
+// GetContractByRound returns the consensus contract of the engine scheduled
+// for the round.  This is part of the IRoundContract interface.
+func (m *scheduledRoundManager) GetContractByRound(round uint32) common.Address {
+	return m.managerAt(round).GetContract()
+}
+
+func (m *scheduledRoundManager) GetHsMappingByRound(round uint32) (map[string]*ainterface.ValidatorInfo, error) {
+	return m.managerAt(round).GetHsMappingByRound(round)
+}
+
+func (m *scheduledRoundManager) GetRoundInterval(round int64) int64 {
+	return m.managerAt(uint32(round)).GetRoundInterval(round)
+}
+
+// GetNextRound returns the round following the passed one, as the engine
+// scheduled for the next round defines it.
+func (m *scheduledRoundManager) GetNextRound(round *ainterface.Round) (*ainterface.Round, error) {
+	next, err := m.managerAt(round.Round + 1).GetNextRound(round)
+	if err != nil {
+		return nil, err
+	}
+	m.observeRound(next.Round)
+	return next, nil
+}
+
+// HasValidator returns whether any of the scheduled engines knows the
+// validator.
+func (m *scheduledRoundManager) HasValidator(validator common.Address) bool {
+	for _, manager := range m.managers {
+		if manager.HasValidator(validator) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *scheduledRoundManager) GetValidators(blockHash common.Hash, round uint32,
+	fn ainterface.GetValidatorsCallBack) ([]*common.Address, map[common.Address]uint16, error) {
+	return m.managerAt(round).GetValidators(blockHash, round, fn)
+}
+
+// GetValidatorsBySeed selects the validators with the seed when the engine
+// of the round supports it, and ignores the seed otherwise.
+func (m *scheduledRoundManager) GetValidatorsBySeed(blockHash common.Hash, seed common.Hash, round uint32,
+	fn ainterface.GetValidatorsCallBack) ([]*common.Address, map[common.Address]uint16, error) {
+	manager := m.managerAt(round)
+	if vrm, ok := manager.(ainterface.IVrfRoundManager); ok {
+		return vrm.GetValidatorsBySeed(blockHash, seed, round, fn)
+	}
+	return manager.GetValidators(blockHash, round, fn)
+}
+
+// scheduledService implements the Consensus interface by running the service
+// of the consensus engine scheduled for the round of the next block.  It
+// halts the service of an engine and starts the one of the next engine when
+// the chain reaches the round the next engine is scheduled for.
+type scheduledService struct {
+	sync.Mutex
+	consensusName string
+	chainParams   *chaincfg.Params
+	chain         *blockchain.BlockChain
+	services      map[string]ainterface.Consensus
+	active        string
+	started       bool
+}
+
+// NewScheduledService creates the consensus service of the network which
+// honors its consensus schedule.  It is the plain service of the configured
+// engine when the schedule names no other engine.
+func NewScheduledService(consensusName string, cfg *params.Config,
+	chainParams *chaincfg.Params) (ainterface.Consensus, error) {
+
+	names := scheduledEngines(consensusName, chainParams)
+	if len(names) == 1 {
+		return NewConsensusService(consensusName, cfg)
+	}
+
+	s := &scheduledService{
+		consensusName: consensusName,
+		chainParams:   chainParams,
+		chain:         cfg.Chain,
+		services:      make(map[string]ainterface.Consensus),
+	}
+	for _, name := range names {
+		engineCfg := *cfg
+		engineCfg.EngineParams = chainParams.EngineParams(name)
+		service, err := NewConsensusService(name, &engineCfg)
+		if err != nil {
+			return nil, err
+		}
+		s.services[name] = service
+	}
+	cfg.Chain.Subscribe(s.handleBlockchainNotification)
+	return s, nil
+}
+
+// nextEngine returns the name of the engine scheduled for the round of the
+// block following the passed one.
+func (s *scheduledService) nextEngine(node ainterface.BlockNode) string {
+	round := node.Round()
+	if node.Slot()+1 >= s.chainParams.RoundSizeAt(round) {
+		round++
+	}
+	return engineAt(s.consensusName, s.chainParams, round)
+}
+
+func (s *scheduledService) Start() error {
+	s.Lock()
+	defer s.Unlock()
+	s.active = s.nextEngine(s.chain.GetTip())
+	s.started = true
+	log.Infof("Start the %s consensus service", s.active)
+	return s.services[s.active].Start()
+}
+
+func (s *scheduledService) Halt() error {
+	s.Lock()
+	defer s.Unlock()
+	s.started = false
+	return s.services[s.active].Halt()
+}
+
+func (s *scheduledService) GetRoundInterval() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.services[s.active].GetRoundInterval()
+}
+
+// switchEngine halts the active service and starts the one of the passed
+// engine.
+func (s *scheduledService) switchEngine(name string) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.started || s.active == name {
+		return
+	}
+	log.Infof("Switch the consensus service from %s to %s", s.active, name)
+	if err := s.services[s.active].Halt(); err != nil {
+		log.Errorf("Failed to halt the %s consensus service: %v", s.active, err)
+	}
+	s.active = name
+	if err := s.services[name].Start(); err != nil {
+		log.Errorf("Failed to start the %s consensus service: %v", name, err)
+	}
+}
+
+// handleBlockchainNotification switches the consensus service when the block
+// connected to the main chain is the last one before a scheduled engine
+// change.
+func (s *scheduledService) handleBlockchainNotification(notification *blockchain.Notification) {
+	if notification.Type != blockchain.NTBlockConnected {
+		return
+	}
+	dataList, ok := notification.Data.([]interface{})
+	if !ok || len(dataList) < 3 {
+		return
+	}
+	node, ok := dataList[2].(ainterface.BlockNode)
+	if !ok {
+		return
+	}
+
+	s.Lock()
+	name := s.nextEngine(node)
+	switching := s.started && name != s.active
+	s.Unlock()
+
+	// The active service may be waiting for this notification to be
+	// handled, so it is halted from another goroutine.
+	if switching {
+		go s.switchEngine(name)
+	}
+}
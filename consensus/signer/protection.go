@@ -0,0 +1,187 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package signer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// ErrDoubleSign describes a signature the protection state refuses, because
+// it could conflict with a signature made before.
+var ErrDoubleSign = errors.New("refusing to double sign")
+
+// SignRecord identifies the highest block signed for a kind of signature.
+type SignRecord struct {
+	Height int32       `json:"height"`
+	Round  uint32      `json:"round"`
+	Slot   uint16      `json:"slot"`
+	Hash   common.Hash `json:"hash"`
+}
+
+// newSignRecord returns the record of the block of the header.
+func newSignRecord(header *protos.BlockHeader) *SignRecord {
+	return &SignRecord{
+		Height: header.Height,
+		Round:  header.Round,
+		Slot:   header.SlotIndex,
+		Hash:   header.BlockHash(),
+	}
+}
+
+// protectionState is the persisted state of a Protection.
+type protectionState struct {
+	Proposal *SignRecord `json:"proposal,omitempty"`
+	Block    *SignRecord `json:"block,omitempty"`
+}
+
+// Protection keeps the highest blocks a validator signed, and refuses the
+// signatures which could make it double sign.  A validator proposes a block
+// per slot, so a proposal must be for a later slot than the last one.  It
+// endorses a block per height, so a block it signs must be higher than the
+// last one.  Signing the same block again is allowed.
+//
+// The state is written to its file before a signature is allowed, so it
+// survives a crash of the signer.
+type Protection struct {
+	sync.Mutex
+	path  string
+	state protectionState
+}
+
+// NewProtection returns the protection state stored in the file, or a new
+// one when the file does not exist.  The state is only kept in memory when
+// the path is empty.
+func NewProtection(path string) (*Protection, error) {
+	p := &Protection{path: path}
+	if path == "" {
+		return p, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.state); err != nil {
+		return nil, fmt.Errorf("corrupt protection state %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// LastProposal returns the highest block proposed, nil when there is none.
+func (p *Protection) LastProposal() *SignRecord {
+	p.Lock()
+	defer p.Unlock()
+	return p.state.Proposal
+}
+
+// LastBlock returns the highest block of another validator signed, nil
+// when there is none.
+func (p *Protection) LastBlock() *SignRecord {
+	p.Lock()
+	defer p.Unlock()
+	return p.state.Block
+}
+
+// CheckProposal ensures the validator may propose the block of the header
+// and records it.
+//
+// This function is safe for concurrent access.
+func (p *Protection) CheckProposal(header *protos.BlockHeader) error {
+	p.Lock()
+	defer p.Unlock()
+
+	record := newSignRecord(header)
+	if last := p.state.Proposal; last != nil {
+		if record.Round < last.Round ||
+			record.Round == last.Round && record.Slot < last.Slot {
+			return fmt.Errorf("%v: proposal for round %d slot %d after round %d slot %d",
+				ErrDoubleSign, record.Round, record.Slot, last.Round, last.Slot)
+		}
+		if record.Round == last.Round && record.Slot == last.Slot {
+			if record.Hash != last.Hash {
+				return fmt.Errorf("%v: another block %v was proposed for round %d slot %d",
+					ErrDoubleSign, last.Hash, last.Round, last.Slot)
+			}
+			return nil
+		}
+	}
+	return p.update(func(state *protectionState) { state.Proposal = record })
+}
+
+// CheckBlock ensures the validator may sign the block of the header,
+// proposed by another validator, and records it.
+//
+// This function is safe for concurrent access.
+func (p *Protection) CheckBlock(header *protos.BlockHeader) error {
+	p.Lock()
+	defer p.Unlock()
+
+	record := newSignRecord(header)
+	if last := p.state.Block; last != nil {
+		if record.Height < last.Height {
+			return fmt.Errorf("%v: block at height %d after height %d",
+				ErrDoubleSign, record.Height, last.Height)
+		}
+		if record.Height == last.Height {
+			if record.Hash != last.Hash {
+				return fmt.Errorf("%v: another block %v was signed at height %d",
+					ErrDoubleSign, last.Hash, last.Height)
+			}
+			return nil
+		}
+	}
+	return p.update(func(state *protectionState) { state.Block = record })
+}
+
+// update applies the change to a copy of the state, writes it and then
+// keeps it.  The state is left unchanged when it can not be written.
+func (p *Protection) update(change func(state *protectionState)) error {
+	state := p.state
+	change(&state)
+	if p.path != "" {
+		data, err := json.Marshal(&state)
+		if err != nil {
+			return err
+		}
+		if err := writeFileSync(p.path, data); err != nil {
+			return fmt.Errorf("failed to write protection state: %v", err)
+		}
+	}
+	p.state = state
+	return nil
+}
+
+// writeFileSync replaces the file with the data, through a temporary file
+// flushed to the disk and renamed, so the file is never left partially
+// written.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package signer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil/vrf"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+)
+
+const (
+	// apiNamespace is the namespace of the methods of the signer api.
+	apiNamespace = "signer"
+
+	// remoteSignerTimeout is the time a request to the signer daemon may
+	// take, well below the interval of the blocks.
+	remoteSignerTimeout = 3 * time.Second
+)
+
+// errBadSignature describes a signature of the signer daemon which was not
+// made with its key.
+var errBadSignature = errors.New("remote signer returned an invalid signature")
+
+// RemoteSigner implements the Signer interface with a signer daemon serving
+// the signer api on a local socket, a unix domain socket or a named pipe on
+// windows.  The signatures it returns are checked against its public key.
+type RemoteSigner struct {
+	client    *rpc.Client
+	publicKey *crypto.PublicKey
+}
+
+// Ensure RemoteSigner implements the Signer interface.
+var _ Signer = (*RemoteSigner)(nil)
+
+// NewRemoteSigner connects to the signer daemon listening on the endpoint
+// and fetches its public key.
+func NewRemoteSigner(endpoint string) (*RemoteSigner, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSignerTimeout)
+	defer cancel()
+	client, err := rpc.DialIPC(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var key hexutil.Bytes
+	if err := client.CallContext(ctx, &key, apiNamespace+"_publicKey"); err != nil {
+		client.Close()
+		return nil, err
+	}
+	publicKey, err := crypto.ParsePubKey(key, crypto.S256())
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RemoteSigner{
+		client:    client,
+		publicKey: publicKey,
+	}, nil
+}
+
+// Close closes the connection to the signer daemon.
+func (s *RemoteSigner) Close() {
+	s.client.Close()
+}
+
+// call invokes the method of the signer api.
+func (s *RemoteSigner) call(method string, arg []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSignerTimeout)
+	defer cancel()
+	var result hexutil.Bytes
+	err := s.client.CallContext(ctx, &result, apiNamespace+"_"+method, hexutil.Bytes(arg))
+	return result, err
+}
+
+// signHeader asks the signer daemon to sign the header with the method, and
+// checks the signature.
+func (s *RemoteSigner) signHeader(method string, header *protos.BlockHeader) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := header.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	signature, err := s.call(method, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	hash := header.BlockHash()
+	pub, err := crypto.SigToPub(hash[:], signature)
+	if err != nil || !(*crypto.PublicKey)(pub).IsEqual(s.publicKey) {
+		return nil, errBadSignature
+	}
+	return signature, nil
+}
+
+// PublicKey returns the public key of the signer daemon.
+//
+// This is part of the Signer interface.
+func (s *RemoteSigner) PublicKey() *crypto.PublicKey {
+	return s.publicKey
+}
+
+// SignProposal asks the signer daemon to sign the header of a block the
+// validator proposes.
+//
+// This is part of the Signer interface.
+func (s *RemoteSigner) SignProposal(header *protos.BlockHeader) ([]byte, error) {
+	return s.signHeader("signProposal", header)
+}
+
+// SignBlock asks the signer daemon to sign the header of a block of another
+// validator.
+//
+// This is part of the Signer interface.
+func (s *RemoteSigner) SignBlock(header *protos.BlockHeader) ([]byte, error) {
+	return s.signHeader("signBlock", header)
+}
+
+// ProveVrf asks the signer daemon for the vrf proof of the input.
+//
+// This is part of the Signer interface.
+func (s *RemoteSigner) ProveVrf(alpha []byte) ([]byte, error) {
+	proof, err := s.call("proveVrf", alpha)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vrf.Verify(s.publicKey, alpha, proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// Service serves the signer api of a signer daemon, with the methods
+// signer_publicKey, signer_signProposal, signer_signBlock and
+// signer_proveVrf.  The headers, signatures, inputs and proofs are hex
+// encoded.
+type Service struct {
+	signer Signer
+}
+
+// NewService returns a service signing with the signer.
+func NewService(signer Signer) *Service {
+	return &Service{signer: signer}
+}
+
+// APIs returns the api of the service to register in a rpc server.
+func (s *Service) APIs() []rpc.API {
+	return []rpc.API{{
+		Namespace: apiNamespace,
+		Version:   "1.0",
+		Service:   s,
+		Public:    false,
+	}}
+}
+
+// PublicKey returns the compressed public key of the signer.
+func (s *Service) PublicKey() hexutil.Bytes {
+	return s.signer.PublicKey().SerializeCompressed()
+}
+
+// SignProposal returns the signature of the serialized header of a block
+// the validator proposes.
+func (s *Service) SignProposal(data hexutil.Bytes) (hexutil.Bytes, error) {
+	var header protos.BlockHeader
+	if err := header.Deserialize(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	signature, err := s.signer.SignProposal(&header)
+	if err != nil {
+		log.Warnf("Refused to sign proposal %v at height %d round %d slot %d: %v",
+			header.BlockHash(), header.Height, header.Round, header.SlotIndex, err)
+		return nil, err
+	}
+	log.Infof("Signed proposal %v at height %d round %d slot %d",
+		header.BlockHash(), header.Height, header.Round, header.SlotIndex)
+	return signature, nil
+}
+
+// SignBlock returns the signature of the serialized header of a block of
+// another validator.
+func (s *Service) SignBlock(data hexutil.Bytes) (hexutil.Bytes, error) {
+	var header protos.BlockHeader
+	if err := header.Deserialize(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	signature, err := s.signer.SignBlock(&header)
+	if err != nil {
+		log.Warnf("Refused to sign block %v at height %d: %v",
+			header.BlockHash(), header.Height, err)
+		return nil, err
+	}
+	log.Infof("Signed block %v at height %d", header.BlockHash(), header.Height)
+	return signature, nil
+}
+
+// ProveVrf returns the vrf proof of the input.
+func (s *Service) ProveVrf(alpha hexutil.Bytes) (hexutil.Bytes, error) {
+	return s.signer.ProveVrf(alpha)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package signer produces the signatures of a validator: the signature of
+// the header of the blocks it proposes, the signatures of the blocks of the
+// other validators it endorses with a MsgBlockSign and its vrf proofs.
+//
+// The signatures are made either in the process of the node, with the
+// private key of its account, or by a signer daemon the node reaches on a
+// local socket, so the key does not live on the internet facing host.  The
+// daemon keeps a slashing protection state and refuses to sign conflicting
+// blocks.
+package signer
+
+import (
+	"crypto/ecdsa"
+
+	"github.com/AsimovNetwork/asimov/asiutil/vrf"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// Signer produces the signatures of a validator.
+type Signer interface {
+	// PublicKey returns the public key of the key signing the blocks.
+	PublicKey() *crypto.PublicKey
+
+	// SignProposal returns the signature of the header of a block the
+	// validator proposes, which goes in the SigData of the header.
+	SignProposal(header *protos.BlockHeader) ([]byte, error)
+
+	// SignBlock returns the signature of the block of the header, proposed
+	// by another validator, which goes in a MsgBlockSign.
+	SignBlock(header *protos.BlockHeader) ([]byte, error)
+
+	// ProveVrf returns the vrf proof of the input.
+	ProveVrf(alpha []byte) ([]byte, error)
+}
+
+// LocalSigner implements the Signer interface with a private key held in
+// memory.
+type LocalSigner struct {
+	privateKey *crypto.PrivateKey
+	protection *Protection
+}
+
+// Ensure LocalSigner implements the Signer interface.
+var _ Signer = (*LocalSigner)(nil)
+
+// NewLocalSigner returns a signer signing with the private key.  The
+// signatures are checked against the protection state, if one is passed.
+func NewLocalSigner(privateKey *crypto.PrivateKey, protection *Protection) *LocalSigner {
+	return &LocalSigner{
+		privateKey: privateKey,
+		protection: protection,
+	}
+}
+
+// PublicKey returns the public key of the private key.
+//
+// This is part of the Signer interface.
+func (s *LocalSigner) PublicKey() *crypto.PublicKey {
+	return s.privateKey.PubKey()
+}
+
+// SignProposal signs the hash of the header, once the protection state
+// allows it.
+//
+// This is part of the Signer interface.
+func (s *LocalSigner) SignProposal(header *protos.BlockHeader) ([]byte, error) {
+	if s.protection != nil {
+		if err := s.protection.CheckProposal(header); err != nil {
+			return nil, err
+		}
+	}
+	return s.sign(header)
+}
+
+// SignBlock signs the hash of the header, once the protection state allows
+// it.
+//
+// This is part of the Signer interface.
+func (s *LocalSigner) SignBlock(header *protos.BlockHeader) ([]byte, error) {
+	if s.protection != nil {
+		if err := s.protection.CheckBlock(header); err != nil {
+			return nil, err
+		}
+	}
+	return s.sign(header)
+}
+
+// ProveVrf returns the vrf proof of the input.  Proofs are deterministic,
+// so they are not subject to the protection state.
+//
+// This is part of the Signer interface.
+func (s *LocalSigner) ProveVrf(alpha []byte) ([]byte, error) {
+	proof, _, err := vrf.Prove(s.privateKey, alpha)
+	return proof, err
+}
+
+func (s *LocalSigner) sign(header *protos.BlockHeader) ([]byte, error) {
+	hash := header.BlockHash()
+	return crypto.Sign(hash[:], (*ecdsa.PrivateKey)(s.privateKey))
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package signer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil/vrf"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+)
+
+func newTestHeader(height int32, round uint32, slot uint16, gasUsed uint64) *protos.BlockHeader {
+	return &protos.BlockHeader{
+		Height:    height,
+		Round:     round,
+		SlotIndex: slot,
+		GasUsed:   gasUsed,
+	}
+}
+
+// TestProtection ensures the protection state refuses the signatures which
+// could conflict with the ones made before, also once reloaded.
+func TestProtection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protection")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "protection.json")
+
+	p, err := NewProtection(path)
+	if err != nil {
+		t.Fatalf("NewProtection error %v", err)
+	}
+	tests := []struct {
+		proposal bool
+		header   *protos.BlockHeader
+		wantErr  bool
+	}{
+		{true, newTestHeader(10, 2, 3, 0), false},
+		{true, newTestHeader(10, 2, 3, 0), false},
+		{true, newTestHeader(10, 2, 3, 1), true},
+		{true, newTestHeader(11, 2, 2, 0), true},
+		{true, newTestHeader(9, 3, 0, 0), false},
+		{false, newTestHeader(20, 5, 0, 0), false},
+		{false, newTestHeader(20, 5, 0, 0), false},
+		{false, newTestHeader(20, 5, 1, 0), true},
+		{false, newTestHeader(19, 6, 0, 0), true},
+		{false, newTestHeader(21, 5, 1, 0), false},
+	}
+	for i, test := range tests {
+		check := p.CheckBlock
+		if test.proposal {
+			check = p.CheckProposal
+		}
+		if err := check(test.header); (err != nil) != test.wantErr {
+			t.Errorf("tests #%d: got error %v, want error %v", i, err, test.wantErr)
+		}
+	}
+
+	reloaded, err := NewProtection(path)
+	if err != nil {
+		t.Fatalf("NewProtection error %v", err)
+	}
+	if last := reloaded.LastProposal(); last == nil || last.Height != 9 || last.Round != 3 {
+		t.Errorf("LastProposal: got %+v", last)
+	}
+	if last := reloaded.LastBlock(); last == nil || last.Height != 21 {
+		t.Errorf("LastBlock: got %+v", last)
+	}
+	if err := reloaded.CheckBlock(newTestHeader(21, 6, 0, 0)); err == nil {
+		t.Errorf("CheckBlock: a conflicting block is signed after a reload")
+	}
+}
+
+// TestRemoteSigner ensures the remote signer gets the signatures of the
+// signer daemon, and its refusals.
+func TestRemoteSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	privateKey, _ := crypto.NewPrivateKey(crypto.S256())
+	protection, _ := NewProtection("")
+	service := NewService(NewLocalSigner(privateKey, protection))
+	endpoint := filepath.Join(dir, "signer.ipc")
+	listener, server, err := rpc.StartIPCEndpoint(endpoint, service.APIs(), 1)
+	if err != nil {
+		t.Fatalf("StartIPCEndpoint error %v", err)
+	}
+	defer server.Stop()
+	defer listener.Close()
+
+	remote, err := NewRemoteSigner(endpoint)
+	if err != nil {
+		t.Fatalf("NewRemoteSigner error %v", err)
+	}
+	defer remote.Close()
+	if !remote.PublicKey().IsEqual(privateKey.PubKey()) {
+		t.Fatalf("PublicKey: got a different key")
+	}
+
+	header := newTestHeader(10, 2, 3, 0)
+	signature, err := remote.SignProposal(header)
+	if err != nil {
+		t.Fatalf("SignProposal error %v", err)
+	}
+	hash := header.BlockHash()
+	pub, err := crypto.SigToPub(hash[:], signature)
+	if err != nil || !(*crypto.PublicKey)(pub).IsEqual(privateKey.PubKey()) {
+		t.Errorf("SignProposal: the signature is not made with the key")
+	}
+	if _, err := remote.SignProposal(newTestHeader(10, 2, 3, 1)); err == nil {
+		t.Errorf("SignProposal: a conflicting proposal is signed")
+	}
+	if _, err := remote.SignBlock(newTestHeader(10, 2, 4, 0)); err != nil {
+		t.Errorf("SignBlock error %v", err)
+	}
+
+	alpha := []byte("alpha")
+	proof, err := remote.ProveVrf(alpha)
+	if err != nil {
+		t.Fatalf("ProveVrf error %v", err)
+	}
+	if _, err := vrf.Verify(privateKey.PubKey(), alpha, proof); err != nil {
+		t.Errorf("ProveVrf: invalid proof %v", err)
+	}
+}
@@ -20,10 +20,11 @@ func (m* RoundManager) HasValidator(validator common.Address) bool {
 
 func (m *RoundManager) GetValidators(blockHash common.Hash, round uint32, fn ainterface.GetValidatorsCallBack) (
 	[]*common.Address, map[common.Address]uint16, error) {
-	validators := make([]*common.Address, chaincfg.ActiveNetParams.RoundSize)
+	roundSize := chaincfg.ActiveNetParams.RoundSizeAt(round)
+	validators := make([]*common.Address, roundSize)
 	weightmap := make(map[common.Address]uint16)
 	l := len(m.addrs)
-	for i := 0; i < int(chaincfg.ActiveNetParams.RoundSize); i++ {
+	for i := 0; i < int(roundSize); i++ {
 		validators[i] = m.addrs[i%l]
 	}
 	for _, v := range m.addrs {
@@ -40,13 +41,13 @@ func (m *RoundManager) GetNextRound(round *ainterface.Round) (*ainterface.Round,
 	newRound := &ainterface.Round{
 		Round: round.Round + 1,
 		RoundStartUnix: round.RoundStartUnix + round.Duration,
-		Duration: common.DefaultBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize),
+		Duration: chaincfg.ActiveNetParams.RoundDurationAt(round.Round + 1),
 	}
 	return newRound, nil
 }
 
 func (m *RoundManager) GetRoundInterval(round int64) int64 {
-	return common.DefaultBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize)
+	return chaincfg.ActiveNetParams.RoundDurationAt(uint32(round))
 }
 
 func (m *RoundManager) Init(round uint32, db database.Transactor, c ainterface.IBtcClient) error {
@@ -6,6 +6,7 @@ package solo
 
 import (
 	"errors"
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
@@ -22,7 +23,7 @@ type SoloService struct {
 	wg      sync.WaitGroup
 	existCh chan interface{}
 	context params.Context
-	timer   *time.Timer
+	timer   clock.Timer
 	config  *params.Config
 }
 
@@ -49,7 +50,7 @@ func (s *SoloService) Start() error {
 	}
 
 	// temp timer, need reset
-	s.timer = time.NewTimer(common.DefaultBlockInterval * 10000)
+	s.timer = clock.NewTimer(common.DefaultBlockInterval * 10000)
 	s.initializeConsensus()
 	s.existCh = make(chan interface{})
 	s.wg.Add(1)
@@ -58,7 +59,7 @@ func (s *SoloService) Start() error {
 		existCh := s.existCh
 		for {
 			select {
-			case <-s.timer.C:
+			case <-s.timer.C():
 				s.genBlock()
 			case <-existCh:
 				s.wg.Done()
@@ -83,14 +84,14 @@ func (s *SoloService) Halt() error {
 func (s *SoloService) genBlock() {
 	round, slot := s.slotControl()
 	s.resetTimer()
-	blockInterval := float64(s.GetRoundInterval()) / float64(chaincfg.ActiveNetParams.RoundSize) * 1000
+	blockInterval := float64(s.GetRoundInterval()) / float64(s.context.RoundSize) * 1000
 
 	// Create a new block using the available transactions
 	// in the memory pool as a source of transactions to potentially
 	// include in the block.
 	template, err := s.config.BlockTemplateGenerator.ProduceNewBlock(
-		s.config.Account, s.config.GasFloor, s.config.GasCeil,
-		time.Now().Unix(), uint32(round), uint16(slot), blockInterval)
+		s.config.Validator(uint32(round)), s.config.BlockSigner(), s.config.GasFloor, s.config.GasCeil,
+		clock.Now().Unix(), uint32(round), uint16(slot), blockInterval)
 	if err != nil {
 		log.Errorf("solo failed to create new block:%s", err)
 		return
@@ -119,18 +120,7 @@ func (s *SoloService) GetRoundInterval() int64 {
 //sync control of local slot:
 func (s *SoloService) slotControl() (int64, int64) {
 
-	duration := time.Now().Unix() - s.context.RoundStartTime
-	roundsizei64 := int64(chaincfg.ActiveNetParams.RoundSize)
-	slotCount := duration * roundsizei64 / s.context.RoundInterval
-
-	if slotCount < roundsizei64 {
-		s.context.Slot = slotCount
-	} else {
-		roundCount := slotCount / roundsizei64
-		s.context.Round += roundCount
-		s.context.Slot = slotCount % roundsizei64
-		s.context.RoundStartTime = s.context.RoundStartTime + s.context.RoundInterval * roundCount
-	}
+	s.setContext(clock.Now().Unix())
 	best := s.config.Chain.BestSnapshot()
 	if s.config.IsCurrent() != true {
 		log.Infof("waiting blocks")
@@ -141,32 +131,26 @@ func (s *SoloService) slotControl() (int64, int64) {
 	return s.context.Round, s.context.Slot
 }
 
-func (s *SoloService) initializeConsensus() {
+// setContext sets the round and the slot of the context at the given time,
+// following the consensus schedule of the network.
+func (s *SoloService) setContext(now int64) {
+	round, slot, roundStartTime := chaincfg.ActiveNetParams.RoundAt(now)
+	s.context.Round = int64(round)
+	s.context.Slot = int64(slot)
+	s.context.RoundStartTime = roundStartTime
+	s.context.RoundInterval = chaincfg.ActiveNetParams.RoundDurationAt(round)
+	s.context.RoundSize = int64(chaincfg.ActiveNetParams.RoundSizeAt(round))
+}
 
-	s.context.RoundInterval = common.DefaultBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize)
-	chainStartTime := chaincfg.ActiveNetParams.ChainStartTime
-
-	d := time.Now().Unix() - chainStartTime
-	roundSizei64 := int64(chaincfg.ActiveNetParams.RoundSize)
-	if d < common.DefaultBlockInterval {
-		s.context.Round = 0
-		s.context.Slot = roundSizei64 - 1
-		s.context.RoundStartTime = chainStartTime - (roundSizei64 - 1) * common.DefaultBlockInterval
-	} else {
-		// blockinterval = RoundInterval / RoundSize
-		// slotCount = d / blockinterval
-		slotCount := d * roundSizei64 / s.context.RoundInterval
-		s.context.Round = 1 + slotCount / roundSizei64
-		s.context.Slot = slotCount % roundSizei64
-		s.context.RoundStartTime = chainStartTime + common.DefaultBlockInterval + s.context.RoundInterval * (s.context.Round-1)
-	}
+func (s *SoloService) initializeConsensus() {
+	s.setContext(clock.Now().Unix())
 	log.Infof("Solo initializeConsensus round: %v, slot: %v, roundStartTime: %v",
 		s.context.Round, s.context.Slot, s.context.RoundStartTime)
 	s.resetTimer()
 }
 
 func (s *SoloService) resetTimer() {
-	d := time.Duration(int64(s.context.Slot+1) * s.context.RoundInterval) * time.Second / time.Duration(chaincfg.ActiveNetParams.RoundSize)
-	offset := time.Unix(s.context.RoundStartTime, 0).Add(d + time.Millisecond).Sub(time.Now())
+	d := time.Duration(int64(s.context.Slot+1) * s.context.RoundInterval) * time.Second / time.Duration(s.context.RoundSize)
+	offset := time.Unix(s.context.RoundStartTime, 0).Add(d + time.Millisecond).Sub(clock.Now())
 	s.timer.Reset(offset)
 }
@@ -9,7 +9,9 @@ import (
 	"github.com/AsimovNetwork/asimov/common/hexutil"
 )
 
-/* crypto object */
+// Account is a crypto object.  Its Address is the identity of the validator it produces and
+// signs blocks for.  It is the address of the key unless the key is a signing
+// key the validator delegated the signing of its blocks to.
 type Account struct {
 	PrivateKey PrivateKey
 	PublicKey  PublicKey
@@ -30,4 +32,11 @@ func NewAccount(privateKey string) (*Account, error) {
 	acc.Address, err = common.NewAddressWithId(common.PubKeyHashAddrID,
 		common.Hash160(acc.PublicKey.SerializeCompressed()))
 	return &acc, err
-}
\ No newline at end of file
+}
+
+// KeyAddress returns the address of the key of the account, which differs
+// from the account address when the key is a delegated signing key.
+func (acc *Account) KeyAddress() (*common.Address, error) {
+	return common.NewAddressWithId(common.PubKeyHashAddrID,
+		common.Hash160(acc.PublicKey.SerializeCompressed()))
+}
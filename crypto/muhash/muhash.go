@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package muhash implements a multiplicative rolling hash of a set.
+//
+// Each element is mapped to a 3072 bit number and the hash of the set is the
+// product of the numbers of its elements modulo the prime 2^3072 - 1103717.
+// Since the product does not depend on the order of the factors, elements
+// can be added and removed in any order and two sets holding the same
+// elements hash identically, however they were built.  Removals are
+// accumulated in a separate denominator so no modular inverse is computed
+// until the hash is finalized.
+package muhash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+const (
+	// ElementSize is the size in bytes of the number an element maps to.
+	ElementSize = 384
+
+	// SerializedSize is the size in bytes of a serialized MuHash.
+	SerializedSize = 2 * ElementSize
+)
+
+// prime is the modulus of the group, 2^3072 - 1103717.
+var prime = func() *big.Int {
+	p := new(big.Int).Lsh(big.NewInt(1), ElementSize*8)
+	return p.Sub(p, big.NewInt(1103717))
+}()
+
+// MuHash is a rolling hash of a set of byte strings.  The zero value is not
+// usable, use New.
+type MuHash struct {
+	numerator   *big.Int
+	denominator *big.Int
+}
+
+// New returns the hash of the empty set.
+func New() *MuHash {
+	return &MuHash{
+		numerator:   big.NewInt(1),
+		denominator: big.NewInt(1),
+	}
+}
+
+// element maps data to a number of the group.  The sha256 of the data is
+// expanded to ElementSize bytes in counter mode and read as a little endian
+// number.
+func element(data []byte) *big.Int {
+	seed := sha256.Sum256(data)
+	var buf [ElementSize]byte
+	var block [common.HashLength + 4]byte
+	copy(block[:], seed[:])
+	for i := 0; i < ElementSize/sha256.Size; i++ {
+		binary.LittleEndian.PutUint32(block[common.HashLength:], uint32(i))
+		sum := sha256.Sum256(block[:])
+		copy(buf[i*sha256.Size:], sum[:])
+	}
+	return leToInt(buf[:])
+}
+
+// leToInt reads a little endian number, reduced modulo the prime.
+func leToInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	n := new(big.Int).SetBytes(be)
+	return n.Mod(n, prime)
+}
+
+// putIntLE writes n as a little endian number of ElementSize bytes.
+func putIntLE(dst []byte, n *big.Int) {
+	be := n.Bytes()
+	for i := range dst[:ElementSize] {
+		dst[i] = 0
+	}
+	for i := range be {
+		dst[len(be)-1-i] = be[i]
+	}
+}
+
+// Add adds data to the set.
+func (h *MuHash) Add(data []byte) {
+	h.numerator.Mul(h.numerator, element(data))
+	h.numerator.Mod(h.numerator, prime)
+}
+
+// Remove removes data from the set.  Data which is not in the set must not
+// be removed.
+func (h *MuHash) Remove(data []byte) {
+	h.denominator.Mul(h.denominator, element(data))
+	h.denominator.Mod(h.denominator, prime)
+}
+
+// Combine adds the elements of another set to the set.
+func (h *MuHash) Combine(other *MuHash) {
+	h.numerator.Mul(h.numerator, other.numerator)
+	h.numerator.Mod(h.numerator, prime)
+	h.denominator.Mul(h.denominator, other.denominator)
+	h.denominator.Mod(h.denominator, prime)
+}
+
+// normalize folds the denominator into the numerator.
+func (h *MuHash) normalize() {
+	if h.denominator.Cmp(big.NewInt(1)) == 0 {
+		return
+	}
+	inverse := new(big.Int).ModInverse(h.denominator, prime)
+	h.numerator.Mul(h.numerator, inverse)
+	h.numerator.Mod(h.numerator, prime)
+	h.denominator.SetInt64(1)
+}
+
+// Finalize returns the hash of the set, the sha256 of the little endian
+// serialization of the product of its elements.
+func (h *MuHash) Finalize() common.Hash {
+	h.normalize()
+	var buf [ElementSize]byte
+	putIntLE(buf[:], h.numerator)
+	return common.Hash(sha256.Sum256(buf[:]))
+}
+
+// Serialize returns the serialized state of the hash, which can be restored
+// with Deserialize to keep updating it.
+func (h *MuHash) Serialize() []byte {
+	serialized := make([]byte, SerializedSize)
+	putIntLE(serialized, h.numerator)
+	putIntLE(serialized[ElementSize:], h.denominator)
+	return serialized
+}
+
+// Deserialize restores a hash serialized with Serialize.
+func Deserialize(serialized []byte) (*MuHash, error) {
+	if len(serialized) != SerializedSize {
+		return nil, errors.New("malformed serialized muhash")
+	}
+	h := &MuHash{
+		numerator:   leToInt(serialized[:ElementSize]),
+		denominator: leToInt(serialized[ElementSize:]),
+	}
+	if h.numerator.Sign() == 0 || h.denominator.Sign() == 0 {
+		return nil, errors.New("malformed serialized muhash")
+	}
+	return h, nil
+}
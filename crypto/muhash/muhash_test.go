@@ -0,0 +1,74 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package muhash
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestMuHash ensures the hash of a set does not depend on the order of the
+// updates and survives a serialization round trip.
+func TestMuHash(t *testing.T) {
+	elements := make([][]byte, 8)
+	for i := range elements {
+		elements[i] = []byte(fmt.Sprintf("element %d", i))
+	}
+
+	empty := New().Finalize()
+
+	forward := New()
+	for _, e := range elements {
+		forward.Add(e)
+	}
+	backward := New()
+	for i := len(elements) - 1; i >= 0; i-- {
+		backward.Add(elements[i])
+	}
+	if forward.Finalize() != backward.Finalize() {
+		t.Fatalf("hash depends on the order of the elements")
+	}
+	if forward.Finalize() == empty {
+		t.Fatalf("hash of a set equals the hash of the empty set")
+	}
+
+	// Removing elements, including before they are added, gives the hash
+	// of the remaining set.
+	partial := New()
+	partial.Remove(elements[0])
+	for _, e := range elements {
+		partial.Add(e)
+	}
+	partial.Remove(elements[1])
+	want := New()
+	for _, e := range elements[2:] {
+		want.Add(e)
+	}
+	if partial.Finalize() != want.Finalize() {
+		t.Fatalf("unexpected hash after removals")
+	}
+
+	restored, err := Deserialize(partial.Serialize())
+	if err != nil {
+		t.Fatalf("Deserialize: unexpected error: %v", err)
+	}
+	restored.Add(elements[1])
+	combined := New()
+	combined.Combine(want)
+	combined.Add(elements[1])
+	if restored.Finalize() != combined.Finalize() {
+		t.Fatalf("unexpected hash after a serialization round trip")
+	}
+
+	for _, e := range elements {
+		forward.Remove(e)
+	}
+	if forward.Finalize() != empty {
+		t.Fatalf("removing every element does not give the empty set hash")
+	}
+	if _, err := Deserialize(make([]byte, SerializedSize)); err == nil {
+		t.Fatalf("Deserialize: zero state was accepted")
+	}
+}
@@ -74,7 +74,8 @@ Application Options:
       --testnet             Use the test network
       --regtest             Use the regression test network
       --simnet              Use the simulation test network
-      --addcheckpoint=      Add a custom checkpoint.  Format: '<height>:<hash>'
+      --addcheckpoint=      Add a custom checkpoint.  Format:
+                            '<height>:<hash>[:<utxo set hash>[:<snapshot hash>]]'
       --nocheckpoints       Disable built-in checkpoints.  Don't do this unless
                             you know what you're doing.
       --uacomment=          Comment to add to the user agent --
@@ -0,0 +1,401 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+	"github.com/AsimovNetwork/asimov/mining"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+const (
+	// estimateFeeDepth is the maximum number of blocks a transaction is
+	// tracked for before it is deemed not mined at any target.  It is also
+	// the highest target a gas price is estimated for.
+	estimateFeeDepth = 25
+
+	// estimateFeeSamples is the number of the most recently mined
+	// transactions of an asset its estimates are based on.
+	estimateFeeSamples = 2000
+
+	// estimateFeeMinSamples is the minimum number of transactions paying
+	// at least a gas price for it to be estimated.
+	estimateFeeMinSamples = 10
+
+	// estimateFeeSuccess is the fraction of the transactions paying at
+	// least the estimated gas price which must have been mined within the
+	// target.
+	estimateFeeSuccess = 0.85
+
+	// estimateFeeVersion is the version of the serialized state of the fee
+	// estimator.
+	estimateFeeVersion = 1
+
+	// DefaultEstimateFeeMinRegisteredBlocks is the default minimum number
+	// of blocks which must be registered before gas prices are estimated.
+	DefaultEstimateFeeMinRegisteredBlocks = 3
+
+	// EstimateFeeDatabaseKey is the key the state of the fee estimator is
+	// stored under in the database while the node is stopped.
+	EstimateFeeDatabaseKey = "estimatefee"
+)
+
+// notMined is the number of blocks recorded for a transaction which was not
+// mined within estimateFeeDepth blocks.
+const notMined = estimateFeeDepth + 1
+
+// observedTransaction is a transaction of the pool the fee estimator waits
+// for to be mined.
+type observedTransaction struct {
+	// asset is the asset the transaction pays its fee in.
+	asset protos.Asset
+
+	// price is the gas price the transaction pays in the asset.
+	price float64
+
+	// observed is the height of the chain when the transaction entered
+	// the pool.
+	observed int32
+}
+
+// feeSample is the gas price a transaction paid and the number of blocks it
+// waited in the pool before being mined.
+type feeSample struct {
+	price  float64
+	blocks uint32
+}
+
+// FeeEstimator tracks how many blocks the transactions of the pool wait before
+// being mined, for each asset the fees are paid in, and estimates from them
+// the gas price a transaction should pay to be mined within a number of
+// blocks.  Only the transactions paying their fee in a single asset are
+// tracked, since the price of a fee split among assets is not meaningful in
+// any of them.
+type FeeEstimator struct {
+	mtx sync.RWMutex
+
+	// minRegisteredBlocks is the number of blocks which must be registered
+	// before gas prices are estimated.
+	minRegisteredBlocks uint32
+
+	numBlocksRegistered uint32
+	lastKnownHeight     int32
+
+	observed map[common.Hash]*observedTransaction
+	samples  map[protos.Asset][]feeSample
+}
+
+// NewFeeEstimator creates a fee estimator which estimates gas prices once the
+// given number of blocks are registered.
+func NewFeeEstimator(minRegisteredBlocks uint32) *FeeEstimator {
+	return &FeeEstimator{
+		minRegisteredBlocks: minRegisteredBlocks,
+		lastKnownHeight:     mining.UnminedHeight,
+		observed:            make(map[common.Hash]*observedTransaction),
+		samples:             make(map[protos.Asset][]feeSample),
+	}
+}
+
+// feeAsset returns the asset the transaction pays its fee in and its gas price
+// in that asset, and false when the fee is paid in several assets or none.
+func feeAsset(t *mining.TxDesc) (protos.Asset, float64, bool) {
+	if t.FeeList == nil || len(*t.FeeList) != 1 {
+		return protos.Asset{}, 0, false
+	}
+	gasLimit := t.Tx.MsgTx().TxContract.GasLimit
+	if gasLimit == 0 {
+		return protos.Asset{}, 0, false
+	}
+	for asset, fee := range *t.FeeList {
+		return asset, float64(fee) / float64(gasLimit), true
+	}
+	return protos.Asset{}, 0, false
+}
+
+// ObserveTransaction starts tracking a transaction which entered the pool.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) ObserveTransaction(t *mining.TxDesc) {
+	asset, price, ok := feeAsset(t)
+	if !ok {
+		return
+	}
+
+	ef.mtx.Lock()
+	if _, exists := ef.observed[*t.Tx.Hash()]; !exists {
+		ef.observed[*t.Tx.Hash()] = &observedTransaction{
+			asset:    asset,
+			price:    price,
+			observed: t.Height,
+		}
+	}
+	ef.mtx.Unlock()
+}
+
+// addSample records a transaction of the asset, dropping the oldest one when
+// the asset has too many.
+//
+// This function MUST be called with the lock held (for writes).
+func (ef *FeeEstimator) addSample(asset protos.Asset, sample feeSample) {
+	samples := append(ef.samples[asset], sample)
+	if len(samples) > estimateFeeSamples {
+		samples = samples[len(samples)-estimateFeeSamples:]
+	}
+	ef.samples[asset] = samples
+}
+
+// RegisterBlock records how many blocks the observed transactions mined in the
+// block waited, and deems the ones waiting for longer than the tracked depth
+// not mined.  The blocks of a reorganization are registered like any other,
+// the transactions of the disconnected blocks are observed again when they
+// reenter the pool.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) RegisterBlock(block *asiutil.Block) {
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
+
+	height := block.Height()
+	ef.lastKnownHeight = height
+	ef.numBlocksRegistered++
+
+	for _, tx := range block.Transactions() {
+		o, exists := ef.observed[*tx.Hash()]
+		if !exists {
+			continue
+		}
+		delete(ef.observed, *tx.Hash())
+
+		blocks := height - o.observed
+		if blocks < 1 {
+			blocks = 1
+		}
+		if blocks > estimateFeeDepth {
+			blocks = notMined
+		}
+		ef.addSample(o.asset, feeSample{price: o.price, blocks: uint32(blocks)})
+	}
+
+	for hash, o := range ef.observed {
+		if height-o.observed > estimateFeeDepth {
+			delete(ef.observed, hash)
+			ef.addSample(o.asset, feeSample{price: o.price, blocks: notMined})
+		}
+	}
+}
+
+// LastKnownHeight returns the height of the last block registered.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) LastKnownHeight() int32 {
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
+
+	return ef.lastKnownHeight
+}
+
+// EstimateGasPrice returns the lowest gas price in the asset such that most of
+// the recent transactions paying at least as much were mined within the
+// target number of blocks.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) EstimateGasPrice(targetBlocks uint32, asset protos.Asset) (float64, error) {
+	if targetBlocks == 0 || targetBlocks > estimateFeeDepth {
+		return 0, fmt.Errorf("target of %d blocks is out of range [1, %d]",
+			targetBlocks, estimateFeeDepth)
+	}
+
+	ef.mtx.RLock()
+	if ef.numBlocksRegistered < ef.minRegisteredBlocks {
+		ef.mtx.RUnlock()
+		return 0, errors.New("not enough blocks have been observed")
+	}
+	samples := make([]feeSample, len(ef.samples[asset]))
+	copy(samples, ef.samples[asset])
+	ef.mtx.RUnlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].price > samples[j].price
+	})
+
+	// Lower the price as long as enough of the transactions paying at
+	// least as much were mined in time.
+	estimate := -1.0
+	var total, mined int
+	for i, sample := range samples {
+		total++
+		if sample.blocks <= targetBlocks {
+			mined++
+		}
+
+		// A price is only settled once every sample paying it is counted.
+		if i+1 < len(samples) && samples[i+1].price == sample.price {
+			continue
+		}
+		if total < estimateFeeMinSamples {
+			continue
+		}
+		if float64(mined) < estimateFeeSuccess*float64(total) {
+			break
+		}
+		estimate = sample.price
+	}
+	if estimate < 0 {
+		return 0, fmt.Errorf("not enough transactions paying fees in "+
+			"asset %v were mined to estimate a gas price", asset.String())
+	}
+
+	return estimate, nil
+}
+
+// writeAsset serializes an asset.
+func writeAsset(w io.Writer, asset *protos.Asset) error {
+	if err := serialization.WriteUint32(w, asset.Property); err != nil {
+		return err
+	}
+	return serialization.WriteUint64(w, asset.Id)
+}
+
+// readAsset deserializes an asset.
+func readAsset(r io.Reader, asset *protos.Asset) error {
+	if err := serialization.ReadUint32(r, &asset.Property); err != nil {
+		return err
+	}
+	return serialization.ReadUint64(r, &asset.Id)
+}
+
+// Save serializes the state of the fee estimator, for it to be restored with
+// RestoreFeeEstimator after a restart.
+//
+// This function is safe for concurrent access.
+func (ef *FeeEstimator) Save() []byte {
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
+
+	// Writes to a buffer only fail when out of memory, which panics.
+	var w bytes.Buffer
+	serialization.WriteUint32(&w, estimateFeeVersion)
+	serialization.WriteUint32(&w, ef.minRegisteredBlocks)
+	serialization.WriteUint32(&w, ef.numBlocksRegistered)
+	serialization.WriteUint32(&w, uint32(ef.lastKnownHeight))
+
+	serialization.WriteVarInt(&w, 0, uint64(len(ef.observed)))
+	for hash, o := range ef.observed {
+		w.Write(hash[:])
+		writeAsset(&w, &o.asset)
+		serialization.WriteUint64(&w, math.Float64bits(o.price))
+		serialization.WriteUint32(&w, uint32(o.observed))
+	}
+
+	serialization.WriteVarInt(&w, 0, uint64(len(ef.samples)))
+	for asset, samples := range ef.samples {
+		writeAsset(&w, &asset)
+		serialization.WriteVarInt(&w, 0, uint64(len(samples)))
+		for _, sample := range samples {
+			serialization.WriteUint64(&w, math.Float64bits(sample.price))
+			serialization.WriteUint32(&w, sample.blocks)
+		}
+	}
+
+	return w.Bytes()
+}
+
+// RestoreFeeEstimator creates a fee estimator from the state Save serialized.
+func RestoreFeeEstimator(data []byte) (*FeeEstimator, error) {
+	r := bytes.NewReader(data)
+
+	var version uint32
+	if err := serialization.ReadUint32(r, &version); err != nil {
+		return nil, err
+	}
+	if version != estimateFeeVersion {
+		return nil, fmt.Errorf("unsupported fee estimator version %d", version)
+	}
+
+	ef := NewFeeEstimator(0)
+	var lastKnownHeight uint32
+	err := serialization.ReadUint32(r, &ef.minRegisteredBlocks)
+	if err == nil {
+		err = serialization.ReadUint32(r, &ef.numBlocksRegistered)
+	}
+	if err == nil {
+		err = serialization.ReadUint32(r, &lastKnownHeight)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ef.lastKnownHeight = int32(lastKnownHeight)
+
+	numObserved, err := serialization.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numObserved; i++ {
+		var hash common.Hash
+		var o observedTransaction
+		var price uint64
+		var observed uint32
+		_, err := io.ReadFull(r, hash[:])
+		if err == nil {
+			err = readAsset(r, &o.asset)
+		}
+		if err == nil {
+			err = serialization.ReadUint64(r, &price)
+		}
+		if err == nil {
+			err = serialization.ReadUint32(r, &observed)
+		}
+		if err != nil {
+			return nil, err
+		}
+		o.price = math.Float64frombits(price)
+		o.observed = int32(observed)
+		ef.observed[hash] = &o
+	}
+
+	numAssets, err := serialization.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numAssets; i++ {
+		var asset protos.Asset
+		if err := readAsset(r, &asset); err != nil {
+			return nil, err
+		}
+		numSamples, err := serialization.ReadVarInt(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		if numSamples > estimateFeeSamples {
+			return nil, fmt.Errorf("too many fee samples for asset %v: %d",
+				asset.String(), numSamples)
+		}
+		samples := make([]feeSample, numSamples)
+		for j := range samples {
+			var price uint64
+			err := serialization.ReadUint64(r, &price)
+			if err == nil {
+				err = serialization.ReadUint32(r, &samples[j].blocks)
+			}
+			if err != nil {
+				return nil, err
+			}
+			samples[j].price = math.Float64frombits(price)
+		}
+		ef.samples[asset] = samples
+	}
+
+	return ef, nil
+}
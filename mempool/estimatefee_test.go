@@ -0,0 +1,126 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/mining"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// estimateFeeTester creates the transactions and the blocks mining them for
+// the fee estimator tests.
+type estimateFeeTester struct {
+	t      *testing.T
+	ef     *FeeEstimator
+	nonce  uint32
+	height int32
+}
+
+// newTx creates a transaction observed at the current height, paying the
+// given fee in each of the assets for a gas limit of 1000.
+func (eft *estimateFeeTester) newTx(fees map[protos.Asset]int64) *asiutil.Tx {
+	eft.nonce++
+	msgTx := protos.NewMsgTx(protos.TxVersion)
+	msgTx.LockTime = eft.nonce
+	msgTx.TxContract.GasLimit = 1000
+	tx := asiutil.NewTx(msgTx)
+	eft.ef.ObserveTransaction(&mining.TxDesc{
+		Tx:      tx,
+		Height:  eft.height,
+		FeeList: &fees,
+	})
+	return tx
+}
+
+// mineBlock registers the next block with the transactions.
+func (eft *estimateFeeTester) mineBlock(txs ...*asiutil.Tx) {
+	eft.height++
+	msgBlock := &protos.MsgBlock{}
+	msgBlock.Header.Height = eft.height
+	for _, tx := range txs {
+		msgBlock.AddTransaction(tx.MsgTx())
+	}
+	eft.ef.RegisterBlock(asiutil.NewBlock(msgBlock))
+}
+
+// checkEstimate ensures the estimate of the asset for the target is the given
+// gas price, or fails when the price is negative.
+func (eft *estimateFeeTester) checkEstimate(target uint32, asset protos.Asset, want float64) {
+	eft.t.Helper()
+	price, err := eft.ef.EstimateGasPrice(target, asset)
+	if want < 0 {
+		if err == nil {
+			eft.t.Errorf("EstimateGasPrice(%d, %v): got %f, want an error",
+				target, asset.String(), price)
+		}
+		return
+	}
+	if err != nil || price != want {
+		eft.t.Errorf("EstimateGasPrice(%d, %v): got %f, %v, want %f",
+			target, asset.String(), price, err, want)
+	}
+}
+
+// TestEstimateGasPrice ensures the gas prices are estimated for each asset
+// from the blocks the transactions waited, and that the state of the fee
+// estimator survives a restart.
+func TestEstimateGasPrice(t *testing.T) {
+	asset := asiutil.AsimovAsset
+	other := *protos.NewAsset(0, 1, 1)
+	eft := &estimateFeeTester{t: t, ef: NewFeeEstimator(3)}
+
+	// Transactions paying 10 per gas are mined in the next block, the ones
+	// paying 1 per gas wait for five blocks.
+	var fast, slow []*asiutil.Tx
+	for i := 0; i < 10; i++ {
+		fast = append(fast, eft.newTx(map[protos.Asset]int64{asset: 10000}))
+		slow = append(slow, eft.newTx(map[protos.Asset]int64{asset: 1000}))
+	}
+	eft.newTx(map[protos.Asset]int64{asset: 100000, other: 100000})
+	if len(eft.ef.observed) != 20 {
+		t.Fatalf("ObserveTransaction: got %d transactions, want the 20 "+
+			"paying a single asset", len(eft.ef.observed))
+	}
+
+	eft.mineBlock(fast...)
+	eft.checkEstimate(1, asset, -1)
+	eft.mineBlock()
+	eft.mineBlock()
+	eft.mineBlock()
+	eft.mineBlock(slow...)
+
+	eft.checkEstimate(0, asset, -1)
+	eft.checkEstimate(estimateFeeDepth+1, asset, -1)
+	eft.checkEstimate(1, asset, 10)
+	eft.checkEstimate(4, asset, 10)
+	eft.checkEstimate(5, asset, 1)
+	eft.checkEstimate(5, other, -1)
+
+	// A transaction which is never mined fails at every target.
+	eft.newTx(map[protos.Asset]int64{asset: 20000})
+	for i := 0; i <= estimateFeeDepth; i++ {
+		eft.mineBlock()
+	}
+	if len(eft.ef.observed) != 0 {
+		t.Fatalf("RegisterBlock: got %d observed transactions, want none",
+			len(eft.ef.observed))
+	}
+	eft.checkEstimate(1, asset, 10)
+
+	restored, err := RestoreFeeEstimator(eft.ef.Save())
+	if err != nil {
+		t.Fatalf("RestoreFeeEstimator error %v", err)
+	}
+	if restored.LastKnownHeight() != eft.height {
+		t.Errorf("LastKnownHeight: got %d, want %d",
+			restored.LastKnownHeight(), eft.height)
+	}
+	eft.ef = restored
+	eft.checkEstimate(1, asset, 10)
+	eft.checkEstimate(5, asset, 1)
+}
@@ -6,11 +6,13 @@
 package mempool
 
 import (
+	"container/heap"
 	"container/list"
 	"fmt"
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"math"
 	"sync"
 	"time"
 
@@ -32,6 +34,14 @@ const (
 	// scans of the orphan pool to evict expired transactions.
 	orphanExpireScanInterval = time.Minute * 5
 
+	// txExpireScanInterval is the minimum amount of time in between scans
+	// of the main pool to evict expired transactions.
+	txExpireScanInterval = time.Minute * 10
+
+	// minPriceHalfLife is the amount of time it takes the minimum gas
+	// price, raised by the evictions of a full pool, to decay by half.
+	minPriceHalfLife = time.Hour * 12
+
 	// forbiddenTxLimit is the maximum count of forbidden transactions in cache.
 	forbiddenTxLimit = 1000
 
@@ -39,6 +49,14 @@ const (
 	// can be evicted from the mempool when accepting a transaction
 	// replacement.
 	MaxReplacementEvictions = 100
+
+	// MaxPackageTxs is the maximum number of transactions of a package
+	// accepted into the mempool at once.
+	MaxPackageTxs = 25
+
+	// maxLowPriceTxs is the maximum number of transactions rejected for
+	// their gas price kept for the children paying for them.
+	maxLowPriceTxs = 100
 )
 
 // Tag represents an identifier to use for tagging orphan transactions.  The
@@ -81,6 +99,20 @@ type Config struct {
 
 	CheckTransactionInputs func(tx *asiutil.Tx, txHeight int32, utxoView *txo.UtxoViewpoint,
 		b *blockchain.BlockChain) (int64, *map[protos.Asset]int64, error)
+
+	// FeeEstimator provides a feeEstimator. If it is not nil, the mempool
+	// records all new transactions it observes into the feeEstimator.
+	FeeEstimator *FeeEstimator
+
+	// DumpFile is the path of the file the transactions of the pool are
+	// saved to when it halts, and accepted again from when it starts.
+	// Persistence is disabled when it is empty.
+	DumpFile string
+
+	// PolicyHooks are the admission rules of the operator checked on every
+	// transaction, after the standard rules, before it is accepted into
+	// the main pool.
+	PolicyHooks []PolicyHook
 }
 
 // Policy houses the policy (configuration parameters) which is used to
@@ -108,6 +140,15 @@ type Policy struct {
 	// transactions using the Replace-By-Price (RBP) signaling policy into
 	// the mempool.
 	RejectReplacement bool
+
+	// MaxPoolSize is the maximum total size in bytes of the transactions
+	// in the main pool.  The transactions paying the lowest gas price are
+	// evicted once it is exceeded.  Zero means no limit.
+	MaxPoolSize int64
+
+	// MaxTxAge is the maximum amount of time a transaction is allowed to
+	// stay in the main pool before it expires.  Zero disables expiry.
+	MaxTxAge time.Duration
 }
 
 // orphanTx is normal transaction that references an ancestor transaction
@@ -143,7 +184,35 @@ type TxPool struct {
 	// to on an unconditional timer.
 	nextExpireScan time.Time
 
+	// nextTxExpireScan is the time after which the main pool will be
+	// scanned in order to evict transactions older than the maximum age.
+	nextTxExpireScan time.Time
+
+	// poolSize is the total serialized size of the transactions in the
+	// main pool.
+	poolSize int64
+
+	// minPrice is the gas price a transaction must pay to be accepted once
+	// the main pool had to evict transactions.  It is raised above the
+	// price of the evicted transactions and decays back to the relay price
+	// of the policy over time.
+	minPrice           float64
+	lastMinPriceUpdate time.Time
+
+	// packages tracks the fee and gas limit of each transaction of the main
+	// pool along with its descendants, and evictions orders them by the
+	// price they are evicted by.  Both are kept up to date as transactions
+	// enter and leave the pool, so evicting one is a heap pop.
+	packages  map[common.Hash]*evictionItem
+	evictions evictionQueue
+
 	fees map[protos.Asset]int32
+
+	// lowPriceTxs are transactions recently rejected only for paying a gas
+	// price too low.  A peer relays the parents of a package on their own,
+	// so they are kept until a child paying for them is accepted along with
+	// them as a package.
+	lowPriceTxs map[common.Hash]*asiutil.Tx
 }
 
 // Ensure the TxPool type implements the mining.TxSource interface.
@@ -452,11 +521,17 @@ func (mp *TxPool) removeTransaction(tx *asiutil.Tx, removeRedeemers bool) {
 			mp.cfg.AddrIndex.RemoveUnconfirmedTx(txHash)
 		}
 
+		// The ancestors are looked up while the transaction still
+		// spends them.
+		ancestors := mp.txAncestors(tx, nil)
+
 		// Mark the referenced outpoints as unspent by the pool.
 		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
 		delete(mp.pool, *txHash)
+		mp.poolSize -= int64(tx.MsgTx().SerializeSize())
+		mp.removePackage(txDesc, ancestors)
 	}
 }
 
@@ -524,9 +599,11 @@ func (mp *TxPool) addTransaction(utxoView *txo.UtxoViewpoint, tx *asiutil.Tx, he
 	}
 
 	mp.pool[*tx.Hash()] = txD
+	mp.poolSize += int64(tx.MsgTx().SerializeSize())
 	for _, txIn := range tx.MsgTx().TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+	mp.addPackage(txD)
 
 	// Add unconfirmed address index entries associated with the transaction
 	// if enabled.
@@ -534,9 +611,279 @@ func (mp *TxPool) addTransaction(utxoView *txo.UtxoViewpoint, tx *asiutil.Tx, he
 		mp.cfg.AddrIndex.AddUnconfirmedTx(tx, utxoView)
 	}
 
+	// Record this tx for fee estimation if enabled.
+	if mp.cfg.FeeEstimator != nil {
+		mp.cfg.FeeEstimator.ObserveTransaction(txD)
+	}
+
 	return txD
 }
 
+// minGasPrice returns the gas price a transaction must pay to be accepted into
+// the main pool.  It is the relay price of the policy, unless evictions of the
+// full pool raised it higher.  The raised price decays by half every
+// minPriceHalfLife, or faster when the pool has room again, and falls back to
+// the relay price once below it.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) minGasPrice() float64 {
+	relayPrice := mp.cfg.Policy.MinRelayTxPrice
+	if mp.minPrice == 0 {
+		return relayPrice
+	}
+
+	halfLife := minPriceHalfLife
+	if mp.poolSize < mp.cfg.Policy.MaxPoolSize/4 {
+		halfLife /= 4
+	} else if mp.poolSize < mp.cfg.Policy.MaxPoolSize/2 {
+		halfLife /= 2
+	}
+	now := time.Now()
+	elapsed := now.Sub(mp.lastMinPriceUpdate)
+	mp.minPrice *= math.Pow(0.5, float64(elapsed)/float64(halfLife))
+	mp.lastMinPriceUpdate = now
+
+	if mp.minPrice < relayPrice {
+		mp.minPrice = 0
+		return relayPrice
+	}
+	return mp.minPrice
+}
+
+// MinGasPrice returns the gas price a transaction must currently pay to be
+// accepted into the main pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MinGasPrice() float64 {
+	mp.mtx.Lock()
+	price := mp.minGasPrice()
+	mp.mtx.Unlock()
+
+	return price
+}
+
+// evictionItem is a transaction of the main pool with the fee and gas limit
+// of the package it forms with its descendants, and the price it is evicted
+// by.
+type evictionItem struct {
+	tx       *asiutil.Tx
+	gasPrice float64
+	fee      int64
+	gasLimit uint64
+	price    float64
+	index    int
+}
+
+// updatePrice sets the price of the item to the greater of the gas price of
+// the transaction and the gas price of its package.
+func (item *evictionItem) updatePrice() {
+	item.price = item.gasPrice
+	if item.gasLimit > 0 {
+		if packagePrice := float64(item.fee) / float64(item.gasLimit); packagePrice > item.price {
+			item.price = packagePrice
+		}
+	}
+}
+
+// evictionQueue implements a heap of evictionItem elements, the cheapest
+// first.
+type evictionQueue []*evictionItem
+
+func (q evictionQueue) Len() int           { return len(q) }
+func (q evictionQueue) Less(i, j int) bool { return q[i].price < q[j].price }
+
+func (q evictionQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *evictionQueue) Push(x interface{}) {
+	item := x.(*evictionItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *evictionQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
+
+// hasRedeemers returns whether any output of the transaction is spent by a
+// transaction of the main pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) hasRedeemers(tx *asiutil.Tx) bool {
+	op := protos.OutPoint{Hash: *tx.Hash()}
+	for i := range tx.MsgTx().TxOut {
+		op.Index = uint32(i)
+		if _, ok := mp.outpoints[op]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshPackage recomputes the fee and gas limit of the package of the
+// transaction from its descendants in the main pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) refreshPackage(hash common.Hash,
+	cache map[common.Hash]map[common.Hash]*asiutil.Tx) {
+
+	item := mp.packages[hash]
+	txDesc := mp.pool[hash]
+	item.fee = txDesc.Fee
+	item.gasLimit = uint64(txDesc.Tx.MsgTx().TxContract.GasLimit)
+	for descHash := range mp.txDescendants(txDesc.Tx, cache) {
+		descendant := mp.pool[descHash]
+		item.fee += descendant.Fee
+		item.gasLimit += uint64(descendant.Tx.MsgTx().TxContract.GasLimit)
+	}
+	item.updatePrice()
+	heap.Fix(&mp.evictions, item.index)
+}
+
+// addPackage queues the transaction just added to the main pool for eviction
+// and accounts for it in the packages of its ancestors.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) addPackage(txD *mining.TxDesc) {
+	hash := *txD.Tx.Hash()
+	gasLimit := uint64(txD.Tx.MsgTx().TxContract.GasLimit)
+	item := &evictionItem{tx: txD.Tx, gasPrice: txD.GasPrice, fee: txD.Fee,
+		gasLimit: gasLimit}
+	item.updatePrice()
+	mp.packages[hash] = item
+	heap.Push(&mp.evictions, item)
+
+	ancestors := mp.txAncestors(txD.Tx, nil)
+	if !mp.hasRedeemers(txD.Tx) {
+		for ancestorHash := range ancestors {
+			ancestor := mp.packages[ancestorHash]
+			ancestor.fee += txD.Fee
+			ancestor.gasLimit += gasLimit
+			ancestor.updatePrice()
+			heap.Fix(&mp.evictions, ancestor.index)
+		}
+		return
+	}
+
+	// A transaction mined in a disconnected block comes back after the
+	// transactions spending it, so its descendants are new to its
+	// ancestors as well.
+	cache := make(map[common.Hash]map[common.Hash]*asiutil.Tx)
+	mp.refreshPackage(hash, cache)
+	for ancestorHash := range ancestors {
+		mp.refreshPackage(ancestorHash, cache)
+	}
+}
+
+// removePackage drops the transaction just removed from the main pool from
+// the eviction queue and from the packages of the ancestors it had.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) removePackage(txDesc *mining.TxDesc,
+	ancestors map[common.Hash]*asiutil.Tx) {
+
+	tx := txDesc.Tx
+	hash := *tx.Hash()
+	item := mp.packages[hash]
+	heap.Remove(&mp.evictions, item.index)
+	delete(mp.packages, hash)
+
+	// A transaction mined in a connected block leaves its descendants in
+	// the pool, they are no longer descendants of its ancestors.
+	if mp.hasRedeemers(tx) {
+		cache := make(map[common.Hash]map[common.Hash]*asiutil.Tx)
+		for ancestorHash := range ancestors {
+			mp.refreshPackage(ancestorHash, cache)
+		}
+		return
+	}
+
+	gasLimit := uint64(tx.MsgTx().TxContract.GasLimit)
+	for ancestorHash := range ancestors {
+		ancestor := mp.packages[ancestorHash]
+		ancestor.fee -= txDesc.Fee
+		ancestor.gasLimit -= gasLimit
+		ancestor.updatePrice()
+		heap.Fix(&mp.evictions, ancestor.index)
+	}
+}
+
+// limitPoolSize evicts the transactions paying the lowest gas price, along with
+// the transactions spending them, until the main pool fits in the maximum size
+// of the policy.  The price of a transaction is the greater of its own gas
+// price and the gas price of the package it forms with its descendants, so a
+// parent is kept as long as its children pay enough for it.  The minimum gas
+// price is raised above the price of every evicted package.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) limitPoolSize() {
+	maxSize := mp.cfg.Policy.MaxPoolSize
+	if maxSize <= 0 || mp.poolSize <= maxSize {
+		return
+	}
+
+	// Decay the minimum price up to now before raising it.
+	minPrice := mp.minGasPrice()
+	origNumTxs := len(mp.pool)
+	for mp.evictions.Len() > 0 && mp.poolSize > maxSize {
+		// Removing the cheapest transaction removes its descendants
+		// along with it, and updates the packages of its ancestors.
+		ptx := mp.evictions[0]
+		price := ptx.price
+		mp.removeTransaction(ptx.tx, true)
+
+		if price += mp.cfg.Policy.MinRelayTxPrice; price > minPrice {
+			minPrice = price
+		}
+	}
+	mp.minPrice = minPrice
+	mp.lastMinPriceUpdate = time.Now()
+
+	numEvicted := origNumTxs - len(mp.pool)
+	log.Debugf("Evicted %d %s to limit the pool size (size: %d bytes, "+
+		"minimum gas price: %f)", numEvicted,
+		pickNoun(numEvicted, "transaction", "transactions"),
+		mp.poolSize, minPrice)
+}
+
+// expireTransactions removes the transactions which stayed in the main pool
+// longer than the maximum age of the policy, along with the transactions
+// spending them.  The pool is only scanned once per scan interval.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) expireTransactions() {
+	maxAge := mp.cfg.Policy.MaxTxAge
+	now := time.Now()
+	if maxAge <= 0 || now.Before(mp.nextTxExpireScan) {
+		return
+	}
+
+	origNumTxs := len(mp.pool)
+	for _, txDesc := range mp.pool {
+		if now.Sub(txDesc.Added) > maxAge {
+			mp.removeTransaction(txDesc.Tx, true)
+		}
+	}
+
+	// Set next expiration scan to occur after the scan interval.
+	mp.nextTxExpireScan = now.Add(txExpireScanInterval)
+
+	numTxs := len(mp.pool)
+	if numExpired := origNumTxs - numTxs; numExpired > 0 {
+		log.Debugf("Expired %d %s (remaining: %d)", numExpired,
+			pickNoun(numExpired, "transaction", "transactions"),
+			numTxs)
+	}
+}
+
 // checkPoolDoubleSpend checks whether or not the passed transaction is
 // attempting to spend coins already spent by other transactions in the pool.
 // Note it does not check for double spends against transactions already in the
@@ -814,8 +1161,12 @@ func (mp *TxPool) validateReplacement(tx *asiutil.Tx,
 // MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
 // more details.
 //
+// A transaction of a package is neither held to the minimum gas price nor
+// allowed to replace others, and it does not make room in a full pool, since
+// the package is only priced, and the pool limited, once all of it is in.
+//
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAcceptTransaction(tx *asiutil.Tx, isNew, rejectDupOrphans bool) ([]*common.Hash, *mining.TxDesc, error) {
+func (mp *TxPool) maybeAcceptTransaction(tx *asiutil.Tx, isNew, rejectDupOrphans, inPackage bool) ([]*common.Hash, *mining.TxDesc, error) {
 	txHash := tx.Hash()
 
 	// Don't accept the transaction if it already exists in the pool.  This
@@ -833,6 +1184,10 @@ func (mp *TxPool) maybeAcceptTransaction(tx *asiutil.Tx, isNew, rejectDupOrphans
 		return nil, nil, txRuleError(protos.RejectMalformed, str)
 	}
 
+	// Evict the transactions which stayed in the pool for too long before
+	// the inputs of this one are looked up in it.
+	mp.expireTransactions()
+
 	// Perform preliminary sanity checks on the transaction.  This makes
 	// use of blockchain which contains the invariant rules for what
 	// transactions are allowed into blocks.
@@ -974,9 +1329,9 @@ func (mp *TxPool) maybeAcceptTransaction(tx *asiutil.Tx, isNew, rejectDupOrphans
 
 	// Don't allow transactions with price too low to get into a mined block.
 	gasPrice := float64(txFee) / float64(tx.MsgTx().TxContract.GasLimit)
-	if gasPrice < mp.cfg.Policy.MinRelayTxPrice {
+	if minPrice := mp.minGasPrice(); !inPackage && gasPrice < minPrice {
 		str := fmt.Sprintf("transaction %v gas price too low: %f > %f",
-			txHash, gasPrice, mp.cfg.Policy.MinRelayTxPrice)
+			txHash, gasPrice, minPrice)
 		return nil, nil, txRuleError(protos.RejectLowGasPrice, str)
 	}
 
@@ -995,6 +1350,12 @@ func (mp *TxPool) maybeAcceptTransaction(tx *asiutil.Tx, isNew, rejectDupOrphans
 		return nil, nil, txRuleError(rejectCode, str)
 	}
 
+	// Don't allow transactions rejected by the rules of the operator.
+	err = checkPolicyHooks(mp.cfg.PolicyHooks, tx, utxoView)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// NOTE: if you modify this code to accept non-standard transactions,
 	// you should add code here to check that the transaction does a
 	// reasonable number of ECDSA signature verifications.
@@ -1013,6 +1374,11 @@ func (mp *TxPool) maybeAcceptTransaction(tx *asiutil.Tx, isNew, rejectDupOrphans
 	// If the transaction has any conflicts and we've made it this far, then
 	// we're processing a potential replacement.
 	var conflicts map[common.Hash]*asiutil.Tx
+	if isReplacement && inPackage {
+		str := fmt.Sprintf("transaction %v of a package replaces "+
+			"transactions in the pool", txHash)
+		return nil, nil, txRuleError(protos.RejectDuplicate, str)
+	}
 	if isReplacement {
 		conflicts, err = mp.validateReplacement(tx, gasPrice)
 		if err != nil {
@@ -1037,6 +1403,18 @@ func (mp *TxPool) maybeAcceptTransaction(tx *asiutil.Tx, isNew, rejectDupOrphans
 	// Add to transaction pool.
 	txD := mp.addTransaction(utxoView, tx, bestHeight, txFee, feeList)
 
+	// Make room for the transaction when the pool is full.  It is rejected
+	// if it pays less than the transactions already in the pool.
+	if inPackage {
+		return nil, txD, nil
+	}
+	mp.limitPoolSize()
+	if !mp.isTransactionInPool(txHash) {
+		str := fmt.Sprintf("transaction %v gas price too low to enter "+
+			"the full memory pool: %f", txHash, gasPrice)
+		return nil, nil, txRuleError(protos.RejectLowGasPrice, str)
+	}
+
 	log.Debugf("Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.pool))
 
@@ -1057,7 +1435,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *asiutil.Tx, isNew, rejectDupOrphans
 func (mp *TxPool) MaybeAcceptTransaction(tx *asiutil.Tx, isNew bool) ([]*common.Hash, *mining.TxDesc, error) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
-	hashes, txD, err := mp.maybeAcceptTransaction(tx, isNew, true)
+	hashes, txD, err := mp.maybeAcceptTransaction(tx, isNew, true, false)
 	mp.mtx.Unlock()
 
 	return hashes, txD, err
@@ -1100,7 +1478,7 @@ func (mp *TxPool) processOrphans(acceptedTx *asiutil.Tx) []*mining.TxDesc {
 			// Potentially accept an orphan into the tx pool.
 			for _, tx := range orphans {
 				missing, txD, err := mp.maybeAcceptTransaction(
-					tx, true, false)
+					tx, true, false, false)
 				if err != nil {
 					// The orphan is now invalid, so there
 					// is no way any other orphans which
@@ -1174,6 +1552,13 @@ func (mp *TxPool) ProcessOrphans(acceptedTx *asiutil.Tx) []*mining.TxDesc {
 // with any additional orphan transaactions that were added as a result of
 // the passed one being accepted.
 //
+// The packages are relayed one transaction at a time, parents first.  A
+// transaction rejected for its gas price is kept for a while, so a child
+// paying for it is accepted along with it, whichever comes first.  The list
+// then starts with the parents of the package.  Only a child paying for its
+// direct parents is handled that way, deeper packages must be submitted at
+// once with ProcessPackage.
+//
 // This function is safe for concurrent access.
 func (mp *TxPool) ProcessTransaction(tx *asiutil.Tx, allowOrphan, rateLimit bool, tag Tag) ([]*mining.TxDesc, error) {
 	log.Tracef("Processing transaction %v", tx.Hash())
@@ -1183,8 +1568,11 @@ func (mp *TxPool) ProcessTransaction(tx *asiutil.Tx, allowOrphan, rateLimit bool
 	defer mp.mtx.Unlock()
 
 	// Potentially accept the transaction to the memory pool.
-	missingParents, txD, err := mp.maybeAcceptTransaction(tx, true, true)
+	missingParents, txD, err := mp.maybeAcceptTransaction(tx, true, true, false)
 	if err != nil {
+		if code, _ := extractRejectCode(err); code == protos.RejectLowGasPrice {
+			return mp.processLowPriceTx(tx, err)
+		}
 		return nil, err
 	}
 
@@ -1204,6 +1592,11 @@ func (mp *TxPool) ProcessTransaction(tx *asiutil.Tx, allowOrphan, rateLimit bool
 		return acceptedTxs, nil
 	}
 
+	// The transaction may pay for parents rejected for their gas price.
+	if acceptedTxs := mp.processLowPriceParents(tx, missingParents); acceptedTxs != nil {
+		return acceptedTxs, nil
+	}
+
 	// The transaction is an orphan (has inputs missing).  Reject
 	// it if the flag to allow orphans is not set.
 	if !allowOrphan {
@@ -1227,6 +1620,202 @@ func (mp *TxPool) ProcessTransaction(tx *asiutil.Tx, allowOrphan, rateLimit bool
 	return nil, err
 }
 
+// ProcessPackage accepts a package of transactions into the main pool at once,
+// so a transaction paying too little to be accepted on its own gets in when
+// the transactions spending it pay enough for both.  The transactions must be
+// ordered with every parent before its children, and every one but the last
+// must be spent by a later one.  None may be an orphan, and those already in
+// the pool are skipped.
+//
+// The package is priced at the total fee of the transactions it adds over
+// their total gas limit.  It is rejected, and none of its transactions kept,
+// when that price is below the minimum gas price or too low to enter the full
+// pool.
+//
+// It returns a slice of transactions added to the mempool, the ones of the
+// package first, followed by any orphan transactions accepted as a result.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ProcessPackage(txs []*asiutil.Tx) ([]*mining.TxDesc, error) {
+	if len(txs) == 0 || len(txs) > MaxPackageTxs {
+		str := fmt.Sprintf("package has %d transactions, it must have "+
+			"between 1 and %d", len(txs), MaxPackageTxs)
+		return nil, txRuleError(protos.RejectInvalid, str)
+	}
+
+	// Walk the package backwards so the outputs spent by the transactions
+	// after each one are known.
+	spent := make(map[common.Hash]struct{})
+	for i := len(txs) - 1; i >= 0; i-- {
+		tx := txs[i]
+		if _, ok := spent[*tx.Hash()]; !ok && i != len(txs)-1 {
+			str := fmt.Sprintf("transaction %v of the package is not "+
+				"spent by a later one", tx.Hash())
+			return nil, txRuleError(protos.RejectInvalid, str)
+		}
+		for _, txIn := range tx.MsgTx().TxIn {
+			spent[txIn.PreviousOutPoint.Hash] = struct{}{}
+		}
+	}
+
+	log.Tracef("Processing package of %d transactions", len(txs))
+
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	return mp.processPackage(txs)
+}
+
+// processPackage is the internal function which implements the public
+// ProcessPackage once the package is known to be connected.  See the comment
+// for ProcessPackage for more details.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) processPackage(txs []*asiutil.Tx) ([]*mining.TxDesc, error) {
+	var accepted []*mining.TxDesc
+	reject := func(err error) ([]*mining.TxDesc, error) {
+		for _, txD := range accepted {
+			if mp.isTransactionInPool(txD.Tx.Hash()) {
+				mp.removeTransaction(txD.Tx, true)
+			}
+		}
+		return nil, err
+	}
+
+	var fee int64
+	var gasLimit uint64
+	for _, tx := range txs {
+		if mp.isTransactionInPool(tx.Hash()) {
+			continue
+		}
+		missingParents, txD, err := mp.maybeAcceptTransaction(tx, true, true, true)
+		if err != nil {
+			return reject(err)
+		}
+		if len(missingParents) > 0 {
+			str := fmt.Sprintf("orphan transaction %v of the package "+
+				"references outputs of unknown or fully-spent "+
+				"transaction %v", tx.Hash(), missingParents[0])
+			return reject(txRuleError(protos.RejectDuplicate, str))
+		}
+		accepted = append(accepted, txD)
+		fee += txD.Fee
+		gasLimit += uint64(tx.MsgTx().TxContract.GasLimit)
+	}
+	if len(accepted) == 0 {
+		return nil, txRuleError(protos.RejectDuplicate,
+			"already have every transaction of the package")
+	}
+
+	gasPrice := float64(fee) / float64(gasLimit)
+	if minPrice := mp.minGasPrice(); gasPrice < minPrice {
+		str := fmt.Sprintf("package gas price too low: %f > %f",
+			gasPrice, minPrice)
+		return reject(txRuleError(protos.RejectLowGasPrice, str))
+	}
+
+	// Make room for the package when the pool is full.  It is rejected if
+	// any of its transactions is evicted.
+	mp.limitPoolSize()
+	for _, txD := range accepted {
+		if !mp.isTransactionInPool(txD.Tx.Hash()) {
+			str := fmt.Sprintf("package gas price too low to enter "+
+				"the full memory pool: %f", gasPrice)
+			return reject(txRuleError(protos.RejectLowGasPrice, str))
+		}
+	}
+
+	log.Debugf("Accepted package of %d %s (pool size: %v)", len(accepted),
+		pickNoun(len(accepted), "transaction", "transactions"), len(mp.pool))
+
+	// Accept any orphan transactions that depend on the package.
+	acceptedTxs := accepted
+	for _, txD := range accepted {
+		acceptedTxs = append(acceptedTxs, mp.processOrphans(txD.Tx)...)
+	}
+	return acceptedTxs, nil
+}
+
+// addLowPriceTx keeps a transaction rejected for its gas price for the
+// children which may pay for it, evicting a random one when too many are kept.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) addLowPriceTx(tx *asiutil.Tx) {
+	if len(mp.lowPriceTxs) >= maxLowPriceTxs {
+		for hash := range mp.lowPriceTxs {
+			delete(mp.lowPriceTxs, hash)
+			break
+		}
+	}
+	mp.lowPriceTxs[*tx.Hash()] = tx
+}
+
+// processLowPriceTx handles a transaction rejected for its gas price.  It is
+// accepted along with an orphan spending it when the orphan pays enough for
+// both, otherwise it is kept for a child to come and the rejection returned.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) processLowPriceTx(tx *asiutil.Tx, reason error) ([]*mining.TxDesc, error) {
+	children := make(map[common.Hash]*orphanTx)
+	for i := range tx.MsgTx().TxOut {
+		prevOut := protos.OutPoint{Hash: *tx.Hash(), Index: uint32(i)}
+		for hash := range mp.orphansByPrev[prevOut] {
+			children[hash] = mp.orphans[hash]
+		}
+	}
+	for _, child := range children {
+		// The child leaves the orphan pool while the package is
+		// processed, so the orphans it redeems are kept.
+		mp.removeOrphan(child.tx, false)
+		acceptedTxs, err := mp.processPackage([]*asiutil.Tx{tx, child.tx})
+		if err == nil {
+			delete(mp.lowPriceTxs, *tx.Hash())
+			return acceptedTxs, nil
+		}
+		mp.addOrphan(child.tx, child.tag)
+	}
+
+	mp.addLowPriceTx(tx)
+	return nil, reason
+}
+
+// processLowPriceParents accepts a transaction whose missing parents were all
+// rejected for their gas price along with them as a package, when it pays
+// enough for all of them.  It returns nil when the transaction is still an
+// orphan.  Only the direct parents of a transaction are looked up, so the
+// parents must not be children paid for themselves.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) processLowPriceParents(tx *asiutil.Tx, missingParents []*common.Hash) []*mining.TxDesc {
+	if len(missingParents) >= MaxPackageTxs {
+		return nil
+	}
+	pkg := make([]*asiutil.Tx, 0, len(missingParents)+1)
+	seen := make(map[common.Hash]struct{}, len(missingParents))
+	for _, hash := range missingParents {
+		if _, ok := seen[*hash]; ok {
+			continue
+		}
+		seen[*hash] = struct{}{}
+		parent, ok := mp.lowPriceTxs[*hash]
+		if !ok {
+			return nil
+		}
+		pkg = append(pkg, parent)
+	}
+	acceptedTxs, err := mp.processPackage(append(pkg, tx))
+	if err != nil {
+		log.Debugf("Rejected package of transaction %v with its low "+
+			"price parents: %v", tx.Hash(), err)
+		return nil
+	}
+	for hash := range seen {
+		delete(mp.lowPriceTxs, hash)
+	}
+	return acceptedTxs
+}
+
 // Count returns the number of transactions in the main pool.  It does not
 // include the orphan pool.
 //
@@ -1374,6 +1963,19 @@ func (mp *TxPool) Start() {
 	log.Info("TxPool start")
 	mp.existCh = make(chan interface{})
 	go mp.handleUpdateFees()
+
+	if mp.cfg.DumpFile != "" {
+		accepted, dropped, err := mp.Load()
+		if err != nil {
+			log.Warnf("Unable to load the saved transactions: %v", err)
+		}
+		if accepted > 0 || dropped > 0 {
+			log.Infof("Loaded %d saved %s, dropped %d which are no "+
+				"longer valid", accepted,
+				pickNoun(accepted, "transaction", "transactions"),
+				dropped)
+		}
+	}
 }
 
 func (mp *TxPool) Halt() {
@@ -1384,6 +1986,17 @@ func (mp *TxPool) Halt() {
 	if mp.existCh != nil {
 		close(mp.existCh)
 		mp.existCh = nil
+
+		if mp.cfg.DumpFile != "" {
+			count, err := mp.Save()
+			if err != nil {
+				log.Errorf("Unable to save the transactions: %v", err)
+			} else {
+				log.Infof("Saved %d %s to %s", count,
+					pickNoun(count, "transaction", "transactions"),
+					mp.cfg.DumpFile)
+			}
+		}
 	}
 }
 
@@ -1391,13 +2004,16 @@ func (mp *TxPool) Halt() {
 // transactions until they are mined into a block.
 func New(cfg *Config) *TxPool {
 	return &TxPool{
-		cfg:            *cfg,
-		pool:           make(map[common.Hash]*mining.TxDesc),
-		orphans:        make(map[common.Hash]*orphanTx),
-		orphansByPrev:  make(map[protos.OutPoint]map[common.Hash]*asiutil.Tx),
-		nextExpireScan: time.Now().Add(orphanExpireScanInterval),
-		outpoints:      make(map[protos.OutPoint]*asiutil.Tx),
-		forbiddenTxs:   make(map[common.Hash]int64),
+		cfg:              *cfg,
+		pool:             make(map[common.Hash]*mining.TxDesc),
+		orphans:          make(map[common.Hash]*orphanTx),
+		orphansByPrev:    make(map[protos.OutPoint]map[common.Hash]*asiutil.Tx),
+		nextExpireScan:   time.Now().Add(orphanExpireScanInterval),
+		nextTxExpireScan: time.Now().Add(txExpireScanInterval),
+		outpoints:        make(map[protos.OutPoint]*asiutil.Tx),
+		forbiddenTxs:     make(map[common.Hash]int64),
+		lowPriceTxs:      make(map[common.Hash]*asiutil.Tx),
+		packages:         make(map[common.Hash]*evictionItem),
 	}
 }
 
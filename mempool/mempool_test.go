@@ -16,6 +16,7 @@ import (
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/common/address"
 	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/mining"
 	"github.com/AsimovNetwork/asimov/protos"
 	"github.com/AsimovNetwork/asimov/txscript"
 	"reflect"
@@ -1729,4 +1730,349 @@ func TestRBP(t *testing.T) {
 			break
 		}
 	}
-}
\ No newline at end of file
+}
+
+// TestLimitPoolSize ensures the transactions paying the lowest gas price are
+// evicted when the pool exceeds its maximum size, that the minimum gas price
+// is raised above them, and that it decays back to the relay price over time.
+func TestLimitPoolSize(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	outs := make([][]spendableOutput, 4)
+	for i := range outs {
+		coinbase := ctx.addCoinbaseTx(1)
+		outs[i] = []spendableOutput{txOutToSpendableOut(coinbase, 0)}
+	}
+
+	// Leave room for two transactions in the pool.
+	probe, err := harness.CreateSignedTx(outs[0], 1, DefaultInputFee)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	harness.txPool.cfg.Policy.MaxPoolSize = int64(probe.MsgTx().SerializeSize() * 5 / 2)
+
+	low := ctx.addSignedTx(outs[0], 1, DefaultInputFee, false)
+	high := ctx.addSignedTx(outs[1], 1, 3*DefaultInputFee, false)
+	mid := ctx.addSignedTx(outs[2], 1, 2*DefaultInputFee, false)
+	testPoolMembership(ctx, low, false, false)
+	testPoolMembership(ctx, high, false, true)
+	testPoolMembership(ctx, mid, false, true)
+
+	// The minimum gas price now exceeds the price of the evicted one.
+	relayPrice := harness.txPool.cfg.Policy.MinRelayTxPrice
+	if price := harness.txPool.MinGasPrice(); price <= 1.5*relayPrice {
+		t.Fatalf("MinGasPrice: got %f, want more than %f", price, 1.5*relayPrice)
+	}
+	tx, err := harness.CreateSignedTx(outs[3], 1, 3*DefaultInputFee/2)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err == nil || !strings.Contains(err.Error(), "gas price too low") {
+		t.Fatalf("ProcessTransaction: got %v, want a low gas price error", err)
+	}
+
+	// Two half lives later, the relay price applies again.
+	harness.txPool.lastMinPriceUpdate = time.Now().Add(-2 * minPriceHalfLife)
+	if price := harness.txPool.MinGasPrice(); price != relayPrice {
+		t.Fatalf("MinGasPrice: got %f, want %f", price, relayPrice)
+	}
+}
+
+// TestLimitPoolSizePackages ensures a parent paying a low gas price is not
+// evicted from a full pool when its descendants pay enough for both.
+func TestLimitPoolSizePackages(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	outs := make([][]spendableOutput, 3)
+	for i := range outs {
+		coinbase := ctx.addCoinbaseTx(1)
+		outs[i] = []spendableOutput{txOutToSpendableOut(coinbase, 0)}
+	}
+
+	// Leave room for three transactions in the pool.
+	probe, err := harness.CreateSignedTx(outs[0], 1, DefaultInputFee)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	harness.txPool.cfg.Policy.MaxPoolSize = int64(probe.MsgTx().SerializeSize() * 7 / 2)
+
+	parent := ctx.addSignedTx(outs[0], 1, DefaultInputFee, false)
+	parentOut := []spendableOutput{txOutToSpendableOut(parent, 0)}
+	child := ctx.addSignedTx(parentOut, 1, 5*DefaultInputFee, false)
+	other := ctx.addSignedTx(outs[1], 1, 2*DefaultInputFee, false)
+	newTx := ctx.addSignedTx(outs[2], 1, 4*DefaultInputFee, false)
+
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+	testPoolMembership(ctx, other, false, false)
+	testPoolMembership(ctx, newTx, false, true)
+}
+
+// testPackages ensures the package of every transaction of the pool sums the
+// fee and gas limit of the transaction and its descendants, and that the
+// eviction queue holds exactly those packages.
+func testPackages(tc *testContext) {
+	tc.t.Helper()
+
+	mp := tc.harness.txPool
+	if len(mp.packages) != len(mp.pool) || mp.evictions.Len() != len(mp.pool) {
+		tc.t.Fatalf("got %d packages and %d queued, want %d", len(mp.packages),
+			mp.evictions.Len(), len(mp.pool))
+	}
+	for hash, txDesc := range mp.pool {
+		fee := txDesc.Fee
+		gasLimit := uint64(txDesc.Tx.MsgTx().TxContract.GasLimit)
+		for descHash := range mp.txDescendants(txDesc.Tx, nil) {
+			fee += mp.pool[descHash].Fee
+			gasLimit += uint64(mp.pool[descHash].Tx.MsgTx().TxContract.GasLimit)
+		}
+		item := mp.packages[hash]
+		if item.fee != fee || item.gasLimit != gasLimit || mp.evictions[item.index] != item {
+			tc.t.Fatalf("package of %v: got fee %d, gas limit %d, want %d, %d",
+				hash, item.fee, item.gasLimit, fee, gasLimit)
+		}
+	}
+}
+
+// TestPoolPackages ensures the packages of the transactions are kept up to
+// date as transactions enter and leave the pool, in any order.
+func TestPoolPackages(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	coinbase := ctx.addCoinbaseTx(2)
+
+	parent := ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(coinbase, 0)},
+		2, DefaultInputFee, false)
+	child := ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(parent, 0)},
+		1, DefaultInputFee, false)
+	ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(parent, 1),
+		txOutToSpendableOut(child, 0)}, 1, DefaultInputFee, false)
+	ctx.addSignedTx([]spendableOutput{txOutToSpendableOut(coinbase, 1)},
+		1, DefaultInputFee, false)
+	testPackages(ctx)
+
+	// The parent is mined, its descendants stay.
+	harness.txPool.RemoveTransaction(parent, false)
+	testPackages(ctx)
+
+	// The block is disconnected, the parent comes back after them.
+	if _, err := harness.txPool.ProcessTransaction(parent, false, false, 0); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error %v", err)
+	}
+	testPackages(ctx)
+
+	// The child is removed along with the grandchild.
+	harness.txPool.RemoveTransaction(child, true)
+	testPackages(ctx)
+	testPoolMembership(ctx, parent, false, true)
+	if harness.txPool.Count() != 2 {
+		t.Fatalf("got %d transactions in the pool, want 2", harness.txPool.Count())
+	}
+}
+
+// TestExpireTransactions ensures the transactions older than the maximum age
+// are removed from the pool along with their descendants, once the expiry scan
+// is due.
+func TestExpireTransactions(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.Policy.MaxTxAge = time.Hour
+	ctx := &testContext{t, harness}
+	outs := make([][]spendableOutput, 3)
+	for i := range outs {
+		coinbase := ctx.addCoinbaseTx(1)
+		outs[i] = []spendableOutput{txOutToSpendableOut(coinbase, 0)}
+	}
+
+	parent := ctx.addSignedTx(outs[0], 1, DefaultInputFee, false)
+	parentOut := []spendableOutput{txOutToSpendableOut(parent, 0)}
+	child := ctx.addSignedTx(parentOut, 1, DefaultInputFee, false)
+	harness.txPool.pool[*parent.Hash()].Added = time.Now().Add(-2 * time.Hour)
+
+	// The pool is not scanned before the scan interval elapsed.
+	ctx.addSignedTx(outs[1], 1, DefaultInputFee, false)
+	testPoolMembership(ctx, parent, false, true)
+
+	harness.txPool.nextTxExpireScan = time.Now()
+	ctx.addSignedTx(outs[2], 1, DefaultInputFee, false)
+	testPoolMembership(ctx, parent, false, false)
+	testPoolMembership(ctx, child, false, false)
+	if count := harness.txPool.Count(); count != 2 {
+		t.Fatalf("Count: got %d, want 2", count)
+	}
+}
+
+// TestProcessPackage ensures a parent paying too little on its own is accepted
+// along with a child paying enough for both, and that a package is rejected
+// as a whole when it pays too little or is not connected.
+func TestProcessPackage(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.Policy.MinRelayTxPrice = 2 * DefaultInputFee / DefaultGasLimit
+	ctx := &testContext{t, harness}
+	outs := make([][]spendableOutput, 3)
+	for i := range outs {
+		coinbase := ctx.addCoinbaseTx(1)
+		outs[i] = []spendableOutput{txOutToSpendableOut(coinbase, 0)}
+	}
+
+	createTx := func(inputs []spendableOutput, fee common.Amount) *asiutil.Tx {
+		tx, err := harness.CreateSignedTx(inputs, 1, fee)
+		if err != nil {
+			t.Fatalf("unable to create transaction: %v", err)
+		}
+		return tx
+	}
+
+	parent := createTx(outs[0], DefaultInputFee)
+	parentOut := []spendableOutput{txOutToSpendableOut(parent, 0)}
+	if _, err := harness.txPool.ProcessTransaction(parent, false, false, 0); err == nil {
+		t.Fatalf("ProcessTransaction: accepted a parent paying too little")
+	}
+
+	// The package is not connected.
+	other := createTx(outs[1], 5*DefaultInputFee)
+	if _, err := harness.txPool.ProcessPackage([]*asiutil.Tx{parent, other}); err == nil {
+		t.Fatalf("ProcessPackage: accepted a package which is not connected")
+	}
+
+	// The child does not pay enough for both.
+	cheapChild := createTx(parentOut, 2*DefaultInputFee)
+	if _, err := harness.txPool.ProcessPackage([]*asiutil.Tx{parent, cheapChild}); err == nil {
+		t.Fatalf("ProcessPackage: accepted a package paying too little")
+	}
+	testPoolMembership(ctx, parent, false, false)
+	testPoolMembership(ctx, cheapChild, false, false)
+
+	child := createTx(parentOut, 5*DefaultInputFee)
+	acceptedTxs, err := harness.txPool.ProcessPackage([]*asiutil.Tx{parent, child})
+	if err != nil {
+		t.Fatalf("ProcessPackage error %v", err)
+	}
+	if len(acceptedTxs) != 2 || acceptedTxs[0].Tx != parent || acceptedTxs[1].Tx != child {
+		t.Fatalf("ProcessPackage: got %d accepted transactions, want the "+
+			"parent and the child", len(acceptedTxs))
+	}
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+
+	// The transactions already in the pool are skipped.
+	grandchild := createTx([]spendableOutput{txOutToSpendableOut(child, 0)}, 2*DefaultInputFee)
+	acceptedTxs, err = harness.txPool.ProcessPackage([]*asiutil.Tx{child, grandchild})
+	if err != nil {
+		t.Fatalf("ProcessPackage error %v", err)
+	}
+	if len(acceptedTxs) != 1 || acceptedTxs[0].Tx != grandchild {
+		t.Fatalf("ProcessPackage: got %d accepted transactions, want the "+
+			"grandchild", len(acceptedTxs))
+	}
+}
+
+// TestProcessPackageRelay ensures a parent relayed on its own and rejected for
+// its gas price is accepted along with a child paying for both, whichever of
+// them is received first.
+func TestProcessPackageRelay(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.Policy.MinRelayTxPrice = 2 * DefaultInputFee / DefaultGasLimit
+	ctx := &testContext{t, harness}
+	outs := make([][]spendableOutput, 3)
+	for i := range outs {
+		coinbase := ctx.addCoinbaseTx(1)
+		outs[i] = []spendableOutput{txOutToSpendableOut(coinbase, 0)}
+	}
+
+	createTx := func(inputs []spendableOutput, fee common.Amount) *asiutil.Tx {
+		tx, err := harness.CreateSignedTx(inputs, 1, fee)
+		if err != nil {
+			t.Fatalf("unable to create transaction: %v", err)
+		}
+		return tx
+	}
+	process := func(tx *asiutil.Tx) ([]*mining.TxDesc, error) {
+		return harness.txPool.ProcessTransaction(tx, true, false, 0)
+	}
+
+	// The parent is received first.
+	parent := createTx(outs[0], DefaultInputFee)
+	if _, err := process(parent); err == nil {
+		t.Fatalf("ProcessTransaction: accepted a parent paying too little")
+	}
+	testPoolMembership(ctx, parent, false, false)
+
+	// A child not paying enough for both is an orphan.
+	cheapChild := createTx([]spendableOutput{txOutToSpendableOut(parent, 0)}, 2*DefaultInputFee)
+	if acceptedTxs, err := process(cheapChild); err != nil || len(acceptedTxs) != 0 {
+		t.Fatalf("ProcessTransaction: got %d accepted transactions, error %v",
+			len(acceptedTxs), err)
+	}
+	testPoolMembership(ctx, cheapChild, true, false)
+	harness.txPool.RemoveOrphan(cheapChild)
+
+	child := createTx([]spendableOutput{txOutToSpendableOut(parent, 0)}, 5*DefaultInputFee)
+	acceptedTxs, err := process(child)
+	if err != nil {
+		t.Fatalf("ProcessTransaction error %v", err)
+	}
+	if len(acceptedTxs) != 2 || acceptedTxs[0].Tx != parent || acceptedTxs[1].Tx != child {
+		t.Fatalf("ProcessTransaction: got %d accepted transactions, want the "+
+			"parent and the child", len(acceptedTxs))
+	}
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+
+	// The child is received first.
+	parent = createTx(outs[1], DefaultInputFee)
+	child = createTx([]spendableOutput{txOutToSpendableOut(parent, 0)}, 5*DefaultInputFee)
+	if acceptedTxs, err := process(child); err != nil || len(acceptedTxs) != 0 {
+		t.Fatalf("ProcessTransaction: got %d accepted transactions, error %v",
+			len(acceptedTxs), err)
+	}
+	testPoolMembership(ctx, child, true, false)
+	acceptedTxs, err = process(parent)
+	if err != nil {
+		t.Fatalf("ProcessTransaction error %v", err)
+	}
+	if len(acceptedTxs) != 2 || acceptedTxs[0].Tx != parent || acceptedTxs[1].Tx != child {
+		t.Fatalf("ProcessTransaction: got %d accepted transactions, want the "+
+			"parent and the child", len(acceptedTxs))
+	}
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+
+	// A parent with no child paying for it stays out, as long as it is not
+	// paid for.
+	lonely := createTx(outs[2], DefaultInputFee)
+	if _, err := process(lonely); err == nil {
+		t.Fatalf("ProcessTransaction: accepted a parent paying too little")
+	}
+	testPoolMembership(ctx, lonely, false, false)
+}
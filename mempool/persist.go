@@ -0,0 +1,232 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+	"github.com/AsimovNetwork/asimov/mining"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+const (
+	// dumpVersion is the version of the format of the file the transactions
+	// of the pool are saved to.
+	dumpVersion = 1
+
+	// maxDumpTxs is the maximum number of transactions read from the file
+	// the pool is saved to.  It only guards against a corrupted count.
+	maxDumpTxs = 10000000
+)
+
+// errNoDumpFile is returned when the pool is saved or loaded but its config
+// has no file to do it with.
+var errNoDumpFile = errors.New("mempool persistence is disabled")
+
+// DumpFile returns the path of the file the transactions of the pool are saved
+// to, empty when persistence is disabled.
+func (mp *TxPool) DumpFile() string {
+	return mp.cfg.DumpFile
+}
+
+// dumpOrder returns the descriptors of the transactions to save, the oldest
+// first except that every transaction comes after the transactions of the
+// pool it spends, so they can be accepted again in order.  Forbidden
+// transactions are left out.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) dumpOrder() []*mining.TxDesc {
+	descs := make([]*mining.TxDesc, 0, len(mp.pool))
+	for hash, desc := range mp.pool {
+		if _, forbidden := mp.forbiddenTxs[hash]; !forbidden {
+			descs = append(descs, desc)
+		}
+	}
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].Added.Before(descs[j].Added)
+	})
+
+	ordered := make([]*mining.TxDesc, 0, len(descs))
+	visited := make(map[common.Hash]struct{}, len(descs))
+	var visit func(desc *mining.TxDesc)
+	visit = func(desc *mining.TxDesc) {
+		hash := *desc.Tx.Hash()
+		if _, ok := visited[hash]; ok {
+			return
+		}
+		visited[hash] = struct{}{}
+		for _, txIn := range desc.Tx.MsgTx().TxIn {
+			parent, ok := mp.pool[txIn.PreviousOutPoint.Hash]
+			if ok {
+				if _, forbidden := mp.forbiddenTxs[txIn.PreviousOutPoint.Hash]; !forbidden {
+					visit(parent)
+				}
+			}
+		}
+		ordered = append(ordered, desc)
+	}
+	for _, desc := range descs {
+		visit(desc)
+	}
+	return ordered
+}
+
+// Save writes the transactions of the main pool, along with the time they
+// were added, to the dump file of the config.  The file is replaced at once
+// so a failure never leaves a partial one behind.  It returns the number of
+// transactions saved.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Save() (int, error) {
+	path := mp.cfg.DumpFile
+	if path == "" {
+		return 0, errNoDumpFile
+	}
+
+	var buf bytes.Buffer
+	mp.mtx.RLock()
+	descs := mp.dumpOrder()
+	err := serialization.WriteUint32(&buf, dumpVersion)
+	if err == nil {
+		err = serialization.WriteVarInt(&buf, 0, uint64(len(descs)))
+	}
+	for _, desc := range descs {
+		if err != nil {
+			break
+		}
+		err = serialization.WriteUint64(&buf, uint64(desc.Added.Unix()))
+		if err == nil {
+			err = desc.Tx.MsgTx().Serialize(&buf)
+		}
+	}
+	mp.mtx.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	tmpPath := path + ".new"
+	if err := ioutil.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	return len(descs), nil
+}
+
+// Load accepts the transactions saved to the dump file of the config into the
+// main pool again, with the time they were first added.  They are validated
+// like new transactions, those which are no longer valid or which expired
+// meanwhile are dropped.  A parent rejected for its gas price is accepted
+// again along with the children paying for it, as a package.  A missing file
+// is not an error.  It returns the number of transactions accepted and
+// dropped.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Load() (int, int, error) {
+	path := mp.cfg.DumpFile
+	if path == "" {
+		return 0, 0, errNoDumpFile
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	var version uint32
+	if err := serialization.ReadUint32(r, &version); err != nil {
+		return 0, 0, err
+	}
+	if version != dumpVersion {
+		return 0, 0, fmt.Errorf("unsupported mempool file version %d", version)
+	}
+	count, err := serialization.ReadVarInt(r, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if count > maxDumpTxs {
+		return 0, 0, fmt.Errorf("too many transactions in mempool file: %d", count)
+	}
+
+	var accepted, dropped int
+	now := time.Now()
+
+	// lowPrice holds the time the parents rejected for their gas price were
+	// first added, until a child pays for them.
+	lowPrice := make(map[common.Hash]time.Time)
+	for i := uint64(0); i < count; i++ {
+		var addedUnix uint64
+		if err := serialization.ReadUint64(r, &addedUnix); err != nil {
+			return accepted, dropped, err
+		}
+		var msgTx protos.MsgTx
+		if err := msgTx.Deserialize(r); err != nil {
+			return accepted, dropped, err
+		}
+		added := time.Unix(int64(addedUnix), 0)
+
+		maxAge := mp.cfg.Policy.MaxTxAge
+		if maxAge > 0 && now.Sub(added) > maxAge {
+			dropped++
+			continue
+		}
+
+		tx := asiutil.NewTx(&msgTx)
+		mp.mtx.Lock()
+		missingParents, txD, err := mp.maybeAcceptTransaction(tx, false, true, false)
+		if code, _ := extractRejectCode(err); code == protos.RejectLowGasPrice {
+			mp.addLowPriceTx(tx)
+			lowPrice[*tx.Hash()] = added
+			mp.mtx.Unlock()
+			continue
+		}
+		var acceptedTxs []*mining.TxDesc
+		if err == nil && len(missingParents) > 0 {
+			acceptedTxs = mp.processLowPriceParents(tx, missingParents)
+		}
+		switch {
+		case err != nil:
+			log.Debugf("Dropped saved transaction %v: %v", tx.Hash(), err)
+			dropped++
+		case acceptedTxs != nil:
+			for _, txD := range acceptedTxs {
+				txD.Added = added
+				if parentAdded, ok := lowPrice[*txD.Tx.Hash()]; ok {
+					txD.Added = parentAdded
+					delete(lowPrice, *txD.Tx.Hash())
+				}
+			}
+			accepted += len(acceptedTxs)
+		case len(missingParents) > 0:
+			log.Debugf("Dropped saved transaction %v: it spends unknown "+
+				"transaction %v", tx.Hash(), missingParents[0])
+			dropped++
+		default:
+			txD.Added = added
+			accepted++
+		}
+		mp.mtx.Unlock()
+	}
+
+	// The parents no child paid for are dropped.
+	dropped += len(lowPrice)
+	return accepted, dropped, nil
+}
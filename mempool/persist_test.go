@@ -0,0 +1,141 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+)
+
+// TestSaveLoad ensures the transactions saved by a pool are accepted again by
+// a new pool with the time they were first added, and that the transactions
+// which are no longer valid or which expired meanwhile are dropped.
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "mempool")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.DumpFile = filepath.Join(dir, "mempool.dat")
+	ctx := &testContext{t, harness}
+	outs := make([][]spendableOutput, 3)
+	for i := range outs {
+		coinbase := ctx.addCoinbaseTx(1)
+		outs[i] = []spendableOutput{txOutToSpendableOut(coinbase, 0)}
+	}
+
+	// The child is added first in the pool, yet it must be saved after its
+	// parent to be accepted again.
+	parent := ctx.addSignedTx(outs[0], 1, DefaultInputFee, false)
+	parentOut := []spendableOutput{txOutToSpendableOut(parent, 0)}
+	child := ctx.addSignedTx(parentOut, 1, DefaultInputFee, false)
+	spent := ctx.addSignedTx(outs[1], 1, DefaultInputFee, false)
+	expired := ctx.addSignedTx(outs[2], 1, DefaultInputFee, false)
+	added := time.Unix(time.Now().Unix()-60, 0)
+	harness.txPool.pool[*parent.Hash()].Added = added
+	harness.txPool.pool[*child.Hash()].Added = added.Add(-time.Second)
+	harness.txPool.pool[*expired.Hash()].Added = added.Add(-2 * time.Hour)
+
+	count, err := harness.txPool.Save()
+	if err != nil {
+		t.Fatalf("Save error %v", err)
+	}
+	if count != 4 {
+		t.Fatalf("Save: got %d transactions, want 4", count)
+	}
+
+	// The output the spent transaction redeems was spent in the chain
+	// meanwhile.
+	harness.chain.utxos.RemoveEntry(outs[1][0].outPoint)
+	cfg := harness.txPool.cfg
+	cfg.Policy.MaxTxAge = time.Hour
+	harness.txPool = New(&cfg)
+
+	accepted, dropped, err := harness.txPool.Load()
+	if err != nil {
+		t.Fatalf("Load error %v", err)
+	}
+	if accepted != 2 || dropped != 2 {
+		t.Fatalf("Load: got %d accepted and %d dropped, want 2 and 2",
+			accepted, dropped)
+	}
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+	testPoolMembership(ctx, spent, false, false)
+	testPoolMembership(ctx, expired, false, false)
+	if got := harness.txPool.pool[*parent.Hash()].Added; !got.Equal(added) {
+		t.Errorf("Load: got added time %v, want %v", got, added)
+	}
+}
+
+// TestSaveLoadPackage ensures a parent paying too little to be accepted on its
+// own is accepted again along with the child paying for it.
+func TestSaveLoadPackage(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "mempool")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.DumpFile = filepath.Join(dir, "mempool.dat")
+	harness.txPool.cfg.Policy.MinRelayTxPrice = 2 * DefaultInputFee / DefaultGasLimit
+	ctx := &testContext{t, harness}
+	coinbase := ctx.addCoinbaseTx(1)
+
+	parent, err := harness.CreateSignedTx([]spendableOutput{txOutToSpendableOut(coinbase, 0)},
+		1, DefaultInputFee)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	child, err := harness.CreateSignedTx([]spendableOutput{txOutToSpendableOut(parent, 0)},
+		1, 5*DefaultInputFee)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	if _, err := harness.txPool.ProcessPackage([]*asiutil.Tx{parent, child}); err != nil {
+		t.Fatalf("ProcessPackage error %v", err)
+	}
+	added := time.Unix(time.Now().Unix()-60, 0)
+	harness.txPool.pool[*parent.Hash()].Added = added
+
+	if _, err := harness.txPool.Save(); err != nil {
+		t.Fatalf("Save error %v", err)
+	}
+	cfg := harness.txPool.cfg
+	harness.txPool = New(&cfg)
+
+	accepted, dropped, err := harness.txPool.Load()
+	if err != nil {
+		t.Fatalf("Load error %v", err)
+	}
+	if accepted != 2 || dropped != 0 {
+		t.Fatalf("Load: got %d accepted and %d dropped, want 2 and 0",
+			accepted, dropped)
+	}
+	testPoolMembership(ctx, parent, false, true)
+	testPoolMembership(ctx, child, false, true)
+	if got := harness.txPool.pool[*parent.Hash()].Added; !got.Equal(added) {
+		t.Errorf("Load: got added time %v, want %v", got, added)
+	}
+}
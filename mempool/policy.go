@@ -43,6 +43,49 @@ const (
 	maxStandardSigScriptSize = 1650
 )
 
+// PolicyHook is an admission rule of the operator, checked on every
+// transaction entering the main pool once it passed the standard rules.
+type PolicyHook interface {
+	// CheckTransaction returns an error when the transaction must be
+	// rejected.  The view holds the outputs the transaction spends, and
+	// classes the script class of each of its outputs.  The reject code of
+	// a TxRuleError is kept, any other error rejects the transaction as
+	// non-standard.
+	CheckTransaction(tx *asiutil.Tx, utxoView *txo.UtxoViewpoint,
+		classes []txscript.ScriptClass) error
+}
+
+// checkPolicyHooks runs the admission rules of the operator on a transaction
+// and returns the first error of any of them.
+func checkPolicyHooks(hooks []PolicyHook, tx *asiutil.Tx,
+	utxoView *txo.UtxoViewpoint) error {
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	txOuts := tx.MsgTx().TxOut
+	classes := make([]txscript.ScriptClass, len(txOuts))
+	for i, txOut := range txOuts {
+		classes[i] = txscript.GetScriptClass(txOut.PkScript)
+	}
+	for _, hook := range hooks {
+		err := hook.CheckTransaction(tx, utxoView, classes)
+		if err == nil {
+			continue
+		}
+		rejectCode, found := extractRejectCode(err)
+		if !found {
+			rejectCode = protos.RejectNonstandard
+		}
+		str := fmt.Sprintf("transaction %v is rejected by policy: %v",
+			tx.Hash(), err)
+		return txRuleError(rejectCode, str)
+	}
+
+	return nil
+}
+
 // checkInputsStandard performs a series of checks on a transaction's inputs
 // to ensure they are "standard".  A standard transaction input within the
 // context of this function is one whose referenced public key script is of a
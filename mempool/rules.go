@@ -0,0 +1,203 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// Rules are the declarative admission rules of an operator, as read from a
+// rules file.  Every rule left empty allows every transaction.
+type Rules struct {
+	// RejectClasses lists the names of the script classes the outputs of
+	// a transaction may not have, such as "create" or "template".
+	RejectClasses []string `json:"rejectclasses"`
+
+	// AllowContracts lists the only contracts a transaction may call or
+	// vote with.
+	AllowContracts []common.Address `json:"allowcontracts"`
+
+	// RejectSenders lists the addresses whose outputs may not be spent.
+	RejectSenders []common.Address `json:"rejectsenders"`
+
+	// RejectAssets lists the assets, hex encoded, a transaction may
+	// neither spend nor pay.
+	RejectAssets []string `json:"rejectassets"`
+
+	// RejectLimitedAssets rejects the transactions spending or paying an
+	// asset restricted by its issuer.
+	RejectLimitedAssets bool `json:"rejectlimitedassets"`
+}
+
+// LoadRules reads the admission rules from the JSON file at the given path.
+func LoadRules(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("malformed mempool rules file %s: %v", path, err)
+	}
+	return &rules, nil
+}
+
+// RulesPolicy is the policy hook checking the admission rules of a rules file.
+type RulesPolicy struct {
+	rejectClasses       map[txscript.ScriptClass]struct{}
+	allowContracts      map[common.Address]struct{}
+	rejectSenders       map[common.Address]struct{}
+	rejectAssets        map[protos.Asset]struct{}
+	rejectLimitedAssets bool
+
+	// isLimitedAsset returns whether an asset is restricted by its issuer
+	// as of the end of the main chain.
+	isLimitedAsset func(asset *protos.Asset) (bool, error)
+}
+
+// Ensure RulesPolicy implements the PolicyHook interface.
+var _ PolicyHook = (*RulesPolicy)(nil)
+
+// NewRulesPolicy returns a policy hook checking the passed rules.  The
+// function telling whether an asset is restricted by its issuer is only
+// needed when limited assets are rejected.
+func NewRulesPolicy(rules *Rules,
+	isLimitedAsset func(asset *protos.Asset) (bool, error)) (*RulesPolicy, error) {
+
+	p := &RulesPolicy{
+		rejectClasses:       make(map[txscript.ScriptClass]struct{}),
+		rejectSenders:       make(map[common.Address]struct{}),
+		rejectAssets:        make(map[protos.Asset]struct{}),
+		rejectLimitedAssets: rules.RejectLimitedAssets,
+		isLimitedAsset:      isLimitedAsset,
+	}
+
+nextClass:
+	for _, name := range rules.RejectClasses {
+		for class := txscript.NonStandardTy; class <= txscript.VoteTy; class++ {
+			if class.String() == name {
+				p.rejectClasses[class] = struct{}{}
+				continue nextClass
+			}
+		}
+		return nil, fmt.Errorf("unknown script class %q", name)
+	}
+
+	if len(rules.AllowContracts) > 0 {
+		p.allowContracts = make(map[common.Address]struct{})
+		for _, contract := range rules.AllowContracts {
+			p.allowContracts[contract] = struct{}{}
+		}
+	}
+
+	for _, sender := range rules.RejectSenders {
+		p.rejectSenders[sender] = struct{}{}
+	}
+
+	for _, assetHex := range rules.RejectAssets {
+		assetBytes, err := hex.DecodeString(assetHex)
+		if err != nil || len(assetBytes) != common.AssetLength {
+			return nil, fmt.Errorf("malformed asset %q", assetHex)
+		}
+		p.rejectAssets[*protos.AssetFromBytes(assetBytes)] = struct{}{}
+	}
+
+	if p.rejectLimitedAssets && isLimitedAsset == nil {
+		return nil, fmt.Errorf("limited assets cannot be rejected " +
+			"without a way to look them up")
+	}
+
+	return p, nil
+}
+
+// checkAsset returns an error when the asset may not be moved.
+func (p *RulesPolicy) checkAsset(asset *protos.Asset) error {
+	if _, ok := p.rejectAssets[*asset]; ok {
+		return fmt.Errorf("asset %v is rejected", asset)
+	}
+	if !p.rejectLimitedAssets || *asset == asiutil.AsimovAsset {
+		return nil
+	}
+	limited, err := p.isLimitedAsset(asset)
+	if err != nil {
+		return err
+	}
+	if limited {
+		return fmt.Errorf("asset %v is limited", asset)
+	}
+	return nil
+}
+
+// CheckTransaction returns an error when the transaction breaks any of the
+// rules.
+//
+// This is part of the PolicyHook interface implementation.
+func (p *RulesPolicy) CheckTransaction(tx *asiutil.Tx, utxoView *txo.UtxoViewpoint,
+	classes []txscript.ScriptClass) error {
+
+	checkedAssets := make(map[protos.Asset]struct{})
+	checkAsset := func(asset *protos.Asset) error {
+		if _, ok := checkedAssets[*asset]; ok {
+			return nil
+		}
+		checkedAssets[*asset] = struct{}{}
+		return p.checkAsset(asset)
+	}
+
+	for i, txIn := range tx.MsgTx().TxIn {
+		entry := utxoView.LookupEntry(txIn.PreviousOutPoint)
+		if entry == nil {
+			continue
+		}
+		if len(p.rejectSenders) > 0 {
+			_, addrs, _, _ := txscript.ExtractPkScriptAddrs(entry.PkScript())
+			for _, addr := range addrs {
+				sender := common.Address(addr.StandardAddress())
+				if _, ok := p.rejectSenders[sender]; ok {
+					return fmt.Errorf("input %d is spent from rejected "+
+						"address %v", i, sender)
+				}
+			}
+		}
+		if err := checkAsset(entry.Asset()); err != nil {
+			return fmt.Errorf("input %d: %v", i, err)
+		}
+	}
+
+	for i, txOut := range tx.MsgTx().TxOut {
+		class := classes[i]
+		if _, ok := p.rejectClasses[class]; ok {
+			return fmt.Errorf("output %d has rejected script class %v",
+				i, class)
+		}
+		if p.allowContracts != nil && (class == txscript.CallTy || class == txscript.VoteTy) {
+			_, addrs, _, _ := txscript.ExtractPkScriptAddrs(txOut.PkScript)
+			if len(addrs) == 0 {
+				return fmt.Errorf("output %d calls an unknown contract", i)
+			}
+			for _, addr := range addrs {
+				contract := common.Address(addr.StandardAddress())
+				if _, ok := p.allowContracts[contract]; !ok {
+					return fmt.Errorf("output %d calls contract %v "+
+						"which is not allowed", i, contract)
+				}
+			}
+		}
+		if err := checkAsset(&txOut.Asset); err != nil {
+			return fmt.Errorf("output %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
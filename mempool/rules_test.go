@@ -0,0 +1,161 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// hookFunc is a policy hook checking transactions with a function.
+type hookFunc func(tx *asiutil.Tx, utxoView *txo.UtxoViewpoint,
+	classes []txscript.ScriptClass) error
+
+// CheckTransaction calls the function of the hook.
+func (f hookFunc) CheckTransaction(tx *asiutil.Tx, utxoView *txo.UtxoViewpoint,
+	classes []txscript.ScriptClass) error {
+	return f(tx, utxoView, classes)
+}
+
+// TestLoadRules ensures the rules are read from a file and that the malformed
+// ones are refused.
+func TestLoadRules(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "mempool")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.json")
+	data := `{"rejectclasses": ["create"], "rejectassets": ["000000000000000100000001"]}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("WriteFile error %v", err)
+	}
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatalf("LoadRules error %v", err)
+	}
+	policy, err := NewRulesPolicy(rules, nil)
+	if err != nil {
+		t.Fatalf("NewRulesPolicy error %v", err)
+	}
+	if _, ok := policy.rejectClasses[txscript.CreateTy]; !ok || len(policy.rejectClasses) != 1 {
+		t.Errorf("NewRulesPolicy: got rejected classes %v, want create",
+			policy.rejectClasses)
+	}
+	if _, ok := policy.rejectAssets[*protos.NewAsset(0, 1, 1)]; !ok {
+		t.Errorf("NewRulesPolicy: got rejected assets %v, want (0, 4294967297)",
+			policy.rejectAssets)
+	}
+
+	tests := []struct {
+		name  string
+		rules Rules
+	}{
+		{"unknown class", Rules{RejectClasses: []string{"transfer"}}},
+		{"malformed asset", Rules{RejectAssets: []string{"0001"}}},
+		{"no limit lookup", Rules{RejectLimitedAssets: true}},
+	}
+	for _, test := range tests {
+		if _, err := NewRulesPolicy(&test.rules, nil); err == nil {
+			t.Errorf("%s: NewRulesPolicy accepted the rules", test.name)
+		}
+	}
+}
+
+// TestPolicyHooks ensures the transactions rejected by a policy hook are not
+// accepted into the pool, with the reject code of the hook when it has one.
+func TestPolicyHooks(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	ctx := &testContext{t, harness}
+	outs := make([][]spendableOutput, 4)
+	for i := range outs {
+		coinbase := ctx.addCoinbaseTx(1)
+		outs[i] = []spendableOutput{txOutToSpendableOut(coinbase, 0)}
+	}
+	sender := common.Address(harness.payAddr.StandardAddress())
+
+	tests := []struct {
+		name string
+		hook PolicyHook
+		code protos.RejectCode
+	}{
+		{
+			"rejected sender",
+			&RulesPolicy{rejectSenders: map[common.Address]struct{}{sender: {}}},
+			protos.RejectNonstandard,
+		},
+		{
+			"rejected class",
+			&RulesPolicy{rejectClasses: map[txscript.ScriptClass]struct{}{
+				txscript.PubKeyHashTy: {},
+			}},
+			protos.RejectNonstandard,
+		},
+		{
+			"rejected asset",
+			&RulesPolicy{rejectAssets: map[protos.Asset]struct{}{
+				asiutil.AsimovAsset: {},
+			}},
+			protos.RejectNonstandard,
+		},
+		{
+			"hook reject code",
+			hookFunc(func(tx *asiutil.Tx, utxoView *txo.UtxoViewpoint,
+				classes []txscript.ScriptClass) error {
+				if len(classes) != 1 || classes[0] != txscript.PubKeyHashTy {
+					return errors.New("unexpected script classes")
+				}
+				return TxRuleError{RejectCode: protos.RejectInvalid}
+			}),
+			protos.RejectInvalid,
+		},
+	}
+
+	for i, test := range tests {
+		harness.txPool.cfg.PolicyHooks = []PolicyHook{test.hook}
+		tx, err := harness.CreateSignedTx(outs[i], 1, DefaultInputFee)
+		if err != nil {
+			t.Fatalf("unable to create transaction: %v", err)
+		}
+		_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err == nil {
+			t.Errorf("%s: ProcessTransaction accepted the transaction",
+				test.name)
+			continue
+		}
+		code, _ := extractRejectCode(err)
+		if code != test.code {
+			t.Errorf("%s: got reject code %v, want %v", test.name,
+				code, test.code)
+		}
+		testPoolMembership(ctx, tx, false, false)
+	}
+
+	// The transactions breaking none of the rules are accepted.
+	harness.txPool.cfg.PolicyHooks = []PolicyHook{
+		&RulesPolicy{rejectClasses: map[txscript.ScriptClass]struct{}{
+			txscript.CreateTy: {},
+		}},
+	}
+	ctx.addSignedTx(outs[0], 1, DefaultInputFee, false)
+}
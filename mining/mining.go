@@ -7,20 +7,20 @@ package mining
 
 import (
 	"container/heap"
-	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
-	"github.com/AsimovNetwork/asimov/crypto"
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
 	"sort"
 	"time"
 
 	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/asiutil/vrf"
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/common/serialization"
+	"github.com/AsimovNetwork/asimov/consensus/signer"
 	"github.com/AsimovNetwork/asimov/protos"
 	"github.com/AsimovNetwork/asimov/txscript"
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
@@ -122,6 +122,12 @@ type TxPrioItem struct {
 	tx       *asiutil.Tx
 	gasPrice float64
 
+	// txGasPrice is the gas price the transaction pays on its own, and
+	// packageGasLimit the total gas limit of the package which earns it
+	// gasPrice when that one is higher.
+	txGasPrice      float64
+	packageGasLimit uint64
+
 	// dependsOn holds a map of transaction hashes which this one depends
 	// on.  It will only be set when the transaction references other
 	// transactions in the source pool and hence must come after them in
@@ -258,6 +264,79 @@ func CreateCoinbaseTx(params *chaincfg.Params, nextBlockHeight int32, addr commo
 	return asiutil.NewTx(tx), stdTxOut, nil
 }
 
+// packagePrice is the gas price a transaction of the source pool is
+// prioritized at, along with the total gas limit of the package paying it.
+type packagePrice struct {
+	gasPrice float64
+	gasLimit uint64
+}
+
+// maxPackageAncestors is the maximum number of ancestors of the package of a
+// transaction.  A transaction with more ancestors in the source pool does not
+// pay for them, which bounds the work of pricing the packages.
+const maxPackageAncestors = 25
+
+// calcPackagePrices returns the prices the transactions of the source pool are
+// prioritized at.  A package is a transaction along with its ancestors in the
+// source pool, and its price is their total fee over their total gas limit.
+// Every transaction is prioritized at the highest of its own price and the
+// prices of the packages of its descendants, so a parent paying too little is
+// picked as soon as one of its children pays enough for both.  Only packages
+// of at most maxPackageAncestors ancestors are priced.
+func calcPackagePrices(sourceTxns TxDescList) map[common.Hash]*packagePrice {
+	descs := make(map[common.Hash]*TxDesc, len(sourceTxns))
+	prices := make(map[common.Hash]*packagePrice, len(sourceTxns))
+	for _, txDesc := range sourceTxns {
+		hash := *txDesc.Tx.Hash()
+		descs[hash] = txDesc
+		prices[hash] = &packagePrice{
+			gasPrice: txDesc.GasPrice,
+			gasLimit: uint64(txDesc.Tx.MsgTx().TxContract.GasLimit),
+		}
+	}
+
+	for _, txDesc := range sourceTxns {
+		ancestors := make(map[common.Hash]*TxDesc)
+		stack := []*TxDesc{txDesc}
+		for len(stack) > 0 && len(ancestors) <= maxPackageAncestors {
+			desc := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, txIn := range desc.Tx.MsgTx().TxIn {
+				parentHash := txIn.PreviousOutPoint.Hash
+				parent, ok := descs[parentHash]
+				if !ok {
+					continue
+				}
+				if _, ok := ancestors[parentHash]; ok {
+					continue
+				}
+				ancestors[parentHash] = parent
+				stack = append(stack, parent)
+			}
+		}
+		if len(ancestors) == 0 || len(ancestors) > maxPackageAncestors {
+			continue
+		}
+
+		fee := txDesc.Fee
+		gasLimit := uint64(txDesc.Tx.MsgTx().TxContract.GasLimit)
+		for _, ancestor := range ancestors {
+			fee += ancestor.Fee
+			gasLimit += uint64(ancestor.Tx.MsgTx().TxContract.GasLimit)
+		}
+		if gasLimit == 0 {
+			continue
+		}
+		price := float64(fee) / float64(gasLimit)
+		for hash := range ancestors {
+			if price > prices[hash].gasPrice {
+				prices[hash] = &packagePrice{gasPrice: price, gasLimit: gasLimit}
+			}
+		}
+	}
+	return prices
+}
+
 // logSkippedDeps logs any dependencies which are also skipped as a result of
 // skipping a transaction while generating a block template at the trace level.
 func logSkippedDeps(tx *asiutil.Tx, deps map[common.Hash]*TxPrioItem) {
@@ -356,7 +435,11 @@ func NewBlkTmplGenerator(policy *Policy,
 //  |-----------------------------------| --|
 //  |      Coinbase Transaction         |   |
 //   -----------------------------------  --
-func (g *BlkTmplGenerator) ProduceNewBlock(account *crypto.Account, gasFloor, gasCeil uint64,
+//
+// The block is proposed for the validator and signed by the signer, which
+// holds the key signing the blocks of the validator in the round.
+func (g *BlkTmplGenerator) ProduceNewBlock(validator *common.Address, blockSigner signer.Signer,
+	gasFloor, gasCeil uint64,
 	blockTime int64,
 	round uint32, slotIndex uint16, blockInterval float64) (
 	blockTemplate *BlockTemplate, err error) {
@@ -373,8 +456,16 @@ func (g *BlkTmplGenerator) ProduceNewBlock(account *crypto.Account, gasFloor, ga
 	// number of items that are available for the priority queue.  Also,
 	// choose the initial sort order for the priority queue based on whether
 	// or not there is an area allocated for high-priority transactions.
+	//
+	// The utxos are fetched by order of the package prices, so the parents
+	// paid for by their children are not left out by the time limit.
 	sourceTxns := g.txSource.TxDescs()
-	sort.Sort(sourceTxns)
+	packagePrices := calcPackagePrices(sourceTxns)
+	sort.Slice(sourceTxns, func(i, j int) bool {
+		iPrice := packagePrices[*sourceTxns[i].Tx.Hash()].gasPrice
+		jPrice := packagePrices[*sourceTxns[j].Tx.Hash()].gasPrice
+		return iPrice*sourceTxns[j].UtxoFetchCount > jPrice*sourceTxns[i].UtxoFetchCount
+	})
 
 	forbiddenTxHashes := make([]*common.Hash, 0, len(sourceTxns))
 	priorityQueue := NewTxPriorityQueue(len(sourceTxns))
@@ -387,7 +478,23 @@ func (g *BlkTmplGenerator) ProduceNewBlock(account *crypto.Account, gasFloor, ga
 	bestHeight := g.chain.GetTip().Height()
 	totalPreSigns := g.sigSource.MiningDescs(bestHeight)
 
-	payToAddress := account.Address
+	// The validator may have delegated the signing of its blocks to another
+	// key, which must then be the key of the signer.
+	keyAddress, err := common.NewAddressWithId(common.PubKeyHashAddrID,
+		common.Hash160(blockSigner.PublicKey().SerializeCompressed()))
+	if err != nil {
+		return nil, err
+	}
+	signingKey, err := g.chain.SigningKeyOf(*validator, round)
+	if err != nil {
+		return nil, err
+	}
+	if signingKey != *keyAddress {
+		return nil, fmt.Errorf("the blocks of %v in round %d are signed by %v, not by %v",
+			validator, round, signingKey, keyAddress)
+	}
+
+	payToAddress := validator
 	var msgBlock protos.MsgBlock
 	header := &msgBlock.Header
 	header.Round = round
@@ -588,7 +695,10 @@ mempoolLoop:
 			}
 		}
 
-		prioItem.gasPrice = txDesc.GasPrice
+		packagePrice := packagePrices[*tx.Hash()]
+		prioItem.gasPrice = packagePrice.gasPrice
+		prioItem.txGasPrice = txDesc.GasPrice
+		prioItem.packageGasLimit = packagePrice.gasLimit
 
 		// Merge the referenced outputs from the input transactions to
 		// this transaction into the block utxo view.  This allows the
@@ -629,6 +739,19 @@ priorityQueueLoop:
 		// depending on the sort order) transaction.
 		prioItem := heap.Pop(priorityQueue).(*TxPrioItem)
 		tx := prioItem.tx
+
+		// A transaction prioritized at the price of the package of one of
+		// its descendants is only worth including first when the whole
+		// package fits in the block.  Otherwise it goes back to the queue
+		// at its own price.
+		if prioItem.gasPrice > prioItem.txGasPrice &&
+			uint64(blockGasLimit)+prioItem.packageGasLimit >= header.GasLimit {
+			log.Tracef("Requeuing tx %s at its own gas price since its "+
+				"package would exceed the max gas limit", tx.Hash())
+			prioItem.gasPrice = prioItem.txGasPrice
+			heap.Push(priorityQueue, prioItem)
+			continue
+		}
 		txpool[*tx.Hash()] = MiningTxProcessed
 
 		// Grab any transactions which depend on this one.
@@ -835,9 +958,19 @@ priorityQueueLoop:
 	msgBlock.ReceiptHash = receiptHash
 	msgBlock.Bloom = logBloom
 	msgBlock.Header.GasUsed = totalGasUsed
+
+	// Publish the vrf proof of the producer once the vrf is active.  Its
+	// input chains the output of the tip, which is the parent of the block.
+	if chaincfg.ActiveNetParams.VrfActiveAt(round) {
+		alpha := vrf.BlockAlpha(g.chain.GetTip().VrfOutput(), round, slotIndex)
+		msgBlock.VrfProof, err = blockSigner.ProveVrf(alpha)
+		if err != nil {
+			return nil, err
+		}
+	}
 	msgBlock.Header.PoaHash = msgBlock.CalculatePoaHash()
 
-	err = commit(&msgBlock, stateDB, account)
+	err = commit(&msgBlock, stateDB, blockSigner)
 	if err != nil {
 		return nil, err
 	}
@@ -872,18 +1005,24 @@ func rebuildFunder(tx *asiutil.Tx, stdTxout *protos.TxOut, fees *map[protos.Asse
 }
 
 // commit state and signature the given block
-func commit(block *protos.MsgBlock, stateDB *state.StateDB, account *crypto.Account) error {
+func commit(block *protos.MsgBlock, stateDB *state.StateDB, blockSigner signer.Signer) error {
 	stateRoot, err := stateDB.Commit(true)
 	if err != nil {
 		return err
 	}
-	if err := stateDB.Database().TrieDB().Commit(stateRoot, false); err != nil {
+	// The state of a template is written with the block once it is
+	// connected.  When the states are reference counted for pruning, a
+	// template which never gets connected would pin its nodes on disk, so
+	// it is only released from memory.
+	triedb := stateDB.Database().TrieDB()
+	if triedb.Refcounting() {
+		triedb.Dereference(stateRoot)
+	} else if err := triedb.Commit(stateRoot, false); err != nil {
 		return err
 	}
 	block.Header.StateRoot = stateRoot
 
-	blockHash := block.BlockHash()
-	signature, err := crypto.Sign(blockHash[:], (*ecdsa.PrivateKey)(&account.PrivateKey))
+	signature, err := blockSigner.SignProposal(&block.Header)
 	if err != nil {
 		log.Errorf("sign block failed: %s", err)
 		return err
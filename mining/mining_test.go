@@ -13,6 +13,7 @@ import (
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/common/address"
+	"github.com/AsimovNetwork/asimov/consensus/signer"
 	"github.com/AsimovNetwork/asimov/crypto"
 	"github.com/AsimovNetwork/asimov/protos"
 	"math"
@@ -377,7 +378,8 @@ func TestNewBlockTemplate(t *testing.T) {
 			fakeTxSource.push(v)
 		}
 
-		template, err := g.ProduceNewBlock(test.validator, test.gasFloor, test.gasCeil,
+		template, err := g.ProduceNewBlock(test.validator.Address,
+			signer.NewLocalSigner(&test.validator.PrivateKey, nil), test.gasFloor, test.gasCeil,
 			time.Now().Unix(), test.round, test.slot, 5*100000)
 		if err != nil {
 			if test.wantErr != true {
@@ -435,3 +437,64 @@ func TestNewBlockTemplate(t *testing.T) {
 		}
 	}
 }
+
+// TestCalcPackagePrices ensures the transactions are prioritized at the best
+// price of the packages they are part of.
+func TestCalcPackagePrices(t *testing.T) {
+	newTxDesc := func(fee int64, parents ...*TxDesc) *TxDesc {
+		msgTx := protos.NewMsgTx(protos.TxVersion)
+		msgTx.TxContract.GasLimit = 1000
+		msgTx.LockTime = uint32(rand.Int31())
+		for _, parent := range parents {
+			msgTx.AddTxIn(protos.NewTxIn(protos.NewOutPoint(parent.Tx.Hash(), 0), nil))
+		}
+		return &TxDesc{
+			Tx:       asiutil.NewTx(msgTx),
+			Fee:      fee,
+			GasPrice: float64(fee) / 1000,
+		}
+	}
+
+	// The child pays for its parent and grandparent, the sibling pays less
+	// than its parent.
+	grandparent := newTxDesc(1000)
+	parent := newTxDesc(2000, grandparent)
+	child := newTxDesc(27000, parent)
+	sibling := newTxDesc(1000, parent)
+	single := newTxDesc(5000)
+
+	prices := calcPackagePrices(TxDescList{single, sibling, child, parent, grandparent})
+	tests := []struct {
+		name     string
+		desc     *TxDesc
+		gasPrice float64
+		gasLimit uint64
+	}{
+		{"grandparent", grandparent, 10, 3000},
+		{"parent", parent, 10, 3000},
+		{"child", child, 27, 1000},
+		{"sibling", sibling, 1, 1000},
+		{"single", single, 5, 1000},
+	}
+	for _, test := range tests {
+		price := prices[*test.desc.Tx.Hash()]
+		if price.gasPrice != test.gasPrice || price.gasLimit != test.gasLimit {
+			t.Errorf("%s: got price %f and gas limit %d, want %f and %d",
+				test.name, price.gasPrice, price.gasLimit,
+				test.gasPrice, test.gasLimit)
+		}
+	}
+
+	// A transaction with too many ancestors does not pay for them, the
+	// last one with few enough does.
+	chain := TxDescList{newTxDesc(1000)}
+	for i := 1; i < maxPackageAncestors; i++ {
+		chain = append(chain, newTxDesc(1000, chain[i-1]))
+	}
+	chain = append(chain, newTxDesc(27000, chain[len(chain)-1]))
+	deep := newTxDesc(1000000, chain[len(chain)-1])
+	prices = calcPackagePrices(append(chain, deep))
+	if price := prices[*chain[0].Tx.Hash()]; price.gasPrice != 2 {
+		t.Errorf("root of a deep chain: got price %f, want 2", price.gasPrice)
+	}
+}
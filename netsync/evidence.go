@@ -0,0 +1,163 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/mempool"
+	peerpkg "github.com/AsimovNetwork/asimov/peer"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+	"github.com/AsimovNetwork/asimov/vm/fvm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+)
+
+// evidenceMsg packages an evidence message and the peer it came from together
+// so the block handler has access to that information.
+type evidenceMsg struct {
+	evidence *protos.MsgEvidence
+	peer     *peerpkg.Peer
+	reply    chan struct{}
+}
+
+// QueueEvidence adds the passed evidence message and peer to the block
+// handling queue. Responds to the done channel argument after the evidence
+// is processed.
+func (sm *SyncManager) QueueEvidence(evidence *protos.MsgEvidence, peer *peerpkg.Peer, done chan struct{}) {
+	// Don't accept more evidences if we're shutting down.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		done <- struct{}{}
+		return
+	}
+
+	sm.msgChan <- &evidenceMsg{evidence: evidence, peer: peer, reply: done}
+}
+
+// handleEvidenceMsg handles evidence messages from all peers.  New evidences
+// are relayed by the NTEvidence notification handler.
+func (sm *SyncManager) handleEvidenceMsg(emsg *evidenceMsg) {
+	peer := emsg.peer
+	if _, exists := sm.peerStates[peer]; !exists {
+		log.Warnf("Received evidence message from unknown peer %s", peer)
+		return
+	}
+
+	_, err := sm.chain.ProcessEvidence(emsg.evidence)
+	if err != nil {
+		hash := emsg.evidence.Hash()
+		log.Debugf("Rejected evidence %v from %s: %v", hash, peer, err)
+		code, reason := mempool.ErrToRejectErr(err)
+		peer.PushRejectMsg(protos.CmdEvidence, code, reason, &hash, false)
+	}
+}
+
+// reportEvidence submits the evidence to the evidence registry native
+// contract with a transaction paid by the key of the node, so the offender
+// can be penalized.  The key is the delegated signing key of the validator
+// when it has one: it is the only key the node holds.  The contract verifies
+// the evidence itself and ignores the ones already reported by other
+// validators.
+func (sm *SyncManager) reportEvidence(evidence *protos.MsgEvidence) error {
+	account := sm.account
+	offender := evidence.Offender()
+	if offender == *account.Address {
+		return nil
+	}
+	// The node does not hold the private key when its blocks are signed
+	// by a remote signer.
+	if account.PrivateKey.D == nil {
+		return errors.New("no private key to pay the evidence report")
+	}
+	next := big.NewInt(int64(sm.chain.BestSnapshot().Height + 1))
+	if !chaincfg.ActiveNetParams.FvmParam.IsNativeContracts(next) {
+		return errors.New("the evidence registry is not active on the network")
+	}
+
+	var buf bytes.Buffer
+	if err := evidence.Serialize(&buf); err != nil {
+		return err
+	}
+	hash := evidence.Hash()
+	data, err := fvm.PackFunctionArgs(vm.EvidenceRegistryABI, "reportMisbehavior", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	contractPkScript, err := txscript.PayToAddrScript(&common.EvidenceRegistry)
+	if err != nil {
+		return err
+	}
+	payer, err := account.KeyAddress()
+	if err != nil {
+		return err
+	}
+	senderPkScript, err := txscript.PayToAddrScript(payer)
+	if err != nil {
+		return err
+	}
+
+	// Pay the gas with unspent outputs which are not spent in the pool yet.
+	fees := int64(chaincfg.DefaultAutoSignUpGasLimit)
+	view := txo.NewUtxoViewpoint()
+	outpoints, err := sm.chain.FetchUtxoViewByAddressAndAsset(view,
+		payer.ScriptAddress(), &asiutil.AsimovAsset)
+	if err != nil {
+		return err
+	}
+	spent := make(map[protos.OutPoint]struct{})
+	for _, outpoint := range sm.txMemPool.HasSpentInTxPool(outpoints) {
+		spent[outpoint] = struct{}{}
+	}
+	msgTx := protos.NewMsgTx(protos.TxVersion)
+	var total int64
+	for _, outpoint := range *outpoints {
+		if _, ok := spent[outpoint]; ok {
+			continue
+		}
+		msgTx.AddTxIn(protos.NewTxIn(&outpoint, nil))
+		total += view.LookupEntry(outpoint).Amount()
+		if total >= fees {
+			break
+		}
+	}
+	if total < fees {
+		return errors.New("not enough balance to pay the evidence report")
+	}
+	msgTx.AddTxOut(protos.NewContractTxOut(0, contractPkScript, asiutil.AsimovAsset, data))
+	if total > fees {
+		msgTx.AddTxOut(protos.NewTxOut(total-fees, senderPkScript, asiutil.AsimovAsset))
+	}
+	msgTx.TxContract.GasLimit = chaincfg.DefaultAutoSignUpGasLimit
+
+	lookupKey := func(a common.IAddress) (*crypto.PrivateKey, bool, error) {
+		return &account.PrivateKey, true, nil
+	}
+	for i := range msgTx.TxIn {
+		sigScript, err := txscript.SignTxOutput(msgTx, i, senderPkScript,
+			txscript.SigHashAll, txscript.KeyClosure(lookupKey), nil, nil)
+		if err != nil {
+			return err
+		}
+		msgTx.TxIn[i].SignatureScript = sigScript
+	}
+
+	tx := asiutil.NewTx(msgTx)
+	acceptedTxs, err := sm.txMemPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		return err
+	}
+	sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
+	log.Infof("Reported evidence %v against %v in transaction %v",
+		hash, offender, tx.Hash())
+	return nil
+}
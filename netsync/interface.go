@@ -10,6 +10,7 @@ import (
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus/signer"
 	"github.com/AsimovNetwork/asimov/crypto"
 	"github.com/AsimovNetwork/asimov/mempool"
 	"github.com/AsimovNetwork/asimov/mining"
@@ -40,9 +41,18 @@ type Config struct {
 	SigMemPool   *mempool.SigPool
 	ChainParams  *chaincfg.Params
 
+	// FeeEstimator records how long the transactions of the pool wait
+	// before being mined in the connected blocks.  It may be nil.
+	FeeEstimator *mempool.FeeEstimator
+
 	DisableCheckpoints bool
 	MaxPeers           int
 
 	Account *crypto.Account
+
+	// Signer signs the blocks of other validators for the account in place
+	// of its private key, nil when they are signed with the private key.
+	Signer signer.Signer
+
 	BroadcastMessage func(msg protos.Message, exclPeers ...interface{})
 }
@@ -14,11 +14,12 @@ import (
 	"sync/atomic"
 	"time"
 
-	"crypto/ecdsa"
 	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus/signer"
 	"github.com/AsimovNetwork/asimov/crypto"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/mempool"
@@ -181,6 +182,7 @@ type SyncManager struct {
 	chain          *blockchain.BlockChain
 	txMemPool      *mempool.TxPool
 	sigMemPool     *mempool.SigPool
+	feeEstimator   *mempool.FeeEstimator
 	chainParams    *chaincfg.Params
 	progressLogger *blockProgressLogger
 	msgChan        chan interface{}
@@ -204,6 +206,7 @@ type SyncManager struct {
 	nextCheckpoint   *chaincfg.Checkpoint
 
 	account      *crypto.Account
+	signer       signer.Signer
 	signedHeight map[int32]interface{}
 	tipHeight    int32
 	BroadcastMessage func(msg protos.Message, exclPeers ...interface{})
@@ -623,20 +626,38 @@ func (sm *SyncManager) handleSigMsg(tmsg *sigMsg) {
 		return
 	}
 
-	err := blockchain.AddressVerifySignature(sig.MsgSign.BlockHash[:], &sig.MsgSign.Signer, sig.MsgSign.Signature[:])
+	// The signer is not covered by the signature: a signature naming the
+	// signing key of a validator is attributed to the validator, so it is
+	// weighted and its double signs detected like the others.
+	validator, err := sm.chain.SignerValidator(sig.MsgSign)
+	if err != nil {
+		log.Debugf("Failed to resolve the validator of signature %v: %v", sigHash, err)
+		return
+	}
+	if validator != sig.MsgSign.Signer {
+		msgSign := *sig.MsgSign
+		msgSign.Signer = validator
+		sig = asiutil.NewBlockSign(&msgSign)
+	}
+
+	err = sm.chain.VerifyBlockSign(sig.MsgSign)
 	if err != nil {
 		log.Debugf("Received sig message with invalid signature")
 		sm.pushErrorMsg(peer, sm.rejectedSigns, sigHash, blockchain.RuleError{})
 		return
 	}
 
-	err = sm.sigMemPool.ProcessSig(tmsg.sig)
+	if err := sm.chain.DetectDoubleSign(sig.MsgSign); err != nil {
+		log.Warnf("Failed to process double sign of signature %v: %v", sigHash, err)
+	}
+
+	err = sm.sigMemPool.ProcessSig(sig)
 	if err != nil {
 		sm.pushErrorMsg(peer, sm.rejectedSigns, sigHash, err)
 		return
 	}
 
-	sm.peerNotifier.AnnounceNewSignature(tmsg.sig)
+	sm.peerNotifier.AnnounceNewSignature(sig)
 }
 
 func (sm *SyncManager) pushErrorMsg(peer *peerpkg.Peer, rejectMap map[common.Hash]struct{}, hash *common.Hash, err error) {
@@ -1352,6 +1373,10 @@ out:
 				sm.handleSigMsg(msg)
 				msg.reply <- struct{}{}
 
+			case *evidenceMsg:
+				sm.handleEvidenceMsg(msg)
+				msg.reply <- struct{}{}
+
 			case *blockMsg:
 				sm.handleBlockMsg(msg)
 				msg.reply <- struct{}{}
@@ -1476,6 +1501,11 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 
 		sm.sigMemPool.ConnectSigns(block.Signs(), block.Height())
 
+		// Register block with the fee estimator, if it exists.
+		if sm.feeEstimator != nil {
+			sm.feeEstimator.RegisterBlock(block)
+		}
+
 		for _, vtx := range vblock.Transactions() {
 			sm.txMemPool.RemoveTransaction(vtx, false)
 			sm.txMemPool.RemoveOrphan(vtx)
@@ -1489,6 +1519,22 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 		}
 		sm.tipHeight = block.Height()
 
+	// An evidence of validator misbehavior has been stored.  Relay it to
+	// the other peers and report it to the evidence registry.
+	case blockchain.NTEvidence:
+		evidence, ok := notification.Data.(*protos.MsgEvidence)
+		if !ok {
+			log.Warnf("Evidence notification is not an evidence.")
+			break
+		}
+
+		sm.BroadcastMessage(evidence)
+		if sm.account != nil {
+			if err := sm.reportEvidence(evidence); err != nil {
+				log.Warnf("Failed to report evidence %v: %v", evidence.Hash(), err)
+			}
+		}
+
 	// A block has been disconnected from the main block chain.
 	case blockchain.NTBlockDisconnected:
 		blocks, ok := notification.Data.([]interface{})
@@ -1689,6 +1735,7 @@ func New(config *Config) (*SyncManager, error) {
 		chain:            config.Chain,
 		txMemPool:        config.TxMemPool,
 		sigMemPool:       config.SigMemPool,
+		feeEstimator:     config.FeeEstimator,
 		chainParams:      config.ChainParams,
 		rejectedTxns:     make(map[common.Hash]struct{}),
 		requestedTxns:    make(map[common.Hash]struct{}),
@@ -1702,9 +1749,14 @@ func New(config *Config) (*SyncManager, error) {
 		quit:             make(chan struct{}),
 		signedHeight:     make(map[int32]interface{}),
 		account:          config.Account,
+		signer:           config.Signer,
 		BroadcastMessage: config.BroadcastMessage,
 	}
 
+	if sm.signer == nil && sm.account != nil {
+		sm.signer = signer.NewLocalSigner(&sm.account.PrivateKey, nil)
+	}
+
 	best := sm.chain.BestSnapshot()
 	if !config.DisableCheckpoints {
 		// Initialize the next checkpoint based on the current height.
@@ -1741,11 +1793,17 @@ func (sm *SyncManager) makeSignature(block *asiutil.Block) {
 	}
 	header := &block.MsgBlock().Header
 	// Verify the block timestamp
-	if header.Timestamp < (time.Now().Unix() - 5 * int64(time.Minute/time.Second)) {
+	if header.Timestamp < (clock.Now().Unix() - 5 * int64(time.Minute/time.Second)) {
+		return
+	}
+	// The node signs for the validator which registered its key as signing
+	// key, or for its own address.
+	validator, err := sm.chain.ValidatorOf(*sm.account.Address, header.Round)
+	if err != nil {
 		return
 	}
 	// self mined block is needn't make signature
-	if header.CoinBase == *sm.account.Address {
+	if header.CoinBase == validator {
 		return
 	}
 	//get the validators of current block:
@@ -1757,13 +1815,13 @@ func (sm *SyncManager) makeSignature(block *asiutil.Block) {
 		return
 	}
 
-	if _, ok := weightMap[*sm.account.Address]; !ok {
+	if _, ok := weightMap[validator]; !ok {
 		return
 	}
 
 	blockHash := block.MsgBlock().BlockHash()
 
-	signature, err := crypto.Sign(blockHash[:], (*ecdsa.PrivateKey)(&sm.account.PrivateKey))
+	signature, err := sm.signer.SignBlock(header)
 	if err != nil {
 		log.Errorf("Sign error:%s.", err)
 		return
@@ -1773,7 +1831,7 @@ func (sm *SyncManager) makeSignature(block *asiutil.Block) {
 	copy(sigMsg.Signature[:], signature)
 	sigMsg.BlockHeight = header.Height
 	sigMsg.BlockHash = blockHash
-	sigMsg.Signer = *sm.account.Address
+	sigMsg.Signer = validator
 
 	sig := asiutil.NewBlockSign(&sigMsg)
 
@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
@@ -125,6 +126,9 @@ type MessageListeners struct {
 	// OnSig is invoked when a peer receives a signature bitcoin message.
 	OnSig func(p *Peer, msg *protos.MsgBlockSign)
 
+	// OnEvidence is invoked when a peer receives an evidence message.
+	OnEvidence func(p *Peer, msg *protos.MsgEvidence)
+
 	// OnBlock is invoked when a peer receives a block bitcoin message.
 	OnBlock func(p *Peer, msg *protos.MsgBlock, buf []byte)
 
@@ -273,6 +277,12 @@ type Config struct {
 	// not send inv messages for transactions.
 	DisableRelayTx bool
 
+	// AllowSelfConns disables the detection of self connections.  The
+	// nonces of the version messages are shared by the peers of the
+	// process, so it is required to connect several nodes running in the
+	// same process.
+	AllowSelfConns bool
+
 	// Listeners houses callback functions to be invoked on receiving peer
 	// messages.
 	Listeners MessageListeners
@@ -1063,7 +1073,7 @@ func (p *Peer) handleRemoteVersionMsg(msg *protos.MsgVersion) error {
 	}
 
 	// Detect self connections.
-	if !allowSelfConns && sentNonces.Exists(msg.Nonce) {
+	if !allowSelfConns && !p.cfg.AllowSelfConns && sentNonces.Exists(msg.Nonce) {
 		return errors.New("disconnecting peer connected to self")
 	}
 
@@ -1084,7 +1094,7 @@ func (p *Peer) handleRemoteVersionMsg(msg *protos.MsgVersion) error {
 	p.statsMtx.Lock()
 	p.lastBlock = msg.LastBlock
 	p.startingHeight = msg.LastBlock
-	p.timeOffset = msg.Timestamp - time.Now().Unix()
+	p.timeOffset = msg.Timestamp - clock.Now().Unix()
 	p.statsMtx.Unlock()
 
 	// Negotiate the protocol version.
@@ -1516,6 +1526,10 @@ out:
 			if p.cfg.Listeners.OnSig != nil {
 				p.cfg.Listeners.OnSig(p, msg)
 			}
+		case *protos.MsgEvidence:
+			if p.cfg.Listeners.OnEvidence != nil {
+				p.cfg.Listeners.OnEvidence(p, msg)
+			}
 		case *protos.MsgBlock:
 			if p.cfg.Listeners.OnBlock != nil {
 				p.cfg.Listeners.OnBlock(p, msg, buf)
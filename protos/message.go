@@ -54,6 +54,7 @@ const (
 	CmdCFilter      = "cfilter"
 	CmdCFHeaders    = "cfheaders"
 	CmdCFCheckpt    = "cfcheckpt"
+	CmdEvidence     = "evidence"
 )
 
 // MessageEncoding represents the protos message encoding format to be used.
@@ -172,6 +173,9 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdCFCheckpt:
 		msg = &MsgCFCheckpt{}
 
+	case CmdEvidence:
+		msg = &MsgEvidence{}
+
 	default:
 		return nil, fmt.Errorf("unhandled command [%s]", command)
 	}
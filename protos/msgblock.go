@@ -27,6 +27,9 @@ const MaxBlocksPerMsg = 500
 // MaxBlockPayload is the maximum bytes a block message can be in bytes.
 const MaxBlockPayload = common.MaxBlockSize
 
+// maxVrfProofSize is the maximum size of the vrf proof of a block.
+const maxVrfProofSize = 128
+
 // maxTxPerBlock is the maximum number of transactions that could
 // possibly fit into a block.
 const maxTxPerBlock = (MaxBlockPayload / minTxPayload) + 1
@@ -47,6 +50,12 @@ type MsgBlock struct {
 	Bloom        types.Bloom
 	Transactions []*MsgTx
 	PreBlockSigs BlockSignList // collect signatures for ancestors
+
+	// VrfProof is the vrf proof of the producer of the block, which is
+	// published once the vrf is activated.  It extends the header: it is
+	// committed by the PoaHash and only serialized when present, so the
+	// blocks without it keep their encoding.
+	VrfProof []byte
 }
 
 // AddTransaction adds a transaction to the message.
@@ -114,7 +123,13 @@ func (msg *MsgBlock) VVSDecode(r io.Reader, pver uint32, enc MessageEncoding) er
 		msg.PreBlockSigs[i] = &msgSigns[i]
 	}
 
-	return nil
+	msg.VrfProof, err = serialization.ReadVarBytes(r, pver, maxVrfProofSize, "VrfProof")
+	if err == io.EOF {
+		msg.VrfProof, err = nil, nil
+	} else if err == nil && len(msg.VrfProof) == 0 {
+		return messageError("MsgBlock.VVSDecode", "empty vrf proof")
+	}
+	return err
 }
 
 // Deserialize decodes a block from r into the receiver using a format that is
@@ -231,6 +246,9 @@ func (msg *MsgBlock) VVSEncode(w io.Writer, pver uint32, enc MessageEncoding) er
 		}
 	}
 
+	if len(msg.VrfProof) > 0 {
+		return serialization.WriteVarBytes(w, pver, msg.VrfProof)
+	}
 	return nil
 }
 
@@ -271,6 +289,10 @@ func (msg *MsgBlock) SerializeSize() int {
 		n += sig.SerializeSize()
 	}
 
+	if len(msg.VrfProof) > 0 {
+		n += serialization.VarIntSerializeSize(uint64(len(msg.VrfProof))) +
+			len(msg.VrfProof)
+	}
 	return n
 }
 
@@ -309,6 +331,7 @@ func (msg *MsgBlock) CalculatePoaHash() common.Hash {
 	for _, sig := range msg.PreBlockSigs {
 		buflen += sig.SerializeSize()
 	}
+	buflen += len(msg.VrfProof)
 
 	buf := bytes.NewBuffer(make([]byte, 0, buflen))
 	serialization.WriteNBytes(buf, msg.ReceiptHash[:])
@@ -316,6 +339,7 @@ func (msg *MsgBlock) CalculatePoaHash() common.Hash {
 	for _, sig := range msg.PreBlockSigs {
 		sig.Serialize(buf)
 	}
+	serialization.WriteNBytes(buf, msg.VrfProof)
 
 	return common.DoubleHashH(buf.Bytes())
 }
@@ -0,0 +1,195 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+)
+
+// EvidenceType identifies the kind of misbehavior an evidence proves.
+type EvidenceType uint8
+
+const (
+	// EvidenceDoubleProposal proves a validator produced two different
+	// blocks for the same round and slot.
+	EvidenceDoubleProposal EvidenceType = 1
+
+	// EvidenceDoubleSign proves a validator signed two different blocks at
+	// the same height.
+	EvidenceDoubleSign EvidenceType = 2
+)
+
+// String returns the EvidenceType in human-readable form.
+func (t EvidenceType) String() string {
+	switch t {
+	case EvidenceDoubleProposal:
+		return "doubleproposal"
+	case EvidenceDoubleSign:
+		return "doublesign"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(t))
+}
+
+// maxEvidencePayload is the maximum bytes an evidence message can be, the
+// type and a pair of block headers.
+const maxEvidencePayload = 1 + 2*BlockHeaderPayload
+
+// MsgEvidence implements the Message interface and represents an asimov
+// evidence message.  It carries a pair of conflicting block headers or block
+// signatures, both signed by the same validator, which together prove the
+// validator misbehaved.
+//
+// Only the pair matching the type is encoded.  The pair is ordered by block
+// hash so the same misbehavior always produces the same evidence.
+type MsgEvidence struct {
+	Type    EvidenceType
+	Headers [2]BlockHeader
+	Signs   [2]MsgBlockSign
+}
+
+// NewMsgDoubleProposal returns a new evidence message proving the coinbase
+// of the passed headers proposed both of them.
+func NewMsgDoubleProposal(a, b *BlockHeader) *MsgEvidence {
+	msg := &MsgEvidence{Type: EvidenceDoubleProposal}
+	ha, hb := a.BlockHash(), b.BlockHash()
+	if bytes.Compare(ha[:], hb[:]) > 0 {
+		a, b = b, a
+	}
+	msg.Headers[0], msg.Headers[1] = *a, *b
+	return msg
+}
+
+// NewMsgDoubleSign returns a new evidence message proving the signer of the
+// passed signatures signed both blocks.
+func NewMsgDoubleSign(a, b *MsgBlockSign) *MsgEvidence {
+	msg := &MsgEvidence{Type: EvidenceDoubleSign}
+	if bytes.Compare(a.BlockHash[:], b.BlockHash[:]) > 0 {
+		a, b = b, a
+	}
+	msg.Signs[0], msg.Signs[1] = *a, *b
+	return msg
+}
+
+// Offender returns the address of the validator the evidence accuses.
+func (msg *MsgEvidence) Offender() common.Address {
+	if msg.Type == EvidenceDoubleProposal {
+		return msg.Headers[0].CoinBase
+	}
+	return msg.Signs[0].Signer
+}
+
+// Height returns the height of the first block of the evidence.
+func (msg *MsgEvidence) Height() int32 {
+	if msg.Type == EvidenceDoubleProposal {
+		return msg.Headers[0].Height
+	}
+	return msg.Signs[0].BlockHeight
+}
+
+// BlockHashes returns the hashes of the conflicting blocks.
+func (msg *MsgEvidence) BlockHashes() [2]common.Hash {
+	if msg.Type == EvidenceDoubleProposal {
+		return [2]common.Hash{msg.Headers[0].BlockHash(), msg.Headers[1].BlockHash()}
+	}
+	return [2]common.Hash{msg.Signs[0].BlockHash, msg.Signs[1].BlockHash}
+}
+
+// Hash returns the identifier of the evidence, the double sha256 of its
+// encoding.
+func (msg *MsgEvidence) Hash() common.Hash {
+	buf := bytes.NewBuffer(make([]byte, 0, msg.SerializeSize()))
+	_ = msg.Serialize(buf)
+	return common.DoubleHashH(buf.Bytes())
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// evidence.
+func (msg *MsgEvidence) SerializeSize() int {
+	if msg.Type == EvidenceDoubleProposal {
+		return 1 + 2*BlockHeaderPayload
+	}
+	return 1 + 2*fixedBlockSignPayloadLen
+}
+
+// Serialize encodes the evidence to w using a format that is suitable for
+// long-term storage such as a database.
+func (msg *MsgEvidence) Serialize(w io.Writer) error {
+	if err := serialization.WriteUint8(w, uint8(msg.Type)); err != nil {
+		return err
+	}
+	switch msg.Type {
+	case EvidenceDoubleProposal:
+		for i := range msg.Headers {
+			if err := msg.Headers[i].Serialize(w); err != nil {
+				return err
+			}
+		}
+	case EvidenceDoubleSign:
+		for i := range msg.Signs {
+			if err := msg.Signs[i].Serialize(w); err != nil {
+				return err
+			}
+		}
+	default:
+		str := fmt.Sprintf("unknown evidence type %d", msg.Type)
+		return messageError("MsgEvidence.Serialize", str)
+	}
+	return nil
+}
+
+// Deserialize decodes the evidence from r into the receiver using a format
+// that is suitable for long-term storage such as a database.
+func (msg *MsgEvidence) Deserialize(r io.Reader) error {
+	if err := serialization.ReadUint8(r, (*uint8)(&msg.Type)); err != nil {
+		return err
+	}
+	switch msg.Type {
+	case EvidenceDoubleProposal:
+		for i := range msg.Headers {
+			if err := msg.Headers[i].Deserialize(r); err != nil {
+				return err
+			}
+		}
+	case EvidenceDoubleSign:
+		for i := range msg.Signs {
+			if err := msg.Signs[i].Deserialize(r); err != nil {
+				return err
+			}
+		}
+	default:
+		str := fmt.Sprintf("unknown evidence type %d", msg.Type)
+		return messageError("MsgEvidence.Deserialize", str)
+	}
+	return nil
+}
+
+// VVSDecode decodes r using the asimov protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgEvidence) VVSDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	return msg.Deserialize(r)
+}
+
+// VVSEncode encodes the receiver to w using the asimov protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgEvidence) VVSEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	return msg.Serialize(w)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgEvidence) Command() string {
+	return CmdEvidence
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgEvidence) MaxPayloadLength(pver uint32) uint32 {
+	return maxEvidencePayload
+}
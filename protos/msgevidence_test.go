@@ -0,0 +1,84 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// TestEvidence tests the MsgEvidence API and its encode and decode.
+func TestEvidence(t *testing.T) {
+	pver := common.ProtocolVersion
+	signer := common.Address{0x66, 0x01}
+
+	headerA := BlockHeader{Height: 10, Round: 2, SlotIndex: 3, CoinBase: signer, Timestamp: 1}
+	headerB := headerA
+	headerB.Timestamp = 2
+	signA := MsgBlockSign{BlockHeight: 10, BlockHash: common.Hash{0x01}, Signer: signer}
+	signB := MsgBlockSign{BlockHeight: 10, BlockHash: common.Hash{0x02}, Signer: signer}
+
+	tests := []struct {
+		in      *MsgEvidence
+		swapped *MsgEvidence
+		size    int
+	}{
+		{
+			NewMsgDoubleProposal(&headerA, &headerB),
+			NewMsgDoubleProposal(&headerB, &headerA),
+			1 + 2*BlockHeaderPayload,
+		},
+		{
+			NewMsgDoubleSign(&signB, &signA),
+			NewMsgDoubleSign(&signA, &signB),
+			1 + 2*fixedBlockSignPayloadLen,
+		},
+	}
+
+	for i, test := range tests {
+		// The order of the conflicting items must not change the evidence.
+		if test.in.Hash() != test.swapped.Hash() {
+			t.Errorf("#%d: hash depends on the order of the items", i)
+		}
+		hashes := test.in.BlockHashes()
+		if bytes.Compare(hashes[0][:], hashes[1][:]) >= 0 {
+			t.Errorf("#%d: items are not ordered by block hash", i)
+		}
+		if test.in.Offender() != signer || test.in.Height() != 10 {
+			t.Errorf("#%d: wrong offender %v or height %d", i,
+				test.in.Offender(), test.in.Height())
+		}
+		if cmd := test.in.Command(); cmd != "evidence" {
+			t.Errorf("#%d: wrong command - got %v want evidence", i, cmd)
+		}
+
+		var buf bytes.Buffer
+		if err := test.in.VVSEncode(&buf, pver, BaseEncoding); err != nil {
+			t.Errorf("VVSEncode #%d error %v", i, err)
+			continue
+		}
+		if buf.Len() != test.size || buf.Len() > int(test.in.MaxPayloadLength(pver)) {
+			t.Errorf("VVSEncode #%d: wrong size %d, want %d", i, buf.Len(), test.size)
+		}
+
+		var msg MsgEvidence
+		if err := msg.VVSDecode(bytes.NewReader(buf.Bytes()), pver, BaseEncoding); err != nil {
+			t.Errorf("VVSDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.in) {
+			t.Errorf("VVSDecode #%d\n got: %v want: %v", i, msg, test.in)
+		}
+	}
+
+	// Unknown evidence types are rejected.
+	var msg MsgEvidence
+	if err := msg.VVSDecode(bytes.NewReader([]byte{0x07}), pver, BaseEncoding); err == nil {
+		t.Errorf("VVSDecode: unknown evidence type was accepted")
+	}
+}
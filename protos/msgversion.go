@@ -8,11 +8,11 @@ package protos
 import (
 	"bytes"
 	"fmt"
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/common/serialization"
 	"io"
 	"strings"
-	"time"
 	"unsafe"
 )
 
@@ -226,7 +226,7 @@ func NewMsgVersion(me *NetAddress, you *NetAddress, nonce uint64,
 	return &MsgVersion{
 		ProtocolVersion: common.ProtocolVersion,
 		Services:        0,
-		Timestamp:       time.Now().Unix(),
+		Timestamp:       clock.Now().Unix(),
 		AddrYou:         *you,
 		AddrMe:          *me,
 		Nonce:           nonce,
@@ -8,7 +8,12 @@
 // a chain server.
 package rpcjson
 
-import "math/big"
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+)
 
 
 // AddNodeSubCmd defines the type used in the addnode JSON-RPC command for the
@@ -54,3 +59,66 @@ type TestCallData struct {
 	AmountB   big.Int `json:"amountb"`
 	VoteValue string  `json:"voteValue"`
 }
+
+// FilterCriteria models the criteria of the getLogs and newFilter commands.
+// A log matches when it was emitted by one of the addresses and carries, at
+// every position of topics, one of the listed topics.  Empty lists match
+// anything.  BlockHash and the FromBlock/ToBlock range are mutually exclusive.
+type FilterCriteria struct {
+	BlockHash *string    `json:"blockHash"`
+	FromBlock *int32     `json:"fromBlock"`
+	ToBlock   *int32     `json:"toBlock"`
+	Addresses []string   `json:"address"`
+	Topics    [][]string `json:"topics"`
+}
+
+// TraceConfig models the options of the debug tracing commands.  Tracer
+// selects the output, "callTracer" returns the call tree while an empty value
+// returns the opcode level trace which may be trimmed by the other fields.
+type TraceConfig struct {
+	Tracer         string `json:"tracer"`
+	DisableStorage bool   `json:"disableStorage"`
+	DisableMemory  bool   `json:"disableMemory"`
+	DisableStack   bool   `json:"disableStack"`
+	Limit          int    `json:"limit"`
+}
+
+// BlockRef models the optional block argument of the read-only contract call
+// commands.  It is either a block height, given as a JSON number or a decimal
+// string, or a block hash given as a hex string.  The string "latest" refers
+// to the best block and the string "finalized" to the last finalized block.
+type BlockRef struct {
+	Height    *int32
+	Hash      *string
+	Finalized bool
+}
+
+// UnmarshalJSON decodes a block height or a block hash.
+func (r *BlockRef) UnmarshalJSON(data []byte) error {
+	var height int32
+	if err := json.Unmarshal(data, &height); err == nil {
+		r.Height = &height
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return errors.New("block must be a height or a block hash")
+	}
+	switch {
+	case str == "latest" || str == "":
+		return nil
+	case str == "finalized":
+		r.Finalized = true
+	case len(str) < 64:
+		h, err := strconv.ParseInt(str, 10, 32)
+		if err != nil {
+			return errors.New("invalid block height: " + str)
+		}
+		height = int32(h)
+		r.Height = &height
+	default:
+		r.Hash = &str
+	}
+	return nil
+}
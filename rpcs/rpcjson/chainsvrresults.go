@@ -382,3 +382,266 @@ type GetBalancesResult struct {
 	Address string             `json:"address"`
 	Assets  []GetBalanceResult `json:"assets"`
 }
+
+// AssetHolderResult models a holder of an asset returned by the
+// getassetholders command.
+type AssetHolderResult struct {
+	Address string `json:"address"`
+	Value   string `json:"value"`
+}
+
+// GetAssetHoldersResult models the data from the getassetholders command.
+type GetAssetHoldersResult struct {
+	Asset   string              `json:"asset"`
+	Total   uint32              `json:"total"`
+	Holders []AssetHolderResult `json:"holders"`
+}
+
+// RegisteredAssetResult models an asset returned by the listassets and
+// getassetsbyorganization commands.
+type RegisteredAssetResult struct {
+	Asset          string `json:"asset"`
+	OrganizationId uint32 `json:"organizationid"`
+	Organization   string `json:"organization,omitempty"`
+	Indivisible    bool   `json:"indivisible"`
+	CreationHeight int32  `json:"creationheight"`
+	CreationTxid   string `json:"creationtxid"`
+	Mints          uint32 `json:"mints"`
+}
+
+// ListAssetsResult models the data from the listassets command.
+type ListAssetsResult struct {
+	Total  uint32                  `json:"total"`
+	Assets []RegisteredAssetResult `json:"assets"`
+}
+
+// AssetMintResult models a mint returned by the getassetminthistory command.
+type AssetMintResult struct {
+	Height int32  `json:"height"`
+	Txid   string `json:"txid"`
+	Amount string `json:"amount"`
+}
+
+// AssetSupplyResult models a supply change returned by the
+// getassetsupplyhistory command.
+type AssetSupplyResult struct {
+	Height  int32  `json:"height"`
+	Supply  string `json:"supply"`
+	Holders uint32 `json:"holders"`
+}
+
+// BlockNotificationResult models the payload of a newBlocks subscription.
+type BlockNotificationResult struct {
+	Hash         string `json:"hash"`
+	Height       int32  `json:"height"`
+	PreviousHash string `json:"previousblockhash"`
+	StateRoot    string `json:"stateroot"`
+	Time         int64  `json:"time"`
+	Round        uint32 `json:"round"`
+	Slot         uint16 `json:"slot"`
+	GasLimit     uint64 `json:"gaslimit"`
+	GasUsed      uint64 `json:"gasused"`
+	TxCount      int    `json:"txCount"`
+}
+
+// ReorgNotificationResult models the payload of a reorgs subscription.
+type ReorgNotificationResult struct {
+	ForkHash   string   `json:"forkhash"`
+	ForkHeight int32    `json:"forkheight"`
+	OldTip     string   `json:"oldtip"`
+	OldHeight  int32    `json:"oldheight"`
+	NewTip     string   `json:"newtip"`
+	NewHeight  int32    `json:"newheight"`
+	Detached   []string `json:"detached"`
+	Attached   []string `json:"attached"`
+}
+
+// PendingTxNotificationResult models the payload of a pendingTransactions
+// subscription.
+type PendingTxNotificationResult struct {
+	Txid     string  `json:"txid"`
+	Size     int     `json:"size"`
+	Fee      int64   `json:"fee"`
+	GasPrice float64 `json:"gasprice"`
+	Time     int64   `json:"time"`
+}
+
+// AddressActivityResult models the payload of an addressActivity
+// subscription.  Each payload describes one output received by or spent from
+// a watched address.  BlockHash is empty for mempool transactions and Removed
+// is set when the containing block was disconnected from the main chain.
+type AddressActivityResult struct {
+	Address   string `json:"address"`
+	Txid      string `json:"txid"`
+	Vout      uint32 `json:"vout"`
+	Spent     bool   `json:"spent"`
+	Asset     string `json:"asset"`
+	Amount    int64  `json:"amount"`
+	BlockHash string `json:"blockhash,omitempty"`
+	Height    int32  `json:"height,omitempty"`
+	Removed   bool   `json:"removed"`
+}
+
+// StructLogResult models an opcode step of a traced execution.
+type StructLogResult struct {
+	Pc      uint64            `json:"pc"`
+	Op      string            `json:"op"`
+	Gas     uint64            `json:"gas"`
+	GasCost uint64            `json:"gasCost"`
+	Depth   int               `json:"depth"`
+	Error   string            `json:"error,omitempty"`
+	Stack   []string          `json:"stack,omitempty"`
+	Memory  []string          `json:"memory,omitempty"`
+	Storage map[string]string `json:"storage,omitempty"`
+}
+
+// ExecutionResult models the opcode level trace of an execution.
+type ExecutionResult struct {
+	Gas         uint64            `json:"gas"`
+	Failed      bool              `json:"failed"`
+	ReturnValue string            `json:"returnValue"`
+	StructLogs  []StructLogResult `json:"structLogs"`
+}
+
+// VTransferResult models an asset transfer, creation or mint appended to
+// the virtual transaction by a contract execution.
+type VTransferResult struct {
+	Type   string `json:"type"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to"`
+	Amount int64  `json:"amount"`
+	Asset  string `json:"asset"`
+}
+
+// CallTraceResult models a frame of the call tree of a traced execution.
+type CallTraceResult struct {
+	Type      string            `json:"type"`
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Value     string            `json:"value"`
+	Asset     string            `json:"asset,omitempty"`
+	Gas       uint64            `json:"gas"`
+	GasUsed   uint64            `json:"gasUsed"`
+	Input     string            `json:"input"`
+	Output    string            `json:"output,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Calls     []CallTraceResult `json:"calls,omitempty"`
+	Transfers []VTransferResult `json:"transfers,omitempty"`
+}
+
+// TxTraceResult models the trace of a transaction returned by the
+// debug_traceTransaction and debug_traceBlock commands.  Result holds an
+// ExecutionResult or the list of top level CallTraceResult depending on the
+// requested tracer.
+type TxTraceResult struct {
+	Txid    string             `json:"txid"`
+	Index   int                `json:"index"`
+	GasUsed uint64             `json:"gasUsed"`
+	Failed  bool               `json:"failed"`
+	Result  interface{}        `json:"result"`
+	VTX     *TxRawDecodeResult `json:"vtx,omitempty"`
+}
+
+// CallTraceCallResult models the result of the debug_traceCall command.
+type CallTraceCallResult struct {
+	GasUsed   uint64            `json:"gasUsed"`
+	Failed    bool              `json:"failed"`
+	Result    interface{}       `json:"result"`
+	Transfers []VTransferResult `json:"transfers"`
+}
+
+// StorageProofResult models the merkle proof of a storage slot returned by
+// the getproof command.
+type StorageProofResult struct {
+	Key   string   `json:"key"`
+	Value string   `json:"value"`
+	Proof []string `json:"proof"`
+}
+
+// AccountProofResult models the result of the getproof command.  The proofs
+// are lists of RLP encoded trie nodes, from the root down, against StateRoot
+// for the account and against StorageHash for the storage slots.
+type AccountProofResult struct {
+	Address      string               `json:"address"`
+	BlockHash    string               `json:"blockhash"`
+	Height       int32                `json:"height"`
+	StateRoot    string               `json:"stateroot"`
+	AccountProof []string             `json:"accountProof"`
+	Balance      string               `json:"balance"`
+	Nonce        uint64               `json:"nonce"`
+	CodeHash     string               `json:"codeHash"`
+	StorageHash  string               `json:"storageHash"`
+	StorageProof []StorageProofResult `json:"storageProof"`
+}
+
+// TxOutSetAssetResult models the unspent outputs of an asset returned by the
+// gettxoutsetinfo command.  The amount of an indivisible asset is not summed.
+type TxOutSetAssetResult struct {
+	Asset  string `json:"asset"`
+	TxOuts int64  `json:"txouts"`
+	Amount string `json:"amount"`
+}
+
+// GetTxOutSetInfoResult models the result of the gettxoutsetinfo command.
+type GetTxOutSetInfoResult struct {
+	Height       int32                 `json:"height"`
+	BestBlock    string                `json:"bestblock"`
+	TxOuts       int64                 `json:"txouts"`
+	UtxoSetHash  string                `json:"utxosethash"`
+	SnapshotHash string                `json:"snapshothash"`
+	Assets       []TxOutSetAssetResult `json:"assets"`
+}
+
+// EvidenceResult models an evidence of validator misbehavior returned by the
+// getevidence and listevidence commands.  The conflicting block headers or
+// block signatures are hex encoded, as is the whole evidence.
+type EvidenceResult struct {
+	Hash       string   `json:"hash"`
+	Type       string   `json:"type"`
+	Offender   string   `json:"offender"`
+	Height     int32    `json:"height"`
+	Blocks     []string `json:"blocks"`
+	Headers    []string `json:"headers,omitempty"`
+	Signatures []string `json:"signatures,omitempty"`
+	Hex        string   `json:"hex"`
+}
+
+// ValidatorRoundStatsResult models the statistics of a validator in a round
+// returned by the getvalidatorstats command.  The latency is the average
+// number of seconds between the start of the slots of the validator and the
+// timestamps of its blocks.
+type ValidatorRoundStatsResult struct {
+	Round          uint32  `json:"round,omitempty"`
+	Produced       uint32  `json:"produced"`
+	Missed         uint32  `json:"missed"`
+	Signed         uint32  `json:"signed"`
+	AverageLatency float64 `json:"averagelatency"`
+}
+
+// GetValidatorStatsResult models the result of the getvalidatorstats
+// command: the statistics of a validator over the rounds, and in each round
+// it has any.
+type GetValidatorStatsResult struct {
+	Address   string `json:"address"`
+	FromRound uint32 `json:"fromround"`
+	ToRound   uint32 `json:"toround"`
+	ValidatorRoundStatsResult
+	Rounds []ValidatorRoundStatsResult `json:"rounds"`
+}
+
+// RoundSlotResult models a slot of a round returned by the getroundschedule
+// command, with the block of the main chain produced in it, if any.
+type RoundSlotResult struct {
+	Slot      uint16 `json:"slot"`
+	Validator string `json:"validator"`
+	Block     string `json:"block,omitempty"`
+	Height    int32  `json:"height,omitempty"`
+	Missed    bool   `json:"missed"`
+}
+
+// SaveMempoolResult models the result of the savemempool command: the file
+// the transactions of the memory pool were saved to and how many.
+type SaveMempoolResult struct {
+	Filename     string `json:"filename"`
+	Transactions int    `json:"transactions"`
+}
@@ -34,5 +34,7 @@ const (
 	ErrRPCNoTxInfo            RPCErrorCode = -204
 	ErrRPCInvalidTxVout       RPCErrorCode = -205
 	ErrRPCDecodeHexString     RPCErrorCode = -206
+	ErrRPCStatePruned         RPCErrorCode = -207
+	ErrRPCEvidenceNotFound    RPCErrorCode = -208
 )
 
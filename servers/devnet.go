@@ -0,0 +1,852 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/asiutil/clock"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/connmgr"
+	"github.com/AsimovNetwork/asimov/consensus/satoshiplus/minersync"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/database/dbdriver"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/peer"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+const (
+	// devnetRounds is the number of rounds the bitcoin chain of a devnet
+	// provides miners for.
+	devnetRounds = 256
+
+	// devnetBasePort is the port of the address of the first node of a
+	// devnet.  The addresses are only reported by the connections, no
+	// port is ever bound.
+	devnetBasePort = 18800
+
+	// devnetQueueSize is the number of messages a link holds in each
+	// direction before it stops reading from the sender.
+	devnetQueueSize = 1024
+
+	// defaultDevnetSettleSteps is the default number of times the devnet
+	// yields to the nodes while waiting for them to be idle.  The nodes
+	// usually settle within a few steps.
+	defaultDevnetSettleSteps = 10000
+)
+
+// DevnetConfig is the configuration of a Devnet.
+type DevnetConfig struct {
+	// Validators is the number of nodes, each of them a validator of the
+	// network.
+	Validators int
+
+	// RoundSize is the number of slots of a round.  It defaults to twice
+	// the number of validators.
+	RoundSize uint16
+
+	// GenesisBlockFile is the file of the genesis block of the develop
+	// network, which the nodes start from.
+	GenesisBlockFile string
+
+	// DataDir is the directory the databases of the nodes are created in.
+	// It defaults to a temporary directory removed when the devnet stops.
+	DataDir string
+
+	// StartTime is the time the simulated clock starts at, which is also
+	// the start time of the chain.  It defaults to the time of the
+	// genesis block.
+	StartTime time.Time
+
+	// Seed derives the keys of the validators and the messages the links
+	// drop, so a scenario runs the same way every time.
+	Seed int64
+
+	// SettleSteps bounds the number of times the devnet yields to the
+	// nodes while waiting for them to be idle, so a node which never stops
+	// working does not hang the scenario.  It defaults to ten thousand.
+	SettleSteps int
+}
+
+// DevnetNode is a full node of a Devnet.
+type DevnetNode struct {
+	index   int
+	key     string
+	address common.Address
+	netAddr *net.TCPAddr
+	dataDir string
+
+	server  *NodeServer
+	db      database.Database
+	stateDB *ethdb.LDBDatabase
+}
+
+// Index returns the index of the node in the devnet.
+func (n *DevnetNode) Index() int {
+	return n.index
+}
+
+// Address returns the address of the validator of the node.
+func (n *DevnetNode) Address() common.Address {
+	return n.address
+}
+
+// Running returns whether the node is started.
+func (n *DevnetNode) Running() bool {
+	return n.server != nil
+}
+
+// Chain returns the block chain of the node, nil when it is stopped.
+func (n *DevnetNode) Chain() *blockchain.BlockChain {
+	if n.server == nil {
+		return nil
+	}
+	return n.server.chain
+}
+
+// BestSnapshot returns the best state of the chain of the node, nil when it
+// is stopped.
+func (n *DevnetNode) BestSnapshot() *blockchain.BestState {
+	if n.server == nil {
+		return nil
+	}
+	return n.server.chain.BestSnapshot()
+}
+
+// devnetConn is a connection of a node to a link, which reports the loopback
+// addresses of the nodes instead of the addresses of a pipe.
+type devnetConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+// LocalAddr returns the address of the node owning the connection.
+func (c *devnetConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr returns the address of the node at the other end of the link.
+func (c *devnetConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// devnetWire is a live connection of a link.
+type devnetWire struct {
+	conns []net.Conn
+	quit  chan struct{}
+	once  sync.Once
+}
+
+// close disconnects both nodes of the wire.
+func (w *devnetWire) close() {
+	w.once.Do(func() {
+		close(w.quit)
+		for _, conn := range w.conns {
+			conn.Close()
+		}
+	})
+}
+
+// devnetLink connects two nodes of a devnet.  The node a dials the node b.
+// The rules of the direction 0 apply to the messages from a to b, those of
+// the direction 1 to the messages from b to a.
+type devnetLink struct {
+	a, b  int
+	delay [2]time.Duration
+	loss  [2]float64
+	cut   bool
+	wire  *devnetWire
+}
+
+// devnetMsg is a message queued by a link.
+type devnetMsg struct {
+	msg   protos.Message
+	timer clock.Timer
+}
+
+// Devnet is a network of validators running in the process, for testing the
+// consensus offline.  The nodes run on a simulated clock the devnet advances,
+// follow a bitcoin chain it mines beforehand, and talk through links which
+// can be cut, delayed or made lossy.
+//
+// A devnet replaces the clock and the network parameters of the process while
+// it runs, so only one devnet may run at a time, and no other node.
+type Devnet struct {
+	cfg     DevnetConfig
+	clock   *clock.Simulated
+	tempDir bool
+
+	params    chaincfg.Params
+	fconfig   chaincfg.FConfig
+	btcParams minersync.BtcChainParams
+	btcSource *minersync.FakeBtcSource
+
+	prevParams *chaincfg.Params
+	prevCfg    *chaincfg.FConfig
+
+	mtx      sync.Mutex
+	rand     *rand.Rand
+	nodes    []*DevnetNode
+	links    map[[2]int]*devnetLink
+	started  bool
+	activity uint64
+
+	// settleErr is the first time the nodes did not settle, reported by
+	// Err.  It is only used by the goroutine running the scenario.
+	settleErr error
+}
+
+// NewDevnet returns a devnet of validators connected to each other.  The
+// network parameters are those of the develop network, with the validators
+// as candidates of every round.
+func NewDevnet(cfg *DevnetConfig) (*Devnet, error) {
+	if cfg.Validators < 1 {
+		return nil, errors.New("a devnet requires a validator")
+	}
+	genesisBlock, err := asiutil.LoadBlockFromFile(cfg.GenesisBlockFile)
+	if err != nil {
+		return nil, fmt.Errorf("load genesis block error: %v", err)
+	}
+	if hash := genesisBlock.BlockHash(); hash != *chaincfg.DevelopNetParams.GenesisHash {
+		return nil, fmt.Errorf("genesis block %s is not the develop network one", hash)
+	}
+
+	d := &Devnet{
+		cfg:   *cfg,
+		rand:  rand.New(rand.NewSource(cfg.Seed)),
+		links: make(map[[2]int]*devnetLink),
+	}
+	if d.cfg.RoundSize == 0 {
+		d.cfg.RoundSize = uint16(2 * d.cfg.Validators)
+	}
+	if d.cfg.StartTime.IsZero() {
+		d.cfg.StartTime = time.Unix(genesisBlock.Header.Timestamp, 0)
+	}
+	d.clock = clock.NewSimulated(d.cfg.StartTime)
+	if d.cfg.SettleSteps == 0 {
+		d.cfg.SettleSteps = defaultDevnetSettleSteps
+	}
+	if d.cfg.DataDir == "" {
+		d.cfg.DataDir, err = ioutil.TempDir("", "devnet")
+		if err != nil {
+			return nil, err
+		}
+		d.tempDir = true
+	}
+
+	for i := 0; i < d.cfg.Validators; i++ {
+		var buf [16]byte
+		binary.BigEndian.PutUint64(buf[:8], uint64(d.cfg.Seed))
+		binary.BigEndian.PutUint64(buf[8:], uint64(i))
+		privKey := sha256.Sum256(buf[:])
+		key := hexutil.Encode(privKey[:])
+		acc, err := crypto.NewAccount(key)
+		if err != nil {
+			return nil, err
+		}
+		d.nodes = append(d.nodes, &DevnetNode{
+			index:   i,
+			key:     key,
+			address: *acc.Address,
+			netAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: devnetBasePort + i},
+			dataDir: filepath.Join(d.cfg.DataDir, fmt.Sprintf("node%d", i)),
+		})
+		for j := 0; j < i; j++ {
+			d.links[[2]int{j, i}] = &devnetLink{a: j, b: i}
+		}
+	}
+
+	// Every round lasts as long as a bitcoin block, so the slots of the
+	// rounds after the first ones last the default block interval too.
+	roundDuration := time.Duration(d.cfg.RoundSize) * common.DefaultBlockInterval * time.Second
+	d.params = chaincfg.DevelopNetParams
+	d.params.GenesisCandidates = make([]common.Address, 0, len(d.nodes))
+	for _, node := range d.nodes {
+		d.params.GenesisCandidates = append(d.params.GenesisCandidates, node.address)
+	}
+	d.params.RoundSize = d.cfg.RoundSize
+	d.params.ChainStartTime = d.cfg.StartTime.Unix()
+	d.params.CollectHeight = 1
+	d.params.Checkpoints = nil
+	d.params.ConsensusSchedule = nil
+	d.params.Bitcoin = nil
+
+	// The bitcoin chain ends at the start time, so none of its headers is
+	// in the future of the spv clients.
+	d.btcParams = minersync.BtcRegTestParams
+	d.btcParams.TargetTimePerBlock = roundDuration
+	btcBlocks := d.params.CollectHeight + d.params.CollectInterval +
+		devnetRounds*int32(d.params.BtcBlocksPerRound)
+	btcStart := d.cfg.StartTime.Add(-time.Duration(btcBlocks) * roundDuration)
+	d.btcSource = minersync.NewFakeBtcSource(&d.btcParams, 0, uint32(btcStart.Unix()))
+	for i := int32(0); i < btcBlocks; i++ {
+		d.btcSource.Mine(make([]byte, 20))
+	}
+
+	d.fconfig = chaincfg.FConfig{
+		MaxPeers:            chaincfg.DefaultMaxPeers,
+		BanDuration:         chaincfg.DefaultBanDuration,
+		BanThreshold:        chaincfg.DefaultBanThreshold,
+		MinTxPrice:          chaincfg.DefaultMinTxPrice,
+		BlkProductedTimeOut: chaincfg.DefaultBlockProductedTimeOut,
+		TxConnectTimeOut:    chaincfg.DefaultTxConnectTimeOut,
+		UtxoValidateTimeOut: chaincfg.DefaultUtxoValidateTimeOut,
+		BlockSyncTime:       chaincfg.DefaultSyncBlockTime,
+		MaxOrphanTxs:        chaincfg.DefaultMaxOrphanTransactions,
+		MaxOrphanTxSize:     chaincfg.DefaultMaxOrphanTxSize,
+		MaxTimeOffset:       chaincfg.DefaultMaxTimeOffsetSeconds,
+		GenesisBlockFile:    cfg.GenesisBlockFile,
+		DevelopNet:          true,
+		Consensustype:       "satoshiplus",
+		DisableListen:       true,
+		DisableRPC:          true,
+		DisableDNSSeed:      true,
+		DisableCheckpoints:  true,
+		DisableBanning:      true,
+		// Blocks produced early would follow each other without the
+		// clock moving.
+		DisableBlockEarly: true,
+	}
+	return d, nil
+}
+
+// Node returns the node at the index.
+func (d *Devnet) Node(i int) *DevnetNode {
+	return d.nodes[i]
+}
+
+// Nodes returns the nodes of the devnet.
+func (d *Devnet) Nodes() []*DevnetNode {
+	return d.nodes
+}
+
+// Now returns the time of the simulated clock.
+func (d *Devnet) Now() time.Time {
+	return d.clock.Now()
+}
+
+// Start replaces the clock and the network parameters of the process, then
+// starts and connects every node.
+func (d *Devnet) Start() error {
+	d.mtx.Lock()
+	if d.started {
+		d.mtx.Unlock()
+		return errors.New("devnet is already started")
+	}
+	d.started = true
+	clock.Set(d.clock)
+	d.prevParams = chaincfg.ActiveNetParams.Params
+	d.prevCfg = chaincfg.Cfg
+	chaincfg.ActiveNetParams.Params = &d.params
+	chaincfg.Cfg = &d.fconfig
+	d.mtx.Unlock()
+
+	for i := range d.nodes {
+		if err := d.StartNode(i); err != nil {
+			d.Stop()
+			return err
+		}
+	}
+	if err := d.settle(); err != nil {
+		d.Stop()
+		return err
+	}
+	return nil
+}
+
+// Stop stops every node and restores the clock and the network parameters
+// of the process.
+func (d *Devnet) Stop() {
+	d.mtx.Lock()
+	started := d.started
+	d.mtx.Unlock()
+	if !started {
+		return
+	}
+	for i := range d.nodes {
+		d.StopNode(i)
+	}
+
+	d.mtx.Lock()
+	d.started = false
+	chaincfg.ActiveNetParams.Params = d.prevParams
+	chaincfg.Cfg = d.prevCfg
+	clock.Set(nil)
+	d.mtx.Unlock()
+
+	if d.tempDir {
+		os.RemoveAll(d.cfg.DataDir)
+	}
+}
+
+// openDevnetBlockDB opens the block database in the directory, creating it
+// when it does not exist.
+func openDevnetBlockDB(dataDir string) (database.Database, error) {
+	dbPath := filepath.Join(dataDir, "blocks_"+database.FFLDB)
+	db, err := dbdriver.Open(database.FFLDB, dbPath, chaincfg.ActiveNetParams.Net)
+	if err == nil {
+		return db, nil
+	}
+	if dbErr, ok := err.(database.Error); !ok || dbErr.ErrorCode != database.ErrDbDoesNotExist {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, err
+	}
+	return dbdriver.Create(database.FFLDB, dbPath, chaincfg.ActiveNetParams.Net)
+}
+
+// StartNode starts the node at the index, from its databases when it ran
+// before, and connects it to the running nodes it has a link with.
+func (d *Devnet) StartNode(i int) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	node := d.nodes[i]
+	if node.server != nil {
+		return fmt.Errorf("devnet node %d is already started", i)
+	}
+
+	// The configuration is only read while the server is created.
+	d.fconfig.Privatekey = node.key
+	d.fconfig.DataDir = node.dataDir
+
+	db, err := openDevnetBlockDB(node.dataDir)
+	if err != nil {
+		return err
+	}
+	stateDB, err := ethdb.NewLDBDatabase(filepath.Join(node.dataDir, "state"), 16, 16)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	btcClient, err := minersync.NewSpvClient(db, &d.btcParams, 0, d.btcSource)
+	if err != nil {
+		stateDB.Close()
+		db.Close()
+		return err
+	}
+	server, err := newServer(db, stateDB, nil, nil, &d.params, nil, nil,
+		&serverOptions{btcClient: btcClient, allowSelfConns: true})
+	if err != nil {
+		stateDB.Close()
+		db.Close()
+		return err
+	}
+	server.Start()
+	node.server = server
+	node.db = db
+	node.stateDB = stateDB
+
+	for _, link := range d.links {
+		if link.a == i || link.b == i {
+			d.dial(link)
+		}
+	}
+	return nil
+}
+
+// StopNode disconnects and stops the node at the index.  Its databases are
+// kept so it can be started again.
+func (d *Devnet) StopNode(i int) {
+	d.mtx.Lock()
+	node := d.nodes[i]
+	server := node.server
+	for _, link := range d.links {
+		if (link.a == i || link.b == i) && link.wire != nil {
+			link.wire.close()
+			link.wire = nil
+		}
+	}
+	node.server = nil
+	d.mtx.Unlock()
+	if server == nil {
+		return
+	}
+
+	server.Stop()
+	server.WaitForShutdown()
+	node.stateDB.Close()
+	node.db.Close()
+	node.stateDB = nil
+	node.db = nil
+}
+
+// link returns the link between the nodes, nil when there is none.  It must
+// be called with the lock held.
+func (d *Devnet) link(a, b int) *devnetLink {
+	if a > b {
+		a, b = b, a
+	}
+	return d.links[[2]int{a, b}]
+}
+
+// Connect links the nodes, the first one dialing the second one.
+func (d *Devnet) Connect(a, b int) error {
+	if a == b {
+		return errors.New("a devnet node can not connect to itself")
+	}
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.link(a, b) != nil {
+		return fmt.Errorf("devnet nodes %d and %d are already linked", a, b)
+	}
+	link := &devnetLink{a: a, b: b}
+	if a > b {
+		a, b = b, a
+	}
+	d.links[[2]int{a, b}] = link
+	d.dial(link)
+	return nil
+}
+
+// Disconnect removes the link between the nodes.
+func (d *Devnet) Disconnect(a, b int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	link := d.link(a, b)
+	if link == nil {
+		return
+	}
+	if link.wire != nil {
+		link.wire.close()
+	}
+	if a > b {
+		a, b = b, a
+	}
+	delete(d.links, [2]int{a, b})
+}
+
+// Partition cuts the links between the nodes of distinct groups.  Nodes in
+// none of the groups are cut from every other node.
+func (d *Devnet) Partition(groups ...[]int) {
+	group := make(map[int]int)
+	for g, nodes := range groups {
+		for _, i := range nodes {
+			group[i] = g
+		}
+	}
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, link := range d.links {
+		ga, oka := group[link.a]
+		gb, okb := group[link.b]
+		link.cut = !oka || !okb || ga != gb
+		if link.cut && link.wire != nil {
+			link.wire.close()
+			link.wire = nil
+		}
+	}
+}
+
+// Heal restores the links cut by a partition, and reconnects the links
+// which were disconnected.
+func (d *Devnet) Heal() {
+	d.mtx.Lock()
+	for _, link := range d.links {
+		link.cut = false
+		d.dial(link)
+	}
+	d.mtx.Unlock()
+	d.settle()
+}
+
+// SetDelay delays the messages sent by a node to another one by the
+// duration of simulated time.
+func (d *Devnet) SetDelay(from, to int, delay time.Duration) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	link := d.link(from, to)
+	if link == nil {
+		return fmt.Errorf("devnet nodes %d and %d are not linked", from, to)
+	}
+	if link.a == from {
+		link.delay[0] = delay
+	} else {
+		link.delay[1] = delay
+	}
+	return nil
+}
+
+// SetLoss makes a link drop the given ratio of the messages sent by a node
+// to another one.  The handshake messages are never dropped.
+func (d *Devnet) SetLoss(from, to int, rate float64) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	link := d.link(from, to)
+	if link == nil {
+		return fmt.Errorf("devnet nodes %d and %d are not linked", from, to)
+	}
+	if link.a == from {
+		link.loss[0] = rate
+	} else {
+		link.loss[1] = rate
+	}
+	return nil
+}
+
+// dial connects the nodes of the link when both run and the link is neither
+// cut nor already connected.  It must be called with the lock held.
+func (d *Devnet) dial(link *devnetLink) {
+	if link.wire != nil {
+		select {
+		case <-link.wire.quit:
+			link.wire = nil
+		default:
+			return
+		}
+	}
+	na, nb := d.nodes[link.a], d.nodes[link.b]
+	if link.cut || na.server == nil || nb.server == nil {
+		return
+	}
+	connA, endA := net.Pipe()
+	connB, endB := net.Pipe()
+	wire := &devnetWire{
+		conns: []net.Conn{connA, endA, connB, endB},
+		quit:  make(chan struct{}),
+	}
+	link.wire = wire
+	go d.forward(link, 0, wire, endA, endB)
+	go d.forward(link, 1, wire, endB, endA)
+
+	na.server.outboundPeerConnected(&connmgr.ConnReq{Addr: nb.netAddr},
+		&devnetConn{Conn: connA, local: na.netAddr, remote: nb.netAddr})
+	nb.server.inboundPeerConnected(
+		&devnetConn{Conn: connB, local: nb.netAddr, remote: na.netAddr})
+}
+
+// route returns the delay of a message sent on the link in the direction,
+// and whether the message is dropped.
+func (d *Devnet) route(link *devnetLink, dir int, msg protos.Message) (time.Duration, bool) {
+	switch msg.Command() {
+	case protos.CmdVersion, protos.CmdVerAck:
+		return 0, false
+	}
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if link.loss[dir] > 0 && d.rand.Float64() < link.loss[dir] {
+		return 0, true
+	}
+	return link.delay[dir], false
+}
+
+// forward relays the messages read from a node to the other node of the
+// link, applying the rules of the link in the direction.  The messages are
+// delivered in the order they are read, each one once its delay expired.
+func (d *Devnet) forward(link *devnetLink, dir int, wire *devnetWire, r io.Reader, w io.Writer) {
+	queue := make(chan *devnetMsg, devnetQueueSize)
+	defer close(queue)
+	go func() {
+		for m := range queue {
+			if m.timer != nil {
+				select {
+				case <-m.timer.C():
+				case <-wire.quit:
+					m.timer.Stop()
+					continue
+				}
+			}
+			err := protos.WriteMessage(w, m.msg, peer.MaxProtocolVersion)
+			if err != nil {
+				wire.close()
+				continue
+			}
+			atomic.AddUint64(&d.activity, 1)
+		}
+	}()
+
+	for {
+		msg, _, err := protos.ReadMessage(r, peer.MaxProtocolVersion)
+		if err != nil {
+			wire.close()
+			return
+		}
+		atomic.AddUint64(&d.activity, 1)
+		delay, drop := d.route(link, dir, msg)
+		if drop {
+			continue
+		}
+		m := &devnetMsg{msg: msg}
+		if delay > 0 {
+			m.timer = d.clock.NewTimer(delay)
+		}
+		select {
+		case queue <- m:
+		case <-wire.quit:
+			return
+		}
+	}
+}
+
+// fingerprint summarizes the state of the devnet: it changes whenever a
+// message is relayed, a timer is set or a chain moves.
+func (d *Devnet) fingerprint() string {
+	var b strings.Builder
+	next, _ := d.clock.Next()
+	fmt.Fprintf(&b, "%d %d %d", atomic.LoadUint64(&d.activity),
+		d.clock.Pending(), next.UnixNano())
+	d.mtx.Lock()
+	for _, node := range d.nodes {
+		if node.server != nil {
+			fmt.Fprintf(&b, " %s", node.server.chain.BestSnapshot().Hash)
+		}
+	}
+	d.mtx.Unlock()
+	return b.String()
+}
+
+// busyGoroutines returns the number of goroutines of the process, other than
+// the calling one, which are running, ready to run or in a system call.  The
+// others are blocked until another goroutine, a timer or the network wakes
+// them up.  The buffer holding the stacks is grown as needed.
+func busyGoroutines(buf *[]byte) int {
+	var n int
+	for {
+		n = runtime.Stack(*buf, true)
+		if n < len(*buf) {
+			break
+		}
+		*buf = make([]byte, 2*len(*buf)+64*1024)
+	}
+	busy := 0
+	self := true
+	for _, line := range strings.Split(string((*buf)[:n]), "\n") {
+		if !strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+		start, end := strings.IndexByte(line, '['), strings.IndexAny(line, ",]")
+		if start < 0 || end < start {
+			continue
+		}
+		if self {
+			self = false
+			continue
+		}
+		switch line[start+1 : end] {
+		case "running", "runnable", "syscall", "preempted":
+			busy++
+		}
+	}
+	return busy
+}
+
+// settle lets the nodes run until they are idle: every other goroutine of
+// the process is blocked, twice in a row on the same relayed messages,
+// simulated timers and best chains.  Only the simulated clock can then wake
+// the nodes up, so each step of a scenario ends in the same state whatever
+// the speed of the machine.  The wait is bounded by the settle steps, the
+// nodes which are still busy then make the scenario fail: the first such
+// error is returned and kept for Err.
+func (d *Devnet) settle() error {
+	var buf []byte
+	last := ""
+	for i := 0; i < d.cfg.SettleSteps; i++ {
+		runtime.Gosched()
+		if busyGoroutines(&buf) > 0 {
+			continue
+		}
+		fp := d.fingerprint()
+		if fp == last {
+			return nil
+		}
+		last = fp
+	}
+	err := fmt.Errorf("the nodes did not settle in %d steps at %v",
+		d.cfg.SettleSteps, d.clock.Now())
+	if d.settleErr == nil {
+		d.settleErr = err
+	}
+	return err
+}
+
+// Err returns the first error met while the nodes were left to settle, nil
+// when they always settled.  The steps of a scenario after such an error
+// may not end in the same state on every run.
+func (d *Devnet) Err() error {
+	return d.settleErr
+}
+
+// Advance moves the simulated clock forward by the duration.  It stops at
+// every timer on the way and lets the nodes settle, so every slot and every
+// delayed message is handled in order.  Nodes failing to settle are reported
+// by Err.
+func (d *Devnet) Advance(duration time.Duration) {
+	end := d.clock.Now().Add(duration)
+	d.settle()
+	for {
+		next, ok := d.clock.Next()
+		if !ok || next.After(end) {
+			break
+		}
+		d.clock.AdvanceTo(next)
+		d.settle()
+	}
+	d.clock.AdvanceTo(end)
+	d.settle()
+}
+
+// AdvanceSlots moves the simulated clock forward by the number of slots.
+func (d *Devnet) AdvanceSlots(n int) {
+	d.Advance(time.Duration(n) * common.DefaultBlockInterval * time.Second)
+}
+
+// CheckConvergence returns an error unless every running node has the same
+// best block.
+func (d *Devnet) CheckConvergence() error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	var first *blockchain.BestState
+	var tips []string
+	converged := true
+	for _, node := range d.nodes {
+		if node.server == nil {
+			continue
+		}
+		best := node.server.chain.BestSnapshot()
+		tips = append(tips, fmt.Sprintf("node %d at %d %s", node.index, best.Height, best.Hash))
+		if first == nil {
+			first = best
+		} else if best.Hash != first.Hash {
+			converged = false
+		}
+	}
+	if !converged {
+		return fmt.Errorf("devnet did not converge: %s", strings.Join(tips, ", "))
+	}
+	return nil
+}
+
+// WaitForConvergence advances the simulated clock slot by slot, at most by
+// the number of slots, until every running node has the same best block.
+func (d *Devnet) WaitForConvergence(slots int) error {
+	for i := 0; i < slots; i++ {
+		if d.CheckConvergence() == nil {
+			return nil
+		}
+		d.AdvanceSlots(1)
+	}
+	return d.CheckConvergence()
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+)
+
+// newTestDevnet starts a devnet of the validators, stopped at the end of the
+// test, which fails if the nodes did not settle meanwhile.
+func newTestDevnet(t *testing.T, validators int) *Devnet {
+	d, err := NewDevnet(&DevnetConfig{
+		Validators:       validators,
+		GenesisBlockFile: "../genesisbin/devnet.block",
+		Seed:             1,
+	})
+	if err != nil {
+		t.Fatalf("NewDevnet error %v", err)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start error %v", err)
+	}
+	t.Cleanup(func() {
+		if err := d.Err(); err != nil {
+			t.Errorf("Devnet: %v", err)
+		}
+		d.Stop()
+	})
+	return d
+}
+
+// TestDevnetSettleSteps ensures nodes which do not settle within the settle
+// steps are reported.
+func TestDevnetSettleSteps(t *testing.T) {
+	if testing.Short() {
+		t.Skip("devnet scenarios are skipped in short mode")
+	}
+	d, err := NewDevnet(&DevnetConfig{
+		Validators:       1,
+		GenesisBlockFile: "../genesisbin/devnet.block",
+		SettleSteps:      1,
+	})
+	if err != nil {
+		t.Fatalf("NewDevnet error %v", err)
+	}
+	defer d.Stop()
+	if err := d.Start(); err == nil {
+		t.Fatalf("Start: the nodes settled in a single step")
+	}
+	if d.Err() == nil {
+		t.Fatalf("Err: the nodes which did not settle are not reported")
+	}
+}
+
+// TestDevnetPartition ensures the validators of a devnet agree on a chain,
+// fork when they are partitioned, and reorganize to the chain of the larger
+// side when the partition heals.
+func TestDevnetPartition(t *testing.T) {
+	if testing.Short() {
+		t.Skip("devnet scenarios are skipped in short mode")
+	}
+	d := newTestDevnet(t, 3)
+
+	d.AdvanceSlots(6)
+	if err := d.CheckConvergence(); err != nil {
+		t.Fatalf("CheckConvergence: %v", err)
+	}
+	start := d.Node(0).BestSnapshot().Height
+	if start == 0 {
+		t.Fatalf("AdvanceSlots: no block was produced")
+	}
+
+	d.Partition([]int{0, 1}, []int{2})
+	d.AdvanceSlots(12)
+	if d.CheckConvergence() == nil {
+		t.Fatalf("CheckConvergence: the partitioned validators did not fork")
+	}
+	minority := d.Node(2).BestSnapshot()
+	majority := d.Node(0).BestSnapshot()
+	if minority.Height <= start || minority.Height >= majority.Height {
+		t.Fatalf("Partition: got heights %d and %d from %d, want both sides to "+
+			"grow and the larger one to lead", minority.Height, majority.Height, start)
+	}
+
+	d.Heal()
+	if err := d.WaitForConvergence(12); err != nil {
+		t.Fatalf("WaitForConvergence: %v", err)
+	}
+	if d.Node(2).Chain().MainChainHasBlock(&minority.Hash) {
+		t.Errorf("Heal: the minority fork is still in the main chain")
+	}
+	if !d.Node(2).Chain().MainChainHasBlock(&majority.Hash) {
+		t.Errorf("Heal: the majority fork is not in the main chain")
+	}
+}
+
+// TestDevnetChurn ensures a validator which stops and starts again catches
+// up with the blocks the others produced meanwhile, even through a slow link.
+func TestDevnetChurn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("devnet scenarios are skipped in short mode")
+	}
+	d := newTestDevnet(t, 3)
+
+	d.AdvanceSlots(6)
+	d.StopNode(2)
+	d.AdvanceSlots(12)
+	stopped := d.Node(0).BestSnapshot().Height
+
+	if err := d.StartNode(2); err != nil {
+		t.Fatalf("StartNode error %v", err)
+	}
+	if err := d.SetDelay(0, 2, 2*time.Second); err != nil {
+		t.Fatalf("SetDelay error %v", err)
+	}
+	if err := d.WaitForConvergence(12); err != nil {
+		t.Fatalf("WaitForConvergence: %v", err)
+	}
+	if height := d.Node(2).BestSnapshot().Height; height < stopped {
+		t.Errorf("StartNode: got height %d, want at least %d", height, stopped)
+	}
+}
+
+// TestDevnetDeterministic ensures a scenario ends in the same chain every
+// time it runs with the same seed.
+func TestDevnetDeterministic(t *testing.T) {
+	if testing.Short() {
+		t.Skip("devnet scenarios are skipped in short mode")
+	}
+	var tips [2]common.Hash
+	for i := range tips {
+		d, err := NewDevnet(&DevnetConfig{
+			Validators:       3,
+			GenesisBlockFile: "../genesisbin/devnet.block",
+			Seed:             1,
+		})
+		if err != nil {
+			t.Fatalf("NewDevnet error %v", err)
+		}
+		if err := d.Start(); err != nil {
+			t.Fatalf("Start error %v", err)
+		}
+		d.AdvanceSlots(6)
+		d.Partition([]int{0, 1}, []int{2})
+		d.AdvanceSlots(6)
+		d.Heal()
+		err = d.WaitForConvergence(12)
+		tips[i] = d.Node(0).BestSnapshot().Hash
+		d.Stop()
+		if err != nil {
+			t.Fatalf("WaitForConvergence: %v", err)
+		}
+		if err := d.Err(); err != nil {
+			t.Fatalf("Devnet: %v", err)
+		}
+	}
+	if tips[0] != tips[1] {
+		t.Errorf("got best blocks %v and %v from the same scenario", tips[0], tips[1])
+	}
+}
+
+// TestDevnetEvidence ensures an evidence of a double sign is reported by a
+// validator to the evidence registry in a transaction, and that the registry
+// counts the misbehavior once the transaction is mined.
+func TestDevnetEvidence(t *testing.T) {
+	if testing.Short() {
+		t.Skip("devnet scenarios are skipped in short mode")
+	}
+	d := newTestDevnet(t, 2)
+	d.AdvanceSlots(6)
+
+	offender := d.Node(1)
+	acc, err := crypto.NewAccount(offender.key)
+	if err != nil {
+		t.Fatalf("NewAccount error %v", err)
+	}
+	best := d.Node(0).BestSnapshot()
+	sign := func(hash common.Hash) *protos.MsgBlockSign {
+		signature, err := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&acc.PrivateKey))
+		if err != nil {
+			t.Fatalf("Sign error %v", err)
+		}
+		msg := &protos.MsgBlockSign{BlockHeight: best.Height, BlockHash: hash,
+			Signer: offender.Address()}
+		copy(msg.Signature[:], signature)
+		return msg
+	}
+	evidence := protos.NewMsgDoubleSign(sign(best.Hash), sign(common.Hash{0x01}))
+	if isNew, err := d.Node(0).Chain().ProcessEvidence(evidence); err != nil || !isNew {
+		t.Fatalf("ProcessEvidence: got %v, error %v", isNew, err)
+	}
+	d.AdvanceSlots(4)
+
+	server := d.Node(0).server
+	cfg := &rpcserverConfig{Chain: server.chain, ChainParams: server.chainParams}
+	block, stateDB, err := createBlockState(cfg, nil)
+	if err != nil {
+		t.Fatalf("createBlockState error %v", err)
+	}
+	input, err := fvm.PackFunctionArgs(vm.EvidenceRegistryABI, "getMisbehaviors",
+		offender.Address())
+	if err != nil {
+		t.Fatalf("PackFunctionArgs error %v", err)
+	}
+	ret, _, err := fvm.CallReadOnlyFunction(d.Node(0).Address(), block, server.chain,
+		stateDB, chaincfg.ActiveNetParams.FvmParam, common.SystemContractReadOnlyGas,
+		common.EvidenceRegistry, input)
+	if err != nil {
+		t.Fatalf("CallReadOnlyFunction error %v", err)
+	}
+	count, err := fvm.UnPackReadOnlyResult(vm.EvidenceRegistryABI, "getMisbehaviors", ret)
+	if err != nil {
+		t.Fatalf("UnPackReadOnlyResult error %v", err)
+	}
+	if count.(*big.Int).Int64() != 1 {
+		t.Errorf("got %v misbehaviors of the offender, want 1", count)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"encoding/hex"
+	"strconv"
+
+	"github.com/AsimovNetwork/asimov/blockchain/indexers"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/vm/fvm"
+)
+
+const (
+	// maxAssetPage is the maximum number of assets or mints returned by a
+	// single request.
+	maxAssetPage = 1000
+)
+
+// errAssetIndexDisabled is returned by the commands which require the asset
+// registry index when it is not enabled.
+var errAssetIndexDisabled = &rpcjson.RPCError{
+	Code:    rpcjson.ErrRPCMisc,
+	Message: "The asset registry index must be enabled to query assets (specify --assetindex)",
+}
+
+// checkAssetPage verifies the passed paging parameters.
+func checkAssetPage(offset int32, count int32) error {
+	if offset < 0 || count <= 0 || count > maxAssetPage {
+		return &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Invalid offset or count, count must be in range of (0, 1000]",
+		}
+	}
+	return nil
+}
+
+// createAssetResults converts the passed assets to their RPC representation.
+// The organization contract of each asset is resolved through the registry
+// center when possible.
+func (s *PublicRpcAPI) createAssetResults(infos []*indexers.AssetInfo) []rpcjson.RegisteredAssetResult {
+	results := make([]rpcjson.RegisteredAssetResult, 0, len(infos))
+	if len(infos) == 0 {
+		return results
+	}
+
+	assets := make([]string, 0, len(infos))
+	for _, info := range infos {
+		assets = append(assets, hex.EncodeToString(info.Asset.Bytes()))
+	}
+	var orgAddrs []common.Address
+	block, stateDB, err := createBlockState(s.cfg, nil)
+	if err == nil {
+		addrs, ok, _ := s.cfg.ContractMgr.GetContractAddressByAsset(common.SystemContractReadOnlyGas,
+			block, stateDB, chaincfg.ActiveNetParams.FvmParam, assets)
+		if ok && len(addrs) == len(infos) {
+			orgAddrs = addrs
+		}
+	}
+
+	for i, info := range infos {
+		result := rpcjson.RegisteredAssetResult{
+			Asset:          assets[i],
+			OrganizationId: info.OrganizationId(),
+			Indivisible:    info.Asset.IsIndivisible(),
+			CreationHeight: info.CreationHeight,
+			CreationTxid:   info.CreationTx.String(),
+			Mints:          info.Mints,
+		}
+		if orgAddrs != nil && orgAddrs[i] != (common.Address{}) {
+			result.Organization = orgAddrs[i].String()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// organizationId returns the id the registry center assigned to the
+// organization contract at the passed address.
+func (s *PublicRpcAPI) organizationId(orgAddr common.Address) (uint32, error) {
+	block, stateDB, err := createBlockState(s.cfg, nil)
+	if err != nil {
+		return 0, err
+	}
+	contract := s.cfg.ContractMgr.GetActiveContractByHeight(block.Height(), common.RegistryCenter)
+	if contract == nil {
+		return 0, internalRPCError("Failed to get active registry center", "")
+	}
+
+	funcName := common.ContractRegistryCenter_GetOrganizationIdFunction()
+	input, err := fvm.PackFunctionArgs(contract.AbiInfo, funcName)
+	if err != nil {
+		return 0, internalRPCError(err.Error(), "Failed to pack registry center call")
+	}
+	ret, _, err := fvm.CallReadOnlyFunction(orgAddr, block, s.cfg.Chain, stateDB,
+		chaincfg.ActiveNetParams.FvmParam, common.SystemContractReadOnlyGas,
+		common.RegistryCenter, input)
+	if err != nil {
+		return 0, internalRPCError(err.Error(), "Failed to call registry center")
+	}
+
+	outType := &[]interface{}{new(bool), new(uint32)}
+	err = fvm.UnPackFunctionResult(contract.AbiInfo, outType, funcName, ret)
+	if err != nil {
+		return 0, internalRPCError(err.Error(), "Failed to unpack registry center result")
+	}
+	if !*((*outType)[0]).(*bool) {
+		return 0, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Organization is not registered: " + orgAddr.String(),
+		}
+	}
+	return *((*outType)[1]).(*uint32), nil
+}
+
+// ListAssets returns the assets created on the main chain in the order they
+// were created.  The page [offset, offset+count) is returned along with the
+// total number of assets.
+func (s *PublicRpcAPI) ListAssets(offset int32, count int32) (interface{}, error) {
+	if s.cfg.AssetIndex == nil {
+		return nil, errAssetIndexDisabled
+	}
+	if err := checkAssetPage(offset, count); err != nil {
+		return nil, err
+	}
+
+	infos, total, err := s.cfg.AssetIndex.Assets(uint32(offset), uint32(count))
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch assets")
+	}
+	return &rpcjson.ListAssetsResult{
+		Total:  total,
+		Assets: s.createAssetResults(infos),
+	}, nil
+}
+
+// GetAssetsByOrganization returns the assets created by the organization
+// contract at the passed address.
+func (s *PublicRpcAPI) GetAssetsByOrganization(orgAddress string) (interface{}, error) {
+	if s.cfg.AssetIndex == nil {
+		return nil, errAssetIndexDisabled
+	}
+	addrBytes, err := hexutil.Decode(orgAddress)
+	if err != nil || len(addrBytes) != common.AddressLength {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid address: " + orgAddress,
+		}
+	}
+	orgId, err := s.organizationId(common.BytesToAddress(addrBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := s.cfg.AssetIndex.OrganizationAssets(orgId)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch assets")
+	}
+	return s.createAssetResults(infos), nil
+}
+
+// GetAssetMintHistory returns the creation and the mints of the asset in
+// chain order.  The amount of a mint of an indivisible asset is the voucher
+// id.
+func (s *PublicRpcAPI) GetAssetMintHistory(asset string, offset int32, count int32) (interface{}, error) {
+	if s.cfg.AssetIndex == nil {
+		return nil, errAssetIndexDisabled
+	}
+	a, err := decodeAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAssetPage(offset, count); err != nil {
+		return nil, err
+	}
+
+	mints, err := s.cfg.AssetIndex.AssetMints(a, uint32(offset), uint32(count))
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch asset mints")
+	}
+	result := make([]rpcjson.AssetMintResult, 0, len(mints))
+	for _, mint := range mints {
+		result = append(result, rpcjson.AssetMintResult{
+			Height: mint.Height,
+			Txid:   mint.TxHash.String(),
+			Amount: strconv.FormatInt(mint.Amount, 10),
+		})
+	}
+	return result, nil
+}
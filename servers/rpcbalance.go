@@ -0,0 +1,146 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"encoding/hex"
+	"strconv"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+)
+
+const (
+	// maxAssetHolders is the maximum number of holders returned by a
+	// single getassetholders request.
+	maxAssetHolders = 1000
+)
+
+// errBalanceIndexDisabled is returned by the commands which require the
+// balance index when it is not enabled.
+var errBalanceIndexDisabled = &rpcjson.RPCError{
+	Code:    rpcjson.ErrRPCMisc,
+	Message: "The balance index must be enabled to query balance history (specify --balanceindex)",
+}
+
+// decodeAsset decodes the hex encoded asset of a balance index command.
+func decodeAsset(asset string) (*protos.Asset, error) {
+	assetBytes, err := hex.DecodeString(asset)
+	if err != nil || len(assetBytes) != common.AssetLength {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Invalid asset: " + asset,
+		}
+	}
+	return protos.AssetFromBytes(assetBytes), nil
+}
+
+// GetBalanceAtHeight returns the balance the address held of the asset after
+// the main chain block at the passed height was connected.  The balance of an
+// indivisible asset is the number of vouchers held.
+func (s *PublicRpcAPI) GetBalanceAtHeight(address string, asset string, height int32) (interface{}, error) {
+	if s.cfg.BalanceIndex == nil {
+		return nil, errBalanceIndexDisabled
+	}
+	addrBytes, err := hexutil.Decode(address)
+	if err != nil || len(addrBytes) != common.AddressLength {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid address: " + address,
+		}
+	}
+	addr := common.BytesToAddress(addrBytes)
+	a, err := decodeAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 || height > s.cfg.Chain.BestSnapshot().Height {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCBlockHeightNotFound,
+			Message: "Block height out of range",
+		}
+	}
+
+	balance, err := s.cfg.BalanceIndex.BalanceAtHeight(&addr, a, height)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch balance")
+	}
+	return rpcjson.GetBalanceResult{
+		Asset: asset,
+		Value: strconv.FormatInt(balance, 10),
+	}, nil
+}
+
+// GetAssetHolders returns the holders of the asset ordered by descending
+// balance.  The page [offset, offset+count) is returned along with the total
+// number of holders.
+func (s *PublicRpcAPI) GetAssetHolders(asset string, offset int32, count int32) (interface{}, error) {
+	if s.cfg.BalanceIndex == nil {
+		return nil, errBalanceIndexDisabled
+	}
+	a, err := decodeAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || count <= 0 || count > maxAssetHolders {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Invalid offset or count, count must be in range of (0, 1000]",
+		}
+	}
+
+	holders, total, err := s.cfg.BalanceIndex.AssetHolders(a, int(offset), int(count))
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch asset holders")
+	}
+	result := &rpcjson.GetAssetHoldersResult{
+		Asset:   asset,
+		Total:   total,
+		Holders: make([]rpcjson.AssetHolderResult, 0, len(holders)),
+	}
+	for _, holder := range holders {
+		result.Holders = append(result.Holders, rpcjson.AssetHolderResult{
+			Address: holder.Address.String(),
+			Value:   strconv.FormatInt(holder.Balance, 10),
+		})
+	}
+	return result, nil
+}
+
+// GetAssetSupplyHistory returns the supply of the asset held by addresses and
+// its number of holders after every main chain block between fromHeight and
+// toHeight which changed them.  The first entry describes the state at
+// fromHeight when the asset existed then.
+func (s *PublicRpcAPI) GetAssetSupplyHistory(asset string, fromHeight int32, toHeight int32) (interface{}, error) {
+	if s.cfg.BalanceIndex == nil {
+		return nil, errBalanceIndexDisabled
+	}
+	a, err := decodeAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+	if fromHeight < 0 || fromHeight > toHeight {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Invalid height range",
+		}
+	}
+
+	history, err := s.cfg.BalanceIndex.AssetSupplyHistory(a, fromHeight, toHeight)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch asset supply history")
+	}
+	result := make([]rpcjson.AssetSupplyResult, 0, len(history))
+	for _, supply := range history {
+		result = append(result, rpcjson.AssetSupplyResult{
+			Height:  supply.Height,
+			Supply:  strconv.FormatInt(supply.Supply, 10),
+			Holders: supply.Holders,
+		})
+	}
+	return result, nil
+}
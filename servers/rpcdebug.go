@@ -0,0 +1,380 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/vm/fvm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/virtualtx"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/math"
+)
+
+const (
+	// callTracerName is the name of the tracer which returns the call tree
+	// of an execution.
+	callTracerName = "callTracer"
+
+	// traceCallGas is the gas provided to a traced call, the same amount
+	// the other simulated calls use.
+	traceCallGas = uint64(1000000000)
+)
+
+// PublicDebugAPI provides the commands of the debug namespace.  They replay
+// executions with a tracer attached and are therefore expensive, the
+// namespace is only served when enabled with --httpmodule or --wsmodule.
+type PublicDebugAPI struct {
+	cfg *rpcserverConfig
+}
+
+// NewPublicDebugAPI creates a new debug API.
+func NewPublicDebugAPI(config *rpcserverConfig) *PublicDebugAPI {
+	return &PublicDebugAPI{
+		cfg: config,
+	}
+}
+
+// newTracer creates the tracer requested by the passed config.
+func newTracer(config *rpcjson.TraceConfig) (vm.Tracer, error) {
+	if config == nil {
+		return vm.NewStructLogger(nil), nil
+	}
+	switch config.Tracer {
+	case "":
+		return vm.NewStructLogger(&vm.LogConfig{
+			DisableMemory:  config.DisableMemory,
+			DisableStack:   config.DisableStack,
+			DisableStorage: config.DisableStorage,
+			Limit:          config.Limit,
+		}), nil
+	case callTracerName:
+		return vm.NewCallTracer(), nil
+	}
+	return nil, &rpcjson.RPCError{
+		Code:    rpcjson.ErrRPCInvalidParameter,
+		Message: "Unknown tracer: " + config.Tracer,
+	}
+}
+
+// createTraceResult converts the output of the passed tracer to its RPC
+// representation.
+func createTraceResult(tracer vm.Tracer, gasUsed uint64, failed bool) interface{} {
+	switch tracer := tracer.(type) {
+	case *vm.StructLogger:
+		return &rpcjson.ExecutionResult{
+			Gas:         gasUsed,
+			Failed:      failed,
+			ReturnValue: hex.EncodeToString(tracer.Output()),
+			StructLogs:  createStructLogResults(tracer.StructLogs()),
+		}
+	case *vm.CallTracer:
+		calls := make([]rpcjson.CallTraceResult, 0, len(tracer.Calls()))
+		for _, frame := range tracer.Calls() {
+			calls = append(calls, createCallTraceResult(frame))
+		}
+		return calls
+	}
+	return nil
+}
+
+// createStructLogResults converts the opcode steps of a StructLogger.
+func createStructLogResults(logs []vm.StructLog) []rpcjson.StructLogResult {
+	results := make([]rpcjson.StructLogResult, 0, len(logs))
+	for _, log := range logs {
+		result := rpcjson.StructLogResult{
+			Pc:      log.Pc,
+			Op:      log.Op.String(),
+			Gas:     log.Gas,
+			GasCost: log.GasCost,
+			Depth:   log.Depth,
+			Error:   log.ErrorString(),
+		}
+		if log.Stack != nil {
+			result.Stack = make([]string, 0, len(log.Stack))
+			for _, item := range log.Stack {
+				result.Stack = append(result.Stack,
+					hex.EncodeToString(math.PaddedBigBytes(item, 32)))
+			}
+		}
+		if log.Memory != nil {
+			result.Memory = make([]string, 0, (len(log.Memory)+31)/32)
+			for i := 0; i+32 <= len(log.Memory); i += 32 {
+				result.Memory = append(result.Memory,
+					hex.EncodeToString(log.Memory[i:i+32]))
+			}
+		}
+		if log.Storage != nil {
+			result.Storage = make(map[string]string, len(log.Storage))
+			for key, value := range log.Storage {
+				result.Storage[key.String()] = value.String()
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// createCallTraceResult converts a frame of a CallTracer and its sub calls.
+func createCallTraceResult(frame *vm.CallFrame) rpcjson.CallTraceResult {
+	result := rpcjson.CallTraceResult{
+		Type:      frame.Type,
+		From:      frame.From.String(),
+		To:        frame.To.String(),
+		Value:     frame.Value.String(),
+		Gas:       frame.Gas,
+		GasUsed:   frame.GasUsed,
+		Input:     hex.EncodeToString(frame.Input),
+		Output:    hex.EncodeToString(frame.Output),
+		Error:     frame.Error,
+		Transfers: createVTransferResults(frame.Transfers),
+	}
+	if frame.Asset != nil {
+		result.Asset = hex.EncodeToString(frame.Asset.Bytes())
+	}
+	for _, call := range frame.Calls {
+		result.Calls = append(result.Calls, createCallTraceResult(call))
+	}
+	return result
+}
+
+// createVTransferResults converts the transfers appended to a virtual
+// transaction.
+func createVTransferResults(transfers []*virtualtx.VTransfer) []rpcjson.VTransferResult {
+	results := make([]rpcjson.VTransferResult, 0, len(transfers))
+	for _, transfer := range transfers {
+		result := rpcjson.VTransferResult{
+			To:     common.BytesToAddress(transfer.To).String(),
+			Amount: transfer.Amount,
+		}
+		switch transfer.VTransferType {
+		case virtualtx.VTransferTypeCreation:
+			result.Type = "creation"
+		case virtualtx.VTransferTypeMint:
+			result.Type = "mint"
+		default:
+			result.Type = "transfer"
+			result.From = common.BytesToAddress(transfer.From).String()
+		}
+		if transfer.Asset != nil {
+			result.Asset = hex.EncodeToString(transfer.Asset.Bytes())
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// createVtxResult converts the virtual transaction generated by a contract
+// execution, nil is returned when there is none.
+func createVtxResult(vtx *protos.MsgTx) *rpcjson.TxRawDecodeResult {
+	if vtx == nil {
+		return nil
+	}
+	result := &rpcjson.TxRawDecodeResult{}
+	result.Vin = make([]rpcjson.Vin, len(vtx.TxIn))
+	result.Vout = make([]rpcjson.Vout, len(vtx.TxOut))
+	for i, in := range vtx.TxIn {
+		vin := &result.Vin[i]
+		vin.Txid = in.PreviousOutPoint.Hash.String()
+		vin.Vout = in.PreviousOutPoint.Index
+	}
+	for i, out := range vtx.TxOut {
+		vout := &result.Vout[i]
+		vout.Value = out.Value
+		vout.ScriptPubKey.Hex = hex.EncodeToString(out.PkScript)
+		vout.Asset = hex.EncodeToString(out.Asset.Bytes())
+	}
+	return result
+}
+
+// traceBlock replays the block with the passed hash and converts the traces
+// of the transactions.  See BlockChain.TraceBlock for txIndex.
+func (s *PublicDebugAPI) traceBlock(hash *common.Hash, txIndex int,
+	config *rpcjson.TraceConfig) ([]*rpcjson.TxTraceResult, error) {
+
+	// Validate the config before replaying anything.
+	if _, err := newTracer(config); err != nil {
+		return nil, err
+	}
+
+	tracers := make(map[common.Hash]vm.Tracer)
+	traces, err := s.cfg.Chain.TraceBlock(hash, txIndex, func(tx *asiutil.Tx) vm.Tracer {
+		tracer, _ := newTracer(config)
+		tracers[*tx.Hash()] = tracer
+		return tracer
+	})
+	if err != nil {
+		if perr, ok := err.(blockchain.StatePrunedError); ok {
+			return nil, stateError(perr, perr.Height)
+		}
+		return nil, internalRPCError(err.Error(), "Failed to trace block")
+	}
+
+	results := make([]*rpcjson.TxTraceResult, 0, len(traces))
+	for _, trace := range traces {
+		failed := trace.Receipt != nil && trace.Receipt.Status == types.ReceiptStatusFailed
+		results = append(results, &rpcjson.TxTraceResult{
+			Txid:    trace.Tx.Hash().String(),
+			Index:   trace.Index,
+			GasUsed: trace.GasUsed,
+			Failed:  failed,
+			Result:  createTraceResult(tracers[*trace.Tx.Hash()], trace.GasUsed, failed),
+			VTX:     createVtxResult(trace.Vtx),
+		})
+	}
+	return results, nil
+}
+
+// TraceTransaction replays the main chain transaction with the passed id on
+// top of the state of its parent block and returns its trace.  The
+// transactions before it in the block are replayed without tracing.
+func (s *PublicDebugAPI) TraceTransaction(txId string, config *rpcjson.TraceConfig) (interface{}, error) {
+	if s.cfg.TxIndex == nil {
+		return nil, &rpcjson.RPCError{
+			Code: rpcjson.ErrRPCNoTxInfo,
+			Message: "The transaction index must be " +
+				"enabled to trace transactions " +
+				"(specify --txindex)",
+		}
+	}
+
+	txHash := common.HexToHash(txId)
+	blockRegion, err := s.cfg.TxIndex.FetchBlockRegion(txHash[:])
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to retrieve transaction location")
+	}
+	if blockRegion == nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCNoTxInfo,
+			Message: "No information available about transaction " + txId,
+		}
+	}
+
+	if blockRegion.Key.IsVirtual() {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Virtual transactions can not be traced: " + txId,
+		}
+	}
+	var blkHash common.Hash
+	copy(blkHash[:], blockRegion.Key[:common.HashLength])
+	block, err := s.cfg.Chain.BlockByHash(&blkHash)
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCBlockNotFound,
+			Message: "Block not found: " + blkHash.String(),
+		}
+	}
+	txIndex := -1
+	for i, tx := range block.Transactions() {
+		if *tx.Hash() == txHash {
+			txIndex = i
+			break
+		}
+	}
+	if txIndex < 0 {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCNoTxInfo,
+			Message: "Transaction not found in block " + blkHash.String(),
+		}
+	}
+
+	results, err := s.traceBlock(block.Hash(), txIndex, config)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 {
+		return nil, internalRPCError(fmt.Sprintf("unexpected number of traces %d",
+			len(results)), "Failed to trace transaction")
+	}
+	return results[0], nil
+}
+
+// TraceBlock replays all transactions of the main chain block with the passed
+// hash on top of the state of its parent and returns their traces.
+func (s *PublicDebugAPI) TraceBlock(blockHash string, config *rpcjson.TraceConfig) (interface{}, error) {
+	hash := common.HexToHash(blockHash)
+	if !s.cfg.Chain.MainChainHasBlock(&hash) {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCBlockNotFound,
+			Message: "Block not found in the main chain: " + blockHash,
+		}
+	}
+	return s.traceBlock(&hash, -1, config)
+}
+
+// TraceCall executes a contract call on top of the state of the best block,
+// or of the optional block, in the same way as the call command, and returns
+// its trace along with the virtual transfers the execution produced.
+// Nothing is persisted.
+func (s *PublicDebugAPI) TraceCall(caller string, contractAddress string, amount int64, asset string,
+	data string, config *rpcjson.TraceConfig, blockRef *rpcjson.BlockRef) (interface{}, error) {
+
+	chain := s.cfg.Chain
+	callerAddressBytes, err := hexutil.Decode(caller)
+	if err != nil || len(callerAddressBytes) != common.AddressLength {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid caller address: " + caller,
+		}
+	}
+	contractAddressBytes, err := hexutil.Decode(contractAddress)
+	if err != nil || len(contractAddressBytes) != common.AddressLength {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid contract address: " + contractAddress,
+		}
+	}
+	assets := &asiutil.AsimovAsset
+	if asset != "" {
+		assets, err = decodeAsset(asset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tracer, err := newTracer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
+	callerAddr := common.BytesToAddress(callerAddressBytes)
+	vmConfig := vm.Config{
+		Debug:            true,
+		Tracer:           tracer,
+		EWASMInterpreter: chain.GetVmConfig().EWASMInterpreter,
+		FVMInterpreter:   chain.GetVmConfig().FVMInterpreter,
+	}
+	context := fvm.NewFVMContext(callerAddr, new(big.Int).SetInt64(1), block, chain, nil, nil)
+	vmInstance := vm.NewFVM(context, stateDB, chaincfg.ActiveNetParams.FvmParam, vmConfig)
+
+	_, leftOverGas, _, err := vmInstance.Call(vm.AccountRef(callerAddr), common.BytesToAddress(contractAddressBytes),
+		common.Hex2Bytes(data), traceCallGas, big.NewInt(amount), assets, true)
+	if stateDB.Error() != nil {
+		return nil, stateError(stateDB.Error(), block.Height()-1)
+	}
+	gasUsed := traceCallGas - leftOverGas
+	failed := err != nil
+
+	return &rpcjson.CallTraceCallResult{
+		GasUsed:   gasUsed,
+		Failed:    failed,
+		Result:    createTraceResult(tracer, gasUsed, failed),
+		Transfers: createVTransferResults(vmInstance.Vtx.VTransfer),
+	}, nil
+}
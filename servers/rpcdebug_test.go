@@ -0,0 +1,145 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/virtualtx"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+)
+
+// TestNewTracer ensures the tracers are created from the trace config and
+// that unknown tracers are refused with an invalid parameter error.
+func TestNewTracer(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *rpcjson.TraceConfig
+		call   bool
+	}{
+		{"no config", nil, false},
+		{"struct logger", &rpcjson.TraceConfig{DisableStack: true, Limit: 10}, false},
+		{"call tracer", &rpcjson.TraceConfig{Tracer: callTracerName}, true},
+	}
+	for _, test := range tests {
+		tracer, err := newTracer(test.config)
+		if err != nil {
+			t.Errorf("%s: newTracer error %v", test.name, err)
+			continue
+		}
+		_, isCall := tracer.(*vm.CallTracer)
+		_, isLogger := tracer.(*vm.StructLogger)
+		if isCall != test.call || isLogger == test.call {
+			t.Errorf("%s: unexpected tracer %T", test.name, tracer)
+		}
+	}
+
+	_, err := newTracer(&rpcjson.TraceConfig{Tracer: "prestateTracer"})
+	rpcErr, ok := err.(*rpcjson.RPCError)
+	if !ok || rpcErr.Code != rpcjson.ErrRPCInvalidParameter {
+		t.Errorf("unknown tracer: got error %v, want invalid parameter", err)
+	}
+
+	// Bad configs are refused before anything is replayed.
+	api := NewPublicDebugAPI(&rpcserverConfig{})
+	if _, err := api.traceBlock(&common.Hash{}, -1,
+		&rpcjson.TraceConfig{Tracer: "prestateTracer"}); err == nil {
+		t.Errorf("traceBlock accepted an unknown tracer")
+	}
+	_, err = api.TraceTransaction(common.Hash{}.String(), nil)
+	if rpcErr, ok := err.(*rpcjson.RPCError); !ok || rpcErr.Code != rpcjson.ErrRPCNoTxInfo {
+		t.Errorf("TraceTransaction without tx index: got error %v", err)
+	}
+}
+
+// TestCreateCallTraceResult ensures the frames of a call tracer are converted
+// with their sub calls and virtual transfers.
+func TestCreateCallTraceResult(t *testing.T) {
+	from := common.BytesToAddress([]byte{1})
+	to := common.BytesToAddress([]byte{2})
+	asset := protos.NewAsset(0, 7, 1)
+	frame := &vm.CallFrame{
+		Type:    "CALL",
+		From:    from,
+		To:      to,
+		Value:   big.NewInt(10),
+		Asset:   asset,
+		Gas:     100,
+		GasUsed: 40,
+		Input:   []byte{0x01, 0x02},
+		Output:  []byte{0x03},
+		Calls: []*vm.CallFrame{{
+			Type:  "STATICCALL",
+			From:  to,
+			To:    from,
+			Value: new(big.Int),
+			Error: "call failed",
+		}},
+		Transfers: []*virtualtx.VTransfer{
+			{To: from.Bytes(), Amount: 5, Asset: asset,
+				VTransferType: virtualtx.VTransferTypeCreation},
+			{From: to.Bytes(), To: from.Bytes(), Amount: 3, Asset: asset,
+				VTransferType: virtualtx.VTransferTypeNormal},
+		},
+	}
+
+	result := createCallTraceResult(frame)
+	if result.Type != "CALL" || result.From != from.String() ||
+		result.To != to.String() || result.Value != "10" ||
+		result.Asset != "000000000000000700000001" || result.Input != "0102" ||
+		result.Output != "03" || result.GasUsed != 40 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if len(result.Calls) != 1 || result.Calls[0].Type != "STATICCALL" ||
+		result.Calls[0].Error != "call failed" || result.Calls[0].Asset != "" {
+		t.Errorf("unexpected sub calls %+v", result.Calls)
+	}
+	if len(result.Transfers) != 2 {
+		t.Fatalf("got %d transfers, want 2", len(result.Transfers))
+	}
+	if creation := result.Transfers[0]; creation.Type != "creation" ||
+		creation.From != "" || creation.Amount != 5 {
+		t.Errorf("unexpected creation %+v", creation)
+	}
+	if transfer := result.Transfers[1]; transfer.Type != "transfer" ||
+		transfer.From != to.String() || transfer.To != from.String() {
+		t.Errorf("unexpected transfer %+v", transfer)
+	}
+
+	tracer := vm.NewCallTracer()
+	tracer.CaptureStart(from, to, true, nil, 100, nil)
+	tracer.CaptureEnd(nil, 30, 0, nil)
+	calls, ok := createTraceResult(tracer, 30, false).([]rpcjson.CallTraceResult)
+	if !ok || len(calls) != 1 || calls[0].Type != "CREATE" || calls[0].GasUsed != 30 {
+		t.Errorf("unexpected call tracer result %+v", calls)
+	}
+}
+
+// TestCreateVtxResult ensures the virtual transaction of a traced execution
+// is converted, and that no result is returned without one.
+func TestCreateVtxResult(t *testing.T) {
+	if result := createVtxResult(nil); result != nil {
+		t.Errorf("got result %+v without a virtual transaction", result)
+	}
+
+	asset := protos.NewAsset(0, 7, 1)
+	vtx := protos.NewMsgTx(0)
+	vtx.AddTxIn(protos.NewTxIn(protos.NewOutPoint(&common.Hash{1}, 2), nil))
+	vtx.AddTxOut(protos.NewTxOut(9, []byte{0x51}, *asset))
+	result := createVtxResult(vtx)
+	if len(result.Vin) != 1 || result.Vin[0].Vout != 2 ||
+		result.Vin[0].Txid != (common.Hash{1}).String() {
+		t.Errorf("unexpected inputs %+v", result.Vin)
+	}
+	if len(result.Vout) != 1 || result.Vout[0].Value != 9 ||
+		result.Vout[0].ScriptPubKey.Hex != "51" ||
+		result.Vout[0].Asset != "000000000000000700000001" {
+		t.Errorf("unexpected outputs %+v", result.Vout)
+	}
+}
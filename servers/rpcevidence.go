@@ -0,0 +1,107 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"bytes"
+	"encoding/hex"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+)
+
+// createEvidenceResult converts an evidence to its json representation.
+func createEvidenceResult(evidence *protos.MsgEvidence) (*rpcjson.EvidenceResult, error) {
+	hashes := evidence.BlockHashes()
+	result := &rpcjson.EvidenceResult{
+		Hash:     evidence.Hash().String(),
+		Type:     evidence.Type.String(),
+		Offender: evidence.Offender().String(),
+		Height:   evidence.Height(),
+		Blocks:   []string{hashes[0].String(), hashes[1].String()},
+	}
+
+	var buf bytes.Buffer
+	switch evidence.Type {
+	case protos.EvidenceDoubleProposal:
+		for i := range evidence.Headers {
+			buf.Reset()
+			if err := evidence.Headers[i].Serialize(&buf); err != nil {
+				return nil, err
+			}
+			result.Headers = append(result.Headers, hex.EncodeToString(buf.Bytes()))
+		}
+	case protos.EvidenceDoubleSign:
+		for i := range evidence.Signs {
+			buf.Reset()
+			if err := evidence.Signs[i].Serialize(&buf); err != nil {
+				return nil, err
+			}
+			result.Signatures = append(result.Signatures, hex.EncodeToString(buf.Bytes()))
+		}
+	}
+
+	buf.Reset()
+	if err := evidence.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	result.Hex = hex.EncodeToString(buf.Bytes())
+	return result, nil
+}
+
+// GetEvidence returns the evidence of validator misbehavior with the passed
+// hash.
+func (s *PublicRpcAPI) GetEvidence(hash string) (interface{}, error) {
+	evidenceHash := common.HexToHash(hash)
+	evidence, err := s.cfg.Chain.FetchEvidence(&evidenceHash)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch evidence")
+	}
+	if evidence == nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCEvidenceNotFound,
+			Message: "Evidence not found: " + hash,
+		}
+	}
+
+	result, err := createEvidenceResult(evidence)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to encode evidence")
+	}
+	return result, nil
+}
+
+// ListEvidence returns the evidences of validator misbehavior the node knows,
+// only the ones against the optional offender address.
+func (s *PublicRpcAPI) ListEvidence(offender *string) (interface{}, error) {
+	var offenderAddr *common.Address
+	if offender != nil {
+		addrBytes, err := hexutil.Decode(*offender)
+		if err != nil || len(addrBytes) != common.AddressLength {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+				Message: "Invalid address: " + *offender,
+			}
+		}
+		addr := common.BytesToAddress(addrBytes)
+		offenderAddr = &addr
+	}
+
+	evidences, err := s.cfg.Chain.FetchEvidences(offenderAddr)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch evidences")
+	}
+	results := make([]*rpcjson.EvidenceResult, 0, len(evidences))
+	for _, evidence := range evidences {
+		result, err := createEvidenceResult(evidence)
+		if err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to encode evidence")
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,405 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/blockchain/indexers"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/rpcs/rawdb"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+)
+
+const (
+	// logFilterTimeout is the duration after which a filter which has not
+	// been polled is uninstalled.
+	logFilterTimeout = 5 * time.Minute
+
+	// maxLogFilters is the maximum number of installed filters.
+	maxLogFilters = 1024
+
+	// maxLogBlockRange is the maximum number of blocks the logs are
+	// queried from at once.
+	maxLogBlockRange = 10000
+
+	// maxLogResults is the maximum number of logs returned at once.
+	maxLogResults = 10000
+)
+
+// logCriteria is the decoded form of rpcjson.FilterCriteria.
+type logCriteria struct {
+	addresses []common.Address
+	topics    [][]common.Hash
+}
+
+// logFilter is a filter installed by newFilter.  It remembers the last block
+// which was reported to the client so that getFilterChanges only returns the
+// logs of the blocks connected since then.
+type logFilter struct {
+	criteria   logCriteria
+	lastHash   common.Hash
+	lastHeight int32
+	lastPoll   time.Time
+}
+
+// logFilterManager keeps the filters installed through the RPC server.
+type logFilterManager struct {
+	mtx     sync.Mutex
+	filters map[string]*logFilter
+}
+
+// newLogFilterManager returns a new, empty filter manager.
+func newLogFilterManager() *logFilterManager {
+	return &logFilterManager{
+		filters: make(map[string]*logFilter),
+	}
+}
+
+// expire removes the filters which have not been polled within the timeout.
+//
+// This function MUST be called with the manager lock held.
+func (m *logFilterManager) expire(now time.Time) {
+	for id, filter := range m.filters {
+		if now.Sub(filter.lastPoll) > logFilterTimeout {
+			delete(m.filters, id)
+		}
+	}
+}
+
+// decodeFilterCriteria decodes the addresses and topics of the passed criteria.
+func decodeFilterCriteria(c *rpcjson.FilterCriteria) (*logCriteria, error) {
+	criteria := &logCriteria{
+		addresses: make([]common.Address, 0, len(c.Addresses)),
+		topics:    make([][]common.Hash, 0, len(c.Topics)),
+	}
+	for _, address := range c.Addresses {
+		addrBytes, err := hexutil.Decode(address)
+		if err != nil || len(addrBytes) != common.AddressLength {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+				Message: "Invalid address: " + address,
+			}
+		}
+		criteria.addresses = append(criteria.addresses, common.BytesToAddress(addrBytes))
+	}
+	for _, sub := range c.Topics {
+		hashes := make([]common.Hash, 0, len(sub))
+		for _, topic := range sub {
+			topicBytes := common.FromHex(topic)
+			if len(topicBytes) != common.HashLength {
+				return nil, &rpcjson.RPCError{
+					Code:    rpcjson.ErrRPCInvalidParameter,
+					Message: "Invalid topic: " + topic,
+				}
+			}
+			hashes = append(hashes, common.BytesToHash(topicBytes))
+		}
+		criteria.topics = append(criteria.topics, hashes)
+	}
+	return criteria, nil
+}
+
+// createLogResult converts a log loaded from the receipts of the block with
+// the passed hash and height to its RPC representation.
+func createLogResult(log *types.Log, hash common.Hash, height int32, removed bool) *rpcjson.LogResult {
+	topics := make([]string, 0, len(log.Topics))
+	for _, topic := range log.Topics {
+		topics = append(topics, topic.String())
+	}
+	return &rpcjson.LogResult{
+		Address:     log.Address.String(),
+		Topics:      topics,
+		Data:        common.Bytes2Hex(log.Data),
+		BlockNumber: uint64(height),
+		TxHash:      log.TxHash.String(),
+		TxIndex:     log.TxIndex,
+		BlockHash:   hash.String(),
+		Index:       log.Index,
+		Removed:     removed,
+	}
+}
+
+// blockLogs returns the logs of the block with the passed hash and height
+// which match the criteria.
+func (s *PublicRpcAPI) blockLogs(hash common.Hash, height int32, criteria *logCriteria,
+	removed bool) []*rpcjson.LogResult {
+
+	receipts := rawdb.ReadReceipts(s.cfg.Chain.EthDB(), hash, uint64(height))
+	var logs []*types.Log
+	for _, receipt := range receipts {
+		logs = append(logs, receipt.Logs...)
+	}
+
+	logs = indexers.FilterLogs(logs, criteria.addresses, criteria.topics)
+	results := make([]*rpcjson.LogResult, 0, len(logs))
+	for _, log := range logs {
+		results = append(results, createLogResult(log, hash, height, removed))
+	}
+	return results
+}
+
+// checkLogRange returns an error when the logs of more than maxLogBlockRange
+// blocks are queried at once.
+func checkLogRange(from, to int32) error {
+	if from <= to && int64(to)-int64(from) >= maxLogBlockRange {
+		return &rpcjson.RPCError{
+			Code: rpcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid block range [%d, %d], at most %d blocks",
+				from, to, maxLogBlockRange),
+		}
+	}
+	return nil
+}
+
+// pageLogs returns the logs of the main chain blocks in the range [from, to]
+// which match the criteria, along with the height of the last block they
+// cover.  It stops before the block whose logs would exceed maxLogResults, but
+// always returns the logs of the first matching block, so a caller paging
+// through the range makes progress.
+func (s *PublicRpcAPI) pageLogs(from, to int32, criteria *logCriteria) ([]*rpcjson.LogResult, int32, error) {
+	results := make([]*rpcjson.LogResult, 0)
+	if from > to {
+		return results, to, nil
+	}
+
+	heights, err := s.cfg.LogIndex.FilterBlocks(from, to, criteria.addresses, criteria.topics)
+	if err != nil {
+		return nil, 0, internalRPCError(err.Error(), "Failed to query the log index")
+	}
+	for _, height := range heights {
+		hash, err := s.cfg.Chain.BlockHashByHeight(height)
+		if err != nil {
+			// The block was disconnected since the index was
+			// queried.
+			continue
+		}
+		logs := s.blockLogs(*hash, height, criteria, false)
+		if len(results) > 0 && len(results)+len(logs) > maxLogResults {
+			return results, height - 1, nil
+		}
+		results = append(results, logs...)
+	}
+	return results, to, nil
+}
+
+// rangeLogs returns the logs of the main chain blocks in the range [from, to]
+// which match the criteria.  It fails when more than maxLogResults logs match.
+func (s *PublicRpcAPI) rangeLogs(from, to int32, criteria *logCriteria) ([]*rpcjson.LogResult, error) {
+	results, last, err := s.pageLogs(from, to, criteria)
+	if err != nil {
+		return nil, err
+	}
+	if last < to || len(results) > maxLogResults {
+		return nil, &rpcjson.RPCError{
+			Code: rpcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Query returns more than %d logs, "+
+				"narrow the block range or the criteria", maxLogResults),
+		}
+	}
+	return results, nil
+}
+
+// GetLogs returns the contract logs matching the passed criteria.  The logs
+// are either those of the block identified by BlockHash or those of the main
+// chain blocks between FromBlock and ToBlock, which both default to the best
+// block.
+func (s *PublicRpcAPI) GetLogs(c rpcjson.FilterCriteria) (interface{}, error) {
+	if s.cfg.LogIndex == nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCMisc,
+			Message: "The contract log index is not enabled",
+		}
+	}
+	criteria, err := decodeFilterCriteria(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.BlockHash != nil {
+		if c.FromBlock != nil || c.ToBlock != nil {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCInvalidParameter,
+				Message: "blockHash can not be combined with fromBlock or toBlock",
+			}
+		}
+		hash := common.HexToHash(*c.BlockHash)
+		height, err := s.cfg.Chain.BlockHeightByHash(&hash)
+		if err != nil {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCBlockNotFound,
+				Message: "Block not found",
+			}
+		}
+		return s.blockLogs(hash, height, criteria, false), nil
+	}
+
+	best := s.cfg.Chain.BestSnapshot().Height
+	from, to := best, best
+	if c.FromBlock != nil {
+		from = *c.FromBlock
+	}
+	if c.ToBlock != nil {
+		to = *c.ToBlock
+	}
+	if from < 0 || to < 0 {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Block heights must not be negative",
+		}
+	}
+	if to > best {
+		to = best
+	}
+	if err := checkLogRange(from, to); err != nil {
+		return nil, err
+	}
+	return s.rangeLogs(from, to, criteria)
+}
+
+// NewFilter installs a filter which reports the contract logs matching the
+// passed criteria through GetFilterChanges.  Only the addresses and topics of
+// the criteria are used, the filter reports the logs of the blocks connected
+// after its creation.  A filter which is not polled for five minutes is
+// uninstalled.
+func (s *PublicRpcAPI) NewFilter(c rpcjson.FilterCriteria) (interface{}, error) {
+	if s.cfg.LogIndex == nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCMisc,
+			Message: "The contract log index is not enabled",
+		}
+	}
+	criteria, err := decodeFilterCriteria(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawID [16]byte
+	if _, err := rand.Read(rawID[:]); err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to generate filter id")
+	}
+	id := hex.EncodeToString(rawID[:])
+
+	best := s.cfg.Chain.BestSnapshot()
+	now := time.Now()
+
+	s.filters.mtx.Lock()
+	defer s.filters.mtx.Unlock()
+	s.filters.expire(now)
+	if len(s.filters.filters) >= maxLogFilters {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCMisc,
+			Message: "Too many installed filters",
+		}
+	}
+	s.filters.filters[id] = &logFilter{
+		criteria:   *criteria,
+		lastHash:   best.Hash,
+		lastHeight: best.Height,
+		lastPoll:   now,
+	}
+	return id, nil
+}
+
+// GetFilterChanges returns the logs matching the filter with the passed id
+// which were emitted since the last poll.  The logs of the blocks which were
+// disconnected from the main chain since then are returned with the removed
+// flag set.  When more than maxLogResults logs were emitted, the logs of the
+// later blocks are left to the next polls.
+func (s *PublicRpcAPI) GetFilterChanges(id string) (interface{}, error) {
+	s.filters.mtx.Lock()
+	defer s.filters.mtx.Unlock()
+
+	now := time.Now()
+	s.filters.expire(now)
+	filter, ok := s.filters.filters[id]
+	if !ok {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Filter not found",
+		}
+	}
+	filter.lastPoll = now
+
+	// Walk back from the last reported block until the main chain is
+	// reached, reporting the logs of the blocks which were reorganized away.
+	chain := s.cfg.Chain
+	results := make([]*rpcjson.LogResult, 0)
+	hash, height := filter.lastHash, filter.lastHeight
+	for !chain.MainChainHasBlock(&hash) {
+		header, err := chain.FetchHeader(&hash)
+		if err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to fetch header")
+		}
+		results = append(results, s.blockLogs(hash, header.Height, &filter.criteria, true)...)
+		hash, height = header.PrevBlock, header.Height-1
+	}
+
+	best := chain.BestSnapshot()
+	logs, last, err := s.pageLogs(height+1, best.Height, &filter.criteria)
+	if err != nil {
+		return nil, err
+	}
+	lastHash := best.Hash
+	if last < best.Height {
+		hash, err := chain.BlockHashByHeight(last)
+		if err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to fetch block hash")
+		}
+		lastHash = *hash
+	}
+	filter.lastHash, filter.lastHeight = lastHash, last
+	return append(results, logs...), nil
+}
+
+// GetFilterLogs returns the logs of the main chain blocks between FromBlock
+// and ToBlock matching the filter with the passed id.  ToBlock defaults to the
+// best block and FromBlock to the first block of the last maxLogBlockRange
+// ones.
+func (s *PublicRpcAPI) GetFilterLogs(id string, fromBlock *int32, toBlock *int32) (interface{}, error) {
+	s.filters.mtx.Lock()
+	s.filters.expire(time.Now())
+	filter, ok := s.filters.filters[id]
+	s.filters.mtx.Unlock()
+	if !ok {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Filter not found",
+		}
+	}
+
+	to := s.cfg.Chain.BestSnapshot().Height
+	if toBlock != nil && *toBlock < to {
+		to = *toBlock
+	}
+	from := to - maxLogBlockRange + 1
+	if fromBlock != nil {
+		from = *fromBlock
+	}
+	if from < 0 {
+		from = 0
+	}
+	if err := checkLogRange(from, to); err != nil {
+		return nil, err
+	}
+	return s.rangeLogs(from, to, &filter.criteria)
+}
+
+// UninstallFilter removes the filter with the passed id.  It returns whether
+// the filter was installed.
+func (s *PublicRpcAPI) UninstallFilter(id string) (interface{}, error) {
+	s.filters.mtx.Lock()
+	defer s.filters.mtx.Unlock()
+
+	_, ok := s.filters.filters[id]
+	delete(s.filters.filters, id)
+	return ok, nil
+}
@@ -731,11 +731,17 @@ type rpcserverConfig struct {
 	// TxMemPool defines the transaction memory pool to interact with.
 	TxMemPool *mempool.TxPool
 
+	// FeeEstimator estimates the gas prices paid in each fee asset.
+	FeeEstimator *mempool.FeeEstimator
+
 	// These fields define any optional indexes the RPC NodeServer can make use
 	// of to provide additional data when queried.
-	TxIndex   *indexers.TxIndex
-	AddrIndex *indexers.AddrIndex
-	CfIndex   *indexers.CfIndex
+	TxIndex      *indexers.TxIndex
+	AddrIndex    *indexers.AddrIndex
+	CfIndex      *indexers.CfIndex
+	LogIndex     *indexers.LogIndex
+	BalanceIndex *indexers.BalanceIndex
+	AssetIndex   *indexers.AssetIndex
 
 	Nap fnet.NetAdapter
 
@@ -743,6 +749,10 @@ type rpcserverConfig struct {
 	ConsensusServer ainterface.Consensus
 
 	BlockTemplateGenerator *mining.BlkTmplGenerator
+
+	// Notifier delivers chain and mempool events to the subscriptions of
+	// websocket and IPC clients.
+	Notifier *rpcNotificationManager
 }
 
 func init() {
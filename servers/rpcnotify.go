@@ -0,0 +1,643 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"container/list"
+	"context"
+	"encoding/hex"
+	"sync"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/mining"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+const (
+	// maxSubscriptionQueue is the maximum number of notifications which
+	// may be pending for a single subscription.  A subscription whose
+	// connection can not keep up is dropped instead of stalling every
+	// other subscriber.
+	maxSubscriptionQueue = 1024
+
+	// spentOutputDepth is the number of blocks the watched outputs spent in
+	// the main chain are remembered for, so they are watched again when the
+	// spending block is disconnected by a reorganization.
+	spentOutputDepth = 100
+)
+
+// subscriptionKind identifies the event stream a subscription listens to.
+type subscriptionKind int
+
+const (
+	subscribeNewBlocks subscriptionKind = iota
+	subscribeReorgs
+	subscribePendingTxs
+	subscribeAddressActivity
+)
+
+// Notification types handled by the notification manager.
+type notificationBlockConnected struct {
+	block  *asiutil.Block
+	vblock *asiutil.VBlock
+}
+type notificationBlockDisconnected struct {
+	block  *asiutil.Block
+	vblock *asiutil.VBlock
+}
+type notificationReorganized blockchain.ReorganizeData
+type notificationTxAccepted mining.TxDesc
+
+// matchMode tells how a transaction matched against an address filter relates
+// to the main chain.
+type matchMode int
+
+const (
+	// matchPending is a transaction accepted into the mempool, it does not
+	// change the outputs the filter watches.
+	matchPending matchMode = iota
+
+	// matchConnected is a transaction of a block connected to the main
+	// chain.
+	matchConnected
+
+	// matchDisconnected is a transaction of a block disconnected from the
+	// main chain.
+	matchDisconnected
+)
+
+// watchedOutput describes an output paying to a watched address, it is kept
+// so the later spend of the output can be reported with full details.
+type watchedOutput struct {
+	address common.Address
+	asset   protos.Asset
+	amount  int64
+
+	// spentHeight is the height of the block spending the output, once it
+	// is spent in the main chain.
+	spentHeight int32
+}
+
+// addressFilter tracks the addresses of an addressActivity subscription and
+// the unspent outputs known to pay to them.  It must only be accessed from
+// the notification handler once the subscription is registered.
+type addressFilter struct {
+	addresses map[common.Address]struct{}
+	outpoints map[protos.OutPoint]*watchedOutput
+
+	// spent holds the watched outputs spent in the last spentOutputDepth
+	// blocks of the main chain.
+	spent map[protos.OutPoint]*watchedOutput
+}
+
+// newAddressFilter creates a filter for the passed addresses.
+func newAddressFilter(addresses []common.Address) *addressFilter {
+	filter := &addressFilter{
+		addresses: make(map[common.Address]struct{}, len(addresses)),
+		outpoints: make(map[protos.OutPoint]*watchedOutput),
+		spent:     make(map[protos.OutPoint]*watchedOutput),
+	}
+	for _, addr := range addresses {
+		filter.addresses[addr] = struct{}{}
+	}
+	return filter
+}
+
+// matchTx returns the activity of the watched addresses in the passed
+// transaction of the block at the given height.  Outputs paying to watched
+// addresses in the main chain are remembered until they are spent, so that
+// spending them is reported as well.  When the transaction is disconnected
+// from the main chain, its outputs are forgotten and the outputs it spent
+// are watched again.
+func (f *addressFilter) matchTx(tx *protos.MsgTx, txHash *common.Hash,
+	height int32, mode matchMode) []*rpcjson.AddressActivityResult {
+
+	var results []*rpcjson.AddressActivityResult
+	txid := txHash.UnprefixString()
+	removed := mode == matchDisconnected
+
+	for _, txIn := range tx.TxIn {
+		outpoint := txIn.PreviousOutPoint
+		var watched *watchedOutput
+		var ok bool
+		switch mode {
+		case matchPending:
+			watched, ok = f.outpoints[outpoint]
+		case matchConnected:
+			watched, ok = f.outpoints[outpoint]
+			if ok {
+				watched.spentHeight = height
+				f.spent[outpoint] = watched
+				delete(f.outpoints, outpoint)
+			}
+		case matchDisconnected:
+			watched, ok = f.spent[outpoint]
+			if ok {
+				watched.spentHeight = 0
+				f.outpoints[outpoint] = watched
+				delete(f.spent, outpoint)
+			}
+		}
+		if !ok {
+			continue
+		}
+		results = append(results, &rpcjson.AddressActivityResult{
+			Address: watched.address.String(),
+			Txid:    txid,
+			Vout:    txIn.PreviousOutPoint.Index,
+			Spent:   true,
+			Asset:   hex.EncodeToString(watched.asset.Bytes()),
+			Amount:  watched.amount,
+			Removed: removed,
+		})
+	}
+
+	for i, txOut := range tx.TxOut {
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			address := common.Address(addr.StandardAddress())
+			if _, ok := f.addresses[address]; !ok {
+				continue
+			}
+			outpoint := protos.OutPoint{Hash: *txHash, Index: uint32(i)}
+			switch mode {
+			case matchDisconnected:
+				delete(f.outpoints, outpoint)
+			case matchConnected:
+				f.outpoints[outpoint] = &watchedOutput{
+					address: address,
+					asset:   txOut.Asset,
+					amount:  txOut.Value,
+				}
+			}
+			results = append(results, &rpcjson.AddressActivityResult{
+				Address: address.String(),
+				Txid:    txid,
+				Vout:    uint32(i),
+				Asset:   hex.EncodeToString(txOut.Asset.Bytes()),
+				Amount:  txOut.Value,
+				Removed: removed,
+			})
+		}
+	}
+	return results
+}
+
+// rpcSubscriber is a single subscription created through asimov_subscribe.
+type rpcSubscriber struct {
+	id     rpc.ID
+	kind   subscriptionKind
+	filter *addressFilter
+	ntfns  chan interface{}
+}
+
+// rpcNotificationManager fans out block, reorganization and mempool events to
+// the subscriptions of websocket and IPC clients.
+type rpcNotificationManager struct {
+	// queueNotification queues a notification for handling.
+	queueNotification chan interface{}
+
+	// notificationMsgs feeds notificationHandler with notifications
+	// from queueNotification.
+	notificationMsgs chan interface{}
+
+	subsMtx     sync.Mutex
+	subscribers map[rpc.ID]*rpcSubscriber
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// newRPCNotificationManager returns a new notification manager ready for use.
+// See Start for how to run the manager.
+func newRPCNotificationManager() *rpcNotificationManager {
+	return &rpcNotificationManager{
+		queueNotification: make(chan interface{}),
+		notificationMsgs:  make(chan interface{}),
+		subscribers:       make(map[rpc.ID]*rpcSubscriber),
+		quit:              make(chan struct{}),
+	}
+}
+
+// queueHandler manages a queue of empty interfaces, reading from in and
+// sending the oldest unsent to out.  This handler stops when either of the
+// in or quit channels are closed, and closes out before returning, without
+// waiting to send any variables still remaining in the queue.
+func queueHandler(in <-chan interface{}, out chan<- interface{}, quit <-chan struct{}) {
+	var q list.List
+	var dequeue chan<- interface{}
+	skipQueue := out
+	var next interface{}
+out:
+	for {
+		select {
+		case n, ok := <-in:
+			if !ok {
+				// Sender closed input channel.
+				break out
+			}
+
+			// Either send to out immediately if skipQueue is
+			// non-nil (queue is empty) and reader is ready,
+			// or append to the queue and send later.
+			select {
+			case skipQueue <- n:
+			default:
+				q.PushBack(n)
+				dequeue = out
+				skipQueue = nil
+				next = q.Front().Value
+			}
+
+		case dequeue <- next:
+			q.Remove(q.Front())
+			if q.Len() == 0 {
+				dequeue = nil
+				skipQueue = out
+			} else {
+				next = q.Front().Value
+			}
+
+		case <-quit:
+			break out
+		}
+	}
+	close(out)
+}
+
+// queue pushes a notification to the manager without ever blocking the
+// caller for longer than it takes to append it to the queue.
+func (m *rpcNotificationManager) queue(n interface{}) {
+	select {
+	case m.queueNotification <- n:
+	case <-m.quit:
+	}
+}
+
+// handleBlockchainNotification is registered with the chain and converts the
+// chain notifications to notifications handled by the manager.
+func (m *rpcNotificationManager) handleBlockchainNotification(notification *blockchain.Notification) {
+	switch notification.Type {
+	case blockchain.NTBlockConnected:
+		data, ok := notification.Data.([]interface{})
+		if !ok || len(data) < 2 {
+			rpcsLog.Warnf("Chain connected notification need block & vblock.")
+			break
+		}
+		block, ok := data[0].(*asiutil.Block)
+		if !ok {
+			rpcsLog.Warnf("Chain connected notification is not a block.")
+			break
+		}
+		vblock, _ := data[1].(*asiutil.VBlock)
+		m.queue(&notificationBlockConnected{block: block, vblock: vblock})
+
+	case blockchain.NTBlockDisconnected:
+		data, ok := notification.Data.([]interface{})
+		if !ok || len(data) < 2 {
+			rpcsLog.Warnf("Chain disconnected notification need block & vblock.")
+			break
+		}
+		block, ok := data[0].(*asiutil.Block)
+		if !ok {
+			rpcsLog.Warnf("Chain disconnected notification is not a block.")
+			break
+		}
+		vblock, _ := data[1].(*asiutil.VBlock)
+		m.queue(&notificationBlockDisconnected{block: block, vblock: vblock})
+
+	case blockchain.NTChainReorganized:
+		data, ok := notification.Data.(*blockchain.ReorganizeData)
+		if !ok {
+			rpcsLog.Warnf("Chain reorganized notification is not reorganize data.")
+			break
+		}
+		m.queue((*notificationReorganized)(data))
+	}
+}
+
+// NotifyNewTransactions notifies the subscribers about the transactions newly
+// accepted into the memory pool.
+func (m *rpcNotificationManager) NotifyNewTransactions(txns []*mining.TxDesc) {
+	for _, txD := range txns {
+		m.queue((*notificationTxAccepted)(txD))
+	}
+}
+
+// notificationHandler reads notifications off the queue and dispatches them
+// to the interested subscribers.
+//
+// This MUST be run as a goroutine.
+func (m *rpcNotificationManager) notificationHandler() {
+out:
+	for {
+		select {
+		case n, ok := <-m.notificationMsgs:
+			if !ok {
+				break out
+			}
+			m.dispatch(n)
+
+		case <-m.quit:
+			break out
+		}
+	}
+	m.wg.Done()
+}
+
+// dispatch sends the passed notification to every subscriber interested in it.
+func (m *rpcNotificationManager) dispatch(n interface{}) {
+	m.subsMtx.Lock()
+	defer m.subsMtx.Unlock()
+
+	for _, sub := range m.subscribers {
+		var payloads []interface{}
+		switch n := n.(type) {
+		case *notificationBlockConnected:
+			if sub.kind == subscribeNewBlocks {
+				payloads = append(payloads, blockNotificationResult(n.block))
+			} else if sub.kind == subscribeAddressActivity {
+				payloads = matchBlock(sub.filter, n.block, n.vblock, matchConnected)
+			}
+
+		case *notificationBlockDisconnected:
+			if sub.kind == subscribeAddressActivity {
+				payloads = matchBlock(sub.filter, n.block, n.vblock, matchDisconnected)
+			}
+
+		case *notificationReorganized:
+			if sub.kind == subscribeReorgs {
+				payloads = append(payloads, reorgNotificationResult(n))
+			}
+
+		case *notificationTxAccepted:
+			if sub.kind == subscribePendingTxs {
+				payloads = append(payloads, &rpcjson.PendingTxNotificationResult{
+					Txid:     n.Tx.Hash().UnprefixString(),
+					Size:     n.Tx.MsgTx().SerializeSize(),
+					Fee:      n.Fee,
+					GasPrice: n.GasPrice,
+					Time:     n.Added.Unix(),
+				})
+			} else if sub.kind == subscribeAddressActivity {
+				for _, res := range sub.filter.matchTx(n.Tx.MsgTx(), n.Tx.Hash(), 0, matchPending) {
+					payloads = append(payloads, res)
+				}
+			}
+		}
+
+		// The queue of a dropped subscriber is closed, nothing more is
+		// sent to it.
+	deliver:
+		for _, payload := range payloads {
+			select {
+			case sub.ntfns <- payload:
+			default:
+				rpcsLog.Warnf("Dropping subscription %v, the client "+
+					"does not keep up with notifications", sub.id)
+				m.removeSubscriber(sub)
+				break deliver
+			}
+		}
+	}
+}
+
+// matchBlock returns the activity of the watched addresses in all regular and
+// virtual transactions of the passed block.  The transactions of a
+// disconnected block are matched in reverse order, so the outputs spent within
+// the block are watched again before they are forgotten.
+func matchBlock(filter *addressFilter, block *asiutil.Block, vblock *asiutil.VBlock, mode matchMode) []interface{} {
+	txs := block.Transactions()
+	if vblock != nil {
+		txs = append(txs[:len(txs):len(txs)], vblock.Transactions()...)
+	}
+
+	var payloads []interface{}
+	for i := range txs {
+		tx := txs[i]
+		if mode == matchDisconnected {
+			tx = txs[len(txs)-1-i]
+		}
+		for _, res := range filter.matchTx(tx.MsgTx(), tx.Hash(), block.Height(), mode) {
+			res.BlockHash = block.Hash().UnprefixString()
+			res.Height = block.Height()
+			payloads = append(payloads, res)
+		}
+	}
+
+	// Forget the spent outputs which are too deep to be disconnected.
+	if mode == matchConnected {
+		for outpoint, watched := range filter.spent {
+			if watched.spentHeight <= block.Height()-spentOutputDepth {
+				delete(filter.spent, outpoint)
+			}
+		}
+	}
+	return payloads
+}
+
+// blockNotificationResult creates the newBlocks payload of a block.
+func blockNotificationResult(block *asiutil.Block) *rpcjson.BlockNotificationResult {
+	header := &block.MsgBlock().Header
+	return &rpcjson.BlockNotificationResult{
+		Hash:         block.Hash().UnprefixString(),
+		Height:       block.Height(),
+		PreviousHash: header.PrevBlock.UnprefixString(),
+		StateRoot:    header.StateRoot.UnprefixString(),
+		Time:         header.Timestamp,
+		Round:        header.Round,
+		Slot:         header.SlotIndex,
+		GasLimit:     header.GasLimit,
+		GasUsed:      header.GasUsed,
+		TxCount:      len(block.Transactions()),
+	}
+}
+
+// reorgNotificationResult creates the reorgs payload of a reorganization.
+func reorgNotificationResult(n *notificationReorganized) *rpcjson.ReorgNotificationResult {
+	result := &rpcjson.ReorgNotificationResult{
+		ForkHash:   n.ForkHash.UnprefixString(),
+		ForkHeight: n.ForkHeight,
+		OldTip:     n.OldTip.UnprefixString(),
+		OldHeight:  n.OldHeight,
+		NewTip:     n.NewTip.UnprefixString(),
+		NewHeight:  n.NewHeight,
+		Detached:   make([]string, 0, len(n.Detached)),
+		Attached:   make([]string, 0, len(n.Attached)),
+	}
+	for _, hash := range n.Detached {
+		result.Detached = append(result.Detached, hash.UnprefixString())
+	}
+	for _, hash := range n.Attached {
+		result.Attached = append(result.Attached, hash.UnprefixString())
+	}
+	return result
+}
+
+// addSubscriber registers a subscription and starts forwarding its
+// notifications to the RPC connection until the client unsubscribes or the
+// connection is closed.
+func (m *rpcNotificationManager) addSubscriber(notifier *rpc.Notifier, kind subscriptionKind,
+	filter *addressFilter) *rpc.Subscription {
+
+	rpcSub := notifier.CreateSubscription()
+	sub := &rpcSubscriber{
+		id:     rpcSub.ID,
+		kind:   kind,
+		filter: filter,
+		ntfns:  make(chan interface{}, maxSubscriptionQueue),
+	}
+
+	m.subsMtx.Lock()
+	m.subscribers[sub.id] = sub
+	m.subsMtx.Unlock()
+
+	go func() {
+		defer func() {
+			m.subsMtx.Lock()
+			m.removeSubscriber(sub)
+			m.subsMtx.Unlock()
+		}()
+		for {
+			select {
+			case payload, ok := <-sub.ntfns:
+				if !ok {
+					return
+				}
+				if err := notifier.Notify(rpcSub.ID, payload); err != nil {
+					rpcsLog.Debugf("Failed to notify subscription %v: %v",
+						rpcSub.ID, err)
+					return
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			case <-m.quit:
+				return
+			}
+		}
+	}()
+
+	return rpcSub
+}
+
+// removeSubscriber unregisters a subscription.
+//
+// This function MUST be called with the subscriber lock held.
+func (m *rpcNotificationManager) removeSubscriber(sub *rpcSubscriber) {
+	if _, ok := m.subscribers[sub.id]; !ok {
+		return
+	}
+	delete(m.subscribers, sub.id)
+	close(sub.ntfns)
+}
+
+// Start starts the goroutines required for the manager to queue and process
+// notifications.
+func (m *rpcNotificationManager) Start() {
+	m.wg.Add(2)
+	go func() {
+		queueHandler(m.queueNotification, m.notificationMsgs, m.quit)
+		m.wg.Done()
+	}()
+	go m.notificationHandler()
+}
+
+// Stop shuts down the manager and waits for its goroutines to exit.
+func (m *rpcNotificationManager) Stop() {
+	close(m.quit)
+	m.wg.Wait()
+}
+
+// subscribe creates a subscription of the passed kind on the connection of
+// the request context.
+func (s *PublicRpcAPI) subscribe(ctx context.Context, kind subscriptionKind, filter *addressFilter) (*rpc.Subscription, error) {
+	if s.cfg.Notifier == nil {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	return s.cfg.Notifier.addSubscriber(notifier, kind, filter), nil
+}
+
+// NewBlocks creates a subscription that is notified each time a block is
+// connected to the main chain.
+func (s *PublicRpcAPI) NewBlocks(ctx context.Context) (*rpc.Subscription, error) {
+	return s.subscribe(ctx, subscribeNewBlocks, nil)
+}
+
+// Reorgs creates a subscription that is notified each time the main chain
+// switches to another branch.  The notification lists the blocks that were
+// detached and attached, so short lived forks are never missed.
+func (s *PublicRpcAPI) Reorgs(ctx context.Context) (*rpc.Subscription, error) {
+	return s.subscribe(ctx, subscribeReorgs, nil)
+}
+
+// PendingTransactions creates a subscription that is notified each time a
+// transaction is accepted into the memory pool.
+func (s *PublicRpcAPI) PendingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+	return s.subscribe(ctx, subscribePendingTxs, nil)
+}
+
+// AddressActivity creates a subscription that is notified each time one of the
+// given addresses receives or spends an output, either in the memory pool or
+// in a block connected to or disconnected from the main chain.
+func (s *PublicRpcAPI) AddressActivity(ctx context.Context, addresses []string) (*rpc.Subscription, error) {
+	if len(addresses) == 0 {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "At least one address is required",
+		}
+	}
+
+	addrs := make([]common.Address, 0, len(addresses))
+	for _, address := range addresses {
+		addrBytes, err := hexutil.Decode(address)
+		if err != nil || len(addrBytes) != common.AddressLength {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+				Message: "Invalid address: " + address,
+			}
+		}
+		addrs = append(addrs, common.BytesToAddress(addrBytes))
+	}
+	filter := newAddressFilter(addrs)
+
+	// Watch the unspent outputs the addresses already own so that spending
+	// them is reported as well.
+	for _, addr := range addrs {
+		view := txo.NewUtxoViewpoint()
+		outpoints, err := s.cfg.Chain.FetchUtxoViewByAddress(view, addr.Bytes())
+		if err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to fetch utxos of address")
+		}
+		for _, outpoint := range *outpoints {
+			entry := view.LookupEntry(outpoint)
+			if entry == nil || entry.IsSpent() {
+				continue
+			}
+			filter.outpoints[outpoint] = &watchedOutput{
+				address: addr,
+				asset:   *entry.Asset(),
+				amount:  entry.Amount(),
+			}
+		}
+	}
+
+	return s.subscribe(ctx, subscribeAddressActivity, filter)
+}
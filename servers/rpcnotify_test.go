@@ -0,0 +1,152 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// TestAddressFilterMatchTx ensures the outputs paying to a watched address are
+// reported when received and spent, are forgotten once spent, and are watched
+// again when the spending transaction is disconnected.
+func TestAddressFilterMatchTx(t *testing.T) {
+	watched, err := common.NewAddressWithId(common.PubKeyHashAddrID,
+		common.Hash160([]byte("watched")))
+	if err != nil {
+		t.Fatalf("NewAddressWithId error %v", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(watched)
+	if err != nil {
+		t.Fatalf("PayToAddrScript error %v", err)
+	}
+	filter := newAddressFilter([]common.Address{*watched})
+
+	receive := protos.NewMsgTx(protos.TxVersion)
+	receive.AddTxIn(protos.NewTxIn(&protos.OutPoint{Hash: common.Hash{1}}, nil))
+	receive.AddTxOut(protos.NewTxOut(1000, pkScript, asiutil.AsimovAsset))
+	receiveHash := receive.TxHash()
+	outpoint := protos.OutPoint{Hash: receiveHash, Index: 0}
+
+	spend := protos.NewMsgTx(protos.TxVersion)
+	spend.AddTxIn(protos.NewTxIn(&outpoint, nil))
+	spendHash := spend.TxHash()
+
+	check := func(name string, outpoints, spentOutpoints int) {
+		t.Helper()
+		if len(filter.outpoints) != outpoints || len(filter.spent) != spentOutpoints {
+			t.Errorf("%s: got %d watched and %d spent outputs, want %d and %d",
+				name, len(filter.outpoints), len(filter.spent),
+				outpoints, spentOutpoints)
+		}
+	}
+
+	// A pending transaction is reported without changing the filter.
+	results := filter.matchTx(receive, &receiveHash, 0, matchPending)
+	if len(results) != 1 || results[0].Spent || results[0].Amount != 1000 {
+		t.Fatalf("pending receive: got %d results, want the output", len(results))
+	}
+	check("pending receive", 0, 0)
+
+	results = filter.matchTx(receive, &receiveHash, 10, matchConnected)
+	if len(results) != 1 || results[0].Spent || results[0].Removed {
+		t.Fatalf("receive: got %d results, want the output", len(results))
+	}
+	check("receive", 1, 0)
+
+	results = filter.matchTx(spend, &spendHash, 0, matchPending)
+	if len(results) != 1 || !results[0].Spent {
+		t.Fatalf("pending spend: got %d results, want the spend", len(results))
+	}
+	check("pending spend", 1, 0)
+
+	results = filter.matchTx(spend, &spendHash, 11, matchConnected)
+	if len(results) != 1 || !results[0].Spent || results[0].Amount != 1000 {
+		t.Fatalf("spend: got %d results, want the spend", len(results))
+	}
+	check("spend", 0, 1)
+
+	// Disconnecting the spend watches the output again, disconnecting the
+	// receive forgets it.
+	results = filter.matchTx(spend, &spendHash, 11, matchDisconnected)
+	if len(results) != 1 || !results[0].Spent || !results[0].Removed {
+		t.Fatalf("disconnect spend: got %d results, want the removed spend",
+			len(results))
+	}
+	check("disconnect spend", 1, 0)
+
+	results = filter.matchTx(receive, &receiveHash, 10, matchDisconnected)
+	if len(results) != 1 || results[0].Spent || !results[0].Removed {
+		t.Fatalf("disconnect receive: got %d results, want the removed "+
+			"output", len(results))
+	}
+	check("disconnect receive", 0, 0)
+
+	// The spent outputs are forgotten once too deep to be disconnected.
+	filter.matchTx(receive, &receiveHash, 10, matchConnected)
+	filter.matchTx(spend, &spendHash, 11, matchConnected)
+	block := asiutil.NewBlock(&protos.MsgBlock{
+		Header: protos.BlockHeader{Height: 11 + spentOutputDepth},
+	})
+	matchBlock(filter, block, nil, matchConnected)
+	check("deep spend", 0, 0)
+}
+
+// TestDispatchSlowSubscriber ensures a subscriber whose queue is full is
+// dropped, and nothing is sent to it afterwards, while the other payloads of
+// the notification are dispatched.
+func TestDispatchSlowSubscriber(t *testing.T) {
+	watched, err := common.NewAddressWithId(common.PubKeyHashAddrID,
+		common.Hash160([]byte("watched")))
+	if err != nil {
+		t.Fatalf("NewAddressWithId error %v", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(watched)
+	if err != nil {
+		t.Fatalf("PayToAddrScript error %v", err)
+	}
+
+	// A block paying twice to the watched address makes two payloads.
+	msgBlock := &protos.MsgBlock{Header: protos.BlockHeader{Height: 1}}
+	for i := byte(1); i <= 2; i++ {
+		tx := protos.NewMsgTx(protos.TxVersion)
+		tx.AddTxIn(protos.NewTxIn(&protos.OutPoint{Hash: common.Hash{i}}, nil))
+		tx.AddTxOut(protos.NewTxOut(1000, pkScript, asiutil.AsimovAsset))
+		msgBlock.AddTransaction(tx)
+	}
+	block := asiutil.NewBlock(msgBlock)
+
+	m := newRPCNotificationManager()
+	slow := &rpcSubscriber{
+		id:     rpc.ID("slow"),
+		kind:   subscribeAddressActivity,
+		filter: newAddressFilter([]common.Address{*watched}),
+		ntfns:  make(chan interface{}, 1),
+	}
+	slow.ntfns <- struct{}{}
+	idle := &rpcSubscriber{
+		id:     rpc.ID("idle"),
+		kind:   subscribeAddressActivity,
+		filter: newAddressFilter([]common.Address{*watched}),
+		ntfns:  make(chan interface{}, 2),
+	}
+	m.subscribers[slow.id] = slow
+	m.subscribers[idle.id] = idle
+
+	m.dispatch(&notificationBlockConnected{block: block})
+
+	if _, ok := m.subscribers[slow.id]; ok {
+		t.Errorf("dispatch: the slow subscriber was not dropped")
+	}
+	if _, ok := m.subscribers[idle.id]; !ok || len(idle.ntfns) != 2 {
+		t.Errorf("dispatch: got %d payloads for the idle subscriber, want 2",
+			len(idle.ntfns))
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"bytes"
+	"encoding/hex"
+
+	"github.com/AsimovNetwork/asimov/asiutil/bloom"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+)
+
+// encodeProof hex encodes the nodes of a merkle proof.
+func encodeProof(proof [][]byte) []string {
+	result := make([]string, 0, len(proof))
+	for _, node := range proof {
+		result = append(result, hex.EncodeToString(node))
+	}
+	return result
+}
+
+// GetProof returns the merkle proof of the account at the passed address and
+// of the passed storage slots of the account.  The proofs are built against
+// the state root of the best block, or of the optional block.  An account
+// which does not exist is proven absent by its account proof.
+func (s *PublicRpcAPI) GetProof(address string, storageKeys []string, blockRef *rpcjson.BlockRef) (interface{}, error) {
+	addrBytes, err := hexutil.Decode(address)
+	if err != nil || len(addrBytes) != common.AddressLength {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid address: " + address,
+		}
+	}
+	addr := common.BytesToAddress(addrBytes)
+
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
+	height := block.Height() - 1
+	header, err := s.cfg.Chain.FetchHeader(&block.MsgBlock().Header.PrevBlock)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch block header")
+	}
+
+	accountProof, err := stateDB.GetProof(addr)
+	if err != nil {
+		return nil, stateError(err, height)
+	}
+	result := &rpcjson.AccountProofResult{
+		Address:      address,
+		BlockHash:    header.BlockHash().String(),
+		Height:       height,
+		StateRoot:    header.StateRoot.String(),
+		AccountProof: encodeProof(accountProof),
+		StorageProof: make([]rpcjson.StorageProofResult, 0, len(storageKeys)),
+	}
+
+	exist := stateDB.Exist(addr)
+	if exist {
+		result.Balance = stateDB.GetBalance(addr).String()
+		result.Nonce = stateDB.GetNonce(addr)
+		result.CodeHash = stateDB.GetCodeHash(addr).String()
+		result.StorageHash = stateDB.StorageTrie(addr).Hash().String()
+	}
+	for _, key := range storageKeys {
+		slot := common.HexToHash(key)
+		storage := rpcjson.StorageProofResult{
+			Key:   key,
+			Value: stateDB.GetState(addr, slot).String(),
+			Proof: []string{},
+		}
+		if exist {
+			proof, err := stateDB.GetStorageProof(addr, slot)
+			if err != nil {
+				return nil, stateError(err, height)
+			}
+			storage.Proof = encodeProof(proof)
+		}
+		result.StorageProof = append(result.StorageProof, storage)
+	}
+	if stateDB.Error() != nil {
+		return nil, stateError(stateDB.Error(), height)
+	}
+	return result, nil
+}
+
+// GetTxOutProof returns a hex encoded merkle block which proves that the
+// transactions with the passed ids are included in a block.  The block is
+// located with the transaction index unless its hash is passed.  All the
+// transactions must be in the same block.  The outputs of a transaction are
+// proven by its inclusion since they are committed to by its hash.
+func (s *PublicRpcAPI) GetTxOutProof(txIds []string, blockHash *string) (interface{}, error) {
+	if len(txIds) == 0 {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "At least one transaction id is required",
+		}
+	}
+	txHashes := make([]*common.Hash, 0, len(txIds))
+	seen := make(map[common.Hash]struct{}, len(txIds))
+	for _, txId := range txIds {
+		txHash := common.HexToHash(txId)
+		if _, ok := seen[txHash]; ok {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCInvalidParameter,
+				Message: "Duplicated transaction id: " + txId,
+			}
+		}
+		seen[txHash] = struct{}{}
+		txHashes = append(txHashes, &txHash)
+	}
+
+	var hash common.Hash
+	if blockHash != nil {
+		hash = common.HexToHash(*blockHash)
+	} else {
+		if s.cfg.TxIndex == nil {
+			return nil, &rpcjson.RPCError{
+				Code: rpcjson.ErrRPCNoTxInfo,
+				Message: "The transaction index must be enabled to " +
+					"locate transactions without a block hash " +
+					"(specify --txindex)",
+			}
+		}
+		blockRegion, err := s.cfg.TxIndex.FetchBlockRegion(txHashes[0][:])
+		if err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to retrieve transaction location")
+		}
+		if blockRegion == nil || blockRegion.Key.IsVirtual() {
+			return nil, rpcNoTxInfoError(txHashes[0])
+		}
+		copy(hash[:], blockRegion.Key[:common.HashLength])
+	}
+
+	// The blocks of the side chains are known too, but prove nothing.
+	notFound := &rpcjson.RPCError{
+		Code:    rpcjson.ErrRPCBlockNotFound,
+		Message: "Block not found in the main chain: " + hash.String(),
+	}
+	if !s.cfg.Chain.MainChainHasBlock(&hash) {
+		return nil, notFound
+	}
+	block, err := s.cfg.Chain.BlockByHash(&hash)
+	if err != nil {
+		return nil, notFound
+	}
+
+	merkleBlock, indices := bloom.NewMerkleBlockWithTxs(block, txHashes)
+	if len(indices) != len(txHashes) {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Not all transactions found in block " + hash.String(),
+		}
+	}
+	return messageToHex(merkleBlock)
+}
+
+// VerifyTxOutProof verifies a merkle block returned by gettxoutproof and
+// returns the ids of the transactions it proves.  An error is returned when
+// the proof is malformed, does not commit to the merkle root of its header
+// or the block is not in the main chain.
+func (s *PublicRpcAPI) VerifyTxOutProof(proof string) (interface{}, error) {
+	proofBytes, err := hex.DecodeString(proof)
+	if err != nil {
+		return nil, rpcDecodeHexError(proof)
+	}
+	var merkleBlock protos.MsgMerkleBlock
+	err = merkleBlock.VVSDecode(bytes.NewReader(proofBytes), maxProtocolVersion,
+		protos.BaseEncoding)
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCDeserialization,
+			Message: "Failed to decode proof: " + err.Error(),
+		}
+	}
+
+	root, matches, _, err := bloom.ExtractMerkleBlockMatches(&merkleBlock)
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Invalid proof: " + err.Error(),
+		}
+	}
+	if *root != merkleBlock.Header.MerkleRoot {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "Proof does not match the merkle root of the block",
+		}
+	}
+	blockHash := merkleBlock.Header.BlockHash()
+	if !s.cfg.Chain.MainChainHasBlock(&blockHash) {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCBlockNotFound,
+			Message: "Block not found in the main chain: " + blockHash.String(),
+		}
+	}
+
+	txIds := make([]string, 0, len(matches))
+	for _, hash := range matches {
+		txIds = append(txIds, hash.String())
+	}
+	return txIds, nil
+}
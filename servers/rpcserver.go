@@ -17,6 +17,7 @@ import (
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/consensus/signer"
 	fnet "github.com/AsimovNetwork/asimov/common/net"
 	"github.com/AsimovNetwork/asimov/crypto"
 	"github.com/AsimovNetwork/asimov/database"
@@ -32,6 +33,7 @@ import (
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/trie"
 	"math"
 	"math/big"
 	"strconv"
@@ -40,15 +42,17 @@ import (
 )
 
 type PublicRpcAPI struct {
-	stack *node.Node
-	cfg   *rpcserverConfig
+	stack   *node.Node
+	cfg     *rpcserverConfig
+	filters *logFilterManager
 }
 
 // NewPublicWeb3API creates a new Web3Service instance
 func NewPublicRpcAPI(stack *node.Node, config *rpcserverConfig) *PublicRpcAPI {
 	return &PublicRpcAPI{
-		stack: stack,
-		cfg:   config,
+		stack:   stack,
+		cfg:     config,
+		filters: newLogFilterManager(),
 	}
 }
 
@@ -122,6 +126,17 @@ func (s *PublicRpcAPI) GetBestBlock() (*rpcjson.GetBestBlockResult, error) {
 	return result, nil
 }
 
+// GetFinalizedBlock returns the hash and the height of the last finalized
+// block, the main chain never reorganizes below it.
+func (s *PublicRpcAPI) GetFinalizedBlock() (*rpcjson.GetBestBlockResult, error) {
+	hash, height := s.cfg.Chain.GetFinalizedBlock()
+	result := &rpcjson.GetBestBlockResult{
+		Hash:   hash.UnprefixString(),
+		Height: height,
+	}
+	return result, nil
+}
+
 func (s *PublicRpcAPI) GetBlock(blockHash string, verbose bool, verboseTx bool) (interface{}, error) {
 	// Load the raw block bytes from the database.
 	hash := common.HexToHash(blockHash)
@@ -806,7 +821,10 @@ func (s *PublicRpcAPI) CreateRawTransaction(inputs []rpcjson.TransactionInput, o
 				caller := addresses[0].StandardAddress()
 
 				category, templateName, _, ok := blockchain.DecodeCreateContractData(data)
-				block, stateDB := createTempBlockState(s.cfg)
+				block, stateDB, err := createBlockState(s.cfg, nil)
+				if err != nil {
+					return nil, err
+				}
 
 				_, ok, _ = s.cfg.Chain.GetByteCode(nil, block, common.SystemContractReadOnlyGas,
 					stateDB, chaincfg.ActiveNetParams.FvmParam, category, templateName)
@@ -983,8 +1001,11 @@ func (s *PublicRpcAPI) GetGenesisContract(contractAddr common.Address) (interfac
 }
 
 // Get the contract addresses which issued the given assets
-func (s *PublicRpcAPI) GetContractAddressesByAssets(assets []string) (interface{}, error) {
-	block, stateDB := createTempBlockState(s.cfg)
+func (s *PublicRpcAPI) GetContractAddressesByAssets(assets []string, blockRef *rpcjson.BlockRef) (interface{}, error) {
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
 	var result = make([]string, 0)
 
 	contractAddrs, isSuccess, _ := s.cfg.ContractMgr.GetContractAddressByAsset(common.SystemContractReadOnlyGas,
@@ -1000,9 +1021,12 @@ func (s *PublicRpcAPI) GetContractAddressesByAssets(assets []string) (interface{
 }
 
 // Get detail information of given assets.
-func (s *PublicRpcAPI) GetAssetInfoList(assets []string) (interface{}, error) {
+func (s *PublicRpcAPI) GetAssetInfoList(assets []string, blockRef *rpcjson.BlockRef) (interface{}, error) {
 
-	block, stateDB := createTempBlockState(s.cfg)
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
 
 	results, err := s.cfg.ContractMgr.GetAssetInfoByAssetId(
 		block, stateDB, chaincfg.ActiveNetParams.FvmParam, assets)
@@ -1016,9 +1040,13 @@ func (s *PublicRpcAPI) GetAssetInfoList(assets []string) (interface{}, error) {
 
 // Get a list of template information.
 // The result contains a page from pageNo to pageNo+pageSize of given category.
-func (s *PublicRpcAPI) GetContractTemplateList(approved bool, category uint16, pageNo int, pageSize int) (interface{}, error) {
+func (s *PublicRpcAPI) GetContractTemplateList(approved bool, category uint16, pageNo int, pageSize int,
+	blockRef *rpcjson.BlockRef) (interface{}, error) {
 
-	block, stateDB := createTempBlockState(s.cfg)
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
 
 	var getCountFunc string
 	var getTempFunc string
@@ -1041,8 +1069,8 @@ func (s *PublicRpcAPI) GetContractTemplateList(approved bool, category uint16, p
 }
 
 // Get name of the template based on which the given address (contract) is deployed.
-func (s *PublicRpcAPI) GetContractTemplateName(contractAddress string) (interface{}, error) {
-	result, err := s.GetContractTemplate(contractAddress)
+func (s *PublicRpcAPI) GetContractTemplateName(contractAddress string, blockRef *rpcjson.BlockRef) (interface{}, error) {
+	result, err := s.GetContractTemplate(contractAddress, blockRef)
 
 	if err != nil {
 		return result, err
@@ -1052,7 +1080,7 @@ func (s *PublicRpcAPI) GetContractTemplateName(contractAddress string) (interfac
 }
 
 // Get information of the template based on which the given address (contract) is deployed.
-func (s *PublicRpcAPI) GetContractTemplate(contractAddress string) (interface{}, error) {
+func (s *PublicRpcAPI) GetContractTemplate(contractAddress string, blockRef *rpcjson.BlockRef) (interface{}, error) {
 	chain := s.cfg.Chain
 	addr, err := hexutil.Decode(contractAddress)
 	if err != nil {
@@ -1063,7 +1091,10 @@ func (s *PublicRpcAPI) GetContractTemplate(contractAddress string) (interface{},
 		return nil, internalRPCError("The input contract address is not valid", "")
 	}
 
-	block, stateDB := createTempBlockState(s.cfg)
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
 
 	templateType, templateName, _ := chain.GetTemplateInfo(addr, common.SystemContractReadOnlyGas,
 		block, stateDB, chaincfg.ActiveNetParams.FvmParam)
@@ -1075,11 +1106,15 @@ func (s *PublicRpcAPI) GetContractTemplate(contractAddress string) (interface{},
 }
 
 // Call a readonly function (view, pure in solidity) in a contract and return the execution result of the contract function
-func (s *PublicRpcAPI) CallReadOnlyFunction(callerAddress string, contractAddress string, data string, name string, abi string) (interface{}, error) {
+func (s *PublicRpcAPI) CallReadOnlyFunction(callerAddress string, contractAddress string, data string, name string, abi string,
+	blockRef *rpcjson.BlockRef) (interface{}, error) {
 
 	input := common.Hex2Bytes(data)
 
-	block, stateDB := createTempBlockState(s.cfg)
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
 
 	callerAddressBytes, err := hexutil.Decode(callerAddress)
 	if err != nil {
@@ -1098,6 +1133,9 @@ func (s *PublicRpcAPI) CallReadOnlyFunction(callerAddress string, contractAddres
 	fmt.Println(data)
 
 	ret, _, err := fvm.CallReadOnlyFunction(callerAddr, block, s.cfg.Chain, stateDB, chaincfg.ActiveNetParams.FvmParam, common.SystemContractReadOnlyGas, contractAddr, input)
+	if stateDB.Error() != nil {
+		return nil, stateError(stateDB.Error(), block.Height()-1)
+	}
 	if err != nil {
 		return nil, internalRPCError(err.Error(), "Failed to call readonly function")
 	}
@@ -1113,14 +1151,18 @@ func (s *PublicRpcAPI) CallReadOnlyFunction(callerAddress string, contractAddres
 // The function provides a way to call a contract function in a state preserving manner.
 // A contract function can be called and returns the execution result without affecting the block state.
 // In order to prevent the execution to end in OUT OF GAS, the gas set to call the contract function is 1000000000.
-func (s *PublicRpcAPI) Call(callerAddress string, contractAddress string, data string, name string, abiStr string, amount int64, asset string) (interface{}, error) {
+func (s *PublicRpcAPI) Call(callerAddress string, contractAddress string, data string, name string, abiStr string, amount int64, asset string,
+	blockRef *rpcjson.BlockRef) (interface{}, error) {
 
 	res := &rpcjson.CallResult{}
 	input := common.Hex2Bytes(data)
 
 	chain := s.cfg.Chain
 
-	block, stateDB := createTempBlockState(s.cfg)
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
 
 	callerAddressBytes, err := hexutil.Decode(callerAddress)
 	if err != nil {
@@ -1174,6 +1216,9 @@ func (s *PublicRpcAPI) Call(callerAddress string, contractAddress string, data s
 
 	ret, leftGas, _, err := vmInstance.Call(vm.AccountRef(callerAddr), contractAddr, input, uint64(1000000000), big.NewInt(amount), assets, true)
 	res.GasUsed = uint64(1000000000) - leftGas
+	if stateDB.Error() != nil {
+		return nil, stateError(stateDB.Error(), block.Height()-1)
+	}
 	if err != nil {
 		return nil, internalRPCError(err.Error(), "Failed to call contract function")
 	}
@@ -1289,7 +1334,10 @@ func (s *PublicRpcAPI) Test(callerAddress string, byteCode string, argStr string
 			return value
 		}
 
-		block, stateDB := createTempBlockState(s.cfg)
+		block, stateDB, err := createBlockState(s.cfg, nil)
+		if err != nil {
+			return nil, err
+		}
 		context := fvm.NewFVMContext(callerAddr, new(big.Int).SetInt64(1), block, chain, nil, voteValueFunc)
 		vmInstance := vm.NewFVM(context, stateDB, chaincfg.ActiveNetParams.FvmParam, *chain.GetVmConfig())
 
@@ -1351,33 +1399,92 @@ func (s *PublicRpcAPI) Test(callerAddress string, byteCode string, argStr string
 	return res, nil
 }
 
-// create a template block and a stateDB.
-func createTempBlockState(cfg   *rpcserverConfig) (*asiutil.Block, *state.StateDB) {
-	tipNode := cfg.Chain.GetTip()
+// createBlockState creates a template block on top of the main chain block
+// referenced by the passed argument, the best block when it is nil, and a
+// stateDB of the state after that block.  A call executed with them sees the
+// chain as it was right after the referenced block was connected.
+//
+// It serves the optional block argument of the handlers reading the contract
+// state, which selects the block by height, by hash or as the last finalized
+// block.
+func createBlockState(cfg *rpcserverConfig, ref *rpcjson.BlockRef) (*asiutil.Block, *state.StateDB, error) {
+	node := cfg.Chain.GetTip()
+	if ref != nil && ref.Finalized {
+		_, height := cfg.Chain.GetFinalizedBlock()
+		node, _ = cfg.Chain.GetNodeByHeight(height)
+	} else if ref != nil && ref.Hash != nil {
+		hash := common.HexToHash(*ref.Hash)
+		height, err := cfg.Chain.BlockHeightByHash(&hash)
+		if err != nil {
+			return nil, nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCBlockNotFound,
+				Message: "Block not found in the main chain: " + *ref.Hash,
+			}
+		}
+		node, _ = cfg.Chain.GetNodeByHeight(height)
+	} else if ref != nil && ref.Height != nil {
+		var err error
+		node, err = cfg.Chain.GetNodeByHeight(*ref.Height)
+		if err != nil {
+			return nil, nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCBlockHeightNotFound,
+				Message: fmt.Sprintf("Block height %d out of range", *ref.Height),
+			}
+		}
+	}
+	if node == nil {
+		return nil, nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCBlockNotFound,
+			Message: "Block not found in the main chain",
+		}
+	}
+
 	header := protos.BlockHeader{
-		PrevBlock:tipNode.Hash(),
-		SlotIndex:tipNode.Slot() + 1,
-		Round:    tipNode.Round(),
-		Height:   tipNode.Height() + 1,
+		PrevBlock: node.Hash(),
+		SlotIndex: node.Slot() + 1,
+		Round:     node.Round(),
+		Height:    node.Height() + 1,
 	}
-	if tipNode.Slot() == cfg.ChainParams.RoundSize {
+	if node.Slot()+1 >= cfg.ChainParams.RoundSizeAt(node.Round()) {
 		header.SlotIndex = 0
-		header.Round ++
+		header.Round++
 	}
-	block := asiutil.NewBlock(&protos.MsgBlock{Header:header})
+	block := asiutil.NewBlock(&protos.MsgBlock{Header: header})
 
-	stateDB, err := state.New(tipNode.StateRoot(), cfg.Chain.GetStateCache())
+	if err := cfg.Chain.CheckStateRetained(node.Height()); err != nil {
+		return nil, nil, stateError(err, node.Height())
+	}
+	stateDB, err := state.New(node.StateRoot(), cfg.Chain.GetStateCache())
 	if err != nil {
-		return nil, nil
+		return nil, nil, stateError(err, node.Height())
 	}
-	return block, stateDB
+	return block, stateDB, nil
+}
+
+// stateError converts an error raised while reading the state after the
+// block at the passed height.  A missing trie node means the state was
+// pruned.
+func stateError(err error, height int32) error {
+	if _, ok := err.(blockchain.StatePrunedError); ok {
+		return &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCStatePruned,
+			Message: err.Error(),
+		}
+	}
+	if _, ok := err.(*trie.MissingNodeError); ok {
+		return &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCStatePruned,
+			Message: fmt.Sprintf("State of block %d is pruned: %v", height, err),
+		}
+	}
+	return internalRPCError(err.Error(), "Failed to load state")
 }
 
 // This function is provided only for INTERNAL USE.
 // By running this function, the caller can estimate gas cost of a specific contract call.
 // Note the estimated gas cost is augmented by 120% in order to prevent OUT OF GAS error in real execution.
 func (s *PublicRpcAPI) EstimateGas(caller string, contractAddress string, amount int64, asset string, data string,
-	callType string, voteValue int64) (interface{}, error) {
+	callType string, voteValue int64, blockRef *rpcjson.BlockRef) (interface{}, error) {
 
 	chain := s.cfg.Chain
 
@@ -1404,7 +1511,10 @@ func (s *PublicRpcAPI) EstimateGas(caller string, contractAddress string, amount
 			return voteValue
 		}
 	}
-	block, stateDB := createTempBlockState(s.cfg)
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return 0, err
+	}
 
 	context := fvm.NewFVMContext(callerAddr, new(big.Int).SetInt64(1), block, chain, nil, voteValueFunc)
 	vmInstance := vm.NewFVM(context, stateDB, chaincfg.ActiveNetParams.FvmParam, *chain.GetVmConfig())
@@ -1462,6 +1572,9 @@ func (s *PublicRpcAPI) EstimateGas(caller string, contractAddress string, amount
 	if err != nil {
 		return 0, internalRPCError(err.Error(), "Failed to validate transfer")
 	}
+	if stateDB.Error() != nil {
+		return 0, stateError(stateDB.Error(), block.Height()-1)
+	}
 
 	result := uint64(1000000000) - leftOverGas
 	result = uint64(math.Ceil(float64(result + uint64(2300)) * float64(1.2)))
@@ -1473,7 +1586,8 @@ func (s *PublicRpcAPI) EstimateGas(caller string, contractAddress string, amount
 // This function is provided only for INTERNAL USE.
 // This function provides a way to run a transaction in a state preserving manner.
 // Which is especially useful to estimate gas cost at blockchain level instead of VM level (compared to `EstimateGas`)
-func (s *PublicRpcAPI) RunTransaction(hexTx string, utxos []*rpcjson.ListUnspentResult) (interface{}, error) {
+func (s *PublicRpcAPI) RunTransaction(hexTx string, utxos []*rpcjson.ListUnspentResult,
+	blockRef *rpcjson.BlockRef) (interface{}, error) {
 	bytesTx, err := hex.DecodeString(hexTx)
 	if err != nil {
 		return nil, internalRPCError(err.Error(), "Failed to decode hex tx")
@@ -1530,10 +1644,13 @@ func (s *PublicRpcAPI) RunTransaction(hexTx string, utxos []*rpcjson.ListUnspent
 		}
 	}
 
-	block, stateDB := createTempBlockState(s.cfg)
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
 	block.MsgBlock().AddTransaction(tx)
 
-	fee, _, _ := blockchain.CheckTransactionInputs(asiutil.NewTx(tx), s.cfg.Chain.BestSnapshot().Height, view, s.cfg.Chain)
+	fee, _, _ := blockchain.CheckTransactionInputs(asiutil.NewTx(tx), block.Height()-1, view, s.cfg.Chain)
 	receipt, err, gasUsed, vtx, _ := s.cfg.Chain.ConnectTransaction(
 		block, 0, view, asiutil.NewTx(tx),
 		nil, stateDB, fee)
@@ -1545,22 +1662,7 @@ func (s *PublicRpcAPI) RunTransaction(hexTx string, utxos []*rpcjson.ListUnspent
 		Receipt: receipt,
 		GasUsed: gasUsed,
 	}
-	if vtx != nil {
-		result.VTX = &rpcjson.TxRawDecodeResult{}
-		result.VTX.Vin = make([]rpcjson.Vin, len(vtx.TxIn))
-		result.VTX.Vout = make([]rpcjson.Vout, len(vtx.TxOut))
-		for i, in := range vtx.TxIn {
-			vin := &result.VTX.Vin[i]
-			vin.Txid = in.PreviousOutPoint.Hash.String()
-			vin.Vout = in.PreviousOutPoint.Index
-		}
-		for i, out := range vtx.TxOut {
-			vout := &result.VTX.Vout[i]
-			vout.Value = out.Value
-			vout.ScriptPubKey.Hex = hex.EncodeToString(out.PkScript)
-			vout.Asset = hex.EncodeToString(out.Asset.Bytes())
-		}
-	}
+	result.VTX = createVtxResult(vtx)
 
 	return result, nil
 
@@ -1850,9 +1952,11 @@ func (s *PublicRpcAPI) SendRawTransaction(hexTx string) (interface{}, error) {
 	// accepted.
 	s.cfg.ConnMgr.RelayTransactions(acceptedTxs)
 
-	// Notify both websocket and getblocktemplate long poll clients of all
-	// newly accepted transactions.
-	// s.NotifyNewTransactions(acceptedTxs)
+	// Notify the websocket and IPC subscriptions of all newly accepted
+	// transactions.
+	if s.cfg.Notifier != nil {
+		s.cfg.Notifier.NotifyNewTransactions(acceptedTxs)
+	}
 
 	// Keep track of all the sendrawtransaction request txns so that they
 	// can be rebroadcast if they don't make their way into a block.
@@ -1863,6 +1967,73 @@ func (s *PublicRpcAPI) SendRawTransaction(hexTx string) (interface{}, error) {
 	return tx.Hash().String(), nil
 }
 
+// SubmitPackage accepts a package of raw transactions into the memory pool at
+// once, ordered with every parent before its children, so a stuck
+// transaction can be bumped by a child paying for both.  It returns the ids
+// of the transactions of the package.
+func (s *PublicRpcAPI) SubmitPackage(hexTxs []string) (interface{}, error) {
+	txs := make([]*asiutil.Tx, 0, len(hexTxs))
+	for _, hexStr := range hexTxs {
+		if len(hexStr)%2 != 0 {
+			hexStr = "0" + hexStr
+		}
+		serializedTx, err := hex.DecodeString(hexStr)
+		if err != nil {
+			return nil, rpcDecodeHexError(hexStr)
+		}
+
+		var msgTx protos.MsgTx
+		err = msgTx.Deserialize(bytes.NewReader(serializedTx))
+		if err != nil {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCDeserialization,
+				Message: "TX decode failed: " + err.Error(),
+			}
+		}
+		txs = append(txs, asiutil.NewTx(&msgTx))
+	}
+
+	acceptedTxs, err := s.cfg.TxMemPool.ProcessPackage(txs)
+	if err != nil {
+		// A package breaking the rules of the memory pool is an invalid
+		// parameter of the call, not a failure of the server.
+		if _, ok := err.(mempool.RuleError); ok {
+			rpcsLog.Debugf("Rejected package of %d transactions: %v",
+				len(txs), err)
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCInvalidParameter,
+				Message: "Package rejected: " + err.Error(),
+			}
+		}
+		rpcsLog.Errorf("Failed to process package of %d "+
+			"transactions: %v", len(txs), err)
+		return nil, internalRPCError(err.Error(), "Failed to ProcessPackage")
+	}
+
+	// Relay and notify all newly accepted transactions, parents first.
+	s.cfg.ConnMgr.RelayTransactions(acceptedTxs)
+	if s.cfg.Notifier != nil {
+		s.cfg.Notifier.NotifyNewTransactions(acceptedTxs)
+	}
+
+	// Keep track of the transactions of the package so that they can be
+	// rebroadcast if they don't make their way into a block.
+	packageTxs := make(map[common.Hash]struct{}, len(txs))
+	txIds := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		packageTxs[*tx.Hash()] = struct{}{}
+		txIds = append(txIds, tx.Hash().String())
+	}
+	for _, txD := range acceptedTxs {
+		if _, ok := packageTxs[*txD.Tx.Hash()]; ok {
+			iv := protos.NewInvVect(protos.InvTypeTx, txD.Tx.Hash())
+			s.cfg.ConnMgr.AddRebroadcastInventory(iv, txD)
+		}
+	}
+
+	return txIds, nil
+}
+
 // 	Address     string
 // 	Verbose     *int  `jsonrpcdefault:"1"`
 // 	Skip        *int  `jsonrpcdefault:"0"`
@@ -2287,6 +2458,43 @@ func (s *PublicRpcAPI) GetMempoolTransactions(txIds []string) (interface{}, erro
 	return result, nil
 }
 
+// EstimateGasPrice returns the gas price, in the given fee asset, a
+// transaction should pay to be mined within the target number of blocks.  The
+// asset defaults to the asimov coin.
+func (s *PublicRpcAPI) EstimateGasPrice(targetBlocks uint32, asset string) (interface{}, error) {
+	feeAsset := asiutil.AsimovAsset
+	if asset != "" {
+		assetBytes, err := hex.DecodeString(asset)
+		if err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to decode asset")
+		}
+		feeAsset = *protos.AssetFromBytes(assetBytes)
+	}
+
+	price, err := s.cfg.FeeEstimator.EstimateGasPrice(targetBlocks, feeAsset)
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: err.Error(),
+		}
+	}
+	return price, nil
+}
+
+// SaveMempool saves the transactions of the memory pool to the data
+// directory, like on shutdown, so they are not lost if the node stops
+// abruptly.
+func (s *PublicRpcAPI) SaveMempool() (interface{}, error) {
+	count, err := s.cfg.TxMemPool.Save()
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to save mempool")
+	}
+	return &rpcjson.SaveMempoolResult{
+		Filename:     s.cfg.TxMemPool.DumpFile(),
+		Transactions: count,
+	}, nil
+}
+
 func (s *PublicRpcAPI) AddNode(_addr string, _subCmd rpcjson.AddNodeSubCmd) (interface{}, error) {
 	addr := fnet.NormalizeAddress(_addr, s.cfg.ChainParams.DefaultPort)
 	var err error
@@ -2318,9 +2526,12 @@ func (s *PublicRpcAPI) AddNode(_addr string, _subCmd rpcjson.AddNodeSubCmd) (int
 // Get the list of assets which can be used as transaction fees on Asimov blockchain
 // By default, only Asim can be used as transaction fee.
 // The validator committee can choose to add new asset to the list as needed.
-func (s *PublicRpcAPI) GetFeeList() (interface{}, error) {
+func (s *PublicRpcAPI) GetFeeList(blockRef *rpcjson.BlockRef) (interface{}, error) {
 
-	block, stateDB := createTempBlockState(s.cfg)
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
 
 	fees, err := s.cfg.ContractMgr.GetFees(block,
 		stateDB, chaincfg.ActiveNetParams.FvmParam)
@@ -2337,9 +2548,13 @@ func (s *PublicRpcAPI) GetFeeList() (interface{}, error) {
 }
 
 // Get template information by category and template name
-func (s *PublicRpcAPI) GetContractTemplateInfoByName(category uint16, templateName string) (interface{}, error) {
+func (s *PublicRpcAPI) GetContractTemplateInfoByName(category uint16, templateName string,
+	blockRef *rpcjson.BlockRef) (interface{}, error) {
 
-	block, stateDB := createTempBlockState(s.cfg)
+	block, stateDB, err := createBlockState(s.cfg, blockRef)
+	if err != nil {
+		return nil, err
+	}
 
 	templateContent, ok, _ := s.cfg.ContractMgr.GetTemplate(block,
 		common.SystemContractReadOnlyGas,
@@ -2414,6 +2629,12 @@ func (s *AsimovRpcService) APIs() []rpc.API {
 			Service:   NewPublicRpcAPI(s.stack, s.config),
 			Public:    true,
 		},
+		{
+			Namespace: "debug",
+			Version:   "1.0",
+			Service:   NewPublicDebugAPI(s.config),
+			Public:    true,
+		},
 	}
 }
 
@@ -2648,8 +2869,8 @@ func (s *PublicRpcAPI) GetBlockTemplate(privkey string, round uint32, slotIndex
 
 	// 5 seconds
 	blockInteval := 5.0 * 100000
-	template, err := s.cfg.BlockTemplateGenerator.ProduceNewBlock(acc,
-		common.GasFloor, common.GasCeil,
+	template, err := s.cfg.BlockTemplateGenerator.ProduceNewBlock(acc.Address,
+		signer.NewLocalSigner(&acc.PrivateKey, nil), common.GasFloor, common.GasCeil,
 		time.Now().Unix(), round, slotIndex, blockInteval)
 	if err != nil {
 		return nil, internalRPCError(err.Error(), "failed to get block template")
@@ -0,0 +1,96 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+)
+
+// TestCreateBlockState ensures the block argument of the read-only RPCs is
+// resolved to the right main chain block, and that unknown blocks are
+// reported with the matching RPC error.
+func TestCreateBlockState(t *testing.T) {
+	if testing.Short() {
+		t.Skip("devnet scenarios are skipped in short mode")
+	}
+	d := newTestDevnet(t, 1)
+	d.AdvanceSlots(4)
+
+	server := d.Node(0).server
+	chain := server.chain
+	cfg := &rpcserverConfig{Chain: chain, ChainParams: server.chainParams}
+	best := chain.BestSnapshot()
+	if best.Height < 2 {
+		t.Fatalf("AdvanceSlots: got height %d, want at least 2", best.Height)
+	}
+	middle, err := chain.GetNodeByHeight(1)
+	if err != nil {
+		t.Fatalf("GetNodeByHeight error %v", err)
+	}
+	finalizedHash, finalizedHeight := chain.GetFinalizedBlock()
+
+	tests := []struct {
+		name   string
+		arg    string
+		height int32
+		code   rpcjson.RPCErrorCode
+	}{
+		{"latest", `"latest"`, best.Height, 0},
+		{"finalized", `"finalized"`, finalizedHeight, 0},
+		{"number", `1`, 1, 0},
+		{"decimal string", `"1"`, 1, 0},
+		{"hash", fmt.Sprintf("%q", middle.Hash()), 1, 0},
+		{"finalized hash", fmt.Sprintf("%q", finalizedHash), finalizedHeight, 0},
+		{"height out of range", fmt.Sprintf("%d", best.Height+10), 0,
+			rpcjson.ErrRPCBlockHeightNotFound},
+		{"unknown hash", fmt.Sprintf("%q", fmt.Sprintf("%064x", 1)), 0,
+			rpcjson.ErrRPCBlockNotFound},
+	}
+	for _, test := range tests {
+		var ref rpcjson.BlockRef
+		if err := json.Unmarshal([]byte(test.arg), &ref); err != nil {
+			t.Errorf("%s: unmarshal error %v", test.name, err)
+			continue
+		}
+		block, stateDB, err := createBlockState(cfg, &ref)
+		if test.code != 0 {
+			rpcErr, ok := err.(*rpcjson.RPCError)
+			if !ok || rpcErr.Code != test.code {
+				t.Errorf("%s: got error %v, want code %d", test.name,
+					err, test.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: createBlockState error %v", test.name, err)
+			continue
+		}
+		node, _ := chain.GetNodeByHeight(test.height)
+		if block.Height() != test.height+1 ||
+			block.MsgBlock().Header.PrevBlock != node.Hash() {
+			t.Errorf("%s: got block %d on %v, want a block on %d",
+				test.name, block.Height(), block.MsgBlock().Header.PrevBlock,
+				test.height)
+		}
+		if stateDB == nil {
+			t.Errorf("%s: no state", test.name)
+		}
+	}
+
+	// No block argument is the best block.
+	block, _, err := createBlockState(cfg, nil)
+	if err != nil || block.Height() != best.Height+1 {
+		t.Errorf("no block argument: got block %v, error %v", block, err)
+	}
+
+	var ref rpcjson.BlockRef
+	if err := json.Unmarshal([]byte(`"tip"`), &ref); err == nil {
+		t.Errorf("unmarshal accepted an invalid block height")
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"encoding/hex"
+	"strconv"
+
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+)
+
+// GetTxOutSetInfo returns the number of unspent outputs and the total amount
+// of each asset in the utxo set at the best block, along with the rolling
+// hash of the set and the hash of a snapshot of the block.  Two nodes at the
+// same block report the same hashes when their utxo sets match.  The whole
+// utxo set is scanned.
+func (s *PublicRpcAPI) GetTxOutSetInfo() (interface{}, error) {
+	info, err := s.cfg.Chain.FetchUtxoSetInfo()
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch utxo set info")
+	}
+
+	assets := make([]rpcjson.TxOutSetAssetResult, 0, len(info.Assets))
+	for _, asset := range info.Assets {
+		assets = append(assets, rpcjson.TxOutSetAssetResult{
+			Asset:  hex.EncodeToString(asset.Asset.Bytes()),
+			TxOuts: asset.Outputs,
+			Amount: strconv.FormatInt(asset.Amount, 10),
+		})
+	}
+	return &rpcjson.GetTxOutSetInfoResult{
+		Height:       info.Height,
+		BestBlock:    info.Hash.String(),
+		TxOuts:       info.Outputs,
+		UtxoSetHash:  info.SetHash.String(),
+		SnapshotHash: info.SnapshotHash.String(),
+		Assets:       assets,
+	}, nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"fmt"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+)
+
+// maxValidatorStatsRounds is the maximum number of rounds the statistics of
+// a validator are returned for at once.
+const maxValidatorStatsRounds = 10000
+
+// GetValidatorStats returns the blocks the validator produced, the slots it
+// missed, the blocks it signed and the average latency of its blocks in the
+// rounds from fromRound to toRound included, in total and per round.
+func (s *PublicRpcAPI) GetValidatorStats(address string, fromRound, toRound uint32) (interface{}, error) {
+	addrBytes, err := hexutil.Decode(address)
+	if err != nil || len(addrBytes) != common.AddressLength {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid address: " + address,
+		}
+	}
+	if fromRound > toRound || toRound-fromRound >= maxValidatorStatsRounds {
+		return nil, &rpcjson.RPCError{
+			Code: rpcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid round range [%d, %d], at most %d rounds",
+				fromRound, toRound, maxValidatorStatsRounds),
+		}
+	}
+	validator := common.BytesToAddress(addrBytes)
+
+	rounds, err := s.cfg.Chain.GetValidatorStats(validator, fromRound, toRound)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch validator stats")
+	}
+	result := &rpcjson.GetValidatorStatsResult{
+		Address:   validator.String(),
+		FromRound: fromRound,
+		ToRound:   toRound,
+		Rounds:    make([]rpcjson.ValidatorRoundStatsResult, 0, len(rounds)),
+	}
+	var latency int64
+	for _, stats := range rounds {
+		result.Rounds = append(result.Rounds, rpcjson.ValidatorRoundStatsResult{
+			Round:          stats.Round,
+			Produced:       stats.Produced,
+			Missed:         stats.Missed,
+			Signed:         stats.Signed,
+			AverageLatency: stats.AverageLatency(),
+		})
+		latency += stats.Latency
+		result.Produced += stats.Produced
+		result.Missed += stats.Missed
+		result.Signed += stats.Signed
+	}
+	if result.Produced > 0 {
+		result.AverageLatency = float64(latency) / float64(result.Produced)
+	}
+	return result, nil
+}
+
+// GetRoundSchedule returns the validators of the slots of the round, with
+// the blocks of the main chain produced in them and the slots missed.
+func (s *PublicRpcAPI) GetRoundSchedule(round uint32) (interface{}, error) {
+	slots, err := s.cfg.Chain.GetRoundSchedule(round)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to get the round schedule")
+	}
+	results := make([]rpcjson.RoundSlotResult, 0, len(slots))
+	for _, slot := range slots {
+		result := rpcjson.RoundSlotResult{
+			Slot:      slot.Slot,
+			Validator: slot.Validator.String(),
+			Missed:    slot.Missed,
+		}
+		if slot.Block != nil {
+			result.Block = slot.Block.String()
+			result.Height = slot.Height
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -22,6 +22,7 @@ import (
 	"math"
 	"net"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -36,10 +37,12 @@ import (
 	"github.com/AsimovNetwork/asimov/blockchain/indexers"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
 	fnet "github.com/AsimovNetwork/asimov/common/net"
 	"github.com/AsimovNetwork/asimov/connmgr"
 	"github.com/AsimovNetwork/asimov/consensus"
 	"github.com/AsimovNetwork/asimov/consensus/params"
+	"github.com/AsimovNetwork/asimov/consensus/signer"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/mempool"
 	"github.com/AsimovNetwork/asimov/mining"
@@ -51,7 +54,8 @@ import (
 const (
 	// defaultServices describes the default services that are supported by
 	// the NodeServer.
-	defaultServices = common.SFNodeNetwork | common.SFNodeBloom | common.SFNodeCF
+	defaultServices = common.SFNodeNetwork | common.SFNodeBloom | common.SFNodeCF |
+		common.SFNodeEvidence
 
 	// defaultRequiredServices describes the default services that are
 	// required to be supported by outbound peers.
@@ -64,6 +68,10 @@ const (
 	// retries when connecting to persistent peers.  It is adjusted by the
 	// number of retries such that there is a retry backoff.
 	connectionRetryInterval = time.Second * 5
+
+	// mempoolDumpFilename is the name of the file in the data directory the
+	// transactions of the memory pool are saved to on shutdown.
+	mempoolDumpFilename = "mempool.dat"
 )
 
 var (
@@ -173,6 +181,7 @@ type NodeServer struct {
 	chain                *blockchain.BlockChain
 	txMemPool            *mempool.TxPool
 	sigMemPool           *mempool.SigPool
+	feeEstimator         *mempool.FeeEstimator
 	consensus            ainterface.Consensus
 	cfg                  *params.Config
 	modifyRebroadcastInv chan interface{}
@@ -197,8 +206,15 @@ type NodeServer struct {
 	txIndex       *indexers.TxIndex
 	addrIndex     *indexers.AddrIndex
 	cfIndex       *indexers.CfIndex
+	logIndex      *indexers.LogIndex
+	balanceIndex  *indexers.BalanceIndex
+	assetIndex    *indexers.AssetIndex
 	templateIndex blockchain.Indexer
 
+	// rpcNotifier delivers chain and mempool events to the RPC
+	// subscriptions.  It is nil when the RPC server is disabled.
+	rpcNotifier *rpcNotificationManager
+
 	// cfCheckptCaches stores a cached slice of filter headers for cfcheckpt
 	// messages for each filter type.
 	cfCheckptCaches    map[protos.FilterType][]cfHeaderKV
@@ -212,6 +228,10 @@ type NodeServer struct {
 	// agentWhitelist is a list of whitelisted user agent substrings, no
 	// whitelisting will be applied if the list is empty or nil.
 	agentWhitelist []string
+
+	// allowSelfConns disables the detection of self connections of the
+	// peers, for nodes sharing the process.
+	allowSelfConns bool
 }
 
 // serverPeer extends the peer to maintain state shared by the NodeServer and
@@ -237,6 +257,8 @@ type serverPeer struct {
 	txProcessed    chan struct{}
 	sigProcessed   chan struct{}
 	blockProcessed chan struct{}
+
+	evidenceProcessed chan struct{}
 }
 
 // newServerPeer returns a new serverPeer instance. The peer needs to be set by
@@ -251,6 +273,8 @@ func newServerPeer(s *NodeServer, isPersistent bool) *serverPeer {
 		txProcessed:    make(chan struct{}, 1),
 		sigProcessed:   make(chan struct{}, 1),
 		blockProcessed: make(chan struct{}, 1),
+
+		evidenceProcessed: make(chan struct{}, 1),
 	}
 }
 
@@ -468,6 +492,13 @@ func (sp *serverPeer) OnSig(_ *peer.Peer, msg *protos.MsgBlockSign) {
 	<-sp.sigProcessed
 }
 
+// OnEvidence is invoked when a peer receives an evidence message.  It blocks
+// until the evidence has been fully processed.
+func (sp *serverPeer) OnEvidence(_ *peer.Peer, msg *protos.MsgEvidence) {
+	sp.server.syncManager.QueueEvidence(msg, sp.Peer, sp.evidenceProcessed)
+	<-sp.evidenceProcessed
+}
+
 // OnBlock is invoked when a peer receives a block bitcoin message.  It
 // blocks until the bitcoin block has been fully processed.
 func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *protos.MsgBlock, buf []byte) {
@@ -1232,11 +1263,22 @@ func (s *NodeServer) AnnounceNewTransactions(txns []*mining.TxDesc) {
 	// Generate and relay inventory vectors for all newly accepted
 	// transactions.
 	s.relayTransactions(txns)
+
+	// Notify the RPC subscriptions of all newly accepted transactions.
+	if s.rpcNotifier != nil {
+		s.rpcNotifier.NotifyNewTransactions(txns)
+	}
 }
 
 // Transaction has one confirmation on the main chain. Now we can mark it as no
 // longer needing rebroadcasting.
 func (s *NodeServer) TransactionConfirmed(tx *asiutil.Tx) {
+	// Rebroadcasting is only necessary when the RPC server is active, the
+	// rebroadcast handler is not running otherwise.
+	if chaincfg.Cfg.DisableRPC {
+		return
+	}
+
 	iv := protos.NewInvVect(protos.InvTypeTx, tx.Hash())
 	s.RemoveRebroadcastInventory(iv)
 }
@@ -1717,6 +1759,13 @@ func (s *NodeServer) handleBroadcastMsg(state *peerState, bmsg *broadcastMsg) {
 			}
 		}
 
+		// Peers which do not know the evidence message disconnect
+		// when they receive it.
+		if _, ok := bmsg.message.(*protos.MsgEvidence); ok &&
+			!hasServices(sp.Services(), common.SFNodeEvidence) {
+			return
+		}
+
 		sp.QueueMessage(bmsg.message, nil)
 	})
 }
@@ -1899,6 +1948,7 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnMemPool:      sp.OnMemPool,
 			OnTx:           sp.OnTx,
 			OnSig:          sp.OnSig,
+			OnEvidence:     sp.OnEvidence,
 			OnBlock:        sp.OnBlock,
 			OnInv:          sp.OnInv,
 			OnHeaders:      sp.OnHeaders,
@@ -1928,6 +1978,7 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 		Services:          sp.server.services,
 		DisableRelayTx:    chaincfg.Cfg.BlocksOnly,
 		ProtocolVersion:   peer.MaxProtocolVersion,
+		AllowSelfConns:    sp.server.allowSelfConns,
 	}
 }
 
@@ -2227,6 +2278,12 @@ func (s *NodeServer) Start() {
 
 	s.txMemPool.Start()
 
+	// Start the RPC notification manager before any block or transaction
+	// is processed so no notification is missed.
+	if s.rpcNotifier != nil {
+		s.rpcNotifier.Start()
+	}
+
 	// Start the peer handler which in turn starts the address and block
 	// managers.
 	s.wg.Add(1)
@@ -2281,6 +2338,18 @@ func (s *NodeServer) Stop() {
 
 	s.txMemPool.Halt()
 
+	// Save fee estimator state in the database.
+	s.db.Update(func(dbTx database.Tx) error {
+		metadata := dbTx.Metadata()
+		metadata.Put([]byte(mempool.EstimateFeeDatabaseKey), s.feeEstimator.Save())
+
+		return nil
+	})
+
+	if s.rpcNotifier != nil {
+		s.rpcNotifier.Stop()
+	}
+
 	// Signal the remaining goroutines to quit.
 	close(s.quit)
 	return
@@ -2391,6 +2460,24 @@ out:
 // connections from peers.
 func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist, agentWhitelist []string,
 	chainParams *chaincfg.Params, interrupt <-chan struct{}, shutdownRequestChannel chan struct{}) (*NodeServer, error) {
+	return newServer(db, stateDB, agentBlacklist, agentWhitelist, chainParams, interrupt,
+		shutdownRequestChannel, nil)
+}
+
+// serverOptions overrides the services a NodeServer otherwise creates from
+// the configuration, for the nodes the devnet harness runs in process.
+type serverOptions struct {
+	// btcClient replaces the bitcoin client of the configuration.
+	btcClient ainterface.IBtcClient
+
+	// allowSelfConns lets the peers connect to the nodes sharing the
+	// process.
+	allowSelfConns bool
+}
+
+func newServer(db database.Transactor, stateDB database.Database, agentBlacklist, agentWhitelist []string,
+	chainParams *chaincfg.Params, interrupt <-chan struct{}, shutdownRequestChannel chan struct{},
+	opts *serverOptions) (*NodeServer, error) {
 	UseLogger()
 	services := defaultServices
 	if chaincfg.Cfg.NoPeerBloomFilters {
@@ -2461,6 +2548,9 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		agentWhitelist:       agentWhitelist,
 		startupTime:          time.Now().Unix(),
 	}
+	if opts != nil {
+		s.allowSelfConns = opts.allowSelfConns
+	}
 
 	// Create the transaction and address indexes.
 	var indexes []blockchain.Indexer
@@ -2475,6 +2565,21 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		s.cfIndex = indexers.NewCfIndex(db)
 		indexes = append(indexes, s.cfIndex)
 	}
+	// Create log index if needed
+	if !chaincfg.Cfg.NoLogIndex {
+		s.logIndex = indexers.NewLogIndex(db, stateDB)
+		indexes = append(indexes, s.logIndex)
+	}
+	// Create balance index if needed
+	if chaincfg.Cfg.BalanceIndex {
+		s.balanceIndex = indexers.NewBalanceIndex(db)
+		indexes = append(indexes, s.balanceIndex)
+	}
+	// Create asset registry index if needed
+	if chaincfg.Cfg.AssetIndex {
+		s.assetIndex = indexers.NewAssetIndex(db, stateDB)
+		indexes = append(indexes, s.assetIndex)
+	}
 
 	// Create an index manager if any of the optional indexes are enabled.
 	var indexManager blockchain.IndexManager
@@ -2485,8 +2590,16 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 	// Create a contract manager.
 	contractManager := syscontract.NewContractManager()
 
-	// Create a btc rpc client
-	btcClient, err := minersync.NewBtcClient()
+	// Create a btc rpc client, or a spv client which verifies what the btc
+	// servers return.
+	var btcClient ainterface.IBtcClient
+	if opts != nil && opts.btcClient != nil {
+		btcClient = opts.btcClient
+	} else if chaincfg.Cfg.BtcSpv {
+		btcClient, err = minersync.NewSpvClientWithParams(db, chainParams)
+	} else {
+		btcClient, err = minersync.NewBtcClient()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -2494,21 +2607,50 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 	// Merge given checkpoints with the default ones unless they are disabled.
 	var checkpoints []chaincfg.Checkpoint
 	if !chaincfg.Cfg.DisableCheckpoints {
-		checkpoints = mergeCheckpoints(s.chainParams.Checkpoints, chaincfg.Cfg.AddCheckpoints)
+		checkpoints = MergeCheckpoints(s.chainParams.Checkpoints, chaincfg.Cfg.AddCheckpoints)
 	}
 
 	// Create a new block chain instance with the appropriate configuration.
 	acc, err := crypto.NewAccount(chaincfg.Cfg.Privatekey)
+	var blockSigner signer.Signer
+	if chaincfg.Cfg.RemoteSigner != "" {
+		if acc != nil {
+			return nil, errors.New("privatekey and remotesigner can not be used together")
+		}
+		// The key signing the blocks is held by the signer daemon, the
+		// node only knows its public key.
+		remoteSigner, err := signer.NewRemoteSigner(chaincfg.Cfg.RemoteSigner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the remote signer: %v", err)
+		}
+		blockSigner = remoteSigner
+		acc = &crypto.Account{PublicKey: *remoteSigner.PublicKey()}
+		if acc.Address, err = acc.KeyAddress(); err != nil {
+			return nil, err
+		}
+	}
 	if acc == nil {
 		srvrLog.Warn("No miner configuration")
 	} else {
+		if chaincfg.Cfg.Validator != "" {
+			// The private key is a signing key the validator delegated
+			// the signing of its blocks to.
+			addrBytes, err := hexutil.Decode(chaincfg.Cfg.Validator)
+			if err != nil {
+				return nil, fmt.Errorf("invalid validator address: %v", err)
+			}
+			acc.Address, err = common.NewAddress(addrBytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid validator address: %v", err)
+			}
+		}
 		srvrLog.Infof("miner address=%v", acc.Address.String())
 	}
 
 	// Create a round manager.
-	roundManger := consensus.NewRoundManager(cfg.Consensustype, acc)
-	if roundManger == nil {
-		return nil, fmt.Errorf("new %v round manager error", cfg.Consensustype)
+	roundManger, err := consensus.NewScheduledRoundManager(cfg.Consensustype, acc, chainParams)
+	if err != nil {
+		return nil, fmt.Errorf("new %v round manager error: %v", cfg.Consensustype, err)
 	}
 
 	// create fees chan
@@ -2527,11 +2669,40 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		RoundManager:    roundManger,
 		ContractManager: contractManager,
 		FeesChan:        feesChan,
+		StateRetain:     chaincfg.Cfg.StateRetain,
 	}, chaincfg.Cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	// Search for a FeeEstimator state in the database. If none can be found
+	// or if it cannot be loaded, create a new one.
+	db.Update(func(dbTx database.Tx) error {
+		metadata := dbTx.Metadata()
+		feeEstimationData := metadata.Get([]byte(mempool.EstimateFeeDatabaseKey))
+		if feeEstimationData != nil {
+			// Delete it from the database so that we don't try to
+			// restore the same thing again somehow.
+			metadata.Delete([]byte(mempool.EstimateFeeDatabaseKey))
+
+			// If there is an error, log it and make a new fee estimator.
+			var err error
+			s.feeEstimator, err = mempool.RestoreFeeEstimator(feeEstimationData)
+			if err != nil {
+				srvrLog.Errorf("Failed to restore fee estimator %v", err)
+			}
+		}
+
+		return nil
+	})
+
+	// If no feeEstimator has been found, or if the one that has been found
+	// is behind somehow, create a new one and start over.
+	if s.feeEstimator == nil || s.feeEstimator.LastKnownHeight() != s.chain.BestSnapshot().Height {
+		s.feeEstimator = mempool.NewFeeEstimator(
+			mempool.DefaultEstimateFeeMinRegisteredBlocks)
+	}
+
 	txC := mempool.Config{
 		Policy: mempool.Policy{
 			MaxOrphanTxs:      chaincfg.Cfg.MaxOrphanTxs,
@@ -2539,6 +2710,8 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 			MinRelayTxPrice:   chaincfg.Cfg.MinTxPrice,
 			MaxTxVersion:      2,
 			RejectReplacement: cfg.RejectReplacement,
+			MaxPoolSize:       chaincfg.Cfg.MaxMempool * 1000000,
+			MaxTxAge:          chaincfg.Cfg.MempoolExpiry,
 		},
 		FetchUtxoView:  s.chain.FetchUtxoView,
 		Chain:          s.chain,
@@ -2550,6 +2723,21 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		AddrIndex:              s.addrIndex,
 		FeesChan:               feesChan,
 		CheckTransactionInputs: blockchain.CheckTransactionInputs,
+		FeeEstimator:           s.feeEstimator,
+		DumpFile:               filepath.Join(chaincfg.Cfg.DataDir, mempoolDumpFilename),
+	}
+	if chaincfg.Cfg.MempoolRules != "" {
+		rules, err := mempool.LoadRules(chaincfg.Cfg.MempoolRules)
+		if err != nil {
+			return nil, err
+		}
+		rulesPolicy, err := mempool.NewRulesPolicy(rules, s.chain.IsLimitedAsset)
+		if err != nil {
+			return nil, fmt.Errorf("mempool rules %s: %v",
+				chaincfg.Cfg.MempoolRules, err)
+		}
+		txC.PolicyHooks = append(txC.PolicyHooks, rulesPolicy)
+		srvrLog.Infof("Loaded mempool rules from %s", chaincfg.Cfg.MempoolRules)
 	}
 	s.txMemPool = mempool.New(&txC)
 	s.sigMemPool = mempool.NewSigPool()
@@ -2560,9 +2748,11 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		TxMemPool:          s.txMemPool,
 		SigMemPool:         s.sigMemPool,
 		ChainParams:        s.chainParams,
+		FeeEstimator:       s.feeEstimator,
 		DisableCheckpoints: chaincfg.Cfg.DisableCheckpoints,
 		MaxPeers:           chaincfg.Cfg.MaxPeers,
 		Account:            acc,
+		Signer:             blockSigner,
 		BroadcastMessage: func(msg protos.Message, exclPeers ...interface{}) {
 			s.BroadcastMessage(msg)
 		},
@@ -2593,9 +2783,11 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		GasCeil:      common.GasCeil,
 		RoundManager: roundManger,
 		Account:      acc,
+		Signer:       blockSigner,
+		EngineParams: chainParams.EngineParams(cfg.Consensustype),
 	}
 
-	s.consensus, err = consensus.NewConsensusService(chaincfg.Cfg.Consensustype, &consensusConfig)
+	s.consensus, err = consensus.NewScheduledService(chaincfg.Cfg.Consensustype, &consensusConfig, chainParams)
 	if err != nil {
 		return nil, err
 	}
@@ -2688,6 +2880,9 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 	}
 
 	if !chaincfg.Cfg.DisableRPC {
+		s.rpcNotifier = newRPCNotificationManager()
+		s.chain.Subscribe(s.rpcNotifier.handleBlockchainNotification)
+
 		// Setup listeners for the configured RPC listen addresses and
 		// TLS settings.
 		serverConfig := &rpcserverConfig{
@@ -2701,12 +2896,17 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 			ChainParams:     chainParams,
 			DB:              db,
 			TxMemPool:       s.txMemPool,
+			FeeEstimator:    s.feeEstimator,
 			TxIndex:         s.txIndex,
 			AddrIndex:       s.addrIndex,
 			CfIndex:         s.cfIndex,
+			LogIndex:        s.logIndex,
+			BalanceIndex:    s.balanceIndex,
+			AssetIndex:      s.assetIndex,
 			Nap:             nap,
 			ConsensusServer: s.consensus,
 			ContractMgr:     contractManager,
+			Notifier:        s.rpcNotifier,
 
 			BlockTemplateGenerator: blockTemplateGenerator,
 		}
@@ -2917,12 +3117,12 @@ func (s checkpointSorter) Less(i, j int) bool {
 	return s[i].Height < s[j].Height
 }
 
-// mergeCheckpoints returns two slices of checkpoints merged into one slice
+// MergeCheckpoints returns two slices of checkpoints merged into one slice
 // such that the checkpoints are sorted by height.  In the case the additional
 // checkpoints contain a checkpoint with the same height as a checkpoint in the
 // default checkpoints, the additional checkpoint will take precedence and
 // overwrite the default one.
-func mergeCheckpoints(defaultCheckpoints, additional []chaincfg.Checkpoint) []chaincfg.Checkpoint {
+func MergeCheckpoints(defaultCheckpoints, additional []chaincfg.Checkpoint) []chaincfg.Checkpoint {
 	// Create a map of the additional checkpoints to remove duplicates while
 	// leaving the most recently-specified checkpoint.
 	extra := make(map[int32]chaincfg.Checkpoint)
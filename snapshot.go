@@ -0,0 +1,66 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/vm/fvm/trie"
+)
+
+// exportSnapshot writes a snapshot of the best block of the data directory to
+// the passed file.
+func exportSnapshot(db database.Transactor, stateDB database.Database, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	mainLog.Infof("Exporting snapshot to '%s'...", path)
+	info, err := blockchain.ExportSnapshot(db, stateDB, f, chaincfg.ActiveNetParams.Params)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	mainLog.Infof("Exported snapshot of block %v (height %d), %d states",
+		info.Hash, info.Height, len(info.States))
+	mainLog.Infof("Import it with --addcheckpoint=%d:%v:%v:%v once the "+
+		"hashes are confirmed by trusted nodes", info.Height, info.Hash,
+		info.UtxoSetHash, info.SnapshotHash)
+	return nil
+}
+
+// importSnapshot bootstraps the empty data directory from the snapshot in the
+// passed file.
+func importSnapshot(db database.Transactor, stateDB trie.IterableDatabase, path string,
+	checkpoints []chaincfg.Checkpoint, retain int32, interrupt <-chan struct{}) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mainLog.Infof("Importing snapshot from '%s'...", path)
+	info, err := blockchain.ImportSnapshot(db, stateDB, f,
+		chaincfg.ActiveNetParams.Params, checkpoints, retain, interrupt)
+	if err != nil {
+		mainLog.Errorf("Snapshot import failed, the data directory must be " +
+			"removed before trying again")
+		return err
+	}
+
+	mainLog.Infof("Imported snapshot of block %v (height %d), %d states, "+
+		"utxo set hash %v", info.Hash, info.Height, len(info.States),
+		info.UtxoSetHash)
+	return nil
+}
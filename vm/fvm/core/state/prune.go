@@ -0,0 +1,56 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package state
+
+import (
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/vm/fvm/rlp"
+	"github.com/AsimovNetwork/asimov/vm/fvm/trie"
+)
+
+// accountRefs is the trie.LeafResolver of the state tries.  The leaves of
+// the account trie reference the storage trie and the code of the account,
+// the leaves of the storage tries do not decode as accounts.
+func accountRefs(leaf []byte) (tries []common.Hash, blobs []common.Hash) {
+	var account Account
+	if err := rlp.DecodeBytes(leaf, &account); err != nil {
+		return nil, nil
+	}
+	return []common.Hash{account.Root}, []common.Hash{common.BytesToHash(account.CodeHash)}
+}
+
+// EnableRefcount makes the trie database of db keep persistent reference
+// counts of the state it writes, so the states which are no longer needed
+// can be removed with DereferenceState.
+func EnableRefcount(db Database) {
+	db.TrieDB().EnableRefcount(accountRefs)
+}
+
+// ReferenceState adds a persistent reference to the committed state with the
+// passed root.
+func ReferenceState(db Database, root common.Hash) error {
+	return db.TrieDB().ReferenceRoot(root)
+}
+
+// DereferenceState removes a persistent reference from the state with the
+// passed root, deleting the tries and the code no other state references.
+func DereferenceState(db Database, root common.Hash) (int, error) {
+	return db.TrieDB().DereferenceRoot(root)
+}
+
+// Prune deletes from diskdb the tries and the code which are not part of the
+// states with the passed roots, and rebuilds the reference counts of the
+// kept ones so the database can be used with reference counting enabled.
+// It returns the number of entries kept and deleted.
+func Prune(diskdb trie.IterableDatabase, roots []common.Hash, interrupt <-chan struct{}) (int, int, error) {
+	return trie.Prune(diskdb, roots, accountRefs, interrupt)
+}
+
+// Walk invokes fn once with the hash and the encoding of every trie node and
+// contract code of the states with the passed roots.
+func Walk(diskdb database.Database, roots []common.Hash, fn func(hash common.Hash, enc []byte) error) error {
+	return trie.Walk(diskdb, roots, accountRefs, fn)
+}
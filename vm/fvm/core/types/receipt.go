@@ -55,6 +55,10 @@ type Receipt struct {
 	TxHash          common.Hash    `json:"transactionHash" gencodec:"required"`
 	ContractAddress common.Address `json:"contractAddress"`
 	GasUsed         uint64         `json:"gasUsed" gencodec:"required"`
+
+	// CreatedAssets holds the ids of the assets created by the transaction,
+	// including the ones created without any issuance.
+	CreatedAssets [][]byte `json:"-"`
 }
 
 type receiptMarshaling struct {
@@ -81,6 +85,7 @@ type receiptStorageRLP struct {
 	Logs              []*LogForStorage
 	GasUsed           uint64
 	Status            uint64
+	CreatedAssets     [][]byte `rlp:"tail"`
 }
 
 // NewReceipt creates a barebone transaction receipt, copying the init fields.
@@ -166,6 +171,7 @@ func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
 		Logs:              make([]*LogForStorage, len(r.Logs)),
 		GasUsed:           r.GasUsed,
 		Status:            r.Status,
+		CreatedAssets:     r.CreatedAssets,
 	}
 	for i, log := range r.Logs {
 		enc.Logs[i] = (*LogForStorage)(log)
@@ -192,6 +198,7 @@ func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
 	}
 	// Assign the implementation fields
 	r.TxHash, r.ContractAddress, r.GasUsed = dec.TxHash, dec.ContractAddress, dec.GasUsed
+	r.CreatedAssets = dec.CreatedAssets
 	return nil
 }
 
@@ -0,0 +1,285 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/virtualtx"
+)
+
+// CallFrame describes a single call, contract creation or flow operation
+// observed by the CallTracer along with the calls it made itself.
+type CallFrame struct {
+	Type      string
+	From      common.Address
+	To        common.Address
+	Value     *big.Int
+	Asset     *protos.Asset
+	Gas       uint64
+	GasUsed   uint64
+	Input     []byte
+	Output    []byte
+	Error     string
+	Calls     []*CallFrame
+	Transfers []*virtualtx.VTransfer
+
+	// lastGas is the gas left before the last step executed in the frame.
+	lastGas uint64
+	lastOp  OpCode
+}
+
+// pendingCall records the arguments of a call opcode until the tracer learns
+// whether the callee ran any code.
+type pendingCall struct {
+	op    OpCode
+	depth int
+	to    common.Address
+	value *big.Int
+	asset *protos.Asset
+	gas   uint64
+}
+
+// CallTracer is an FVM tracer which rebuilds the tree of calls made during
+// an execution from the opcode stream.  Besides the regular call and create
+// opcodes, the calls made by the flow opcodes to the system contracts are
+// reported with the name of the flow opcode, and every virtual transfer
+// appended to the vtx is attached to the frame which caused it.
+//
+// A transaction may start several top level executions, e.g. the coinbase
+// calls the consensus contract once per output, so CallTracer collects a
+// list of root frames.
+type CallTracer struct {
+	roots   []*CallFrame
+	stack   []*CallFrame
+	pending *pendingCall
+
+	vtx       *virtualtx.VirtualTransaction
+	transfers int
+}
+
+// NewCallTracer returns a new call tracer.
+func NewCallTracer() *CallTracer {
+	return &CallTracer{}
+}
+
+// CaptureStart implements the Tracer interface to open a top level frame.
+func (t *CallTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	frame := &CallFrame{
+		Type:    CALL.String(),
+		From:    from,
+		To:      to,
+		Value:   copyBig(value),
+		Gas:     gas,
+		Input:   common.CopyBytes(input),
+		lastGas: gas,
+	}
+	if create {
+		frame.Type = CREATE.String()
+	}
+	t.roots = append(t.roots, frame)
+	t.stack = append(t.stack[:0], frame)
+	t.pending = nil
+	return nil
+}
+
+// CaptureState implements the Tracer interface.  Calls are entered and left
+// according to the depth of the steps.
+func (t *CallTracer) CaptureState(env *FVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	if len(t.stack) == 0 {
+		return nil
+	}
+	root := t.stack[0]
+	if depth == 1 && root.Asset == nil {
+		root.Asset = contract.Asset()
+	}
+
+	// Leave the frames which returned, the result of the call opcode is
+	// now on the top of the stack of the caller.
+	for len(t.stack) > depth {
+		t.exit(stack)
+	}
+
+	target := t.stack[len(t.stack)-1]
+	if depth > len(t.stack) {
+		// Enter a call which runs code.
+		frame := &CallFrame{
+			Type:  target.lastOp.String(),
+			From:  contract.Caller(),
+			To:    contract.Address(),
+			Value: copyBig(contract.Value()),
+			Asset: contract.Asset(),
+			Gas:   contract.Gas,
+			Input: common.CopyBytes(contract.Input),
+		}
+		if t.pending != nil && t.pending.asset != nil {
+			frame.Asset = t.pending.asset
+		}
+		t.pending = nil
+		target.Calls = append(target.Calls, frame)
+		t.stack = append(t.stack, frame)
+		target = frame
+	} else if t.pending != nil && t.pending.depth == depth {
+		// The callee did not run any code, e.g. a plain transfer or a
+		// precompiled contract.
+		frame := &CallFrame{
+			Type:  t.pending.op.String(),
+			From:  contract.Address(),
+			To:    t.pending.to,
+			Value: t.pending.value,
+			Asset: t.pending.asset,
+			Gas:   t.pending.gas,
+		}
+		if stack.len() > 0 && stack.Back(0).Sign() == 0 {
+			frame.Error = "call failed"
+		}
+		t.pending = nil
+		target.Calls = append(target.Calls, frame)
+		t.collectTransfers(env, frame)
+		target.lastGas, target.lastOp = gas, op
+		t.capturePending(op, depth, stack)
+		return nil
+	}
+
+	t.collectTransfers(env, target)
+	target.lastGas, target.lastOp = gas, op
+	t.capturePending(op, depth, stack)
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to record the error of the
+// current frame.
+func (t *CallTracer) CaptureFault(env *FVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	if len(t.stack) == 0 || err == nil {
+		return nil
+	}
+	for len(t.stack) > depth && len(t.stack) > 1 {
+		t.exit(stack)
+	}
+	t.stack[len(t.stack)-1].Error = err.Error()
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface to close the top level frame.
+func (t *CallTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) error {
+	if len(t.stack) == 0 {
+		return nil
+	}
+	for len(t.stack) > 1 {
+		t.exit(nil)
+	}
+	root := t.stack[0]
+	root.Output = common.CopyBytes(output)
+	root.GasUsed = gasUsed
+	if err != nil {
+		root.Error = err.Error()
+	}
+	if t.vtx != nil {
+		t.collectTransfers(nil, root)
+	}
+	t.stack = t.stack[:0]
+	t.pending = nil
+	return nil
+}
+
+// Calls returns the top level frames captured by the tracer.
+func (t *CallTracer) Calls() []*CallFrame { return t.roots }
+
+// exit leaves the innermost frame.  The passed stack is the one of the
+// caller after the call opcode returned, it may be nil when unknown.
+func (t *CallTracer) exit(stack *Stack) {
+	frame := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	parent := t.stack[len(t.stack)-1]
+
+	if frame.Gas > frame.lastGas {
+		frame.GasUsed = frame.Gas - frame.lastGas
+	}
+	if frame.lastOp == REVERT && frame.Error == "" {
+		frame.Error = errExecutionReverted.Error()
+	}
+	if stack == nil || stack.len() == 0 {
+		return
+	}
+	switch parent.lastOp {
+	case CREATE, CREATE2:
+		if stack.Back(0).Sign() != 0 {
+			frame.To = common.BigToAddress(stack.Back(0))
+		} else if frame.Error == "" {
+			frame.Error = "creation failed"
+		}
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		if stack.Back(0).Sign() == 0 && frame.Error == "" {
+			frame.Error = "call failed"
+		}
+	}
+	t.pending = nil
+}
+
+// capturePending remembers the arguments of a call opcode.
+func (t *CallTracer) capturePending(op OpCode, depth int, stack *Stack) {
+	switch op {
+	case CALL:
+		if stack.len() < 4 {
+			return
+		}
+		t.pending = &pendingCall{
+			op:    op,
+			depth: depth,
+			gas:   stack.Back(0).Uint64(),
+			to:    common.BigToAddress(stack.Back(1)),
+			value: copyBig(stack.Back(2)),
+			asset: protos.AssetFromInt(stack.Back(3)),
+		}
+	case CALLCODE:
+		if stack.len() < 3 {
+			return
+		}
+		t.pending = &pendingCall{
+			op:    op,
+			depth: depth,
+			gas:   stack.Back(0).Uint64(),
+			to:    common.BigToAddress(stack.Back(1)),
+			value: copyBig(stack.Back(2)),
+		}
+	case DELEGATECALL, STATICCALL:
+		if stack.len() < 2 {
+			return
+		}
+		t.pending = &pendingCall{
+			op:    op,
+			depth: depth,
+			gas:   stack.Back(0).Uint64(),
+			to:    common.BigToAddress(stack.Back(1)),
+			value: new(big.Int),
+		}
+	}
+}
+
+// collectTransfers attaches the virtual transfers appended since the last
+// step to the passed frame.
+func (t *CallTracer) collectTransfers(env *FVM, frame *CallFrame) {
+	if env != nil && env.Vtx != t.vtx {
+		t.vtx = env.Vtx
+		t.transfers = 0
+	}
+	if t.vtx == nil {
+		return
+	}
+	for ; t.transfers < len(t.vtx.VTransfer); t.transfers++ {
+		frame.Transfers = append(frame.Transfers, t.vtx.VTransfer[t.transfers])
+	}
+}
+
+// copyBig returns a copy of the passed integer, nil is returned as zero.
+func copyBig(v *big.Int) *big.Int {
+	if v == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(v)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// callStack returns a stack holding the passed values, the last one on top.
+func callStack(values ...*big.Int) *Stack {
+	stack := newstack()
+	for _, value := range values {
+		stack.push(new(big.Int).Set(value))
+	}
+	return stack
+}
+
+// TestCallTracer ensures the call tracer rebuilds the call tree from the
+// opcode stream, with the errors of the calls and the virtual transfers they
+// caused.
+func TestCallTracer(t *testing.T) {
+	var (
+		env    = NewFVM(Context{}, nil, chaincfg.ActiveNetParams.FvmParam, Config{})
+		tracer = NewCallTracer()
+		mem    = NewMemory()
+		sender = common.BytesToAddress([]byte{1})
+		root   = common.BytesToAddress([]byte{2})
+		callee = common.BytesToAddress([]byte{3})
+		payee  = common.BytesToAddress([]byte{4})
+		asset  = protos.NewAsset(0, 7, 1)
+	)
+	assetInt := new(big.Int).SetBytes(asset.Bytes())
+	rootContract := NewContract(AccountRef(sender), AccountRef(root), new(big.Int), 1000, asset)
+	calleeContract := NewContract(AccountRef(root), AccountRef(callee), big.NewInt(5), 300, asset)
+
+	tracer.CaptureStart(sender, root, false, []byte{0xaa}, 1000, new(big.Int))
+
+	// The root calls the callee which reverts.
+	tracer.CaptureState(env, 0, CALL, 900, 0, mem, callStack(assetInt,
+		big.NewInt(5), callee.Big(), big.NewInt(300)), rootContract, 1, nil)
+	tracer.CaptureState(env, 0, PUSH1, 300, 3, mem, newstack(), calleeContract, 2, nil)
+	tracer.CaptureState(env, 2, REVERT, 280, 0, mem, callStack(new(big.Int),
+		new(big.Int)), calleeContract, 2, nil)
+	tracer.CaptureState(env, 1, POP, 600, 2, mem, callStack(new(big.Int)),
+		rootContract, 1, nil)
+
+	// The root pays the payee which has no code, the transfer is appended
+	// to the virtual transaction.
+	tracer.CaptureState(env, 2, CALL, 590, 0, mem, callStack(assetInt,
+		big.NewInt(8), payee.Big(), big.NewInt(0)), rootContract, 1, nil)
+	env.Vtx.AppendVTransfer(root, payee, big.NewInt(8), asset)
+	tracer.CaptureState(env, 3, STOP, 500, 0, mem, callStack(big.NewInt(1)),
+		rootContract, 1, nil)
+	tracer.CaptureEnd([]byte{0xbb}, 500, 0, nil)
+
+	calls := tracer.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("got %d root frames, want 1", len(calls))
+	}
+	frame := calls[0]
+	if frame.Type != "CALL" || frame.From != sender || frame.To != root ||
+		frame.GasUsed != 500 || frame.Error != "" ||
+		frame.Output[0] != 0xbb || *frame.Asset != *asset {
+		t.Errorf("unexpected root frame %+v", frame)
+	}
+	if len(frame.Calls) != 2 {
+		t.Fatalf("got %d calls of the root, want 2", len(frame.Calls))
+	}
+
+	reverted := frame.Calls[0]
+	if reverted.Type != "CALL" || reverted.From != root || reverted.To != callee ||
+		reverted.Value.Int64() != 5 || reverted.GasUsed != 20 ||
+		reverted.Error != errExecutionReverted.Error() {
+		t.Errorf("unexpected reverted frame %+v", reverted)
+	}
+
+	paid := frame.Calls[1]
+	if paid.Type != "CALL" || paid.From != root || paid.To != payee ||
+		paid.Value.Int64() != 8 || *paid.Asset != *asset || paid.Error != "" {
+		t.Errorf("unexpected payment frame %+v", paid)
+	}
+	if len(paid.Transfers) != 1 || paid.Transfers[0].Amount != 8 {
+		t.Errorf("unexpected transfers of the payment %+v", paid.Transfers)
+	}
+	if len(reverted.Transfers) != 0 || len(frame.Transfers) != 0 {
+		t.Errorf("transfers attached to the wrong frames")
+	}
+}
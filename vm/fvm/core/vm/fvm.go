@@ -63,7 +63,7 @@ type (
 // run runs the given contract and takes care of running precompiles with a fallback to the byte code interpreter.
 func run(fvm *FVM, contract *Contract, input []byte, readOnly bool) ([]byte, error) {
 	if contract.CodeAddr != nil {
-		if p := GetPreCompiledContract(*contract.CodeAddr); p != nil {
+		if p := fvm.precompiledContract(*contract.CodeAddr); p != nil {
 			return RunPrecompiledContract(fvm, p, input, contract)
 		}
 	}
@@ -220,7 +220,7 @@ func (fvm *FVM) Call(caller ContractRef, addr common.Address, input []byte,
 	var to = AccountRef(addr)
 	snapshot = fvm.StateDB.Snapshot()
 	if !fvm.StateDB.Exist(addr) {
-		if GetPreCompiledContract(addr) == nil && value.Sign() == 0 {
+		if fvm.precompiledContract(addr) == nil && value.Sign() == 0 {
 			// Calling a non existing account, don't do anything, but ping the tracer
 			if fvm.vmConfig.Debug && fvm.depth == 0 {
 				fvm.vmConfig.Tracer.CaptureStart(caller.Address(), addr, false, input, leftOverGas, value)
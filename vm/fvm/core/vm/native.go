@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/vm/fvm/abi"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+)
+
+var (
+	// errNativeValue is returned when a native system contract is sent a
+	// value, they hold no asset.
+	errNativeValue = errors.New("native contract does not accept value")
+
+	// errNativeContext is returned when a native system contract is run in
+	// the context of another contract, by a call code or a delegate call.
+	errNativeContext = errors.New("native contract must be called directly")
+)
+
+// nativeMethod is a method of a native system contract.
+type nativeMethod struct {
+	// gas is the gas the method uses, on top of the gas of the call.
+	gas uint64
+
+	// run executes the method of the native contract c with the unpacked
+	// arguments and returns the values to pack as its outputs.
+	run func(c *nativeContract, fvm *FVM, contract *Contract, args []interface{}) ([]interface{}, error)
+}
+
+// nativeContract is a system contract implemented by the virtual machine
+// rather than by byte code.  It is called with the abi encoding like any
+// other contract, and keeps its data in the storage of its own account, so
+// it is part of the state and follows the reorganizations of the chain.
+//
+// The native contracts do not need to be deployed in the genesis block.  They
+// are available from the NativeContractsBlock of the chain config on.
+type nativeContract struct {
+	addr    common.Address
+	abi     abi.ABI
+	methods map[string]nativeMethod
+}
+
+// newNativeContract returns the native contract at the address, with the abi
+// definition and the implementation of each of its methods.
+func newNativeContract(addr common.Address, definition string,
+	methods map[string]nativeMethod) *nativeContract {
+
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(fmt.Sprintf("invalid abi of native contract %v: %v", addr, err))
+	}
+	for name := range parsed.Methods {
+		if _, ok := methods[name]; !ok {
+			panic(fmt.Sprintf("native contract %v does not implement %s", addr, name))
+		}
+	}
+	return &nativeContract{addr: addr, abi: parsed, methods: methods}
+}
+
+// RequiredGas returns the gas of the called method, nothing when the input
+// does not call any.
+func (c *nativeContract) RequiredGas(input []byte) uint64 {
+	method, err := c.abi.MethodById(input)
+	if err != nil {
+		return 0
+	}
+	return c.methods[method.Name].gas
+}
+
+// Run unpacks the arguments of the called method, runs it and packs its
+// outputs.
+func (c *nativeContract) Run(fvm *FVM, input []byte, contract *Contract) ([]byte, error) {
+	if contract.Address() != c.addr {
+		return nil, errNativeContext
+	}
+	if contract.value != nil && contract.value.Sign() != 0 {
+		return nil, errNativeValue
+	}
+	method, err := c.abi.MethodById(input)
+	if err != nil {
+		return nil, err
+	}
+	args, err := method.Inputs.UnpackValues(input[4:])
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := c.methods[method.Name].run(c, fvm, contract, args)
+	if err != nil {
+		return nil, err
+	}
+	return method.Outputs.PackValues(outputs)
+}
+
+// storageKey returns the storage slot of the key in the mapping at the
+// passed slot, laid out like solidity does.
+func (c *nativeContract) storageKey(slot uint64, key []byte) common.Hash {
+	return crypto.Keccak256Hash(common.LeftPadBytes(key, common.HashLength),
+		common.LeftPadBytes(new(big.Int).SetUint64(slot).Bytes(), common.HashLength))
+}
+
+// getState returns the value of the key in the mapping at the slot.
+func (c *nativeContract) getState(db StateDB, slot uint64, key []byte) common.Hash {
+	return db.GetState(c.addr, c.storageKey(slot, key))
+}
+
+// setState sets the value of the key in the mapping at the slot.
+func (c *nativeContract) setState(db StateDB, slot uint64, key []byte, value common.Hash) {
+	db.SetState(c.addr, c.storageKey(slot, key), value)
+}
+
+// addLog emits the event of the contract with the passed values, none of them
+// indexed.
+func (c *nativeContract) addLog(fvm *FVM, name string, values ...interface{}) error {
+	event := c.abi.Events[name]
+	data, err := event.Inputs.Pack(values...)
+	if err != nil {
+		return err
+	}
+	fvm.StateDB.AddLog(&types.Log{
+		Address:     c.addr,
+		Topics:      []common.Hash{event.ID()},
+		Data:        data,
+		BlockNumber: fvm.BlockNumber.Uint64(),
+	})
+	return nil
+}
+
+// nativeContracts are the native system contracts by address.
+var nativeContracts = map[common.Address]*nativeContract{
+	common.EvidenceRegistry:   evidenceRegistry,
+	common.SigningKeyRegistry: signingKeyRegistry,
+}
+
+// precompiledContract returns the pre-compiled contract at the address, nil
+// if there is none.  The native system contracts are only returned once they
+// are active.
+func (fvm *FVM) precompiledContract(addr common.Address) PrecompiledContract {
+	if c, ok := nativeContracts[addr]; ok {
+		if fvm.chainConfig == nil || !fvm.chainConfig.IsNativeContracts(fvm.BlockNumber) {
+			return nil
+		}
+		return c
+	}
+	return GetPreCompiledContract(addr)
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/params"
+)
+
+// EvidenceRegistryABI is the abi of the evidence registry, the native system
+// contract recording the misbehaviors of the validators.  A misbehavior is
+// reported with the serialized evidence proving it, which the contract
+// verifies itself.  The chain penalizes the validators whose misbehavior was
+// reported during a round when it reports the blocks of the round to the
+// validator committee.
+const EvidenceRegistryABI = `[
+	{"constant":false,"inputs":[{"name":"evidence","type":"bytes"}],"name":"reportMisbehavior","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},
+	{"constant":true,"inputs":[{"name":"validator","type":"address"}],"name":"getMisbehaviors","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},
+	{"constant":true,"inputs":[{"name":"validators","type":"address[]"}],"name":"getMisbehaviorHeights","outputs":[{"name":"","type":"uint256[]"}],"payable":false,"stateMutability":"view","type":"function"},
+	{"anonymous":false,"inputs":[{"indexed":false,"name":"offender","type":"address"},{"indexed":false,"name":"height","type":"uint256"},{"indexed":false,"name":"evidenceHash","type":"bytes32"},{"indexed":false,"name":"reporter","type":"address"}],"name":"MisbehaviorReportEvent","type":"event"}
+]`
+
+// The storage slots of the mappings of the evidence registry.
+const (
+	// reportedEvidencesSlot maps the hashes of the reported evidences to
+	// one.
+	reportedEvidencesSlot = 0
+
+	// misbehaviorsSlot maps the validators to the number of their reported
+	// misbehaviors.
+	misbehaviorsSlot = 1
+
+	// misbehaviorHeightsSlot maps the validators to the height of the block
+	// which recorded their last reported misbehavior.
+	misbehaviorHeightsSlot = 2
+)
+
+// evidenceRegistry is the native contract at common.EvidenceRegistry.
+var evidenceRegistry = newNativeContract(common.EvidenceRegistry, EvidenceRegistryABI,
+	map[string]nativeMethod{
+		"reportMisbehavior":     {gas: params.EvidenceReportGas, run: reportMisbehavior},
+		"getMisbehaviors":       {gas: params.NativeReadGas, run: getMisbehaviors},
+		"getMisbehaviorHeights": {gas: params.NativeReadGas, run: getMisbehaviorHeights},
+	})
+
+// recoverSigner returns the address of the key which made the signature of
+// the hash.
+func recoverSigner(hash, sig []byte) (common.Address, error) {
+	if len(sig) != protos.HashSignLen {
+		return common.Address{}, errors.New("invalid signature length")
+	}
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	addr, err := common.NewAddressWithId(common.PubKeyHashAddrID,
+		common.Hash160(crypto.CompressPubkey(pub)))
+	if err != nil {
+		return common.Address{}, err
+	}
+	return *addr, nil
+}
+
+// verifyEvidence ensures the evidence holds two distinct items which conflict,
+// both signed by the offender or by a signing key it registered.
+func verifyEvidence(db StateDB, evidence *protos.MsgEvidence) error {
+	hashes := evidence.BlockHashes()
+	if bytes.Compare(hashes[0][:], hashes[1][:]) >= 0 {
+		return errors.New("evidence blocks are not distinct and ordered")
+	}
+
+	var sigs [2][]byte
+	switch evidence.Type {
+	case protos.EvidenceDoubleProposal:
+		a, b := &evidence.Headers[0], &evidence.Headers[1]
+		if a.CoinBase != b.CoinBase || a.Round != b.Round || a.SlotIndex != b.SlotIndex {
+			return errors.New("evidence headers do not conflict")
+		}
+		sigs = [2][]byte{a.SigData[:], b.SigData[:]}
+
+	case protos.EvidenceDoubleSign:
+		a, b := &evidence.Signs[0], &evidence.Signs[1]
+		if a.Signer != b.Signer || a.BlockHeight != b.BlockHeight {
+			return errors.New("evidence signatures do not conflict")
+		}
+		sigs = [2][]byte{a.Signature[:], b.Signature[:]}
+
+	default:
+		return fmt.Errorf("unknown evidence type %v", evidence.Type)
+	}
+
+	offender := evidence.Offender()
+	for i := range hashes {
+		signer, err := recoverSigner(hashes[i][:], sigs[i])
+		if err != nil {
+			return err
+		}
+		if signer != offender && signingKeyOwner(signingKeyRegistry, db, signer) != offender {
+			return fmt.Errorf("block %v of the evidence is not signed by %v",
+				hashes[i], offender)
+		}
+	}
+	return nil
+}
+
+// reportMisbehavior verifies the serialized evidence and counts the
+// misbehavior of its offender.  An evidence already reported reverts so the
+// validators reporting it late are not charged all their gas.
+func reportMisbehavior(c *nativeContract, fvm *FVM, contract *Contract,
+	args []interface{}) ([]interface{}, error) {
+
+	r := bytes.NewReader(args[0].([]byte))
+	evidence := &protos.MsgEvidence{}
+	if err := evidence.Deserialize(r); err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing bytes after the evidence")
+	}
+	if err := verifyEvidence(fvm.StateDB, evidence); err != nil {
+		return nil, err
+	}
+
+	hash := evidence.Hash()
+	if c.getState(fvm.StateDB, reportedEvidencesSlot, hash[:]) != (common.Hash{}) {
+		return nil, errExecutionReverted
+	}
+	c.setState(fvm.StateDB, reportedEvidencesSlot, hash[:], common.BigToHash(common.Big1))
+
+	offender := evidence.Offender()
+	count := c.getState(fvm.StateDB, misbehaviorsSlot, offender[:]).Big()
+	count.Add(count, common.Big1)
+	c.setState(fvm.StateDB, misbehaviorsSlot, offender[:], common.BigToHash(count))
+	c.setState(fvm.StateDB, misbehaviorHeightsSlot, offender[:], common.BigToHash(fvm.BlockNumber))
+
+	err := c.addLog(fvm, "MisbehaviorReportEvent", offender,
+		big.NewInt(int64(evidence.Height())), [common.HashLength]byte(hash),
+		contract.Caller())
+	return nil, err
+}
+
+// getMisbehaviors returns the number of reported misbehaviors of the
+// validator.
+func getMisbehaviors(c *nativeContract, fvm *FVM, contract *Contract,
+	args []interface{}) ([]interface{}, error) {
+
+	validator := args[0].(common.Address)
+	count := c.getState(fvm.StateDB, misbehaviorsSlot, validator[:]).Big()
+	return []interface{}{count}, nil
+}
+
+// getMisbehaviorHeights returns the height of the block which recorded the
+// last reported misbehavior of each validator, zero for the validators which
+// never misbehaved.
+func getMisbehaviorHeights(c *nativeContract, fvm *FVM, contract *Contract,
+	args []interface{}) ([]interface{}, error) {
+
+	validators := args[0].([]common.Address)
+	heights := make([]*big.Int, len(validators))
+	for i, validator := range validators {
+		heights[i] = c.getState(fvm.StateDB, misbehaviorHeightsSlot, validator[:]).Big()
+	}
+	return []interface{}{heights}, nil
+}
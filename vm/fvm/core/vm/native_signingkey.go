@@ -0,0 +1,96 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/vm/fvm/params"
+)
+
+// SigningKeyRegistryABI is the abi of the signing key registry, the native
+// system contract where the validators register the key they delegate the
+// signing of their blocks to, so their own key can be kept offline.
+const SigningKeyRegistryABI = `[
+	{"constant":false,"inputs":[{"name":"signingKey","type":"address"}],"name":"setSigningKey","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},
+	{"constant":true,"inputs":[{"name":"validators","type":"address[]"}],"name":"getSigningKeys","outputs":[{"name":"","type":"address[]"}],"payable":false,"stateMutability":"view","type":"function"},
+	{"constant":true,"inputs":[{"name":"signingKey","type":"address"}],"name":"getSigningKeyOwner","outputs":[{"name":"","type":"address"}],"payable":false,"stateMutability":"view","type":"function"},
+	{"anonymous":false,"inputs":[{"indexed":false,"name":"validator","type":"address"},{"indexed":false,"name":"signingKey","type":"address"}],"name":"SigningKeyEvent","type":"event"}
+]`
+
+// The storage slots of the mappings of the signing key registry.
+const (
+	// signingKeysSlot maps the validators to the key signing their blocks.
+	signingKeysSlot = 0
+
+	// signingKeyOwnersSlot maps the keys to the validator which registered
+	// them first.  A key is never given to another validator, so what a key
+	// signed is attributed to its owner even after it is rotated out.
+	signingKeyOwnersSlot = 1
+)
+
+// signingKeyRegistry is the native contract at common.SigningKeyRegistry.
+var signingKeyRegistry = newNativeContract(common.SigningKeyRegistry, SigningKeyRegistryABI,
+	map[string]nativeMethod{
+		"setSigningKey":      {gas: params.SigningKeyRegistrationGas, run: setSigningKey},
+		"getSigningKeys":     {gas: params.NativeReadGas, run: getSigningKeys},
+		"getSigningKeyOwner": {gas: params.NativeReadGas, run: getSigningKeyOwner},
+	})
+
+// addressFromState returns the address stored in the value of a slot.
+func addressFromState(value common.Hash) common.Address {
+	return common.BytesToAddress(value[common.HashLength-common.AddressLength:])
+}
+
+// signingKeyOwner returns the validator owning the signing key in the
+// registry c, the zero address when the key was never registered.
+func signingKeyOwner(c *nativeContract, db StateDB, key common.Address) common.Address {
+	return addressFromState(c.getState(db, signingKeyOwnersSlot, key[:]))
+}
+
+// setSigningKey registers the signing key of the calling validator.  The zero
+// address or the address of the validator itself clear the registration, so
+// the validator signs with its own key again.  A key owned by another
+// validator is refused.
+func setSigningKey(c *nativeContract, fvm *FVM, contract *Contract,
+	args []interface{}) ([]interface{}, error) {
+
+	validator := contract.Caller()
+	key := args[0].(common.Address)
+	if key == validator {
+		key = common.Address{}
+	}
+	if key != (common.Address{}) {
+		owner := signingKeyOwner(c, fvm.StateDB, key)
+		if owner != (common.Address{}) && owner != validator {
+			return nil, errExecutionReverted
+		}
+		c.setState(fvm.StateDB, signingKeyOwnersSlot, key[:], validator.Hash())
+	}
+	c.setState(fvm.StateDB, signingKeysSlot, validator[:], key.Hash())
+
+	err := c.addLog(fvm, "SigningKeyEvent", validator, key)
+	return nil, err
+}
+
+// getSigningKeys returns the signing keys of the validators, the zero address
+// for the validators signing with their own key.
+func getSigningKeys(c *nativeContract, fvm *FVM, contract *Contract,
+	args []interface{}) ([]interface{}, error) {
+
+	validators := args[0].([]common.Address)
+	keys := make([]common.Address, len(validators))
+	for i, validator := range validators {
+		keys[i] = addressFromState(c.getState(fvm.StateDB, signingKeysSlot, validator[:]))
+	}
+	return []interface{}{keys}, nil
+}
+
+// getSigningKeyOwner returns the validator owning the signing key, the zero
+// address when the key was never registered.
+func getSigningKeyOwner(c *nativeContract, fvm *FVM, contract *Contract,
+	args []interface{}) ([]interface{}, error) {
+
+	return []interface{}{signingKeyOwner(c, fvm.StateDB, args[0].(common.Address))}, nil
+}
@@ -24,14 +24,25 @@ import (
 var (
 	// MainnetChainConfig is the chain parameters to run a node on the main network.
 	MainnetChainConfig = &ChainConfig{
-		ChainID:             big.NewInt(1),
-		Ethash:              new(EthashConfig),
+		ChainID:              big.NewInt(1),
+		Ethash:               new(EthashConfig),
+		NativeContractsBlock: big.NewInt(51840000),
 	}
 
 	// TestnetChainConfig contains the chain parameters to run a node on the Ropsten test network.
 	TestnetChainConfig = &ChainConfig{
-		ChainID:             big.NewInt(3),
-		Ethash:              new(EthashConfig),
+		ChainID:              big.NewInt(3),
+		Ethash:               new(EthashConfig),
+		NativeContractsBlock: big.NewInt(51840000),
+	}
+
+	// DevelopnetChainConfig contains the chain parameters to run a node on the
+	// development network, where the native system contracts are active from
+	// the genesis block.
+	DevelopnetChainConfig = &ChainConfig{
+		ChainID:              big.NewInt(3),
+		Ethash:               new(EthashConfig),
+		NativeContractsBlock: big.NewInt(0),
 	}
 
 	// RinkebyChainConfig contains the chain parameters to run a node on the Rinkeby test network.
@@ -48,14 +59,14 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), new(EthashConfig), nil}
+	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), new(EthashConfig), nil, big.NewInt(0)}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), nil, &CliqueConfig{Period: 0, Epoch: 30000}}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), nil, &CliqueConfig{Period: 0, Epoch: 30000}, big.NewInt(0)}
 )
 
 // ChainConfig is the core config which determines the blockchain settings.
@@ -68,6 +79,11 @@ type ChainConfig struct {
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
+
+	// NativeContractsBlock is the block from which the system contracts
+	// implemented natively by the virtual machine can be called, nil if they
+	// are never active.
+	NativeContractsBlock *big.Int `json:"nativeContractsBlock,omitempty"`
 }
 
 // EthashConfig is the consensus engine configs for proof-of-work based sealing.
@@ -106,6 +122,12 @@ func (c *ChainConfig) String() string {
 	)
 }
 
+// IsNativeContracts returns whether num is either equal to the native
+// contracts block or greater.
+func (c *ChainConfig) IsNativeContracts(num *big.Int) bool {
+	return isForked(c.NativeContractsBlock, num)
+}
+
 // GasTable returns the gas table corresponding to the current phase (homestead or homestead reprice).
 //
 // The returned GasTable's fields shouldn't, under any circumstances, be changed.
@@ -76,4 +76,10 @@ const (
 	Bn256PairingBaseGas     uint64 = 100000 // Base price for an elliptic curve pairing check
 	Bn256PairingPerPointGas uint64 = 80000  // Per-point price for an elliptic curve pairing check
 	SystemDelegateCall uint64 = 0
+
+	// Native system contract gas prices
+
+	NativeReadGas             uint64 = 800   // Gas of a method of a native system contract reading its state
+	EvidenceReportGas         uint64 = 50000 // Gas of verifying and recording an evidence of misbehavior
+	SigningKeyRegistrationGas uint64 = 40000 // Gas of registering the block signing key of a validator
 )
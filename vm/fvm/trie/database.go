@@ -82,6 +82,9 @@ type Database struct {
 	nodesSize     common.StorageSize // Storage size of the nodes cache (exc. flushlist)
 	preimagesSize common.StorageSize // Storage size of the preimages cache
 
+	resolver LeafResolver // Resolver of the leaf references, nil unless reference counting
+	reflock  sync.Mutex   // Lock serializing the updates of the reference counts
+
 	lock sync.RWMutex
 }
 
@@ -606,6 +609,15 @@ func (db *Database) Cap(limit common.StorageSize) error {
 //
 // As a side effect, all pre-images accumulated up to this point are also written.
 func (db *Database) Commit(node common.Hash, report bool) error {
+	// When reference counting, the counts of the written nodes are updated in
+	// the same batch, serialized against dereferences.
+	var refs *refcounter
+	if db.Refcounting() {
+		db.reflock.Lock()
+		defer db.reflock.Unlock()
+		refs = newRefcounter(db.diskdb)
+	}
+
 	// Create a database batch to flush persistent data out. It is important that
 	// outside code doesn't see an inconsistent state (referenced data removed from
 	// memory cache during commit but not yet in persistent storage). This is ensured
@@ -631,11 +643,18 @@ func (db *Database) Commit(node common.Hash, report bool) error {
 	}
 	// Move the trie itself into the batch, flushing if enough data is accumulated
 	nodes, storage := len(db.nodes), db.nodesSize
-	if err := db.commit(node, batch); err != nil {
+	if err := db.commit(node, batch, refs); err != nil {
 		log.Error("Failed to commit trie from trie database", "err", err)
 		db.lock.RUnlock()
 		return err
 	}
+	if refs != nil {
+		if err := refs.write(batch); err != nil {
+			log.Error("Failed to commit trie reference counts", "err", err)
+			db.lock.RUnlock()
+			return err
+		}
+	}
 	// Write batch ready, unlock for readers during persistence
 	if err := batch.Write(); err != nil {
 		log.Error("Failed to write trie to disk", "err", err)
@@ -671,15 +690,21 @@ func (db *Database) Commit(node common.Hash, report bool) error {
 	return nil
 }
 
-// commit is the private locked version of Commit.
-func (db *Database) commit(hash common.Hash, batch database.Batch) error {
+// commit is the private locked version of Commit.  The reference counts of the
+// written nodes are tracked in refs unless it is nil.
+func (db *Database) commit(hash common.Hash, batch database.Batch, refs *refcounter) error {
 	// If the node does not exist, it's a previously committed node
 	node, ok := db.nodes[hash]
 	if !ok {
 		return nil
 	}
 	for _, child := range node.childs() {
-		if err := db.commit(child, batch); err != nil {
+		if err := db.commit(child, batch, refs); err != nil {
+			return err
+		}
+	}
+	if refs != nil {
+		if err := db.countNode(hash, node, refs); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,533 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/vm/fvm/log"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// refcountPrefix is the database key prefix of the persistent reference
+// counts of the trie nodes and blobs.
+var refcountPrefix = []byte("trie-refcount-") // refcountPrefix + hash -> count (uint32 big endian)
+
+// ErrPruneInterrupted is returned by Prune when it is interrupted.
+var ErrPruneInterrupted = errors.New("trie prune interrupted")
+
+// LeafResolver returns the roots of the tries and the hashes of the blobs
+// referenced by the value of a trie leaf, e.g. the storage trie and the code
+// of an account.  Values which do not reference anything resolve to nothing.
+type LeafResolver func(leaf []byte) (tries []common.Hash, blobs []common.Hash)
+
+// IterableDatabase is a persistent store whose content can be iterated, it
+// is required to prune the nodes which are not referenced.
+type IterableDatabase interface {
+	database.Database
+
+	NewIterator() iterator.Iterator
+	NewIteratorWithPrefix(prefix []byte) iterator.Iterator
+}
+
+// refcountKey returns the key of the reference count of a node or blob.
+func refcountKey(hash common.Hash) []byte {
+	key := make([]byte, len(refcountPrefix)+common.HashLength)
+	copy(key, refcountPrefix)
+	copy(key[len(refcountPrefix):], hash[:])
+	return key
+}
+
+// refcounter caches the reference counts read and updated from disk until
+// they are written out with a batch.  A negative count means the node or
+// blob is not tracked.
+type refcounter struct {
+	diskdb database.Database
+	counts map[common.Hash]int64
+	dirty  map[common.Hash]struct{}
+}
+
+// newRefcounter returns a reference counter reading the counts from diskdb.
+func newRefcounter(diskdb database.Database) *refcounter {
+	return &refcounter{
+		diskdb: diskdb,
+		counts: make(map[common.Hash]int64),
+		dirty:  make(map[common.Hash]struct{}),
+	}
+}
+
+// load returns the reference count of the passed hash.
+func (r *refcounter) load(hash common.Hash) (int64, error) {
+	if count, ok := r.counts[hash]; ok {
+		return count, nil
+	}
+	key := refcountKey(hash)
+	count := int64(-1)
+	has, err := r.diskdb.Has(key)
+	if err != nil {
+		return 0, err
+	}
+	if has {
+		blob, err := r.diskdb.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		if len(blob) != 4 {
+			return 0, fmt.Errorf("corrupt reference count of %x", hash)
+		}
+		count = int64(binary.BigEndian.Uint32(blob))
+	}
+	r.counts[hash] = count
+	return count, nil
+}
+
+// set updates the reference count of the passed hash, a negative count
+// deletes it.
+func (r *refcounter) set(hash common.Hash, count int64) {
+	r.counts[hash] = count
+	r.dirty[hash] = struct{}{}
+}
+
+// inc adds a reference to the passed hash.
+func (r *refcounter) inc(hash common.Hash) error {
+	count, err := r.load(hash)
+	if err != nil {
+		return err
+	}
+	if count < 0 {
+		count = 0
+	}
+	r.set(hash, count+1)
+	return nil
+}
+
+// write adds the updated reference counts to the batch.
+func (r *refcounter) write(batch database.Batch) error {
+	var buf [4]byte
+	for hash := range r.dirty {
+		count := r.counts[hash]
+		if count < 0 {
+			if err := batch.Delete(refcountKey(hash)); err != nil {
+				return err
+			}
+			continue
+		}
+		binary.BigEndian.PutUint32(buf[:], uint32(count))
+		if err := batch.Put(refcountKey(hash), buf[:]); err != nil {
+			return err
+		}
+	}
+	r.dirty = make(map[common.Hash]struct{})
+	return nil
+}
+
+// nodeRefs collects the children of a trie node, either collapsed or
+// expanded, along with the tries and blobs referenced by its values.
+func nodeRefs(n node, resolve LeafResolver, tries, blobs *[]common.Hash) {
+	switch n := n.(type) {
+	case *rawShortNode:
+		nodeRefs(n.Val, resolve, tries, blobs)
+
+	case rawFullNode:
+		for _, child := range n {
+			nodeRefs(child, resolve, tries, blobs)
+		}
+	case *shortNode:
+		nodeRefs(n.Val, resolve, tries, blobs)
+
+	case *fullNode:
+		for _, child := range n.Children {
+			nodeRefs(child, resolve, tries, blobs)
+		}
+	case hashNode:
+		*tries = append(*tries, common.BytesToHash(n))
+
+	case valueNode:
+		if resolve == nil {
+			return
+		}
+		leafTries, leafBlobs := resolve(n)
+		for _, root := range leafTries {
+			if root != emptyRoot {
+				*tries = append(*tries, root)
+			}
+		}
+		for _, hash := range leafBlobs {
+			if hash != emptyState {
+				*blobs = append(*blobs, hash)
+			}
+		}
+	case nil:
+
+	default:
+		panic(fmt.Sprintf("unknown node type: %T", n))
+	}
+}
+
+// EnableRefcount makes the database keep a persistent reference count for
+// every trie node and blob it writes to disk, so the tries which are no
+// longer needed can be deleted with DereferenceRoot.  The resolver returns
+// the external references of the trie leaves.
+//
+// The counts are only accurate if every node on disk was written with
+// reference counting enabled, Prune rebuilds them for an existing database.
+func (db *Database) EnableRefcount(resolver LeafResolver) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.resolver = resolver
+}
+
+// Refcounting returns whether the database keeps persistent reference counts.
+func (db *Database) Refcounting() bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.resolver != nil
+}
+
+// countNode creates the reference count of a node written to disk for the
+// first time and adds a reference to each of its children.  The children of
+// a node which is already on disk are referenced already.
+func (db *Database) countNode(hash common.Hash, node *cachedNode, refs *refcounter) error {
+	count, err := refs.load(hash)
+	if err != nil || count >= 0 {
+		return err
+	}
+	refs.set(hash, 0)
+
+	// Raw blobs, i.e. contract code, do not reference anything.
+	if _, ok := node.node.(rawNode); ok {
+		return nil
+	}
+	var tries, blobs []common.Hash
+	nodeRefs(node.node, db.resolver, &tries, &blobs)
+	for _, child := range append(tries, blobs...) {
+		if err := refs.inc(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReferenceRoot adds a persistent reference to the committed trie with the
+// passed root, which keeps it on disk until it is dereferenced.
+func (db *Database) ReferenceRoot(root common.Hash) error {
+	if !db.Refcounting() {
+		return errors.New("trie reference counting is not enabled")
+	}
+	if root == emptyRoot {
+		return nil
+	}
+	db.reflock.Lock()
+	defer db.reflock.Unlock()
+
+	refs := newRefcounter(db.diskdb)
+	if err := refs.inc(root); err != nil {
+		return err
+	}
+	batch := db.diskdb.NewBatch()
+	if err := refs.write(batch); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// DereferenceRoot removes a persistent reference from the trie with the
+// passed root.  The nodes and blobs which are no longer referenced are
+// deleted from disk, cascading into their children.  The number of deleted
+// entries is returned.
+func (db *Database) DereferenceRoot(root common.Hash) (int, error) {
+	if !db.Refcounting() {
+		return 0, errors.New("trie reference counting is not enabled")
+	}
+	if root == emptyRoot {
+		return 0, nil
+	}
+	db.reflock.Lock()
+	defer db.reflock.Unlock()
+
+	type entry struct {
+		hash common.Hash
+		blob bool
+	}
+	start := time.Now()
+	refs := newRefcounter(db.diskdb)
+	batch := db.diskdb.NewBatch()
+	stack := []entry{{hash: root}}
+	deleted := 0
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		count, err := refs.load(e.hash)
+		if err != nil {
+			return deleted, err
+		}
+		// Entries which are not tracked are never deleted.
+		if count <= 0 {
+			continue
+		}
+		if count > 1 {
+			refs.set(e.hash, count-1)
+			continue
+		}
+
+		if !e.blob {
+			enc, err := db.diskdb.Get(e.hash[:])
+			if err != nil {
+				return deleted, &MissingNodeError{NodeHash: e.hash}
+			}
+			n, err := decodeNode(e.hash[:], enc, 0)
+			if err != nil {
+				return deleted, err
+			}
+			var tries, blobs []common.Hash
+			nodeRefs(n, db.resolver, &tries, &blobs)
+			for _, hash := range tries {
+				stack = append(stack, entry{hash: hash})
+			}
+			for _, hash := range blobs {
+				stack = append(stack, entry{hash: hash, blob: true})
+			}
+		}
+		refs.set(e.hash, -1)
+		if err := batch.Delete(e.hash[:]); err != nil {
+			return deleted, err
+		}
+		deleted++
+
+		if batch.ValueSize() >= database.IdealBatchSize {
+			if err := refs.write(batch); err != nil {
+				return deleted, err
+			}
+			if err := batch.Write(); err != nil {
+				return deleted, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := refs.write(batch); err != nil {
+		return deleted, err
+	}
+	if err := batch.Write(); err != nil {
+		return deleted, err
+	}
+
+	log.Debug("Dereferenced trie from disk", "root", root, "deleted", deleted,
+		"time", time.Since(start))
+	return deleted, nil
+}
+
+// Prune deletes from diskdb the trie nodes and blobs which are not reachable
+// from the passed roots and rebuilds the reference counts of the reachable
+// ones, each occurrence of a root in roots holding one reference to it.  The
+// resolver returns the external references of the trie leaves.
+//
+// Prune must not run while the database is in use.  It returns the number of
+// entries kept and deleted.
+func Prune(diskdb IterableDatabase, roots []common.Hash, resolver LeafResolver,
+	interrupt <-chan struct{}) (int, int, error) {
+
+	interrupted := func() bool {
+		select {
+		case <-interrupt:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Mark all the entries reachable from the roots, counting the
+	// references to them on the way.  Each entry is visited once.
+	type entry struct {
+		hash common.Hash
+		blob bool
+	}
+	counts := make(map[common.Hash]uint32)
+	var stack []entry
+	reference := func(hash common.Hash, blob bool) {
+		if _, ok := counts[hash]; !ok {
+			stack = append(stack, entry{hash: hash, blob: blob})
+		}
+		counts[hash]++
+	}
+	for _, root := range roots {
+		if root != emptyRoot {
+			reference(root, false)
+		}
+	}
+	for visited := 1; len(stack) > 0; visited++ {
+		if visited%100000 == 0 && interrupted() {
+			return 0, 0, ErrPruneInterrupted
+		}
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		enc, err := diskdb.Get(e.hash[:])
+		if err != nil {
+			return 0, 0, &MissingNodeError{NodeHash: e.hash}
+		}
+		if e.blob {
+			continue
+		}
+		n, err := decodeNode(e.hash[:], enc, 0)
+		if err != nil {
+			return 0, 0, err
+		}
+		var tries, blobs []common.Hash
+		nodeRefs(n, resolver, &tries, &blobs)
+		for _, hash := range tries {
+			reference(hash, false)
+		}
+		for _, hash := range blobs {
+			reference(hash, true)
+		}
+	}
+	log.Info("Marked reachable trie nodes", "roots", len(roots), "nodes", len(counts))
+
+	// Sweep the entries which are not reachable.  Trie nodes and blobs are
+	// keyed by the hash of their content, the other entries which happen
+	// to have a key of the same length are left alone.  The old reference
+	// counts are dropped as well.
+	deleted := 0
+	batch := diskdb.NewBatch()
+	flush := func() error {
+		if batch.ValueSize() < database.IdealBatchSize {
+			return nil
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+		return nil
+	}
+	it := diskdb.NewIterator()
+	for it.Next() {
+		key := it.Key()
+		if len(key) != common.HashLength {
+			continue
+		}
+		if _, ok := counts[common.BytesToHash(key)]; ok {
+			continue
+		}
+		if !bytes.Equal(crypto.Keccak256(it.Value()), key) {
+			continue
+		}
+		if err := batch.Delete(common.CopyBytes(key)); err != nil {
+			it.Release()
+			return 0, deleted, err
+		}
+		deleted++
+		if err := flush(); err != nil {
+			it.Release()
+			return 0, deleted, err
+		}
+		if deleted%100000 == 0 && interrupted() {
+			it.Release()
+			return 0, deleted, ErrPruneInterrupted
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return 0, deleted, err
+	}
+
+	it = diskdb.NewIteratorWithPrefix(refcountPrefix)
+	for it.Next() {
+		if err := batch.Delete(common.CopyBytes(it.Key())); err != nil {
+			it.Release()
+			return 0, deleted, err
+		}
+		if err := flush(); err != nil {
+			it.Release()
+			return 0, deleted, err
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return 0, deleted, err
+	}
+	if err := batch.Write(); err != nil {
+		return 0, deleted, err
+	}
+	batch.Reset()
+
+	var buf [4]byte
+	for hash, count := range counts {
+		binary.BigEndian.PutUint32(buf[:], count)
+		if err := batch.Put(refcountKey(hash), buf[:]); err != nil {
+			return 0, deleted, err
+		}
+		if err := flush(); err != nil {
+			return 0, deleted, err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return 0, deleted, err
+	}
+	return len(counts), deleted, nil
+}
+
+// Walk invokes fn once with the hash and the encoding of every trie node and
+// blob reachable from the passed roots, reading them from diskdb.  The
+// resolver returns the external references of the trie leaves.  A
+// MissingNodeError is returned when a reachable entry is not on disk.
+func Walk(diskdb database.Database, roots []common.Hash, resolver LeafResolver,
+	fn func(hash common.Hash, enc []byte) error) error {
+
+	type entry struct {
+		hash common.Hash
+		blob bool
+	}
+	seen := make(map[common.Hash]struct{})
+	var stack []entry
+	visit := func(hash common.Hash, blob bool) {
+		if _, ok := seen[hash]; !ok {
+			seen[hash] = struct{}{}
+			stack = append(stack, entry{hash: hash, blob: blob})
+		}
+	}
+	for _, root := range roots {
+		if root != emptyRoot {
+			visit(root, false)
+		}
+	}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		enc, err := diskdb.Get(e.hash[:])
+		if err != nil {
+			return &MissingNodeError{NodeHash: e.hash}
+		}
+		if err := fn(e.hash, enc); err != nil {
+			return err
+		}
+		if e.blob {
+			continue
+		}
+		n, err := decodeNode(e.hash[:], enc, 0)
+		if err != nil {
+			return err
+		}
+		var tries, blobs []common.Hash
+		nodeRefs(n, resolver, &tries, &blobs)
+		for _, hash := range tries {
+			visit(hash, false)
+		}
+		for _, hash := range blobs {
+			visit(hash, true)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package trie
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+)
+
+// noRefs is a leaf resolver for tries whose values reference nothing.
+func noRefs([]byte) ([]common.Hash, []common.Hash) { return nil, nil }
+
+// newRefcountDisk returns an empty on disk database, removed at the end of
+// the test.
+func newRefcountDisk(t *testing.T) *ethdb.LDBDatabase {
+	dir, err := ioutil.TempDir("", "trie-refcount")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	diskdb, err := ethdb.NewLDBDatabase(dir, 16, 16)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewLDBDatabase error %v", err)
+	}
+	t.Cleanup(func() {
+		diskdb.Close()
+		os.RemoveAll(dir)
+	})
+	return diskdb
+}
+
+// refcountValue returns a value large enough for its leaf not to be
+// embedded in its parent.
+func refcountValue(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 40)
+}
+
+// commitTrie updates the trie with the passed root and writes it to disk.
+func commitTrie(t *testing.T, db *Database, root common.Hash, keys []string, value int) common.Hash {
+	tr, err := New(root, db)
+	if err != nil {
+		t.Fatalf("New error %v", err)
+	}
+	for _, key := range keys {
+		tr.Update([]byte(key), refcountValue(value))
+	}
+	root, err = tr.Commit(nil)
+	if err != nil {
+		t.Fatalf("Commit error %v", err)
+	}
+	if err := db.Commit(root, false); err != nil {
+		t.Fatalf("Database.Commit error %v", err)
+	}
+	return root
+}
+
+// checkTrie ensures the trie with the passed root is complete on disk and
+// maps the keys to the value.
+func checkTrie(t *testing.T, name string, diskdb *ethdb.LDBDatabase, root common.Hash,
+	keys []string, value int) {
+
+	tr, err := New(root, NewDatabase(diskdb))
+	if err != nil {
+		t.Errorf("%s: New error %v", name, err)
+		return
+	}
+	for _, key := range keys {
+		got, err := tr.TryGet([]byte(key))
+		if err != nil {
+			t.Errorf("%s: TryGet(%s) error %v", name, key, err)
+			continue
+		}
+		if !bytes.Equal(got, refcountValue(value)) {
+			t.Errorf("%s: got value %x for %s", name, got, key)
+		}
+	}
+}
+
+// refcountKeys are the keys of the tries of the tests.
+var refcountKeys = []string{"alpha", "beta", "gamma", "delta", "epsilon", "zeta"}
+
+// TestRefcountReorg ensures the nodes of a trie are deleted once it is no
+// longer referenced while the nodes shared with the tries still referenced
+// stay on disk, as when the states of a side chain are dropped.
+func TestRefcountReorg(t *testing.T) {
+	diskdb := newRefcountDisk(t)
+	db := NewDatabase(diskdb)
+	db.EnableRefcount(noRefs)
+
+	// The parent state and two children forking from it, each child
+	// changing one key only.
+	parent := commitTrie(t, db, common.Hash{}, refcountKeys, 1)
+	if err := db.ReferenceRoot(parent); err != nil {
+		t.Fatalf("ReferenceRoot error %v", err)
+	}
+	side := commitTrie(t, db, parent, refcountKeys[:1], 2)
+	if err := db.ReferenceRoot(side); err != nil {
+		t.Fatalf("ReferenceRoot error %v", err)
+	}
+	main := commitTrie(t, db, parent, refcountKeys[1:2], 3)
+	if err := db.ReferenceRoot(main); err != nil {
+		t.Fatalf("ReferenceRoot error %v", err)
+	}
+
+	// Referencing a root twice needs two dereferences to delete it.
+	if err := db.ReferenceRoot(side); err != nil {
+		t.Fatalf("ReferenceRoot error %v", err)
+	}
+	deleted, err := db.DereferenceRoot(side)
+	if err != nil || deleted != 0 {
+		t.Fatalf("DereferenceRoot: got %d deleted, error %v, want none",
+			deleted, err)
+	}
+
+	// Dropping the side chain deletes the nodes on the path to the key it
+	// changed only.
+	deleted, err = db.DereferenceRoot(side)
+	if err != nil {
+		t.Fatalf("DereferenceRoot error %v", err)
+	}
+	if deleted == 0 {
+		t.Errorf("DereferenceRoot: no node of the side trie was deleted")
+	}
+	if has, _ := diskdb.Has(side[:]); has {
+		t.Errorf("DereferenceRoot: the side root is still on disk")
+	}
+	checkTrie(t, "parent", diskdb, parent, refcountKeys[2:], 1)
+	checkTrie(t, "main", diskdb, main, refcountKeys[1:2], 3)
+	checkTrie(t, "main", diskdb, main, refcountKeys[2:], 1)
+
+	// Dropping the parent once it falls out of the retained states keeps
+	// the main child complete.
+	if _, err := db.DereferenceRoot(parent); err != nil {
+		t.Fatalf("DereferenceRoot error %v", err)
+	}
+	if has, _ := diskdb.Has(parent[:]); has {
+		t.Errorf("DereferenceRoot: the parent root is still on disk")
+	}
+	checkTrie(t, "main", diskdb, main, refcountKeys[:1], 1)
+	checkTrie(t, "main", diskdb, main, refcountKeys[2:], 1)
+
+	// Dereferencing an untracked root deletes nothing.
+	deleted, err = db.DereferenceRoot(common.Hash{1})
+	if err != nil || deleted != 0 {
+		t.Errorf("DereferenceRoot of an unknown root: got %d deleted, "+
+			"error %v", deleted, err)
+	}
+}
+
+// TestPrune ensures Prune deletes the trie nodes which are not reachable from
+// the passed roots, leaves the other entries alone and rebuilds the reference
+// counts.
+func TestPrune(t *testing.T) {
+	diskdb := newRefcountDisk(t)
+	db := NewDatabase(diskdb)
+
+	kept := commitTrie(t, db, common.Hash{}, refcountKeys, 1)
+	dropped := commitTrie(t, db, common.Hash{}, refcountKeys, 2)
+
+	// Entries keyed by 32 bytes which are not trie nodes.
+	foreign := common.BytesToHash(crypto.Keccak256([]byte("foreign")))
+	if err := diskdb.Put(foreign[:], []byte("not a trie node")); err != nil {
+		t.Fatalf("Put error %v", err)
+	}
+	other := []byte("0123456789abcdef0123456789abcdef")
+	if err := diskdb.Put(other, []byte("other")); err != nil {
+		t.Fatalf("Put error %v", err)
+	}
+
+	reachable, deleted, err := Prune(diskdb, []common.Hash{kept, kept}, noRefs, nil)
+	if err != nil {
+		t.Fatalf("Prune error %v", err)
+	}
+	if reachable == 0 || deleted == 0 {
+		t.Errorf("Prune: got %d reachable and %d deleted entries",
+			reachable, deleted)
+	}
+	checkTrie(t, "kept", diskdb, kept, refcountKeys, 1)
+	if has, _ := diskdb.Has(dropped[:]); has {
+		t.Errorf("Prune: the unreachable root is still on disk")
+	}
+	for _, key := range [][]byte{foreign[:], other} {
+		if has, _ := diskdb.Has(key); !has {
+			t.Errorf("Prune: deleted entry %x which is not a trie node", key)
+		}
+	}
+
+	// Each occurrence of a root holds one reference to it.
+	refs := newRefcounter(diskdb)
+	if count, err := refs.load(kept); err != nil || count != 2 {
+		t.Errorf("Prune: got %d references to the root, error %v, want 2",
+			count, err)
+	}
+
+	// Counting resumes from the rebuilt counts.
+	db = NewDatabase(diskdb)
+	db.EnableRefcount(noRefs)
+	for i := 0; i < 2; i++ {
+		if _, err := db.DereferenceRoot(kept); err != nil {
+			t.Fatalf("DereferenceRoot error %v", err)
+		}
+	}
+	if has, _ := diskdb.Has(kept[:]); has {
+		t.Errorf("DereferenceRoot: the pruned root is still on disk")
+	}
+	for i, key := range [][]byte{foreign[:], other} {
+		if has, _ := diskdb.Has(key); !has {
+			t.Errorf("DereferenceRoot: deleted foreign entry %d", i)
+		}
+	}
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
 	"github.com/AsimovNetwork/asimov/vm/fvm/rlp"
 )
@@ -317,7 +318,7 @@ func TestLargeValue(t *testing.T) {
 }
 
 type countingDB struct {
-	ethdb.Database
+	database.Database
 	gets map[string]int
 }
 